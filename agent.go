@@ -20,6 +20,7 @@ import (
 	"github.com/valyala/fasthttp"
 	"github.com/weibocom/motan-go/cluster"
 	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/filter"
 	mhttp "github.com/weibocom/motan-go/http"
 	"github.com/weibocom/motan-go/log"
 	"github.com/weibocom/motan-go/metrics"
@@ -61,6 +62,7 @@ type Agent struct {
 	runtimedir     string
 
 	serviceExporters  *motan.CopyOnWriteMap
+	configServices    map[string]string // the identities of the services exported by the config, keyed by the urls in the config
 	serviceMap        *motan.CopyOnWriteMap
 	agentPortServer   map[int]motan.Server
 	serviceRegistries *motan.CopyOnWriteMap
@@ -182,6 +184,7 @@ func (a *Agent) StartMotanAgentFromConfig(config *cfg.Config) {
 	a.initAgentURL()
 	// start metrics reporter early, here agent context has already initialized
 	metrics.StartReporter(a.Context)
+	filter.StartOTelTracing(a.Context)
 	a.registerStatusSampler()
 	a.initStatus()
 	a.initClusters()
@@ -189,7 +192,8 @@ func (a *Agent) StartMotanAgentFromConfig(config *cfg.Config) {
 	a.initHTTPClusters()
 	a.startHTTPAgent()
 	a.configurer = NewDynamicConfigurer(a)
-	go a.startMServer()
+	a.startConfigWatcher()
+	a.startMServer()
 	go a.registerAgent()
 	f, err := os.Create(a.pidfile)
 	if err != nil {
@@ -202,6 +206,7 @@ func (a *Agent) StartMotanAgentFromConfig(config *cfg.Config) {
 		// recover form a unexpected case
 		a.availableAllServices()
 	}
+	a.watchUpgradeSignal()
 	vlog.Infoln("Motan agent is starting...")
 	a.startAgent()
 }
@@ -295,7 +300,11 @@ func (a *Agent) initParam() {
 	if section != nil && section["log_level"] != nil {
 		logLevel = section["log_level"].(string)
 	}
-	initLog(logDir, logAsync, logStructured, rotatePerHour, logLevel)
+	logFormat := ""
+	if section != nil && section["log_format"] != nil {
+		logFormat = section["log_format"].(string)
+	}
+	initLog(logDir, logAsync, logStructured, rotatePerHour, logLevel, logFormat)
 	registerSwitchers(a.Context)
 
 	port := *motan.Port
@@ -547,11 +556,15 @@ func (a *Agent) startAgent() {
 	fmt.Println("Motan agent start.")
 	a.agentServer = server
 	a.callAfterStart()
-	err := server.Open(true, true, handler, a.extFactory)
+	err := server.Open(false, true, handler, a.extFactory)
 	if err != nil {
 		vlog.Fatalf("start agent fail. port :%d, err: %v", a.port, err)
+		fmt.Println("Motan agent start fail!")
+		return
 	}
-	fmt.Println("Motan agent start fail!")
+	// all listeners of the agent are listened, the parent process drains after that if the agent is started by the upgrade
+	motan.UpgradeReady()
+	select {}
 }
 
 func (a *Agent) registerAgent() {
@@ -747,9 +760,12 @@ func (a *agentMessageHandler) GetProvider(serviceName string) motan.Provider {
 
 func (a *Agent) startServerAgent() {
 	globalContext := a.Context
+	a.configServices = make(map[string]string, len(globalContext.ServiceURLs))
 	for _, url := range globalContext.ServiceURLs {
+		key := url.ToExtInfo()
 		a.initProxyServiceURL(url)
 		a.doExportService(url)
+		a.configServices[key] = url.GetIdentity()
 	}
 }
 
@@ -797,6 +813,10 @@ func (a *Agent) doExportService(url *motan.URL) {
 		server.GetMessageHandler().AddProvider(provider)
 	}
 	err := exporter.Export(server, a.extFactory, globalContext)
+	if _, partial := err.(*mserver.InvalidRegistryError); partial {
+		vlog.Warningf("service exported to part of the registries. url:%v, err:%v", url, err)
+		err = nil
+	}
 	if err != nil {
 		vlog.Errorf("service export fail! url:%v, err:%v", url, err)
 		return
@@ -825,7 +845,7 @@ func getServiceKey(group, path string) string {
 }
 
 func (sa *serverAgentMessageHandler) Call(request motan.Request) (res motan.Response) {
-	defer motan.HandlePanic(func() {
+	defer motan.HandleRequestPanic(request, func() {
 		res = motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 500, ErrMsg: "provider call panic", ErrType: motan.ServiceException})
 		vlog.Errorf("provider call panic. req:%s", motan.GetReqInfo(request))
 	})
@@ -861,7 +881,7 @@ func getClusterKey(group, version, protocol, path string) string {
 	return group + "_" + version + "_" + protocol + "_" + path
 }
 
-func initLog(logDir, logAsync, logStructured, rotatePerHour string, logLevel string) {
+func initLog(logDir, logAsync, logStructured, rotatePerHour string, logLevel string, logFormat string) {
 	// TODO: remove after a better handle
 	if logDir == "stdout" {
 		return
@@ -880,6 +900,9 @@ func initLog(logDir, logAsync, logStructured, rotatePerHour string, logLevel str
 	if logLevel != "" {
 		_ = flag.Set("log_level", logLevel)
 	}
+	if logFormat != "" {
+		_ = flag.Set("log_format", logFormat)
+	}
 	vlog.LogInit(nil)
 }
 
@@ -986,7 +1009,7 @@ func (a *Agent) startMServer() {
 			return
 		}
 		for port := int(startPort); port <= int(endPort); port++ {
-			listener, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+			listener, err := motan.Listen("tcp", ":"+strconv.Itoa(port))
 			if err != nil {
 				continue
 			}
@@ -999,7 +1022,7 @@ func (a *Agent) startMServer() {
 			return
 		}
 	} else {
-		listener, err := net.Listen("tcp", ":"+strconv.Itoa(a.mport))
+		listener, err := motan.Listen("tcp", ":"+strconv.Itoa(a.mport))
 		if err != nil {
 			vlog.Infof("listen manage port %d failed:%s", a.mport, err.Error())
 			return
@@ -1008,10 +1031,12 @@ func (a *Agent) startMServer() {
 	}
 
 	vlog.Infof("start listen manage for address: %s", managementListener.Addr().String())
-	err := http.Serve(managementListener, nil)
-	if err != nil {
-		vlog.Warningf("start listen manage port fail! port:%d, err:%s", a.mport, err.Error())
-	}
+	go func() {
+		err := http.Serve(managementListener, nil)
+		if err != nil {
+			vlog.Warningf("start listen manage port fail! port:%d, err:%s", a.mport, err.Error())
+		}
+	}()
 }
 
 func (a *Agent) mhandle(k string, h http.Handler) {
@@ -11,6 +11,7 @@ import (
 
 	"github.com/weibocom/motan-go/cluster"
 	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/filter"
 	mpro "github.com/weibocom/motan-go/protocol"
 )
 
@@ -59,6 +60,39 @@ func (c *Client) BaseCall(req motan.Request, reply interface{}) error {
 	return nil
 }
 
+// CallOneway sends the request without waiting for the response, it returns after the request is written to the
+// connection. the server does not send the response of a oneway request
+func (c *Client) CallOneway(method string, args []interface{}) error {
+	req := c.BuildRequest(method, args)
+	return c.BaseCallOneway(req)
+}
+
+func (c *Client) BaseCallOneway(req motan.Request) error {
+	rc := req.GetRPCContext(true)
+	rc.ExtFactory = c.extFactory
+	rc.Oneway = true
+	res := c.cluster.Call(req)
+	if res.GetException() != nil {
+		return errors.New(res.GetException().ErrMsg)
+	}
+	return nil
+}
+
+// CallWithPreloadHints calls the method and asks the server for preload hints, the client can warm its caches with the hints
+// after connecting. the hints are nil if the server returns no hints
+func (c *Client) CallWithPreloadHints(method string, args []interface{}, reply interface{}) ([]string, error) {
+	req := c.BuildRequest(method, args)
+	req.SetAttachment(motan.PreloadHintsRequestAttachment, "true")
+	rc := req.GetRPCContext(true)
+	rc.ExtFactory = c.extFactory
+	rc.Reply = reply
+	res := c.cluster.Call(req)
+	if res.GetException() != nil {
+		return nil, errors.New(res.GetException().ErrMsg)
+	}
+	return motan.GetPreloadHints(res), nil
+}
+
 func (c *Client) Go(method string, args []interface{}, reply interface{}, done chan *motan.AsyncResult) *motan.AsyncResult {
 	req := c.BuildRequest(method, args)
 	return c.BaseGo(req, reply, done)
@@ -94,11 +128,23 @@ func (c *Client) BuildRequest(method string, args []interface{}) motan.Request {
 		application = c.cluster.Context.ClientURL.GetParam(motan.ApplicationKey, "")
 	}
 	req.SetAttachment(mpro.MSource, application)
+	if callerVersion := c.url.GetParam(motan.CallerVersionKey, ""); callerVersion != "" {
+		req.SetAttachment(motan.CallerVersionAttachment, callerVersion)
+	}
 	req.SetAttachment(mpro.MGroup, c.url.Group)
 	req.SetAttachment(mpro.MPath, req.GetServiceName())
 	return req
 }
 
+// BuildChildRequest build a request made within the handling of parent request, the trace baggage of parent is propagated
+func (c *Client) BuildChildRequest(parent motan.Request, method string, args []interface{}) motan.Request {
+	req := c.BuildRequest(method, args)
+	if parent != nil {
+		motan.PropagateBaggage(parent, req)
+	}
+	return req
+}
+
 func NewClientContextFromConfig(conf *config.Config) (mc *MCContext) {
 	mc = &MCContext{config: conf}
 	motan.Initialize(mc)
@@ -130,8 +176,13 @@ func NewClientContextFromConfig(conf *config.Config) (mc *MCContext) {
 	if section != nil && section["log_level"] != nil {
 		logLevel = section["log_level"].(string)
 	}
-	initLog(logDir, logAsync, logStructured, rotatePerHour, logLevel)
+	logFormat := ""
+	if section != nil && section["log_format"] != nil {
+		logFormat = section["log_format"].(string)
+	}
+	initLog(logDir, logAsync, logStructured, rotatePerHour, logLevel, logFormat)
 	registerSwitchers(mc.context)
+	filter.StartOTelTracing(mc.context)
 	return mc
 }
 
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	assert2 "github.com/stretchr/testify/assert"
 	"github.com/weibocom/motan-go/config"
+	"sync"
 	"testing"
 	"time"
 )
@@ -43,7 +44,69 @@ motan-refer:
 	assert.Equal("Hello Ray", reply)
 }
 
+func TestGenericService(t *testing.T) {
+	assert := assert2.New(t)
+	StartServer()
+	cfgText := `
+motan-client:
+  log_dir: "stdout"
+  application: "app-golang"
+motan-registry:
+  direct:
+    protocol: direct
+    host: 127.0.0.1
+    port: 64531
+motan-refer:
+  generic:
+    path: genericTemplate
+    group: bj
+    protocol: motan2
+    registry: direct
+`
+	conf, err := config.NewConfigFromReader(bytes.NewReader([]byte(cfgText)))
+	assert.Nil(err)
+	mccontext := NewClientContextFromConfig(conf)
+	assert.Nil(mccontext.GetGenericService("generic"), "context is not started")
+	mccontext.Start(GetDefaultExtFactory())
+	assert.Nil(mccontext.GetGenericService("unknown"))
+	generic := mccontext.GetGenericService("generic")
+	assert.NotNil(generic)
+
+	var reply string
+	err = generic.Call("helloService", "hello", "simple", []interface{}{"Ray"}, &reply)
+	assert.Nil(err)
+	assert.Equal("Hello Ray", reply)
+	client, err := generic.GetClient("helloService", "simple")
+	assert.Nil(err)
+	assert.Equal("helloService", client.url.Path)
+	assert.Equal("simple", client.url.GetParam("serialization", ""))
+
+	result := generic.Go("helloService", "hello", "simple", []interface{}{"Ray"}, new(string), nil)
+	select {
+	case <-result.Done:
+		assert.Nil(result.Error)
+		assert.Equal("Hello Ray", *result.Reply.(*string))
+	case <-time.After(3 * time.Second):
+		t.Fatal("async call is not finished")
+	}
+
+	err = generic.Call("helloService", "hello", "unknown", []interface{}{"Ray"}, &reply)
+	assert.NotNil(err)
+	result = generic.Go("", "hello", "simple", nil, nil, nil)
+	assert.NotNil((<-result.Done).Error)
+
+	generic.Destroy()
+	err = generic.Call("helloService", "hello", "simple", []interface{}{"Ray"}, &reply)
+	assert.Equal(errGenericServiceDestroyed, err)
+}
+
+var startServerOnce sync.Once
+
 func StartServer() {
+	startServerOnce.Do(startHelloServer)
+}
+
+func startHelloServer() {
 	cfgText := `
 motan-server:
   log_dir: "stdout"
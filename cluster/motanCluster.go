@@ -31,6 +31,7 @@ type MotanCluster struct {
 	available      bool
 	closed         bool
 	proxy          bool
+	outlier        *outlierDetector
 
 	// cached identity
 	identity motan.AtomicString
@@ -63,7 +64,7 @@ func (m *MotanCluster) SetURL(url *motan.URL) {
 	m.url = url
 }
 func (m *MotanCluster) Call(request motan.Request) (res motan.Response) {
-	defer motan.HandlePanic(func() {
+	defer motan.HandleRequestPanic(request, func() {
 		res = motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 500, ErrMsg: "cluster call panic", ErrType: motan.ServiceException})
 		vlog.Errorf("cluster call panic. req:%s", motan.GetReqInfo(request))
 	})
@@ -86,6 +87,12 @@ func (m *MotanCluster) initCluster() bool {
 	m.HaStrategy = m.extFactory.GetHa(m.url)
 	//lb
 	m.LoadBalance = m.extFactory.GetLB(m.url)
+	if isZoneAware(m.url) {
+		m.LoadBalance = newZoneLoadBalance(m.url, func() motan.LoadBalance {
+			return m.extFactory.GetLB(m.url)
+		})
+	}
+	m.outlier = newOutlierDetector(m.url)
 	//filter should initialize after HaStrategy
 	m.initFilters()
 
@@ -125,6 +132,9 @@ func (m *MotanCluster) refresh() {
 	// shuffle endpoints list avoid to call to determine server nodes when the list is not change.
 	newRefers = m.ShuffleEndpoints(newRefers)
 	m.Refers = newRefers
+	if m.outlier != nil {
+		m.outlier.setEndpointCount(len(newRefers))
+	}
 	m.LoadBalance.OnRefresh(newRefers)
 }
 func (m *MotanCluster) ShuffleEndpoints(endpoints []motan.EndPoint) []motan.EndPoint {
@@ -167,6 +177,9 @@ func (m *MotanCluster) Notify(registryURL *motan.URL, urls []*motan.URL) {
 		if ep == nil {
 			newURL := u.Copy()
 			newURL.MergeParams(m.url.Parameters)
+			if isZoneAware(m.url) {
+				restoreLocality(newURL, u)
+			}
 			ep = m.extFactory.GetEndPoint(newURL)
 
 			if ep != nil {
@@ -179,6 +192,9 @@ func (m *MotanCluster) Notify(registryURL *motan.URL, urls []*motan.URL) {
 				}
 				motan.Initialize(ep)
 				ep = m.addFilter(ep, m.Filters)
+				if m.outlier != nil {
+					ep = m.outlier.wrap(ep)
+				}
 			}
 		}
 
@@ -261,6 +277,11 @@ func (m *MotanCluster) Destroy() {
 			vlog.Infof("destroy endpoint %s .", e.GetURL().GetIdentity())
 			e.Destroy()
 		}
+		for f := m.clusterFilter; f != nil; f = f.GetNext() {
+			if d, ok := f.(motan.Destroyable); ok {
+				d.Destroy()
+			}
+		}
 		m.closed = true
 	}
 }
@@ -308,6 +329,14 @@ func (m *MotanCluster) initFilters() {
 	clusterFilter, endpointFilters := motan.GetURLFilters(m.url, m.extFactory)
 	if clusterFilter != nil {
 		m.clusterFilter = clusterFilter
+		for f := clusterFilter; f != nil; f = f.GetNext() {
+			motan.CanSetContext(f, m.Context)
+			if gf, ok := f.(motan.SetGroupCallerFactory); ok {
+				gf.SetGroupCallerFactory(func(url *motan.URL) motan.Caller {
+					return NewCluster(m.Context, m.extFactory, url, m.proxy)
+				})
+			}
+		}
 	}
 	if len(endpointFilters) > 0 {
 		m.Filters = endpointFilters
@@ -0,0 +1,185 @@
+package cluster
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// outlier detection options of the referer url parameters
+const (
+	OutlierDetectionKey          = "outlierDetection"          // eject the endpoints with too many errors from the load balance if it is true
+	OutlierConsecutiveErrorsKey  = "outlierConsecutiveErrors"  // eject the endpoint after the consecutive errors, default 5, 0 disables it
+	OutlierErrorPercentKey       = "outlierErrorPercent"       // eject the endpoint if the error percent of an interval reaches it, default 50, 0 disables it
+	OutlierMinRequestsKey        = "outlierMinRequests"        // the error percent is checked only if the requests of the interval reach it, default 20
+	OutlierIntervalKey           = "outlierInterval"           // the interval(ms) of the error percent, default 10000
+	OutlierEjectionTimeKey       = "outlierEjectionTime"       // the base time(ms) of the ejection, multiplied by the times ejected in a row, default 30000
+	OutlierMaxEjectionPercentKey = "outlierMaxEjectionPercent" // the max percent of the endpoints ejected at the same time, default 50
+)
+
+const (
+	defaultOutlierConsecutiveErrors  = 5
+	defaultOutlierErrorPercent       = 50
+	defaultOutlierMinRequests        = 20
+	defaultOutlierInterval           = 10 * time.Second
+	defaultOutlierEjectionTime       = 30 * time.Second
+	defaultOutlierMaxEjectionPercent = 50
+	maxOutlierEjectionMultiplier     = 10
+)
+
+// outlierNow is replaced by the tests
+var outlierNow = time.Now
+
+// outlierDetector passively checks the health of the endpoints of a cluster by the results of the calls. the endpoint
+// with consecutive errors or a high error percent is ejected(unavailable to the load balance) for a cooldown period,
+// after the cooldown the next call to the endpoint is a probe, the endpoint is readmitted if the probe succeeds or
+// ejected again for a longer period. the biz exceptions are not errors of the endpoint
+type outlierDetector struct {
+	consecutiveErrors  int64
+	errorPercent       int64
+	minRequests        int64
+	interval           time.Duration
+	ejectionTime       time.Duration
+	maxEjectionPercent int64
+	endpointCount      int64
+	ejectedCount       int64
+}
+
+func newOutlierDetector(url *motan.URL) *outlierDetector {
+	if enable, _ := strconv.ParseBool(url.GetParam(OutlierDetectionKey, "false")); !enable {
+		return nil
+	}
+	return &outlierDetector{
+		consecutiveErrors:  url.GetIntValue(OutlierConsecutiveErrorsKey, defaultOutlierConsecutiveErrors),
+		errorPercent:       url.GetIntValue(OutlierErrorPercentKey, defaultOutlierErrorPercent),
+		minRequests:        url.GetPositiveIntValue(OutlierMinRequestsKey, defaultOutlierMinRequests),
+		interval:           url.GetTimeDuration(OutlierIntervalKey, time.Millisecond, defaultOutlierInterval),
+		ejectionTime:       url.GetTimeDuration(OutlierEjectionTimeKey, time.Millisecond, defaultOutlierEjectionTime),
+		maxEjectionPercent: url.GetIntValue(OutlierMaxEjectionPercentKey, defaultOutlierMaxEjectionPercent),
+	}
+}
+
+func (o *outlierDetector) wrap(ep motan.EndPoint) motan.EndPoint {
+	return &outlierEndpoint{EndPoint: ep, detector: o, windowStart: outlierNow()}
+}
+
+// setEndpointCount is called when the endpoints of the cluster are refreshed
+func (o *outlierDetector) setEndpointCount(count int) {
+	atomic.StoreInt64(&o.endpointCount, int64(count))
+}
+
+// tryEject reserves an ejection if the ejected endpoints do not exceed the max ejection percent
+func (o *outlierDetector) tryEject() bool {
+	for {
+		ejected := atomic.LoadInt64(&o.ejectedCount)
+		if (ejected+1)*100 > o.maxEjectionPercent*atomic.LoadInt64(&o.endpointCount) {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&o.ejectedCount, ejected, ejected+1) {
+			return true
+		}
+	}
+}
+
+func (o *outlierDetector) readmit() {
+	atomic.AddInt64(&o.ejectedCount, -1)
+}
+
+// outlierEndpoint records the results of the calls to the endpoint and is unavailable when ejected
+type outlierEndpoint struct {
+	motan.EndPoint
+	detector    *outlierDetector
+	lock        sync.Mutex
+	consecutive int64
+	requests    int64
+	errors      int64
+	windowStart time.Time
+	ejected     bool
+	ejectedTime time.Time
+	multiplier  int64
+	probing     bool
+}
+
+func (e *outlierEndpoint) IsAvailable() bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.ejected && !e.probing {
+		// the cooldown is over, let a call probe the endpoint
+		if outlierNow().Sub(e.ejectedTime) < time.Duration(e.multiplier)*e.detector.ejectionTime {
+			return false
+		}
+		e.probing = true
+	}
+	return e.EndPoint.IsAvailable()
+}
+
+func (e *outlierEndpoint) Call(request motan.Request) motan.Response {
+	response := e.EndPoint.Call(request)
+	failed := response == nil || (response.GetException() != nil && response.GetException().ErrType != motan.BizException)
+	e.record(failed)
+	return response
+}
+
+func (e *outlierEndpoint) record(failed bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.ejected {
+		if !e.probing {
+			return
+		}
+		e.probing = false
+		if failed {
+			e.ejectedTime = outlierNow()
+			if e.multiplier < maxOutlierEjectionMultiplier {
+				e.multiplier++
+			}
+			vlog.Warningf("[outlier] probe endpoint fail, eject again. url:%s, ejection:%v", e.GetURL().GetIdentity(), time.Duration(e.multiplier)*e.detector.ejectionTime)
+			return
+		}
+		vlog.Infof("[outlier] probe endpoint success, readmit it. url:%s", e.GetURL().GetIdentity())
+		e.ejected = false
+		e.multiplier = 0
+		e.resetStats()
+		e.detector.readmit()
+		return
+	}
+	now := outlierNow()
+	if now.Sub(e.windowStart) >= e.detector.interval {
+		e.requests, e.errors, e.windowStart = 0, 0, now
+	}
+	e.requests++
+	if !failed {
+		e.consecutive = 0
+		return
+	}
+	e.errors++
+	e.consecutive++
+	d := e.detector
+	byConsecutive := d.consecutiveErrors > 0 && e.consecutive >= d.consecutiveErrors
+	byPercent := d.errorPercent > 0 && e.requests >= d.minRequests && e.errors*100 >= d.errorPercent*e.requests
+	if (byConsecutive || byPercent) && d.tryEject() {
+		e.ejected = true
+		e.ejectedTime = now
+		e.multiplier = 1
+		vlog.Warningf("[outlier] eject endpoint. url:%s, consecutive errors:%d, errors:%d of %d, ejection:%v", e.GetURL().GetIdentity(), e.consecutive, e.errors, e.requests, d.ejectionTime)
+		e.resetStats()
+	}
+}
+
+func (e *outlierEndpoint) resetStats() {
+	e.consecutive, e.requests, e.errors, e.windowStart = 0, 0, 0, outlierNow()
+}
+
+func (e *outlierEndpoint) Destroy() {
+	e.lock.Lock()
+	if e.ejected {
+		e.ejected = false
+		e.detector.readmit()
+	}
+	e.lock.Unlock()
+	e.EndPoint.Destroy()
+}
@@ -0,0 +1,104 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+)
+
+type outlierTestEndpoint struct {
+	motan.TestEndPoint
+	errType int
+	fail    bool
+}
+
+func (o *outlierTestEndpoint) Call(request motan.Request) motan.Response {
+	if o.fail {
+		return motan.BuildExceptionResponse(0, &motan.Exception{ErrCode: 503, ErrMsg: "fail", ErrType: o.errType})
+	}
+	return &motan.MotanResponse{Value: "ok"}
+}
+
+func TestOutlierDetector(t *testing.T) {
+	now := time.Now()
+	defer func() { outlierNow = time.Now }()
+	outlierNow = func() time.Time { return now }
+	url := &motan.URL{Parameters: map[string]string{OutlierDetectionKey: "true", OutlierConsecutiveErrorsKey: "3",
+		OutlierMinRequestsKey: "4", OutlierEjectionTimeKey: "1000", OutlierMaxEjectionPercentKey: "50"}}
+	detector := newOutlierDetector(url)
+	detector.setEndpointCount(3)
+	inners := make([]*outlierTestEndpoint, 3)
+	eps := make([]motan.EndPoint, 3)
+	for i := range eps {
+		inners[i] = &outlierTestEndpoint{TestEndPoint: motan.TestEndPoint{URL: &motan.URL{Port: 1000 + i}}, errType: motan.ServiceException}
+		eps[i] = detector.wrap(inners[i])
+	}
+
+	// the biz exceptions are not errors
+	inners[0].fail = true
+	inners[0].errType = motan.BizException
+	for i := 0; i < 5; i++ {
+		eps[0].Call(nil)
+	}
+	if !eps[0].IsAvailable() {
+		t.Fatal("endpoint ejected by biz exceptions")
+	}
+
+	// ejected by the consecutive errors
+	inners[0].errType = motan.ServiceException
+	for i := 0; i < 3; i++ {
+		eps[0].Call(nil)
+	}
+	if eps[0].IsAvailable() {
+		t.Fatal("endpoint not ejected by consecutive errors")
+	}
+
+	// not ejected over the max ejection percent
+	inners[1].fail = true
+	for i := 0; i < 3; i++ {
+		eps[1].Call(nil)
+	}
+	if !eps[1].IsAvailable() {
+		t.Fatal("endpoint ejected over the max ejection percent")
+	}
+
+	// probed after the cooldown, ejected again for a longer time if the probe fails
+	now = now.Add(time.Second)
+	if !eps[0].IsAvailable() {
+		t.Fatal("endpoint not probed after the cooldown")
+	}
+	eps[0].Call(nil)
+	now = now.Add(time.Second)
+	if eps[0].IsAvailable() {
+		t.Fatal("endpoint not ejected again after the probe fails")
+	}
+	now = now.Add(time.Second)
+	if !eps[0].IsAvailable() {
+		t.Fatal("endpoint not probed after the cooldown")
+	}
+	inners[0].fail = false
+	eps[0].Call(nil)
+	if !eps[0].IsAvailable() || detector.ejectedCount != 0 {
+		t.Fatalf("endpoint not readmitted after the probe succeeds, ejected: %d", detector.ejectedCount)
+	}
+
+	// ejected by the error percent
+	inners[1].fail = false
+	detector.consecutiveErrors = 0
+	for i := 0; i < 4; i++ {
+		inners[2].fail = i%2 == 1
+		eps[2].Call(nil)
+	}
+	if eps[2].IsAvailable() {
+		t.Fatal("endpoint not ejected by the error percent")
+	}
+	eps[2].Destroy()
+	if detector.ejectedCount != 0 {
+		t.Fatalf("ejected count not correct after destroy: %d", detector.ejectedCount)
+	}
+
+	if newOutlierDetector(&motan.URL{Parameters: map[string]string{}}) != nil {
+		t.Fatal("outlier detection enabled by default")
+	}
+}
@@ -0,0 +1,134 @@
+package cluster
+
+import (
+	"strconv"
+
+	motan "github.com/weibocom/motan-go/core"
+)
+
+// the locality of the urls, on the provider url it is where the node is deployed, on the referer url it is where the
+// local node is deployed
+const (
+	ZoneKey   = "zone"
+	RegionKey = "region"
+)
+
+// zone aware routing options of the referer url parameters
+const (
+	ZoneAwareKey        = "zoneAware"        // prefer the endpoints in the local zone, then the local region if it is true
+	ZoneSpilloverKey    = "zoneSpillover"    // spill over to the next locality if the available percent of the endpoints is less than it, default 50
+	ZoneMinAvailableKey = "zoneMinAvailable" // spill over to the next locality if the available endpoints are less than it, default 1
+)
+
+const defaultZoneSpillover = 50
+
+// zoneLoadBalance routes the requests to the endpoints of the same zone as the local node, then the same region, then
+// all the endpoints. the next locality is used when the available endpoints of a locality are not enough, each
+// locality selects the endpoints by its own load balance of the referer url
+type zoneLoadBalance struct {
+	zone         string
+	region       string
+	spillover    int64
+	minAvailable int64
+	newLb        func() motan.LoadBalance
+	weight       string
+	tiers        []*zoneTier
+}
+
+type zoneTier struct {
+	name      string
+	endpoints []motan.EndPoint
+	lb        motan.LoadBalance
+}
+
+func newZoneLoadBalance(url *motan.URL, newLb func() motan.LoadBalance) *zoneLoadBalance {
+	spillover := url.GetIntValue(ZoneSpilloverKey, defaultZoneSpillover)
+	if spillover < 0 || spillover > 100 {
+		spillover = defaultZoneSpillover
+	}
+	z := &zoneLoadBalance{
+		zone:         url.GetParam(ZoneKey, ""),
+		region:       url.GetParam(RegionKey, ""),
+		spillover:    spillover,
+		minAvailable: url.GetPositiveIntValue(ZoneMinAvailableKey, 1),
+		newLb:        newLb,
+	}
+	z.tiers = []*zoneTier{z.newTier("all", nil)}
+	return z
+}
+
+func isZoneAware(url *motan.URL) bool {
+	enable, _ := strconv.ParseBool(url.GetParam(ZoneAwareKey, "false"))
+	return enable
+}
+
+func (z *zoneLoadBalance) newTier(name string, endpoints []motan.EndPoint) *zoneTier {
+	lb := z.newLb()
+	lb.SetWeight(z.weight)
+	lb.OnRefresh(endpoints)
+	return &zoneTier{name: name, endpoints: endpoints, lb: lb}
+}
+
+func (z *zoneLoadBalance) OnRefresh(endpoints []motan.EndPoint) {
+	var zoneEps, regionEps []motan.EndPoint
+	for _, ep := range endpoints {
+		url := ep.GetURL()
+		if z.zone != "" && url.GetParam(ZoneKey, "") == z.zone {
+			zoneEps = append(zoneEps, ep)
+		}
+		if z.region != "" && url.GetParam(RegionKey, "") == z.region {
+			regionEps = append(regionEps, ep)
+		}
+	}
+	tiers := make([]*zoneTier, 0, 3)
+	if len(zoneEps) > 0 {
+		tiers = append(tiers, z.newTier(ZoneKey, zoneEps))
+	}
+	if len(regionEps) > 0 && len(regionEps) > len(zoneEps) {
+		tiers = append(tiers, z.newTier(RegionKey, regionEps))
+	}
+	z.tiers = append(tiers, z.newTier("all", endpoints))
+}
+
+// selectTier returns the first locality with enough available endpoints, the last one is returned if none is enough
+func (z *zoneLoadBalance) selectTier() *zoneTier {
+	tiers := z.tiers
+	for _, t := range tiers[:len(tiers)-1] {
+		var available int64
+		for _, ep := range t.endpoints {
+			if ep.IsAvailable() {
+				available++
+			}
+		}
+		if available >= z.minAvailable && available*100 >= z.spillover*int64(len(t.endpoints)) {
+			return t
+		}
+	}
+	return tiers[len(tiers)-1]
+}
+
+func (z *zoneLoadBalance) Select(request motan.Request) motan.EndPoint {
+	return z.selectTier().lb.Select(request)
+}
+
+func (z *zoneLoadBalance) SelectArray(request motan.Request) []motan.EndPoint {
+	return z.selectTier().lb.SelectArray(request)
+}
+
+func (z *zoneLoadBalance) SetWeight(weight string) {
+	z.weight = weight
+	for _, t := range z.tiers {
+		t.lb.SetWeight(weight)
+	}
+}
+
+// restoreLocality keeps the locality of the provider url on the endpoint url merged with the referer parameters
+func restoreLocality(endpointURL *motan.URL, providerURL *motan.URL) {
+	for _, key := range []string{ZoneKey, RegionKey} {
+		if v, ok := providerURL.Parameters[key]; ok {
+			endpointURL.Parameters[key] = v
+		} else {
+			delete(endpointURL.Parameters, key)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package cluster
+
+import (
+	"testing"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/lb"
+)
+
+type zoneTestEndpoint struct {
+	motan.TestEndPoint
+	available bool
+}
+
+func (z *zoneTestEndpoint) IsAvailable() bool {
+	return z.available
+}
+
+func newZoneTestEndpoint(port int, zone string, region string) *zoneTestEndpoint {
+	url := &motan.URL{Protocol: "test", Host: "127.0.0.1", Port: port, Parameters: map[string]string{ZoneKey: zone, RegionKey: region}}
+	return &zoneTestEndpoint{TestEndPoint: motan.TestEndPoint{URL: url}, available: true}
+}
+
+func TestZoneLoadBalance(t *testing.T) {
+	url := &motan.URL{Parameters: map[string]string{ZoneKey: "z1", RegionKey: "r1", ZoneSpilloverKey: "30", motan.Lbkey: lb.Roundrobin}}
+	ext := getCustomExt()
+	z := newZoneLoadBalance(url, func() motan.LoadBalance {
+		return ext.GetLB(url)
+	})
+	local1 := newZoneTestEndpoint(1001, "z1", "r1")
+	local2 := newZoneTestEndpoint(1002, "z1", "r1")
+	region := newZoneTestEndpoint(1003, "z2", "r1")
+	remote := newZoneTestEndpoint(1004, "z3", "r2")
+	z.OnRefresh([]motan.EndPoint{local1, local2, region, remote})
+	if len(z.tiers) != 3 {
+		t.Fatalf("zone tiers not correct. expect: 3, real: %d", len(z.tiers))
+	}
+	checkZone := func(expect string) {
+		for i := 0; i < 10; i++ {
+			if zone := z.Select(nil).GetURL().GetParam(ZoneKey, ""); zone != expect && expect != "" {
+				t.Fatalf("zone select not correct. expect: %s, real: %s", expect, zone)
+			}
+		}
+	}
+	checkZone("z1")
+
+	// spill over to the region when less than 30 percent of the local endpoints are available
+	local1.available = false
+	checkZone("z1")
+	local2.available = false
+	checkZone("z2")
+	if eps := z.SelectArray(nil); len(eps) != 1 || eps[0] != region {
+		t.Fatalf("zone select array not correct. %v", eps)
+	}
+	region.available = false
+	checkZone("z3")
+
+	// no local endpoint
+	z.OnRefresh([]motan.EndPoint{remote})
+	if len(z.tiers) != 1 {
+		t.Fatalf("zone tiers not correct. expect: 1, real: %d", len(z.tiers))
+	}
+	checkZone("z3")
+}
+
+func TestZoneAwareCluster(t *testing.T) {
+	url := &motan.URL{Protocol: "test", Parameters: map[string]string{motan.Hakey: "failover", motan.Lbkey: "random",
+		ZoneAwareKey: "true", ZoneKey: "z1"}}
+	cluster := NewCluster(&motan.Context{}, getCustomExt(), url, false)
+	z, ok := cluster.LoadBalance.(*zoneLoadBalance)
+	if !ok {
+		t.Fatalf("cluster load balance not zoneLoadBalance: %v", cluster.LoadBalance)
+	}
+	urls := []*motan.URL{
+		{Host: "127.0.0.1", Port: 8001, Protocol: "test", Parameters: map[string]string{ZoneKey: "z1"}},
+		{Host: "127.0.0.1", Port: 8002, Protocol: "test", Parameters: map[string]string{ZoneKey: "z2"}},
+		{Host: "127.0.0.1", Port: 8003, Protocol: "test", Parameters: map[string]string{}},
+	}
+	cluster.Notify(RegistryURL, urls)
+	if len(z.tiers) != 2 || len(z.tiers[0].endpoints) != 1 || z.tiers[0].endpoints[0].GetURL().Port != 8001 {
+		t.Fatalf("zone tiers not correct: %+v", z.tiers)
+	}
+	for i := 0; i < 10; i++ {
+		if port := z.Select(nil).GetURL().Port; port != 8001 {
+			t.Fatalf("zone select not correct. port: %d", port)
+		}
+	}
+}
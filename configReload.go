@@ -0,0 +1,213 @@
+package motan
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/weibocom/motan-go/config"
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+	mserver "github.com/weibocom/motan-go/server"
+	"gopkg.in/yaml.v2"
+)
+
+// ConfigWatchIntervalKey is the parameter(ms) of the motan-agent and the motan-server sections to check the changes
+// of the config files, the changes are applied as ReloadConfig. 0 or absent disables the watcher
+const ConfigWatchIntervalKey = "configWatchInterval"
+
+// ConfigChanges are the changes applied by a config reload
+type ConfigChanges struct {
+	ExportedServices   []string `json:"exported_services"`
+	UnexportedServices []string `json:"unexported_services"`
+	Refers             int      `json:"refers"`
+}
+
+// configFingerprint is the digest of the merged config, the changes of the layout of the files are ignored
+func configFingerprint(c *config.Config) string {
+	data, err := yaml.Marshal(c.GetOriginMap())
+	if err != nil {
+		return ""
+	}
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// watchConfig checks the config every interval, the reload func is called if the fingerprint is changed
+func watchConfig(name string, interval time.Duration, load func() (*config.Config, error), reload func() error, stop <-chan struct{}) {
+	var last string
+	if c, err := load(); err == nil {
+		last = configFingerprint(c)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+		c, err := load()
+		if err != nil {
+			vlog.Warningf("watch config of %s fail. err:%v", name, err)
+			continue
+		}
+		if fingerprint := configFingerprint(c); fingerprint != last {
+			vlog.Infof("config of %s is changed, reload it", name)
+			if err = reload(); err != nil {
+				vlog.Errorf("reload config of %s fail. err:%v", name, err)
+				continue
+			}
+			last = fingerprint
+		}
+	}
+}
+
+func (a *Agent) loadContext() (*motan.Context, error) {
+	ctx := &motan.Context{ConfigFile: a.ConfigFile}
+	ctx.Initialize()
+	if ctx.Config == nil {
+		return nil, errors.New("parse config file " + a.ConfigFile + " fail")
+	}
+	return ctx, nil
+}
+
+// ReloadConfig parses the config files again and applies the changes: the new services are exported, the removed
+// services are unexported, and the clusters of the changed refers are rebuilt. the services exported or
+// unexported by the dynamic config are not changed
+func (a *Agent) ReloadConfig() (*ConfigChanges, error) {
+	ctx, err := a.loadContext()
+	if err != nil {
+		return nil, err
+	}
+	a.reloadClusters(ctx)
+	changes := a.reloadServices(ctx)
+	changes.Refers = len(ctx.RefersURLs)
+	return changes, nil
+}
+
+// reloadServices exports the services of the context by the diff of the services of the config before. the services
+// are identified by the urls in the config, so a service with changed parameters is exported again
+func (a *Agent) reloadServices(ctx *motan.Context) *ConfigChanges {
+	a.svcLock.Lock()
+	services := make(map[string]*motan.URL, len(ctx.ServiceURLs))
+	for _, url := range ctx.ServiceURLs {
+		services[url.ToExtInfo()] = url
+	}
+	var removed []string
+	for key, identity := range a.configServices {
+		if _, ok := services[key]; !ok {
+			removed = append(removed, identity)
+			delete(a.configServices, key)
+		}
+	}
+	a.svcLock.Unlock()
+
+	changes := &ConfigChanges{}
+	for _, identity := range removed {
+		if exporter := a.serviceExporters.Delete(identity); exporter != nil {
+			exporter.(motan.Exporter).Unexport()
+			vlog.Infof("hot unexport service: %s", identity)
+			changes.UnexportedServices = append(changes.UnexportedServices, identity)
+		}
+	}
+	for key, url := range services {
+		a.initProxyServiceURL(url)
+		a.svcLock.Lock()
+		_, exported := a.configServices[key]
+		a.svcLock.Unlock()
+		if exported {
+			continue
+		}
+		a.doExportService(url)
+		a.svcLock.Lock()
+		a.configServices[key] = url.GetIdentity()
+		a.svcLock.Unlock()
+		vlog.Infof("hot export service: %s", url.GetIdentity())
+		changes.ExportedServices = append(changes.ExportedServices, url.GetIdentity())
+	}
+	return changes
+}
+
+func (a *Agent) startConfigWatcher() {
+	interval := a.agentURL.GetTimeDuration(ConfigWatchIntervalKey, time.Millisecond, 0)
+	if interval <= 0 {
+		return
+	}
+	load := func() (*config.Config, error) {
+		ctx, err := a.loadContext()
+		if err != nil {
+			return nil, err
+		}
+		return ctx.Config, nil
+	}
+	reload := func() error {
+		_, err := a.ReloadConfig()
+		return err
+	}
+	go watchConfig(a.ConfigFile, interval, load, reload, nil)
+}
+
+// ReloadConfig parses the config file of the server context again, the new services are exported and the removed
+// services are unexported. the implementations of the new services must be registered by RegisterService before
+func (m *MSContext) ReloadConfig() (*ConfigChanges, error) {
+	if m.confFile == "" {
+		return nil, errors.New("the server context is not created from a config file")
+	}
+	conf, err := config.NewConfigFromFile(m.confFile)
+	if err != nil {
+		return nil, err
+	}
+	ctx := motan.NewContextFromConfig(conf, "", "")
+	m.csync.Lock()
+	defer m.csync.Unlock()
+	services := make(map[string]*motan.URL, len(ctx.ServiceURLs))
+	for _, url := range ctx.ServiceURLs {
+		services[url.ToExtInfo()] = url
+	}
+	changes := &ConfigChanges{}
+	for key, exporter := range m.exporters {
+		if _, ok := services[key]; !ok {
+			exporter.Unexport()
+			delete(m.exporters, key)
+			vlog.Infof("hot unexport service: %s", exporter.GetURL().GetIdentity())
+			changes.UnexportedServices = append(changes.UnexportedServices, exporter.GetURL().GetIdentity())
+		}
+	}
+	m.config = conf
+	m.context.Config = conf
+	m.context.ServiceURLs = ctx.ServiceURLs
+	m.context.BasicServiceURLs = ctx.BasicServiceURLs
+	for key, url := range services {
+		if _, ok := m.exporters[key]; ok {
+			continue
+		}
+		if m.export(url) {
+			changes.ExportedServices = append(changes.ExportedServices, url.GetIdentity())
+		}
+	}
+	return changes, nil
+}
+
+func (m *MSContext) startConfigWatcher() {
+	if m.confFile == "" || m.configWatchInterval <= 0 {
+		return
+	}
+	load := func() (*config.Config, error) {
+		return config.NewConfigFromFile(m.confFile)
+	}
+	reload := func() error {
+		_, err := m.ReloadConfig()
+		return err
+	}
+	go watchConfig(m.confFile, m.configWatchInterval, load, reload, m.stopWatch)
+}
+
+// exporters are keyed by the urls in the config before exported
+func (m *MSContext) addExporter(key string, exporter *mserver.DefaultExporter) {
+	if m.exporters == nil {
+		m.exporters = make(map[string]*mserver.DefaultExporter)
+	}
+	m.exporters[key] = exporter
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"sort"
+	"strings"
+)
+
+// baggage items are carried by request attachments with BaggagePrefix
+const (
+	BaggagePrefix = "M_bg_"
+
+	MaxBaggageItemsKey = "maxBaggageItems" // url parameter of max baggage items of a request
+	MaxBaggageSizeKey  = "maxBaggageSize"  // url parameter of max total bytes of baggage keys and values
+
+	DefaultMaxBaggageItems = 32
+	DefaultMaxBaggageSize  = 4096
+)
+
+// Baggage is the key/values propagated along a trace, keys are without BaggagePrefix
+type Baggage map[string]string
+
+// ExtractBaggage extract the baggage from request attachments into the request rpc context.
+// when the limits are exceeded, items are kept in key order and the rest are dropped from both the context and the attachments
+func ExtractBaggage(request Request, maxItems int, maxSize int) Baggage {
+	attachments := request.GetAttachments()
+	if attachments == nil {
+		return nil
+	}
+	var keys []string
+	attachments.Range(func(k, v string) bool {
+		if strings.HasPrefix(k, BaggagePrefix) && len(k) > len(BaggagePrefix) {
+			keys = append(keys, k)
+		}
+		return true
+	})
+	if len(keys) == 0 {
+		return nil
+	}
+	sort.Strings(keys)
+	baggage := make(Baggage, len(keys))
+	size := 0
+	for _, k := range keys {
+		v := attachments.LoadOrEmpty(k)
+		key := k[len(BaggagePrefix):]
+		size += len(key) + len(v)
+		if len(baggage) >= maxItems || size > maxSize {
+			attachments.Delete(k)
+			continue
+		}
+		baggage[key] = v
+	}
+	request.GetRPCContext(true).Baggage = baggage
+	return baggage
+}
+
+// GetBaggage returns the baggage of request extracted by ExtractBaggage
+func GetBaggage(request Request) Baggage {
+	if ctx := request.GetRPCContext(false); ctx != nil {
+		return ctx.Baggage
+	}
+	return nil
+}
+
+// SetBaggageItem set a baggage item which will be propagated by the request and its outbound requests
+func SetBaggageItem(request Request, key string, value string) {
+	ctx := request.GetRPCContext(true)
+	if ctx.Baggage == nil {
+		ctx.Baggage = make(Baggage)
+	}
+	ctx.Baggage[key] = value
+	request.SetAttachment(BaggagePrefix+key, value)
+}
+
+// PropagateBaggage copy the baggage of inbound request to an outbound request made within it
+func PropagateBaggage(inbound Request, outbound Request) {
+	for k, v := range GetBaggage(inbound) {
+		if outbound.GetAttachment(BaggagePrefix+k) == "" {
+			SetBaggageItem(outbound, k, v)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBaggage(t *testing.T) {
+	request := &MotanRequest{}
+	request.SetAttachment(BaggagePrefix+"a", "1")
+	request.SetAttachment(BaggagePrefix+"b", "2")
+	request.SetAttachment(BaggagePrefix+"c", "3")
+	request.SetAttachment("other", "x")
+	baggage := ExtractBaggage(request, 2, 100)
+	assert.Equal(t, Baggage{"a": "1", "b": "2"}, baggage)
+	assert.Equal(t, baggage, GetBaggage(request))
+	assert.Equal(t, "", request.GetAttachment(BaggagePrefix+"c"))
+
+	request = &MotanRequest{}
+	request.SetAttachment(BaggagePrefix+"a", strings.Repeat("v", 10))
+	request.SetAttachment(BaggagePrefix+"b", strings.Repeat("v", 10))
+	assert.Equal(t, 1, len(ExtractBaggage(request, 10, 15)))
+	assert.Nil(t, ExtractBaggage(&MotanRequest{}, 10, 10))
+	assert.Nil(t, GetBaggage(&MotanRequest{}))
+
+	outbound := &MotanRequest{}
+	outbound.SetAttachment(BaggagePrefix+"a", "own")
+	SetBaggageItem(request, "added", "y")
+	PropagateBaggage(request, outbound)
+	assert.Equal(t, "own", outbound.GetAttachment(BaggagePrefix+"a"))
+	assert.Equal(t, "y", outbound.GetAttachment(BaggagePrefix+"added"))
+	assert.Equal(t, "y", GetBaggage(outbound)["added"])
+
+	clone := outbound.Clone().(Request)
+	assert.Equal(t, GetBaggage(outbound), GetBaggage(clone))
+}
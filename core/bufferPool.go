@@ -0,0 +1,88 @@
+package core
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+// the size classes of the buffer pool are the powers of 2 from minPooledSize to maxPooledSize, the larger buffers are
+// not pooled
+const (
+	minPooledSizeBits = 8  // 256B
+	maxPooledSizeBits = 22 // 4MB
+	pooledClasses     = maxPooledSizeBits - minPooledSizeBits + 1
+)
+
+var (
+	bytesBufferPools [pooledClasses]sync.Pool
+	bytesPools       [pooledClasses]sync.Pool
+)
+
+// acquireClass returns the smallest class not smaller than size, -1 if size is larger than the max class
+func acquireClass(size int) int {
+	for c := 0; c < pooledClasses; c++ {
+		if size <= 1<<uint(minPooledSizeBits+c) {
+			return c
+		}
+	}
+	return -1
+}
+
+// releaseClass returns the largest class not larger than capacity, -1 if capacity is smaller than the min class
+func releaseClass(capacity int) int {
+	for c := pooledClasses - 1; c >= 0; c-- {
+		if capacity >= 1<<uint(minPooledSizeBits+c) {
+			return c
+		}
+	}
+	return -1
+}
+
+// AcquireBytesBuffer returns an empty big endian BytesBuffer of at least size capacity from the buffer pool, it should
+// be released by ReleaseBytesBuffer when the bytes are not used
+func AcquireBytesBuffer(size int) *BytesBuffer {
+	c := acquireClass(size)
+	if c < 0 {
+		return NewBytesBuffer(size)
+	}
+	if v := bytesBufferPools[c].Get(); v != nil {
+		return v.(*BytesBuffer)
+	}
+	return NewBytesBuffer(1 << uint(minPooledSizeBits+c))
+}
+
+// ReleaseBytesBuffer returns the buffer to the buffer pool, the buffer and the bytes of it must not be used after that
+func ReleaseBytesBuffer(b *BytesBuffer) {
+	if b == nil {
+		return
+	}
+	c := releaseClass(len(b.buf))
+	if c < 0 || len(b.buf) > 1<<maxPooledSizeBits {
+		return
+	}
+	b.Reset()
+	b.order = binary.BigEndian
+	bytesBufferPools[c].Put(b)
+}
+
+// AcquireBytes returns a byte slice of the size from the buffer pool, the content of it is not zeroed. it should be
+// released by ReleaseBytes when it is not used
+func AcquireBytes(size int) []byte {
+	c := acquireClass(size)
+	if c < 0 {
+		return make([]byte, size)
+	}
+	if v := bytesPools[c].Get(); v != nil {
+		return v.([]byte)[:size]
+	}
+	return make([]byte, size, 1<<uint(minPooledSizeBits+c))
+}
+
+// ReleaseBytes returns the byte slice to the buffer pool, the slice must not be used after that
+func ReleaseBytes(b []byte) {
+	c := releaseClass(cap(b))
+	if c < 0 || cap(b) > 1<<maxPooledSizeBits {
+		return
+	}
+	bytesPools[c].Put(b[:0])
+}
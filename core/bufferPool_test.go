@@ -0,0 +1,62 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSizeClass(t *testing.T) {
+	assert.Equal(t, 0, acquireClass(1))
+	assert.Equal(t, 0, acquireClass(256))
+	assert.Equal(t, 1, acquireClass(257))
+	assert.Equal(t, pooledClasses-1, acquireClass(4*1024*1024))
+	assert.Equal(t, -1, acquireClass(4*1024*1024+1))
+
+	assert.Equal(t, -1, releaseClass(255))
+	assert.Equal(t, 0, releaseClass(256))
+	assert.Equal(t, 0, releaseClass(511))
+	assert.Equal(t, 1, releaseClass(512))
+}
+
+func TestAcquireBytesBuffer(t *testing.T) {
+	b := AcquireBytesBuffer(300)
+	assert.Equal(t, 0, b.Len())
+	assert.True(t, b.Cap() >= 300)
+	b.WriteString("hello")
+	b.WriteUint32(1)
+	assert.Equal(t, 9, b.Len())
+	ReleaseBytesBuffer(b)
+	ReleaseBytesBuffer(nil)
+
+	// the buffer released is reset
+	b = AcquireBytesBuffer(300)
+	assert.Equal(t, 0, b.Len())
+	assert.Equal(t, 0, b.Remain())
+	ReleaseBytesBuffer(b)
+
+	large := AcquireBytesBuffer(5 * 1024 * 1024)
+	assert.True(t, large.Cap() >= 5*1024*1024)
+	ReleaseBytesBuffer(large)
+}
+
+func TestAcquireBytes(t *testing.T) {
+	b := AcquireBytes(100)
+	assert.Equal(t, 100, len(b))
+	assert.Equal(t, 256, cap(b))
+	ReleaseBytes(b)
+	b = AcquireBytes(200)
+	assert.Equal(t, 200, len(b))
+	ReleaseBytes(b)
+	// the slices smaller than the min class are not pooled
+	ReleaseBytes(make([]byte, 10))
+	assert.Equal(t, 10, len(AcquireBytes(10)))
+}
+
+func TestWriteString(t *testing.T) {
+	b := NewBytesBuffer(2)
+	b.WriteString("motan")
+	b.WriteString("")
+	b.WriteString("2")
+	assert.Equal(t, "motan2", string(b.Bytes()))
+}
@@ -88,6 +88,16 @@ func (b *BytesBuffer) Write(bytes []byte) {
 	b.wpos += l
 }
 
+// WriteString write a string append the BytesBuffer without converting it to a byte array, the wpos will increase len(s)
+func (b *BytesBuffer) WriteString(s string) {
+	l := len(s)
+	if len(b.buf) < b.wpos+l {
+		b.grow(l)
+	}
+	copy(b.buf[b.wpos:], s)
+	b.wpos += l
+}
+
 // WriteUint16 write a uint16 append the BytesBuffer acording to buffer's order
 func (b *BytesBuffer) WriteUint16(u uint16) {
 	if len(b.buf) < b.wpos+2 {
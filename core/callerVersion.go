@@ -0,0 +1,81 @@
+package core
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	// CallerVersionAttachment is the request attachment of the caller(client) version, e.g. "2.1.0"
+	CallerVersionAttachment = "M_cv"
+	// CallerVersionKey is the client url parameter of the caller version sent by the client
+	CallerVersionKey = "callerVersion"
+)
+
+// ExtractCallerVersion put the caller version of request attachment into the request rpc context
+func ExtractCallerVersion(request Request) string {
+	version := strings.TrimSpace(request.GetAttachment(CallerVersionAttachment))
+	if version != "" {
+		request.GetRPCContext(true).CallerVersion = version
+	}
+	return version
+}
+
+// GetCallerVersion returns the caller version extracted by ExtractCallerVersion, empty if the caller does not send it
+func GetCallerVersion(request Request) string {
+	if ctx := request.GetRPCContext(false); ctx != nil {
+		return ctx.CallerVersion
+	}
+	return ""
+}
+
+// CallerVersionAtLeast check whether the caller version is not less than the version.
+// the callers without version are treated as the oldest ones
+func CallerVersionAtLeast(request Request, version string) bool {
+	callerVersion := GetCallerVersion(request)
+	if callerVersion == "" {
+		return false
+	}
+	return CompareVersion(callerVersion, version) >= 0
+}
+
+// CompareVersion compare dot separated numeric versions, e.g. "1.10" > "1.9", missing parts are treated as 0.
+// non-numeric parts are compared as strings
+func CompareVersion(a string, b string) int {
+	as := strings.Split(a, ".")
+	bs := strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var ap, bp string
+		if i < len(as) {
+			ap = as[i]
+		}
+		if i < len(bs) {
+			bp = bs[i]
+		}
+		if c := compareVersionPart(ap, bp); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func compareVersionPart(a string, b string) int {
+	if a == "" {
+		a = "0"
+	}
+	if b == "" {
+		b = "0"
+	}
+	an, aErr := strconv.Atoi(a)
+	bn, bErr := strconv.Atoi(b)
+	if aErr == nil && bErr == nil {
+		switch {
+		case an < bn:
+			return -1
+		case an > bn:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(a, b)
+}
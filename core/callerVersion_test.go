@@ -0,0 +1,27 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareVersion(t *testing.T) {
+	assert.Equal(t, 0, CompareVersion("1.0", "1"))
+	assert.Equal(t, 1, CompareVersion("1.10", "1.9"))
+	assert.Equal(t, -1, CompareVersion("1.2", "1.2.1"))
+	assert.Equal(t, 1, CompareVersion("2.0.0", "1.99"))
+	assert.Equal(t, -1, CompareVersion("1.0-alpha", "1.0-beta"))
+}
+
+func TestCallerVersion(t *testing.T) {
+	request := &MotanRequest{}
+	assert.Equal(t, "", ExtractCallerVersion(request))
+	assert.False(t, CallerVersionAtLeast(request, "0"))
+	request.SetAttachment(CallerVersionAttachment, " 2.1.0 ")
+	assert.Equal(t, "2.1.0", ExtractCallerVersion(request))
+	assert.Equal(t, "2.1.0", GetCallerVersion(request))
+	assert.True(t, CallerVersionAtLeast(request, "2.1"))
+	assert.False(t, CallerVersionAtLeast(request, "2.2"))
+	assert.Equal(t, "2.1.0", GetCallerVersion(request.Clone().(Request)))
+}
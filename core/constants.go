@@ -10,6 +10,8 @@ const (
 	ServiceException
 	// BizException : exception by service implements
 	BizException
+	// TimeoutException : exception by service call exceeding the timeout
+	TimeoutException
 )
 
 // filter type
@@ -44,6 +46,10 @@ const (
 	ProxyKey                = "proxy"
 	AddressKey              = "address"
 	GzipSizeKey             = "mingzSize"
+	CompressionKey          = "compression"         // the compression algorithms of the bodies larger than GzipSizeKey, e.g. "snappy,gzip"
+	MaxRequestBodySizeKey   = "maxRequestBodySize"  // bytes, the larger request bodies are rejected by the motan2 server and endpoint
+	MaxResponseBodySizeKey  = "maxResponseBodySize" // bytes, the larger response bodies are rejected by the motan2 server and endpoint
+	MaxMetadataSizeKey      = "maxMetadataSize"     // bytes, the requests of the larger metadata are rejected by the motan2 server and endpoint, see MetadataSize
 	HostKey                 = "host"
 	RemoteIPKey             = "remoteIP"
 	ProxyRegistryKey        = "proxyRegistry"
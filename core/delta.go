@@ -0,0 +1,56 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	// DeltaBaseAttachment is the request attachment of the version of the value the client has
+	DeltaBaseAttachment = "M_dbase"
+	// DeltaVersionAttachment is the response attachment of the version of the full value
+	DeltaVersionAttachment = "M_dver"
+	// DeltaAttachment is the response attachment of the name of the delta strategy if the response value is a delta against
+	// the base version, the value is the full value if it is absent
+	DeltaAttachment = "M_delta"
+)
+
+// BytesDelta is the name of the delta of []byte or string values, which replaces the bytes between the common prefix and suffix
+const BytesDelta = "bytes"
+
+var ErrIllegalBytesDelta = errors.New("illegal bytes delta")
+
+// DiffBytes returns the BytesDelta from base to value: uvarint prefix length, uvarint suffix length and the changed bytes
+func DiffBytes(base []byte, value []byte) []byte {
+	prefix := 0
+	for prefix < len(base) && prefix < len(value) && base[prefix] == value[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(base)-prefix && suffix < len(value)-prefix && base[len(base)-1-suffix] == value[len(value)-1-suffix] {
+		suffix++
+	}
+	changed := value[prefix : len(value)-suffix]
+	delta := make([]byte, 2*binary.MaxVarintLen64+len(changed))
+	n := binary.PutUvarint(delta, uint64(prefix))
+	n += binary.PutUvarint(delta[n:], uint64(suffix))
+	n += copy(delta[n:], changed)
+	return delta[:n]
+}
+
+// PatchBytes applies the BytesDelta to the base, the clients use it to rebuild the value
+func PatchBytes(base []byte, delta []byte) ([]byte, error) {
+	prefix, n := binary.Uvarint(delta)
+	if n <= 0 {
+		return nil, ErrIllegalBytesDelta
+	}
+	suffix, m := binary.Uvarint(delta[n:])
+	if m <= 0 || prefix+suffix > uint64(len(base)) {
+		return nil, ErrIllegalBytesDelta
+	}
+	changed := delta[n+m:]
+	value := make([]byte, 0, int(prefix)+len(changed)+int(suffix))
+	value = append(value, base[:prefix]...)
+	value = append(value, changed...)
+	return append(value, base[uint64(len(base))-suffix:]...), nil
+}
@@ -0,0 +1,21 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBytesDelta(t *testing.T) {
+	for _, c := range [][2]string{{"hello world", "hello motan world"}, {"", "abc"}, {"abc", ""}, {"aaa", "aaaa"}, {"same", "same"}, {"abc", "xyz"}} {
+		delta := DiffBytes([]byte(c[0]), []byte(c[1]))
+		value, err := PatchBytes([]byte(c[0]), delta)
+		assert.Nil(t, err, c[0])
+		assert.Equal(t, c[1], string(value), c[0])
+	}
+	assert.True(t, len(DiffBytes([]byte("a large resource v1"), []byte("a large resource v2"))) < 4)
+	_, err := PatchBytes([]byte("abc"), DiffBytes([]byte("abcdef"), []byte("abcdeg")))
+	assert.Equal(t, ErrIllegalBytesDelta, err)
+	_, err = PatchBytes([]byte("abc"), nil)
+	assert.Equal(t, ErrIllegalBytesDelta, err)
+}
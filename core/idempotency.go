@@ -0,0 +1,9 @@
+package core
+
+// IdempotencyKeyAttachment is the request attachment of the idempotency key, requests with the same key are one operation
+const IdempotencyKeyAttachment = "M_idk"
+
+// SetIdempotencyKey set the idempotency key of a request, the retries of the request should use the same key
+func SetIdempotencyKey(request Request, key string) {
+	request.SetAttachment(IdempotencyKeyAttachment, key)
+}
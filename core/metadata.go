@@ -0,0 +1,143 @@
+package core
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// the conventions of the attachment keys shared with the other motan implementations, e.g. motan-java
+const (
+	// ReservedAttachmentPrefix is the prefix of the keys of the protocol metadata, e.g. M_p, the applications should not
+	// use it for their own attachments
+	ReservedAttachmentPrefix = "M_"
+	// HTTPAttachmentPrefix replaces ReservedAttachmentPrefix when the attachments are carried by the http headers,
+	// the registered reserved keys are restored by NormalizeAttachmentKey
+	HTTPAttachmentPrefix = "MOTAN-"
+	// BinaryAttachmentSuffix is the suffix of the keys of the binary values encoded in base64, see SetBinaryAttachment
+	BinaryAttachmentSuffix = "-bin"
+)
+
+// DefaultMaxMetadataSize is the default limit of MaxMetadataSizeKey
+const DefaultMaxMetadataSize = 64 * 1024
+
+var (
+	reservedKeysLock sync.RWMutex
+	// the lower cased names after the prefix of the reserved keys, e.g. "p" of M_p
+	reservedKeys = make(map[string]string)
+)
+
+func init() {
+	RegisterReservedAttachmentKeys(IdempotencyKeyAttachment, PreloadHintsRequestAttachment, PreloadHintsAttachment,
+		CallerVersionAttachment, DeltaBaseAttachment, DeltaVersionAttachment, DeltaAttachment,
+		RetryBudgetAttachment, RetryBackoffAttachment, RetryAfterAttachment)
+}
+
+// RegisterReservedAttachmentKeys registers the protocol metadata keys with ReservedAttachmentPrefix, only the registered
+// keys are restored by NormalizeAttachmentKey
+func RegisterReservedAttachmentKeys(keys ...string) {
+	reservedKeysLock.Lock()
+	defer reservedKeysLock.Unlock()
+	for _, key := range keys {
+		if strings.HasPrefix(key, ReservedAttachmentPrefix) && len(key) > len(ReservedAttachmentPrefix) {
+			reservedKeys[strings.ToLower(key[len(ReservedAttachmentPrefix):])] = key
+		}
+	}
+}
+
+// NormalizeAttachmentKey returns the registered reserved key in the form of motan-go and motan-java. the prefixes of the
+// reserved keys are case-insensitive, e.g. m_p is M_p, and the reserved keys carried by the http headers with
+// HTTPAttachmentPrefix are case-insensitive, e.g. Motan-P is M_p. the other keys are kept as is, e.g. m_foo or motan-x
+func NormalizeAttachmentKey(key string) string {
+	var name string
+	if len(key) > len(ReservedAttachmentPrefix) && strings.EqualFold(key[:len(ReservedAttachmentPrefix)], ReservedAttachmentPrefix) {
+		name = key[len(ReservedAttachmentPrefix):]
+	} else if len(key) > len(HTTPAttachmentPrefix) && strings.EqualFold(key[:len(HTTPAttachmentPrefix)], HTTPAttachmentPrefix) {
+		name = key[len(HTTPAttachmentPrefix):]
+	} else {
+		return key
+	}
+	reservedKeysLock.RLock()
+	defer reservedKeysLock.RUnlock()
+	if reserved, ok := reservedKeys[strings.ToLower(name)]; ok {
+		return reserved
+	}
+	return key
+}
+
+// IsReservedAttachmentKey returns whether the key is of the protocol metadata
+func IsReservedAttachmentKey(key string) bool {
+	return strings.HasPrefix(NormalizeAttachmentKey(key), ReservedAttachmentPrefix)
+}
+
+// MetadataSize returns the encoded size of the keys and the values of the attachments
+func MetadataSize(m *StringMap) int {
+	if m == nil {
+		return 0
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	size := 0
+	for k, v := range m.innerMap {
+		// the empty keys and values are not encoded, the key and the value are both followed by a separator
+		if k != "" && v != "" {
+			size += len(k) + len(v) + 2
+		}
+	}
+	return size
+}
+
+// SetBinaryAttachment sets the value in base64 with the key ending with BinaryAttachmentSuffix, so the bytes which are not
+// allowed in the metadata(e.g. '\n') are kept
+func SetBinaryAttachment(a Attachment, key string, value []byte) {
+	a.SetAttachment(binaryAttachmentKey(key), base64.StdEncoding.EncodeToString(value))
+}
+
+// GetBinaryAttachment returns the value set by SetBinaryAttachment, the values without padding are also accepted
+func GetBinaryAttachment(a Attachment, key string) ([]byte, bool) {
+	v := a.GetAttachment(binaryAttachmentKey(key))
+	if v == "" {
+		return nil, false
+	}
+	b, err := base64.StdEncoding.DecodeString(v)
+	if err != nil {
+		if b, err = base64.RawStdEncoding.DecodeString(v); err != nil {
+			return nil, false
+		}
+	}
+	return b, true
+}
+
+func binaryAttachmentKey(key string) string {
+	if strings.HasSuffix(key, BinaryAttachmentSuffix) {
+		return key
+	}
+	return key + BinaryAttachmentSuffix
+}
+
+// GetAttachmentInt returns the int value of the attachment, false if it is missing or not an int
+func GetAttachmentInt(a Attachment, key string) (int64, bool) {
+	v := a.GetAttachment(key)
+	if v == "" {
+		return 0, false
+	}
+	i, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return i, true
+}
+
+// GetAttachmentBool returns the bool value of the attachment, e.g. "true" of motan-java, false if it is missing or not a bool
+func GetAttachmentBool(a Attachment, key string) (bool, bool) {
+	v := a.GetAttachment(key)
+	if v == "" {
+		return false, false
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, false
+	}
+	return b, true
+}
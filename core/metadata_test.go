@@ -0,0 +1,78 @@
+package core
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeAttachmentKey(t *testing.T) {
+	RegisterReservedAttachmentKeys("M_p", "M_g", "M_mdu", "M_rid", "illegal")
+	for key, expect := range map[string]string{
+		"M_p":       "M_p",
+		"m_g":       "M_g",
+		"Motan-P":   "M_p",
+		"MOTAN-MDU": "M_mdu",
+		"motan-rid": "M_rid",
+		"m_rb":      "M_rb",
+		"userId":    "userId",
+		"M_":        "M_",
+		"Motan-":    "Motan-",
+		"motanKey":  "motanKey",
+		"":          "",
+		// the keys not reserved are kept
+		"m_foo":   "m_foo",
+		"motan-x": "motan-x",
+		"M_Foo":   "M_Foo",
+	} {
+		assert.Equal(t, expect, NormalizeAttachmentKey(key), key)
+	}
+	assert.True(t, IsReservedAttachmentKey("Motan-G"))
+	assert.False(t, IsReservedAttachmentKey("group"))
+	assert.False(t, IsReservedAttachmentKey("m_foo"))
+}
+
+func TestTypedAttachments(t *testing.T) {
+	request := &MotanRequest{}
+	request.SetAttachment("count", "12")
+	request.SetAttachment("enabled", "true")
+	request.SetAttachment("illegal", "abc")
+	v, ok := request.GetInt("count")
+	assert.True(t, ok)
+	assert.Equal(t, int64(12), v)
+	_, ok = request.GetInt("illegal")
+	assert.False(t, ok)
+	_, ok = request.GetInt("missing")
+	assert.False(t, ok)
+	b, ok := request.GetBool("enabled")
+	assert.True(t, ok && b)
+	_, ok = request.GetBool("illegal")
+	assert.False(t, ok)
+
+	response := &MotanResponse{}
+	response.SetAttachment("retry", "0")
+	b, ok = response.GetBool("retry")
+	assert.True(t, ok)
+	assert.False(t, b)
+
+	value := []byte("line1\nline2\x00")
+	SetBinaryAttachment(request, "token", value)
+	assert.NotContains(t, request.GetAttachment("token"+BinaryAttachmentSuffix), "\n")
+	bin, ok := GetBinaryAttachment(request, "token")
+	assert.True(t, ok)
+	assert.Equal(t, value, bin)
+	// the values without padding of the other implementations
+	request.SetAttachment("raw-bin", base64.RawStdEncoding.EncodeToString([]byte("ab")))
+	bin, ok = GetBinaryAttachment(request, "raw-bin")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("ab"), bin)
+	_, ok = GetBinaryAttachment(request, "missing")
+	assert.False(t, ok)
+
+	assert.Equal(t, 0, MetadataSize(nil))
+	m := NewStringMap(2)
+	m.Store("ab", "c")
+	m.Store("d", "")
+	assert.Equal(t, 5, MetadataSize(m))
+}
@@ -1,6 +1,8 @@
 package core
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"strconv"
 	"strings"
@@ -174,7 +176,9 @@ type DiscoverCommand interface {
 	DiscoverCommand(url *URL) string
 }
 
-// RegisterService : register service for rpc server
+// RegisterService : register service for rpc server.
+// implementations should be idempotent: registering a registered url or unregistering a url not registered is a no-op,
+// and GetRegisteredServices contains a url at most once. registrytest.Run checks the contract
 type RegisterService interface {
 	Register(serverURL *URL)
 	UnRegister(serverURL *URL)
@@ -183,6 +187,19 @@ type RegisterService interface {
 	GetRegisteredServices() []*URL
 }
 
+// BatchRegistry : registry which registers multiple urls in one operation, e.g. for the batched registrations of exporters
+type BatchRegistry interface {
+	Registry
+	RegisterBatch(serverURLs []*URL)
+}
+
+// TryRegistry : registry which reports the registration failure, e.g. the registry is unreachable. the exporters retry
+// the failed registrations
+type TryRegistry interface {
+	Registry
+	TryRegister(serverURL *URL) error
+}
+
 // SnapshotService : start registry snapshot
 type SnapshotService interface {
 	StartSnapshot(conf *SnapshotConf)
@@ -228,6 +245,15 @@ type EndPointFilter interface {
 	Filter(caller Caller, request Request) Response
 }
 
+// FilterConstraint is implemented by the filters which can not work with some filters or rely on the order of other filters.
+// the constraints are checked when the filters of a provider are built
+type FilterConstraint interface {
+	// ConflictFilters returns the names of the filters which can not be configured together with the filter
+	ConflictFilters() []string
+	// AfterFilters returns the names of the filters which must be called before the filter if they are configured
+	AfterFilters() []string
+}
+
 // ClusterFilter : filter for cluster
 type ClusterFilter interface {
 	Filter
@@ -245,6 +271,9 @@ type Server interface {
 	SetMessageHandler(mh MessageHandler)
 	GetMessageHandler() MessageHandler
 	Open(block bool, proxy bool, handler MessageHandler, extFactory ExtensionFactory) error
+	// Shutdown stops accepting new connections, waits the requests in processing until they finish or the context is done,
+	// and closes the connections
+	Shutdown(ctx context.Context) error
 }
 
 // Exporter : export and manage a service. one exporter bind with a service
@@ -266,6 +295,14 @@ type Provider interface {
 	GetPath() string
 }
 
+// DynamicMethodProvider : provider whose method set can change at runtime, e.g. plugin methods
+type DynamicMethodProvider interface {
+	// AddMethod add or replace a method, the method should be a func
+	AddMethod(name string, method interface{}) error
+	// RemoveMethod returns false if the method does not exist
+	RemoveMethod(name string) bool
+}
+
 // MessageHandler : handler message(request) for Server
 type MessageHandler interface {
 	Call(request Request) (res Response)
@@ -315,6 +352,15 @@ type SetContext interface {
 	SetContext(context *Context)
 }
 
+// GroupCallerFactory creates the caller of the url of another group, the url is a copy of the cluster url with the
+// group changed
+type GroupCallerFactory func(url *URL) Caller
+
+// SetGroupCallerFactory is implemented by the cluster filters which call other groups of the service
+type SetGroupCallerFactory interface {
+	SetGroupCallerFactory(factory GroupCallerFactory)
+}
+
 // Initialize : Initialize if implement Initializable
 func Initialize(s interface{}) {
 	if init, ok := s.(Initializable); ok {
@@ -343,6 +389,39 @@ type Exception struct {
 	ErrCode int    `json:"errcode"`
 	ErrMsg  string `json:"errmsg"`
 	ErrType int    `json:"errtype"`
+	// Details is the structured data of the exception in json, e.g. field errors. old clients only read ErrMsg
+	Details json.RawMessage `json:"details,omitempty"`
+}
+
+// NewDetailedException build an exception with structured details, details is encoded as json
+func NewDetailedException(errCode int, errMsg string, errType int, details interface{}) (*Exception, error) {
+	e := &Exception{ErrCode: errCode, ErrMsg: errMsg, ErrType: errType}
+	if err := e.SetDetails(details); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// SetDetails encode details as json, nil details clears it
+func (e *Exception) SetDetails(details interface{}) error {
+	if details == nil {
+		e.Details = nil
+		return nil
+	}
+	data, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	e.Details = data
+	return nil
+}
+
+// DecodeDetails decode the details into v, it returns false if the exception has no details
+func (e *Exception) DecodeDetails(v interface{}) (bool, error) {
+	if len(e.Details) == 0 {
+		return false, nil
+	}
+	return true, json.Unmarshal(e.Details, v)
 }
 
 // RPCContext : Context for RPC call
@@ -352,6 +431,7 @@ type RPCContext struct {
 	Oneway          bool
 	Proxy           bool
 	GzipSize        int
+	Compression     string // the compression algorithm of the body larger than GzipSize, default gzip
 	BodySize        int
 	SerializeNum    int
 	Serialized      bool
@@ -372,6 +452,32 @@ type RPCContext struct {
 
 	// trace context
 	Tc *TraceContext
+	// trace baggage of the request, see ExtractBaggage
+	Baggage Baggage
+	// version of the caller, see ExtractCallerVersion
+	CallerVersion string
+	// names of the server filters skipped by a trusted caller
+	SkipFilters map[string]bool
+
+	// progress of long running call, the listener is set by client and the reporter is set by server
+	ProgressListener ProgressListener
+	ProgressReporter func(event *ProgressEvent) error
+	// trailers of the response, set by server. see SetTrailer
+	Trailers *Trailers
+	// stream of the server call, it is set if the request opens a stream. see GetStream
+	Stream Stream
+	// context of the server call, it has the deadline of the request timeout and is canceled when the call times out,
+	// so the providers can stop the useless work. see RequestContext. on the client the call stops waiting for the
+	// response when it is canceled
+	Context context.Context
+}
+
+// RequestContext returns the context of the server call of the request, context.Background() if it is not set
+func RequestContext(request Request) context.Context {
+	if rc := request.GetRPCContext(false); rc != nil && rc.Context != nil {
+		return rc.Context
+	}
+	return context.Background()
 }
 
 func (c *RPCContext) AddFinishHandler(handler FinishHandler) {
@@ -439,6 +545,16 @@ func (m *MotanRequest) SetAttachment(key string, value string) {
 	m.GetAttachments().Store(key, value)
 }
 
+// GetInt returns the int value of the attachment, see GetAttachmentInt
+func (m *MotanRequest) GetInt(key string) (int64, bool) {
+	return GetAttachmentInt(m, key)
+}
+
+// GetBool returns the bool value of the attachment, see GetAttachmentBool
+func (m *MotanRequest) GetBool(key string) (bool, bool) {
+	return GetAttachmentBool(m, key)
+}
+
 // GetServiceName GetServiceName
 func (m *MotanRequest) GetServiceName() string {
 	return m.ServiceName
@@ -507,6 +623,7 @@ func (m *MotanRequest) Clone() interface{} {
 			Oneway:              m.RPCContext.Oneway,
 			Proxy:               m.RPCContext.Proxy,
 			GzipSize:            m.RPCContext.GzipSize,
+			Compression:         m.RPCContext.Compression,
 			SerializeNum:        m.RPCContext.SerializeNum,
 			Serialized:          m.RPCContext.Serialized,
 			AsyncCall:           m.RPCContext.AsyncCall,
@@ -519,6 +636,9 @@ func (m *MotanRequest) Clone() interface{} {
 			ResponseReceiveTime: m.RPCContext.ResponseReceiveTime,
 			FinishHandlers:      m.RPCContext.FinishHandlers,
 			Tc:                  m.RPCContext.Tc,
+			Baggage:             m.RPCContext.Baggage,
+			CallerVersion:       m.RPCContext.CallerVersion,
+			ProgressListener:    m.RPCContext.ProgressListener,
 		}
 		if m.RPCContext.OriginalMessage != nil {
 			if oldMessage, ok := m.RPCContext.OriginalMessage.(Cloneable); ok {
@@ -567,6 +687,16 @@ func (m *MotanResponse) SetAttachment(key string, value string) {
 	m.GetAttachments().Store(key, value)
 }
 
+// GetInt returns the int value of the attachment, see GetAttachmentInt
+func (m *MotanResponse) GetInt(key string) (int64, bool) {
+	return GetAttachmentInt(m, key)
+}
+
+// GetBool returns the bool value of the attachment, see GetAttachmentBool
+func (m *MotanResponse) GetBool(key string) (bool, bool) {
+	return GetAttachmentBool(m, key)
+}
+
 func (m *MotanResponse) GetValue() interface{} {
 	return m.Value
 }
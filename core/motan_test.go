@@ -190,3 +190,22 @@ func newDiscoverErrorRegistry() *TestRegistry {
 	registry.DiscoverError = true
 	return registry
 }
+
+func TestExceptionDetails(t *testing.T) {
+	type fieldError struct {
+		Field  string `json:"field"`
+		Reason string `json:"reason"`
+	}
+	e, err := NewDetailedException(400, "invalid params", BizException, []fieldError{{Field: "name", Reason: "required"}})
+	assert.Nil(t, err)
+	var details []fieldError
+	ok, err := e.DecodeDetails(&details)
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	assert.Equal(t, "name", details[0].Field)
+
+	_, err = NewDetailedException(400, "invalid params", BizException, func() {})
+	assert.NotNil(t, err)
+	ok, _ = (&Exception{ErrCode: 500}).DecodeDetails(&details)
+	assert.False(t, ok)
+}
@@ -0,0 +1,79 @@
+package core
+
+import (
+	"sync"
+	"time"
+
+	"github.com/weibocom/motan-go/log"
+)
+
+const panicQueueSize = 100
+
+// PanicInfo describes a recovered panic, Service and Method are empty if the panic is not recovered by HandleRequestPanic
+type PanicInfo struct {
+	Service string
+	Method  string
+	Err     interface{}
+	Stack   []byte
+	Time    time.Time
+}
+
+// PanicCallback is called asynchronously in a single goroutine for each recovered panic, e.g. for alerting.
+// panics are dropped when the callbacks can not keep up, so a slow callback will not block the recovering goroutine
+type PanicCallback func(info *PanicInfo)
+
+var (
+	panicCallbacks     []PanicCallback
+	panicCallbacksLock sync.RWMutex
+	panicQueue         chan *PanicInfo
+	panicQueueOnce     sync.Once
+)
+
+// RegisterPanicCallback add a callback for recovered panics
+func RegisterPanicCallback(callback PanicCallback) {
+	if callback == nil {
+		return
+	}
+	panicQueueOnce.Do(func() {
+		panicQueue = make(chan *PanicInfo, panicQueueSize)
+		go dispatchPanics()
+	})
+	panicCallbacksLock.Lock()
+	panicCallbacks = append(panicCallbacks, callback)
+	panicCallbacksLock.Unlock()
+}
+
+func getPanicCallbacks() []PanicCallback {
+	panicCallbacksLock.RLock()
+	defer panicCallbacksLock.RUnlock()
+	return panicCallbacks
+}
+
+func notifyPanic(info *PanicInfo) {
+	if len(getPanicCallbacks()) == 0 {
+		return
+	}
+	select {
+	case panicQueue <- info:
+	default:
+		vlog.Warningf("panic callback queue is full, drop panic info. service:%s, method:%s", info.Service, info.Method)
+	}
+}
+
+func dispatchPanics() {
+	for info := range panicQueue {
+		for _, callback := range getPanicCallbacks() {
+			callPanicCallback(callback, info)
+		}
+	}
+}
+
+// callPanicCallback does not use HandlePanic, avoid notifying the panic of callback itself again
+func callPanicCallback(callback PanicCallback, info *PanicInfo) {
+	defer func() {
+		if err := recover(); err != nil {
+			vlog.Errorf("panic callback panic. error:%v", err)
+		}
+	}()
+	callback(info)
+}
@@ -0,0 +1,78 @@
+package core
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleRequestPanic(t *testing.T) {
+	var statService, statMethod string
+	MethodPanicStatFunc = func(service string, method string) {
+		statService = service
+		statMethod = method
+	}
+	defer func() {
+		MethodPanicStatFunc = nil
+	}()
+	// callbacks can not be removed, so only handle the panics of this run
+	service := "panicService" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	infos := make(chan *PanicInfo, 10)
+	block := make(chan bool)
+	RegisterPanicCallback(func(info *PanicInfo) {
+		if info.Service != service {
+			return
+		}
+		if info.Method == "block" {
+			<-block
+		}
+		select {
+		case infos <- info:
+		default:
+		}
+	})
+	RegisterPanicCallback(func(info *PanicInfo) {
+		panic("callback panic")
+	})
+
+	recovered := false
+	func() {
+		defer HandleRequestPanic(&MotanRequest{ServiceName: service, Method: "hello"}, func() {
+			recovered = true
+		})
+		panic("test panic")
+	}()
+	assert.True(t, recovered)
+	assert.Equal(t, service, statService)
+	assert.Equal(t, "hello", statMethod)
+	select {
+	case info := <-infos:
+		assert.Equal(t, service, info.Service)
+		assert.Equal(t, "hello", info.Method)
+		assert.Equal(t, "test panic", info.Err)
+		assert.NotEmpty(t, info.Stack)
+	case <-time.After(time.Second):
+		assert.Fail(t, "panic callback not called")
+	}
+
+	// a blocked callback does not block the recovering goroutine
+	start := time.Now()
+	for i := 0; i < panicQueueSize*2; i++ {
+		func() {
+			defer HandleRequestPanic(&MotanRequest{ServiceName: service, Method: "block"}, nil)
+			panic("block panic")
+		}()
+	}
+	assert.True(t, time.Since(start) < time.Second)
+	close(block)
+	for len(panicQueue) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	func() {
+		defer HandlePanic(nil)
+		panic("no request")
+	}()
+}
@@ -0,0 +1,59 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PlacementHintsKey is the service url parameter of the placement hints of the provider, e.g. "zone=bj-1,rack=r12,antiAffinity=order-shard-3".
+// motan does not schedule providers, the hints are published with the registered url for schedulers and clients
+const PlacementHintsKey = "placementHints"
+
+// PlacementPrefix is the prefix of the hint parameters in the registered url, e.g. placement.zone
+const PlacementPrefix = "placement."
+
+// well known placement hints
+const (
+	// PlacementZone is the availability zone of the provider
+	PlacementZone = "zone"
+	// PlacementRack is the rack or failure domain of the provider
+	PlacementRack = "rack"
+	// PlacementAffinity is the group of the providers preferred to be co-located
+	PlacementAffinity = "affinity"
+	// PlacementAntiAffinity is the group of the providers which should not be co-located, e.g. replicas of a shard
+	PlacementAntiAffinity = "antiAffinity"
+)
+
+// ParsePlacementHints parses the hints of PlacementHintsKey, a hint name can not be empty or repeated
+func ParsePlacementHints(value string) (map[string]string, error) {
+	hints := make(map[string]string)
+	for _, hint := range TrimSplit(value, ",") {
+		if hint == "" {
+			continue
+		}
+		kv := strings.SplitN(hint, "=", 2)
+		name := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || name == "" || strings.TrimSpace(kv[1]) == "" {
+			return nil, fmt.Errorf("illegal %s: %s, hints should be name=value separated by comma", PlacementHintsKey, value)
+		}
+		if _, ok := hints[name]; ok {
+			return nil, fmt.Errorf("illegal %s: %s, hint %s is repeated", PlacementHintsKey, value, name)
+		}
+		hints[name] = strings.TrimSpace(kv[1])
+	}
+	return hints, nil
+}
+
+// GetPlacementHints returns the placement hints published with the registered url, nil if the provider has no hints
+func GetPlacementHints(url *URL) map[string]string {
+	var hints map[string]string
+	for k, v := range url.Parameters {
+		if strings.HasPrefix(k, PlacementPrefix) {
+			if hints == nil {
+				hints = make(map[string]string)
+			}
+			hints[k[len(PlacementPrefix):]] = v
+		}
+	}
+	return hints
+}
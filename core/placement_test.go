@@ -0,0 +1,21 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlacementHints(t *testing.T) {
+	hints, err := ParsePlacementHints(" zone=bj-1, antiAffinity = shard-3,")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{PlacementZone: "bj-1", PlacementAntiAffinity: "shard-3"}, hints)
+	for _, value := range []string{"zone", "=bj", "zone=", "zone=a,zone=b"} {
+		_, err = ParsePlacementHints(value)
+		assert.NotNil(t, err, value)
+	}
+
+	url := &URL{Parameters: map[string]string{PlacementPrefix + PlacementRack: "r12", "group": "test"}}
+	assert.Equal(t, map[string]string{PlacementRack: "r12"}, GetPlacementHints(url))
+	assert.Nil(t, GetPlacementHints(&URL{}))
+}
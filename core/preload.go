@@ -0,0 +1,26 @@
+package core
+
+import (
+	"encoding/json"
+)
+
+const (
+	// PreloadHintsRequestAttachment is the request attachment of the cooperative clients asking for preload hints, e.g. after connecting
+	PreloadHintsRequestAttachment = "M_plr"
+	// PreloadHintsAttachment is the response attachment of the keys or resources the client should preload to warm its caches,
+	// the value is a json array of strings
+	PreloadHintsAttachment = "M_plh"
+)
+
+// GetPreloadHints returns the preload hints of the response, nil if the server returns no hints
+func GetPreloadHints(response Response) []string {
+	value := response.GetAttachment(PreloadHintsAttachment)
+	if value == "" {
+		return nil
+	}
+	var hints []string
+	if err := json.Unmarshal([]byte(value), &hints); err != nil {
+		return nil
+	}
+	return hints
+}
@@ -0,0 +1,32 @@
+package core
+
+import (
+	"errors"
+)
+
+// ProgressEvent is the progress of a long running call, it is delivered to the client before the final response
+type ProgressEvent struct {
+	Percent int // 0 ~ 100
+	Message string
+}
+
+// ProgressListener receives progress events of a call on the client side.
+// it is called in the receiving goroutine of the connection, so it should be fast
+type ProgressListener func(event *ProgressEvent)
+
+var errProgressNotSupported = errors.New("progress is not supported by the request")
+
+// ReportProgress send a progress event of the request to the client, it can be called by providers during the call.
+// it returns an error if the client does not listen the progress or the final response has been sent
+func ReportProgress(request Request, percent int, message string) error {
+	ctx := request.GetRPCContext(false)
+	if ctx == nil || ctx.ProgressReporter == nil {
+		return errProgressNotSupported
+	}
+	if percent < 0 {
+		percent = 0
+	} else if percent > 100 {
+		percent = 100
+	}
+	return ctx.ProgressReporter(&ProgressEvent{Percent: percent, Message: message})
+}
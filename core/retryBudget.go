@@ -0,0 +1,56 @@
+package core
+
+import (
+	"strconv"
+	"time"
+)
+
+// retry policy advertised by servers, cooperative clients(e.g. FailOverHA) retry no more than the budget.
+// the policy is advertised by the service url in registry, and by the response attachments which can be lowered dynamically
+const (
+	RetryBudgetKey  = "retryBudget"  // service url parameter of max retries
+	RetryBackoffKey = "retryBackoff" // service url parameter of backoff between retries in ms
+
+	RetryBudgetAttachment  = "M_rb"
+	RetryBackoffAttachment = "M_rbo"
+	// RetryAfterAttachment is the delay in ms advertised by overloaded(503) responses, clients should not retry before it
+	RetryAfterAttachment = "M_ra"
+)
+
+// GetRetryAfter returns the delay advertised by the overloaded response, 0 if the response does not carry it
+func GetRetryAfter(response Response) time.Duration {
+	if response == nil {
+		return 0
+	}
+	if v, err := strconv.ParseInt(response.GetAttachment(RetryAfterAttachment), 10, 64); err == nil && v > 0 {
+		return time.Duration(v) * time.Millisecond
+	}
+	return 0
+}
+
+// GetAdvertisedRetryPolicy returns the retry policy advertised by the response, or by the service url if the response does not carry it.
+// ok is false if neither of them advertises a policy
+func GetAdvertisedRetryPolicy(serviceURL *URL, response Response) (budget int64, backoff time.Duration, ok bool) {
+	budget = -1
+	if response != nil {
+		if v, err := strconv.ParseInt(response.GetAttachment(RetryBudgetAttachment), 10, 64); err == nil && v >= 0 {
+			budget = v
+			ok = true
+		}
+		if v, err := strconv.ParseInt(response.GetAttachment(RetryBackoffAttachment), 10, 64); err == nil && v > 0 {
+			backoff = time.Duration(v) * time.Millisecond
+		}
+	}
+	if serviceURL != nil {
+		if !ok {
+			if v, exist := serviceURL.GetInt(RetryBudgetKey); exist && v >= 0 {
+				budget = v
+				ok = true
+			}
+		}
+		if backoff == 0 {
+			backoff = time.Duration(serviceURL.GetPositiveIntValue(RetryBackoffKey, 0)) * time.Millisecond
+		}
+	}
+	return budget, backoff, ok
+}
@@ -0,0 +1,54 @@
+package core
+
+import (
+	"errors"
+)
+
+// ErrStreamClosed is returned by Send after the stream is finished
+var ErrStreamClosed = errors.New("stream is closed")
+
+// Stream is the bidirectional stream of a streaming call, the messages of a stream are sent and received incrementally
+// as the frames of the request id. Recv returns io.EOF after the peer has finished sending. on the client the stream
+// is finished by the final response of the server, the exception of the response is returned by Recv as an error.
+// Send and Recv can be called concurrently with each other, but not with themselves
+type Stream interface {
+	Send(value interface{}) error
+	// Recv deserializes the next message into v like Serialization.DeSerialize
+	Recv(v interface{}) (interface{}, error)
+	// CloseSend tells the peer no more message will be sent
+	CloseSend() error
+}
+
+// StreamProvider is the provider serves streaming calls. the stream is finished when CallStream returns, the error is
+// sent to the client as the exception of the final response
+type StreamProvider interface {
+	Provider
+	CallStream(request Request, stream Stream) error
+}
+
+// StreamEndpoint is the endpoint supports streaming calls, the request is sent to open the stream
+type StreamEndpoint interface {
+	EndPoint
+	OpenStream(request Request) (Stream, error)
+}
+
+// GetStream returns the stream of the request on the server, it is nil if the request does not open a stream
+func GetStream(request Request) Stream {
+	if rc := request.GetRPCContext(false); rc != nil {
+		return rc.Stream
+	}
+	return nil
+}
+
+// ServeStream calls the StreamProvider with the stream of the request and builds the final response, the providers
+// can call it in Call for the streaming requests
+func ServeStream(request Request, provider StreamProvider) Response {
+	stream := GetStream(request)
+	if stream == nil {
+		return BuildExceptionResponse(request.GetRequestID(), &Exception{ErrCode: 400, ErrMsg: "request does not open a stream", ErrType: ServiceException})
+	}
+	if err := provider.CallStream(request, stream); err != nil {
+		return BuildExceptionResponse(request.GetRequestID(), &Exception{ErrCode: 500, ErrMsg: err.Error(), ErrType: BizException})
+	}
+	return &MotanResponse{RequestID: request.GetRequestID()}
+}
@@ -0,0 +1,272 @@
+package core
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/weibocom/motan-go/log"
+)
+
+// tls options of the server url parameters
+const (
+	SSLEnableKey  = "sslEnable"  // serve tls connections if it is true
+	CertFileKey   = "certFile"   // pem file of the server certificate
+	KeyFileKey    = "keyFile"    // pem file of the server private key
+	CAFileKey     = "caFile"     // pem file of the certificates to verify the clients
+	ClientAuthKey = "clientAuth" // the client certificate policy, one of the TLSClientAuth modes, default none
+)
+
+// tls options of the endpoint url parameters, the server url parameters advertised to the clients are not used by the endpoints
+const (
+	ClientSSLEnableKey = "clientSslEnable" // dial tls connections if it is true
+	ClientCertFileKey  = "clientCertFile"  // pem file of the client certificate for mutual tls, optional
+	ClientKeyFileKey   = "clientKeyFile"   // pem file of the client private key for mutual tls, optional
+	ServerCAFileKey    = "serverCaFile"    // pem file of the certificates to verify the servers, the system roots if absent
+	TLSServerNameKey   = "tlsServerName"   // the server name to verify, the host of the url if absent
+	InsecureSkipTLSKey = "insecureSkipTls" // skip the verification of the server certificate if it is true, only for tests
+)
+
+// the TLSClientAuth modes of ClientAuthKey
+const (
+	TLSClientAuthNone          = "none"          // no client certificate is requested
+	TLSClientAuthRequest       = "request"       // the client certificate is requested but not required or verified
+	TLSClientAuthRequire       = "require"       // the client certificate is required but not verified
+	TLSClientAuthVerifyIfGiven = "verifyIfGiven" // the client certificate is verified if it is sent
+	TLSClientAuthVerify        = "verify"        // the client certificate is required and verified, it is mutual tls
+)
+
+// the certificate files are checked again at most once in tlsReloadInterval when handshaking, so the renewed certificates
+// are used by the new connections without restart
+var tlsReloadInterval = 10 * time.Second
+
+var tlsClientAuths = map[string]tls.ClientAuthType{
+	TLSClientAuthNone:          tls.NoClientCert,
+	TLSClientAuthRequest:       tls.RequestClientCert,
+	TLSClientAuthRequire:       tls.RequireAnyClientCert,
+	TLSClientAuthVerifyIfGiven: tls.VerifyClientCertIfGiven,
+	TLSClientAuthVerify:        tls.RequireAndVerifyClientCert,
+}
+
+// ParseServerTLSConfig returns the tls config of the server url, it returns nil if tls is not enabled
+func ParseServerTLSConfig(url *URL) (*tls.Config, error) {
+	enable, err := parseTLSEnable(url, SSLEnableKey)
+	if err != nil || !enable {
+		return nil, err
+	}
+	certFile, keyFile := url.GetParam(CertFileKey, ""), url.GetParam(KeyFileKey, "")
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New(CertFileKey + " and " + KeyFileKey + " are required if " + SSLEnableKey + " is true")
+	}
+	reloader, err := newKeyPairReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return reloader.certificate(), nil
+	}}
+	mode := url.GetParam(ClientAuthKey, TLSClientAuthNone)
+	clientAuth, ok := tlsClientAuths[mode]
+	if !ok {
+		return nil, fmt.Errorf("illegal %s: %s", ClientAuthKey, mode)
+	}
+	config.ClientAuth = clientAuth
+	if caFile := url.GetParam(CAFileKey, ""); caFile != "" {
+		cas, err := newCertPoolReloader(CAFileKey, caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = cas.certPool()
+		// the clients are verified by the CA certificates reloaded when handshaking
+		base := config.Clone()
+		config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			c := base.Clone()
+			c.ClientCAs = cas.certPool()
+			return c, nil
+		}
+	} else if clientAuth == tls.RequireAndVerifyClientCert || clientAuth == tls.VerifyClientCertIfGiven {
+		return nil, errors.New(CAFileKey + " is required if " + ClientAuthKey + " is " + mode)
+	}
+	return config, nil
+}
+
+// ParseClientTLSConfig returns the tls config of the endpoint url, it returns nil if tls is not enabled
+func ParseClientTLSConfig(url *URL) (*tls.Config, error) {
+	enable, err := parseTLSEnable(url, ClientSSLEnableKey)
+	if err != nil || !enable {
+		return nil, err
+	}
+	config := &tls.Config{ServerName: url.GetParam(TLSServerNameKey, url.Host)}
+	if value := url.GetParam(InsecureSkipTLSKey, ""); value != "" {
+		if config.InsecureSkipVerify, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("illegal %s: %s", InsecureSkipTLSKey, value)
+		}
+	}
+	if caFile := url.GetParam(ServerCAFileKey, ""); caFile != "" && !config.InsecureSkipVerify {
+		roots, err := newCertPoolReloader(ServerCAFileKey, caFile)
+		if err != nil {
+			return nil, err
+		}
+		// the servers are verified by the CA certificates reloaded when handshaking instead of the fixed RootCAs, the
+		// default verification is skipped for it
+		config.InsecureSkipVerify = true
+		serverName := config.ServerName
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyServerCertificate(rawCerts, roots.certPool(), serverName)
+		}
+	}
+	certFile, keyFile := url.GetParam(ClientCertFileKey, ""), url.GetParam(ClientKeyFileKey, "")
+	if certFile == "" && keyFile == "" {
+		return config, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New(ClientCertFileKey + " and " + ClientKeyFileKey + " should be set together")
+	}
+	reloader, err := newKeyPairReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		return reloader.certificate(), nil
+	}
+	return config, nil
+}
+
+func parseTLSEnable(url *URL, key string) (bool, error) {
+	value := url.GetParam(key, "")
+	if value == "" {
+		return false, nil
+	}
+	enable, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("illegal %s: %s", key, value)
+	}
+	return enable, nil
+}
+
+func loadCertPool(key string, file string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read %s fail. file: %s, err: %v", key, file, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("illegal %s: %s, no certificate found", key, file)
+	}
+	return pool, nil
+}
+
+func newKeyPairReloader(certFile string, keyFile string) (*tlsFileReloader, error) {
+	r, err := newTLSFileReloader(func() (interface{}, error) {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		return &cert, err
+	}, certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load certificate fail. %s: %s, %s: %s, err: %v", CertFileKey, certFile, KeyFileKey, keyFile, err)
+	}
+	return r, nil
+}
+
+func newCertPoolReloader(key string, file string) (*tlsFileReloader, error) {
+	return newTLSFileReloader(func() (interface{}, error) {
+		return loadCertPool(key, file)
+	}, file)
+}
+
+// tlsFileReloader loads the tls files again when any of them is modified, e.g. the certificate and the key, or the CA
+// certificates. the last loaded value is kept if the modified files can not be loaded, e.g. only one of them is written
+type tlsFileReloader struct {
+	files     []string
+	loadFunc  func() (interface{}, error)
+	lock      sync.Mutex
+	value     interface{}
+	modTime   time.Time
+	checkTime time.Time
+}
+
+func newTLSFileReloader(load func() (interface{}, error), files ...string) (*tlsFileReloader, error) {
+	r := &tlsFileReloader{files: files, loadFunc: load, checkTime: time.Now()}
+	modTime, err := r.lastModified()
+	if err == nil {
+		err = r.load(modTime)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *tlsFileReloader) lastModified() (time.Time, error) {
+	var modTime time.Time
+	for _, file := range r.files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return modTime, err
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	return modTime, nil
+}
+
+func (r *tlsFileReloader) load(modTime time.Time) error {
+	value, err := r.loadFunc()
+	if err != nil {
+		return err
+	}
+	r.value, r.modTime = value, modTime
+	return nil
+}
+
+func (r *tlsFileReloader) get() interface{} {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if now := time.Now(); now.Sub(r.checkTime) >= tlsReloadInterval {
+		r.checkTime = now
+		if modTime, err := r.lastModified(); err == nil && !modTime.Equal(r.modTime) {
+			if err = r.load(modTime); err != nil {
+				vlog.Warningf("reload tls files fail, the last loaded are used. files: %v, err: %v", r.files, err)
+			} else {
+				vlog.Infof("tls files reloaded. files: %v", r.files)
+			}
+		}
+	}
+	return r.value
+}
+
+func (r *tlsFileReloader) certificate() *tls.Certificate {
+	return r.get().(*tls.Certificate)
+}
+
+func (r *tlsFileReloader) certPool() *x509.CertPool {
+	return r.get().(*x509.CertPool)
+}
+
+// verifyServerCertificate verifies the certificate chain of the server by the roots and the server name like the
+// default verification of tls, it is used to verify by the reloaded roots
+func verifyServerCertificate(rawCerts [][]byte, roots *x509.CertPool, serverName string) error {
+	if len(rawCerts) == 0 {
+		return errors.New("tls: no certificate of the server")
+	}
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return fmt.Errorf("tls: parse certificate of the server fail. err: %v", err)
+		}
+		certs[i] = cert
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, DNSName: serverName})
+	return err
+}
@@ -0,0 +1,150 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestKeyPair(t *testing.T, dir string, name string) (certFile string, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	assert.Nil(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.Nil(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certFile, keyFile
+}
+
+func commonName(t *testing.T, cert *tls.Certificate) string {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	assert.Nil(t, err)
+	return leaf.Subject.CommonName
+}
+
+func TestTLSConfigReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	defer func(interval time.Duration) { tlsReloadInterval = interval }(tlsReloadInterval)
+	tlsReloadInterval = 0
+	certFile, keyFile := writeTestKeyPair(t, dir, "first")
+	config, err := ParseServerTLSConfig(&URL{Parameters: map[string]string{SSLEnableKey: "true", CertFileKey: certFile, KeyFileKey: keyFile}})
+	assert.Nil(t, err)
+	assert.Equal(t, tls.NoClientCert, config.ClientAuth)
+	cert, err := config.GetCertificate(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "first", commonName(t, cert))
+
+	// the modified files are loaded again
+	writeTestKeyPair(t, dir, "second")
+	later := time.Now().Add(time.Minute)
+	assert.Nil(t, os.Chtimes(certFile, later, later))
+	cert, _ = config.GetCertificate(nil)
+	assert.Equal(t, "second", commonName(t, cert))
+	// the last certificate is kept if the files are broken
+	assert.Nil(t, ioutil.WriteFile(keyFile, []byte("broken"), 0600))
+	later = later.Add(time.Minute)
+	assert.Nil(t, os.Chtimes(keyFile, later, later))
+	cert, _ = config.GetCertificate(nil)
+	assert.Equal(t, "second", commonName(t, cert))
+
+	// the modified CA files are loaded again by the servers and the clients
+	caDir := filepath.Join(dir, "ca")
+	assert.Nil(t, os.Mkdir(caDir, 0700))
+	caFile, caKeyFile := writeTestKeyPair(t, caDir, "first")
+	first, err := tls.LoadX509KeyPair(caFile, caKeyFile)
+	assert.Nil(t, err)
+	config, err = ParseServerTLSConfig(&URL{Parameters: map[string]string{SSLEnableKey: "true", CertFileKey: caFile, KeyFileKey: caKeyFile,
+		CAFileKey: caFile, ClientAuthKey: TLSClientAuthVerify}})
+	assert.Nil(t, err)
+	clientConfig, err := ParseClientTLSConfig(&URL{Host: "127.0.0.1", Parameters: map[string]string{ClientSSLEnableKey: "true", ServerCAFileKey: caFile}})
+	assert.Nil(t, err)
+	assert.Nil(t, clientConfig.VerifyPeerCertificate(first.Certificate, nil))
+	writeTestKeyPair(t, caDir, "second")
+	second, err := tls.LoadX509KeyPair(caFile, caKeyFile)
+	assert.Nil(t, err)
+	later = later.Add(time.Minute)
+	assert.Nil(t, os.Chtimes(caFile, later, later))
+	assert.NotNil(t, clientConfig.VerifyPeerCertificate(first.Certificate, nil))
+	assert.Nil(t, clientConfig.VerifyPeerCertificate(second.Certificate, nil))
+	serverConfig, err := config.GetConfigForClient(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, serverConfig.ClientAuth)
+	assert.NotNil(t, verifyServerCertificate(first.Certificate, serverConfig.ClientCAs, "127.0.0.1"))
+	assert.Nil(t, verifyServerCertificate(second.Certificate, serverConfig.ClientCAs, "127.0.0.1"))
+}
+
+func TestParseTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeTestKeyPair(t, dir, "test")
+
+	config, err := ParseServerTLSConfig(&URL{Parameters: map[string]string{SSLEnableKey: "false", CertFileKey: "none"}})
+	assert.Nil(t, err)
+	assert.Nil(t, config)
+	config, err = ParseServerTLSConfig(&URL{Parameters: map[string]string{SSLEnableKey: "true", CertFileKey: certFile, KeyFileKey: keyFile,
+		CAFileKey: certFile, ClientAuthKey: TLSClientAuthVerify}})
+	assert.Nil(t, err)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, config.ClientAuth)
+	assert.NotNil(t, config.ClientCAs)
+	for _, params := range []map[string]string{
+		{SSLEnableKey: "true", CertFileKey: certFile, KeyFileKey: keyFile, ClientAuthKey: "always"},
+		{SSLEnableKey: "true", CertFileKey: certFile, KeyFileKey: keyFile, ClientAuthKey: TLSClientAuthVerifyIfGiven},
+	} {
+		_, err = ParseServerTLSConfig(&URL{Parameters: params})
+		assert.NotNil(t, err, params)
+	}
+
+	config, err = ParseClientTLSConfig(&URL{Host: "127.0.0.1", Parameters: map[string]string{SSLEnableKey: "true"}})
+	assert.Nil(t, err)
+	assert.Nil(t, config, "the server parameters are not used by the clients")
+	config, err = ParseClientTLSConfig(&URL{Host: "127.0.0.1", Parameters: map[string]string{ClientSSLEnableKey: "true",
+		ServerCAFileKey: certFile, ClientCertFileKey: certFile, ClientKeyFileKey: keyFile}})
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1", config.ServerName)
+	assert.True(t, config.InsecureSkipVerify, "the servers are verified by VerifyPeerCertificate")
+	cert, err := config.GetClientCertificate(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, config.VerifyPeerCertificate(cert.Certificate, nil))
+	config.ServerName = "other"
+	assert.Nil(t, config.VerifyPeerCertificate(cert.Certificate, nil), "the server name is fixed when parsing")
+	assert.Equal(t, "test", commonName(t, cert))
+	for _, params := range []map[string]string{
+		{ClientSSLEnableKey: "yes"},
+		{ClientSSLEnableKey: "true", InsecureSkipTLSKey: "maybe"},
+		{ClientSSLEnableKey: "true", ClientCertFileKey: certFile},
+		{ClientSSLEnableKey: "true", ServerCAFileKey: keyFile},
+	} {
+		_, err = ParseClientTLSConfig(&URL{Parameters: params})
+		assert.NotNil(t, err, params)
+	}
+}
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+// TrailerAttachmentPrefix is the prefix of the response attachments of trailers
+const TrailerAttachmentPrefix = "M_tr_"
+
+var (
+	errTrailerNotSupported = errors.New("trailer is not supported by the request")
+	errTrailerSealed       = errors.New("trailer set after the final response")
+)
+
+// Trailers is the final attachments of a response that are only known after the body is produced, e.g. total count or
+// next cursor. they are sent with the final response after the body is serialized, so the values set by the lazy values
+// during the serialization are also sent
+type Trailers struct {
+	lock   sync.Mutex
+	values map[string]string
+	sealed bool
+}
+
+func (t *Trailers) set(key string, value string) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.sealed {
+		return errTrailerSealed
+	}
+	if t.values == nil {
+		t.values = make(map[string]string)
+	}
+	t.values[key] = value
+	return nil
+}
+
+// Seal returns the trailers and rejects the trailers set later, it is called by the server before sending the final response
+func (t *Trailers) Seal() map[string]string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.sealed = true
+	return t.values
+}
+
+// SetTrailer sets a trailer of the response of the request, it can be called by providers during the call or the serialization
+// of the response value. it returns an error if the server does not support trailers or the final response has been sent
+func SetTrailer(request Request, key string, value string) error {
+	ctx := request.GetRPCContext(false)
+	if ctx == nil || ctx.Trailers == nil {
+		return errTrailerNotSupported
+	}
+	return ctx.Trailers.set(key, value)
+}
+
+// GetTrailer returns the trailer of the response on the client side
+func GetTrailer(response Response, key string) string {
+	return response.GetAttachment(TrailerAttachmentPrefix + key)
+}
+
+// GetTrailers returns all trailers of the response on the client side
+func GetTrailers(response Response) map[string]string {
+	trailers := make(map[string]string)
+	if attachments := response.GetAttachments(); attachments != nil {
+		attachments.Range(func(k, v string) bool {
+			if strings.HasPrefix(k, TrailerAttachmentPrefix) {
+				trailers[k[len(TrailerAttachmentPrefix):]] = v
+			}
+			return true
+		})
+	}
+	return trailers
+}
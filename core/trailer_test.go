@@ -0,0 +1,29 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrailers(t *testing.T) {
+	request := &MotanRequest{RequestID: 1, ServiceName: "trailerService", Method: "list"}
+	assert.Equal(t, errTrailerNotSupported, SetTrailer(request, "total", "10"))
+
+	request.GetRPCContext(true).Trailers = &Trailers{}
+	assert.Nil(t, SetTrailer(request, "total", "10"))
+	assert.Nil(t, SetTrailer(request, "cursor", "abc"))
+	trailers := request.GetRPCContext(false).Trailers.Seal()
+	assert.Equal(t, map[string]string{"total": "10", "cursor": "abc"}, trailers)
+	assert.Equal(t, errTrailerSealed, SetTrailer(request, "total", "11"))
+
+	response := &MotanResponse{RequestID: 1}
+	response.SetAttachment("other", "1")
+	for k, v := range trailers {
+		response.SetAttachment(TrailerAttachmentPrefix+k, v)
+	}
+	assert.Equal(t, "10", GetTrailer(response, "total"))
+	assert.Equal(t, "", GetTrailer(response, "other"))
+	assert.Equal(t, trailers, GetTrailers(response))
+	assert.Equal(t, map[string]string{}, GetTrailers(&MotanResponse{}))
+}
@@ -0,0 +1,58 @@
+package core
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// TransportKey is the url parameter of the transport of the motan2 servers and endpoints, default TCPTransport
+const TransportKey = "transport"
+
+const TCPTransport = "tcp"
+
+// Transport is the network of the motan2 connections. the motan2 messages are framed by themselves, so any transport
+// provides reliable and ordered byte streams can be used. the url is passed for the options of the transport, the tls
+// of the url is applied on top of the connections
+type Transport interface {
+	Listen(address string, url *URL) (net.Listener, error)
+	Dial(address string, url *URL, timeout time.Duration) (net.Conn, error)
+}
+
+var transports = NewCopyOnWriteMap()
+
+func init() {
+	RegistTransport(TCPTransport, tcpTransport{})
+}
+
+// RegistTransport registers a transport by name, only TCPTransport is built in. there is no quic transport because
+// this module does not depend on a quic library, it can be registered by an application which does
+func RegistTransport(name string, transport Transport) {
+	transports.Store(name, transport)
+}
+
+func GetTransport(name string) Transport {
+	if t, ok := transports.Load(name); ok {
+		return t.(Transport)
+	}
+	return nil
+}
+
+// GetURLTransport returns the transport configured by the url, an error if it is not registered
+func GetURLTransport(url *URL) (Transport, error) {
+	name := url.GetParam(TransportKey, TCPTransport)
+	if t := GetTransport(name); t != nil {
+		return t, nil
+	}
+	return nil, errors.New("unsupported " + TransportKey + ": " + name + ", the transport is not registered")
+}
+
+type tcpTransport struct{}
+
+func (tcpTransport) Listen(address string, url *URL) (net.Listener, error) {
+	return Listen("tcp", address)
+}
+
+func (tcpTransport) Dial(address string, url *URL, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("tcp", address, timeout)
+}
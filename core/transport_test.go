@@ -0,0 +1,19 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetURLTransport(t *testing.T) {
+	url := &URL{Protocol: "motan2", Host: "127.0.0.1", Port: 8002, Parameters: map[string]string{}}
+	transport, err := GetURLTransport(url)
+	assert.Nil(t, err)
+	assert.Equal(t, GetTransport(TCPTransport), transport)
+
+	url.PutParam(TransportKey, "unregistered")
+	_, err = GetURLTransport(url)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "unregistered")
+}
@@ -0,0 +1,215 @@
+package core
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/weibocom/motan-go/log"
+)
+
+// the environments of the process started by Upgrade, the listeners are passed as the extra files from fd 3 in the
+// order of UpgradeListenersEnv, and the new process writes to the fd of UpgradeReadyFDEnv when it is ready
+const (
+	UpgradeListenersEnv = "MOTAN_UPGRADE_LISTENERS"
+	UpgradeReadyFDEnv   = "MOTAN_UPGRADE_READY_FD"
+)
+
+// HotUpgradeKey is the agent parameter to disable the hot upgrade on SIGUSR2, default true
+const HotUpgradeKey = "hotUpgrade"
+
+// HotUpgradeTimeoutKey is the max duration(ms) waiting for the new process is ready, the new process is killed and
+// the old process keeps serving after the timeout
+const HotUpgradeTimeoutKey = "hotUpgradeTimeout"
+
+const DefaultHotUpgradeTimeout = 30 * time.Second
+
+var upgrader = newListenerUpgrader()
+
+// listenerUpgrader keeps the listeners opened by Listen, so they can be handed over to the new process by Upgrade
+type listenerUpgrader struct {
+	lock      sync.Mutex
+	inherited map[string]net.Listener // the listeners inherited from the parent process and not listened yet
+	listeners map[string]net.Listener // the listeners opened by Listen, network|address -> listener
+	readyFile *os.File                // the ready pipe to the parent process, nil if it is notified
+	upgrading bool
+}
+
+func newListenerUpgrader() *listenerUpgrader {
+	u := &listenerUpgrader{inherited: make(map[string]net.Listener), listeners: make(map[string]net.Listener)}
+	keys := os.Getenv(UpgradeListenersEnv)
+	if keys != "" {
+		for i, key := range strings.Split(keys, ",") {
+			f := os.NewFile(uintptr(3+i), key)
+			l, err := net.FileListener(f)
+			f.Close()
+			if err != nil {
+				vlog.Errorf("inherit listener %s from the parent process fail. err:%v", key, err)
+				continue
+			}
+			u.inherited[key] = l
+		}
+	}
+	if fd, err := strconv.Atoi(os.Getenv(UpgradeReadyFDEnv)); err == nil {
+		u.readyFile = os.NewFile(uintptr(fd), "upgrade-ready")
+	}
+	// the environments are not passed to the other child processes
+	os.Unsetenv(UpgradeListenersEnv)
+	os.Unsetenv(UpgradeReadyFDEnv)
+	return u
+}
+
+func listenerKey(network string, address string) string {
+	return network + "|" + address
+}
+
+// Listen announces on the local network address like net.Listen, the listener of the same network and address
+// inherited from the parent process is used if the process is started by Upgrade. the parent process is not notified
+// until UpgradeReady is called, so it keeps serving while the process is initializing
+func Listen(network string, address string) (net.Listener, error) {
+	upgrader.lock.Lock()
+	defer upgrader.lock.Unlock()
+	key := listenerKey(network, address)
+	l, ok := upgrader.inherited[key]
+	if ok {
+		delete(upgrader.inherited, key)
+		vlog.Infof("use the listener inherited from the parent process. network:%s, address:%s", network, address)
+	} else {
+		var err error
+		if l, err = net.Listen(network, address); err != nil {
+			return nil, err
+		}
+	}
+	upgrader.listeners[key] = l
+	return l, nil
+}
+
+func isInherited(network string, address string) bool {
+	upgrader.lock.Lock()
+	defer upgrader.lock.Unlock()
+	_, ok := upgrader.inherited[listenerKey(network, address)]
+	return ok
+}
+
+// UpgradeReady notifies the parent process that the process is ready, the parent process starts draining after that.
+// the inherited listeners not listened by the process are closed. it does nothing if the process is not started by
+// Upgrade or has notified the parent process
+func UpgradeReady() {
+	upgrader.lock.Lock()
+	defer upgrader.lock.Unlock()
+	for key, l := range upgrader.inherited {
+		vlog.Warningf("close the inherited listener not used. listener:%s", key)
+		l.Close()
+		delete(upgrader.inherited, key)
+	}
+	upgrader.notifyReady()
+}
+
+func (u *listenerUpgrader) notifyReady() {
+	if u.readyFile == nil {
+		return
+	}
+	if _, err := u.readyFile.Write([]byte{1}); err != nil {
+		vlog.Errorf("notify the parent process of the upgrade fail. err:%v", err)
+	}
+	u.readyFile.Close()
+	u.readyFile = nil
+	vlog.Infof("the parent process of the upgrade is notified")
+}
+
+// Upgrade starts the new process by the executable of the current process with the same arguments, and hands over
+// the listeners opened by Listen to it. it returns nil after the new process is ready, then the current process
+// should drain the connections and exit. the new process is killed if it is not ready in the timeout
+func Upgrade(timeout time.Duration) error {
+	upgrader.lock.Lock()
+	if upgrader.upgrading {
+		upgrader.lock.Unlock()
+		return errors.New("the upgrade is in progress")
+	}
+	upgrader.upgrading = true
+	keys := make([]string, 0, len(upgrader.listeners))
+	files := make([]*os.File, 0, len(upgrader.listeners))
+	for key, l := range upgrader.listeners {
+		fl, ok := l.(interface {
+			File() (*os.File, error)
+		})
+		if !ok {
+			continue
+		}
+		f, err := fl.File()
+		if err != nil {
+			// the listener is closed
+			delete(upgrader.listeners, key)
+			continue
+		}
+		keys = append(keys, key)
+		files = append(files, f)
+	}
+	upgrader.lock.Unlock()
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+		upgrader.lock.Lock()
+		upgrader.upgrading = false
+		upgrader.lock.Unlock()
+	}()
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyReader.Close()
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), UpgradeListenersEnv+"="+strings.Join(keys, ","), UpgradeReadyFDEnv+"="+strconv.Itoa(3+len(files)))
+	cmd.ExtraFiles = append(files, readyWriter)
+	err = cmd.Start()
+	readyWriter.Close()
+	if err != nil {
+		return err
+	}
+	vlog.Infof("the new process of the upgrade is started. pid:%d, executable:%s, listeners:%v", cmd.Process.Pid, executable, keys)
+
+	// the read fails if the new process exits before it is ready
+	ready := make(chan error, 1)
+	go func() {
+		b := make([]byte, 1)
+		_, err := readyReader.Read(b)
+		ready <- err
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err = <-ready:
+	case <-timer.C:
+		err = errors.New("the new process is not ready in " + timeout.String())
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return errors.New("upgrade fail, the new process is not ready: " + err.Error())
+	}
+	go cmd.Wait()
+	// the socket files are used by the new process
+	upgrader.lock.Lock()
+	for _, l := range upgrader.listeners {
+		if ul, ok := l.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	upgrader.lock.Unlock()
+	vlog.Infof("the new process of the upgrade is ready. pid:%d", cmd.Process.Pid)
+	return nil
+}
@@ -0,0 +1,103 @@
+package core
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const upgradeTestChildEnv = "MOTAN_TEST_UPGRADE_CHILD"
+
+// the test binary is executed again as the new process by Upgrade, it does not run the tests
+func init() {
+	switch os.Getenv(upgradeTestChildEnv) {
+	case "serve":
+		upgradeTestChild()
+	case "exit":
+		os.Exit(1)
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	defer os.Unsetenv(upgradeTestChildEnv)
+
+	listener, err := Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	address := listener.Addr().String()
+
+	// the upgrade fails if the new process exits before it is ready
+	os.Setenv(upgradeTestChildEnv, "exit")
+	assert.NotNil(t, Upgrade(10*time.Second))
+
+	os.Setenv(upgradeTestChildEnv, "serve")
+	assert.Nil(t, Upgrade(10*time.Second))
+	// the new connections are accepted by the new process after the listener of the old process is closed
+	listener.Close()
+	conn, err := net.DialTimeout("tcp", address, time.Second)
+	if assert.Nil(t, err) {
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		b, err := ioutil.ReadAll(conn)
+		assert.Nil(t, err)
+		assert.Equal(t, "new process", string(b))
+	}
+}
+
+func upgradeTestChild() {
+	defer os.Exit(0)
+	listener, err := Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return
+	}
+	UpgradeReady()
+	listener.(*net.TCPListener).SetDeadline(time.Now().Add(10 * time.Second))
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	conn.Write([]byte("new process"))
+	conn.Close()
+}
+
+func TestUpgradeReady(t *testing.T) {
+	r, w, err := os.Pipe()
+	assert.Nil(t, err)
+	defer r.Close()
+	inherited, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	unused, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	address := inherited.Addr().String()
+	upgrader.lock.Lock()
+	upgrader.inherited[listenerKey("tcp", address)] = inherited
+	upgrader.inherited[listenerKey("tcp", unused.Addr().String())] = unused
+	upgrader.readyFile = w
+	upgrader.lock.Unlock()
+
+	// the parent process is not notified by listening the inherited listeners
+	l, err := Listen("tcp", address)
+	assert.Nil(t, err)
+	defer func() {
+		upgrader.lock.Lock()
+		delete(upgrader.listeners, listenerKey("tcp", address))
+		upgrader.lock.Unlock()
+		l.Close()
+	}()
+	assert.Equal(t, inherited, l)
+	r.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err = r.Read(make([]byte, 1))
+	assert.NotNil(t, err)
+
+	UpgradeReady()
+	r.SetReadDeadline(time.Now().Add(time.Second))
+	b, err := ioutil.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, b)
+	assert.False(t, isInherited("tcp", unused.Addr().String()))
+	_, err = unused.Accept()
+	assert.NotNil(t, err, "the inherited listeners not used are closed")
+}
@@ -2,6 +2,7 @@ package core
 
 import (
 	"bytes"
+	"fmt"
 	"sort"
 	"strconv"
 	"strings"
@@ -27,6 +28,10 @@ var (
 	defaultSerialize = "simple"
 )
 
+// UnixAddressPrefix is the prefix of the unix socket addresses, e.g. unix:///var/run/motan-agent.sock. the url connects
+// to the unix socket if its host is a unix socket address, and the port is not used
+const UnixAddressPrefix = "unix://"
+
 //TODO int param cache
 
 // GetIdentity return the identity of url. identity info includes protocol, host, port, path, group
@@ -73,6 +78,16 @@ func (u *URL) GetInt(key string) (int64, bool) {
 	return 0, false
 }
 
+// GetFloatValue get float param, the default value will be returned if the param is not a float
+func (u *URL) GetFloatValue(key string, defaultValue float64) float64 {
+	if v, ok := u.Parameters[key]; ok {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	}
+	return defaultValue
+}
+
 func (u *URL) GetStringParamsWithDefault(key string, defaultvalue string) string {
 	var ret string
 	if u.Parameters != nil {
@@ -200,10 +215,22 @@ func (u *URL) GetAddressStr() string {
 	if u.address != "" {
 		return u.address
 	}
-	u.address = u.Host + ":" + u.GetPortStr()
+	if _, ok := u.GetUnixSock(); ok {
+		u.address = u.Host
+	} else {
+		u.address = u.Host + ":" + u.GetPortStr()
+	}
 	return u.address
 }
 
+// GetUnixSock returns the path of the unix socket if the host of the url is a unix socket address
+func (u *URL) GetUnixSock() (string, bool) {
+	if strings.HasPrefix(u.Host, UnixAddressPrefix) {
+		return u.Host[len(UnixAddressPrefix):], true
+	}
+	return "", false
+}
+
 func (u *URL) Copy() *URL {
 	newURL := &URL{Protocol: u.Protocol, Host: u.Host, Port: u.Port, Group: u.Group, Path: u.Path}
 	newParams := make(map[string]string)
@@ -279,7 +306,7 @@ func GetURLFilters(url *URL, extFactory ExtensionFactory) (clusterFilter Cluster
 			}
 		}
 		if len(clusterFilters) > 0 {
-			sort.Sort(filterSlice(clusterFilters))
+			sortFilters(clusterFilters)
 			var lastFilter ClusterFilter
 			lastFilter = GetLastClusterFilter()
 
@@ -291,13 +318,48 @@ func GetURLFilters(url *URL, extFactory ExtensionFactory) (clusterFilter Cluster
 			clusterFilter = lastFilter
 		}
 		if len(endpointFilters) > 0 {
-			sort.Sort(filterSlice(endpointFilters))
+			sortFilters(endpointFilters)
 		}
 
 	}
 	return clusterFilter, endpointFilters
 }
 
+// CheckFilterConstraints checks the FilterConstraint of the filters sorted by GetURLFilters, the filters of the smaller index are called first
+func CheckFilterConstraints(filters []Filter) error {
+	positions := make(map[string]int, len(filters))
+	for i, f := range filters {
+		positions[f.GetName()] = i
+	}
+	for i, f := range filters {
+		c, ok := f.(FilterConstraint)
+		if !ok {
+			continue
+		}
+		for _, name := range c.ConflictFilters() {
+			if _, ok := positions[name]; ok {
+				return fmt.Errorf("filter %s conflicts with filter %s", f.GetName(), name)
+			}
+		}
+		for _, name := range c.AfterFilters() {
+			// the filters are sorted by index desc, the latter one wraps the former one
+			if j, ok := positions[name]; ok && j <= i {
+				return fmt.Errorf("filter %s(index %d) must be called after filter %s(index %d)", f.GetName(), f.GetIndex(), name, filters[j].GetIndex())
+			}
+		}
+	}
+	return nil
+}
+
+// sortFilters sorts the filters by index desc, the filters of the same index are called in the configured order
+func sortFilters(filters []Filter) {
+	// the latter one wraps the former one, so the filters of the same index are reversed before the stable sort
+	for i, j := 0, len(filters)-1; i < j; i, j = i+1, j-1 {
+		filters[i], filters[j] = filters[j], filters[i]
+	}
+	sort.Stable(filterSlice(filters))
+}
+
 type filterSlice []Filter
 
 func (f filterSlice) Len() int {
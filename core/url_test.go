@@ -156,3 +156,13 @@ func TestGetPositiveIntValue(t *testing.T) {
 		t.Errorf("get positive int fail. v:%d", v)
 	}
 }
+
+func TestGetFloatValue(t *testing.T) {
+	url := &URL{Parameters: map[string]string{"rate": "0.25", "bad": "x"}}
+	if v := url.GetFloatValue("rate", 1); v != 0.25 {
+		t.Errorf("get float fail. v:%v", v)
+	}
+	if v := url.GetFloatValue("bad", 1); v != 1 {
+		t.Errorf("get default float fail. v:%v", v)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"time"
 	"unicode"
 
 	"github.com/weibocom/motan-go/log"
@@ -20,6 +21,8 @@ const (
 
 var (
 	PanicStatFunc func()
+	// MethodPanicStatFunc stat the panics recovered by HandleRequestPanic
+	MethodPanicStatFunc func(service string, method string)
 
 	localIPs = make([]string, 0)
 )
@@ -124,16 +127,57 @@ func GetReqInfo(request Request) string {
 
 func HandlePanic(f func()) {
 	if err := recover(); err != nil {
-		vlog.Errorf("recover panic. error:%v, stack: %s", err, debug.Stack())
-		if f != nil {
-			f()
-		}
-		if PanicStatFunc != nil {
-			PanicStatFunc()
-		}
+		handlePanic(err, nil, withoutPanicInfo(f))
+	}
+}
+
+// HandleRequestPanic is the same as HandlePanic, but the panic is stat and notified with the service and method of the request.
+// it must be called by defer directly
+func HandleRequestPanic(request Request, f func()) {
+	if err := recover(); err != nil {
+		handlePanic(err, request, withoutPanicInfo(f))
 	}
 }
 
+// HandleRequestPanicInfo is the same as HandleRequestPanic, but f gets the recovered value and the stack of the panic.
+// it must be called by defer directly
+func HandleRequestPanicInfo(request Request, f func(info *PanicInfo)) {
+	if err := recover(); err != nil {
+		handlePanic(err, request, f)
+	}
+}
+
+func withoutPanicInfo(f func()) func(*PanicInfo) {
+	if f == nil {
+		return nil
+	}
+	return func(*PanicInfo) {
+		f()
+	}
+}
+
+func handlePanic(err interface{}, request Request, f func(info *PanicInfo)) {
+	stack := debug.Stack()
+	info := &PanicInfo{Err: err, Stack: stack, Time: time.Now()}
+	if request != nil {
+		info.Service = request.GetServiceName()
+		info.Method = request.GetMethod()
+		vlog.Errorf("recover panic. service:%s, method:%s, error:%v, stack: %s", info.Service, info.Method, err, stack)
+	} else {
+		vlog.Errorf("recover panic. error:%v, stack: %s", err, stack)
+	}
+	if f != nil {
+		f(info)
+	}
+	if PanicStatFunc != nil {
+		PanicStatFunc()
+	}
+	if request != nil && MethodPanicStatFunc != nil {
+		MethodPanicStatFunc(info.Service, info.Method)
+	}
+	notifyPanic(info)
+}
+
 // TrimSplit slices s into all substrings separated by sep and
 // returns a slice of the substrings between those separators,
 // specially trim all substrings.
@@ -161,12 +205,15 @@ func TrimSplit(s string, sep string) []string {
 // ListenUnixSock try to listen a unix socket address
 // this method using by create motan agent server, management server and http proxy server
 func ListenUnixSock(unixSockAddr string) (net.Listener, error) {
-	if err := os.RemoveAll(unixSockAddr); err != nil {
-		vlog.Errorf("listenUnixSock err, remove old unix sock file fail. err: %v", err)
-		return nil, err
+	// the socket file of the listener inherited from the parent process is in use
+	if !isInherited("unix", unixSockAddr) {
+		if err := os.RemoveAll(unixSockAddr); err != nil {
+			vlog.Errorf("listenUnixSock err, remove old unix sock file fail. err: %v", err)
+			return nil, err
+		}
 	}
 
-	listener, err := net.Listen("unix", unixSockAddr)
+	listener, err := Listen("unix", unixSockAddr)
 	if err != nil {
 		vlog.Errorf("listenUnixSock err, listen unix sock fail. err:%v", err)
 		return nil, err
@@ -9,6 +9,7 @@ import (
 	"github.com/weibocom/motan-go/filter"
 	"github.com/weibocom/motan-go/ha"
 	"github.com/weibocom/motan-go/lb"
+	"github.com/weibocom/motan-go/metrics"
 	"github.com/weibocom/motan-go/provider"
 	"github.com/weibocom/motan-go/registry"
 	"github.com/weibocom/motan-go/serialize"
@@ -45,6 +46,7 @@ func GetDefaultManageHandlers() map[string]http.Handler {
 		info := &InfoHandler{}
 		defaultManageHandlers["/getConfig"] = info
 		defaultManageHandlers["/getReferService"] = info
+		defaultManageHandlers["/getExportedService"] = info
 
 		debug := &DebugHandler{}
 		defaultManageHandlers["/debug/pprof/"] = debug
@@ -53,6 +55,7 @@ func GetDefaultManageHandlers() map[string]http.Handler {
 		defaultManageHandlers["/debug/pprof/symbol"] = debug
 		defaultManageHandlers["/debug/pprof/trace"] = debug
 		defaultManageHandlers["/debug/mesh/trace"] = debug
+		defaultManageHandlers["/debug/request/tap"] = debug
 		defaultManageHandlers["/debug/pprof/sw"] = debug
 		defaultManageHandlers["/debug/stat/system"] = debug
 		defaultManageHandlers["/debug/stat/process"] = debug
@@ -67,6 +70,10 @@ func GetDefaultManageHandlers() map[string]http.Handler {
 		log := &LogHandler{}
 		defaultManageHandlers["/logConfig/get"] = log
 		defaultManageHandlers["/logConfig/set"] = log
+		defaultManageHandlers["/logConfig/method/get"] = log
+		defaultManageHandlers["/logConfig/method/set"] = log
+
+		defaultManageHandlers["/metrics"] = metrics.PrometheusHandler()
 
 		dynamicConfigurer := &DynamicConfigurerHandler{}
 		defaultManageHandlers["/registry/register"] = dynamicConfigurer
@@ -74,9 +81,21 @@ func GetDefaultManageHandlers() map[string]http.Handler {
 		defaultManageHandlers["/registry/subscribe"] = dynamicConfigurer
 		defaultManageHandlers["/registry/list"] = dynamicConfigurer
 		defaultManageHandlers["/registry/info"] = dynamicConfigurer
+		defaultManageHandlers["/quota/update"] = dynamicConfigurer
+		defaultManageHandlers["/quota/reset"] = dynamicConfigurer
+		defaultManageHandlers["/groupRoute/update"] = dynamicConfigurer
+		defaultManageHandlers["/groupRoute/reset"] = dynamicConfigurer
 
 		hotReload := &HotReload{}
 		defaultManageHandlers["/reload/clusters"] = hotReload
+		defaultManageHandlers["/reload/config"] = hotReload
+
+		defaultManageHandlers["/circuitBreaker/list"] = &CircuitBreakerHandler{}
+
+		exporter := &ExporterHandler{}
+		defaultManageHandlers["/exporter/list"] = exporter
+		defaultManageHandlers["/exporter/available"] = exporter
+		defaultManageHandlers["/exporter/unavailable"] = exporter
 	})
 	return defaultManageHandlers
 }
@@ -15,6 +15,7 @@ import (
 	vlog "github.com/weibocom/motan-go/log"
 
 	"github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/filter"
 )
 
 const dynamicConfigRegistrySnapshot = "registry.snap"
@@ -197,6 +198,14 @@ func (h *DynamicConfigurerHandler) ServeHTTP(res http.ResponseWriter, req *http.
 		h.list(res, req)
 	case "/registry/info":
 		h.info(res, req)
+	case "/quota/update":
+		h.updateQuota(res, req)
+	case "/quota/reset":
+		h.resetQuota(res, req)
+	case "/groupRoute/update":
+		h.updateGroupRoute(res, req)
+	case "/groupRoute/reset":
+		h.resetGroupRoute(res, req)
 	default:
 		res.WriteHeader(http.StatusNotFound)
 	}
@@ -337,6 +346,56 @@ func (h *DynamicConfigurerHandler) info(res http.ResponseWriter, req *http.Reque
 	}{MeshPort: h.agent.port})
 }
 
+// updateQuota changes the quota of the quotaRateLimit filters of a service by the form, e.g.
+// service=com.weibo.TestService&quotaRate=100&quotaBurst=200&quotaDimension=method
+func (h *DynamicConfigurerHandler) updateQuota(res http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		writeHandlerResponse(res, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	params := make(map[string]string, len(req.PostForm))
+	for k := range req.PostForm {
+		params[k] = req.PostForm.Get(k)
+	}
+	if err := filter.UpdateQuota(req.PostForm.Get("service"), params); err != nil {
+		writeHandlerResponse(res, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	writeHandlerResponse(res, http.StatusOK, "ok", nil)
+}
+
+func (h *DynamicConfigurerHandler) resetQuota(res http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		writeHandlerResponse(res, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	filter.ResetQuota(req.PostForm.Get("service"))
+	writeHandlerResponse(res, http.StatusOK, "ok", nil)
+}
+
+// updateGroupRoute changes the rules of the clusterGroupRoute filters of a service by the form, e.g.
+// service=com.weibo.TestService&rules=canary:uid % 100 < 5
+func (h *DynamicConfigurerHandler) updateGroupRoute(res http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		writeHandlerResponse(res, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	if err := filter.UpdateGroupRoute(req.PostForm.Get("service"), req.PostForm.Get("rules")); err != nil {
+		writeHandlerResponse(res, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	writeHandlerResponse(res, http.StatusOK, "ok", nil)
+}
+
+func (h *DynamicConfigurerHandler) resetGroupRoute(res http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		writeHandlerResponse(res, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	filter.ResetGroupRoute(req.PostForm.Get("service"))
+	writeHandlerResponse(res, http.StatusOK, "ok", nil)
+}
+
 func writeHandlerResponse(res http.ResponseWriter, code int, message string, body interface{}) {
 	res.WriteHeader(code)
 	m := make(map[string]interface{})
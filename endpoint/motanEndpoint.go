@@ -2,8 +2,10 @@ package endpoint
 
 import (
 	"bufio"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"math/rand"
 	"net"
 	"strconv"
 	"sync"
@@ -12,9 +14,33 @@ import (
 
 	motan "github.com/weibocom/motan-go/core"
 	"github.com/weibocom/motan-go/log"
+	"github.com/weibocom/motan-go/metrics"
 	mpro "github.com/weibocom/motan-go/protocol"
 )
 
+// the url parameters of the channels of MotanEndpoint, the requests are multiplexed over the motan.ClientConnectionKey
+// channels
+const (
+	// MaxStreamsPerConnectionKey is the max count of the requests in flight of a channel, default no limit
+	MaxStreamsPerConnectionKey = "maxStreamsPerConnection"
+	// ConnectionIdleTimeoutKey closes the channels without requests for the duration(ms), default never
+	ConnectionIdleTimeoutKey = "connectionIdleTimeout"
+	// ReconnectMaxIntervalKey is the max backoff(ms) of reconnecting a closed channel, default 10s
+	ReconnectMaxIntervalKey = "reconnectMaxInterval"
+	// BatchWindowKey coalesces the small requests of a channel sent in the window(µs) into a batch frame, default no
+	// batching. the server should support the batch frames, see mpro.BuildBatch
+	BatchWindowKey = "batchWindow"
+	// BatchMaxSizeKey is the max count of the requests of a batch frame, default 32
+	BatchMaxSizeKey = "batchMaxSize"
+)
+
+const (
+	defaultBatchMaxSize = 32
+	// the larger requests are not batched
+	maxBatchRequestSize = 16 * 1024
+	maxBatchBodySize    = 256 * 1024
+)
+
 var (
 	defaultChannelPoolSize      = 3
 	defaultRequestTimeout       = 1000 * time.Millisecond
@@ -25,6 +51,12 @@ var (
 	ErrChannelShutdown          = fmt.Errorf("The channel has been shutdown")
 	ErrSendRequestTimeout       = fmt.Errorf("Timeout err: send request timeout")
 	ErrRecvRequestTimeout       = fmt.Errorf("Timeout err: receive request timeout")
+	ErrRequestCanceled          = fmt.Errorf("The request has been canceled")
+	ErrChannelBusy              = fmt.Errorf("The streams of the channels reach the limit")
+	ErrChannelPoolClosed        = fmt.Errorf("The channel pool has been closed")
+
+	defaultReconnectMinInterval = 100 * time.Millisecond
+	defaultReconnectMaxInterval = 10 * time.Second
 
 	defaultAsyncResponse = &motan.MotanResponse{Attachment: motan.NewStringMap(motan.DefaultAttachmentSize), RPCContext: &motan.RPCContext{AsyncCall: true}}
 
@@ -46,6 +78,12 @@ type MotanEndpoint struct {
 	minRequestTimeoutMillisecond int64
 	maxRequestTimeoutMillisecond int64
 	clientConnection             int
+	// the motan.CompressionKey algorithms, the requests are compressed by the first one the server supports
+	compressions string
+	compression  atomic.Value // string
+	// the larger requests are rejected before sending
+	maxRequestBodySize int
+	maxMetadataSize    int
 
 	// for heartbeat requestID
 	keepaliveID      uint64
@@ -75,10 +113,62 @@ func (m *MotanEndpoint) Initialize() {
 	m.minRequestTimeoutMillisecond, _ = m.url.GetInt(motan.MinTimeOutKey)
 	m.maxRequestTimeoutMillisecond, _ = m.url.GetInt(motan.MaxTimeOutKey)
 	m.clientConnection = int(m.url.GetPositiveIntValue(motan.ClientConnectionKey, int64(defaultChannelPoolSize)))
+	m.compressions = m.url.GetParam(motan.CompressionKey, "")
+	m.maxRequestBodySize = int(m.url.GetPositiveIntValue(motan.MaxRequestBodySizeKey, mpro.DefaultMaxBodySize))
+	m.maxMetadataSize = int(m.url.GetPositiveIntValue(motan.MaxMetadataSizeKey, motan.DefaultMaxMetadataSize))
+	config := DefaultConfig()
+	config.MaxResponseBodySize = int(m.url.GetPositiveIntValue(motan.MaxResponseBodySizeKey, mpro.DefaultMaxBodySize))
+	config.MaxStreams = int(m.url.GetIntValue(MaxStreamsPerConnectionKey, 0))
+	config.IdleTimeout = m.url.GetTimeDuration(ConnectionIdleTimeoutKey, time.Millisecond, 0)
+	config.ReconnectMaxInterval = m.url.GetTimeDuration(ReconnectMaxIntervalKey, time.Millisecond, defaultReconnectMaxInterval)
+	config.BatchWindow = m.url.GetTimeDuration(BatchWindowKey, time.Microsecond, 0)
+	config.BatchMaxSize = int(m.url.GetPositiveIntValue(BatchMaxSizeKey, defaultBatchMaxSize))
+	if config.ReconnectMaxInterval < config.ReconnectMinInterval {
+		config.ReconnectMaxInterval = config.ReconnectMinInterval
+	}
+	tlsConfig, err := motan.ParseClientTLSConfig(m.url)
+	if err != nil {
+		vlog.Errorf("motan2 endpoint %s init fail, it is unavailable. err:%v", m.url.GetAddressStr(), err)
+		return
+	}
+	transport, err := motan.GetURLTransport(m.url)
+	if err != nil {
+		vlog.Errorf("motan2 endpoint %s init fail, it is unavailable. err:%v", m.url.GetAddressStr(), err)
+		return
+	}
+	unixSock, isUnix := m.url.GetUnixSock()
+	dial := func() (net.Conn, error) {
+		if tlsConfig != nil && !isUnix && transport == motan.GetTransport(motan.TCPTransport) {
+			return tls.DialWithDialer(&net.Dialer{Timeout: connectTimeout}, "tcp", m.url.GetAddressStr(), tlsConfig)
+		}
+		var conn net.Conn
+		var err error
+		// the local servers(e.g. the agent) are connected by the unix socket without the transport
+		if isUnix {
+			conn, err = net.DialTimeout("unix", unixSock, connectTimeout)
+		} else {
+			conn, err = transport.Dial(m.url.GetAddressStr(), m.url, connectTimeout)
+		}
+		if err != nil || tlsConfig == nil {
+			return conn, err
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		tlsConn.SetDeadline(time.Now().Add(connectTimeout))
+		if err = tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		tlsConn.SetDeadline(time.Time{})
+		return tlsConn, nil
+	}
 	factory := func() (net.Conn, error) {
-		return net.DialTimeout("tcp", m.url.GetAddressStr(), connectTimeout)
+		conn, err := dial()
+		if err != nil {
+			metrics.PromConnectErrors.Add(1, m.url.GetAddressStr())
+		}
+		return conn, err
 	}
-	channels, err := NewChannelPool(m.clientConnection, factory, nil, m.serialization)
+	channels, err := NewChannelPool(m.clientConnection, factory, config, m.serialization)
 	if err != nil {
 		vlog.Errorf("Channel pool init failed. url: %v, err:%s", m.url, err.Error())
 		// retry connect
@@ -90,7 +180,7 @@ func (m *MotanEndpoint) Initialize() {
 			for {
 				select {
 				case <-ticker.C:
-					channels, err := NewChannelPool(m.clientConnection, factory, nil, m.serialization)
+					channels, err := NewChannelPool(m.clientConnection, factory, config, m.serialization)
 					if err == nil {
 						m.channels = channels
 						m.setAvailable(true)
@@ -145,6 +235,10 @@ func (m *MotanEndpoint) Call(request motan.Request) motan.Response {
 	rc := request.GetRPCContext(true)
 	rc.Proxy = m.proxy
 	rc.GzipSize = int(m.url.GetIntValue(motan.GzipSizeKey, 0))
+	if m.compressions != "" {
+		request.SetAttachment(mpro.MAcceptCompression, m.compressions)
+		rc.Compression, _ = m.compression.Load().(string)
+	}
 
 	if m.channels == nil {
 		vlog.Errorf("motanEndpoint %s error: channels is null", m.url.GetAddressStr())
@@ -159,10 +253,13 @@ func (m *MotanEndpoint) Call(request motan.Request) motan.Response {
 	channel, err := m.channels.Get()
 	if err != nil {
 		vlog.Errorf("motanEndpoint %s error: can not get a channel, msg: %s", m.url.GetAddressStr(), err.Error())
-		m.recordErrAndKeepalive()
+		// the busy channels are still available
+		if err != ErrChannelBusy {
+			m.recordErrAndKeepalive()
+		}
 		return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{
 			ErrCode: motan.ENoChannel,
-			ErrMsg:  "can not get a channel",
+			ErrMsg:  "can not get a channel: " + err.Error(),
 			ErrType: motan.ServiceException,
 		})
 	}
@@ -173,6 +270,9 @@ func (m *MotanEndpoint) Call(request motan.Request) motan.Response {
 		request.SetAttachment(mpro.MGroup, m.url.Group)
 	}
 
+	if rc.ProgressListener != nil {
+		request.SetAttachment(mpro.MProgressEnabled, "true")
+	}
 	var msg *mpro.Message
 	msg, err = mpro.ConvertToReqMessage(request, m.serialization)
 
@@ -180,6 +280,17 @@ func (m *MotanEndpoint) Call(request motan.Request) motan.Response {
 		vlog.Errorf("convert motan request fail! ep: %s, req: %s, err:%s", m.url.GetAddressStr(), motan.GetReqInfo(request), err.Error())
 		return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 500, ErrMsg: "convert motan request fail!", ErrType: motan.ServiceException})
 	}
+	// the compressed body is copied into the encoded buffer when it is sent
+	defer msg.ReleaseBody()
+	if m.maxRequestBodySize > 0 && len(msg.Body) > m.maxRequestBodySize {
+		return m.oversizedResponse(request.GetRequestID(), msg, "request", len(msg.Body), m.maxRequestBodySize)
+	}
+	if size := motan.MetadataSize(msg.Metadata); size > m.maxMetadataSize {
+		vlog.Warningf("reject oversized request metadata. ep:%s, req:%s, size:%d, limit:%d", m.url.GetAddressStr(), motan.GetReqInfo(request), size, m.maxMetadataSize)
+		return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 413,
+			ErrMsg:  "request metadata size " + strconv.Itoa(size) + " exceeds the limit " + strconv.Itoa(m.maxMetadataSize),
+			ErrType: motan.FrameworkException})
+	}
 	if rc.Tc != nil {
 		rc.Tc.PutReqSpan(&motan.Span{Name: motan.Convert, Addr: m.GetURL().GetAddressStr(), Time: time.Now()})
 	}
@@ -189,11 +300,18 @@ func (m *MotanEndpoint) Call(request motan.Request) motan.Response {
 		m.recordErrAndKeepalive()
 		return m.defaultErrMotanResponse(request, "channel call error:"+err.Error())
 	}
+	if rc.Oneway {
+		m.resetErr()
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Attachment: motan.NewStringMap(motan.DefaultAttachmentSize), RPCContext: &motan.RPCContext{Oneway: true}}
+	}
 	if rc.AsyncCall {
 		return defaultAsyncResponse
 	}
 	recvMsg.Header.SetProxy(m.proxy)
 	recvMsg.Header.RequestID = request.GetRequestID()
+	if supported := recvMsg.Metadata.LoadOrEmpty(mpro.MAcceptCompression); supported != "" && m.compressions != "" {
+		m.compression.Store(mpro.NegotiateCompression(m.compressions, motan.TrimSplit(supported, ",")))
+	}
 	response, err := mpro.ConvertToResponse(recvMsg, m.serialization)
 	if rc.Tc != nil {
 		rc.Tc.PutResSpan(&motan.Span{Name: motan.Convert, Time: time.Now()})
@@ -277,6 +395,23 @@ func (m *MotanEndpoint) keepalive() {
 	}
 }
 
+func (m *MotanEndpoint) oversizedResponse(requestID uint64, msg *mpro.Message, kind string, size int, limit int) motan.Response {
+	vlog.Warningf("reject oversized %s. ep:%s, service:%s, method:%s, size:%d, limit:%d", kind, m.url.GetAddressStr(), msg.Metadata.LoadOrEmpty(mpro.MPath), msg.Metadata.LoadOrEmpty(mpro.MMethod), size, limit)
+	addOversizedMetric(m.url, kind)
+	return motan.BuildExceptionResponse(requestID, &motan.Exception{ErrCode: 413,
+		ErrMsg:  kind + " body size " + strconv.Itoa(size) + " exceeds the limit " + strconv.Itoa(limit),
+		ErrType: motan.FrameworkException})
+}
+
+func addOversizedMetric(url *motan.URL, kind string) {
+	application := metrics.DefaultStatApplication
+	if url != nil {
+		application = url.GetParam(motan.ApplicationKey, metrics.DefaultStatApplication)
+	}
+	key := "motan-client" + metrics.KeyDelimiter + application + metrics.KeyDelimiter + kind + "_" + mpro.OversizedBodyMetric
+	metrics.AddCounter(metrics.DefaultStatGroup, metrics.DefaultStatService, key, 1)
+}
+
 func (m *MotanEndpoint) defaultErrMotanResponse(request motan.Request, errMsg string) motan.Response {
 	response := &motan.MotanResponse{
 		RequestID:  request.GetRequestID(),
@@ -309,11 +444,29 @@ func (m *MotanEndpoint) IsAvailable() bool {
 // Config : Config
 type Config struct {
 	RequestTimeout time.Duration
+	// MaxStreams is the max count of the requests in flight of a channel, there is no limit if it is not positive
+	MaxStreams int
+	// IdleTimeout closes the channels without streams for the duration, they are reconnected when they are used.
+	// the channels are not closed for idle if it is not positive
+	IdleTimeout time.Duration
+	// the backoff of reconnecting a channel doubles from ReconnectMinInterval to ReconnectMaxInterval
+	ReconnectMinInterval time.Duration
+	ReconnectMaxInterval time.Duration
+	// MaxResponseBodySize is the limit of the response bodies, the larger responses are replaced by exceptions.
+	// there is no limit if it is not positive
+	MaxResponseBodySize int
+	// BatchWindow is the time the first request of a batch frame waits for the following requests, the requests are
+	// not batched if it is not positive
+	BatchWindow time.Duration
+	// BatchMaxSize is the max count of the requests of a batch frame, a full batch frame is sent without waiting
+	BatchMaxSize int
 }
 
 func DefaultConfig() *Config {
 	return &Config{
-		RequestTimeout: defaultRequestTimeout,
+		RequestTimeout:       defaultRequestTimeout,
+		ReconnectMinInterval: defaultReconnectMinInterval,
+		ReconnectMaxInterval: defaultReconnectMaxInterval,
 	}
 }
 
@@ -321,10 +474,15 @@ func VerifyConfig(config *Config) error {
 	if config.RequestTimeout <= 0 {
 		return fmt.Errorf("RequestTimeout interval must be positive")
 	}
+	if config.ReconnectMinInterval <= 0 || config.ReconnectMaxInterval < config.ReconnectMinInterval {
+		return fmt.Errorf("ReconnectMinInterval must be positive and not larger than ReconnectMaxInterval")
+	}
 	return nil
 }
 
 type Channel struct {
+	lastActive int64 // the unix nano of the last stream, keep it first for atomic alignment
+
 	// config
 	config        *Config
 	serialization motan.Serialization
@@ -357,6 +515,8 @@ type Stream struct {
 	// recv msg
 	recvMsg      *mpro.Message
 	recvNotifyCh chan struct{}
+	// frames of the opened stream, see MotanEndpoint.OpenStream
+	frameCh chan *mpro.Message
 	// timeout
 	deadline time.Time
 
@@ -373,9 +533,19 @@ func (s *Stream) Send() error {
 	if s.rc != nil && s.rc.Tc != nil {
 		s.rc.Tc.PutReqSpan(&motan.Span{Name: motan.Encode, Addr: s.channel.address, Time: time.Now()})
 	}
-	ready := sendReady{data: buf.Bytes()}
+	ready := sendReady{data: buf.Bytes(), buf: buf, batchable: !s.isHeartBeat && len(buf.Bytes()) <= maxBatchRequestSize}
+	oneway := s.rc != nil && s.rc.Oneway && !s.isHeartBeat
+	if oneway {
+		ready.written = make(chan error, 1)
+	}
 	select {
 	case s.channel.sendCh <- ready:
+		// the oneway request is sent when it is written to the connection
+		if oneway {
+			if err := s.waitWritten(ready.written, timer.C); err != nil {
+				return err
+			}
+		}
 		if s.rc != nil {
 			sendTime := time.Now()
 			s.rc.RequestSendTime = sendTime
@@ -385,6 +555,19 @@ func (s *Stream) Send() error {
 		}
 		return nil
 	case <-timer.C:
+		motan.ReleaseBytesBuffer(buf)
+		return ErrSendRequestTimeout
+	case <-s.channel.shutdownCh:
+		motan.ReleaseBytesBuffer(buf)
+		return ErrChannelShutdown
+	}
+}
+
+func (s *Stream) waitWritten(written chan error, timeout <-chan time.Time) error {
+	select {
+	case err := <-written:
+		return err
+	case <-timeout:
 		return ErrSendRequestTimeout
 	case <-s.channel.shutdownCh:
 		return ErrChannelShutdown
@@ -398,6 +581,11 @@ func (s *Stream) Recv() (*mpro.Message, error) {
 	}()
 	timer := time.NewTimer(s.deadline.Sub(time.Now()))
 	defer timer.Stop()
+	// the call is abandoned if the context of the request is canceled
+	var canceled <-chan struct{}
+	if s.rc != nil && s.rc.Context != nil {
+		canceled = s.rc.Context.Done()
+	}
 	select {
 	case <-s.recvNotifyCh:
 		msg := s.recvMsg
@@ -409,9 +597,21 @@ func (s *Stream) Recv() (*mpro.Message, error) {
 		return nil, ErrRecvRequestTimeout
 	case <-s.channel.shutdownCh:
 		return nil, ErrChannelShutdown
+	case <-canceled:
+		return nil, ErrRequestCanceled
 	}
 }
 
+// notifyProgress does not close the stream, the final response is still expected
+func (s *Stream) notifyProgress(msg *mpro.Message) {
+	if s.rc == nil || s.rc.ProgressListener == nil {
+		return
+	}
+	defer motan.HandlePanic(nil)
+	percent, _ := strconv.Atoi(msg.Metadata.LoadOrEmpty(mpro.MProgress))
+	s.rc.ProgressListener(&motan.ProgressEvent{Percent: percent, Message: msg.Metadata.LoadOrEmpty(mpro.MProgressMessage)})
+}
+
 func (s *Stream) notify(msg *mpro.Message, t time.Time) {
 	defer func() {
 		s.Close()
@@ -459,6 +659,7 @@ func (c *Channel) NewStream(msg *mpro.Message, rc *motan.RPCContext) (*Stream, e
 	if c.IsClosed() {
 		return nil, ErrChannelShutdown
 	}
+	c.touch()
 	s := &Stream{
 		channel:      c,
 		sendMsg:      msg,
@@ -476,12 +677,37 @@ func (c *Channel) NewStream(msg *mpro.Message, rc *motan.RPCContext) (*Stream, e
 		s.isHeartBeat = true
 	} else {
 		c.streamLock.Lock()
+		if c.config.MaxStreams > 0 && len(c.streams) >= c.config.MaxStreams {
+			c.streamLock.Unlock()
+			return nil, ErrChannelBusy
+		}
 		c.streams[msg.Header.RequestID] = s
 		c.streamLock.Unlock()
 	}
 	return s, nil
 }
 
+func (c *Channel) streamCount() int {
+	c.streamLock.Lock()
+	defer c.streamLock.Unlock()
+	return len(c.streams)
+}
+
+func (c *Channel) touch() {
+	atomic.StoreInt64(&c.lastActive, time.Now().UnixNano())
+}
+
+// idle returns true if the channel has no streams and is not used for the timeout
+func (c *Channel) idle(timeout time.Duration) bool {
+	if time.Since(time.Unix(0, atomic.LoadInt64(&c.lastActive))) < timeout {
+		return false
+	}
+	c.heartbeatLock.Lock()
+	heartbeats := len(c.heartbeats)
+	c.heartbeatLock.Unlock()
+	return heartbeats == 0 && c.streamCount() == 0
+}
+
 func (s *Stream) Close() {
 	if !s.isClose.Load().(bool) {
 		if s.isHeartBeat {
@@ -493,12 +719,19 @@ func (s *Stream) Close() {
 			delete(s.channel.streams, s.sendMsg.Header.RequestID)
 			s.channel.streamLock.Unlock()
 		}
+		s.channel.touch()
 		s.isClose.Store(true)
 	}
 }
 
 type sendReady struct {
 	data []byte
+	// the buffer of data, it is released to the buffer pool after data is written
+	buf *motan.BytesBuffer
+	// receives the result of the write if it is not nil, it should be buffered
+	written chan error
+	// the requests can be sent in a batch frame, the heartbeats and the stream frames are not batched
+	batchable bool
 }
 
 func (c *Channel) Call(msg *mpro.Message, deadline time.Duration, rc *motan.RPCContext) (*mpro.Message, error) {
@@ -507,7 +740,13 @@ func (c *Channel) Call(msg *mpro.Message, deadline time.Duration, rc *motan.RPCC
 		return nil, err
 	}
 	stream.SetDeadline(deadline)
-	if err := stream.Send(); err != nil {
+	err = stream.Send()
+	if rc != nil && rc.Oneway {
+		// no response of a oneway request
+		stream.Close()
+		return nil, err
+	}
+	if err != nil {
 		return nil, err
 	}
 	if rc != nil && rc.AsyncCall {
@@ -517,6 +756,8 @@ func (c *Channel) Call(msg *mpro.Message, deadline time.Duration, rc *motan.RPCC
 }
 
 func (c *Channel) IsClosed() bool {
+	c.shutdownLock.Lock()
+	defer c.shutdownLock.Unlock()
 	return c.shutdown
 }
 
@@ -531,9 +772,18 @@ func (c *Channel) recv() {
 
 func (c *Channel) recvLoop() error {
 	for {
-		res, t, err := mpro.DecodeWithTime(c.bufRead)
+		res, t, err := mpro.DecodeWithLimit(c.bufRead, nil, c.config.MaxResponseBodySize, 0)
 		if err != nil {
-			return err
+			be, ok := err.(*mpro.BodySizeError)
+			if !ok {
+				return err
+			}
+			// the body has been skipped, the caller gets an exception and the channel keeps receiving
+			vlog.Warningf("reject oversized response. ep:%s, requestid:%d, size:%d, limit:%d", c.address, be.Message.Header.RequestID, be.Size, be.Limit)
+			addOversizedMetric(nil, "response")
+			res = mpro.BuildExceptionResponse(be.Message.Header.RequestID, mpro.ExceptionToJSON(&motan.Exception{ErrCode: 413,
+				ErrMsg:  be.Error(),
+				ErrType: motan.FrameworkException}))
 		}
 		//TODO async
 		var handleErr error
@@ -555,19 +805,15 @@ func (c *Channel) send() {
 	for {
 		select {
 		case ready := <-c.sendCh:
-			if ready.data != nil {
-				// TODO need async?
-				c.conn.SetWriteDeadline(time.Now().Add(motan.DefaultWriteTimeout))
-				sent := 0
-				for sent < len(ready.data) {
-					n, err := c.conn.Write(ready.data[sent:])
-					if err != nil {
-						vlog.Errorf("Failed to write channel. ep: %s, err: %s", c.address, err.Error())
-						c.closeOnErr(err)
-						return
-					}
-					sent += n
-				}
+			var err error
+			if ready.batchable && c.config.BatchWindow > 0 {
+				err = c.writeBatch(ready)
+			} else {
+				err = c.write(ready)
+			}
+			if err != nil {
+				c.closeOnErr(err)
+				return
 			}
 		case <-c.shutdownCh:
 			return
@@ -575,6 +821,72 @@ func (c *Channel) send() {
 	}
 }
 
+func (c *Channel) write(readies ...sendReady) error {
+	var data []byte
+	if len(readies) == 1 {
+		data = readies[0].data
+	} else {
+		frames := make([][]byte, 0, len(readies))
+		for _, ready := range readies {
+			frames = append(frames, ready.data)
+		}
+		buf := mpro.BuildBatch(frames).Encode()
+		defer motan.ReleaseBytesBuffer(buf)
+		data = buf.Bytes()
+	}
+	var err error
+	if len(data) > 0 {
+		// TODO need async?
+		c.conn.SetWriteDeadline(time.Now().Add(motan.DefaultWriteTimeout))
+		sent := 0
+		for sent < len(data) {
+			n, e := c.conn.Write(data[sent:])
+			if e != nil {
+				vlog.Errorf("Failed to write channel. ep: %s, err: %s", c.address, e.Error())
+				err = e
+				break
+			}
+			sent += n
+		}
+	}
+	for _, ready := range readies {
+		if err == nil {
+			motan.ReleaseBytesBuffer(ready.buf)
+		}
+		if ready.written != nil {
+			ready.written <- err
+		}
+	}
+	return err
+}
+
+// writeBatch writes the first request with the batchable requests sent in the batch window in a batch frame, the frame
+// is written once it is full or a request which is not batchable is sent, so the order of the messages is kept
+func (c *Channel) writeBatch(first sendReady) error {
+	batch := []sendReady{first}
+	size := len(first.data)
+	timer := time.NewTimer(c.config.BatchWindow)
+	defer timer.Stop()
+	for len(batch) < c.config.BatchMaxSize && size < maxBatchBodySize {
+		select {
+		case ready := <-c.sendCh:
+			if !ready.batchable {
+				if err := c.write(batch...); err != nil {
+					return err
+				}
+				return c.write(ready)
+			}
+			batch = append(batch, ready)
+			size += len(ready.data)
+		case <-timer.C:
+			return c.write(batch...)
+		case <-c.shutdownCh:
+			return ErrChannelShutdown
+		}
+	}
+	return c.write(batch...)
+}
+
 func (c *Channel) handleHeartbeat(msg *mpro.Message, t time.Time) error {
 	c.heartbeatLock.Lock()
 	stream := c.heartbeats[msg.Header.RequestID]
@@ -593,6 +905,10 @@ func (c *Channel) handleMessage(msg *mpro.Message, t time.Time) error {
 	c.streamLock.Unlock()
 	if stream == nil {
 		vlog.Warningf("handle recv message, missing stream: %d, ep:%s", msg.Header.RequestID, c.address)
+	} else if msg.IsProgress() {
+		stream.notifyProgress(msg)
+	} else if msg.GetStreamFrame() != "" {
+		stream.notifyFrame(msg)
 	} else {
 		stream.notify(msg, t)
 	}
@@ -621,73 +937,178 @@ func (c *Channel) Close() error {
 	c.shutdown = true
 	close(c.shutdownCh)
 	c.conn.Close()
+	metrics.PromConnections.Add(-1, c.address)
 	return nil
 }
 
 type ConnFactory func() (net.Conn, error)
 
+// ChannelPool multiplexes the requests over a fixed number of channels, the requests of a channel are correlated by
+// the request id. a closed channel is reconnected when it is got, with a jittered exponential backoff if the connection
+// fails, and the channels without streams for Config.IdleTimeout are closed until they are used again
 type ChannelPool struct {
-	channels      chan *Channel
-	channelsLock  sync.Mutex
+	next          uint32
+	slots         []*channelSlot
 	factory       ConnFactory
 	config        *Config
 	serialization motan.Serialization
+	closed        bool
+	closeCh       chan struct{}
+	closeLock     sync.Mutex
 }
 
-func (c *ChannelPool) getChannels() chan *Channel {
-	channels := c.channels
-	return channels
+// channelSlot is a position of the pool, it holds a channel or the backoff state of reconnecting
+type channelSlot struct {
+	lock     sync.Mutex
+	channel  *Channel
+	dialing  bool
+	failures uint
+	nextDial time.Time
 }
 
+func (c *ChannelPool) isClosed() bool {
+	c.closeLock.Lock()
+	defer c.closeLock.Unlock()
+	return c.closed
+}
+
+// Get returns a channel of the pool by round robin, the channels reconnecting in backoff and the channels reaching
+// Config.MaxStreams are skipped
 func (c *ChannelPool) Get() (*Channel, error) {
-	channels := c.getChannels()
-	if channels == nil {
-		return nil, errors.New("channels is nil")
+	if c.isClosed() {
+		return nil, ErrChannelPoolClosed
 	}
-	channel, ok := <-channels
-	if ok && (channel == nil || channel.IsClosed()) {
-		conn, err := c.factory()
-		if err != nil {
-			vlog.Errorf("create channel failed. err:%s", err.Error())
+	n := uint32(len(c.slots))
+	start := atomic.AddUint32(&c.next, 1)
+	busy := false
+	for i := uint32(0); i < n; i++ {
+		channel := c.slots[(start+i)%n].get(c)
+		if channel == nil {
+			continue
+		}
+		if c.config.MaxStreams > 0 && channel.streamCount() >= c.config.MaxStreams {
+			busy = true
+			continue
+		}
+		return channel, nil
+	}
+	if busy {
+		return nil, ErrChannelBusy
+	}
+	return nil, errors.New("channel is nil")
+}
+
+func (s *channelSlot) get(pool *ChannelPool) *Channel {
+	s.lock.Lock()
+	if s.channel != nil && !s.channel.IsClosed() {
+		channel := s.channel
+		channel.touch()
+		s.lock.Unlock()
+		return channel
+	}
+	if s.dialing || time.Now().Before(s.nextDial) {
+		s.lock.Unlock()
+		return nil
+	}
+	s.dialing = true
+	s.lock.Unlock()
+
+	channel, err := pool.dial()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.dialing = false
+	if err != nil {
+		s.failures++
+		backoff := reconnectBackoff(pool.config, s.failures)
+		s.nextDial = time.Now().Add(backoff)
+		vlog.Errorf("create channel failed, retry after %v. err:%s", backoff, err.Error())
+		return nil
+	}
+	s.failures = 0
+	if pool.isClosed() {
+		channel.Close()
+		return nil
+	}
+	s.channel = channel
+	return channel
+}
+
+// reapIdle closes the channel if it has no streams for the idle timeout
+func (s *channelSlot) reapIdle(idleTimeout time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.channel != nil && s.channel.idle(idleTimeout) {
+		vlog.Infof("close idle channel. ep:%s", s.channel.address)
+		s.channel.Close()
+		s.channel = nil
+	}
+}
+
+func (s *channelSlot) close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.channel != nil {
+		s.channel.Close()
+		s.channel = nil
+	}
+}
+
+// reconnectBackoff doubles the interval by the failures from ReconnectMinInterval to ReconnectMaxInterval, and
+// jitters it by ±20% so the clients do not reconnect at the same time
+func reconnectBackoff(config *Config, failures uint) time.Duration {
+	backoff := config.ReconnectMaxInterval
+	if failures < 32 {
+		if d := config.ReconnectMinInterval << (failures - 1); d > 0 && d < backoff {
+			backoff = d
 		}
-		channel = buildChannel(conn, c.config, c.serialization)
 	}
-	if err := retChannelPool(channels, channel); err != nil && channel != nil {
-		channel.closeOnErr(err)
+	return time.Duration(float64(backoff) * (0.8 + 0.4*rand.Float64()))
+}
+
+func (c *ChannelPool) dial() (*Channel, error) {
+	conn, err := c.factory()
+	if err != nil {
+		return nil, err
+	}
+	if tc, ok := conn.(*net.TCPConn); ok {
+		_ = tc.SetNoDelay(true)
 	}
+	channel := buildChannel(conn, c.config, c.serialization)
 	if channel == nil {
-		return nil, errors.New("channel is nil")
+		conn.Close()
+		return nil, errors.New("build channel fail")
 	}
 	return channel, nil
 }
 
-func retChannelPool(channels chan *Channel, channel *Channel) (error error) {
-	defer func() {
-		if err := recover(); err != nil {
-			error = errors.New("ChannelPool has been closed")
+func (c *ChannelPool) reap() {
+	defer motan.HandlePanic(nil)
+	ticker := time.NewTicker(c.config.IdleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, s := range c.slots {
+				s.reapIdle(c.config.IdleTimeout)
+			}
+		case <-c.closeCh:
+			return
 		}
-	}()
-	if channels == nil {
-		return errors.New("channels is nil")
 	}
-	channels <- channel
-	return nil
 }
 
 func (c *ChannelPool) Close() error {
-	c.channelsLock.Lock() // to prevent channels closed many times
-	channels := c.channels
-	c.channels = nil
-	c.factory = nil
-	c.config = nil
-	c.channelsLock.Unlock()
-	if channels == nil {
+	c.closeLock.Lock() // to prevent channels closed many times
+	if c.closed {
+		c.closeLock.Unlock()
 		return nil
 	}
-	close(channels)
-	for channel := range channels {
-		if channel != nil {
-			channel.Close()
+	c.closed = true
+	close(c.closeCh)
+	c.closeLock.Unlock()
+	for _, s := range c.slots {
+		if s != nil {
+			s.close()
 		}
 	}
 	return nil
@@ -697,20 +1118,29 @@ func NewChannelPool(poolCap int, factory ConnFactory, config *Config, serializat
 	if poolCap <= 0 {
 		return nil, errors.New("invalid capacity settings")
 	}
+	if config == nil {
+		config = DefaultConfig()
+	}
+	if err := VerifyConfig(config); err != nil {
+		return nil, err
+	}
 	channelPool := &ChannelPool{
-		channels:      make(chan *Channel, poolCap),
+		slots:         make([]*channelSlot, poolCap),
 		factory:       factory,
 		config:        config,
 		serialization: serialization,
+		closeCh:       make(chan struct{}),
 	}
 	for i := 0; i < poolCap; i++ {
-		conn, err := factory()
+		channel, err := channelPool.dial()
 		if err != nil {
 			channelPool.Close()
 			return nil, err
 		}
-		_ = conn.(*net.TCPConn).SetNoDelay(true)
-		channelPool.channels <- buildChannel(conn, config, serialization)
+		channelPool.slots[i] = &channelSlot{channel: channel}
+	}
+	if config.IdleTimeout > 0 {
+		go channelPool.reap()
 	}
 	return channelPool, nil
 }
@@ -730,6 +1160,7 @@ func buildChannel(conn net.Conn, config *Config, serialization motan.Serializati
 		config:        config,
 		bufRead:       bufio.NewReader(conn),
 		sendCh:        make(chan sendReady, 256),
+		lastActive:    time.Now().UnixNano(),
 		streams:       make(map[uint64]*Stream, 64),
 		heartbeats:    make(map[uint64]*Stream),
 		shutdownCh:    make(chan struct{}),
@@ -737,6 +1168,8 @@ func buildChannel(conn net.Conn, config *Config, serialization motan.Serializati
 		address:       conn.RemoteAddr().String(),
 	}
 
+	metrics.PromConnections.Add(1, channel.address)
+
 	go channel.recv()
 
 	go channel.send()
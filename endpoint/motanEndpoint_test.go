@@ -1,11 +1,26 @@
 package endpoint
 
 import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"github.com/weibocom/motan-go/protocol"
+	"io/ioutil"
+	"math/big"
 	"net"
+	"os"
+	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -87,6 +102,344 @@ func TestMotanEndpoint_RequestTimeout(t *testing.T) {
 	ep.Destroy()
 }
 
+func TestMotanEndpoint_MaxMetadataSize(t *testing.T) {
+	url := &motan.URL{Port: 8989, Protocol: "motan2"}
+	url.PutParam(motan.TimeOutKey, "100")
+	url.PutParam(motan.MaxMetadataSizeKey, "64")
+	ep := &MotanEndpoint{}
+	ep.SetURL(url)
+	ep.SetProxy(true)
+	ep.SetSerialization(&serialize.SimpleSerialization{})
+	ep.Initialize()
+	defer ep.Destroy()
+	request := &motan.MotanRequest{ServiceName: "test", Method: "test"}
+	request.SetAttachment("large", strings.Repeat("a", 64))
+	res := ep.Call(request)
+	assert.Equal(t, 413, res.GetException().ErrCode)
+	assert.Contains(t, res.GetException().ErrMsg, "exceeds the limit 64")
+	// not an error of the endpoint
+	assert.True(t, ep.IsAvailable())
+}
+
+func TestMotanEndpoint_TLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeTestKeyPair(t, dir)
+	config, err := motan.ParseServerTLSConfig(&motan.URL{Parameters: map[string]string{motan.SSLEnableKey: "true",
+		motan.CertFileKey: certFile, motan.KeyFileKey: keyFile, motan.CAFileKey: certFile, motan.ClientAuthKey: motan.TLSClientAuthVerify}})
+	assert.Nil(t, err)
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	assert.Nil(t, err)
+	defer lis.Close()
+	go handleTLS(lis)
+
+	port := lis.Addr().(*net.TCPAddr).Port
+	request := &motan.MotanRequest{ServiceName: "test", Method: "test", Attachment: motan.NewStringMap(0)}
+
+	// mutual tls
+	ep := newTLSTestEndpoint(port, map[string]string{motan.ClientSSLEnableKey: "true", motan.ServerCAFileKey: certFile,
+		motan.ClientCertFileKey: certFile, motan.ClientKeyFileKey: keyFile})
+	assert.True(t, ep.IsAvailable())
+	res := ep.Call(request)
+	assert.Nil(t, res.GetException())
+	assert.Equal(t, "ok", res.GetValue())
+	ep.Destroy()
+
+	// the server rejects the client without certificate
+	ep = newTLSTestEndpoint(port, map[string]string{motan.ClientSSLEnableKey: "true", motan.ServerCAFileKey: certFile,
+		motan.ErrorCountThresholdKey: "1"})
+	assert.NotNil(t, ep.Call(request).GetException())
+	ep.Destroy()
+
+	// the illegal tls config keeps the endpoint unavailable
+	ep = newTLSTestEndpoint(port, map[string]string{motan.ClientSSLEnableKey: "true", motan.ClientCertFileKey: certFile})
+	assert.False(t, ep.IsAvailable())
+}
+
+func TestChannel_OversizedResponse(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	config := DefaultConfig()
+	config.MaxResponseBodySize = 5
+	channel := buildChannel(client, config, &serialize.SimpleSerialization{})
+	defer channel.Close()
+	go func() {
+		reader := bufio.NewReader(server)
+		for {
+			req, err := protocol.Decode(reader)
+			if err != nil {
+				return
+			}
+			res := protocol.BuildHeartbeat(req.Header.RequestID, protocol.Res)
+			res.Header.SetHeartbeat(false)
+			res.Body = []byte(req.Metadata.LoadOrEmpty("body"))
+			server.Write(res.Encode().Bytes())
+		}
+	}()
+	call := func(id uint64, body string) (*protocol.Message, error) {
+		req := protocol.BuildHeartbeat(id, protocol.Req)
+		req.Header.SetHeartbeat(false)
+		req.Metadata.Store("body", body)
+		return channel.Call(req, time.Second, nil)
+	}
+	res, err := call(1, "0123456789")
+	assert.Nil(t, err)
+	assert.Equal(t, protocol.Exception, res.Header.GetStatus())
+	assert.Contains(t, res.Metadata.LoadOrEmpty(protocol.MExceptionn), "exceeds the limit 5")
+	// the channel keeps receiving after the body is skipped
+	res, err = call(2, "01234")
+	assert.Nil(t, err)
+	assert.Equal(t, "01234", string(res.Body))
+	assert.False(t, channel.IsClosed())
+}
+
+func TestChannel_Oneway(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	channel := buildChannel(client, DefaultConfig(), &serialize.SimpleSerialization{})
+	defer channel.Close()
+	received := make(chan *protocol.Message, 1)
+	go func() {
+		reader := bufio.NewReader(server)
+		// the oneway request is not responded
+		req, err := protocol.Decode(reader)
+		if err == nil {
+			received <- req
+		}
+	}()
+	req := protocol.BuildHeartbeat(1, protocol.Req)
+	req.Header.SetHeartbeat(false)
+	req.Header.SetOneWay(true)
+	res, err := channel.Call(req, time.Second, &motan.RPCContext{Oneway: true})
+	assert.Nil(t, err)
+	assert.Nil(t, res)
+	assert.Equal(t, 0, channel.streamCount())
+	select {
+	case msg := <-received:
+		assert.True(t, msg.Header.IsOneWay())
+	case <-time.After(time.Second):
+		t.Fatal("oneway request is not written")
+	}
+
+	// the write is not finished if the connection is not read
+	_, err = channel.Call(req, 50*time.Millisecond, &motan.RPCContext{Oneway: true})
+	assert.Equal(t, ErrSendRequestTimeout, err)
+	assert.Equal(t, 0, channel.streamCount())
+}
+
+func TestChannel_Batch(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	config := DefaultConfig()
+	config.BatchWindow = 50 * time.Millisecond
+	config.BatchMaxSize = 3
+	channel := buildChannel(client, config, &serialize.SimpleSerialization{})
+	defer channel.Close()
+	var batches int32
+	go func() {
+		reader := bufio.NewReader(server)
+		for {
+			req, err := protocol.Decode(reader)
+			if err != nil {
+				return
+			}
+			messages := []*protocol.Message{req}
+			if req.IsBatch() {
+				atomic.AddInt32(&batches, 1)
+				if messages, err = protocol.DecodeBatch(req, nil); err != nil {
+					return
+				}
+			}
+			for _, msg := range messages {
+				res := protocol.BuildHeartbeat(msg.Header.RequestID, protocol.Res)
+				res.Header.SetHeartbeat(false)
+				res.Body = msg.Body
+				server.Write(res.Encode().Bytes())
+			}
+		}
+	}()
+	call := func(id uint64) (*protocol.Message, error) {
+		req := protocol.BuildHeartbeat(id, protocol.Req)
+		req.Header.SetHeartbeat(false)
+		req.Body = []byte(strconv.FormatUint(id, 10))
+		return channel.Call(req, time.Second, nil)
+	}
+	// the full batch frame is sent without waiting for the window
+	start := time.Now()
+	results := make(chan string, 3)
+	for i := 1; i <= 3; i++ {
+		go func(id uint64) {
+			res, err := call(id)
+			if err != nil {
+				results <- err.Error()
+				return
+			}
+			results <- string(res.Body)
+		}(uint64(i))
+	}
+	received := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		received[<-results] = true
+	}
+	assert.Equal(t, map[string]bool{"1": true, "2": true, "3": true}, received)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&batches))
+	assert.True(t, time.Since(start) < 50*time.Millisecond)
+
+	// a single request in the window is sent as is
+	res, err := call(4)
+	assert.Nil(t, err)
+	assert.Equal(t, "4", string(res.Body))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&batches))
+}
+
+func TestChannelPool_MaxStreams(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxStreams = 1
+	pool, err := NewChannelPool(2, func() (net.Conn, error) {
+		client, _ := net.Pipe()
+		return client, nil
+	}, config, &serialize.SimpleSerialization{})
+	assert.Nil(t, err)
+	defer pool.Close()
+	streams := make([]*Stream, 0, 2)
+	for i := 0; i < 2; i++ {
+		channel, err := pool.Get()
+		assert.Nil(t, err)
+		msg := protocol.BuildHeartbeat(0, protocol.Req)
+		msg.Header.SetHeartbeat(false)
+		s, err := channel.NewStream(msg, nil)
+		assert.Nil(t, err)
+		streams = append(streams, s)
+	}
+	_, err = pool.Get()
+	assert.Equal(t, ErrChannelBusy, err)
+	streams[0].Close()
+	channel, err := pool.Get()
+	assert.Nil(t, err)
+	assert.Equal(t, streams[0].channel, channel)
+
+	pool.Close()
+	_, err = pool.Get()
+	assert.Equal(t, ErrChannelPoolClosed, err)
+}
+
+func TestChannelPool_Reconnect(t *testing.T) {
+	var dials int32
+	var fail atomic.Value
+	fail.Store(false)
+	config := DefaultConfig()
+	config.ReconnectMinInterval = 50 * time.Millisecond
+	config.IdleTimeout = 20 * time.Millisecond
+	pool, err := NewChannelPool(1, func() (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		if fail.Load().(bool) {
+			return nil, errors.New("connect fail")
+		}
+		client, _ := net.Pipe()
+		return client, nil
+	}, config, &serialize.SimpleSerialization{})
+	assert.Nil(t, err)
+	defer pool.Close()
+
+	// the idle channel is closed and reconnected when it is used
+	channel, _ := pool.Get()
+	time.Sleep(60 * time.Millisecond)
+	assert.True(t, channel.IsClosed())
+	newChannel, err := pool.Get()
+	assert.Nil(t, err)
+	assert.NotEqual(t, channel, newChannel)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&dials))
+
+	// the failed connection is retried after the backoff
+	fail.Store(true)
+	newChannel.Close()
+	_, err = pool.Get()
+	assert.NotNil(t, err)
+	_, err = pool.Get()
+	assert.NotNil(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&dials))
+	fail.Store(false)
+	time.Sleep(70 * time.Millisecond)
+	_, err = pool.Get()
+	assert.Nil(t, err)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&dials))
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	config := DefaultConfig()
+	config.ReconnectMinInterval = 100 * time.Millisecond
+	config.ReconnectMaxInterval = time.Second
+	for failures, expected := range map[uint]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 4: 800 * time.Millisecond, 5: time.Second, 100: time.Second} {
+		backoff := reconnectBackoff(config, failures)
+		assert.True(t, backoff >= expected*8/10 && backoff <= expected*12/10, "failures %d, backoff %v", failures, backoff)
+	}
+}
+
+func writeTestKeyPair(t *testing.T, dir string) (certFile string, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	assert.Nil(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.Nil(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certFile, keyFile
+}
+
+// handleTLS answers the heartbeats and responds "ok" to the requests
+// newTLSTestEndpoint returns the initialized endpoint of the tls test server with one connection
+func newTLSTestEndpoint(port int, params map[string]string) *MotanEndpoint {
+	url := &motan.URL{Host: "127.0.0.1", Port: port, Protocol: "motan2", Parameters: params}
+	url.PutParam(motan.TimeOutKey, "1000")
+	url.PutParam(motan.ClientConnectionKey, "1")
+	ep := &MotanEndpoint{}
+	ep.SetURL(url)
+	ep.SetSerialization(&serialize.SimpleSerialization{})
+	ep.Initialize()
+	return ep
+}
+
+func handleTLS(lis net.Listener) {
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			reader := bufio.NewReader(conn)
+			for {
+				msg, err := protocol.Decode(reader)
+				if err != nil {
+					return
+				}
+				res := protocol.BuildHeartbeat(msg.Header.RequestID, protocol.Res)
+				if !msg.Header.IsHeartbeat() {
+					res, _ = protocol.ConvertToResMessage(&motan.MotanResponse{RequestID: msg.Header.RequestID, Value: "ok"},
+						&serialize.SimpleSerialization{})
+				}
+				if _, err = conn.Write(res.Encode().Bytes()); err != nil {
+					return
+				}
+			}
+		}(conn)
+	}
+}
+
 func StartTestServer(port int) *MockServer {
 	m := &MockServer{Port: port}
 	m.Start()
@@ -0,0 +1,177 @@
+package endpoint
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+	mpro "github.com/weibocom/motan-go/protocol"
+)
+
+// StreamTimeoutKey is the url parameter of the max lifetime(ms) of a stream, default 5 minutes
+const StreamTimeoutKey = "streamTimeout"
+
+const (
+	defaultStreamTimeout = 5 * time.Minute
+	// the frames received are buffered, the connection is not read when the buffer is full until the stream receives
+	streamFrameBufferSize = 64
+)
+
+// clientStream is the stream opened by a request, the frames of the server are received by the Stream of the request
+// until the final response
+type clientStream struct {
+	stream        *Stream
+	serialization motan.Serialization
+	final         *mpro.Message
+	finalErr      error
+	sendEnd       bool
+}
+
+// OpenStream sends the request to open a stream, the provider of the request should be a motan.StreamProvider
+func (m *MotanEndpoint) OpenStream(request motan.Request) (motan.Stream, error) {
+	if m.channels == nil {
+		return nil, errors.New("motanEndpoint error: channels is null")
+	}
+	if m.serialization == nil {
+		return nil, errors.New("motanEndpoint error: no serialization")
+	}
+	rc := request.GetRPCContext(true)
+	rc.Proxy = m.proxy
+	channel, err := m.channels.Get()
+	if err != nil {
+		m.recordErrAndKeepalive()
+		return nil, errors.New("can not get a channel")
+	}
+	if group := GetRequestGroup(request); group != m.url.Group && m.url.Group != "" {
+		request.SetAttachment(mpro.MGroup, m.url.Group)
+	}
+	msg, err := mpro.ConvertToReqMessage(request, m.serialization)
+	if err != nil {
+		return nil, errors.New("convert motan request fail: " + err.Error())
+	}
+	msg.Metadata.Store(mpro.MStream, "true")
+	s, err := channel.NewStream(msg, rc)
+	if err != nil {
+		return nil, err
+	}
+	s.frameCh = make(chan *mpro.Message, streamFrameBufferSize)
+	s.SetDeadline(m.url.GetTimeDuration(StreamTimeoutKey, time.Millisecond, defaultStreamTimeout))
+	if err = s.Send(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return &clientStream{stream: s, serialization: m.serialization}, nil
+}
+
+func (c *clientStream) Send(value interface{}) error {
+	if c.sendEnd {
+		return motan.ErrStreamClosed
+	}
+	body, err := c.serialization.Serialize(value)
+	if err != nil {
+		return err
+	}
+	return c.send(mpro.StreamFrameData, body)
+}
+
+func (c *clientStream) CloseSend() error {
+	if c.sendEnd {
+		return nil
+	}
+	c.sendEnd = true
+	return c.send(mpro.StreamFrameEnd, nil)
+}
+
+func (c *clientStream) send(frame string, body []byte) error {
+	if c.final != nil || c.finalErr != nil {
+		return motan.ErrStreamClosed
+	}
+	s := c.stream
+	msg := mpro.BuildStreamFrame(mpro.Req, s.sendMsg.Header.RequestID, c.serialization.GetSerialNum(), frame, body)
+	timer := time.NewTimer(s.deadline.Sub(time.Now()))
+	defer timer.Stop()
+	buf := msg.Encode()
+	select {
+	case s.channel.sendCh <- sendReady{data: buf.Bytes(), buf: buf}:
+		return nil
+	case <-timer.C:
+		motan.ReleaseBytesBuffer(buf)
+		return ErrSendRequestTimeout
+	case <-s.channel.shutdownCh:
+		motan.ReleaseBytesBuffer(buf)
+		return ErrChannelShutdown
+	}
+}
+
+func (c *clientStream) Recv(v interface{}) (interface{}, error) {
+	s := c.stream
+	// the frames are delivered before the final response, so they are received first
+	select {
+	case msg := <-s.frameCh:
+		return c.serialization.DeSerialize(msg.Body, v)
+	default:
+	}
+	if c.final != nil || c.finalErr != nil {
+		return nil, c.finish()
+	}
+	timer := time.NewTimer(s.deadline.Sub(time.Now()))
+	defer timer.Stop()
+	var canceled <-chan struct{}
+	if s.rc != nil && s.rc.Context != nil {
+		canceled = s.rc.Context.Done()
+	}
+	select {
+	case msg := <-s.frameCh:
+		return c.serialization.DeSerialize(msg.Body, v)
+	case <-s.recvNotifyCh:
+		c.final = s.recvMsg
+	case <-timer.C:
+		c.finalErr = ErrRecvRequestTimeout
+	case <-s.channel.shutdownCh:
+		c.finalErr = ErrChannelShutdown
+	case <-canceled:
+		c.finalErr = ErrRequestCanceled
+	}
+	s.Close()
+	return c.Recv(v)
+}
+
+// finish returns the result of the final response, io.EOF if it is succeeded
+func (c *clientStream) finish() error {
+	if c.finalErr != nil {
+		return c.finalErr
+	}
+	if c.final == nil {
+		return errors.New("recv err: recvMsg is nil")
+	}
+	response, err := mpro.ConvertToResponse(c.final, c.serialization)
+	if err != nil {
+		c.finalErr = err
+		return err
+	}
+	if e := response.GetException(); e != nil {
+		vlog.Warningf("stream finished with exception. ep:%s, err:%+v", c.stream.channel.address, e)
+		c.finalErr = errors.New(e.ErrMsg)
+		return c.finalErr
+	}
+	c.finalErr = io.EOF
+	return io.EOF
+}
+
+// notifyFrame delivers the frame of the stream, it is called by the receiving goroutine of the channel
+func (s *Stream) notifyFrame(msg *mpro.Message) {
+	if s.frameCh == nil {
+		vlog.Warningf("receive stream frame of a normal call: %d, ep:%s", msg.Header.RequestID, s.channel.address)
+		return
+	}
+	timer := time.NewTimer(s.deadline.Sub(time.Now()))
+	defer timer.Stop()
+	select {
+	case s.frameCh <- msg:
+	case <-timer.C:
+		vlog.Warningf("stream frame dropped, the stream is not received before the deadline: %d, ep:%s", msg.Header.RequestID, s.channel.address)
+	case <-s.channel.shutdownCh:
+	}
+}
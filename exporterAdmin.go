@@ -0,0 +1,146 @@
+package motan
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+	mserver "github.com/weibocom/motan-go/server"
+)
+
+// ServerAdminPortKey is the parameter of the motan-server section, the admin api of the exporters(see ExporterHandler)
+// is served on the port if it is set
+const ServerAdminPortKey = "adminPort"
+
+// ExporterInfo is the state of an exported service
+type ExporterInfo struct {
+	Identity   string                  `json:"identity"`
+	Path       string                  `json:"path"`
+	Group      string                  `json:"group"`
+	Protocol   string                  `json:"protocol"`
+	Port       int                     `json:"port"`
+	Available  bool                    `json:"available"`
+	Registries []mserver.RegistryState `json:"registries"`
+}
+
+// ExporterHandler lists the exported services with their registry states, and changes the availability of the
+// services in the registries, e.g. to drain the traffic of a service before a deploy:
+//
+//	GET  /exporter/list
+//	POST /exporter/unavailable?service=com.weibo.TestService[&group=xxx]
+//	POST /exporter/available?service=com.weibo.TestService[&group=xxx]
+type ExporterHandler struct {
+	exporters func() []motan.Exporter
+}
+
+func (e *ExporterHandler) SetAgent(agent *Agent) {
+	e.exporters = func() []motan.Exporter {
+		var exporters []motan.Exporter
+		agent.serviceExporters.Range(func(k, v interface{}) bool {
+			exporters = append(exporters, v.(motan.Exporter))
+			return true
+		})
+		return exporters
+	}
+}
+
+func (e *ExporterHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/exporter/list":
+		infos := make([]ExporterInfo, 0, 16)
+		for _, exporter := range e.exporters() {
+			infos = append(infos, exporterInfo(exporter))
+		}
+		sort.Slice(infos, func(i, j int) bool {
+			return infos[i].Identity < infos[j].Identity
+		})
+		writeHandlerResponse(w, http.StatusOK, "", infos)
+	case "/exporter/available", "/exporter/unavailable":
+		if r.Method != http.MethodPost {
+			writeHandlerResponse(w, http.StatusMethodNotAllowed, "only POST is supported", nil)
+			return
+		}
+		service := r.FormValue("service")
+		if service == "" {
+			writeHandlerResponse(w, http.StatusBadRequest, "service is empty", nil)
+			return
+		}
+		group := r.FormValue("group")
+		available := r.URL.Path == "/exporter/available"
+		var changed []string
+		for _, exporter := range e.exporters() {
+			url := exporter.GetURL()
+			if url.Path != service || (group != "" && url.Group != group) {
+				continue
+			}
+			if available {
+				exporter.Available()
+			} else {
+				exporter.Unavailable()
+			}
+			vlog.Infof("set availability of %s to %v by the admin api", url.GetIdentity(), available)
+			changed = append(changed, url.GetIdentity())
+		}
+		if len(changed) == 0 {
+			writeHandlerResponse(w, http.StatusNotFound, "service not exported: "+service, nil)
+			return
+		}
+		sort.Strings(changed)
+		writeHandlerResponse(w, http.StatusOK, "ok", changed)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func exporterInfo(exporter motan.Exporter) ExporterInfo {
+	url := exporter.GetURL()
+	info := ExporterInfo{
+		Identity:  url.GetIdentity(),
+		Path:      url.Path,
+		Group:     url.Group,
+		Protocol:  url.Protocol,
+		Port:      url.Port,
+		Available: exporter.IsAvailable(),
+	}
+	if s, ok := exporter.(interface {
+		GetRegistryStates() []mserver.RegistryState
+	}); ok {
+		info.Registries = s.GetRegistryStates()
+	}
+	return info
+}
+
+// startAdminServer serves the ExporterHandler of the exporters of the server context on the admin port
+func (m *MSContext) startAdminServer(port int) {
+	handler := &ExporterHandler{exporters: func() []motan.Exporter {
+		m.csync.Lock()
+		defer m.csync.Unlock()
+		exporters := make([]motan.Exporter, 0, len(m.exporters))
+		for _, exporter := range m.exporters {
+			exporters = append(exporters, exporter)
+		}
+		return exporters
+	}}
+	listener, err := motan.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		vlog.Errorf("listen admin port %d fail. err:%v", port, err)
+		return
+	}
+	m.adminListener = listener
+	mux := http.NewServeMux()
+	mux.HandleFunc("/exporter/", func(w http.ResponseWriter, r *http.Request) {
+		if !PermissionCheck(r) {
+			w.Write([]byte("need permission!"))
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+	vlog.Infof("start listen admin for address: %s", listener.Addr().String())
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			vlog.Warningf("admin server of port %d is stopped. err:%v", port, err)
+		}
+	}()
+}
@@ -3,6 +3,7 @@ package filter
 import (
 	"encoding/json"
 	"strconv"
+	"strings"
 	"time"
 
 	motan "github.com/weibocom/motan-go/core"
@@ -15,8 +16,27 @@ const (
 	serverAgentRole = "server-agent"
 )
 
+const (
+	// AccessLogAttachmentsKey is the url parameter of attachment keys logged by access log, separated by comma.
+	// the value of a key is taken from the request attachments, then the response attachments
+	AccessLogAttachmentsKey = "accessLogAttachments"
+	// AccessLogAttachmentMaxLengthKey is the url parameter of max logged length of each attachment value
+	AccessLogAttachmentMaxLengthKey = "accessLogAttachmentMaxLength"
+	// AccessLogFormatKey is the url parameter of the access log line format, vlog.AccessLogFormatJSON or vlog.AccessLogFormatTSV.
+	// the lines are formatted by the log_structured flag if it is absent
+	AccessLogFormatKey = "accessLogFormat"
+
+	defaultAccessLogAttachmentMaxLength = 64
+)
+
+// accessLog writes the access log entities, it is replaced in tests
+var accessLog = vlog.AccessLog
+
 type AccessLogFilter struct {
-	next motan.EndPointFilter
+	attachmentKeys      []string
+	attachmentMaxLength int
+	format              string
+	next                motan.EndPointFilter
 }
 
 func (t *AccessLogFilter) GetIndex() int {
@@ -28,7 +48,19 @@ func (t *AccessLogFilter) GetName() string {
 }
 
 func (t *AccessLogFilter) NewFilter(url *motan.URL) motan.Filter {
-	return &AccessLogFilter{}
+	f := &AccessLogFilter{attachmentMaxLength: int(url.GetPositiveIntValue(AccessLogAttachmentMaxLengthKey, defaultAccessLogAttachmentMaxLength))}
+	for _, k := range motan.TrimSplit(url.GetParam(AccessLogAttachmentsKey, ""), ",") {
+		if k != "" {
+			f.attachmentKeys = append(f.attachmentKeys, k)
+		}
+	}
+	switch format := url.GetParam(AccessLogFormatKey, ""); format {
+	case "", vlog.AccessLogFormatJSON, vlog.AccessLogFormatTSV:
+		f.format = format
+	default:
+		vlog.Warningf("illegal %s of %s: %s, the default format is used", AccessLogFormatKey, url.GetIdentity(), format)
+	}
+	return f
 }
 
 func (t *AccessLogFilter) Filter(caller motan.Caller, request motan.Request) motan.Response {
@@ -50,14 +82,41 @@ func (t *AccessLogFilter) Filter(caller motan.Caller, request motan.Request) mot
 		resCtx := response.GetRPCContext(true)
 		resCtx.AddFinishHandler(motan.FinishHandleFunc(func() {
 			totalTime := reqCtx.ResponseSendTime.Sub(reqCtx.RequestReceiveTime).Nanoseconds() / 1e6
-			doAccessLog(t.GetName(), role, address, totalTime, request, response)
+			doAccessLog(t.GetName(), role, address, totalTime, request, response, t.attachments(request, response), t.format)
 		}))
 	} else {
-		doAccessLog(t.GetName(), role, address, time.Now().Sub(start).Nanoseconds()/1e6, request, response)
+		doAccessLog(t.GetName(), role, address, time.Now().Sub(start).Nanoseconds()/1e6, request, response, t.attachments(request, response), t.format)
 	}
 	return response
 }
 
+// attachments formats the configured attachments as k1=v1,k2=v2, absent keys are skipped
+func (t *AccessLogFilter) attachments(request motan.Request, response motan.Response) string {
+	if len(t.attachmentKeys) == 0 {
+		return ""
+	}
+	var builder strings.Builder
+	for _, k := range t.attachmentKeys {
+		v := request.GetAttachment(k)
+		if v == "" {
+			v = response.GetAttachment(k)
+		}
+		if v == "" {
+			continue
+		}
+		if len(v) > t.attachmentMaxLength {
+			v = v[:t.attachmentMaxLength]
+		}
+		if builder.Len() > 0 {
+			builder.WriteByte(',')
+		}
+		builder.WriteString(k)
+		builder.WriteByte('=')
+		builder.WriteString(v)
+	}
+	return builder.String()
+}
+
 func (t *AccessLogFilter) HasNext() bool {
 	return t.next != nil
 }
@@ -74,7 +133,7 @@ func (t *AccessLogFilter) GetType() int32 {
 	return motan.EndPointFilterType
 }
 
-func doAccessLog(filterName string, role string, address string, totalTime int64, request motan.Request, response motan.Response) {
+func doAccessLog(filterName string, role string, address string, totalTime int64, request motan.Request, response motan.Response, attachments string, format string) {
 	exception := response.GetException()
 	reqCtx := request.GetRPCContext(true)
 	resCtx := response.GetRPCContext(true)
@@ -90,7 +149,7 @@ func doAccessLog(filterName string, role string, address string, totalTime int64
 			responseCode = "200"
 		}
 	}
-	vlog.AccessLog(&vlog.AccessLogEntity{
+	accessLog(&vlog.AccessLogEntity{
 		FilterName:    filterName,
 		Role:          role,
 		RequestID:     response.GetRequestID(),
@@ -104,5 +163,10 @@ func doAccessLog(filterName string, role string, address string, totalTime int64
 		TotalTime:     totalTime,                 //ms
 		ResponseCode:  responseCode,
 		Success:       exception == nil,
-		Exception:     string(exceptionData)})
+		Exception:     string(exceptionData),
+		Attachments:   attachments,
+		Format:        format})
+	if methodLogEnabled(request) {
+		doMethodLog(role, address, request, response)
+	}
 }
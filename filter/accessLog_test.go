@@ -3,9 +3,12 @@ package filter
 import (
 	"fmt"
 	"testing"
+	"time"
 
+	"github.com/stretchr/testify/assert"
 	motan "github.com/weibocom/motan-go/core"
 	"github.com/weibocom/motan-go/endpoint"
+	"github.com/weibocom/motan-go/log"
 	"github.com/weibocom/motan-go/protocol"
 	"github.com/weibocom/motan-go/provider"
 )
@@ -32,6 +35,93 @@ func TestFilter(t *testing.T) {
 	fmt.Printf("res:%+v", res)
 }
 
+func TestAccessLogAttachments(t *testing.T) {
+	url := mockURL()
+	url.PutParam(AccessLogAttachmentsKey, "tenant, caller,absent,rid")
+	url.PutParam(AccessLogAttachmentMaxLengthKey, "4")
+	f := (&AccessLogFilter{}).NewFilter(url).(*AccessLogFilter)
+	request := defaultRequest()
+	request.SetAttachment("tenant", "t1")
+	request.SetAttachment("caller", "application")
+	request.SetAttachment("token", "secret")
+	response := &motan.MotanResponse{}
+	response.SetAttachment("rid", "r1")
+	assert.Equal(t, "tenant=t1,caller=appl,rid=r1", f.attachments(request, response))
+	assert.Equal(t, "", (&AccessLogFilter{}).NewFilter(mockURL()).(*AccessLogFilter).attachments(request, response))
+}
+
+func TestProviderAccessLog(t *testing.T) {
+	var entities []*vlog.AccessLogEntity
+	accessLog = func(entity *vlog.AccessLogEntity) { entities = append(entities, entity) }
+	defer func() { accessLog = vlog.AccessLog }()
+	url := mockURL()
+	url.PutParam(AccessLogFormatKey, vlog.AccessLogFormatTSV)
+	f := (&AccessLogFilter{}).NewFilter(url).(*AccessLogFilter)
+	f.SetNext(motan.GetLastEndPointFilter())
+	p := &Provider{url: url, handler: func(request motan.Request) motan.Response {
+		if request.GetMethod() == "fail" {
+			return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 503, ErrMsg: "unavailable", ErrType: motan.ServiceException})
+		}
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: "ok"}
+	}}
+
+	for i, method := range []string{testMethod, "fail"} {
+		request := getRequest(testService, testGroup, method)
+		request.RequestID = uint64(i + 1)
+		request.SetAttachment(motan.HostKey, "10.0.0.1")
+		reqCtx := request.GetRPCContext(true)
+		reqCtx.RequestReceiveTime = time.Now()
+		response := f.Filter(p, request)
+		assert.Equal(t, i, len(entities), "provider access log is written when the response is sent")
+		reqCtx.ResponseSendTime = reqCtx.RequestReceiveTime.Add(5 * time.Millisecond)
+		response.GetRPCContext(true).OnFinish()
+	}
+	assert.Equal(t, 2, len(entities))
+	assert.Equal(t, uint64(1), entities[0].RequestID)
+	assert.Equal(t, serverAgentRole, entities[0].Role)
+	assert.Equal(t, testService, entities[0].Service)
+	assert.Equal(t, testMethod, entities[0].Method)
+	assert.Equal(t, "10.0.0.1:7888", entities[0].RemoteAddress)
+	assert.Equal(t, int64(5), entities[0].TotalTime)
+	assert.Equal(t, "200", entities[0].ResponseCode)
+	assert.True(t, entities[0].Success)
+	assert.Equal(t, vlog.AccessLogFormatTSV, entities[0].Format)
+	assert.Equal(t, uint64(2), entities[1].RequestID)
+	assert.Equal(t, "503", entities[1].ResponseCode)
+	assert.False(t, entities[1].Success)
+	assert.Contains(t, entities[1].Exception, "unavailable")
+
+	// the illegal format is ignored
+	url.PutParam(AccessLogFormatKey, "xml")
+	assert.Equal(t, "", (&AccessLogFilter{}).NewFilter(url).(*AccessLogFilter).format)
+}
+
+func TestMethodLogToggle(t *testing.T) {
+	request := defaultRequest()
+	assert.False(t, methodLogEnabled(request))
+	EnableMethodLog(request.GetServiceName(), request.GetMethod(), time.Minute)
+	assert.True(t, methodLogEnabled(request))
+	toggles := GetMethodLogToggles()
+	assert.Equal(t, 1, len(toggles))
+	assert.Equal(t, request.GetMethod(), toggles[0].Method)
+
+	// the access log filter logs the payload
+	f := (&AccessLogFilter{}).NewFilter(mockURL()).(*AccessLogFilter)
+	f.SetNext(motan.GetLastEndPointFilter())
+	factory := initFactory()
+	f.Filter(factory.GetEndPoint(mockURL()), request)
+
+	EnableMethodLog(request.GetServiceName(), "expired", time.Millisecond)
+	expired := defaultRequest()
+	expired.Method = "expired"
+	time.Sleep(5 * time.Millisecond)
+	assert.False(t, methodLogEnabled(expired))
+	DisableMethodLog(request.GetServiceName(), request.GetMethod())
+	assert.False(t, methodLogEnabled(request))
+	assert.Equal(t, 0, len(GetMethodLogToggles()))
+	assert.Equal(t, int32(0), methodLogCount)
+}
+
 func initFactory() motan.ExtensionFactory {
 	defaultExtFactory := &motan.DefaultExtensionFactory{}
 	defaultExtFactory.Initialize()
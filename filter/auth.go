@@ -0,0 +1,442 @@
+package filter
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+	"github.com/weibocom/motan-go/protocol"
+)
+
+// the attachments of the credentials
+const (
+	AuthTimestampKey = "auth_ts"    // unix seconds of the hmac signature
+	AuthNonceKey     = "auth_nonce" // the request id of the call, or a random value if the request has no id
+	AuthSignatureKey = "auth_sign"  // hex hmac-sha256 of "application\nservice\nmethod\ntimestamp\nnonce\narguments hash"
+	AuthTokenKey     = "auth_token"
+)
+
+// the url parameters of AuthFilter
+const (
+	AuthModeKey  = "authMode"  // the credentials accepted by the providers: hmac(default), jwt or any
+	AuthAllowKey = "authAllow" // the callers allowed to call the service, all authenticated callers if it is empty
+	AuthDenyKey  = "authDeny"  // the callers denied, it is checked before the allow list
+)
+
+const (
+	AuthModeHMAC = "hmac"
+	AuthModeJWT  = "jwt"
+	AuthModeAny  = "any"
+)
+
+// the exception codes of the calls rejected by AuthFilter
+const (
+	AuthUnauthenticatedCode = 401
+	AuthForbiddenCode       = 403
+)
+
+const (
+	defaultAuthMaxSkew  = 300
+	defaultAuthMaxNonce = 500000
+	defaultAuthJWTClaim = "sub"
+)
+
+// AuthConfig is the "auth" section of the yaml, e.g.
+//
+//	auth:
+//	  application: my-app  # the identity of the calls of the clients
+//	  secret: my-key       # the hmac key of the calls of the clients
+//	  token: xxx           # the jwt of the calls of the clients, it is sent instead of the hmac signature
+//	  secrets:             # the hmac keys of the callers of the providers
+//	    app1: key1
+//	  jwtSecret: xxx       # the HS256 key of the jwt of the callers
+//	  maxSkew: 300         # the max difference(seconds) of the timestamps of the signatures
+//	  maxNonces: 500000    # the max signatures of a caller accepted in twice the max skew
+//	  acls:
+//	    com.weibo.TestService:
+//	      allow: [app1]
+//	      deny: [app2]
+type AuthConfig struct {
+	Application string
+	Secret      string
+	Token       string
+	Secrets     map[string]string
+	JWTSecret   string
+	JWTClaim    string // the claim of the identity, default sub
+	MaxSkew     int    // the max difference(seconds) of the timestamps of the signatures, default 300
+	// the max nonces of a caller kept in a generation(twice the max skew), default 500000. the signatures of the caller
+	// are rejected until the generation is rotated if it is reached, so the memory of the nonces is bounded by the
+	// callers of the secrets. the callers of more qps than maxNonces/(2*maxSkew) should lower the maxSkew
+	MaxNonces int
+	ACLs      map[string]AuthACL
+}
+
+// AuthACL is the callers allow/deny lists of a service
+type AuthACL struct {
+	Allow []string
+	Deny  []string
+}
+
+type authACLSet struct {
+	allow map[string]bool
+	deny  map[string]bool
+}
+
+func newAuthACLSet(acl AuthACL) *authACLSet {
+	return &authACLSet{allow: newAuthCallers(acl.Allow), deny: newAuthCallers(acl.Deny)}
+}
+
+func newAuthCallers(callers []string) map[string]bool {
+	set := make(map[string]bool, len(callers))
+	for _, c := range callers {
+		if c = strings.TrimSpace(c); c != "" {
+			set[c] = true
+		}
+	}
+	return set
+}
+
+// authState is the config with the parsed acls
+type authState struct {
+	config *AuthConfig
+	acls   map[string]*authACLSet
+}
+
+var (
+	authStateValue atomic.Value // *authState
+	// the acls set at runtime by service, they override the acls of the yaml and the url
+	authACLs = core.NewCopyOnWriteMap()
+	authOnce sync.Once
+	// the nonces of the accepted signatures, the signatures are rejected if they are replayed
+	authNonces = &authNonceSet{current: make(map[string]map[string]bool)}
+)
+
+// authNonceSet keeps the nonces of the callers for two generations, a generation lasts for the twice max skew, so the
+// nonces are kept until the timestamps of them expire. a caller has at most maxNonces nonces in a generation
+type authNonceSet struct {
+	lock     sync.Mutex
+	current  map[string]map[string]bool // the nonces by the callers
+	previous map[string]map[string]bool
+	rotated  time.Time
+}
+
+// add returns the message of the failure if the nonce has been added or the nonces of the caller are full
+func (n *authNonceSet) add(application string, nonce string, maxSkew int64, maxNonces int) string {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	now := time.Now()
+	if now.Sub(n.rotated) > 2*time.Duration(maxSkew)*time.Second {
+		n.previous, n.current, n.rotated = n.current, make(map[string]map[string]bool, len(n.current)), now
+	}
+	if n.current[application][nonce] || n.previous[application][nonce] {
+		return "replayed signature"
+	}
+	nonces := n.current[application]
+	if nonces == nil {
+		nonces = make(map[string]bool)
+		n.current[application] = nonces
+	}
+	if len(nonces) >= maxNonces {
+		return "too many signatures of caller " + application
+	}
+	nonces[nonce] = true
+	return ""
+}
+
+// SetAuthConfig replaces the credentials and the acls of AuthFilter
+func SetAuthConfig(config *AuthConfig) {
+	state := &authState{config: config, acls: make(map[string]*authACLSet, len(config.ACLs))}
+	for service, acl := range config.ACLs {
+		state.acls[service] = newAuthACLSet(acl)
+	}
+	authStateValue.Store(state)
+}
+
+func getAuthState() *authState {
+	if s, ok := authStateValue.Load().(*authState); ok {
+		return s
+	}
+	return &authState{config: &AuthConfig{}}
+}
+
+// SetAuthACL replaces the acl of a service at runtime, e.g. with the acls pushed from a registry. nil removes the acl set
+func SetAuthACL(service string, acl *AuthACL) {
+	if acl == nil {
+		authACLs.Delete(service)
+	} else {
+		authACLs.Store(service, newAuthACLSet(*acl))
+	}
+	vlog.Infof("[auth] acl of %s is changed to %+v", service, acl)
+}
+
+// AuthSign returns the hmac signature of a call, the argumentsHash is returned by AuthArgumentsHash
+func AuthSign(secret string, application string, service string, method string, timestamp string, nonce string, argumentsHash string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(application + "\n" + service + "\n" + method + "\n" + timestamp + "\n" + nonce + "\n" + argumentsHash))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// AuthArgumentsHash returns the hex sha256 of the serialized arguments of a request, the arguments of the clients are
+// serialized by the serialization, and the arguments of the providers are serialized by the clients
+func AuthArgumentsHash(request core.Request, serialization core.Serialization) (string, error) {
+	var body []byte
+	arguments := request.GetArguments()
+	if len(arguments) == 1 {
+		if v, ok := arguments[0].(*core.DeserializableValue); ok {
+			body = v.Body
+		} else if b, ok := arguments[0].([]byte); ok && request.GetRPCContext(true).Serialized {
+			body = b
+		}
+	}
+	if body == nil && len(arguments) > 0 {
+		if serialization == nil {
+			return "", errors.New("no serialization for the arguments")
+		}
+		b, err := serialization.SerializeMulti(arguments)
+		if err != nil {
+			return "", err
+		}
+		body = b
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// AuthFilter authenticates the callers of the providers by the hmac signatures or the jwt of the requests, and checks
+// the allow/deny lists of the services. the identity of the caller replaces the application of the request(M_s), so
+// the filters and the providers after it see the authenticated caller. the hmac signatures cover the nonce and the
+// serialized arguments, and a signature is accepted only once. for the endpoints it adds the credentials of the auth
+// config to the requests
+type AuthFilter struct {
+	url           *core.URL
+	mode          string
+	acl           *authACLSet // the acl of the url
+	extFactory    core.ExtensionFactory
+	serialization core.Serialization // the serialization of the endpoint to hash the arguments
+	next          core.EndPointFilter
+}
+
+func (a *AuthFilter) NewFilter(url *core.URL) core.Filter {
+	ret := &AuthFilter{url: url, mode: url.GetParam(AuthModeKey, AuthModeHMAC), extFactory: a.extFactory}
+	if a.extFactory != nil {
+		ret.serialization = core.GetSerialization(url, a.extFactory)
+	}
+	if url.GetParam(AuthAllowKey, "") != "" || url.GetParam(AuthDenyKey, "") != "" {
+		ret.acl = newAuthACLSet(AuthACL{Allow: core.TrimSplit(url.GetParam(AuthAllowKey, ""), ","), Deny: core.TrimSplit(url.GetParam(AuthDenyKey, ""), ",")})
+	}
+	return ret
+}
+
+// SetContext loads the auth section of the config once
+func (a *AuthFilter) SetContext(context *core.Context) {
+	authOnce.Do(func() {
+		var config AuthConfig
+		if context.Config == nil || context.Config.GetStruct("auth", &config) != nil {
+			return
+		}
+		SetAuthConfig(&config)
+	})
+}
+
+func (a *AuthFilter) Filter(caller core.Caller, request core.Request) core.Response {
+	if _, ok := caller.(core.Provider); !ok {
+		a.sign(request)
+		return a.GetNext().Filter(caller, request)
+	}
+	state := getAuthState()
+	identity, msg := a.authenticate(state.config, request)
+	if msg != "" {
+		return a.reject(request, AuthUnauthenticatedCode, msg)
+	}
+	if !a.allowed(state, request.GetServiceName(), identity) {
+		return a.reject(request, AuthForbiddenCode, "caller "+identity+" is not allowed to call service "+request.GetServiceName())
+	}
+	request.SetAttachment(protocol.MSource, identity)
+	return a.GetNext().Filter(caller, request)
+}
+
+func (a *AuthFilter) sign(request core.Request) {
+	config := getAuthState().config
+	if config.Token != "" {
+		request.SetAttachment(AuthTokenKey, config.Token)
+		return
+	}
+	if config.Secret == "" {
+		return
+	}
+	application := config.Application
+	if application == "" {
+		application = request.GetAttachment(protocol.MSource)
+	} else {
+		request.SetAttachment(protocol.MSource, application)
+	}
+	argumentsHash, err := AuthArgumentsHash(request, a.serialization)
+	if err != nil {
+		vlog.Warningf("[auth] sign request fail. req:%s, err:%v", core.GetReqInfo(request), err)
+		return
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := strconv.FormatUint(request.GetRequestID(), 10)
+	if request.GetRequestID() == 0 {
+		b := make([]byte, 8)
+		rand.Read(b)
+		nonce = hex.EncodeToString(b)
+	}
+	request.SetAttachment(AuthTimestampKey, timestamp)
+	request.SetAttachment(AuthNonceKey, nonce)
+	request.SetAttachment(AuthSignatureKey, AuthSign(config.Secret, application, request.GetServiceName(), request.GetMethod(), timestamp, nonce, argumentsHash))
+}
+
+// authenticate returns the identity of the caller, or the message of the failure
+func (a *AuthFilter) authenticate(config *AuthConfig, request core.Request) (string, string) {
+	if token := request.GetAttachment(AuthTokenKey); token != "" && a.mode != AuthModeHMAC {
+		return verifyJWT(config, token)
+	}
+	if a.mode == AuthModeJWT {
+		return "", "missing token"
+	}
+	application := request.GetAttachment(protocol.MSource)
+	signature := request.GetAttachment(AuthSignatureKey)
+	nonce := request.GetAttachment(AuthNonceKey)
+	if application == "" || signature == "" || nonce == "" {
+		return "", "missing signature"
+	}
+	timestamp := request.GetAttachment(AuthTimestampKey)
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", "illegal timestamp"
+	}
+	maxSkew := int64(config.MaxSkew)
+	if maxSkew <= 0 {
+		maxSkew = defaultAuthMaxSkew
+	}
+	if skew := time.Now().Unix() - ts; skew > maxSkew || skew < -maxSkew {
+		return "", "signature expired"
+	}
+	secret, ok := config.Secrets[application]
+	if !ok {
+		return "", "unknown caller " + application
+	}
+	argumentsHash, err := AuthArgumentsHash(request, nil)
+	if err != nil {
+		return "", "illegal arguments"
+	}
+	expected := AuthSign(secret, application, request.GetServiceName(), request.GetMethod(), timestamp, nonce, argumentsHash)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return "", "illegal signature"
+	}
+	// the nonce is only recorded for the valid signatures, so the nonces of the callers can not be taken by others
+	maxNonces := config.MaxNonces
+	if maxNonces <= 0 {
+		maxNonces = defaultAuthMaxNonce
+	}
+	if msg := authNonces.add(application, nonce, maxSkew, maxNonces); msg != "" {
+		return "", msg
+	}
+	return application, ""
+}
+
+func (a *AuthFilter) allowed(state *authState, service string, identity string) bool {
+	var acl *authACLSet
+	if v, ok := authACLs.Load(service); ok {
+		acl = v.(*authACLSet)
+	} else if v, ok := state.acls[service]; ok {
+		acl = v
+	} else {
+		acl = a.acl
+	}
+	if acl == nil {
+		return true
+	}
+	if acl.deny[identity] {
+		return false
+	}
+	return len(acl.allow) == 0 || acl.allow[identity] || acl.allow["*"]
+}
+
+func (a *AuthFilter) reject(request core.Request, code int, msg string) core.Response {
+	vlog.Warningf("[auth] reject request. %s, req:%s", msg, core.GetReqInfo(request))
+	return core.BuildExceptionResponse(request.GetRequestID(), &core.Exception{ErrCode: code, ErrMsg: msg, ErrType: core.ServiceException})
+}
+
+// verifyJWT verifies a HS256 jwt, it returns the identity of the claim
+func verifyJWT(config *AuthConfig, token string) (string, string) {
+	if config.JWTSecret == "" {
+		return "", "jwt is not supported"
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", "illegal token"
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if data, err := base64.RawURLEncoding.DecodeString(parts[0]); err != nil || json.Unmarshal(data, &header) != nil {
+		return "", "illegal token header"
+	}
+	if header.Alg != "HS256" {
+		return "", "unsupported token algorithm " + header.Alg
+	}
+	mac := hmac.New(sha256.New, []byte(config.JWTSecret))
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(mac.Sum(nil), signature) {
+		return "", "illegal token signature"
+	}
+	var claims map[string]interface{}
+	if data, err := base64.RawURLEncoding.DecodeString(parts[1]); err != nil || json.Unmarshal(data, &claims) != nil {
+		return "", "illegal token claims"
+	}
+	now := float64(time.Now().Unix())
+	if exp, ok := claims["exp"].(float64); ok && now >= exp {
+		return "", "token expired"
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now < nbf {
+		return "", "token not valid yet"
+	}
+	claim := config.JWTClaim
+	if claim == "" {
+		claim = defaultAuthJWTClaim
+	}
+	identity, _ := claims[claim].(string)
+	if identity == "" {
+		return "", "missing token claim " + claim
+	}
+	return identity, ""
+}
+
+func (a *AuthFilter) SetNext(nextFilter core.EndPointFilter) {
+	a.next = nextFilter
+}
+
+func (a *AuthFilter) GetNext() core.EndPointFilter {
+	return a.next
+}
+
+func (a *AuthFilter) GetName() string {
+	return Auth
+}
+
+func (a *AuthFilter) HasNext() bool {
+	return a.next != nil
+}
+
+// GetIndex is smaller than the other provider filters, so the requests are authenticated first
+func (a *AuthFilter) GetIndex() int {
+	return 1
+}
+
+func (a *AuthFilter) GetType() int32 {
+	return core.EndPointFilterType
+}
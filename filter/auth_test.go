@@ -0,0 +1,179 @@
+package filter
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/protocol"
+	"github.com/weibocom/motan-go/serialize"
+)
+
+func newAuthFilter(params map[string]string) core.EndPointFilter {
+	factory := initFactory()
+	serialize.RegistDefaultSerializations(factory)
+	url := &core.URL{Host: "127.0.0.1", Port: 7888, Path: "authService", Parameters: params}
+	f := factory.GetFilter(Auth).NewFilter(url).(core.EndPointFilter)
+	f.SetNext(core.GetLastEndPointFilter())
+	return f
+}
+
+var authNonce uint64
+
+// signAuthRequest signs the request of the provider as the client does, the nonces differ from the request ids of the
+// requests signed by the client filter
+func signAuthRequest(request core.Request, secret string, application string, ts string) {
+	nonce := "test-" + strconv.FormatUint(atomic.AddUint64(&authNonce, 1), 10)
+	hash, _ := AuthArgumentsHash(request, nil)
+	request.SetAttachment(protocol.MSource, application)
+	request.SetAttachment(AuthTimestampKey, ts)
+	request.SetAttachment(AuthNonceKey, nonce)
+	request.SetAttachment(AuthSignatureKey, AuthSign(secret, application, request.GetServiceName(), request.GetMethod(), ts, nonce, hash))
+}
+
+func newAuthProvider() *Provider {
+	return &Provider{url: &core.URL{Path: "authService"}, handler: func(request core.Request) core.Response {
+		return &core.MotanResponse{RequestID: request.GetRequestID(), Value: request.GetAttachment(protocol.MSource)}
+	}}
+}
+
+func newJWT(secret string, claims string) string {
+	token := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + base64.RawURLEncoding.EncodeToString([]byte(claims))
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(token))
+	return token + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func authErrCode(res core.Response) int {
+	if res.GetException() == nil {
+		return 0
+	}
+	return res.GetException().ErrCode
+}
+
+// newAuthProviderRequest returns the request received by the provider of the signed client request
+func newAuthProviderRequest(request core.Request, args ...interface{}) core.Request {
+	serialization := &serialize.SimpleSerialization{}
+	body, _ := serialization.SerializeMulti(args)
+	return &core.MotanRequest{ServiceName: request.GetServiceName(), Method: request.GetMethod(), Attachment: request.GetAttachments().Copy(),
+		Arguments: []interface{}{&core.DeserializableValue{Serialization: serialization, Body: body}}}
+}
+
+func TestAuthFilter_HMAC(t *testing.T) {
+	defer SetAuthConfig(&AuthConfig{})
+	provider := newAuthProvider()
+	server := newAuthFilter(map[string]string{AuthDenyKey: "app3"})
+	client := newAuthFilter(nil)
+
+	// signed by the client filter, the arguments are serialized by the serialization of the endpoint
+	request := &core.MotanRequest{RequestID: 12, ServiceName: "authService", Method: "hello", Arguments: []interface{}{"a"}}
+	caller := &defaultParamsCaller{}
+	SetAuthConfig(&AuthConfig{Application: "app1", Secret: "key1", Secrets: map[string]string{"app1": "key1", "app3": "key3"}})
+	client.Filter(caller, request)
+	assert.Equal(t, "app1", caller.request.GetAttachment(protocol.MSource))
+	assert.Equal(t, "12", request.GetAttachment(AuthNonceKey))
+	assert.NotEqual(t, "", request.GetAttachment(AuthSignatureKey))
+	// the signature covers the arguments
+	assert.Equal(t, AuthUnauthenticatedCode, authErrCode(server.Filter(provider, newAuthProviderRequest(request, "b"))))
+	res := server.Filter(provider, newAuthProviderRequest(request, "a"))
+	assert.Nil(t, res.GetException())
+	assert.Equal(t, "app1", res.GetValue())
+	// the signature can not be replayed
+	res = server.Filter(provider, newAuthProviderRequest(request, "a"))
+	assert.Equal(t, AuthUnauthenticatedCode, authErrCode(res))
+	assert.Contains(t, res.GetException().ErrMsg, "replayed")
+
+	// the signature covers the method
+	request = &core.MotanRequest{ServiceName: "authService", Method: "hello"}
+	signAuthRequest(request, "key1", "app1", strconv.FormatInt(time.Now().Unix(), 10))
+	request.Method = "world"
+	assert.Equal(t, AuthUnauthenticatedCode, authErrCode(server.Filter(provider, request)))
+	request = &core.MotanRequest{ServiceName: "authService", Method: "hello"}
+	assert.Equal(t, AuthUnauthenticatedCode, authErrCode(server.Filter(provider, request)))
+
+	// expired
+	signAuthRequest(request, "key1", "app1", strconv.FormatInt(time.Now().Unix()-600, 10))
+	assert.Equal(t, AuthUnauthenticatedCode, authErrCode(server.Filter(provider, request)))
+
+	// unknown callers and the denied callers
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	signAuthRequest(request, "key2", "app2", ts)
+	assert.Equal(t, AuthUnauthenticatedCode, authErrCode(server.Filter(provider, request)))
+	signAuthRequest(request, "key3", "app3", ts)
+	assert.Equal(t, AuthForbiddenCode, authErrCode(server.Filter(provider, request)))
+
+	// the acl of the yaml and the acl set at runtime override the url
+	SetAuthConfig(&AuthConfig{Secrets: map[string]string{"app1": "key1", "app3": "key3"},
+		ACLs: map[string]AuthACL{"authService": {Allow: []string{"app3"}}}})
+	signAuthRequest(request, "key3", "app3", ts)
+	assert.Nil(t, server.Filter(provider, request).GetException())
+	SetAuthACL("authService", &AuthACL{Allow: []string{"app1"}})
+	signAuthRequest(request, "key3", "app3", ts)
+	assert.Equal(t, AuthForbiddenCode, authErrCode(server.Filter(provider, request)))
+	SetAuthACL("authService", nil)
+	signAuthRequest(request, "key3", "app3", ts)
+	assert.Nil(t, server.Filter(provider, request).GetException())
+}
+
+func TestAuthFilter_MaxNonces(t *testing.T) {
+	defer SetAuthConfig(&AuthConfig{})
+	provider := newAuthProvider()
+	server := newAuthFilter(nil)
+	SetAuthConfig(&AuthConfig{Secrets: map[string]string{"nonceApp1": "key1", "nonceApp2": "key2"}, MaxNonces: 2})
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	request := &core.MotanRequest{ServiceName: "authService", Method: "hello"}
+	for i := 0; i < 2; i++ {
+		signAuthRequest(request, "key1", "nonceApp1", ts)
+		assert.Nil(t, server.Filter(provider, request).GetException())
+	}
+	// the signatures of the caller are rejected if the nonces of it are full
+	signAuthRequest(request, "key1", "nonceApp1", ts)
+	res := server.Filter(provider, request)
+	assert.Equal(t, AuthUnauthenticatedCode, authErrCode(res))
+	assert.Contains(t, res.GetException().ErrMsg, "too many signatures")
+	// the other callers are not limited by the caller
+	signAuthRequest(request, "key2", "nonceApp2", ts)
+	assert.Nil(t, server.Filter(provider, request).GetException())
+}
+
+func TestAuthFilter_JWT(t *testing.T) {
+	defer SetAuthConfig(&AuthConfig{})
+	provider := newAuthProvider()
+	server := newAuthFilter(map[string]string{AuthModeKey: AuthModeJWT, AuthAllowKey: "app1"})
+	SetAuthConfig(&AuthConfig{JWTSecret: "secret"})
+	exp := strconv.FormatInt(time.Now().Unix()+60, 10)
+
+	request := &core.MotanRequest{ServiceName: "authService", Method: "hello"}
+	request.SetAttachment(protocol.MSource, "app2")
+	request.SetAttachment(AuthTokenKey, newJWT("secret", `{"sub":"app1","exp":`+exp+`}`))
+	res := server.Filter(provider, request)
+	assert.Nil(t, res.GetException())
+	// the identity of the token replaces the application
+	assert.Equal(t, "app1", res.GetValue())
+
+	for _, token := range []string{
+		newJWT("other", `{"sub":"app1"}`),
+		newJWT("secret", `{"sub":"app1","exp":1}`),
+		newJWT("secret", `{"name":"app1"}`),
+		"illegal",
+	} {
+		request.SetAttachment(AuthTokenKey, token)
+		assert.Equal(t, AuthUnauthenticatedCode, authErrCode(server.Filter(provider, request)), token)
+	}
+	request.SetAttachment(AuthTokenKey, newJWT("secret", `{"sub":"app2"}`))
+	assert.Equal(t, AuthForbiddenCode, authErrCode(server.Filter(provider, request)))
+	request.GetAttachments().Delete(AuthTokenKey)
+	assert.Equal(t, AuthUnauthenticatedCode, authErrCode(server.Filter(provider, request)))
+
+	// the token of the client
+	SetAuthConfig(&AuthConfig{Token: "token"})
+	caller := &defaultParamsCaller{}
+	newAuthFilter(nil).Filter(caller, request)
+	assert.Equal(t, "token", caller.request.GetAttachment(AuthTokenKey))
+}
@@ -2,7 +2,9 @@ package filter
 
 import (
 	"errors"
+	"math"
 	"strconv"
+	"time"
 
 	"github.com/afex/hystrix-go/hystrix"
 	motan "github.com/weibocom/motan-go/core"
@@ -14,12 +16,24 @@ const (
 	SleepWindowField            = "circuitBreaker.sleepWindow"  //ms
 	ErrorPercentThreshold       = "circuitBreaker.errorPercent" //%
 	IncludeBizException         = "circuitBreaker.bizException"
+	StatWindowField             = "circuitBreaker.statWindow"     // ms of the error percent, default 10s
+	HalfOpenProbesField         = "circuitBreaker.halfOpenProbes" // the concurrent probes and the successful probes to close a half-open circuit, default 1
+
+	// the hystrix names of the thresholds, RequestVolumeThresholdField and ErrorPercentThreshold take precedence
+	RequestVolumeThresholdKey = "circuitBreaker.requestVolumeThreshold"
+	ErrorPercentThresholdKey  = "circuitBreaker.errorPercentThreshold"
 )
 
+// circuitOpenErrCode is the error code of the requests rejected by an open circuit
+const circuitOpenErrCode = 503
+
+// CircuitBreakerFilter breaks the circuit of each endpoint by the error percent of its requests, see endpointCircuit.
+// the states of the circuits are reported by GetCircuitBreakerStates and GetCircuitBreakerEvents, the requests are
+// limited by the timeout and the max concurrency of hystrix too
 type CircuitBreakerFilter struct {
 	url                 *motan.URL
 	next                motan.EndPointFilter
-	circuitBreaker      *hystrix.CircuitBreaker
+	circuit             *endpointCircuit
 	includeBizException bool
 }
 
@@ -32,20 +46,52 @@ func (c *CircuitBreakerFilter) GetName() string {
 }
 
 func (c *CircuitBreakerFilter) NewFilter(url *motan.URL) motan.Filter {
-	bizException := newCircuitBreaker(c.GetName(), url)
-	return &CircuitBreakerFilter{url: url, includeBizException: bizException}
+	bizException := parseIncludeBizException(c.GetName(), url)
+	commandConfig := buildCommandConfig(c.GetName(), url)
+	config := circuitConfig{
+		requestThreshold: hystrix.DefaultVolumeThreshold,
+		errorPercent:     hystrix.DefaultErrorPercentThreshold,
+		sleepWindow:      time.Duration(hystrix.DefaultSleepWindow) * time.Millisecond,
+		statWindow:       url.GetTimeDuration(StatWindowField, time.Millisecond, defaultCircuitStatWindow),
+		halfOpenProbes:   int(url.GetPositiveIntValue(HalfOpenProbesField, defaultCircuitHalfOpenProbes)),
+	}
+	if commandConfig.RequestVolumeThreshold > 0 {
+		config.requestThreshold = commandConfig.RequestVolumeThreshold
+	}
+	if commandConfig.ErrorPercentThreshold > 0 {
+		config.errorPercent = commandConfig.ErrorPercentThreshold
+	}
+	if commandConfig.SleepWindow > 0 {
+		config.sleepWindow = time.Duration(commandConfig.SleepWindow) * time.Millisecond
+	}
+	if config.statWindow <= 0 {
+		config.statWindow = defaultCircuitStatWindow
+	}
+	vlog.Infof("[%s] new circuit success. url:%v, config{%s}", c.GetName(), url.GetIdentity(),
+		getConfigStr(commandConfig)+"bizException:"+strconv.FormatBool(bizException)+" halfOpenProbes:"+strconv.Itoa(config.halfOpenProbes))
+	// the circuit of hystrix never opens, the circuit of the endpoint breaks the requests
+	commandConfig.RequestVolumeThreshold = math.MaxInt32
+	hystrix.ConfigureCommand(url.GetIdentity(), *commandConfig)
+	return &CircuitBreakerFilter{url: url, includeBizException: bizException, circuit: getEndpointCircuit(url, config)}
 }
 
 func (c *CircuitBreakerFilter) Filter(caller motan.Caller, request motan.Request) motan.Response {
-	var response motan.Response
-	err := hystrix.Do(c.url.GetIdentity(), func() error {
-		response = c.GetNext().Filter(caller, request)
-		return checkException(response, c.includeBizException)
-	}, nil)
-	if err != nil {
-		return defaultErrMotanResponse(request, err.Error())
+	allowed, probe := c.circuit.allow()
+	if !allowed {
+		return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: circuitOpenErrCode, ErrMsg: hystrix.ErrCircuitOpen.Error(), ErrType: motan.ServiceException})
 	}
-	return response
+	response, err := doCircuit(c.url.GetIdentity(), c.includeBizException, func() motan.Response {
+		return c.GetNext().Filter(caller, request)
+	})
+	switch err {
+	case nil:
+		c.circuit.record(probe, circuitSuccess)
+	case hystrix.ErrMaxConcurrency:
+		c.circuit.record(probe, circuitIgnored)
+	default:
+		c.circuit.record(probe, circuitFailure)
+	}
+	return circuitBreakerResponse(request, response, err)
 }
 
 func (c *CircuitBreakerFilter) HasNext() bool {
@@ -64,16 +110,21 @@ func (c *CircuitBreakerFilter) GetType() int32 {
 	return motan.EndPointFilterType
 }
 
-func newCircuitBreaker(filterName string, url *motan.URL) bool {
-	bizExceptionStr := url.GetParam(IncludeBizException, "true")
-	bizException, err := strconv.ParseBool(bizExceptionStr)
+func parseIncludeBizException(filterName string, url *motan.URL) bool {
+	bizException, err := strconv.ParseBool(url.GetParam(IncludeBizException, "true"))
 	if err != nil {
 		bizException = true
 		vlog.Warningf("[%s] parse config %s error, use default", filterName, IncludeBizException)
 	}
+	return bizException
+}
+
+func newCircuitBreaker(filterName string, url *motan.URL) bool {
+	bizException := parseIncludeBizException(filterName, url)
+	bizExceptionStr := strconv.FormatBool(bizException)
 	commandConfig := buildCommandConfig(filterName, url)
 	hystrix.ConfigureCommand(url.GetIdentity(), *commandConfig)
-	if _, _, err = hystrix.GetCircuit(url.GetIdentity()); err != nil {
+	if _, _, err := hystrix.GetCircuit(url.GetIdentity()); err != nil {
 		vlog.Errorf("[%s] new circuit fail. err:%s, url:%v, config{%s}", err.Error(), filterName, url.GetIdentity(), getConfigStr(commandConfig)+"bizException:"+bizExceptionStr)
 	} else {
 		vlog.Infof("[%s] new circuit success. url:%v, config{%s}", filterName, url.GetIdentity(), getConfigStr(commandConfig)+"bizException:"+bizExceptionStr)
@@ -85,7 +136,7 @@ func buildCommandConfig(filterName string, url *motan.URL) *hystrix.CommandConfi
 	hystrix.DefaultMaxConcurrent = 1000
 	hystrix.DefaultTimeout = int(url.GetPositiveIntValue(motan.TimeOutKey, int64(hystrix.DefaultTimeout))) * 2
 	commandConfig := &hystrix.CommandConfig{}
-	if v, ok := url.Parameters[RequestVolumeThresholdField]; ok {
+	if v, ok := circuitBreakerParam(url, RequestVolumeThresholdField, RequestVolumeThresholdKey); ok {
 		if temp, _ := strconv.Atoi(v); temp > 0 {
 			commandConfig.RequestVolumeThreshold = temp
 		} else {
@@ -99,7 +150,7 @@ func buildCommandConfig(filterName string, url *motan.URL) *hystrix.CommandConfi
 			vlog.Warningf("[%s] parse config %s error, use default", filterName, SleepWindowField)
 		}
 	}
-	if v, ok := url.Parameters[ErrorPercentThreshold]; ok {
+	if v, ok := circuitBreakerParam(url, ErrorPercentThreshold, ErrorPercentThresholdKey); ok {
 		if temp, _ := strconv.Atoi(v); temp > 0 && temp <= 100 {
 			commandConfig.ErrorPercentThreshold = temp
 		} else {
@@ -109,6 +160,45 @@ func buildCommandConfig(filterName string, url *motan.URL) *hystrix.CommandConfi
 	return commandConfig
 }
 
+func circuitBreakerParam(url *motan.URL, keys ...string) (string, bool) {
+	for _, k := range keys {
+		if v, ok := url.Parameters[k]; ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// circuitBreakerResponse returns the response of the provider if it is called, otherwise the exception of the circuit:
+// 503 if the circuit is open, and 400 if the call is timeout or the concurrency is exceeded
+// doCircuit calls the function by hystrix, the response is nil if the call is not finished, e.g. timeout, because the
+// function is still running in the goroutine of hystrix
+func doCircuit(name string, includeBizException bool, call func() motan.Response) (motan.Response, error) {
+	responses := make(chan motan.Response, 1)
+	err := hystrix.Do(name, func() error {
+		response := call()
+		responses <- response
+		return checkException(response, includeBizException)
+	}, nil)
+	if _, ok := err.(hystrix.CircuitError); ok {
+		return nil, err
+	}
+	return <-responses, err
+}
+
+func circuitBreakerResponse(request motan.Request, response motan.Response, err error) motan.Response {
+	if err == nil {
+		return response
+	}
+	if _, ok := err.(hystrix.CircuitError); !ok && response != nil {
+		return response
+	}
+	if err == hystrix.ErrCircuitOpen {
+		return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: circuitOpenErrCode, ErrMsg: err.Error(), ErrType: motan.ServiceException})
+	}
+	return defaultErrMotanResponse(request, err.Error())
+}
+
 func defaultErrMotanResponse(request motan.Request, errMsg string) motan.Response {
 	response := &motan.MotanResponse{
 		RequestID:   request.GetRequestID(),
@@ -0,0 +1,269 @@
+package filter
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+	"github.com/weibocom/motan-go/metrics"
+)
+
+// the states of the circuits of the endpoints
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "halfOpen"
+)
+
+// the metric keys of the state changes of the circuits, they are reported in the group and the service of the endpoints,
+// e.g. motan-client-circuit-breaker:127_0_0_1:8002.open_count
+const (
+	MetricsCircuitBreakerKeyPrefix = "motan-client-circuit-breaker:"
+	MetricsCircuitOpenSuffix       = ".open_count"
+	MetricsCircuitHalfOpenSuffix   = ".half_open_count"
+	MetricsCircuitClosedSuffix     = ".closed_count"
+)
+
+const (
+	defaultCircuitStatWindow     = 10 * time.Second
+	defaultCircuitHalfOpenProbes = 1
+	circuitStatBuckets           = 10
+	maxCircuitBreakerEvents      = 100
+)
+
+// CircuitBreakerState is the current state of the circuit of an endpoint
+type CircuitBreakerState struct {
+	Endpoint string    `json:"endpoint"` // the identity of the endpoint url
+	Service  string    `json:"service"`
+	Group    string    `json:"group"`
+	Address  string    `json:"address"`
+	State    string    `json:"state"`
+	Since    time.Time `json:"since"`
+	Requests int       `json:"requests"` // the requests in the stat window
+	Failures int       `json:"failures"`
+}
+
+// CircuitBreakerEvent is a state change of the circuit of an endpoint
+type CircuitBreakerEvent struct {
+	Endpoint string    `json:"endpoint"`
+	Service  string    `json:"service"`
+	Address  string    `json:"address"`
+	From     string    `json:"from"`
+	To       string    `json:"to"`
+	Time     time.Time `json:"time"`
+	Requests int       `json:"requests"`
+	Failures int       `json:"failures"`
+}
+
+type circuitOutcome int
+
+const (
+	circuitSuccess circuitOutcome = iota
+	circuitFailure
+	circuitIgnored // the request is not sent, e.g. rejected by the max concurrency
+)
+
+type circuitConfig struct {
+	requestThreshold int
+	errorPercent     int
+	sleepWindow      time.Duration
+	statWindow       time.Duration
+	halfOpenProbes   int
+}
+
+type circuitBucket struct {
+	slot     int64
+	requests int
+	failures int
+}
+
+// endpointCircuit opens if the error percent of the requests in the stat window reaches the threshold, the open
+// circuit rejects the requests for the sleep window and then turns half-open. the half-open circuit lets at most
+// halfOpenProbes requests through at a time, it is closed after halfOpenProbes successful probes, and opened again
+// by a failed probe
+type endpointCircuit struct {
+	identity string
+	service  string
+	group    string
+	address  string
+	lock     sync.Mutex
+	config   circuitConfig
+	state    string
+	since    time.Time
+	buckets  [circuitStatBuckets]circuitBucket
+	probing  int
+	probed   int
+	// increased by the state changes, so the probes of a previous half-open state are ignored
+	generation int64
+}
+
+var (
+	endpointCircuits     = make(map[string]*endpointCircuit)
+	endpointCircuitsLock sync.Mutex
+	circuitEvents        []CircuitBreakerEvent
+	circuitEventsLock    sync.Mutex
+)
+
+// getEndpointCircuit shares the circuit of the same endpoint, e.g. the endpoint is created again by the registry
+// notifications, the config of the shared circuit is updated
+func getEndpointCircuit(url *motan.URL, config circuitConfig) *endpointCircuit {
+	endpointCircuitsLock.Lock()
+	defer endpointCircuitsLock.Unlock()
+	c := endpointCircuits[url.GetIdentity()]
+	if c == nil {
+		c = &endpointCircuit{identity: url.GetIdentity(), service: url.Path, group: url.Group, address: url.GetAddressStr(),
+			state: CircuitClosed, since: time.Now()}
+		endpointCircuits[c.identity] = c
+	}
+	c.lock.Lock()
+	c.config = config
+	c.lock.Unlock()
+	return c
+}
+
+// allow returns whether the request can be sent, and the generation of the half-open circuit if it is a probe, 0 otherwise
+func (c *endpointCircuit) allow() (allowed bool, probe int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	switch c.state {
+	case CircuitClosed:
+		return true, 0
+	case CircuitOpen:
+		if time.Since(c.since) < c.config.sleepWindow {
+			return false, 0
+		}
+		c.changeState(CircuitHalfOpen)
+	}
+	if c.probing >= c.config.halfOpenProbes {
+		return false, 0
+	}
+	c.probing++
+	return true, c.generation
+}
+
+func (c *endpointCircuit) record(probe int64, outcome circuitOutcome) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if probe > 0 {
+		if probe != c.generation {
+			return
+		}
+		c.probing--
+		switch outcome {
+		case circuitFailure:
+			c.changeState(CircuitOpen)
+		case circuitSuccess:
+			if c.probed++; c.probed >= c.config.halfOpenProbes {
+				c.changeState(CircuitClosed)
+			}
+		}
+		return
+	}
+	if c.state != CircuitClosed || outcome == circuitIgnored {
+		return
+	}
+	slot := c.currentSlot()
+	bucket := &c.buckets[slot%circuitStatBuckets]
+	if bucket.slot != slot {
+		*bucket = circuitBucket{slot: slot}
+	}
+	bucket.requests++
+	if outcome == circuitFailure {
+		bucket.failures++
+	}
+	requests, failures := c.stat(slot)
+	if requests >= c.config.requestThreshold && failures*100 >= c.config.errorPercent*requests {
+		c.changeState(CircuitOpen)
+	}
+}
+
+func (c *endpointCircuit) currentSlot() int64 {
+	width := int64(c.config.statWindow) / circuitStatBuckets
+	if width <= 0 {
+		width = 1
+	}
+	return time.Now().UnixNano() / width
+}
+
+// stat returns the requests and the failures of the buckets in the stat window
+func (c *endpointCircuit) stat(slot int64) (requests int, failures int) {
+	for _, b := range c.buckets {
+		if b.slot > slot-circuitStatBuckets {
+			requests += b.requests
+			failures += b.failures
+		}
+	}
+	return requests, failures
+}
+
+// changeState is called with the lock held
+func (c *endpointCircuit) changeState(state string) {
+	requests, failures := c.stat(c.currentSlot())
+	event := CircuitBreakerEvent{Endpoint: c.identity, Service: c.service, Address: c.address, From: c.state, To: state,
+		Time: time.Now(), Requests: requests, Failures: failures}
+	c.state = state
+	c.since = event.Time
+	c.generation++
+	c.probing = 0
+	c.probed = 0
+	if state == CircuitClosed {
+		c.buckets = [circuitStatBuckets]circuitBucket{}
+	}
+	addCircuitEvent(event)
+	suffix := MetricsCircuitClosedSuffix
+	switch state {
+	case CircuitOpen:
+		suffix = MetricsCircuitOpenSuffix
+		vlog.Warningf("[%s] circuit of %s is open. requests:%d, failures:%d", CircuitBreaker, c.identity, requests, failures)
+	case CircuitHalfOpen:
+		suffix = MetricsCircuitHalfOpenSuffix
+		vlog.Infof("[%s] circuit of %s is half-open", CircuitBreaker, c.identity)
+	default:
+		vlog.Infof("[%s] circuit of %s is closed", CircuitBreaker, c.identity)
+	}
+	metrics.AddCounter(metrics.Escape(c.group), metrics.Escape(c.service), MetricsCircuitBreakerKeyPrefix+metrics.Escape(c.address)+suffix, 1)
+}
+
+func (c *endpointCircuit) getState() CircuitBreakerState {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	requests, failures := c.stat(c.currentSlot())
+	return CircuitBreakerState{Endpoint: c.identity, Service: c.service, Group: c.group, Address: c.address,
+		State: c.state, Since: c.since, Requests: requests, Failures: failures}
+}
+
+func addCircuitEvent(event CircuitBreakerEvent) {
+	circuitEventsLock.Lock()
+	defer circuitEventsLock.Unlock()
+	circuitEvents = append(circuitEvents, event)
+	if len(circuitEvents) > maxCircuitBreakerEvents {
+		circuitEvents = append([]CircuitBreakerEvent(nil), circuitEvents[len(circuitEvents)-maxCircuitBreakerEvents:]...)
+	}
+}
+
+// GetCircuitBreakerStates returns the states of the circuits of the endpoints with the circuitBreaker filter
+func GetCircuitBreakerStates() []CircuitBreakerState {
+	endpointCircuitsLock.Lock()
+	circuits := make([]*endpointCircuit, 0, len(endpointCircuits))
+	for _, c := range endpointCircuits {
+		circuits = append(circuits, c)
+	}
+	endpointCircuitsLock.Unlock()
+	states := make([]CircuitBreakerState, 0, len(circuits))
+	for _, c := range circuits {
+		states = append(states, c.getState())
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Endpoint < states[j].Endpoint
+	})
+	return states
+}
+
+// GetCircuitBreakerEvents returns the recent state changes of the circuits, the oldest first
+func GetCircuitBreakerEvents() []CircuitBreakerEvent {
+	circuitEventsLock.Lock()
+	defer circuitEventsLock.Unlock()
+	return append([]CircuitBreakerEvent(nil), circuitEvents...)
+}
@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/weibocom/motan-go/core"
 	"github.com/weibocom/motan-go/endpoint"
 	"github.com/weibocom/motan-go/log"
@@ -85,6 +86,82 @@ func TestCircuitBreakerFilter(t *testing.T) {
 	countLock.RUnlock()
 }
 
+func TestCircuitBreakerTransitions(t *testing.T) {
+	param := map[string]string{RequestVolumeThresholdKey: "4", ErrorPercentThresholdKey: "50", SleepWindowField: "100"}
+	url := &core.URL{Host: "127.0.0.1", Port: 7889, Protocol: "mockEndpoint", Path: "transitions", Parameters: param}
+	ef := (&CircuitBreakerFilter{}).NewFilter(url).(core.EndPointFilter)
+	ef.SetNext(core.GetLastEndPointFilter())
+	var calls, failing int32 = 0, 1
+	p := &Provider{url: url, handler: func(request core.Request) core.Response {
+		atomic.AddInt32(&calls, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			return core.BuildExceptionResponse(request.GetRequestID(), &core.Exception{ErrCode: 500, ErrMsg: "fail", ErrType: core.ServiceException})
+		}
+		return &core.MotanResponse{RequestID: request.GetRequestID(), Value: "ok"}
+	}}
+	request := &core.MotanRequest{Method: "testMethod"}
+	errCode := func() int {
+		if ex := ef.Filter(p, request).GetException(); ex != nil {
+			return ex.ErrCode
+		}
+		return 200
+	}
+
+	// closed -> open, the exceptions of the provider are responded as is
+	opened := false
+	for i := 0; i < 100 && !opened; i++ {
+		switch code := errCode(); code {
+		case circuitOpenErrCode:
+			opened = true
+		case 500:
+			time.Sleep(5 * time.Millisecond) // wait until the metrics are collected
+		default:
+			t.Fatalf("unexpected error code %d while the circuit is closed", code)
+		}
+	}
+	if !opened {
+		t.Fatal("circuit is not opened by the failures")
+	}
+	called := atomic.LoadInt32(&calls)
+	for i := 0; i < 5; i++ {
+		if code := errCode(); code != circuitOpenErrCode {
+			t.Errorf("open circuit responds %d, want %d", code, circuitOpenErrCode)
+		}
+	}
+	if atomic.LoadInt32(&calls) != called {
+		t.Errorf("provider is called while the circuit is open")
+	}
+
+	// half-open, the failed probe keeps the circuit open
+	time.Sleep(150 * time.Millisecond)
+	if code := errCode(); code != 500 || atomic.LoadInt32(&calls) != called+1 {
+		t.Errorf("half-open circuit does not probe the provider, code: %d", code)
+	}
+	if code := errCode(); code != circuitOpenErrCode {
+		t.Errorf("circuit is not opened again by the failed probe, code: %d", code)
+	}
+
+	// half-open -> closed by the successful probe
+	time.Sleep(150 * time.Millisecond)
+	atomic.StoreInt32(&failing, 0)
+	if code := errCode(); code != 200 {
+		t.Errorf("successful probe responds %d", code)
+	}
+	closed := false
+	for i := 0; i < 100 && !closed; i++ {
+		time.Sleep(5 * time.Millisecond) // the circuit is closed asynchronously
+		closed = errCode() == 200
+	}
+	if !closed {
+		t.Fatal("circuit is not closed by the successful probe")
+	}
+	for i := 0; i < 10; i++ {
+		if code := errCode(); code != 200 {
+			t.Errorf("closed circuit responds %d", code)
+		}
+	}
+}
+
 type mockEndPointFilter struct{}
 
 func (m *mockEndPointFilter) GetName() string {
@@ -121,3 +198,74 @@ func (m *mockEndPointFilter) GetIndex() int {
 func (m *mockEndPointFilter) GetType() int32 {
 	return core.EndPointFilterType
 }
+
+func TestEndpointCircuit(t *testing.T) {
+	url := &core.URL{Host: "127.0.0.1", Port: 7890, Protocol: "mockEndpoint", Path: "endpointCircuit", Group: "test"}
+	c := getEndpointCircuit(url, circuitConfig{requestThreshold: 4, errorPercent: 50, sleepWindow: 50 * time.Millisecond, statWindow: time.Second, halfOpenProbes: 2})
+	assert.Equal(t, c, getEndpointCircuit(url, c.config))
+	record := func(outcome circuitOutcome) {
+		allowed, probe := c.allow()
+		assert.True(t, allowed)
+		c.record(probe, outcome)
+	}
+
+	// the requests below the threshold and the ignored requests do not open the circuit
+	for i := 0; i < 3; i++ {
+		record(circuitFailure)
+	}
+	record(circuitIgnored)
+	assert.Equal(t, CircuitClosed, c.getState().State)
+	record(circuitSuccess)
+	assert.Equal(t, CircuitOpen, c.getState().State)
+	allowed, _ := c.allow()
+	assert.False(t, allowed)
+
+	// the half-open circuit lets the probes through at most halfOpenProbes at a time
+	time.Sleep(60 * time.Millisecond)
+	allowed, probe1 := c.allow()
+	assert.True(t, allowed)
+	assert.True(t, probe1 > 0)
+	allowed, probe2 := c.allow()
+	assert.True(t, allowed)
+	allowed, _ = c.allow()
+	assert.False(t, allowed)
+	assert.Equal(t, CircuitHalfOpen, c.getState().State)
+	c.record(probe1, circuitSuccess)
+	assert.Equal(t, CircuitHalfOpen, c.getState().State)
+	// the successful probes close the circuit
+	c.record(probe2, circuitSuccess)
+	assert.Equal(t, CircuitClosed, c.getState().State)
+	assert.Equal(t, 0, c.getState().Requests)
+
+	// a failed probe opens the circuit again, the probes of the previous half-open circuit are ignored
+	for i := 0; i < 4; i++ {
+		record(circuitFailure)
+	}
+	time.Sleep(60 * time.Millisecond)
+	_, probe1 = c.allow()
+	_, probe2 = c.allow()
+	c.record(probe1, circuitFailure)
+	assert.Equal(t, CircuitOpen, c.getState().State)
+	time.Sleep(60 * time.Millisecond)
+	_, probe3 := c.allow()
+	c.record(probe2, circuitSuccess)
+	c.record(probe3, circuitSuccess)
+	assert.Equal(t, CircuitHalfOpen, c.getState().State)
+
+	var states []string
+	for _, event := range GetCircuitBreakerEvents() {
+		if event.Endpoint == url.GetIdentity() {
+			states = append(states, event.To)
+		}
+	}
+	assert.Equal(t, []string{CircuitOpen, CircuitHalfOpen, CircuitClosed, CircuitOpen, CircuitHalfOpen, CircuitOpen, CircuitHalfOpen}, states)
+	found := false
+	for _, state := range GetCircuitBreakerStates() {
+		if state.Endpoint == url.GetIdentity() {
+			found = true
+			assert.Equal(t, "endpointCircuit", state.Service)
+			assert.Equal(t, "127.0.0.1:7890", state.Address)
+		}
+	}
+	assert.True(t, found)
+}
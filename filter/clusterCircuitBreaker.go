@@ -1,7 +1,6 @@
 package filter
 
 import (
-	"github.com/afex/hystrix-go/hystrix"
 	motan "github.com/weibocom/motan-go/core"
 )
 
@@ -21,15 +20,10 @@ func (c *ClusterCircuitBreakerFilter) NewFilter(url *motan.URL) motan.Filter {
 }
 
 func (c *ClusterCircuitBreakerFilter) Filter(ha motan.HaStrategy, lb motan.LoadBalance, request motan.Request) motan.Response {
-	var response motan.Response
-	err := hystrix.Do(c.url.GetIdentity(), func() error {
-		response = c.GetNext().Filter(ha, lb, request)
-		return checkException(response, c.includeBizException)
-	}, nil)
-	if err != nil {
-		return defaultErrMotanResponse(request, err.Error())
-	}
-	return response
+	response, err := doCircuit(c.url.GetIdentity(), c.includeBizException, func() motan.Response {
+		return c.GetNext().Filter(ha, lb, request)
+	})
+	return circuitBreakerResponse(request, response, err)
 }
 
 func (c *ClusterCircuitBreakerFilter) GetName() string {
@@ -0,0 +1,296 @@
+package filter
+
+import (
+	"errors"
+	"hash/fnv"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+	"github.com/weibocom/motan-go/protocol"
+)
+
+// GroupRouteRulesKey is the url parameter of the rules of ClusterGroupRouteFilter, the rules are separated by ';' and
+// matched in order, a rule is '<group>:<condition>'. the condition is a percentage of the requests, e.g. 'canary:5%',
+// or a predicate of an attachment of the request, e.g. 'canary:uid % 100 < 5' or 'staging:env == test'. the modulo
+// of a non integer attachment is the modulo of its hash. the requests matching no rule are called by the cluster
+const GroupRouteRulesKey = "groupRouteRules"
+
+var (
+	// the rules changed at runtime by service path
+	groupRouteUpdates        = motan.NewCopyOnWriteMap()
+	groupRouteUpdatesVersion int64
+
+	groupRoutePercentPattern   = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*%$`)
+	groupRoutePredicatePattern = regexp.MustCompile(`^([\w.\-]+)\s*(?:%\s*(\d+)\s*)?(==|!=|<=|>=|<|>)\s*(\S+)$`)
+)
+
+// UpdateGroupRoute changes the rules of the ClusterGroupRouteFilter of the service, the format of the rules is the
+// same as GroupRouteRulesKey, empty rules disable the routing of the service
+func UpdateGroupRoute(service string, rules string) error {
+	if service == "" {
+		return errors.New("service is empty")
+	}
+	if _, err := parseGroupRouteRules(rules); err != nil {
+		return err
+	}
+	groupRouteUpdates.Store(service, rules)
+	atomic.AddInt64(&groupRouteUpdatesVersion, 1)
+	vlog.Infof("[clusterGroupRoute] rules of %s are updated: %s", service, rules)
+	return nil
+}
+
+// ResetGroupRoute removes the rules changed by UpdateGroupRoute, the rules of the cluster url are used again
+func ResetGroupRoute(service string) {
+	groupRouteUpdates.Delete(service)
+	atomic.AddInt64(&groupRouteUpdatesVersion, 1)
+}
+
+type groupRouteRule struct {
+	group      string
+	percent    float64 // the percentage rule if attachment is empty
+	attachment string
+	mod        int64
+	op         string
+	value      string
+}
+
+func parseGroupRouteRules(rules string) ([]*groupRouteRule, error) {
+	var result []*groupRouteRule
+	for _, r := range motan.TrimSplit(rules, ";") {
+		if r == "" {
+			continue
+		}
+		i := strings.Index(r, ":")
+		if i <= 0 {
+			return nil, errors.New("illegal group route rule: " + r)
+		}
+		rule := &groupRouteRule{group: strings.TrimSpace(r[:i])}
+		condition := strings.TrimSpace(r[i+1:])
+		if m := groupRoutePercentPattern.FindStringSubmatch(condition); m != nil {
+			rule.percent, _ = strconv.ParseFloat(m[1], 64)
+			if rule.percent > 100 {
+				return nil, errors.New("illegal group route percentage: " + r)
+			}
+		} else if m := groupRoutePredicatePattern.FindStringSubmatch(condition); m != nil {
+			rule.attachment, rule.op, rule.value = m[1], m[3], m[4]
+			if m[2] != "" {
+				rule.mod, _ = strconv.ParseInt(m[2], 10, 64)
+				if rule.mod <= 0 {
+					return nil, errors.New("illegal group route modulo: " + r)
+				}
+			}
+		} else {
+			return nil, errors.New("illegal group route condition: " + r)
+		}
+		result = append(result, rule)
+	}
+	return result, nil
+}
+
+func (r *groupRouteRule) match(request motan.Request) bool {
+	if r.attachment == "" {
+		return r.percent >= 100 || rand.Float64()*100 < r.percent
+	}
+	value := request.GetAttachment(r.attachment)
+	if value == "" {
+		return false
+	}
+	v, err := strconv.ParseInt(value, 10, 64)
+	if r.mod > 0 {
+		if err != nil {
+			h := fnv.New64a()
+			h.Write([]byte(value))
+			v = int64(h.Sum64() & 0x7fffffffffffffff)
+		}
+		v %= r.mod
+		if v < 0 {
+			v += r.mod
+		}
+	} else if err != nil {
+		// the non integer attachments are compared as strings
+		switch r.op {
+		case "==":
+			return value == r.value
+		case "!=":
+			return value != r.value
+		}
+		return false
+	}
+	expected, err := strconv.ParseInt(r.value, 10, 64)
+	if err != nil {
+		return r.op == "!="
+	}
+	switch r.op {
+	case "==":
+		return v == expected
+	case "!=":
+		return v != expected
+	case "<":
+		return v < expected
+	case "<=":
+		return v <= expected
+	case ">":
+		return v > expected
+	case ">=":
+		return v >= expected
+	}
+	return false
+}
+
+// ClusterGroupRouteFilter calls the requests matching the rules by the clusters of other groups, it is used for the
+// gradual rollouts of a new version deployed in another group. the rules can be changed at runtime by UpdateGroupRoute
+type ClusterGroupRouteFilter struct {
+	url     *motan.URL
+	next    motan.ClusterFilter
+	version int64
+	rules   atomic.Value // []*groupRouteRule
+	lock    sync.Mutex
+	factory motan.GroupCallerFactory
+	callers map[string]motan.Caller
+	closed  bool
+}
+
+func (c *ClusterGroupRouteFilter) GetIndex() int {
+	return 1
+}
+
+func (c *ClusterGroupRouteFilter) NewFilter(url *motan.URL) motan.Filter {
+	f := &ClusterGroupRouteFilter{url: url, callers: make(map[string]motan.Caller)}
+	f.version = atomic.LoadInt64(&groupRouteUpdatesVersion)
+	f.rules.Store(f.loadRules())
+	return f
+}
+
+func (c *ClusterGroupRouteFilter) loadRules() []*groupRouteRule {
+	rules := c.url.GetParam(GroupRouteRulesKey, "")
+	if v, ok := groupRouteUpdates.Load(c.url.Path); ok {
+		rules = v.(string)
+	}
+	result, err := parseGroupRouteRules(rules)
+	if err != nil {
+		vlog.Warningf("[clusterGroupRoute] %s, routing is disabled. url: %s", err.Error(), c.url.GetIdentity())
+		return nil
+	}
+	// the requests of the group of the cluster are not routed
+	valid := result[:0]
+	for _, r := range result {
+		if r.group != c.url.Group {
+			valid = append(valid, r)
+		}
+	}
+	return valid
+}
+
+func (c *ClusterGroupRouteFilter) getRules() []*groupRouteRule {
+	if version := atomic.LoadInt64(&groupRouteUpdatesVersion); version != atomic.LoadInt64(&c.version) {
+		c.lock.Lock()
+		if version != c.version {
+			rules := c.loadRules()
+			c.rules.Store(rules)
+			atomic.StoreInt64(&c.version, version)
+			c.removeUnusedCallers(rules)
+		}
+		c.lock.Unlock()
+	}
+	return c.rules.Load().([]*groupRouteRule)
+}
+
+// removeUnusedCallers destroys the clusters of the groups not in the rules, it should be called with the lock
+func (c *ClusterGroupRouteFilter) removeUnusedCallers(rules []*groupRouteRule) {
+	for group, caller := range c.callers {
+		used := false
+		for _, r := range rules {
+			if r.group == group {
+				used = true
+				break
+			}
+		}
+		if !used {
+			delete(c.callers, group)
+			caller.Destroy()
+		}
+	}
+}
+
+// SetGroupCallerFactory keeps the factory, the clusters of the groups are created when they are routed to
+func (c *ClusterGroupRouteFilter) SetGroupCallerFactory(factory motan.GroupCallerFactory) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.factory = factory
+}
+
+func (c *ClusterGroupRouteFilter) getCaller(group string) motan.Caller {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed || c.factory == nil {
+		return nil
+	}
+	if caller, ok := c.callers[group]; ok {
+		return caller
+	}
+	// the clusters of the groups do not route again
+	url := c.url.Copy()
+	url.Group = group
+	delete(url.Parameters, GroupRouteRulesKey)
+	filters := make([]string, 0, 8)
+	for _, f := range motan.TrimSplit(url.GetParam(motan.FilterKey, ""), ",") {
+		if f != "" && f != ClusterGroupRoute {
+			filters = append(filters, f)
+		}
+	}
+	url.PutParam(motan.FilterKey, strings.Join(filters, ","))
+	caller := c.factory(url)
+	c.callers[group] = caller
+	return caller
+}
+
+func (c *ClusterGroupRouteFilter) Filter(haStrategy motan.HaStrategy, loadBalance motan.LoadBalance, request motan.Request) motan.Response {
+	for _, r := range c.getRules() {
+		if !r.match(request) {
+			continue
+		}
+		if caller := c.getCaller(r.group); caller != nil && caller.IsAvailable() {
+			request.SetAttachment(protocol.MGroup, r.group)
+			return caller.Call(request)
+		}
+		break
+	}
+	return c.GetNext().Filter(haStrategy, loadBalance, request)
+}
+
+// Destroy destroys the clusters of the groups, it is called when the cluster is destroyed
+func (c *ClusterGroupRouteFilter) Destroy() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.closed = true
+	for group, caller := range c.callers {
+		delete(c.callers, group)
+		caller.Destroy()
+	}
+}
+
+func (c *ClusterGroupRouteFilter) GetName() string {
+	return ClusterGroupRoute
+}
+
+func (c *ClusterGroupRouteFilter) HasNext() bool {
+	return c.next != nil
+}
+
+func (c *ClusterGroupRouteFilter) GetType() int32 {
+	return motan.ClusterFilterType
+}
+
+func (c *ClusterGroupRouteFilter) SetNext(cf motan.ClusterFilter) {
+	c.next = cf
+}
+
+func (c *ClusterGroupRouteFilter) GetNext() motan.ClusterFilter {
+	return c.next
+}
@@ -0,0 +1,103 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/protocol"
+)
+
+func TestParseGroupRouteRules(t *testing.T) {
+	rules, err := parseGroupRouteRules("canary: uid % 100 < 5; gray:10%;staging:env == test;")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(rules))
+	assert.Equal(t, &groupRouteRule{group: "canary", attachment: "uid", mod: 100, op: "<", value: "5"}, rules[0])
+	assert.Equal(t, &groupRouteRule{group: "gray", percent: 10}, rules[1])
+	assert.Equal(t, &groupRouteRule{group: "staging", attachment: "env", op: "==", value: "test"}, rules[2])
+
+	rules, err = parseGroupRouteRules("")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(rules))
+	for _, illegal := range []string{"canary", ":5%", "canary:101%", "canary:uid % 0 < 5", "canary:uid ~ 5"} {
+		_, err = parseGroupRouteRules(illegal)
+		assert.NotNil(t, err, illegal)
+	}
+}
+
+func TestGroupRouteRuleMatch(t *testing.T) {
+	rules, _ := parseGroupRouteRules("a:uid % 100 < 5;b:env == test;c:env != test;d:level >= 3;e:100%;f:0%")
+	request := defaultRequest()
+	request.SetAttachment("uid", "1203")
+	request.SetAttachment("env", "test")
+	request.SetAttachment("level", "2")
+	matched := make([]string, 0)
+	for _, r := range rules {
+		if r.match(request) {
+			matched = append(matched, r.group)
+		}
+	}
+	assert.Equal(t, []string{"a", "b", "e"}, matched)
+
+	// the modulo of a non integer attachment is stable
+	rule, _ := parseGroupRouteRules("a:uid % 10 < 5")
+	request.SetAttachment("uid", "abc")
+	first := rule[0].match(request)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, first, rule[0].match(request))
+	}
+	request.SetAttachment("uid", "")
+	assert.False(t, rule[0].match(request))
+}
+
+func TestClusterGroupRouteFilter(t *testing.T) {
+	url := mockURL()
+	url.Path = testService
+	url.Group = testGroup
+	url.PutParam(motan.FilterKey, ClusterGroupRoute+","+ClusterMetrics)
+	url.PutParam(GroupRouteRulesKey, "canary:uid % 100 < 5")
+	f := initFactory().GetFilter(ClusterGroupRoute).NewFilter(url).(*ClusterGroupRouteFilter)
+	f.SetNext(motan.GetLastClusterFilter())
+	callers := make(map[string]*mirrorCaller)
+	f.SetGroupCallerFactory(func(url *motan.URL) motan.Caller {
+		assert.Equal(t, ClusterMetrics, url.GetParam(motan.FilterKey, ""))
+		assert.Equal(t, "", url.GetParam(GroupRouteRulesKey, ""))
+		caller := &mirrorCaller{url: url, requests: make(chan motan.Request, 10)}
+		callers[url.Group] = caller
+		return caller
+	})
+
+	request := defaultRequest()
+	request.SetAttachment("uid", "102")
+	res := f.Filter(&motan.TestHaStrategy{}, &motan.TestLoadBalance{}, request)
+	assert.Equal(t, "mirror", res.GetValue())
+	assert.Equal(t, "canary", request.GetAttachment(protocol.MGroup))
+	assert.Equal(t, 1, len(callers["canary"].requests))
+
+	request = defaultRequest()
+	request.SetAttachment("uid", "110")
+	res = f.Filter(&motan.TestHaStrategy{}, &motan.TestLoadBalance{}, request)
+	assert.NotEqual(t, "mirror", res.GetValue())
+	assert.Equal(t, testGroup, request.GetAttachment(protocol.MGroup))
+
+	// update the rules at runtime
+	assert.NotNil(t, UpdateGroupRoute(testService, "canary:uid"))
+	assert.Nil(t, UpdateGroupRoute(testService, "gray:100%"))
+	request = defaultRequest()
+	res = f.Filter(&motan.TestHaStrategy{}, &motan.TestLoadBalance{}, request)
+	assert.Equal(t, "gray", request.GetAttachment(protocol.MGroup))
+	assert.True(t, callers["canary"].destroyed, "the cluster of the unused group is destroyed")
+
+	ResetGroupRoute(testService)
+	request = defaultRequest()
+	request.SetAttachment("uid", "3")
+	f.Filter(&motan.TestHaStrategy{}, &motan.TestLoadBalance{}, request)
+	assert.Equal(t, "canary", request.GetAttachment(protocol.MGroup))
+
+	f.Destroy()
+	assert.True(t, callers["gray"].destroyed)
+	request = defaultRequest()
+	request.SetAttachment("uid", "3")
+	f.Filter(&motan.TestHaStrategy{}, &motan.TestLoadBalance{}, request)
+	assert.Equal(t, testGroup, request.GetAttachment(protocol.MGroup))
+}
@@ -0,0 +1,181 @@
+package filter
+
+import (
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+	"github.com/weibocom/motan-go/metrics"
+	"github.com/weibocom/motan-go/protocol"
+)
+
+// the url parameters of ClusterMirrorFilter
+const (
+	MirrorGroupKey = "mirrorGroup" // the group receiving the mirrored requests, the filter is disabled if it is empty
+	// MirrorPercentKey is the percentage of the requests mirrored, 0 ~ 100 and default 100
+	MirrorPercentKey = "mirrorPercent"
+	// MirrorTimeoutKey is the request timeout(ms) of the mirror group, default the request timeout of the cluster
+	MirrorTimeoutKey = "mirrorTimeout"
+	// MirrorMaxConcurrentKey is the max count of the mirrored requests in flight, the requests over it are dropped
+	MirrorMaxConcurrentKey = "mirrorMaxConcurrent"
+)
+
+// the metric keys of the mirrored requests, they are reported in the group '<mirror group>.mirror'
+const (
+	MetricsMirrorKeyPrefix       = "motan-client-mirror:"
+	MetricsMirrorDropCountSuffix = ".drop_count"
+)
+
+const defaultMirrorMaxConcurrent = 100
+
+// ClusterMirrorFilter mirrors a percentage of the requests to another group asynchronously and discards the responses
+// of the mirror group, the response of the cluster is not affected by the mirror group
+type ClusterMirrorFilter struct {
+	url           *motan.URL
+	next          motan.ClusterFilter
+	group         string
+	percent       float64
+	maxConcurrent int64
+	inFlight      int64
+	lock          sync.Mutex
+	mirror        motan.Caller
+	destroyed     bool
+}
+
+func (c *ClusterMirrorFilter) GetIndex() int {
+	return 2
+}
+
+func (c *ClusterMirrorFilter) NewFilter(url *motan.URL) motan.Filter {
+	group := url.GetParam(MirrorGroupKey, "")
+	if group == "" {
+		return nil
+	}
+	if group == url.Group {
+		vlog.Warningf("[clusterMirror] mirror group is the group of the cluster, mirror is disabled. url: %s", url.GetIdentity())
+		return nil
+	}
+	percent, err := strconv.ParseFloat(url.GetParam(MirrorPercentKey, "100"), 64)
+	if err != nil || percent < 0 || percent > 100 {
+		vlog.Warningf("[clusterMirror] illegal %s: %s, mirror is disabled. url: %s", MirrorPercentKey, url.GetParam(MirrorPercentKey, ""), url.GetIdentity())
+		return nil
+	}
+	return &ClusterMirrorFilter{
+		url:           url,
+		group:         group,
+		percent:       percent,
+		maxConcurrent: url.GetPositiveIntValue(MirrorMaxConcurrentKey, defaultMirrorMaxConcurrent),
+	}
+}
+
+// SetGroupCallerFactory creates the cluster of the mirror group, the cluster filters of the mirror group are the same
+// as the cluster except this filter
+func (c *ClusterMirrorFilter) SetGroupCallerFactory(factory motan.GroupCallerFactory) {
+	url := c.url.Copy()
+	url.Group = c.group
+	delete(url.Parameters, MirrorGroupKey)
+	if timeout := c.url.GetParam(MirrorTimeoutKey, ""); timeout != "" {
+		url.PutParam(motan.TimeOutKey, timeout)
+	}
+	mirror := factory(url)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.destroyed {
+		mirror.Destroy()
+		return
+	}
+	if c.mirror != nil {
+		c.mirror.Destroy()
+	}
+	c.mirror = mirror
+}
+
+func (c *ClusterMirrorFilter) Filter(haStrategy motan.HaStrategy, loadBalance motan.LoadBalance, request motan.Request) motan.Response {
+	if mirror := c.getMirror(); mirror != nil && c.sample() {
+		c.doMirror(mirror, request)
+	}
+	return c.GetNext().Filter(haStrategy, loadBalance, request)
+}
+
+func (c *ClusterMirrorFilter) getMirror() motan.Caller {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.mirror
+}
+
+func (c *ClusterMirrorFilter) sample() bool {
+	return c.percent >= 100 || rand.Float64()*100 < c.percent
+}
+
+// doMirror clones the request before the cluster calls it, the request may be changed by the cluster
+func (c *ClusterMirrorFilter) doMirror(mirror motan.Caller, request motan.Request) {
+	service := metrics.Escape(request.GetServiceName())
+	key := MetricsMirrorKeyPrefix + metrics.Escape(request.GetMethod())
+	metricsGroup := metrics.Escape(c.group) + ".mirror"
+	if atomic.AddInt64(&c.inFlight, 1) > c.maxConcurrent {
+		atomic.AddInt64(&c.inFlight, -1)
+		metrics.AddCounter(metricsGroup, service, key+MetricsMirrorDropCountSuffix, 1)
+		return
+	}
+	mirrorRequest := cloneMirrorRequest(request, c.group)
+	go func() {
+		defer atomic.AddInt64(&c.inFlight, -1)
+		defer motan.HandleRequestPanic(mirrorRequest, func() {
+			vlog.Errorf("[clusterMirror] mirror call panic. req:%s", motan.GetReqInfo(mirrorRequest))
+		})
+		start := time.Now()
+		response := mirror.Call(mirrorRequest)
+		addMetric(metricsGroup, service, key, time.Since(start).Nanoseconds()/1e6, response)
+	}()
+}
+
+// cloneMirrorRequest clones the request to the group, the reply and the callbacks of the request are not kept because
+// the response of the mirror group is discarded
+func cloneMirrorRequest(request motan.Request, group string) motan.Request {
+	mirrorRequest := request.Clone().(motan.Request)
+	mirrorRequest.SetAttachment(protocol.MGroup, group)
+	if ctx := mirrorRequest.GetRPCContext(false); ctx != nil {
+		ctx.Reply = nil
+		ctx.AsyncCall = false
+		ctx.Result = nil
+		ctx.FinishHandlers = nil
+		ctx.Tc = nil
+		ctx.ProgressListener = nil
+	}
+	return mirrorRequest
+}
+
+// Destroy destroys the cluster of the mirror group, it is called when the cluster is destroyed
+func (c *ClusterMirrorFilter) Destroy() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.destroyed = true
+	if c.mirror != nil {
+		c.mirror.Destroy()
+		c.mirror = nil
+	}
+}
+
+func (c *ClusterMirrorFilter) GetName() string {
+	return ClusterMirror
+}
+
+func (c *ClusterMirrorFilter) HasNext() bool {
+	return c.next != nil
+}
+
+func (c *ClusterMirrorFilter) GetType() int32 {
+	return motan.ClusterFilterType
+}
+
+func (c *ClusterMirrorFilter) SetNext(cf motan.ClusterFilter) {
+	c.next = cf
+}
+
+func (c *ClusterMirrorFilter) GetNext() motan.ClusterFilter {
+	return c.next
+}
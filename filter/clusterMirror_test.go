@@ -0,0 +1,126 @@
+package filter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/weibocom/motan-go/config"
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/metrics"
+	"github.com/weibocom/motan-go/protocol"
+)
+
+type mirrorCaller struct {
+	url       *motan.URL
+	requests  chan motan.Request
+	block     chan struct{}
+	destroyed bool
+}
+
+func (m *mirrorCaller) GetURL() *motan.URL {
+	return m.url
+}
+
+func (m *mirrorCaller) SetURL(url *motan.URL) {
+	m.url = url
+}
+
+func (m *mirrorCaller) IsAvailable() bool {
+	return true
+}
+
+func (m *mirrorCaller) Call(request motan.Request) motan.Response {
+	if m.block != nil {
+		<-m.block
+	}
+	m.requests <- request
+	return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: "mirror"}
+}
+
+func (m *mirrorCaller) Destroy() {
+	m.destroyed = true
+}
+
+func newMirrorFilter(t *testing.T, params map[string]string) (*ClusterMirrorFilter, *mirrorCaller) {
+	url := mockURL()
+	url.Group = testGroup
+	url.PutParam(motan.FilterKey, ClusterMirror)
+	for k, v := range params {
+		url.PutParam(k, v)
+	}
+	f := initFactory().GetFilter(ClusterMirror).NewFilter(url)
+	if f == nil {
+		return nil, nil
+	}
+	cf := f.(*ClusterMirrorFilter)
+	cf.SetNext(motan.GetLastClusterFilter())
+	caller := &mirrorCaller{requests: make(chan motan.Request, 10)}
+	cf.SetGroupCallerFactory(func(url *motan.URL) motan.Caller {
+		assert.Equal(t, "canary", url.Group)
+		assert.Equal(t, "", url.GetParam(MirrorGroupKey, ""))
+		caller.url = url
+		return caller
+	})
+	return cf, caller
+}
+
+func TestClusterMirrorFilter(t *testing.T) {
+	f, _ := newMirrorFilter(t, nil)
+	assert.Nil(t, f, "no mirror group")
+	f, _ = newMirrorFilter(t, map[string]string{MirrorGroupKey: testGroup})
+	assert.Nil(t, f, "mirror group is the cluster group")
+	f, _ = newMirrorFilter(t, map[string]string{MirrorGroupKey: "canary", MirrorPercentKey: "200"})
+	assert.Nil(t, f, "illegal percent")
+
+	f, caller := newMirrorFilter(t, map[string]string{MirrorGroupKey: "canary", MirrorTimeoutKey: "50"})
+	assert.Equal(t, ClusterMirror, f.GetName())
+	assert.Equal(t, "50", caller.url.GetParam(motan.TimeOutKey, ""))
+	request := defaultRequest()
+	request.GetRPCContext(true).Reply = new(string)
+	res := f.Filter(&motan.TestHaStrategy{}, &motan.TestLoadBalance{}, request)
+	assert.NotEqual(t, "mirror", res.GetValue())
+	select {
+	case mr := <-caller.requests:
+		assert.Equal(t, "canary", mr.GetAttachment(protocol.MGroup))
+		assert.Nil(t, mr.GetRPCContext(true).Reply)
+	case <-time.After(time.Second):
+		t.Fatal("request is not mirrored")
+	}
+	assert.Equal(t, testGroup, request.GetAttachment(protocol.MGroup), "the request is not changed")
+	assert.NotNil(t, request.GetRPCContext(true).Reply)
+
+	f.Destroy()
+	assert.True(t, caller.destroyed)
+	assert.Nil(t, f.getMirror())
+}
+
+func TestClusterMirrorFilterPercent(t *testing.T) {
+	f, caller := newMirrorFilter(t, map[string]string{MirrorGroupKey: "canary", MirrorPercentKey: "0"})
+	for i := 0; i < 10; i++ {
+		f.Filter(&motan.TestHaStrategy{}, &motan.TestLoadBalance{}, defaultRequest())
+	}
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 0, len(caller.requests))
+}
+
+func TestClusterMirrorFilterMaxConcurrent(t *testing.T) {
+	f, caller := newMirrorFilter(t, map[string]string{MirrorGroupKey: "canary", MirrorMaxConcurrentKey: "1"})
+	caller.block = make(chan struct{})
+	metrics.StartReporter(&motan.Context{Config: config.NewConfig()})
+	for i := 0; i < 3; i++ {
+		f.Filter(&motan.TestHaStrategy{}, &motan.TestLoadBalance{}, defaultRequest())
+	}
+	time.Sleep(20 * time.Millisecond)
+	item := metrics.GetStatItem("canary.mirror", metrics.Escape(testService))
+	if assert.NotNil(t, item) {
+		assert.Equal(t, int64(2), item.SnapshotAndClear().Count(MetricsMirrorKeyPrefix+testMethod+MetricsMirrorDropCountSuffix))
+	}
+	close(caller.block)
+	select {
+	case <-caller.requests:
+	case <-time.After(time.Second):
+		t.Fatal("request is not mirrored")
+	}
+	assert.Equal(t, 0, len(caller.requests))
+}
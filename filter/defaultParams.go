@@ -0,0 +1,123 @@
+package filter
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// allDefaultParamsMethod is the method name in defaultParams config which applies to all methods
+const allDefaultParamsMethod = "*"
+
+// DefaultParamsFunc returns the default params of a request dynamically, the static config takes precedence over it
+type DefaultParamsFunc func(request core.Request) map[string]string
+
+var (
+	defaultParamsFuncs    = make(map[string]DefaultParamsFunc)
+	defaultParamsFuncLock sync.RWMutex
+)
+
+// RegisterDefaultParamsFunc register the default params func of a service(path)
+func RegisterDefaultParamsFunc(service string, f DefaultParamsFunc) {
+	defaultParamsFuncLock.Lock()
+	defer defaultParamsFuncLock.Unlock()
+	if f == nil {
+		delete(defaultParamsFuncs, service)
+		return
+	}
+	defaultParamsFuncs[service] = f
+}
+
+func getDefaultParamsFunc(service string) DefaultParamsFunc {
+	defaultParamsFuncLock.RLock()
+	defer defaultParamsFuncLock.RUnlock()
+	return defaultParamsFuncs[service]
+}
+
+// DefaultParamsFilter injects default values of absent params into requests before the provider runs.
+// the static defaults are configured by url parameter 'defaultParams' as a json map of method name to params, e.g.
+// {"*":{"version":"1"},"hello":{"lang":"en"}}, method '*' applies to all methods.
+// params are the attachments of a request, and the keys of map arguments(map[string]string or map[string]interface{})
+// of the client requests. the arguments of the provider requests are not deserialized before the provider runs, so only
+// the attachments are injected for the providers
+type DefaultParamsFilter struct {
+	defaults map[string]map[string]string
+	next     core.EndPointFilter
+}
+
+func (d *DefaultParamsFilter) NewFilter(url *core.URL) core.Filter {
+	ret := &DefaultParamsFilter{}
+	if value := url.GetParam(DefaultParams, ""); value != "" {
+		if err := json.Unmarshal([]byte(value), &ret.defaults); err != nil {
+			vlog.Warningf("[defaultParams] parse %s config error:%v", DefaultParams, err)
+		}
+	}
+	return ret
+}
+
+func (d *DefaultParamsFilter) Filter(caller core.Caller, request core.Request) core.Response {
+	_, isProvider := caller.(core.Provider)
+	d.apply(request, d.defaults[request.GetMethod()], !isProvider)
+	d.apply(request, d.defaults[allDefaultParamsMethod], !isProvider)
+	if f := getDefaultParamsFunc(request.GetServiceName()); f != nil {
+		d.apply(request, f(request), !isProvider)
+	}
+	return d.GetNext().Filter(caller, request)
+}
+
+// apply only sets the absent params, so the first applied defaults win
+func (d *DefaultParamsFilter) apply(request core.Request, params map[string]string, arguments bool) {
+	if len(params) == 0 {
+		return
+	}
+	for k, v := range params {
+		if request.GetAttachment(k) == "" {
+			request.SetAttachment(k, v)
+		}
+	}
+	if !arguments {
+		return
+	}
+	for _, arg := range request.GetArguments() {
+		switch m := arg.(type) {
+		case map[string]string:
+			for k, v := range params {
+				if _, ok := m[k]; !ok {
+					m[k] = v
+				}
+			}
+		case map[string]interface{}:
+			for k, v := range params {
+				if _, ok := m[k]; !ok {
+					m[k] = v
+				}
+			}
+		}
+	}
+}
+
+func (d *DefaultParamsFilter) SetNext(nextFilter core.EndPointFilter) {
+	d.next = nextFilter
+}
+
+func (d *DefaultParamsFilter) GetNext() core.EndPointFilter {
+	return d.next
+}
+
+func (d *DefaultParamsFilter) GetName() string {
+	return DefaultParams
+}
+
+func (d *DefaultParamsFilter) HasNext() bool {
+	return d.next != nil
+}
+
+func (d *DefaultParamsFilter) GetIndex() int {
+	return 10
+}
+
+func (d *DefaultParamsFilter) GetType() int32 {
+	return core.EndPointFilterType
+}
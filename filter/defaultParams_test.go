@@ -0,0 +1,68 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/weibocom/motan-go/core"
+)
+
+type defaultParamsCaller struct {
+	request core.Request
+}
+
+func (d *defaultParamsCaller) GetURL() *core.URL    { return nil }
+func (d *defaultParamsCaller) SetURL(url *core.URL) {}
+func (d *defaultParamsCaller) IsAvailable() bool    { return true }
+func (d *defaultParamsCaller) Destroy()             {}
+func (d *defaultParamsCaller) Call(request core.Request) core.Response {
+	d.request = request
+	return &core.MotanResponse{RequestID: request.GetRequestID()}
+}
+
+func TestDefaultParamsFilter(t *testing.T) {
+	factory := &core.DefaultExtensionFactory{}
+	factory.Initialize()
+	RegistDefaultFilters(factory)
+	url := &core.URL{Host: "127.0.0.1", Port: 7888, Path: "defaultParamsService", Parameters: map[string]string{
+		DefaultParams: `{"*":{"version":"1","lang":"zh"},"hello":{"lang":"en"}}`,
+	}}
+	f := factory.GetFilter(DefaultParams).NewFilter(url).(core.EndPointFilter)
+	f.SetNext(core.GetLastEndPointFilter())
+	caller := &defaultParamsCaller{}
+
+	request := &core.MotanRequest{ServiceName: "defaultParamsService", Method: "hello", Arguments: []interface{}{map[string]string{"version": "2"}}}
+	f.Filter(caller, request)
+	assert.Equal(t, "en", request.GetAttachment("lang"))
+	assert.Equal(t, "1", request.GetAttachment("version"))
+	arg := request.GetArguments()[0].(map[string]string)
+	assert.Equal(t, "2", arg["version"])
+	assert.Equal(t, "en", arg["lang"])
+
+	request = &core.MotanRequest{ServiceName: "defaultParamsService", Method: "world"}
+	request.SetAttachment("lang", "fr")
+	f.Filter(caller, request)
+	assert.Equal(t, "fr", request.GetAttachment("lang"))
+	assert.Equal(t, "1", request.GetAttachment("version"))
+
+	RegisterDefaultParamsFunc("defaultParamsService", func(request core.Request) map[string]string {
+		return map[string]string{"version": "3", "region": request.GetMethod()}
+	})
+	defer RegisterDefaultParamsFunc("defaultParamsService", nil)
+	request = &core.MotanRequest{ServiceName: "defaultParamsService", Method: "world", Arguments: []interface{}{map[string]interface{}{}}}
+	f.Filter(caller, request)
+	assert.Equal(t, "1", request.GetAttachment("version"))
+	assert.Equal(t, "world", request.GetAttachment("region"))
+	assert.Equal(t, "world", request.GetArguments()[0].(map[string]interface{})["region"])
+	assert.Equal(t, request, caller.request)
+
+	// only the attachments are injected for the providers
+	provider := &Provider{url: &core.URL{Path: "defaultParamsService"}, handler: func(request core.Request) core.Response {
+		return &core.MotanResponse{RequestID: request.GetRequestID()}
+	}}
+	value := &core.DeserializableValue{Body: []byte("body")}
+	request = &core.MotanRequest{ServiceName: "defaultParamsService", Method: "hello", Arguments: []interface{}{value}}
+	f.Filter(provider, request)
+	assert.Equal(t, "en", request.GetAttachment("lang"))
+	assert.Equal(t, value, request.GetArguments()[0])
+}
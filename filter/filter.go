@@ -12,12 +12,23 @@ const (
 	CircuitBreaker = "circuitBreaker"
 	FailFast       = "failfast"
 	Trace          = "trace"
+	OTelTrace      = "otelTrace"
 	RateLimit      = "rateLimit"
+	DefaultParams  = "defaultParams"
+	Metering       = "metering"
+
+	RequiredAttachments = "requiredAttachments"
+	ProviderRateLimit   = "providerRateLimit"
+	QuotaRateLimit      = "quotaRateLimit"
+	Auth                = "auth"
+	ResponseCache       = "responseCache"
 
 	// cluster filter
 	ClusterAccessLog      = "clusterAccessLog"
 	ClusterMetrics        = "clusterMetrics"
 	ClusterCircuitBreaker = "clusterCircuitBreaker"
+	ClusterMirror         = "clusterMirror"
+	ClusterGroupRoute     = "clusterGroupRoute"
 )
 
 func RegistDefaultFilters(extFactory motan.ExtensionFactory) {
@@ -42,10 +53,42 @@ func RegistDefaultFilters(extFactory motan.ExtensionFactory) {
 		return &TracingFilter{}
 	})
 
+	extFactory.RegistExtFilter(OTelTrace, func() motan.Filter {
+		return &OTelTracingFilter{}
+	})
+
 	extFactory.RegistExtFilter(RateLimit, func() motan.Filter {
 		return &RateLimitFilter{}
 	})
 
+	extFactory.RegistExtFilter(DefaultParams, func() motan.Filter {
+		return &DefaultParamsFilter{}
+	})
+
+	extFactory.RegistExtFilter(Metering, func() motan.Filter {
+		return &MeteringFilter{}
+	})
+
+	extFactory.RegistExtFilter(RequiredAttachments, func() motan.Filter {
+		return &RequiredAttachmentsFilter{}
+	})
+
+	extFactory.RegistExtFilter(ProviderRateLimit, func() motan.Filter {
+		return &ProviderRateLimitFilter{}
+	})
+
+	extFactory.RegistExtFilter(QuotaRateLimit, func() motan.Filter {
+		return &QuotaRateLimitFilter{}
+	})
+
+	extFactory.RegistExtFilter(Auth, func() motan.Filter {
+		return &AuthFilter{extFactory: extFactory}
+	})
+
+	extFactory.RegistExtFilter(ResponseCache, func() motan.Filter {
+		return &ResponseCacheFilter{}
+	})
+
 	// cluster filter
 	extFactory.RegistExtFilter(ClusterAccessLog, func() motan.Filter {
 		return &ClusterAccessLogFilter{}
@@ -58,4 +101,12 @@ func RegistDefaultFilters(extFactory motan.ExtensionFactory) {
 	extFactory.RegistExtFilter(ClusterCircuitBreaker, func() motan.Filter {
 		return &ClusterCircuitBreakerFilter{}
 	})
+
+	extFactory.RegistExtFilter(ClusterMirror, func() motan.Filter {
+		return &ClusterMirrorFilter{}
+	})
+
+	extFactory.RegistExtFilter(ClusterGroupRoute, func() motan.Filter {
+		return &ClusterGroupRouteFilter{}
+	})
 }
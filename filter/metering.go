@@ -0,0 +1,190 @@
+package filter
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+	"github.com/weibocom/motan-go/protocol"
+)
+
+const (
+	// MeteringCostKey is the url parameter of cost config, a json map of method name to cost, e.g.
+	// {"*":{"base":1},"export":{"base":10,"perKB":0.5}}, method '*' applies to all methods.
+	// the cost of a call is base + perKB * response body size in KB
+	MeteringCostKey = "meteringCost"
+
+	meteringQueueSize = 10000
+)
+
+// MeteringRecord is the cost of a call
+type MeteringRecord struct {
+	Time         time.Time
+	Caller       string // application of the client
+	Service      string
+	Method       string
+	Cost         float64
+	ResponseSize int
+	Success      bool
+}
+
+// MeteringSink receive metering records in a single background goroutine
+type MeteringSink interface {
+	Write(record *MeteringRecord) error
+}
+
+// MeteringCostFunc computes the cost of a call dynamically, it takes precedence over the config
+type MeteringCostFunc func(request core.Request, response core.Response, responseSize int) float64
+
+type meteringCost struct {
+	Base  float64 `json:"base"`
+	PerKB float64 `json:"perKB"`
+}
+
+var (
+	meteringSink      MeteringSink
+	meteringCostFuncs = make(map[string]MeteringCostFunc)
+	meteringLock      sync.RWMutex
+	meteringQueue     chan *MeteringRecord
+	meteringOnce      sync.Once
+)
+
+// SetMeteringSink set the sink of all metering filters, records are dropped if no sink is set
+func SetMeteringSink(sink MeteringSink) {
+	meteringOnce.Do(func() {
+		meteringQueue = make(chan *MeteringRecord, meteringQueueSize)
+		go writeMeteringRecords()
+	})
+	meteringLock.Lock()
+	defer meteringLock.Unlock()
+	meteringSink = sink
+}
+
+// RegisterMeteringCostFunc register the cost func of a service(path)
+func RegisterMeteringCostFunc(service string, f MeteringCostFunc) {
+	meteringLock.Lock()
+	defer meteringLock.Unlock()
+	if f == nil {
+		delete(meteringCostFuncs, service)
+		return
+	}
+	meteringCostFuncs[service] = f
+}
+
+func getMeteringSink() MeteringSink {
+	meteringLock.RLock()
+	defer meteringLock.RUnlock()
+	return meteringSink
+}
+
+func getMeteringCostFunc(service string) MeteringCostFunc {
+	meteringLock.RLock()
+	defer meteringLock.RUnlock()
+	return meteringCostFuncs[service]
+}
+
+func writeMeteringRecords() {
+	for record := range meteringQueue {
+		if sink := getMeteringSink(); sink != nil {
+			writeMeteringRecord(sink, record)
+		}
+	}
+}
+
+// writeMeteringRecord isolates the failure of sink
+func writeMeteringRecord(sink MeteringSink, record *MeteringRecord) {
+	defer core.HandlePanic(nil)
+	if err := sink.Write(record); err != nil {
+		vlog.Warningf("[metering] write record fail. service:%s, method:%s, err:%v", record.Service, record.Method, err)
+	}
+}
+
+// MeteringFilter account the cost of each call and report it to the MeteringSink.
+// on the server side the cost is computed after the response is serialized and sent, so the response size is known
+type MeteringFilter struct {
+	costs map[string]*meteringCost
+	next  core.EndPointFilter
+}
+
+func (m *MeteringFilter) NewFilter(url *core.URL) core.Filter {
+	ret := &MeteringFilter{}
+	if value := url.GetParam(MeteringCostKey, ""); value != "" {
+		if err := json.Unmarshal([]byte(value), &ret.costs); err != nil {
+			vlog.Warningf("[metering] parse %s config error:%v", MeteringCostKey, err)
+		}
+	}
+	return ret
+}
+
+func (m *MeteringFilter) Filter(caller core.Caller, request core.Request) core.Response {
+	response := m.GetNext().Filter(caller, request)
+	if getMeteringSink() == nil {
+		return response
+	}
+	ctx := response.GetRPCContext(true)
+	if ctx.BodySize > 0 {
+		m.report(request, response, ctx.BodySize)
+	} else {
+		ctx.AddFinishHandler(core.FinishHandleFunc(func() {
+			m.report(request, response, ctx.BodySize)
+		}))
+	}
+	return response
+}
+
+func (m *MeteringFilter) cost(request core.Request, response core.Response, size int) float64 {
+	if f := getMeteringCostFunc(request.GetServiceName()); f != nil {
+		return f(request, response, size)
+	}
+	c, ok := m.costs[request.GetMethod()]
+	if !ok {
+		if c, ok = m.costs["*"]; !ok {
+			return 0
+		}
+	}
+	return c.Base + c.PerKB*float64(size)/1024
+}
+
+func (m *MeteringFilter) report(request core.Request, response core.Response, size int) {
+	defer core.HandlePanic(nil)
+	record := &MeteringRecord{
+		Time:         time.Now(),
+		Caller:       request.GetAttachment(protocol.MSource),
+		Service:      request.GetServiceName(),
+		Method:       request.GetMethod(),
+		Cost:         m.cost(request, response, size),
+		ResponseSize: size,
+		Success:      response.GetException() == nil,
+	}
+	select {
+	case meteringQueue <- record:
+	default:
+		vlog.Warningf("[metering] queue is full, drop record. service:%s, method:%s", record.Service, record.Method)
+	}
+}
+
+func (m *MeteringFilter) SetNext(nextFilter core.EndPointFilter) {
+	m.next = nextFilter
+}
+
+func (m *MeteringFilter) GetNext() core.EndPointFilter {
+	return m.next
+}
+
+func (m *MeteringFilter) GetName() string {
+	return Metering
+}
+
+func (m *MeteringFilter) HasNext() bool {
+	return m.next != nil
+}
+
+func (m *MeteringFilter) GetIndex() int {
+	return 2
+}
+
+func (m *MeteringFilter) GetType() int32 {
+	return core.EndPointFilterType
+}
@@ -0,0 +1,88 @@
+package filter
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/protocol"
+)
+
+type chanMeteringSink struct {
+	records chan *MeteringRecord
+}
+
+func (c *chanMeteringSink) Write(record *MeteringRecord) error {
+	if record.Method == "fail" {
+		return errors.New("sink fail")
+	}
+	if record.Method == "panic" {
+		panic("sink panic")
+	}
+	c.records <- record
+	return nil
+}
+
+func (c *chanMeteringSink) next(t *testing.T) *MeteringRecord {
+	select {
+	case r := <-c.records:
+		return r
+	case <-time.After(time.Second):
+		assert.Fail(t, "metering record not received")
+		return nil
+	}
+}
+
+type sizedCaller struct {
+	size int
+}
+
+func (s *sizedCaller) GetURL() *core.URL    { return nil }
+func (s *sizedCaller) SetURL(url *core.URL) {}
+func (s *sizedCaller) IsAvailable() bool    { return true }
+func (s *sizedCaller) Destroy()             {}
+func (s *sizedCaller) Call(request core.Request) core.Response {
+	res := &core.MotanResponse{RequestID: request.GetRequestID()}
+	res.GetRPCContext(true).BodySize = s.size
+	return res
+}
+
+func TestMeteringFilter(t *testing.T) {
+	sink := &chanMeteringSink{records: make(chan *MeteringRecord, 10)}
+	SetMeteringSink(sink)
+	defer SetMeteringSink(nil)
+	url := &core.URL{Parameters: map[string]string{MeteringCostKey: `{"*":{"base":1},"export":{"base":10,"perKB":0.5}}`}}
+	f := (&MeteringFilter{}).NewFilter(url).(core.EndPointFilter)
+	f.SetNext(core.GetLastEndPointFilter())
+
+	request := &core.MotanRequest{ServiceName: "meteringService", Method: "export"}
+	request.SetAttachment(protocol.MSource, "app")
+	f.Filter(&sizedCaller{size: 2048}, request)
+	r := sink.next(t)
+	assert.Equal(t, "app", r.Caller)
+	assert.Equal(t, "export", r.Method)
+	assert.Equal(t, 11.0, r.Cost)
+	assert.True(t, r.Success)
+
+	// sink failures do not affect later records
+	f.Filter(&sizedCaller{size: 1}, &core.MotanRequest{ServiceName: "meteringService", Method: "fail"})
+	f.Filter(&sizedCaller{size: 1}, &core.MotanRequest{ServiceName: "meteringService", Method: "panic"})
+
+	// the cost is computed when finished if the response is not serialized yet
+	res := f.Filter(&sizedCaller{}, &core.MotanRequest{ServiceName: "meteringService", Method: "other"})
+	res.GetRPCContext(true).BodySize = 10
+	res.GetRPCContext(true).OnFinish()
+	r = sink.next(t)
+	assert.Equal(t, "other", r.Method)
+	assert.Equal(t, 1.0, r.Cost)
+	assert.Equal(t, 10, r.ResponseSize)
+
+	RegisterMeteringCostFunc("meteringService", func(request core.Request, response core.Response, responseSize int) float64 {
+		return float64(responseSize) * 2
+	})
+	defer RegisterMeteringCostFunc("meteringService", nil)
+	f.Filter(&sizedCaller{size: 3}, &core.MotanRequest{ServiceName: "meteringService", Method: "export"})
+	assert.Equal(t, 6.0, sink.next(t).Cost)
+}
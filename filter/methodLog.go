@@ -0,0 +1,110 @@
+package filter
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// max logged length of the arguments and the response value in method logs
+const methodLogMaxLength = 4096
+
+// MethodLogToggle is a temporary switch of the detailed access log of a method
+type MethodLogToggle struct {
+	Service  string    `json:"service"`
+	Method   string    `json:"method"`
+	ExpireAt time.Time `json:"expireAt"`
+}
+
+var (
+	methodLogLock    sync.RWMutex
+	methodLogToggles = make(map[string]*MethodLogToggle)
+	// number of the toggles, the access log filters check it first
+	methodLogCount int32
+)
+
+func methodLogKey(service string, method string) string {
+	return service + "#" + method
+}
+
+// EnableMethodLog enables the detailed access log of the method for the duration, the access log filters log the arguments and the
+// response value of the method in addition to the access log. enabling a method again resets the expiry
+func EnableMethodLog(service string, method string, duration time.Duration) {
+	methodLogLock.Lock()
+	defer methodLogLock.Unlock()
+	methodLogToggles[methodLogKey(service, method)] = &MethodLogToggle{Service: service, Method: method, ExpireAt: time.Now().Add(duration)}
+	atomic.StoreInt32(&methodLogCount, int32(len(methodLogToggles)))
+	vlog.Infof("method log enabled. service:%s, method:%s, duration:%v", service, method, duration)
+}
+
+// DisableMethodLog disables the detailed access log of the method before it expires
+func DisableMethodLog(service string, method string) {
+	methodLogLock.Lock()
+	defer methodLogLock.Unlock()
+	delete(methodLogToggles, methodLogKey(service, method))
+	atomic.StoreInt32(&methodLogCount, int32(len(methodLogToggles)))
+	vlog.Infof("method log disabled. service:%s, method:%s", service, method)
+}
+
+// GetMethodLogToggles returns the toggles not expired, ordered by service and method
+func GetMethodLogToggles() []MethodLogToggle {
+	methodLogLock.RLock()
+	defer methodLogLock.RUnlock()
+	now := time.Now()
+	toggles := make([]MethodLogToggle, 0, len(methodLogToggles))
+	for _, t := range methodLogToggles {
+		if now.Before(t.ExpireAt) {
+			toggles = append(toggles, *t)
+		}
+	}
+	sort.Slice(toggles, func(i, j int) bool {
+		return methodLogKey(toggles[i].Service, toggles[i].Method) < methodLogKey(toggles[j].Service, toggles[j].Method)
+	})
+	return toggles
+}
+
+// methodLogEnabled checks the toggle of the request method, the expired toggle is removed
+func methodLogEnabled(request motan.Request) bool {
+	if atomic.LoadInt32(&methodLogCount) == 0 {
+		return false
+	}
+	key := methodLogKey(request.GetServiceName(), request.GetMethod())
+	methodLogLock.RLock()
+	t := methodLogToggles[key]
+	methodLogLock.RUnlock()
+	if t == nil {
+		return false
+	}
+	if time.Now().Before(t.ExpireAt) {
+		return true
+	}
+	methodLogLock.Lock()
+	defer methodLogLock.Unlock()
+	if methodLogToggles[key] == t {
+		delete(methodLogToggles, key)
+		atomic.StoreInt32(&methodLogCount, int32(len(methodLogToggles)))
+		vlog.Infof("method log expired. service:%s, method:%s", t.Service, t.Method)
+	}
+	return false
+}
+
+func doMethodLog(role string, address string, request motan.Request, response motan.Response) {
+	exception := ""
+	if e := response.GetException(); e != nil {
+		exception = e.ErrMsg
+	}
+	vlog.Infof("method log. role:%s, remote:%s, req:%s, args:%s, value:%s, exception:%s",
+		role, address, motan.GetReqInfo(request), truncateLog(fmt.Sprintf("%+v", request.GetArguments())), truncateLog(fmt.Sprintf("%+v", response.GetValue())), exception)
+}
+
+func truncateLog(s string) string {
+	if len(s) > methodLogMaxLength {
+		return s[:methodLogMaxLength] + "..."
+	}
+	return s
+}
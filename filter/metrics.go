@@ -58,10 +58,29 @@ func (m *MetricsFilter) GetNext() motan.EndPointFilter {
 
 func (m *MetricsFilter) Filter(caller motan.Caller, request motan.Request) motan.Response {
 	start := time.Now()
+	group, service := request.GetAttachment(protocol.MGroup), request.GetAttachment(protocol.MPath)
+	inFlightRole := metricsRole(caller, request)
+	metrics.PromRequestsInFlight.Add(1, inFlightRole, group, service)
 	response := m.GetNext().Filter(caller, request)
+	metrics.PromRequestsInFlight.Add(-1, inFlightRole, group, service)
+	// the proxy flag of the context may be set in the call
+	role := metricsRole(caller, request)
+	metrics.RecordPromCall(role, group, service, request.GetMethod(), time.Since(start), response.GetException())
 
+	//get application
+	application := request.GetAttachment(protocol.MSource)
+	if _, ok := caller.(motan.Provider); ok {
+		application = caller.GetURL().GetParam(motan.ApplicationKey, "")
+	}
+	key := metrics.Escape(role) +
+		":" + metrics.Escape(application) +
+		":" + metrics.Escape(request.GetMethod())
+	addMetric(metrics.Escape(group), metrics.Escape(service), key, time.Since(start).Nanoseconds()/1e6, response)
+	return response
+}
+
+func metricsRole(caller motan.Caller, request motan.Request) string {
 	proxy := false
-	provider := false
 	ctx := request.GetRPCContext(false)
 	if ctx != nil {
 		proxy = ctx.Proxy
@@ -70,7 +89,6 @@ func (m *MetricsFilter) Filter(caller motan.Caller, request motan.Request) motan
 	role := "motan-client"
 	switch caller.(type) {
 	case motan.Provider:
-		provider = true
 		if proxy {
 			role = "motan-server-agent"
 		} else {
@@ -81,18 +99,7 @@ func (m *MetricsFilter) Filter(caller motan.Caller, request motan.Request) motan
 			role = "motan-client-agent"
 		}
 	}
-	//get application
-	application := request.GetAttachment(protocol.MSource)
-	if provider {
-		application = caller.GetURL().GetParam(motan.ApplicationKey, "")
-	}
-	key := metrics.Escape(role) +
-		":" + metrics.Escape(application) +
-		":" + metrics.Escape(request.GetMethod())
-	addMetric(metrics.Escape(request.GetAttachment(protocol.MGroup)),
-		metrics.Escape(request.GetAttachment(protocol.MPath)),
-		key, time.Since(start).Nanoseconds()/1e6, response)
-	return response
+	return role
 }
 
 func addMetric(group string, service string, key string, cost int64, response motan.Response) {
@@ -0,0 +1,271 @@
+package filter
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// the W3C trace context attachments
+const (
+	TraceParentKey = "traceparent"
+	TraceStateKey  = "tracestate"
+)
+
+// the OTLP span kinds
+const (
+	OTelSpanKindServer = 2
+	OTelSpanKindClient = 3
+)
+
+// OTelSpan is a finished span of a call. the parent span id is zero for the root spans
+type OTelSpan struct {
+	TraceID      [16]byte
+	SpanID       [8]byte
+	ParentSpanID [8]byte
+	TraceState   string
+	Name         string
+	Kind         int
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]interface{} // string, int64 or bool values
+	Error        bool
+	ErrorMessage string
+}
+
+// OTelSpanExporter receives the sampled spans, Export must not block
+type OTelSpanExporter interface {
+	Export(span *OTelSpan)
+}
+
+type otelTracer struct {
+	sampleRatio float64
+	exporter    OTelSpanExporter
+}
+
+var otelTracerValue atomic.Value // *otelTracer
+
+// SetOTelTracer sets the sample ratio of the new traces and the exporter of the OTelTracingFilter, the child spans
+// of the remote spans are sampled as their parents. the trace context is propagated even if the exporter is nil
+func SetOTelTracer(sampleRatio float64, exporter OTelSpanExporter) {
+	otelTracerValue.Store(&otelTracer{sampleRatio: sampleRatio, exporter: exporter})
+}
+
+func getOTelTracer() *otelTracer {
+	if t, ok := otelTracerValue.Load().(*otelTracer); ok {
+		return t
+	}
+	return &otelTracer{}
+}
+
+// OTelTracingConfig is the "otelTracing" section of the agent/server/client yaml, e.g.
+//
+//	otelTracing:
+//	  endpoint: http://127.0.0.1:4318 # the OTLP/HTTP collector, the spans are not exported if it is empty
+//	  sampleRatio: 0.1
+//	  serviceName: my-service
+type OTelTracingConfig struct {
+	Endpoint      string
+	SampleRatio   float64
+	ServiceName   string
+	BatchSize     int
+	FlushInterval int // ms
+	Timeout       int // ms
+}
+
+var otelTracingOnce sync.Once
+
+// StartOTelTracing configures the OTelTracingFilter by the otelTracing section of the context config
+func StartOTelTracing(ctx *core.Context) {
+	otelTracingOnce.Do(func() {
+		var config OTelTracingConfig
+		if ctx.Config == nil || ctx.Config.GetStruct("otelTracing", &config) != nil {
+			return
+		}
+		if config.ServiceName == "" && ctx.AgentURL != nil {
+			config.ServiceName = ctx.AgentURL.GetParam(core.ApplicationKey, "")
+		}
+		var exporter OTelSpanExporter
+		if config.Endpoint != "" {
+			exporter = NewOTLPExporter(config)
+		}
+		SetOTelTracer(config.SampleRatio, exporter)
+		vlog.Infof("otel tracing is started. endpoint:%s, sampleRatio:%v", config.Endpoint, config.SampleRatio)
+	})
+}
+
+type traceContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+	sampled bool
+	state   string
+}
+
+// parseTraceParent parses the traceparent of the W3C trace context, e.g. 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func parseTraceParent(value string) (traceContext, bool) {
+	var c traceContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return c, false
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return c, false
+	}
+	if _, err := hex.Decode(c.traceID[:], []byte(parts[1])); err != nil || c.traceID == [16]byte{} {
+		return c, false
+	}
+	if _, err := hex.Decode(c.spanID[:], []byte(parts[2])); err != nil || c.spanID == [8]byte{} {
+		return c, false
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return c, false
+	}
+	c.sampled = flags&1 == 1
+	return c, true
+}
+
+func (c traceContext) traceParent() string {
+	flags := "00"
+	if c.sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(c.traceID[:]) + "-" + hex.EncodeToString(c.spanID[:]) + "-" + flags
+}
+
+// sampleTrace samples the new traces by the trace id like the TraceIdRatioBased sampler of OpenTelemetry
+func sampleTrace(traceID [16]byte, ratio float64) bool {
+	if ratio >= 1 {
+		return true
+	}
+	if ratio <= 0 {
+		return false
+	}
+	var v uint64
+	for _, b := range traceID[8:] {
+		v = v<<8 | uint64(b)
+	}
+	return float64(v>>1) < ratio*(1<<63)
+}
+
+type otelContextKey struct{}
+
+// parentTraceContext returns the trace context of the traceparent attachment, or the server span of the context of the
+// request for the outgoing calls of a provider
+func parentTraceContext(request core.Request) (traceContext, bool) {
+	if parent, ok := parseTraceParent(request.GetAttachment(TraceParentKey)); ok {
+		parent.state = request.GetAttachment(TraceStateKey)
+		return parent, true
+	}
+	parent, ok := core.RequestContext(request).Value(otelContextKey{}).(traceContext)
+	return parent, ok
+}
+
+// OTelTracingFilter creates the OpenTelemetry spans of the calls of the providers and the endpoints, the trace context
+// is propagated by the traceparent and tracestate attachments of the requests. the server span is put into the context
+// of the request, so it is the parent of the client spans of the calls made with the context(see core.RequestContext)
+type OTelTracingFilter struct {
+	next core.EndPointFilter
+}
+
+func (o *OTelTracingFilter) NewFilter(url *core.URL) core.Filter {
+	return &OTelTracingFilter{}
+}
+
+func (o *OTelTracingFilter) Filter(caller core.Caller, request core.Request) core.Response {
+	tracer := getOTelTracer()
+	kind := OTelSpanKindClient
+	if _, ok := caller.(core.Provider); ok {
+		kind = OTelSpanKindServer
+	}
+	span := &OTelSpan{Name: spanName(&request), Kind: kind, Start: time.Now()}
+	current, ok := parentTraceContext(request)
+	if ok {
+		span.ParentSpanID = current.spanID
+	} else {
+		rand.Read(current.traceID[:])
+		current.sampled = sampleTrace(current.traceID, tracer.sampleRatio)
+	}
+	rand.Read(current.spanID[:])
+	span.TraceID = current.traceID
+	span.SpanID = current.spanID
+	span.TraceState = current.state
+	if kind == OTelSpanKindServer {
+		rc := request.GetRPCContext(true)
+		rc.Context = context.WithValue(core.RequestContext(request), otelContextKey{}, current)
+	} else {
+		// the request may be retried, so the traceparent of the caller is restored after the call
+		original := request.GetAttachment(TraceParentKey)
+		defer func() {
+			if original == "" {
+				request.GetAttachments().Delete(TraceParentKey)
+			} else {
+				request.SetAttachment(TraceParentKey, original)
+			}
+		}()
+		request.SetAttachment(TraceParentKey, current.traceParent())
+		if current.state != "" {
+			request.SetAttachment(TraceStateKey, current.state)
+		}
+	}
+
+	response := o.GetNext().Filter(caller, request)
+
+	if !current.sampled || tracer.exporter == nil {
+		return response
+	}
+	span.End = time.Now()
+	span.Attributes = map[string]interface{}{
+		"rpc.system":  "motan",
+		"rpc.service": request.GetServiceName(),
+		"rpc.method":  request.GetMethod(),
+	}
+	if url := caller.GetURL(); url != nil {
+		span.Attributes["server.address"] = url.Host
+		span.Attributes["server.port"] = int64(url.Port)
+		if url.Group != "" {
+			span.Attributes["motan.group"] = url.Group
+		}
+	}
+	if response != nil && response.GetException() != nil {
+		e := response.GetException()
+		span.Error = true
+		span.ErrorMessage = e.ErrMsg
+		span.Attributes["motan.error_code"] = int64(e.ErrCode)
+		span.Attributes["motan.error_type"] = int64(e.ErrType)
+	}
+	tracer.exporter.Export(span)
+	return response
+}
+
+func (o *OTelTracingFilter) SetNext(nextFilter core.EndPointFilter) {
+	o.next = nextFilter
+}
+
+func (o *OTelTracingFilter) GetNext() core.EndPointFilter {
+	return o.next
+}
+
+func (o *OTelTracingFilter) GetName() string {
+	return OTelTrace
+}
+
+func (o *OTelTracingFilter) HasNext() bool {
+	return o.next != nil
+}
+
+func (o *OTelTracingFilter) GetIndex() int {
+	return 2
+}
+
+func (o *OTelTracingFilter) GetType() int32 {
+	return core.EndPointFilterType
+}
@@ -0,0 +1,191 @@
+package filter
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/weibocom/motan-go/core"
+)
+
+type recordExporter struct {
+	lock  sync.Mutex
+	spans []*OTelSpan
+}
+
+func (r *recordExporter) Export(span *OTelSpan) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.spans = append(r.spans, span)
+}
+
+func newOTelRequest() *core.MotanRequest {
+	return &core.MotanRequest{
+		RequestID:   1,
+		Attachment:  core.NewStringMap(3),
+		Method:      "foo",
+		ServiceName: "FooService",
+		MethodDesc:  "FooService.foo()",
+		RPCContext:  &core.RPCContext{},
+	}
+}
+
+func TestParseTraceParent(t *testing.T) {
+	c, ok := parseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.True(t, ok)
+	assert.True(t, c.sampled)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", c.traceParent())
+	c, ok = parseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	assert.True(t, ok)
+	assert.False(t, c.sampled)
+	// the future versions may have more fields
+	_, ok = parseTraceParent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-xx")
+	assert.True(t, ok)
+	for _, v := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-xx",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902bz-01",
+	} {
+		_, ok = parseTraceParent(v)
+		assert.False(t, ok, v)
+	}
+}
+
+func TestSampleTrace(t *testing.T) {
+	id := [16]byte{15: 1}
+	assert.True(t, sampleTrace(id, 1))
+	assert.False(t, sampleTrace(id, 0))
+	assert.True(t, sampleTrace(id, 0.5))
+	id[8] = 0xff
+	assert.False(t, sampleTrace(id, 0.5))
+}
+
+func TestOTelTracingFilter_Client(t *testing.T) {
+	exporter := &recordExporter{}
+	SetOTelTracer(0, exporter)
+	defer SetOTelTracer(0, nil)
+
+	req := newOTelRequest()
+	req.SetAttachment(TraceParentKey, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.SetAttachment(TraceStateKey, "k=v")
+	var sent string
+	filter := &OTelTracingFilter{next: &MockFilter{filter: func(caller core.Caller, request core.Request) core.Response {
+		sent = request.GetAttachment(TraceParentKey)
+		return &MockResponse{}
+	}}}
+	referrer := &Referrer{url: core.URL{Host: "1.2.3.4", Port: 8065, Group: "test-group"}}
+	filter.Filter(referrer, req)
+
+	c, ok := parseTraceParent(sent)
+	assert.True(t, ok)
+	assert.True(t, c.sampled)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", hex.EncodeToString(c.traceID[:]))
+	assert.NotEqual(t, "00f067aa0ba902b7", hex.EncodeToString(c.spanID[:]))
+	// the traceparent of the caller is restored for the retries
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", req.GetAttachment(TraceParentKey))
+
+	assert.Equal(t, 1, len(exporter.spans))
+	span := exporter.spans[0]
+	assert.Equal(t, OTelSpanKindClient, span.Kind)
+	assert.Equal(t, c.spanID, span.SpanID)
+	assert.Equal(t, "00f067aa0ba902b7", hex.EncodeToString(span.ParentSpanID[:]))
+	assert.Equal(t, "k=v", span.TraceState)
+	assert.Equal(t, "FooService", span.Attributes["rpc.service"])
+	assert.Equal(t, int64(8065), span.Attributes["server.port"])
+	assert.Equal(t, "test-group", span.Attributes["motan.group"])
+
+	// the new traces are not sampled with ratio 0
+	req = newOTelRequest()
+	filter.Filter(referrer, req)
+	c, ok = parseTraceParent(sent)
+	assert.True(t, ok)
+	assert.False(t, c.sampled)
+	assert.Equal(t, "", req.GetAttachment(TraceParentKey))
+	assert.Equal(t, 1, len(exporter.spans))
+}
+
+func TestOTelTracingFilter_Server(t *testing.T) {
+	exporter := &recordExporter{}
+	SetOTelTracer(1, exporter)
+	defer SetOTelTracer(0, nil)
+
+	client := &OTelTracingFilter{next: &MockFilter{filter: func(caller core.Caller, request core.Request) core.Response {
+		return &MockResponse{}
+	}}}
+	referrer := &Referrer{url: core.URL{Host: "1.2.3.4", Port: 8065}}
+	server := &OTelTracingFilter{next: &MockFilter{filter: func(caller core.Caller, request core.Request) core.Response {
+		// an outgoing call of the provider with the context of the request
+		outgoing := newOTelRequest()
+		outgoing.GetRPCContext(true).Context = core.RequestContext(request)
+		client.Filter(referrer, outgoing)
+		return &core.MotanResponse{Exception: &core.Exception{ErrCode: 503, ErrMsg: "unavailable", ErrType: core.ServiceException}}
+	}}}
+	server.Filter(&Provider{url: &core.URL{Host: "127.0.0.1", Port: 8002}}, newOTelRequest())
+
+	assert.Equal(t, 2, len(exporter.spans))
+	clientSpan, serverSpan := exporter.spans[0], exporter.spans[1]
+	assert.Equal(t, OTelSpanKindServer, serverSpan.Kind)
+	assert.Equal(t, [8]byte{}, serverSpan.ParentSpanID)
+	assert.True(t, serverSpan.Error)
+	assert.Equal(t, "unavailable", serverSpan.ErrorMessage)
+	assert.Equal(t, int64(503), serverSpan.Attributes["motan.error_code"])
+	assert.Equal(t, serverSpan.TraceID, clientSpan.TraceID)
+	assert.Equal(t, serverSpan.SpanID, clientSpan.ParentSpanID)
+}
+
+func TestOTLPExporter(t *testing.T) {
+	bodies := make(chan []byte, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies <- body
+	}))
+	defer ts.Close()
+
+	exporter := NewOTLPExporter(OTelTracingConfig{Endpoint: ts.URL, ServiceName: "test-service", BatchSize: 2, FlushInterval: 60000})
+	now := time.Now()
+	exporter.Export(&OTelSpan{TraceID: [16]byte{0: 1}, SpanID: [8]byte{0: 2}, Name: "FooService.foo", Kind: OTelSpanKindServer,
+		Start: now, End: now, Attributes: map[string]interface{}{"rpc.system": "motan", "server.port": int64(8002)}})
+	exporter.Export(&OTelSpan{TraceID: [16]byte{0: 1}, SpanID: [8]byte{0: 3}, ParentSpanID: [8]byte{0: 2}, Name: "BarService.bar",
+		Kind: OTelSpanKindClient, Start: now, End: now, Error: true, ErrorMessage: "timeout"})
+
+	var body []byte
+	select {
+	case body = <-bodies:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the spans are not exported")
+	}
+	var traces struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []otlpKeyValue
+			}
+			ScopeSpans []struct {
+				Spans []otlpSpan
+			}
+		}
+	}
+	assert.Nil(t, json.Unmarshal(body, &traces))
+	assert.Equal(t, 1, len(traces.ResourceSpans))
+	assert.Equal(t, "test-service", traces.ResourceSpans[0].Resource.Attributes[0].Value["stringValue"])
+	spans := traces.ResourceSpans[0].ScopeSpans[0].Spans
+	assert.Equal(t, 2, len(spans))
+	assert.Equal(t, "01000000000000000000000000000000", spans[0].TraceID)
+	assert.Equal(t, "", spans[0].ParentSpanID)
+	assert.Equal(t, 2, len(spans[0].Attributes))
+	assert.Nil(t, spans[0].Status)
+	assert.Equal(t, "0200000000000000", spans[1].ParentSpanID)
+	assert.Equal(t, 2, spans[1].Status.Code)
+	assert.Equal(t, "timeout", spans[1].Status.Message)
+}
@@ -0,0 +1,189 @@
+package filter
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/weibocom/motan-go/log"
+)
+
+const (
+	defaultOTLPBatchSize     = 512
+	defaultOTLPFlushInterval = 5 * time.Second
+	defaultOTLPTimeout       = 10 * time.Second
+	otlpQueueSize            = 4096
+	otlpTracesPath           = "/v1/traces"
+)
+
+// OTLPExporter exports the spans in batches to an OTLP/HTTP collector with the json encoding. the spans are
+// dropped if the queue is full, so the calls are never blocked by the collector
+type OTLPExporter struct {
+	url           string
+	serviceName   string
+	batchSize     int
+	flushInterval time.Duration
+	client        *http.Client
+	queue         chan *OTelSpan
+}
+
+// NewOTLPExporter starts an exporter to the endpoint of the config, e.g. http://127.0.0.1:4318
+func NewOTLPExporter(config OTelTracingConfig) *OTLPExporter {
+	e := &OTLPExporter{
+		url:           strings.TrimRight(config.Endpoint, "/") + otlpTracesPath,
+		serviceName:   config.ServiceName,
+		batchSize:     config.BatchSize,
+		flushInterval: time.Duration(config.FlushInterval) * time.Millisecond,
+		client:        &http.Client{Timeout: time.Duration(config.Timeout) * time.Millisecond},
+		queue:         make(chan *OTelSpan, otlpQueueSize),
+	}
+	if strings.HasSuffix(config.Endpoint, otlpTracesPath) {
+		e.url = config.Endpoint
+	}
+	if e.batchSize <= 0 {
+		e.batchSize = defaultOTLPBatchSize
+	}
+	if e.flushInterval <= 0 {
+		e.flushInterval = defaultOTLPFlushInterval
+	}
+	if e.client.Timeout <= 0 {
+		e.client.Timeout = defaultOTLPTimeout
+	}
+	go e.run()
+	return e
+}
+
+func (e *OTLPExporter) Export(span *OTelSpan) {
+	select {
+	case e.queue <- span:
+	default:
+		vlog.Warningf("otlp exporter queue is full, span %s is dropped", span.Name)
+	}
+}
+
+func (e *OTLPExporter) run() {
+	ticker := time.NewTicker(e.flushInterval)
+	defer ticker.Stop()
+	batch := make([]*OTelSpan, 0, e.batchSize)
+	for {
+		select {
+		case span := <-e.queue:
+			if batch = append(batch, span); len(batch) < e.batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := e.send(batch); err != nil {
+			vlog.Warningf("export %d spans to %s fail. err:%v", len(batch), e.url, err)
+		}
+		batch = batch[:0]
+	}
+}
+
+func (e *OTLPExporter) send(spans []*OTelSpan) error {
+	body, err := json.Marshal(buildOTLPTraces(e.serviceName, spans))
+	if err != nil {
+		return err
+	}
+	res, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return &otlpStatusError{status: res.Status}
+	}
+	return nil
+}
+
+type otlpStatusError struct {
+	status string
+}
+
+func (o *otlpStatusError) Error() string {
+	return "otlp collector responds " + o.status
+}
+
+// the json encoding of the OTLP ExportTraceServiceRequest
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	TraceState        string          `json:"traceState,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue  `json:"attributes,omitempty"`
+	Status            *otlpSpanStatus `json:"status,omitempty"`
+}
+
+type otlpSpanStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+func buildOTLPTraces(serviceName string, spans []*OTelSpan) map[string]interface{} {
+	otlpSpans := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		o := otlpSpan{
+			TraceID:           hex.EncodeToString(s.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.SpanID[:]),
+			TraceState:        s.TraceState,
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.Attributes),
+		}
+		if s.ParentSpanID != ([8]byte{}) {
+			o.ParentSpanID = hex.EncodeToString(s.ParentSpanID[:])
+		}
+		if s.Error {
+			o.Status = &otlpSpanStatus{Code: 2, Message: s.ErrorMessage}
+		}
+		otlpSpans = append(otlpSpans, o)
+	}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(map[string]interface{}{"service.name": serviceName}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": "motan-go"},
+				"spans": otlpSpans,
+			}},
+		}},
+	}
+}
+
+func otlpAttributes(attributes map[string]interface{}) []otlpKeyValue {
+	keyValues := make([]otlpKeyValue, 0, len(attributes))
+	for k, v := range attributes {
+		var value map[string]interface{}
+		switch x := v.(type) {
+		case string:
+			value = map[string]interface{}{"stringValue": x}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(x, 10)}
+		case bool:
+			value = map[string]interface{}{"boolValue": x}
+		default:
+			continue
+		}
+		keyValues = append(keyValues, otlpKeyValue{Key: k, Value: value})
+	}
+	return keyValues
+}
@@ -0,0 +1,87 @@
+package filter
+
+import (
+	"math"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/juju/ratelimit"
+	"github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// MaxConcurrentKey is the url parameter of the max concurrent requests of ProviderRateLimitFilter, 0 means no limit
+const MaxConcurrentKey = "maxConcurrent"
+
+// ProviderRateLimitFilter rejects the requests over the provider qps(url parameter 'rateLimit') or the max concurrent
+// requests(url parameter 'maxConcurrent') with a 429 exception, unlike RateLimitFilter the requests never wait for tokens.
+// every provider has its own limits, 0 or absent means no limit
+type ProviderRateLimitFilter struct {
+	bucket        *ratelimit.Bucket
+	maxConcurrent int64
+	concurrent    int64
+	next          core.EndPointFilter
+}
+
+func (p *ProviderRateLimitFilter) NewFilter(url *core.URL) core.Filter {
+	ret := &ProviderRateLimitFilter{}
+	if value := url.GetParam(RateLimit, ""); value != "" {
+		if rate, err := strconv.ParseFloat(value, 64); err != nil || rate < 0 {
+			vlog.Warningf("[providerRateLimit] parse %s config error:%v, value:%s", RateLimit, err, value)
+		} else if rate > 0 {
+			// allow the burst of one second
+			ret.bucket = ratelimit.NewBucketWithRate(rate, int64(math.Ceil(rate)))
+		}
+	}
+	if value := url.GetParam(MaxConcurrentKey, ""); value != "" {
+		if max, err := strconv.ParseInt(value, 10, 64); err != nil || max < 0 {
+			vlog.Warningf("[providerRateLimit] parse %s config error:%v, value:%s", MaxConcurrentKey, err, value)
+		} else {
+			ret.maxConcurrent = max
+		}
+	}
+	return ret
+}
+
+func (p *ProviderRateLimitFilter) Filter(caller core.Caller, request core.Request) core.Response {
+	if p.maxConcurrent > 0 {
+		if atomic.AddInt64(&p.concurrent, 1) > p.maxConcurrent {
+			atomic.AddInt64(&p.concurrent, -1)
+			return p.reject(request, "too many concurrent requests, max concurrent: "+strconv.FormatInt(p.maxConcurrent, 10))
+		}
+		defer atomic.AddInt64(&p.concurrent, -1)
+	}
+	if p.bucket != nil && p.bucket.TakeAvailable(1) == 0 {
+		return p.reject(request, "request rate exceeds the limit: "+strconv.FormatFloat(p.bucket.Rate(), 'f', -1, 64))
+	}
+	return p.GetNext().Filter(caller, request)
+}
+
+func (p *ProviderRateLimitFilter) reject(request core.Request, msg string) core.Response {
+	vlog.Warningf("[providerRateLimit] reject request. %s, req:%s", msg, core.GetReqInfo(request))
+	return core.BuildExceptionResponse(request.GetRequestID(), &core.Exception{ErrCode: 429, ErrMsg: msg, ErrType: core.ServiceException})
+}
+
+func (p *ProviderRateLimitFilter) SetNext(nextFilter core.EndPointFilter) {
+	p.next = nextFilter
+}
+
+func (p *ProviderRateLimitFilter) GetNext() core.EndPointFilter {
+	return p.next
+}
+
+func (p *ProviderRateLimitFilter) GetName() string {
+	return ProviderRateLimit
+}
+
+func (p *ProviderRateLimitFilter) HasNext() bool {
+	return p.next != nil
+}
+
+func (p *ProviderRateLimitFilter) GetIndex() int {
+	return 3
+}
+
+func (p *ProviderRateLimitFilter) GetType() int32 {
+	return core.EndPointFilterType
+}
@@ -0,0 +1,85 @@
+package filter
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/weibocom/motan-go/core"
+)
+
+type blockingCaller struct {
+	defaultParamsCaller
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingCaller) Call(request core.Request) core.Response {
+	b.started <- struct{}{}
+	<-b.release
+	return &core.MotanResponse{RequestID: request.GetRequestID()}
+}
+
+func newProviderRateLimitFilter(factory core.ExtensionFactory, params map[string]string) core.EndPointFilter {
+	url := &core.URL{Host: "127.0.0.1", Port: 7888, Path: "rateLimitService", Parameters: params}
+	f := factory.GetFilter(ProviderRateLimit).NewFilter(url).(core.EndPointFilter)
+	f.SetNext(core.GetLastEndPointFilter())
+	return f
+}
+
+func TestProviderRateLimitFilter(t *testing.T) {
+	factory := &core.DefaultExtensionFactory{}
+	factory.Initialize()
+	RegistDefaultFilters(factory)
+	caller := &defaultParamsCaller{}
+	request := &core.MotanRequest{ServiceName: "rateLimitService", Method: "hello"}
+
+	// the burst over the rate is rejected
+	f := newProviderRateLimitFilter(factory, map[string]string{RateLimit: "5"})
+	rejected := 0
+	for i := 0; i < 25; i++ {
+		if res := f.Filter(caller, request); res.GetException() != nil {
+			assert.Equal(t, 429, res.GetException().ErrCode)
+			rejected++
+		}
+	}
+	assert.Equal(t, 20, rejected)
+
+	// every provider has its own bucket
+	other := newProviderRateLimitFilter(factory, map[string]string{RateLimit: "5"})
+	assert.Nil(t, other.Filter(caller, request).GetException())
+
+	// the requests over the max concurrent are rejected
+	blocking := &blockingCaller{started: make(chan struct{}, 3), release: make(chan struct{})}
+	f = newProviderRateLimitFilter(factory, map[string]string{MaxConcurrentKey: "2"})
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, f.Filter(blocking, request).GetException())
+		}()
+	}
+	<-blocking.started
+	<-blocking.started
+	res := f.Filter(blocking, request)
+	assert.NotNil(t, res.GetException())
+	assert.Equal(t, 429, res.GetException().ErrCode)
+	close(blocking.release)
+	wg.Wait()
+	assert.Nil(t, f.Filter(blocking, request).GetException())
+}
+
+func TestProviderRateLimitFilterDisabled(t *testing.T) {
+	factory := &core.DefaultExtensionFactory{}
+	factory.Initialize()
+	RegistDefaultFilters(factory)
+	caller := &defaultParamsCaller{}
+	request := &core.MotanRequest{ServiceName: "rateLimitService", Method: "hello"}
+	for _, params := range []map[string]string{nil, {RateLimit: "0", MaxConcurrentKey: "0"}, {RateLimit: "illegal"}} {
+		f := newProviderRateLimitFilter(factory, params)
+		for i := 0; i < 2000; i++ {
+			assert.Nil(t, f.Filter(caller, request).GetException())
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package filter
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/juju/ratelimit"
+	"github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+	"github.com/weibocom/motan-go/protocol"
+)
+
+// the url parameters of QuotaRateLimitFilter, they can be changed at runtime by UpdateQuota
+const (
+	QuotaRateKey      = "quotaRate"  // tokens per second, 0 or absent means no limit
+	QuotaBurstKey     = "quotaBurst" // the capacity of the buckets, default the rate of one second
+	QuotaDimensionKey = "quotaDimension"
+	// QuotaRedisKey is the address of the redis sharing the quota across the replicas, the buckets are local if it is empty
+	QuotaRedisKey        = "quotaRedis"
+	QuotaRedisTimeoutKey = "quotaRedisTimeout" // ms
+)
+
+// the dimensions of the buckets, they can be combined by ',', e.g. method,caller. the caller is the application of the
+// request(protocol.MSource)
+const (
+	QuotaDimensionService = "service"
+	QuotaDimensionMethod  = "method"
+	QuotaDimensionCaller  = "caller"
+)
+
+const defaultQuotaRedisTimeout = 100 * time.Millisecond
+
+var (
+	// the quota parameters changed at runtime by service path
+	quotaUpdates        = core.NewCopyOnWriteMap()
+	quotaUpdatesVersion int64
+)
+
+// UpdateQuota changes the quota parameters(QuotaRateKey, QuotaBurstKey, QuotaDimensionKey, QuotaRedisKey) of the
+// QuotaRateLimitFilter of the service, the absent parameters are the values of the provider url. the buckets are reset
+func UpdateQuota(service string, params map[string]string) error {
+	if service == "" {
+		return errors.New("service is empty")
+	}
+	update := make(map[string]string, len(params))
+	for k, v := range params {
+		switch k {
+		case QuotaRateKey, QuotaBurstKey:
+			if f, err := strconv.ParseFloat(v, 64); err != nil || f < 0 {
+				return errors.New("illegal " + k + ": " + v)
+			}
+		case QuotaDimensionKey:
+			if _, err := parseQuotaDimensions(v); err != nil {
+				return err
+			}
+		case QuotaRedisKey, QuotaRedisTimeoutKey:
+		default:
+			continue
+		}
+		update[k] = v
+	}
+	quotaUpdates.Store(service, update)
+	atomic.AddInt64(&quotaUpdatesVersion, 1)
+	vlog.Infof("[quotaRateLimit] quota of %s is updated: %v", service, update)
+	return nil
+}
+
+// ResetQuota removes the quota parameters changed by UpdateQuota
+func ResetQuota(service string) {
+	quotaUpdates.Delete(service)
+	atomic.AddInt64(&quotaUpdatesVersion, 1)
+}
+
+func getQuotaUpdate(service string) map[string]string {
+	if v, ok := quotaUpdates.Load(service); ok {
+		return v.(map[string]string)
+	}
+	return nil
+}
+
+func parseQuotaDimensions(value string) ([]string, error) {
+	var dimensions []string
+	for _, d := range core.TrimSplit(value, ",") {
+		switch d {
+		case QuotaDimensionService:
+		case QuotaDimensionMethod, QuotaDimensionCaller:
+			dimensions = append(dimensions, d)
+		default:
+			return nil, errors.New("illegal " + QuotaDimensionKey + ": " + value)
+		}
+	}
+	return dimensions, nil
+}
+
+// quotaLimiter takes the tokens of the buckets of a config, the buckets are created on demand by the dimension key
+type quotaLimiter struct {
+	rate       float64
+	burst      int64
+	dimensions []string
+	redis      *redisQuota
+	lock       sync.RWMutex
+	buckets    map[string]*ratelimit.Bucket
+}
+
+func newQuotaLimiter(url *core.URL, update map[string]string) *quotaLimiter {
+	param := func(key string) string {
+		if v, ok := update[key]; ok {
+			return v
+		}
+		return url.GetParam(key, "")
+	}
+	rate, err := strconv.ParseFloat(param(QuotaRateKey), 64)
+	if err != nil || rate <= 0 {
+		return nil
+	}
+	l := &quotaLimiter{rate: rate, burst: int64(math.Ceil(rate)), buckets: make(map[string]*ratelimit.Bucket)}
+	if burst, err := strconv.ParseFloat(param(QuotaBurstKey), 64); err == nil && burst >= 1 {
+		l.burst = int64(burst)
+	}
+	if l.dimensions, err = parseQuotaDimensions(param(QuotaDimensionKey)); err != nil {
+		vlog.Warningf("[quotaRateLimit] %v, the quota of %s is limited by service", err, url.Path)
+	}
+	if address := param(QuotaRedisKey); address != "" {
+		timeout := defaultQuotaRedisTimeout
+		if ms, err := strconv.ParseInt(param(QuotaRedisTimeoutKey), 10, 64); err == nil && ms > 0 {
+			timeout = time.Duration(ms) * time.Millisecond
+		}
+		l.redis = getRedisQuota(address, timeout)
+	}
+	return l
+}
+
+func (l *quotaLimiter) key(service string, request core.Request) string {
+	key := service
+	for _, d := range l.dimensions {
+		if d == QuotaDimensionMethod {
+			key += ":" + request.GetMethod()
+		} else {
+			key += ":" + request.GetAttachment(protocol.MSource)
+		}
+	}
+	return key
+}
+
+func (l *quotaLimiter) take(key string) bool {
+	if l.redis != nil {
+		allowed, err := l.redis.take(key, l.rate, l.burst)
+		if err == nil {
+			return allowed
+		}
+		// the quota of the replica is limited by the local bucket until the redis is recovered
+		vlog.Warningf("[quotaRateLimit] take the quota of %s from redis fail, use the local bucket. err:%v", key, err)
+	}
+	return l.bucket(key).TakeAvailable(1) == 1
+}
+
+func (l *quotaLimiter) bucket(key string) *ratelimit.Bucket {
+	l.lock.RLock()
+	b := l.buckets[key]
+	l.lock.RUnlock()
+	if b != nil {
+		return b
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if b = l.buckets[key]; b == nil {
+		b = ratelimit.NewBucketWithRate(l.rate, l.burst)
+		l.buckets[key] = b
+	}
+	return b
+}
+
+// QuotaRateLimitFilter rejects the requests of the provider with a 429 exception if the token bucket of the request is
+// empty. the buckets are token buckets by the dimensions of QuotaDimensionKey, they can be shared by the replicas of
+// the service with a redis(QuotaRedisKey)
+type QuotaRateLimitFilter struct {
+	url     *core.URL
+	version int64
+	limiter atomic.Value // *quotaLimiter, nil if there is no limit
+	lock    sync.Mutex
+	next    core.EndPointFilter
+}
+
+func (q *QuotaRateLimitFilter) NewFilter(url *core.URL) core.Filter {
+	ret := &QuotaRateLimitFilter{url: url, version: atomic.LoadInt64(&quotaUpdatesVersion)}
+	ret.limiter.Store(newQuotaLimiter(url, getQuotaUpdate(url.Path)))
+	return ret
+}
+
+func (q *QuotaRateLimitFilter) getLimiter() *quotaLimiter {
+	if version := atomic.LoadInt64(&quotaUpdatesVersion); version != atomic.LoadInt64(&q.version) {
+		q.lock.Lock()
+		if version != q.version {
+			q.limiter.Store(newQuotaLimiter(q.url, getQuotaUpdate(q.url.Path)))
+			atomic.StoreInt64(&q.version, version)
+		}
+		q.lock.Unlock()
+	}
+	return q.limiter.Load().(*quotaLimiter)
+}
+
+func (q *QuotaRateLimitFilter) Filter(caller core.Caller, request core.Request) core.Response {
+	if limiter := q.getLimiter(); limiter != nil && !limiter.take(limiter.key(q.url.Path, request)) {
+		msg := "request rate exceeds the quota: " + strconv.FormatFloat(limiter.rate, 'f', -1, 64)
+		vlog.Warningf("[quotaRateLimit] reject request. %s, req:%s", msg, core.GetReqInfo(request))
+		return core.BuildExceptionResponse(request.GetRequestID(), &core.Exception{ErrCode: 429, ErrMsg: msg, ErrType: core.ServiceException})
+	}
+	return q.GetNext().Filter(caller, request)
+}
+
+func (q *QuotaRateLimitFilter) SetNext(nextFilter core.EndPointFilter) {
+	q.next = nextFilter
+}
+
+func (q *QuotaRateLimitFilter) GetNext() core.EndPointFilter {
+	return q.next
+}
+
+func (q *QuotaRateLimitFilter) GetName() string {
+	return QuotaRateLimit
+}
+
+func (q *QuotaRateLimitFilter) HasNext() bool {
+	return q.next != nil
+}
+
+func (q *QuotaRateLimitFilter) GetIndex() int {
+	return 3
+}
+
+func (q *QuotaRateLimitFilter) GetType() int32 {
+	return core.EndPointFilterType
+}
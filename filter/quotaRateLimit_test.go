@@ -0,0 +1,150 @@
+package filter
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/protocol"
+)
+
+func newQuotaRateLimitFilter(factory core.ExtensionFactory, params map[string]string) core.EndPointFilter {
+	url := &core.URL{Host: "127.0.0.1", Port: 7888, Path: "quotaService", Parameters: params}
+	f := factory.GetFilter(QuotaRateLimit).NewFilter(url).(core.EndPointFilter)
+	f.SetNext(core.GetLastEndPointFilter())
+	return f
+}
+
+func countRejected(f core.EndPointFilter, request core.Request, n int) int {
+	rejected := 0
+	for i := 0; i < n; i++ {
+		if res := f.Filter(&defaultParamsCaller{}, request); res.GetException() != nil {
+			rejected++
+		}
+	}
+	return rejected
+}
+
+func TestQuotaRateLimitFilter(t *testing.T) {
+	factory := &core.DefaultExtensionFactory{}
+	factory.Initialize()
+	RegistDefaultFilters(factory)
+	request := &core.MotanRequest{ServiceName: "quotaService", Method: "hello"}
+	other := &core.MotanRequest{ServiceName: "quotaService", Method: "world"}
+	otherCaller := &core.MotanRequest{ServiceName: "quotaService", Method: "hello"}
+	otherCaller.SetAttachment(protocol.MSource, "other-app")
+
+	f := newQuotaRateLimitFilter(factory, nil)
+	assert.Equal(t, 0, countRejected(f, request, 20))
+
+	f = newQuotaRateLimitFilter(factory, map[string]string{QuotaRateKey: "1", QuotaBurstKey: "5"})
+	res := f.Filter(&defaultParamsCaller{}, request)
+	assert.Nil(t, res.GetException())
+	assert.Equal(t, 4, countRejected(f, request, 8))
+	res = f.Filter(&defaultParamsCaller{}, other)
+	assert.Equal(t, 429, res.GetException().ErrCode)
+
+	// per method and caller
+	f = newQuotaRateLimitFilter(factory, map[string]string{QuotaRateKey: "1", QuotaBurstKey: "2", QuotaDimensionKey: "method,caller"})
+	assert.Equal(t, 1, countRejected(f, request, 3))
+	assert.Equal(t, 1, countRejected(f, other, 3))
+	assert.Equal(t, 1, countRejected(f, otherCaller, 3))
+
+	// updated at runtime
+	assert.NotNil(t, UpdateQuota("quotaService", map[string]string{QuotaDimensionKey: "host"}))
+	assert.NotNil(t, UpdateQuota("quotaService", map[string]string{QuotaRateKey: "-1"}))
+	assert.Nil(t, UpdateQuota("quotaService", map[string]string{QuotaBurstKey: "10", "service": "quotaService"}))
+	assert.Equal(t, 0, countRejected(f, request, 10))
+	assert.Equal(t, 1, countRejected(f, request, 1))
+	assert.Nil(t, UpdateQuota("quotaService", map[string]string{QuotaRateKey: "0"}))
+	assert.Equal(t, 0, countRejected(f, request, 20))
+	ResetQuota("quotaService")
+	assert.Equal(t, 8, countRejected(f, request, 10))
+}
+
+// fakeQuotaRedis counts the EVAL calls of the keys, the first 'allowed' calls of a key are allowed
+type fakeQuotaRedis struct {
+	listener net.Listener
+	allowed  int
+	lock     sync.Mutex
+	counts   map[string]int
+}
+
+func newFakeQuotaRedis(t *testing.T, allowed int) *fakeQuotaRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	r := &fakeQuotaRedis{listener: listener, allowed: allowed, counts: make(map[string]int)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+	return r
+}
+
+func (r *fakeQuotaRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		args := make([]string, n)
+		for i := range args {
+			size, _ := reader.ReadString('\n')
+			l, _ := strconv.Atoi(strings.TrimSpace(size[1:]))
+			arg := make([]byte, l+2)
+			io.ReadFull(reader, arg)
+			args[i] = string(arg[:l])
+		}
+		if args[0] != "EVAL" || args[2] != "1" {
+			conn.Write([]byte("-ERR unknown command\r\n"))
+			continue
+		}
+		r.lock.Lock()
+		r.counts[args[3]]++
+		reply := ":0\r\n"
+		if r.counts[args[3]] <= r.allowed {
+			reply = ":1\r\n"
+		}
+		r.lock.Unlock()
+		conn.Write([]byte(reply))
+	}
+}
+
+func TestQuotaRateLimitFilter_Redis(t *testing.T) {
+	factory := &core.DefaultExtensionFactory{}
+	factory.Initialize()
+	RegistDefaultFilters(factory)
+	redis := newFakeQuotaRedis(t, 3)
+	request := &core.MotanRequest{ServiceName: "quotaService", Method: "hello"}
+
+	// the replicas share the quota
+	params := map[string]string{QuotaRateKey: "100", QuotaRedisKey: redis.listener.Addr().String(), QuotaDimensionKey: "method"}
+	f1 := newQuotaRateLimitFilter(factory, params)
+	f2 := newQuotaRateLimitFilter(factory, params)
+	assert.Equal(t, 0, countRejected(f1, request, 2))
+	assert.Equal(t, 1, countRejected(f2, request, 2))
+	assert.Equal(t, 2, countRejected(f1, request, 2))
+	redis.lock.Lock()
+	assert.Equal(t, 6, redis.counts[redisQuotaKeyPrefix+"quotaService:hello"])
+	redis.lock.Unlock()
+
+	// limited by the local bucket if the redis is unavailable
+	redis.listener.Close()
+	address := redis.listener.Addr().String()
+	f := newQuotaRateLimitFilter(factory, map[string]string{QuotaRateKey: "1", QuotaBurstKey: "2", QuotaRedisKey: address, QuotaRedisTimeoutKey: "50"})
+	assert.Equal(t, 3, countRejected(f, request, 5))
+}
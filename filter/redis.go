@@ -0,0 +1,118 @@
+package filter
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const redisPoolSize = 8
+
+var (
+	redisPools     = make(map[string]*redisPool)
+	redisPoolsLock sync.Mutex
+)
+
+// redisPool sends the commands to a redis, the connections are pooled and closed on errors
+type redisPool struct {
+	address string
+	timeout time.Duration
+	conns   chan *redisConn
+}
+
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// getRedisPool shares the connections of the same redis
+func getRedisPool(address string, timeout time.Duration) *redisPool {
+	redisPoolsLock.Lock()
+	defer redisPoolsLock.Unlock()
+	key := address + "/" + timeout.String()
+	r := redisPools[key]
+	if r == nil {
+		r = &redisPool{address: address, timeout: timeout, conns: make(chan *redisConn, redisPoolSize)}
+		redisPools[key] = r
+	}
+	return r
+}
+
+// do returns the reply of the command, it is an int64 of the integer replies, a string of the simple string replies
+// or a []byte of the bulk string replies, nil if the bulk string is absent
+func (r *redisPool) do(args ...string) (interface{}, error) {
+	var c *redisConn
+	select {
+	case c = <-r.conns:
+	default:
+		conn, err := net.DialTimeout("tcp", r.address, r.timeout)
+		if err != nil {
+			return nil, err
+		}
+		c = &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+	}
+	c.conn.SetDeadline(time.Now().Add(r.timeout))
+	reply, err := c.do(args)
+	if err != nil {
+		c.conn.Close()
+		return nil, err
+	}
+	select {
+	case r.conns <- c:
+	default:
+		c.conn.Close()
+	}
+	return reply, nil
+}
+
+// do sends a command of the RESP protocol, the array replies are not supported
+func (c *redisConn) do(args []string) (interface{}, error) {
+	buf := make([]byte, 0, 512)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	if _, err := c.conn.Write(buf); err != nil {
+		return nil, err
+	}
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 {
+		return nil, errors.New("illegal redis reply: " + line)
+	}
+	line = line[:len(line)-2]
+	switch line[0] {
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, errors.New("redis error: " + line[1:])
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, errors.New("illegal redis reply: " + line)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		b := make([]byte, size+2)
+		if _, err := io.ReadFull(c.reader, b); err != nil {
+			return nil, err
+		}
+		return b[:size], nil
+	}
+	return nil, errors.New("unexpected redis reply: " + line)
+}
@@ -0,0 +1,52 @@
+package filter
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+const redisQuotaKeyPrefix = "motan:quota:"
+
+// redisQuotaScript takes a token of the bucket of KEYS[1] with the rate ARGV[1], the burst ARGV[2] and the current
+// time(ms) ARGV[3], it returns 1 if the token is taken
+const redisQuotaScript = `local v = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local rate, burst, now = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+local tokens, ts = tonumber(v[1]), tonumber(v[2])
+if tokens == nil or ts == nil then
+  tokens, ts = burst, now
+end
+if now > ts then
+  tokens = math.min(burst, tokens + (now - ts) * rate / 1000)
+  ts = now
+end
+local allowed = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+end
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(ts))
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst * 1000 / rate) + 1000)
+return allowed`
+
+// redisQuota takes the tokens of the buckets in a redis
+type redisQuota struct {
+	*redisPool
+}
+
+func getRedisQuota(address string, timeout time.Duration) *redisQuota {
+	return &redisQuota{redisPool: getRedisPool(address, timeout)}
+}
+
+func (r *redisQuota) take(key string, rate float64, burst int64) (bool, error) {
+	reply, err := r.do("EVAL", redisQuotaScript, "1", redisQuotaKeyPrefix+key,
+		strconv.FormatFloat(rate, 'f', -1, 64), strconv.FormatInt(burst, 10), strconv.FormatInt(time.Now().UnixNano()/1e6, 10))
+	if err != nil {
+		return false, err
+	}
+	allowed, ok := reply.(int64)
+	if !ok {
+		return false, errors.New("unexpected redis reply of the quota script")
+	}
+	return allowed == 1, nil
+}
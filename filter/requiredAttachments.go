@@ -0,0 +1,120 @@
+package filter
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+
+	"github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// allRequiredAttachmentsMethod is the method name in requiredAttachments config which applies to all methods
+const allRequiredAttachmentsMethod = "*"
+
+type requiredAttachment struct {
+	key    string
+	format *regexp.Regexp // nil means only presence is required
+}
+
+// RequiredAttachmentsFilter rejects requests missing the required attachments with a 400 exception.
+// the required attachments are configured by url parameter 'requiredAttachments' as a json map of method name to
+// attachment keys and their formats(regular expressions, empty means any value), e.g.
+// {"*":{"tenant":""},"create":{"traceId":"^[0-9a-f]{16}$"}}, method '*' applies to all methods
+type RequiredAttachmentsFilter struct {
+	required map[string][]requiredAttachment
+	next     core.EndPointFilter
+}
+
+func (r *RequiredAttachmentsFilter) NewFilter(url *core.URL) core.Filter {
+	ret := &RequiredAttachmentsFilter{required: make(map[string][]requiredAttachment)}
+	value := url.GetParam(RequiredAttachments, "")
+	if value == "" {
+		return ret
+	}
+	var config map[string]map[string]string
+	if err := json.Unmarshal([]byte(value), &config); err != nil {
+		vlog.Warningf("[requiredAttachments] parse %s config error:%v", RequiredAttachments, err)
+		return ret
+	}
+	for method, keys := range config {
+		attachments := make([]requiredAttachment, 0, len(keys))
+		for key, format := range keys {
+			a := requiredAttachment{key: key}
+			if format != "" {
+				re, err := regexp.Compile(format)
+				if err != nil {
+					vlog.Warningf("[requiredAttachments] illegal format of attachment %s, only the presence is checked. err:%v", key, err)
+				} else {
+					a.format = re
+				}
+			}
+			attachments = append(attachments, a)
+		}
+		// check in key order, so the error message is stable
+		sort.Slice(attachments, func(i, j int) bool { return attachments[i].key < attachments[j].key })
+		ret.required[method] = attachments
+	}
+	return ret
+}
+
+func (r *RequiredAttachmentsFilter) Filter(caller core.Caller, request core.Request) core.Response {
+	if msg := r.check(request, r.required[allRequiredAttachmentsMethod]); msg != "" {
+		return r.reject(request, msg)
+	}
+	if msg := r.check(request, r.required[request.GetMethod()]); msg != "" {
+		return r.reject(request, msg)
+	}
+	return r.GetNext().Filter(caller, request)
+}
+
+func (r *RequiredAttachmentsFilter) check(request core.Request, attachments []requiredAttachment) string {
+	for _, a := range attachments {
+		value := request.GetAttachment(a.key)
+		if value == "" {
+			return "required attachment is missing: " + a.key
+		}
+		if a.format != nil && !a.format.MatchString(value) {
+			return "required attachment has illegal format: " + a.key
+		}
+	}
+	return ""
+}
+
+func (r *RequiredAttachmentsFilter) reject(request core.Request, msg string) core.Response {
+	vlog.Warningf("[requiredAttachments] reject request. %s, req:%s", msg, core.GetReqInfo(request))
+	return core.BuildExceptionResponse(request.GetRequestID(), &core.Exception{ErrCode: 400, ErrMsg: msg, ErrType: core.ServiceException})
+}
+
+func (r *RequiredAttachmentsFilter) SetNext(nextFilter core.EndPointFilter) {
+	r.next = nextFilter
+}
+
+func (r *RequiredAttachmentsFilter) GetNext() core.EndPointFilter {
+	return r.next
+}
+
+func (r *RequiredAttachmentsFilter) GetName() string {
+	return RequiredAttachments
+}
+
+func (r *RequiredAttachmentsFilter) HasNext() bool {
+	return r.next != nil
+}
+
+// GetIndex runs after DefaultParamsFilter, so the default params are counted
+func (r *RequiredAttachmentsFilter) GetIndex() int {
+	return 11
+}
+
+func (r *RequiredAttachmentsFilter) GetType() int32 {
+	return core.EndPointFilterType
+}
+
+func (r *RequiredAttachmentsFilter) ConflictFilters() []string {
+	return nil
+}
+
+func (r *RequiredAttachmentsFilter) AfterFilters() []string {
+	return []string{DefaultParams}
+}
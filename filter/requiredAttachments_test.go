@@ -0,0 +1,47 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/weibocom/motan-go/core"
+)
+
+func TestRequiredAttachmentsFilter(t *testing.T) {
+	factory := &core.DefaultExtensionFactory{}
+	factory.Initialize()
+	RegistDefaultFilters(factory)
+	url := &core.URL{Host: "127.0.0.1", Port: 7888, Path: "requiredService", Parameters: map[string]string{
+		RequiredAttachments: `{"*":{"tenant":""},"create":{"traceId":"^[0-9a-f]{4}$","bad":"("}}`,
+	}}
+	f := factory.GetFilter(RequiredAttachments).NewFilter(url).(core.EndPointFilter)
+	f.SetNext(core.GetLastEndPointFilter())
+	caller := &defaultParamsCaller{}
+	query := &core.MotanRequest{ServiceName: "requiredService", Method: "query"}
+	res := f.Filter(caller, query)
+	assert.NotNil(t, res.GetException())
+	assert.Equal(t, 400, res.GetException().ErrCode)
+	assert.Contains(t, res.GetException().ErrMsg, "tenant")
+	query.SetAttachment("tenant", "t1")
+	assert.Nil(t, f.Filter(caller, query).GetException())
+
+	// the attachment with illegal format config is only checked for presence
+	create := &core.MotanRequest{ServiceName: "requiredService", Method: "create"}
+	create.SetAttachment("tenant", "t1")
+	create.SetAttachment("traceId", "00af")
+	res = f.Filter(caller, create)
+	assert.Contains(t, res.GetException().ErrMsg, "missing: bad")
+	create.SetAttachment("bad", "1")
+	assert.Nil(t, f.Filter(caller, create).GetException())
+	create.SetAttachment("traceId", "xyz")
+	res = f.Filter(caller, create)
+	assert.NotNil(t, res.GetException())
+	assert.Contains(t, res.GetException().ErrMsg, "illegal format: traceId")
+}
+
+func TestRequiredAttachmentsFilterConstraints(t *testing.T) {
+	url := &core.URL{Parameters: map[string]string{core.FilterKey: RequiredAttachments + "," + DefaultParams}}
+	_, filters := core.GetURLFilters(url, initFactory())
+	assert.Equal(t, 2, len(filters))
+	assert.Nil(t, core.CheckFilterConstraints(filters))
+}
@@ -0,0 +1,343 @@
+package filter
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+	"github.com/weibocom/motan-go/protocol"
+)
+
+// the url parameters of ResponseCacheFilter
+const (
+	// CacheMethodsKey is the json map of the idempotent method name to its cache config, e.g.
+	// {"getUser":{"ttl":1000},"getFeed":{"ttl":500,"key":"{method}:{attachment:uid}"}}
+	CacheMethodsKey    = "cacheMethods"
+	CacheMaxEntriesKey = "cacheMaxEntries" // max entries of the local LRU cache
+	// CacheRedisKey is the address of the redis sharing the cache across the replicas, the responses are cached locally if it is empty
+	CacheRedisKey        = "cacheRedis"
+	CacheRedisTimeoutKey = "cacheRedisTimeout" // ms
+)
+
+const (
+	defaultCacheMaxEntries   = 10000
+	defaultCacheRedisTimeout = 100 * time.Millisecond
+	defaultCacheKeyTemplate  = "{group}:{service}:{method}:{args}"
+	redisCacheKeyPrefix      = "motan:cache:"
+)
+
+// cacheMethodConfig is the cache config of a method. the key template is made of the text and the placeholders
+// {service}, {group}, {method}, {args}(the hash of the arguments) and {attachment:name}, default defaultCacheKeyTemplate
+type cacheMethodConfig struct {
+	TTL  int64  `json:"ttl"` // ms
+	Key  string `json:"key"`
+	ttl  time.Duration
+	keys []cacheKeyPart
+}
+
+// cacheKeyPart is a text if name is empty, or a placeholder
+type cacheKeyPart struct {
+	name string
+	text string
+}
+
+// ResponseCacheFilter caches the successful responses of the idempotent methods of a provider for the ttl of each
+// method, the requests with the attachment protocol.MCacheBypass 'true' are called and refresh the responses. the
+// responses are cached in a local LRU cache, or a redis if CacheRedisKey is set. the responses are cached by the
+// serialized values in the redis, so the responses of the requests without arguments are not cached in the redis
+type ResponseCacheFilter struct {
+	methods map[string]*cacheMethodConfig
+	local   *lruResponseCache
+	redis   *redisPool
+	next    core.EndPointFilter
+}
+
+func (c *ResponseCacheFilter) NewFilter(url *core.URL) core.Filter {
+	ret := &ResponseCacheFilter{}
+	methods, err := parseCacheMethods(url.GetParam(CacheMethodsKey, ""))
+	if err != nil {
+		vlog.Warningf("[responseCache] %v, the responses of %s are not cached", err, url.Path)
+		return ret
+	}
+	ret.methods = methods
+	if address := url.GetParam(CacheRedisKey, ""); address != "" {
+		ret.redis = getRedisPool(address, url.GetTimeDuration(CacheRedisTimeoutKey, time.Millisecond, defaultCacheRedisTimeout))
+	} else if len(methods) > 0 {
+		ret.local = newLRUResponseCache(int(url.GetPositiveIntValue(CacheMaxEntriesKey, defaultCacheMaxEntries)))
+	}
+	return ret
+}
+
+func parseCacheMethods(value string) (map[string]*cacheMethodConfig, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var configs map[string]*cacheMethodConfig
+	if err := json.Unmarshal([]byte(value), &configs); err != nil {
+		return nil, fmt.Errorf("illegal %s: %s, err: %v", CacheMethodsKey, value, err)
+	}
+	methods := make(map[string]*cacheMethodConfig, len(configs))
+	for method, c := range configs {
+		if method == "" || c == nil || c.TTL <= 0 {
+			return nil, fmt.Errorf("illegal %s: the method name is empty or the ttl is not positive: %s", CacheMethodsKey, value)
+		}
+		c.ttl = time.Duration(c.TTL) * time.Millisecond
+		if c.Key == "" {
+			c.Key = defaultCacheKeyTemplate
+		}
+		keys, err := parseCacheKeyTemplate(c.Key)
+		if err != nil {
+			return nil, err
+		}
+		c.keys = keys
+		methods[core.FirstUpper(method)] = c
+	}
+	return methods, nil
+}
+
+func parseCacheKeyTemplate(template string) ([]cacheKeyPart, error) {
+	var parts []cacheKeyPart
+	for s := template; s != ""; {
+		start := strings.Index(s, "{")
+		if start < 0 {
+			parts = append(parts, cacheKeyPart{text: s})
+			break
+		}
+		if start > 0 {
+			parts = append(parts, cacheKeyPart{text: s[:start]})
+		}
+		end := strings.Index(s[start:], "}")
+		if end < 0 {
+			return nil, errors.New("illegal cache key template, the placeholder is not closed: " + template)
+		}
+		name := s[start+1 : start+end]
+		switch {
+		case name == "service", name == "group", name == "method", name == "args":
+		case strings.HasPrefix(name, "attachment:") && len(name) > len("attachment:"):
+		default:
+			return nil, errors.New("illegal cache key template, unknown placeholder {" + name + "}: " + template)
+		}
+		parts = append(parts, cacheKeyPart{name: name})
+		s = s[start+end+1:]
+	}
+	return parts, nil
+}
+
+func (c *cacheMethodConfig) key(request core.Request) string {
+	var b strings.Builder
+	for _, part := range c.keys {
+		switch part.name {
+		case "":
+			b.WriteString(part.text)
+		case "service":
+			b.WriteString(request.GetServiceName())
+		case "group":
+			b.WriteString(request.GetAttachment(protocol.MGroup))
+		case "method":
+			b.WriteString(request.GetMethod())
+		case "args":
+			b.WriteString(hashArguments(request.GetArguments()))
+		default:
+			b.WriteString(request.GetAttachment(part.name[len("attachment:"):]))
+		}
+	}
+	return b.String()
+}
+
+// hashArguments hashes the serialized arguments of the provider requests, or the formatted arguments
+func hashArguments(arguments []interface{}) string {
+	h := sha1.New()
+	for _, argument := range arguments {
+		switch v := argument.(type) {
+		case *core.DeserializableValue:
+			h.Write(v.Body)
+		case []byte:
+			h.Write(v)
+		default:
+			fmt.Fprintf(h, "%v", v)
+		}
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *ResponseCacheFilter) Filter(caller core.Caller, request core.Request) core.Response {
+	config := c.methods[core.FirstUpper(request.GetMethod())]
+	if config == nil || core.GetStream(request) != nil {
+		return c.GetNext().Filter(caller, request)
+	}
+	// the arguments are deserialized by the provider, so the key is made before the call
+	key := config.key(request)
+	bypass := request.GetAttachment(protocol.MCacheBypass) == "true"
+	var serialization core.Serialization
+	if c.redis != nil {
+		if serialization = requestSerialization(request); serialization == nil {
+			return c.GetNext().Filter(caller, request)
+		}
+		key = redisCacheKeyPrefix + key + ":" + strconv.Itoa(serialization.GetSerialNum())
+	}
+	if !bypass {
+		if res := c.get(request, key); res != nil {
+			return res
+		}
+	}
+	res := c.GetNext().Filter(caller, request)
+	if res != nil && res.GetException() == nil {
+		c.set(key, res, config.ttl, serialization)
+	}
+	return res
+}
+
+func requestSerialization(request core.Request) core.Serialization {
+	for _, argument := range request.GetArguments() {
+		if v, ok := argument.(*core.DeserializableValue); ok && v.Serialization != nil {
+			return v.Serialization
+		}
+	}
+	return nil
+}
+
+func (c *ResponseCacheFilter) get(request core.Request, key string) core.Response {
+	if c.local != nil {
+		return c.local.get(request, key)
+	}
+	reply, err := c.redis.do("GET", key)
+	if err != nil {
+		vlog.Warningf("[responseCache] get the cached response from redis fail. req:%s, err:%v", core.GetReqInfo(request), err)
+		return nil
+	}
+	b, ok := reply.([]byte)
+	if !ok || len(b) == 0 {
+		return nil
+	}
+	// the first byte is the serialization number, see set
+	res := &core.MotanResponse{RequestID: request.GetRequestID(), Value: b[1:]}
+	rc := res.GetRPCContext(true)
+	rc.Serialized = true
+	rc.SerializeNum = int(b[0])
+	return res
+}
+
+func (c *ResponseCacheFilter) set(key string, res core.Response, ttl time.Duration, serialization core.Serialization) {
+	if c.local != nil {
+		c.local.set(key, res, ttl)
+		return
+	}
+	var body []byte
+	if res.GetValue() != nil {
+		var err error
+		if body, err = serialization.Serialize(res.GetValue()); err != nil {
+			vlog.Warningf("[responseCache] serialize the response of %s fail. err:%v", key, err)
+			return
+		}
+	}
+	value := append([]byte{byte(serialization.GetSerialNum())}, body...)
+	if _, err := c.redis.do("SET", key, string(value), "PX", strconv.FormatInt(int64(ttl/time.Millisecond), 10)); err != nil {
+		vlog.Warningf("[responseCache] cache the response of %s to redis fail. err:%v", key, err)
+	}
+}
+
+func (c *ResponseCacheFilter) SetNext(nextFilter core.EndPointFilter) {
+	c.next = nextFilter
+}
+
+func (c *ResponseCacheFilter) GetNext() core.EndPointFilter {
+	return c.next
+}
+
+func (c *ResponseCacheFilter) GetName() string {
+	return ResponseCache
+}
+
+func (c *ResponseCacheFilter) HasNext() bool {
+	return c.next != nil
+}
+
+// GetIndex runs after AuthFilter and RequiredAttachmentsFilter, so the cached responses are only returned to the
+// accepted requests
+func (c *ResponseCacheFilter) GetIndex() int {
+	return 12
+}
+
+func (c *ResponseCacheFilter) GetType() int32 {
+	return core.EndPointFilterType
+}
+
+func (c *ResponseCacheFilter) ConflictFilters() []string {
+	return nil
+}
+
+func (c *ResponseCacheFilter) AfterFilters() []string {
+	return []string{Auth}
+}
+
+type responseCacheEntry struct {
+	key        string
+	value      interface{}
+	attachment *core.StringMap
+	expireTime time.Time
+}
+
+// lruResponseCache evicts the least recently used responses when the max entries is reached
+type lruResponseCache struct {
+	lock       sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element
+	lru        *list.List
+}
+
+func newLRUResponseCache(maxEntries int) *lruResponseCache {
+	return &lruResponseCache{maxEntries: maxEntries, entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+func (l *lruResponseCache) get(request core.Request, key string) core.Response {
+	l.lock.Lock()
+	element, ok := l.entries[key]
+	if !ok {
+		l.lock.Unlock()
+		return nil
+	}
+	entry := element.Value.(*responseCacheEntry)
+	if time.Now().After(entry.expireTime) {
+		l.lru.Remove(element)
+		delete(l.entries, key)
+		l.lock.Unlock()
+		return nil
+	}
+	l.lru.MoveToFront(element)
+	l.lock.Unlock()
+	res := &core.MotanResponse{RequestID: request.GetRequestID(), Value: entry.value}
+	if entry.attachment != nil {
+		res.Attachment = entry.attachment.Copy()
+	}
+	return res
+}
+
+func (l *lruResponseCache) set(key string, res core.Response, ttl time.Duration) {
+	entry := &responseCacheEntry{key: key, value: res.GetValue(), expireTime: time.Now().Add(ttl)}
+	if attachment := res.GetAttachments(); attachment != nil {
+		entry.attachment = attachment.Copy()
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if element, ok := l.entries[key]; ok {
+		element.Value = entry
+		l.lru.MoveToFront(element)
+		return
+	}
+	l.entries[key] = l.lru.PushFront(entry)
+	for l.lru.Len() > l.maxEntries {
+		oldest := l.lru.Back()
+		l.lru.Remove(oldest)
+		delete(l.entries, oldest.Value.(*responseCacheEntry).key)
+	}
+}
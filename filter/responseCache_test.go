@@ -0,0 +1,230 @@
+package filter
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/protocol"
+	"github.com/weibocom/motan-go/serialize"
+)
+
+type countingCaller struct {
+	defaultParamsCaller
+	count int
+}
+
+func (c *countingCaller) Call(request core.Request) core.Response {
+	c.count++
+	res := &core.MotanResponse{RequestID: request.GetRequestID(), Value: "value" + strconv.Itoa(c.count)}
+	if request.GetMethod() == "fail" {
+		res.Exception = &core.Exception{ErrCode: 500, ErrMsg: "fail", ErrType: core.ServiceException}
+	}
+	res.SetAttachment("count", strconv.Itoa(c.count))
+	return res
+}
+
+func newResponseCacheFilter(factory core.ExtensionFactory, params map[string]string) core.EndPointFilter {
+	url := &core.URL{Host: "127.0.0.1", Port: 7888, Path: "cacheService", Parameters: params}
+	f := factory.GetFilter(ResponseCache).NewFilter(url).(core.EndPointFilter)
+	f.SetNext(core.GetLastEndPointFilter())
+	return f
+}
+
+func newCacheRequest(method string, args ...interface{}) *core.MotanRequest {
+	serialization := &serialize.SimpleSerialization{}
+	body, _ := serialization.SerializeMulti(args)
+	request := &core.MotanRequest{RequestID: 1, ServiceName: "cacheService", Method: method,
+		Arguments: []interface{}{&core.DeserializableValue{Serialization: serialization, Body: body}}}
+	request.SetAttachment(protocol.MGroup, "test")
+	return request
+}
+
+func TestCacheKeyTemplate(t *testing.T) {
+	for _, value := range []string{"[]", `{"hello":{}}`, `{"":{"ttl":1}}`, `{"hello":{"ttl":1,"key":"{unknown}"}}`, `{"hello":{"ttl":1,"key":"{method"}}`} {
+		_, err := parseCacheMethods(value)
+		assert.NotNil(t, err, value)
+	}
+	methods, err := parseCacheMethods(`{"hello":{"ttl":100,"key":"user-{attachment:uid}:{method}"},"world":{"ttl":100}}`)
+	assert.Nil(t, err)
+	request := newCacheRequest("hello", "a")
+	request.SetAttachment("uid", "12")
+	assert.Equal(t, "user-12:hello", methods["Hello"].key(request))
+	assert.Equal(t, "test:cacheService:hello:"+hashArguments(request.GetArguments()), methods["World"].key(request))
+	assert.NotEqual(t, hashArguments(newCacheRequest("hello", "a").GetArguments()), hashArguments(newCacheRequest("hello", "b").GetArguments()))
+}
+
+func TestResponseCacheFilter(t *testing.T) {
+	factory := &core.DefaultExtensionFactory{}
+	factory.Initialize()
+	RegistDefaultFilters(factory)
+	caller := &countingCaller{}
+	f := newResponseCacheFilter(factory, map[string]string{CacheMethodsKey: `{"hello":{"ttl":50},"fail":{"ttl":1000}}`, CacheMaxEntriesKey: "2"})
+
+	res := f.Filter(caller, newCacheRequest("hello", "a"))
+	assert.Equal(t, "value1", res.GetValue())
+	res = f.Filter(caller, newCacheRequest("hello", "a"))
+	assert.Equal(t, "value1", res.GetValue())
+	assert.Equal(t, "1", res.GetAttachment("count"))
+	assert.Equal(t, 1, caller.count)
+	// the arguments are part of the key
+	assert.Equal(t, "value2", f.Filter(caller, newCacheRequest("hello", "b")).GetValue())
+	// the methods not configured and the failed responses are not cached
+	f.Filter(caller, newCacheRequest("other", "a"))
+	f.Filter(caller, newCacheRequest("other", "a"))
+	f.Filter(caller, newCacheRequest("fail", "a"))
+	f.Filter(caller, newCacheRequest("fail", "a"))
+	assert.Equal(t, 6, caller.count)
+
+	// the bypassed request refreshes the cached response
+	request := newCacheRequest("hello", "a")
+	request.SetAttachment(protocol.MCacheBypass, "true")
+	assert.Equal(t, "value7", f.Filter(caller, request).GetValue())
+	assert.Equal(t, "value7", f.Filter(caller, newCacheRequest("hello", "a")).GetValue())
+
+	// the least recently used response is evicted
+	f.Filter(caller, newCacheRequest("hello", "c"))
+	assert.Equal(t, "value7", f.Filter(caller, newCacheRequest("hello", "a")).GetValue())
+	assert.Equal(t, "value9", f.Filter(caller, newCacheRequest("hello", "b")).GetValue())
+
+	// expired
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, "value10", f.Filter(caller, newCacheRequest("hello", "a")).GetValue())
+}
+
+func TestResponseCacheFilter_Redis(t *testing.T) {
+	factory := &core.DefaultExtensionFactory{}
+	factory.Initialize()
+	RegistDefaultFilters(factory)
+	redis := newFakeCacheRedis(t)
+	defer redis.listener.Close()
+	caller := &countingCaller{}
+	params := map[string]string{CacheMethodsKey: `{"hello":{"ttl":1000}}`, CacheRedisKey: redis.listener.Addr().String()}
+	f1 := newResponseCacheFilter(factory, params)
+	f2 := newResponseCacheFilter(factory, params)
+
+	assert.Equal(t, "value1", f1.Filter(caller, newCacheRequest("hello", "a")).GetValue())
+	// the replicas share the cache, the serialized value is responded
+	res := f2.Filter(caller, newCacheRequest("hello", "a"))
+	assert.Equal(t, 1, caller.count)
+	assert.True(t, res.GetRPCContext(true).Serialized)
+	assert.Equal(t, protocol.Simple, res.GetRPCContext(true).SerializeNum)
+	v, err := (&serialize.SimpleSerialization{}).DeSerialize(res.GetValue().([]byte), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", v)
+	redis.lock.Lock()
+	assert.Equal(t, 1, len(redis.values))
+	for key := range redis.values {
+		assert.True(t, strings.HasPrefix(key, redisCacheKeyPrefix+"test:cacheService:hello:"))
+	}
+	redis.lock.Unlock()
+
+	// the provider is called if the redis is unavailable
+	redis.listener.Close()
+	f := newResponseCacheFilter(factory, map[string]string{CacheMethodsKey: `{"hello":{"ttl":1000}}`, CacheRedisKey: redis.listener.Addr().String(), CacheRedisTimeoutKey: "50"})
+	assert.Equal(t, "value2", f.Filter(caller, newCacheRequest("hello", "a")).GetValue())
+}
+
+func TestResponseCacheFilter_Auth(t *testing.T) {
+	defer SetAuthConfig(&AuthConfig{})
+	SetAuthConfig(&AuthConfig{Secrets: map[string]string{"app1": "key1", "app2": "key2"}})
+	url := &core.URL{Host: "127.0.0.1", Port: 7888, Path: "authService", Parameters: map[string]string{
+		core.FilterKey:  ResponseCache + "," + Auth,
+		CacheMethodsKey: `{"hello":{"ttl":1000}}`,
+		AuthDenyKey:     "app2",
+	}}
+	_, filters := core.GetURLFilters(url, initFactory())
+	assert.Nil(t, core.CheckFilterConstraints(filters))
+	// linked as the server does, the filter of the smallest index is the outermost
+	var f core.EndPointFilter = core.GetLastEndPointFilter()
+	for _, filter := range filters {
+		ef := filter.NewFilter(url).(core.EndPointFilter)
+		ef.SetNext(f)
+		f = ef
+	}
+	assert.Equal(t, Auth, f.GetName())
+	count := 0
+	provider := &Provider{url: &core.URL{Path: "authService"}, handler: func(request core.Request) core.Response {
+		count++
+		return &core.MotanResponse{RequestID: request.GetRequestID(), Value: "value"}
+	}}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	request := newCacheRequest("hello", "a")
+	request.ServiceName = "authService"
+	signAuthRequest(request, "key1", "app1", ts)
+	assert.Equal(t, "value", f.Filter(provider, request).GetValue())
+	assert.Equal(t, 1, count)
+
+	// the cached response is not returned to the unauthenticated and the forbidden callers
+	request = newCacheRequest("hello", "a")
+	request.ServiceName = "authService"
+	assert.Equal(t, AuthUnauthenticatedCode, authErrCode(f.Filter(provider, request)))
+	signAuthRequest(request, "key2", "app2", ts)
+	assert.Equal(t, AuthForbiddenCode, authErrCode(f.Filter(provider, request)))
+	assert.Equal(t, 1, count)
+}
+
+// fakeCacheRedis serves the GET and SET commands, the expiration is ignored
+type fakeCacheRedis struct {
+	listener net.Listener
+	lock     sync.Mutex
+	values   map[string]string
+}
+
+func newFakeCacheRedis(t *testing.T) *fakeCacheRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	r := &fakeCacheRedis{listener: listener, values: make(map[string]string)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+	return r
+}
+
+func (r *fakeCacheRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		args := make([]string, n)
+		for i := range args {
+			size, _ := reader.ReadString('\n')
+			l, _ := strconv.Atoi(strings.TrimSpace(size[1:]))
+			arg := make([]byte, l+2)
+			io.ReadFull(reader, arg)
+			args[i] = string(arg[:l])
+		}
+		r.lock.Lock()
+		switch args[0] {
+		case "GET":
+			if v, ok := r.values[args[1]]; ok {
+				conn.Write([]byte("$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n"))
+			} else {
+				conn.Write([]byte("$-1\r\n"))
+			}
+		case "SET":
+			r.values[args[1]] = args[2]
+			conn.Write([]byte("+OK\r\n"))
+		default:
+			conn.Write([]byte("-ERR unknown command\r\n"))
+		}
+		r.lock.Unlock()
+	}
+}
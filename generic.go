@@ -0,0 +1,113 @@
+package motan
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/weibocom/motan-go/cluster"
+	motan "github.com/weibocom/motan-go/core"
+	vlog "github.com/weibocom/motan-go/log"
+)
+
+var errGenericServiceDestroyed = errors.New("generic service is destroyed")
+
+// GenericService calls any motan service by the service name, the method name and the arguments without the stubs
+// of the service, it is used by the gateways and the testing tools forwarding the calls. the url of a service is
+// copied from the template url, so the registry, the group and the other parameters of the services are the same.
+// the cluster of a service is created when the service is called first
+type GenericService struct {
+	context    *motan.Context
+	extFactory motan.ExtensionFactory
+	url        *motan.URL
+	lock       sync.Mutex
+	clients    map[string]*Client
+	destroyed  bool
+}
+
+// NewGenericService creates a GenericService with the template url, the path of the url is not used
+func NewGenericService(context *motan.Context, extFactory motan.ExtensionFactory, url *motan.URL) *GenericService {
+	return &GenericService{
+		context:    context,
+		extFactory: extFactory,
+		url:        url,
+		clients:    make(map[string]*Client, 16),
+	}
+}
+
+// GetGenericService returns a GenericService using the refer of the clientID as the template url, nil if the refer
+// is not found. it should be called after the MCContext is started
+func (m *MCContext) GetGenericService(clientID string) *GenericService {
+	m.csync.Lock()
+	defer m.csync.Unlock()
+	url := m.context.RefersURLs[clientID]
+	if url == nil || m.extFactory == nil {
+		return nil
+	}
+	return NewGenericService(m.context, m.extFactory, url)
+}
+
+// GetClient returns the client of the service, the arguments of the requests are serialized by the serialization,
+// the serialization of the template url is used if it is empty
+func (g *GenericService) GetClient(service string, serialization string) (*Client, error) {
+	if service == "" {
+		return nil, errors.New("service is empty")
+	}
+	if serialization == "" {
+		serialization = g.url.GetParam(motan.SerializationKey, "")
+	}
+	if serialization != "" && g.extFactory.GetSerialization(serialization, -1) == nil {
+		return nil, errors.New("unsupported serialization: " + serialization)
+	}
+	key := service + "|" + serialization
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.destroyed {
+		return nil, errGenericServiceDestroyed
+	}
+	if client, ok := g.clients[key]; ok {
+		return client, nil
+	}
+	url := g.url.Copy()
+	url.Path = service
+	if serialization != "" {
+		url.PutParam(motan.SerializationKey, serialization)
+	}
+	vlog.Infof("[genericService] create cluster of %s", url.GetIdentity())
+	client := NewClient(url, cluster.NewCluster(g.context, g.extFactory, url, false), g.extFactory)
+	g.clients[key] = client
+	return client, nil
+}
+
+// Call calls the method of the service synchronously, the response is deserialized to the reply
+func (g *GenericService) Call(service string, method string, serialization string, args []interface{}, reply interface{}) error {
+	client, err := g.GetClient(service, serialization)
+	if err != nil {
+		return err
+	}
+	return client.Call(method, args, reply)
+}
+
+// Go calls the method of the service asynchronously, the result is sent to done when the call is finished
+func (g *GenericService) Go(service string, method string, serialization string, args []interface{}, reply interface{}, done chan *motan.AsyncResult) *motan.AsyncResult {
+	client, err := g.GetClient(service, serialization)
+	if err != nil {
+		if done == nil || cap(done) == 0 {
+			done = make(chan *motan.AsyncResult, 1)
+		}
+		result := &motan.AsyncResult{Reply: reply, Error: err, Done: done}
+		result.Done <- result
+		return result
+	}
+	return client.Go(method, args, reply, done)
+}
+
+// Destroy destroys the clusters of the services, the GenericService can not be used after that
+func (g *GenericService) Destroy() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.destroyed = true
+	for key, client := range g.clients {
+		delete(g.clients, key)
+		client.cluster.Destroy()
+	}
+}
@@ -96,7 +96,7 @@ func (br *BackupRequestHA) Call(request motan.Request, loadBalance motan.LoadBal
 		}
 		lastErrorCh = make(chan motan.Response, 1)
 		go func(postRequest motan.Request, endpoint motan.EndPoint, errorCh chan motan.Response) {
-			defer motan.HandlePanic(nil)
+			defer motan.HandleRequestPanic(postRequest, nil)
 			response := br.doCall(postRequest, endpoint)
 			if response != nil && (response.GetException() == nil || response.GetException().ErrType == motan.BizException) {
 				successCh <- response
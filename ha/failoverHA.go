@@ -2,6 +2,7 @@ package ha
 
 import (
 	"fmt"
+	"time"
 
 	motan "github.com/weibocom/motan-go/core"
 	"github.com/weibocom/motan-go/log"
@@ -27,7 +28,13 @@ func (f *FailOverHA) SetURL(url *motan.URL) {
 func (f *FailOverHA) Call(request motan.Request, loadBalance motan.LoadBalance) motan.Response {
 	retries := f.url.GetMethodPositiveIntValue(request.GetMethod(), request.GetMethodDesc(), motan.RetriesKey, defaultRetries)
 	var lastErr *motan.Exception
+	calls := 0
+	globalRetryBudget.deposit()
 	for i := 0; i <= int(retries); i++ {
+		if i > 0 && !globalRetryBudget.tryWithdraw() {
+			vlog.Warningf("FailOverHA retry budget is used up, request id: %d", request.GetRequestID())
+			break
+		}
 		ep := loadBalance.Select(request)
 		if ep == nil {
 			return getErrorResponseWithCode(request.GetRequestID(), motan.ENoEndpoints,
@@ -40,8 +47,24 @@ func (f *FailOverHA) Call(request motan.Request, loadBalance motan.LoadBalance)
 		}
 		lastErr = response.GetException()
 		vlog.Warningf("FailOverHA call fail! url:%s, err:%+v", ep.GetURL().GetIdentity(), lastErr)
+		// honor the retry policy advertised by the server
+		var backoff time.Duration
+		if budget, b, ok := motan.GetAdvertisedRetryPolicy(ep.GetURL(), response); ok {
+			if budget < retries {
+				retries = budget
+			}
+			backoff = b
+		}
+		// an overloaded server advertises the delay before retrying
+		if retryAfter := motan.GetRetryAfter(response); retryAfter > backoff {
+			backoff = retryAfter
+		}
+		if backoff > 0 && i < int(retries) {
+			time.Sleep(backoff)
+		}
+		calls = i + 1
 	}
-	errorResponse := getErrorResponse(request.GetRequestID(), fmt.Sprintf("FailOverHA call fail %d times. Exception: %s", retries+1, lastErr.ErrMsg))
+	errorResponse := getErrorResponse(request.GetRequestID(), fmt.Sprintf("FailOverHA call fail %d times. Exception: %s", calls, lastErr.ErrMsg))
 	errorResponse.Exception.ErrCode = lastErr.ErrCode
 	return errorResponse
 }
@@ -2,6 +2,7 @@ package ha
 
 import (
 	"testing"
+	"time"
 
 	motan "github.com/weibocom/motan-go/core"
 )
@@ -22,3 +23,77 @@ func TestGetName(t *testing.T) {
 		t.Errorf("ha call fail. res:%+v", res)
 	}
 }
+
+type retryBudgetEndPoint struct {
+	motan.TestEndPoint
+	calls      int
+	budget     string
+	retryAfter string
+}
+
+func (r *retryBudgetEndPoint) Call(request motan.Request) motan.Response {
+	r.calls++
+	res := motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 503, ErrMsg: "busy", ErrType: motan.ServiceException})
+	if r.budget != "" {
+		res.SetAttachment(motan.RetryBudgetAttachment, r.budget)
+	}
+	if r.retryAfter != "" {
+		res.SetAttachment(motan.RetryAfterAttachment, r.retryAfter)
+	}
+	return res
+}
+
+type singleLoadBalance struct {
+	motan.TestLoadBalance
+	ep motan.EndPoint
+}
+
+func (s *singleLoadBalance) Select(request motan.Request) motan.EndPoint {
+	return s.ep
+}
+
+func TestFailOverHARetryBudget(t *testing.T) {
+	url := &motan.URL{Protocol: "motan", Path: "test/path", Parameters: map[string]string{motan.RetriesKey: "3"}}
+	ha := &FailOverHA{url: url}
+	request := &motan.MotanRequest{ServiceName: "test", Method: "test"}
+
+	ep := &retryBudgetEndPoint{}
+	ep.URL = &motan.URL{Parameters: map[string]string{}}
+	ha.Call(request, &singleLoadBalance{ep: ep})
+	if ep.calls != 4 {
+		t.Errorf("retries without budget, calls: %d", ep.calls)
+	}
+
+	// budget in response attachment
+	ep = &retryBudgetEndPoint{budget: "1"}
+	ep.URL = &motan.URL{Parameters: map[string]string{}}
+	ha.Call(request, &singleLoadBalance{ep: ep})
+	if ep.calls != 2 {
+		t.Errorf("retries with response budget, calls: %d", ep.calls)
+	}
+
+	// budget in service url
+	ep = &retryBudgetEndPoint{}
+	ep.URL = &motan.URL{Parameters: map[string]string{motan.RetryBudgetKey: "0"}}
+	ha.Call(request, &singleLoadBalance{ep: ep})
+	if ep.calls != 1 {
+		t.Errorf("retries with url budget, calls: %d", ep.calls)
+	}
+}
+
+func TestFailOverHARetryAfter(t *testing.T) {
+	url := &motan.URL{Protocol: "motan", Path: "test/path", Parameters: map[string]string{motan.RetriesKey: "2"}}
+	ha := &FailOverHA{url: url}
+	request := &motan.MotanRequest{ServiceName: "test", Method: "test"}
+
+	ep := &retryBudgetEndPoint{retryAfter: "50"}
+	ep.URL = &motan.URL{Parameters: map[string]string{}}
+	start := time.Now()
+	ha.Call(request, &singleLoadBalance{ep: ep})
+	if ep.calls != 3 {
+		t.Errorf("retries with retry after, calls: %d", ep.calls)
+	}
+	if cost := time.Since(start); cost < 100*time.Millisecond {
+		t.Errorf("retries should back off with retry after, cost: %v", cost)
+	}
+}
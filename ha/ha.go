@@ -9,12 +9,16 @@ import (
 const (
 	FailOver      = "failover"
 	BackupRequest = "backupRequest"
+	Hedged        = "hedged"
 )
 
 func RegistDefaultHa(extFactory motan.ExtensionFactory) {
 	extFactory.RegistExtHa(FailOver, func(url *motan.URL) motan.HaStrategy {
 		return &FailOverHA{url: url}
 	})
+	extFactory.RegistExtHa(Hedged, func(url *motan.URL) motan.HaStrategy {
+		return &HedgedHA{url: url}
+	})
 	extFactory.RegistExtHa(BackupRequest, func(url *motan.URL) motan.HaStrategy {
 		if filters, ok := url.Parameters[motan.FilterKey]; ok {
 			hasMetrics := false
@@ -0,0 +1,195 @@
+package ha
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// hedged request options of the referer url parameters, they can be configured by method. the number of the hedged
+// requests is motan.RetriesKey, default 1
+const (
+	HedgedDelayKey           = "hedgedDelay"           // the fixed delay(ms) before sending the hedged request, it takes precedence
+	HedgedDelayPercentileKey = "hedgedDelayPercentile" // the delay is the percentile of the latency of the recent calls, default 95
+)
+
+const (
+	defaultHedgedRetries         = 1
+	defaultHedgedDelayPercentile = 95
+	hedgedLatencySamples         = 128
+	hedgedMinLatencySamples      = 10
+	hedgedMinDelay               = time.Millisecond
+)
+
+// HedgedHA sends the request to another endpoint if the calls sent have not responded within the delay, the first
+// success response is returned and the other calls are canceled. before enough latency samples are collected the
+// request is not hedged unless the delay is fixed. all hedged requests are limited by the global retry budget
+type HedgedHA struct {
+	url       *motan.URL
+	lock      sync.Mutex
+	latencies map[string]*latencyRecorder
+}
+
+func (h *HedgedHA) GetName() string {
+	return Hedged
+}
+
+func (h *HedgedHA) GetURL() *motan.URL {
+	return h.url
+}
+
+func (h *HedgedHA) SetURL(url *motan.URL) {
+	h.url = url
+}
+
+func (h *HedgedHA) Call(request motan.Request, loadBalance motan.LoadBalance) motan.Response {
+	ep := loadBalance.Select(request)
+	if ep == nil {
+		return getErrorResponseWithCode(request.GetRequestID(), motan.ENoEndpoints, fmt.Sprintf("call hedged request fail: %s", "no endpoints"))
+	}
+	globalRetryBudget.deposit()
+	recorder := h.getLatencyRecorder(request.GetMethod() + request.GetMethodDesc())
+	retries := h.url.GetMethodIntValue(request.GetMethod(), request.GetMethodDesc(), motan.RetriesKey, defaultHedgedRetries)
+	delay := h.getDelay(request, recorder)
+	if retries <= 0 || delay <= 0 {
+		return h.doCall(request, ep, recorder)
+	}
+
+	ctx, cancel := context.WithCancel(motan.RequestContext(request))
+	defer cancel()
+	results := make(chan motan.Response, retries+1)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	var lastResponse motan.Response
+	inflight := 0
+	send := func(endpoint motan.EndPoint) {
+		pr := request.Clone().(motan.Request)
+		pr.GetRPCContext(true).Context = ctx
+		inflight++
+		go func() {
+			defer motan.HandleRequestPanic(pr, nil)
+			results <- h.doCall(pr, endpoint, recorder)
+		}()
+	}
+	send(ep)
+	for sent := 1; inflight > 0; {
+		select {
+		case response := <-results:
+			inflight--
+			if isSuccess(response) {
+				return response
+			}
+			lastResponse = response
+			if inflight > 0 {
+				continue
+			}
+		case <-timer.C:
+		}
+		// hedge the request when the last delay elapsed or all the calls sent failed
+		if sent > int(retries) {
+			continue
+		}
+		if ep = loadBalance.Select(request); ep == nil {
+			continue
+		}
+		if !globalRetryBudget.tryWithdraw() {
+			vlog.Warningf("[hedged ha] retry budget is used up, request id: %d", request.GetRequestID())
+			sent = int(retries) + 1
+			continue
+		}
+		vlog.Infof("[hedged ha] delay %v request id: %d, service: %s, method: %s", delay, request.GetRequestID(), request.GetServiceName(), request.GetMethod())
+		send(ep)
+		sent++
+		timer.Reset(delay)
+	}
+	if lastResponse == nil {
+		return getErrorResponse(request.GetRequestID(), fmt.Sprintf("call hedged request fail: %s", "no response"))
+	}
+	return lastResponse
+}
+
+func (h *HedgedHA) doCall(request motan.Request, endpoint motan.EndPoint, recorder *latencyRecorder) motan.Response {
+	start := time.Now()
+	response := endpoint.Call(request)
+	if response == nil {
+		return getErrorResponse(request.GetRequestID(), "call hedged request fail: nil response")
+	}
+	if isSuccess(response) {
+		recorder.record(time.Since(start))
+		return response
+	}
+	vlog.Warningf("HedgedHA call fail! url:%s, err:%+v", endpoint.GetURL().GetIdentity(), response.GetException())
+	return response
+}
+
+func (h *HedgedHA) getDelay(request motan.Request, recorder *latencyRecorder) time.Duration {
+	if delay := h.url.GetMethodIntValue(request.GetMethod(), request.GetMethodDesc(), HedgedDelayKey, 0); delay > 0 {
+		return time.Duration(delay) * time.Millisecond
+	}
+	percentile := h.url.GetMethodPositiveIntValue(request.GetMethod(), request.GetMethodDesc(), HedgedDelayPercentileKey, defaultHedgedDelayPercentile)
+	delay := recorder.percentile(percentile)
+	if delay > 0 && delay < hedgedMinDelay {
+		delay = hedgedMinDelay
+	}
+	return delay
+}
+
+func (h *HedgedHA) getLatencyRecorder(method string) *latencyRecorder {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.latencies == nil {
+		h.latencies = make(map[string]*latencyRecorder)
+	}
+	r, ok := h.latencies[method]
+	if !ok {
+		r = &latencyRecorder{samples: make([]time.Duration, 0, hedgedLatencySamples)}
+		h.latencies[method] = r
+	}
+	return r
+}
+
+func isSuccess(response motan.Response) bool {
+	return response != nil && (response.GetException() == nil || response.GetException().ErrType == motan.BizException)
+}
+
+// latencyRecorder keeps the latency of the recent success calls
+type latencyRecorder struct {
+	lock    sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+func (l *latencyRecorder) record(latency time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if len(l.samples) < cap(l.samples) {
+		l.samples = append(l.samples, latency)
+		return
+	}
+	l.samples[l.next] = latency
+	l.next = (l.next + 1) % len(l.samples)
+}
+
+// percentile returns 0 if the samples are not enough
+func (l *latencyRecorder) percentile(percentile int64) time.Duration {
+	l.lock.Lock()
+	if len(l.samples) < hedgedMinLatencySamples {
+		l.lock.Unlock()
+		return 0
+	}
+	samples := append([]time.Duration(nil), l.samples...)
+	l.lock.Unlock()
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i] < samples[j]
+	})
+	index := int(percentile) * len(samples) / 100
+	if index >= len(samples) {
+		index = len(samples) - 1
+	}
+	return samples[index]
+}
@@ -0,0 +1,152 @@
+package ha
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+)
+
+type hedgedTestEndPoint struct {
+	motan.TestEndPoint
+	delay    time.Duration
+	fail     bool
+	calls    int32
+	canceled int32
+}
+
+func (h *hedgedTestEndPoint) Call(request motan.Request) motan.Response {
+	atomic.AddInt32(&h.calls, 1)
+	select {
+	case <-time.After(h.delay):
+	case <-motan.RequestContext(request).Done():
+		atomic.AddInt32(&h.canceled, 1)
+		return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 503, ErrMsg: "canceled", ErrType: motan.ServiceException})
+	}
+	if h.fail {
+		return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 503, ErrMsg: "fail", ErrType: motan.ServiceException})
+	}
+	return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: h.URL.Port}
+}
+
+// sequenceLoadBalance selects the endpoints in turn
+type sequenceLoadBalance struct {
+	motan.TestLoadBalance
+	lock  sync.Mutex
+	eps   []motan.EndPoint
+	index int
+}
+
+func (s *sequenceLoadBalance) Select(request motan.Request) motan.EndPoint {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ep := s.eps[s.index%len(s.eps)]
+	s.index++
+	return ep
+}
+
+func newHedgedTestEndPoint(port int, delay time.Duration) *hedgedTestEndPoint {
+	ep := &hedgedTestEndPoint{delay: delay}
+	ep.URL = &motan.URL{Port: port, Parameters: map[string]string{}}
+	return ep
+}
+
+func TestHedgedHA(t *testing.T) {
+	defer SetRetryBudget(defaultRetryBudgetPercent, defaultRetryBudgetMinPerSecond)
+	url := &motan.URL{Protocol: "motan", Path: "test/path", Parameters: map[string]string{HedgedDelayKey: "20"}}
+	ha := &HedgedHA{url: url}
+	request := &motan.MotanRequest{ServiceName: "test", Method: "test"}
+
+	// the hedged request wins, the slow one is canceled
+	slow := newHedgedTestEndPoint(1, time.Second)
+	fast := newHedgedTestEndPoint(2, 0)
+	start := time.Now()
+	res := ha.Call(request, &sequenceLoadBalance{eps: []motan.EndPoint{slow, fast}})
+	if res.GetException() != nil || res.GetValue() != 2 {
+		t.Errorf("hedged request not win. res: %+v", res)
+	}
+	if cost := time.Since(start); cost < 20*time.Millisecond || cost > 500*time.Millisecond {
+		t.Errorf("hedged request delay not correct, cost: %v", cost)
+	}
+	for i := 0; i < 100 && atomic.LoadInt32(&slow.canceled) == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if atomic.LoadInt32(&slow.canceled) != 1 {
+		t.Errorf("slow request not canceled")
+	}
+
+	// not hedged if the first responds within the delay
+	first := newHedgedTestEndPoint(1, 0)
+	second := newHedgedTestEndPoint(2, 0)
+	res = ha.Call(request, &sequenceLoadBalance{eps: []motan.EndPoint{first, second}})
+	if res.GetValue() != 1 || atomic.LoadInt32(&second.calls) != 0 {
+		t.Errorf("request hedged without delay. res: %+v", res)
+	}
+
+	// hedged at once if the first fails
+	first.fail = true
+	res = ha.Call(request, &sequenceLoadBalance{eps: []motan.EndPoint{first, second}})
+	if res.GetValue() != 2 {
+		t.Errorf("hedged request not sent after failure. res: %+v", res)
+	}
+	second.fail = true
+	res = ha.Call(request, &sequenceLoadBalance{eps: []motan.EndPoint{first, second}})
+	if res.GetException() == nil {
+		t.Errorf("hedged request should fail. res: %+v", res)
+	}
+
+	// no hedged request without budget
+	SetRetryBudget(0, 0)
+	slow = newHedgedTestEndPoint(1, 50*time.Millisecond)
+	fast = newHedgedTestEndPoint(2, 0)
+	res = ha.Call(request, &sequenceLoadBalance{eps: []motan.EndPoint{slow, fast}})
+	if res.GetValue() != 1 || atomic.LoadInt32(&fast.calls) != 0 {
+		t.Errorf("request hedged without budget. res: %+v", res)
+	}
+}
+
+func TestHedgedHAPercentileDelay(t *testing.T) {
+	url := &motan.URL{Protocol: "motan", Path: "test/path", Parameters: map[string]string{HedgedDelayPercentileKey: "90"}}
+	ha := &HedgedHA{url: url}
+	request := &motan.MotanRequest{ServiceName: "test", Method: "test"}
+	recorder := ha.getLatencyRecorder(request.GetMethod())
+	if delay := ha.getDelay(request, recorder); delay != 0 {
+		t.Errorf("delay without enough samples: %v", delay)
+	}
+	for i := 1; i <= 200; i++ {
+		recorder.record(time.Duration(i%100+1) * time.Millisecond)
+	}
+	if len(recorder.samples) != hedgedLatencySamples {
+		t.Errorf("latency samples not correct: %d", len(recorder.samples))
+	}
+	if delay := ha.getDelay(request, recorder); delay < 80*time.Millisecond || delay > 100*time.Millisecond {
+		t.Errorf("percentile delay not correct: %v", delay)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	budget := newRetryBudget(10, 2)
+	for i := 0; i < 2; i++ {
+		if !budget.tryWithdraw() {
+			t.Errorf("retry not allowed by the reserve")
+		}
+	}
+	if budget.tryWithdraw() {
+		t.Errorf("retry allowed over the budget")
+	}
+	for i := 0; i < 10; i++ {
+		budget.deposit()
+	}
+	if !budget.tryWithdraw() {
+		t.Errorf("retry not allowed by the deposits")
+	}
+	if budget.tryWithdraw() {
+		t.Errorf("retry allowed over the budget")
+	}
+	budget.lastRefill = budget.lastRefill.Add(-time.Second)
+	if !budget.tryWithdraw() {
+		t.Errorf("retry not allowed after the reserve refilled")
+	}
+}
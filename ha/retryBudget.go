@@ -0,0 +1,84 @@
+package ha
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultRetryBudgetPercent      = 20 // the retries can be 20 percent of the requests
+	defaultRetryBudgetMinPerSecond = 10 // the retries allowed per second even if there are few requests
+	retryBudgetMaxBalanceRequests  = 1000
+)
+
+// retryBudget limits the retries and the hedged requests of all the ha strategies so they cannot amplify the load
+// when the services are failing. every request deposits percent/100 token and every retry withdraws one token, a
+// reserve of minPerSecond tokens is refilled every second for the low traffic services
+type retryBudget struct {
+	lock         sync.Mutex
+	percent      int64
+	minPerSecond float64
+	balance      int64 // the deposits in percent of a token
+	reserve      float64
+	lastRefill   time.Time
+}
+
+var globalRetryBudget = newRetryBudget(defaultRetryBudgetPercent, defaultRetryBudgetMinPerSecond)
+
+// SetRetryBudget sets the global retry budget of the ha strategies: the retries can be percent of the requests plus
+// minPerSecond per second
+func SetRetryBudget(percent int, minPerSecond int) {
+	globalRetryBudget.set(percent, minPerSecond)
+}
+
+func newRetryBudget(percent int, minPerSecond int) *retryBudget {
+	b := &retryBudget{lastRefill: time.Now()}
+	b.set(percent, minPerSecond)
+	b.reserve = b.minPerSecond
+	return b
+}
+
+func (b *retryBudget) set(percent int, minPerSecond int) {
+	if percent < 0 {
+		percent = 0
+	}
+	if minPerSecond < 0 {
+		minPerSecond = 0
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.percent = int64(percent)
+	b.minPerSecond = float64(minPerSecond)
+	if b.reserve > b.minPerSecond {
+		b.reserve = b.minPerSecond
+	}
+}
+
+// deposit is called by every request before its first call
+func (b *retryBudget) deposit() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.balance += b.percent; b.balance > b.percent*retryBudgetMaxBalanceRequests {
+		b.balance = b.percent * retryBudgetMaxBalanceRequests
+	}
+}
+
+// tryWithdraw returns false if the retry is not allowed by the budget
+func (b *retryBudget) tryWithdraw() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	now := time.Now()
+	if b.reserve += now.Sub(b.lastRefill).Seconds() * b.minPerSecond; b.reserve > b.minPerSecond {
+		b.reserve = b.minPerSecond
+	}
+	b.lastRefill = now
+	if b.reserve >= 1 {
+		b.reserve--
+		return true
+	}
+	if b.balance >= 100 {
+		b.balance -= 100
+		return true
+	}
+	return false
+}
@@ -28,6 +28,16 @@ const (
 	ProxySchemaKey           = "proxySchema"
 	MaxConnectionsKey        = "maxConnections"
 	EnableRewriteKey         = "enableRewrite"
+	// ProxyRetriesKey is the max retries of the idempotent requests failing to send to the upstream or read the
+	// responses, e.g. the connections closed by the upstream, default 0
+	ProxyRetriesKey = "proxyRetries"
+	// ProxyRewriteRulesKey is the rewrite rules of the request paths separated by ';', e.g. 'start /v1/ ^/v1/(.*) /api/$1',
+	// it replaces the http-locations of the domain, so the upstream can be proxied without the locations config
+	ProxyRewriteRulesKey = "proxyRewriteRules"
+	// ProxyRequestHeadersKey maps the request attachments to the http headers, e.g. 'uid:X-Uid,M_p:X-Source'
+	ProxyRequestHeadersKey = "proxyRequestHeaders"
+	// ProxyResponseHeadersKey maps the http response headers to the response attachments, e.g. 'X-Cost:cost'
+	ProxyResponseHeadersKey = "proxyResponseHeaders"
 )
 
 const (
@@ -0,0 +1,141 @@
+package lb
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+)
+
+const (
+	adaptiveDecay          = 0.3 // the weight of the latest latency in the ewma latency
+	adaptiveDefaultPenalty = time.Second
+	adaptiveInitialLatency = int64(time.Millisecond)
+)
+
+// adaptiveStats is the ewma latency(ns) and the in-flight count of the calls to an endpoint
+type adaptiveStats struct {
+	latency  int64
+	inflight int64
+}
+
+func (s *adaptiveStats) observe(latency int64) {
+	for {
+		old := atomic.LoadInt64(&s.latency)
+		ewma := old + int64(adaptiveDecay*float64(latency-old))
+		if atomic.CompareAndSwapInt64(&s.latency, old, ewma) {
+			return
+		}
+	}
+}
+
+// score is the expected latency of a new call, the calls in flight are queued before it
+func (s *adaptiveStats) score() int64 {
+	return (atomic.LoadInt64(&s.latency) + 1) * (atomic.LoadInt64(&s.inflight) + 1)
+}
+
+// adaptiveEndpoint records the latency and in-flight count of the calls to the endpoint
+type adaptiveEndpoint struct {
+	motan.EndPoint
+	stats   *adaptiveStats
+	penalty int64
+}
+
+func (a *adaptiveEndpoint) Call(request motan.Request) motan.Response {
+	atomic.AddInt64(&a.stats.inflight, 1)
+	start := time.Now()
+	response := a.EndPoint.Call(request)
+	latency := int64(time.Since(start))
+	atomic.AddInt64(&a.stats.inflight, -1)
+	// the failed calls are penalized so the failing fast endpoints are not preferred
+	if response == nil || response.GetException() != nil {
+		if latency < a.penalty {
+			latency = a.penalty
+		}
+	}
+	a.stats.observe(latency)
+	return response
+}
+
+// AdaptiveLB prefers the faster endpoints, it selects the one with the lower expected latency(the ewma latency
+// multiplied by the in-flight count) of two available endpoints picked at random. the failed calls are taken as the
+// request timeout of the url, so the failing endpoints get less traffic until they recover
+type AdaptiveLB struct {
+	url       *motan.URL
+	lock      sync.Mutex
+	endpoints []motan.EndPoint
+	stats     map[string]*adaptiveStats
+	weight    string
+}
+
+func (r *AdaptiveLB) OnRefresh(endpoints []motan.EndPoint) {
+	penalty := adaptiveDefaultPenalty
+	if r.url != nil {
+		penalty = r.url.GetTimeDuration(motan.TimeOutKey, time.Millisecond, adaptiveDefaultPenalty)
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	// keep the stats of the endpoints still in service
+	stats := make(map[string]*adaptiveStats, len(endpoints))
+	eps := make([]motan.EndPoint, 0, len(endpoints))
+	for _, ep := range endpoints {
+		id := ep.GetURL().GetIdentity()
+		s, ok := r.stats[id]
+		if !ok {
+			s = &adaptiveStats{latency: adaptiveInitialLatency}
+		}
+		stats[id] = s
+		eps = append(eps, &adaptiveEndpoint{EndPoint: ep, stats: s, penalty: int64(penalty)})
+	}
+	r.stats = stats
+	r.endpoints = eps
+}
+
+func (r *AdaptiveLB) Select(request motan.Request) motan.EndPoint {
+	_, endpoint := r.adaptiveSelect(r.getEndpoints())
+	return endpoint
+}
+
+func (r *AdaptiveLB) SelectArray(request motan.Request) []motan.EndPoint {
+	eps := r.getEndpoints()
+	index, endpoint := r.adaptiveSelect(eps)
+	if endpoint == nil {
+		return nil
+	}
+	return SelectArrayFromIndex(eps, index)
+}
+
+func (r *AdaptiveLB) SetWeight(weight string) {
+	r.weight = weight
+}
+
+func (r *AdaptiveLB) getEndpoints() []motan.EndPoint {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.endpoints
+}
+
+// adaptiveSelect is the power of two choices by the expected latency, the two candidates are different endpoints if
+// more than one is available
+func (r *AdaptiveLB) adaptiveSelect(eps []motan.EndPoint) (int, motan.EndPoint) {
+	best, ep := SelectOneAtRandom(eps)
+	if ep == nil || len(eps) == 1 {
+		return best, ep
+	}
+	// the other candidate is the first available one from a random offset
+	epsLen := len(eps)
+	offset := 1 + rand.Intn(epsLen-1)
+	for idx := 0; idx < epsLen-1; idx++ {
+		index := (best + offset + idx) % epsLen
+		if index == best || !eps[index].IsAvailable() {
+			continue
+		}
+		if eps[index].(*adaptiveEndpoint).stats.score() < ep.(*adaptiveEndpoint).stats.score() {
+			return index, eps[index]
+		}
+		break
+	}
+	return best, ep
+}
@@ -0,0 +1,88 @@
+package lb
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/endpoint"
+)
+
+type adaptiveTestEndpoint struct {
+	*endpoint.MockEndpoint
+	delay time.Duration
+	fail  bool
+}
+
+func (e *adaptiveTestEndpoint) Call(request motan.Request) motan.Response {
+	time.Sleep(e.delay)
+	if e.fail {
+		return motan.BuildExceptionResponse(0, &motan.Exception{ErrCode: 503, ErrMsg: "fail", ErrType: motan.ServiceException})
+	}
+	return e.MockEndpoint.Call(request)
+}
+
+func TestAdaptiveLB(t *testing.T) {
+	slow := &adaptiveTestEndpoint{MockEndpoint: &endpoint.MockEndpoint{URL: &motan.URL{Port: 1000}}, delay: 20 * time.Millisecond}
+	fast := &adaptiveTestEndpoint{MockEndpoint: &endpoint.MockEndpoint{URL: &motan.URL{Port: 1001}}}
+	lb := &AdaptiveLB{url: &motan.URL{Parameters: map[string]string{motan.TimeOutKey: "100"}}}
+	lb.OnRefresh([]motan.EndPoint{slow, fast})
+	for i := 0; i < 20; i++ {
+		lb.Select(nil).Call(nil)
+	}
+	counts := make(map[int]int)
+	for i := 0; i < 100; i++ {
+		counts[lb.Select(nil).GetURL().Port]++
+	}
+	if counts[1001] < 70 {
+		t.Errorf("adaptive lb not prefer the faster endpoint: %v\n", counts)
+	}
+
+	// the stats are kept when refreshed
+	lb.OnRefresh([]motan.EndPoint{slow, fast})
+	if s := lb.stats[fast.GetURL().GetIdentity()]; s.latency >= lb.stats[slow.GetURL().GetIdentity()].latency {
+		t.Errorf("adaptive lb stats not kept: %v\n", lb.stats)
+	}
+
+	// the failing endpoint is penalized
+	fast.fail = true
+	for i := 0; i < 5; i++ {
+		for _, ep := range lb.SelectArray(nil) {
+			ep.Call(nil)
+		}
+	}
+	if s := lb.stats[fast.GetURL().GetIdentity()]; s.latency < int64(50*time.Millisecond) {
+		t.Errorf("adaptive lb failure not penalized: %d\n", s.latency)
+	}
+
+	// the endpoints with calls in flight are avoided
+	fast.fail = false
+	lb.OnRefresh([]motan.EndPoint{&adaptiveTestEndpoint{MockEndpoint: &endpoint.MockEndpoint{URL: &motan.URL{Port: 1002}}}, &adaptiveTestEndpoint{MockEndpoint: &endpoint.MockEndpoint{URL: &motan.URL{Port: 1003}}}})
+	busy := lb.getEndpoints()[0].(*adaptiveEndpoint)
+	busy.stats.inflight = 10
+	counts = make(map[int]int)
+	for i := 0; i < 100; i++ {
+		counts[lb.Select(nil).GetURL().Port]++
+	}
+	if counts[1003] < 70 {
+		t.Errorf("adaptive lb not avoid the busy endpoint: %v\n", counts)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lb.Select(nil).Call(nil)
+		}()
+	}
+	wg.Wait()
+	if lb.stats[lb.getEndpoints()[1].GetURL().GetIdentity()].inflight != 0 {
+		t.Errorf("adaptive lb inflight not correct\n")
+	}
+	lb.OnRefresh(nil)
+	if ep := lb.Select(nil); ep != nil {
+		t.Errorf("adaptive lb select error, no endpoint: %v\n", ep)
+	}
+}
@@ -12,8 +12,11 @@ import (
 
 // ext name
 const (
-	Random     = "random"
-	Roundrobin = "roundrobin"
+	Random             = "random"
+	Roundrobin         = "roundrobin"
+	WeightedRandom     = "weightedRandom"
+	WeightedRoundrobin = "weightedRoundrobin"
+	Adaptive           = "adaptive"
 )
 
 const (
@@ -33,6 +36,18 @@ func RegistDefaultLb(extFactory motan.ExtensionFactory) {
 	extFactory.RegistExtLb(Roundrobin, NewWeightLbFunc(func(url *motan.URL) motan.LoadBalance {
 		return &RoundrobinLB{url: url}
 	}))
+
+	extFactory.RegistExtLb(WeightedRandom, NewWeightLbFunc(func(url *motan.URL) motan.LoadBalance {
+		return &WeightedRandomLB{url: url}
+	}))
+
+	extFactory.RegistExtLb(WeightedRoundrobin, NewWeightLbFunc(func(url *motan.URL) motan.LoadBalance {
+		return &WeightedRoundrobinLB{url: url}
+	}))
+
+	extFactory.RegistExtLb(Adaptive, NewWeightLbFunc(func(url *motan.URL) motan.LoadBalance {
+		return &AdaptiveLB{url: url}
+	}))
 }
 
 // WeightedLbWraper support multi group weighted LB
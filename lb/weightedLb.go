@@ -0,0 +1,143 @@
+package lb
+
+import (
+	"math/rand"
+	"sync"
+
+	motan "github.com/weibocom/motan-go/core"
+)
+
+// weightedNode is an endpoint with the node weight published in its url(motan.WeightKey, default 1)
+type weightedNode struct {
+	endpoint motan.EndPoint
+	weight   int64
+	current  int64 // the current weight of the smooth weighted round-robin
+}
+
+func newWeightedNodes(endpoints []motan.EndPoint) []*weightedNode {
+	nodes := make([]*weightedNode, 0, len(endpoints))
+	for _, ep := range endpoints {
+		weight := int64(defaultWeight)
+		if url := ep.GetURL(); url != nil {
+			weight = url.GetPositiveIntValue(motan.WeightKey, defaultWeight)
+		}
+		nodes = append(nodes, &weightedNode{endpoint: ep, weight: weight})
+	}
+	return nodes
+}
+
+// WeightedRoundrobinLB selects the endpoints by the smooth weighted round-robin of the node weights, the unavailable
+// endpoints are skipped without breaking the ratio of the others
+type WeightedRoundrobinLB struct {
+	url       *motan.URL
+	lock      sync.Mutex
+	endpoints []motan.EndPoint
+	nodes     []*weightedNode
+	weight    string
+}
+
+func (r *WeightedRoundrobinLB) OnRefresh(endpoints []motan.EndPoint) {
+	nodes := newWeightedNodes(endpoints)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.endpoints = endpoints
+	r.nodes = nodes
+}
+
+func (r *WeightedRoundrobinLB) Select(request motan.Request) motan.EndPoint {
+	_, endpoint := r.weightedSelect()
+	return endpoint
+}
+
+func (r *WeightedRoundrobinLB) SelectArray(request motan.Request) []motan.EndPoint {
+	index, endpoint := r.weightedSelect()
+	if endpoint == nil {
+		return nil
+	}
+	return SelectArrayFromIndex(r.getEndpoints(), index)
+}
+
+func (r *WeightedRoundrobinLB) SetWeight(weight string) {
+	r.weight = weight
+}
+
+func (r *WeightedRoundrobinLB) getEndpoints() []motan.EndPoint {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.endpoints
+}
+
+func (r *WeightedRoundrobinLB) weightedSelect() (int, motan.EndPoint) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var total int64
+	best := -1
+	for i, n := range r.nodes {
+		if !n.endpoint.IsAvailable() {
+			continue
+		}
+		n.current += n.weight
+		total += n.weight
+		if best < 0 || n.current > r.nodes[best].current {
+			best = i
+		}
+	}
+	if best < 0 {
+		return -1, nil
+	}
+	r.nodes[best].current -= total
+	return best, r.nodes[best].endpoint
+}
+
+// WeightedRandomLB selects the available endpoints at random with the probability in proportion to the node weights
+type WeightedRandomLB struct {
+	url       *motan.URL
+	endpoints []motan.EndPoint
+	nodes     []*weightedNode
+	weight    string
+}
+
+func (r *WeightedRandomLB) OnRefresh(endpoints []motan.EndPoint) {
+	r.nodes = newWeightedNodes(endpoints)
+	r.endpoints = endpoints
+}
+
+func (r *WeightedRandomLB) Select(request motan.Request) motan.EndPoint {
+	_, endpoint := weightedRandomSelect(r.nodes)
+	return endpoint
+}
+
+func (r *WeightedRandomLB) SelectArray(request motan.Request) []motan.EndPoint {
+	eps := r.endpoints
+	index, endpoint := weightedRandomSelect(r.nodes)
+	if endpoint == nil {
+		return nil
+	}
+	return SelectArrayFromIndex(eps, index)
+}
+
+func (r *WeightedRandomLB) SetWeight(weight string) {
+	r.weight = weight
+}
+
+func weightedRandomSelect(nodes []*weightedNode) (int, motan.EndPoint) {
+	var total int64
+	for _, n := range nodes {
+		if n.endpoint.IsAvailable() {
+			total += n.weight
+		}
+	}
+	if total == 0 {
+		return -1, nil
+	}
+	random := rand.Int63n(total)
+	for i, n := range nodes {
+		if !n.endpoint.IsAvailable() {
+			continue
+		}
+		if random -= n.weight; random < 0 {
+			return i, n.endpoint
+		}
+	}
+	return -1, nil
+}
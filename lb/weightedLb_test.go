@@ -0,0 +1,86 @@
+package lb
+
+import (
+	"strconv"
+	"testing"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/endpoint"
+)
+
+func newWeightedTestEndpoints(weights ...int) []motan.EndPoint {
+	endpoints := make([]motan.EndPoint, 0, len(weights))
+	for i, w := range weights {
+		url := &motan.URL{Port: 1000 + i, Parameters: map[string]string{}}
+		if w > 0 {
+			url.PutParam(motan.WeightKey, strconv.Itoa(w))
+		}
+		endpoints = append(endpoints, &endpoint.MockEndpoint{URL: url})
+	}
+	return endpoints
+}
+
+func TestWeightedRoundrobinLB(t *testing.T) {
+	lb := &WeightedRoundrobinLB{}
+	lb.OnRefresh(newWeightedTestEndpoints(5, 1, 0))
+	counts := make(map[int]int)
+	for i := 0; i < 70; i++ {
+		counts[lb.Select(nil).GetURL().Port]++
+	}
+	if counts[1000] != 50 || counts[1001] != 10 || counts[1002] != 10 {
+		t.Errorf("weighted roundrobin ratio not correct: %v\n", counts)
+	}
+	// the selection is smooth, the heaviest node is not selected more than weight times in a row
+	lb.OnRefresh(newWeightedTestEndpoints(2, 1))
+	seq := ""
+	for i := 0; i < 6; i++ {
+		seq += strconv.Itoa(lb.Select(nil).GetURL().Port - 1000)
+	}
+	if seq != "010010" {
+		t.Errorf("weighted roundrobin sequence not correct: %s\n", seq)
+	}
+	if eps := lb.SelectArray(nil); len(eps) != 2 {
+		t.Errorf("weighted roundrobin selectArray error: %v\n", eps)
+	}
+
+	endpoints := make([]motan.EndPoint, 0, 4)
+	for i := 0; i < 4; i++ {
+		endpoints = append(endpoints, lbTestMockEndpoint{MockEndpoint: &endpoint.MockEndpoint{URL: &motan.URL{Port: i}}, index: i, isAvail: i != 1})
+	}
+	lb.OnRefresh(endpoints)
+	for i := 0; i < 20; i++ {
+		if ep := lb.Select(nil); ep.(lbTestMockEndpoint).index == 1 {
+			t.Errorf("weighted roundrobin select error, isAvailable=false: %v\n", ep)
+		}
+	}
+	lb.OnRefresh(nil)
+	if ep := lb.Select(nil); ep != nil {
+		t.Errorf("weighted roundrobin select error, no endpoint: %v\n", ep)
+	}
+}
+
+func TestWeightedRandomLB(t *testing.T) {
+	lb := &WeightedRandomLB{}
+	lb.OnRefresh(newWeightedTestEndpoints(9, 1))
+	counts := make(map[int]int)
+	for i := 0; i < 1000; i++ {
+		counts[lb.Select(nil).GetURL().Port]++
+	}
+	if counts[1000] < 800 || counts[1001] == 0 {
+		t.Errorf("weighted random ratio not correct: %v\n", counts)
+	}
+	if eps := lb.SelectArray(nil); len(eps) != 2 {
+		t.Errorf("weighted random selectArray error: %v\n", eps)
+	}
+
+	endpoints := make([]motan.EndPoint, 0, 4)
+	for i := 0; i < 4; i++ {
+		endpoints = append(endpoints, lbTestMockEndpoint{MockEndpoint: &endpoint.MockEndpoint{URL: &motan.URL{Port: i}}, index: i, isAvail: i == 2})
+	}
+	lb.OnRefresh(endpoints)
+	for i := 0; i < 20; i++ {
+		if ep := lb.Select(nil); ep.(lbTestMockEndpoint).index != 2 {
+			t.Errorf("weighted random select error, isAvailable=false: %v\n", ep)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package vlog
+
+import (
+	"fmt"
+	"strings"
+)
+
+// the common keys of the fields logged by Infow, Warningw and Errorw
+const (
+	FieldService       = "service"
+	FieldMethod        = "method"
+	FieldGroup         = "group"
+	FieldRequestID     = "requestID"
+	FieldRemoteAddress = "remoteAddress"
+	FieldCost          = "cost" // ms
+	FieldError         = "error"
+)
+
+// FieldLogger is implemented by the loggers which write the fields of the messages, e.g. the keys of the json logs.
+// the fields of the messages are appended to the messages as key=value if the Logger does not implement it
+type FieldLogger interface {
+	Infow(msg string, keysAndValues ...interface{})
+	Warningw(msg string, keysAndValues ...interface{})
+	Errorw(msg string, keysAndValues ...interface{})
+}
+
+// Infow logs the message with the fields, the keysAndValues are the pairs of the keys and the values of the fields
+func Infow(msg string, keysAndValues ...interface{}) {
+	if l, ok := loggerInstance.(FieldLogger); ok {
+		l.Infow(msg, keysAndValues...)
+	} else {
+		Infoln(formatFields(msg, keysAndValues))
+	}
+}
+
+func Warningw(msg string, keysAndValues ...interface{}) {
+	if l, ok := loggerInstance.(FieldLogger); ok {
+		l.Warningw(msg, keysAndValues...)
+	} else {
+		Warningln(formatFields(msg, keysAndValues))
+	}
+}
+
+func Errorw(msg string, keysAndValues ...interface{}) {
+	if l, ok := loggerInstance.(FieldLogger); ok {
+		l.Errorw(msg, keysAndValues...)
+	} else {
+		Errorln(formatFields(msg, keysAndValues))
+	}
+}
+
+func formatFields(msg string, keysAndValues []interface{}) string {
+	var b strings.Builder
+	b.WriteString(msg)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		b.WriteString(" ")
+		if i+1 == len(keysAndValues) {
+			fmt.Fprintf(&b, "%v", keysAndValues[i])
+			break
+		}
+		fmt.Fprintf(&b, "%v=%v", keysAndValues[i], keysAndValues[i+1])
+	}
+	return b.String()
+}
+
+func (d *defaultLogger) Infow(msg string, keysAndValues ...interface{}) {
+	d.logger.Infow(msg, keysAndValues...)
+}
+
+func (d *defaultLogger) Warningw(msg string, keysAndValues ...interface{}) {
+	d.logger.Warnw(msg, keysAndValues...)
+}
+
+func (d *defaultLogger) Errorw(msg string, keysAndValues ...interface{}) {
+	d.logger.Errorw(msg, keysAndValues...)
+}
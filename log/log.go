@@ -22,6 +22,7 @@ var (
 	logAsync       = flag.Bool("log_async", true, "If false, write log sync, default is true")
 	logLevel       = flag.String("log_level", "info", "Init log level, default is info.")
 	logStructured  = flag.Bool("log_structured", false, "If true, write accessLog structured, default is false")
+	logFormat      = flag.String("log_format", LogFormatConsole, "The format of the logs except the access and metrics logs, console or json")
 	rotatePerHour  = flag.Bool("rotate_per_hour", true, "")
 )
 
@@ -51,8 +52,23 @@ type AccessLogEntity struct {
 	Success       bool   `json:"success"`
 	ResponseCode  string `json:"responseCode"`
 	Exception     string `json:"exception"`
+	Attachments   string `json:"attachments,omitempty"` // the configured subset of attachments
+	// Format is AccessLogFormatJSON or AccessLogFormatTSV, the line is formatted by log_structured if it is empty
+	Format string `json:"-"`
 }
 
+// the formats of the access log lines
+const (
+	AccessLogFormatJSON = "json" // structured fields
+	AccessLogFormatTSV  = "tsv"  // tab-separated values
+)
+
+// the formats of the logs set by log_format
+const (
+	LogFormatConsole = "console"
+	LogFormatJSON    = "json" // a json object per line, the fields logged by Infow, Warningw and Errorw are the keys of it
+)
+
 type Logger interface {
 	Infoln(...interface{})
 	Infof(string, ...interface{})
@@ -164,6 +180,13 @@ func MetricsLog(msg string) {
 	}
 }
 
+// Flush writes the buffered logs, it should be called before the process exits
+func Flush() {
+	if loggerInstance != nil {
+		loggerInstance.Flush()
+	}
+}
+
 func startFlush() {
 	go func() {
 		defer func() {
@@ -201,12 +224,54 @@ func GetLevel() LogLevel {
 	return defaultLogLevel
 }
 
+// SetLevel sets the log level, the restoring of SetLevelFor is canceled
 func SetLevel(level LogLevel) {
+	levelRestore.Lock()
+	defer levelRestore.Unlock()
+	if levelRestore.timer != nil {
+		levelRestore.timer.Stop()
+		levelRestore.timer = nil
+	}
+	setLevel(level)
+}
+
+func setLevel(level LogLevel) {
 	if loggerInstance != nil {
 		loggerInstance.SetLevel(level)
 	}
 }
 
+var levelRestore struct {
+	sync.Mutex
+	timer *time.Timer
+	level LogLevel
+}
+
+// SetLevelFor sets the log level temporarily, e.g. debug for troubleshooting, the level before it is restored after
+// the duration. if it is called again before the restoring, the duration is reset and the first level is restored
+func SetLevelFor(level LogLevel, duration time.Duration) {
+	levelRestore.Lock()
+	defer levelRestore.Unlock()
+	if levelRestore.timer != nil {
+		levelRestore.timer.Stop()
+	} else {
+		levelRestore.level = GetLevel()
+	}
+	setLevel(level)
+	var timer *time.Timer
+	timer = time.AfterFunc(duration, func() {
+		levelRestore.Lock()
+		defer levelRestore.Unlock()
+		if levelRestore.timer != timer {
+			return
+		}
+		levelRestore.timer = nil
+		setLevel(levelRestore.level)
+		Infof("log level is restored to %s", levelRestore.level)
+	})
+	levelRestore.timer = timer
+}
+
 func GetAccessLogAvailable() bool {
 	if loggerInstance != nil {
 		return loggerInstance.GetAccessLogAvailable()
@@ -251,7 +316,7 @@ func newDefaultLog() Logger {
 	level := zap.NewAtomicLevelAt(zapcore.Level(ll))
 	pName := filepath.Base(os.Args[0])
 	zapCore := zapcore.NewCore(
-		zapcore.NewConsoleEncoder(encoderConfig),
+		newLogEncoder(*logFormat, encoderConfig),
 		zapcore.NewMultiWriteSyncer(zapcore.AddSync(newRotateHook(pName+defaultLogSuffix))),
 		level,
 	)
@@ -269,6 +334,17 @@ func newDefaultLog() Logger {
 	}
 }
 
+func newLogEncoder(format string, config zapcore.EncoderConfig) zapcore.Encoder {
+	switch format {
+	case LogFormatJSON:
+		return zapcore.NewJSONEncoder(config)
+	case LogFormatConsole, "":
+	default:
+		log.Printf("unknown log format: %s, use the console format", format)
+	}
+	return zapcore.NewConsoleEncoder(config)
+}
+
 func newRotateHook(logName string) *RotateWriter {
 	rotator := &RotateWriter{
 		LocalTime:     true,
@@ -348,8 +424,16 @@ func (d *defaultLogger) AccessLog(logObject *AccessLogEntity) {
 }
 
 func (d *defaultLogger) doAccessLog(logObject *AccessLogEntity) {
-	if d.accessStructured {
-		d.accessLogger.Info("",
+	structured := d.accessStructured
+	separator := "|"
+	switch logObject.Format {
+	case AccessLogFormatJSON:
+		structured = true
+	case AccessLogFormatTSV:
+		structured, separator = false, "\t"
+	}
+	if structured {
+		fields := []zap.Field{
 			zap.String("filterName", logObject.FilterName),
 			zap.String("role", logObject.Role),
 			zap.Uint64("requestID", logObject.RequestID),
@@ -363,36 +447,44 @@ func (d *defaultLogger) doAccessLog(logObject *AccessLogEntity) {
 			zap.Int64("totalTime", logObject.TotalTime),
 			zap.Bool("success", logObject.Success),
 			zap.String("responseCode", logObject.ResponseCode),
-			zap.String("exception", logObject.Exception))
+			zap.String("exception", logObject.Exception)}
+		if logObject.Attachments != "" {
+			fields = append(fields, zap.String("attachments", logObject.Attachments))
+		}
+		d.accessLogger.Info("", fields...)
 	} else {
 		var buffer bytes.Buffer
 		buffer.WriteString(logObject.FilterName)
-		buffer.WriteString("|")
+		buffer.WriteString(separator)
 		buffer.WriteString(logObject.Role)
-		buffer.WriteString("|")
+		buffer.WriteString(separator)
 		buffer.WriteString(strconv.FormatUint(logObject.RequestID, 10))
-		buffer.WriteString("|")
+		buffer.WriteString(separator)
 		buffer.WriteString(logObject.Service)
-		buffer.WriteString("|")
+		buffer.WriteString(separator)
 		buffer.WriteString(logObject.Method)
-		buffer.WriteString("|")
+		buffer.WriteString(separator)
 		buffer.WriteString(logObject.Desc)
-		buffer.WriteString("|")
+		buffer.WriteString(separator)
 		buffer.WriteString(logObject.RemoteAddress)
-		buffer.WriteString("|")
+		buffer.WriteString(separator)
 		buffer.WriteString(strconv.Itoa(logObject.ReqSize))
-		buffer.WriteString("|")
+		buffer.WriteString(separator)
 		buffer.WriteString(strconv.Itoa(logObject.ResSize))
-		buffer.WriteString("|")
+		buffer.WriteString(separator)
 		buffer.WriteString(strconv.FormatInt(logObject.BizTime, 10))
-		buffer.WriteString("|")
+		buffer.WriteString(separator)
 		buffer.WriteString(strconv.FormatInt(logObject.TotalTime, 10))
-		buffer.WriteString("|")
+		buffer.WriteString(separator)
 		buffer.WriteString(strconv.FormatBool(logObject.Success))
-		buffer.WriteString("|")
+		buffer.WriteString(separator)
 		buffer.WriteString(logObject.ResponseCode)
-		buffer.WriteString("|")
+		buffer.WriteString(separator)
 		buffer.WriteString(logObject.Exception)
+		if logObject.Attachments != "" {
+			buffer.WriteString(separator)
+			buffer.WriteString(logObject.Attachments)
+		}
 		d.accessLogger.Info(buffer.String())
 	}
 }
@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 var logObject *AccessLogEntity
@@ -135,3 +138,74 @@ func TestAccessLog(t *testing.T) {
 	buffer.WriteString(logObject.Exception)
 	assert.Equal(t, buffer.String(), expectString)
 }
+
+func TestAccessLogFormat(t *testing.T) {
+	var buffer bytes.Buffer
+	zapCore := zapcore.NewCore(zapcore.NewConsoleEncoder(zapcore.EncoderConfig{MessageKey: "message"}), zapcore.AddSync(&buffer), zap.InfoLevel)
+	d := &defaultLogger{accessLogger: zap.New(zapCore)}
+	entity := *logObject
+	entity.ResponseCode = "503"
+	d.doAccessLog(&entity)
+	assert.Equal(t, "FilterName|Role|100|Service|Method|Desc|RemoteAddress|100|100|100|100|false|503|Exception\n", buffer.String())
+
+	buffer.Reset()
+	entity.Format = AccessLogFormatTSV
+	d.doAccessLog(&entity)
+	assert.Equal(t, "FilterName\tRole\t100\tService\tMethod\tDesc\tRemoteAddress\t100\t100\t100\t100\tfalse\t503\tException\n", buffer.String())
+
+	buffer.Reset()
+	entity.Format = AccessLogFormatJSON
+	d.doAccessLog(&entity)
+	assert.Contains(t, buffer.String(), `"requestID": 100`)
+	assert.Contains(t, buffer.String(), `"responseCode": "503"`)
+}
+
+func TestFieldLog(t *testing.T) {
+	var buffer bytes.Buffer
+	zapCore := zapcore.NewCore(newLogEncoder(LogFormatJSON, zapcore.EncoderConfig{MessageKey: "message", LevelKey: "level", EncodeLevel: zapcore.CapitalLevelEncoder}), zapcore.AddSync(&buffer), zap.InfoLevel)
+	d := &defaultLogger{logger: zap.New(zapCore).Sugar()}
+	d.Warningw("slow request", FieldService, "service", FieldRequestID, uint64(100), FieldRemoteAddress, "127.0.0.1")
+	assert.Equal(t, `{"level":"WARN","message":"slow request","service":"service","requestID":100,"remoteAddress":"127.0.0.1"}`+"\n", buffer.String())
+
+	buffer.Reset()
+	d.Infof("request %d", 100)
+	assert.Equal(t, `{"level":"INFO","message":"request 100"}`+"\n", buffer.String())
+
+	assert.Equal(t, "slow request service=service requestID=100 odd", formatFields("slow request", []interface{}{FieldService, "service", FieldRequestID, 100, "odd"}))
+}
+
+type levelLogger struct {
+	defaultLogger
+	level LogLevel
+}
+
+func (l *levelLogger) GetLevel() LogLevel {
+	return l.level
+}
+
+func (l *levelLogger) SetLevel(level LogLevel) {
+	l.level = level
+}
+
+func TestSetLevelFor(t *testing.T) {
+	origin := loggerInstance
+	defer func() {
+		loggerInstance = origin
+	}()
+	logger := &levelLogger{defaultLogger: defaultLogger{logger: zap.NewNop().Sugar()}, level: InfoLevel}
+	loggerInstance = logger
+
+	SetLevelFor(DebugLevel, 50*time.Millisecond)
+	assert.Equal(t, DebugLevel, GetLevel())
+	// the first level is restored
+	SetLevelFor(TraceLevel, 50*time.Millisecond)
+	assert.Equal(t, TraceLevel, GetLevel())
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, InfoLevel, GetLevel())
+
+	// the restoring is canceled by SetLevel
+	SetLevelFor(DebugLevel, 50*time.Millisecond)
+	SetLevel(WarnLevel)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, WarnLevel, GetLevel())
+}
@@ -31,6 +31,7 @@ import (
 	"github.com/weibocom/motan-go/log"
 	"github.com/weibocom/motan-go/metrics"
 	"github.com/weibocom/motan-go/protocol"
+	mserver "github.com/weibocom/motan-go/server"
 )
 
 // SetAgent : if need agent to do sth, the handler can implement this interface,
@@ -151,9 +152,23 @@ func (i *InfoHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		rw.Write(i.a.getConfigData())
 	case "/getReferService":
 		rw.Write(i.getReferService())
+	case "/getExportedService":
+		rw.Write(i.getExportedService())
 	}
 }
 
+// getExportedService returns the services served by the running motan servers
+func (i *InfoHandler) getExportedService() []byte {
+	data, _ := json.Marshal(struct {
+		Code int                   `json:"code"`
+		Body []mserver.ServiceInfo `json:"body"`
+	}{
+		Code: 200,
+		Body: mserver.GetExportedServices(),
+	})
+	return data
+}
+
 func (i *InfoHandler) getReferService() []byte {
 	mbody := body{Service: []rpcService{}}
 	i.a.clusterMap.Range(func(k, v interface{}) bool {
@@ -211,6 +226,8 @@ func (d *DebugHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 			Trace(rw, req)
 		case "/debug/mesh/trace":
 			MeshTrace(rw, req)
+		case "/debug/request/tap":
+			RequestTap(rw, req)
 		case "/debug/stat/system":
 			StatSystem(rw)
 		case "/debug/stat/process":
@@ -466,6 +483,54 @@ func MeshTrace(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// RequestTap streams the summaries of live requests matched by service, method and caller as json lines,
+// the tap stops when count requests are tapped, the seconds passed or the client is gone
+func RequestTap(w http.ResponseWriter, r *http.Request) {
+	sec, _ := strconv.ParseInt(r.FormValue("seconds"), 10, 64)
+	if sec == 0 {
+		sec = 30
+	}
+	count, _ := strconv.Atoi(r.FormValue("count"))
+	if count == 0 {
+		count = 100
+	}
+	filter := mserver.TapFilter{
+		Service: strings.TrimSpace(r.FormValue("service")),
+		Method:  strings.TrimSpace(r.FormValue("method")),
+		Caller:  strings.TrimSpace(r.FormValue("caller")),
+	}
+	tap, err := mserver.StartTap(filter, count, time.Duration(sec)*time.Second)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "start request tap fail. err:%v\n", err)
+		return
+	}
+	defer tap.Stop()
+	var clientGone <-chan bool
+	if cn, ok := w.(http.CloseNotifier); ok {
+		clientGone = cn.CloseNotify()
+	}
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case record, ok := <-tap.Records():
+			if !ok {
+				return
+			}
+			if err := encoder.Encode(record); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		case <-clientGone:
+			return
+		}
+	}
+}
+
 func formatTc(tc *motan.TraceContext) string {
 	processReqSpan(tc.ReqSpans)
 	processResSpan(tc.ResSpans)
@@ -624,6 +689,18 @@ func (l *LogHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		if lvlString := r.FormValue("level"); lvlString != "" {
 			var lvl vlog.LogLevel
 			if err := lvl.Set(lvlString); err == nil {
+				// the level is restored after the duration(seconds) if it is set, e.g. debug temporarily
+				if v := r.FormValue("duration"); v != "" {
+					seconds, err := strconv.Atoi(v)
+					if err != nil || seconds <= 0 {
+						_ = jsonEncoder.Encode(logResponse{Code: 400, Body: "illegal duration: " + v})
+						return
+					}
+					vlog.SetLevelFor(lvl, time.Duration(seconds)*time.Second)
+					_ = jsonEncoder.Encode(logResponse{Code: 200, Body: "set log level:" + lvlString + " for " + v + "s"})
+					vlog.Infoln("set log level:", lvlString, "for", v+"s")
+					return
+				}
 				vlog.SetLevel(lvl)
 				_ = jsonEncoder.Encode(logResponse{Code: 200, Body: "set log level:" + lvlString})
 				vlog.Infoln("set log level:", lvlString)
@@ -636,9 +713,39 @@ func (l *LogHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		} else if available := r.FormValue("metrics"); available != "" {
 			setLogStatus(jsonEncoder, "metricsLog", available)
 		}
+	case "/logConfig/method/get":
+		body, _ := json.Marshal(filter.GetMethodLogToggles())
+		_ = jsonEncoder.Encode(logResponse{Code: 200, Body: string(body)})
+	case "/logConfig/method/set":
+		service, method := r.FormValue("service"), r.FormValue("method")
+		if service == "" || method == "" {
+			_ = jsonEncoder.Encode(logResponse{Code: 400, Body: "service and method are required"})
+			return
+		}
+		if enable, err := strconv.ParseBool(r.FormValue("enable")); err != nil {
+			_ = jsonEncoder.Encode(logResponse{Code: 400, Body: "illegal enable: " + r.FormValue("enable")})
+		} else if !enable {
+			filter.DisableMethodLog(service, method)
+			_ = jsonEncoder.Encode(logResponse{Code: 200, Body: "disable method log of " + service + "." + method})
+		} else {
+			// duration is in seconds, 10 minutes by default
+			duration := defaultMethodLogDuration
+			if v := r.FormValue("duration"); v != "" {
+				seconds, err := strconv.Atoi(v)
+				if err != nil || seconds <= 0 {
+					_ = jsonEncoder.Encode(logResponse{Code: 400, Body: "illegal duration: " + v})
+					return
+				}
+				duration = time.Duration(seconds) * time.Second
+			}
+			filter.EnableMethodLog(service, method, duration)
+			_ = jsonEncoder.Encode(logResponse{Code: 200, Body: "enable method log of " + service + "." + method + " for " + duration.String()})
+		}
 	}
 }
 
+const defaultMethodLogDuration = 10 * time.Minute
+
 func setLogStatus(jsonEncoder *json.Encoder, logType, available string) {
 	if status, err := strconv.ParseBool(available); err == nil {
 		switch logType {
@@ -655,6 +762,31 @@ func setLogStatus(jsonEncoder *json.Encoder, logType, available string) {
 	}
 }
 
+// CircuitBreakerHandler lists the circuits of the endpoints with the circuitBreaker filter and their recent state changes
+type CircuitBreakerHandler struct{}
+
+func (c *CircuitBreakerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/circuitBreaker/list":
+		service := r.FormValue("service")
+		states := make([]filter.CircuitBreakerState, 0, 16)
+		for _, state := range filter.GetCircuitBreakerStates() {
+			if service == "" || state.Service == service {
+				states = append(states, state)
+			}
+		}
+		events := make([]filter.CircuitBreakerEvent, 0, 16)
+		for _, event := range filter.GetCircuitBreakerEvents() {
+			if service == "" || event.Service == service {
+				events = append(events, event)
+			}
+		}
+		writeHandlerResponse(w, http.StatusOK, "", map[string]interface{}{"circuits": states, "events": events})
+	default:
+		writeHandlerResponse(w, http.StatusNotFound, "not found", nil)
+	}
+}
+
 type HotReload struct {
 	agent *Agent
 }
@@ -678,6 +810,21 @@ func (h *HotReload) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			Code: 200,
 			Body: string(refersURLs),
 		})
+	case "/reload/config":
+		jsonEncoder := json.NewEncoder(w)
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			_ = jsonEncoder.Encode(logResponse{Code: http.StatusMethodNotAllowed, Body: "only POST is supported"})
+			return
+		}
+		changes, err := h.agent.ReloadConfig()
+		if err != nil {
+			vlog.Warningf("reload config fail. err:%v", err)
+			_ = jsonEncoder.Encode(logResponse{Code: 500, Body: "reload config fail. err:" + err.Error()})
+			return
+		}
+		body, _ := json.Marshal(changes)
+		_ = jsonEncoder.Encode(logResponse{Code: 200, Body: string(body)})
 	}
 }
 
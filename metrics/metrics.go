@@ -701,9 +701,16 @@ func (d *ReadonlyStatItem) IsGauge(key string) bool {
 }
 
 type metric struct {
-	Period    int
-	Processor int
-	Graphite  []graphite
+	Period     int
+	Processor  int
+	Graphite   []graphite
+	Prometheus prometheus
+}
+
+// prometheus serves the prometheus metrics on a http port, e.g. the standalone servers without the agent manage port
+type prometheus struct {
+	Port int
+	Path string // default /metrics
 }
 
 func StartReporter(ctx *motan.Context) {
@@ -723,11 +730,22 @@ func StartReporter(ctx *motan.Context) {
 				w := newGraphite(g.Host, g.Name, g.Port)
 				AddWriter(g.Name, w)
 			}
+			if m.Prometheus.Port > 0 {
+				startPrometheusServer(m.Prometheus.Port, m.Prometheus.Path)
+			}
 		}
 		for i := 0; i < rp.processor; i++ {
 			go rp.eventLoop()
 		}
 		go rp.sink()
+		application := DefaultStatApplication
+		if ctx.AgentURL != nil {
+			application = ctx.AgentURL.GetParam(motan.ApplicationKey, DefaultStatApplication)
+		}
+		motan.MethodPanicStatFunc = func(service string, method string) {
+			key := "panic" + KeyDelimiter + application + KeyDelimiter + Escape(method) + ".total_count"
+			AddCounter(DefaultStatGroup, Escape(service), key, 1)
+		}
 		// panic stat when agent model
 		if ctx.AgentURL != nil {
 			application := ctx.AgentURL.GetParam(motan.ApplicationKey, DefaultStatApplication)
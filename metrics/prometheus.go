@@ -0,0 +1,309 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// PrometheusContentType is the content type of the prometheus text exposition format
+const PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+const (
+	defaultPrometheusPath = "/metrics"
+
+	promCounter   = "counter"
+	promGauge     = "gauge"
+	promHistogram = "histogram"
+
+	promLabelSeparator = "\xff"
+)
+
+var (
+	// DefaultPromLatencyBuckets are the upper bounds(seconds) of the buckets of the latency histograms
+	DefaultPromLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.2, 0.5, 1, 2.5, 5}
+
+	promFamilies     = make(map[string]*promFamily, 16)
+	promFamiliesLock sync.RWMutex
+)
+
+// promFamily is a metric with the series of all the label values
+type promFamily struct {
+	name       string
+	help       string
+	typ        string
+	labelNames []string
+	buckets    []float64
+	lock       sync.RWMutex
+	series     map[string]*promSeries
+}
+
+type promSeries struct {
+	labelValues []string
+	value       int64 // the counters and the gauges
+	// the histograms
+	lock   sync.Mutex
+	counts []uint64 // not cumulative
+	sum    float64
+	count  uint64
+}
+
+func registerPromFamily(name string, help string, typ string, buckets []float64, labelNames []string) *promFamily {
+	promFamiliesLock.Lock()
+	defer promFamiliesLock.Unlock()
+	if f, ok := promFamilies[name]; ok {
+		return f
+	}
+	f := &promFamily{name: name, help: help, typ: typ, labelNames: labelNames, buckets: buckets, series: make(map[string]*promSeries)}
+	promFamilies[name] = f
+	return f
+}
+
+func (f *promFamily) getSeries(labelValues []string) *promSeries {
+	key := strings.Join(labelValues, promLabelSeparator)
+	f.lock.RLock()
+	s := f.series[key]
+	f.lock.RUnlock()
+	if s != nil {
+		return s
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if s = f.series[key]; s == nil {
+		values := make([]string, len(f.labelNames))
+		copy(values, labelValues) // the missing label values are empty
+		s = &promSeries{labelValues: values}
+		if f.typ == promHistogram {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// the prometheus metrics of the calls and the connections. the roles are the roles of the graphite keys, e.g.
+// motan-client, motan-server-agent
+var (
+	PromRequestsTotal    = NewPromCounter("motan_requests_total", "The total count of the calls.", "role", "group", "service", "method")
+	PromRequestErrors    = NewPromCounter("motan_request_errors_total", "The count of the failed calls by the exception type and code.", "role", "group", "service", "method", "type", "code")
+	PromRequestDuration  = NewPromHistogram("motan_request_duration_seconds", "The latency of the calls.", nil, "role", "group", "service", "method")
+	PromRequestsInFlight = NewPromGauge("motan_requests_in_flight", "The count of the calls in process.", "role", "group", "service")
+	PromConnections      = NewPromGauge("motan_endpoint_connections", "The count of the open connections of the endpoint pools.", "address")
+	PromConnectErrors    = NewPromCounter("motan_endpoint_connect_errors_total", "The count of the failed connects of the endpoints.", "address")
+)
+
+var promExceptionTypes = map[int]string{
+	motan.FrameworkException: "framework",
+	motan.ServiceException:   "service",
+	motan.BizException:       "biz",
+	motan.TimeoutException:   "timeout",
+}
+
+// RecordPromCall records a finished call, the exception is nil if the call is succeeded
+func RecordPromCall(role string, group string, service string, method string, duration time.Duration, exception *motan.Exception) {
+	PromRequestsTotal.Add(1, role, group, service, method)
+	PromRequestDuration.Observe(duration.Seconds(), role, group, service, method)
+	if exception != nil {
+		typ, ok := promExceptionTypes[exception.ErrType]
+		if !ok {
+			typ = strconv.Itoa(exception.ErrType)
+		}
+		PromRequestErrors.Add(1, role, group, service, method, typ, strconv.Itoa(exception.ErrCode))
+	}
+}
+
+// PromCounter is a prometheus counter, the label values are in the order of the label names
+type PromCounter struct {
+	family *promFamily
+}
+
+// NewPromCounter registers a counter, the registered one is returned if the name is registered
+func NewPromCounter(name string, help string, labelNames ...string) *PromCounter {
+	return &PromCounter{family: registerPromFamily(name, help, promCounter, nil, labelNames)}
+}
+
+func (c *PromCounter) Add(value int64, labelValues ...string) {
+	atomic.AddInt64(&c.family.getSeries(labelValues).value, value)
+}
+
+// PromGauge is a prometheus gauge, the label values are in the order of the label names
+type PromGauge struct {
+	family *promFamily
+}
+
+// NewPromGauge registers a gauge, the registered one is returned if the name is registered
+func NewPromGauge(name string, help string, labelNames ...string) *PromGauge {
+	return &PromGauge{family: registerPromFamily(name, help, promGauge, nil, labelNames)}
+}
+
+func (g *PromGauge) Add(value int64, labelValues ...string) {
+	atomic.AddInt64(&g.family.getSeries(labelValues).value, value)
+}
+
+func (g *PromGauge) Set(value int64, labelValues ...string) {
+	atomic.StoreInt64(&g.family.getSeries(labelValues).value, value)
+}
+
+// PromHistogram is a prometheus histogram, the label values are in the order of the label names
+type PromHistogram struct {
+	family *promFamily
+}
+
+// NewPromHistogram registers a histogram with the sorted upper bounds of the buckets, DefaultPromLatencyBuckets if
+// the buckets is empty. the registered one is returned if the name is registered
+func NewPromHistogram(name string, help string, buckets []float64, labelNames ...string) *PromHistogram {
+	if len(buckets) == 0 {
+		buckets = DefaultPromLatencyBuckets
+	}
+	return &PromHistogram{family: registerPromFamily(name, help, promHistogram, buckets, labelNames)}
+}
+
+func (h *PromHistogram) Observe(value float64, labelValues ...string) {
+	s := h.family.getSeries(labelValues)
+	i := sort.SearchFloat64s(h.family.buckets, value)
+	s.lock.Lock()
+	if i < len(s.counts) {
+		s.counts[i]++
+	}
+	s.sum += value
+	s.count++
+	s.lock.Unlock()
+}
+
+// WritePrometheus writes all the prometheus metrics in the text exposition format
+func WritePrometheus(w io.Writer) error {
+	promFamiliesLock.RLock()
+	families := make([]*promFamily, 0, len(promFamilies))
+	for _, f := range promFamilies {
+		families = append(families, f)
+	}
+	promFamiliesLock.RUnlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(bw)
+	}
+	return bw.Flush()
+}
+
+func (f *promFamily) write(w *bufio.Writer) {
+	f.lock.RLock()
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	series := make(map[string]*promSeries, len(keys))
+	for _, k := range keys {
+		series[k] = f.series[k]
+	}
+	f.lock.RUnlock()
+	if len(keys) == 0 {
+		return
+	}
+	sort.Strings(keys)
+	w.WriteString("# HELP " + f.name + " " + escapePromHelp(f.help) + "\n")
+	w.WriteString("# TYPE " + f.name + " " + f.typ + "\n")
+	for _, k := range keys {
+		s := series[k]
+		if f.typ != promHistogram {
+			w.WriteString(f.name + f.labels(s.labelValues, "") + " " + strconv.FormatInt(atomic.LoadInt64(&s.value), 10) + "\n")
+			continue
+		}
+		s.lock.Lock()
+		counts := make([]uint64, len(s.counts))
+		copy(counts, s.counts)
+		sum, count := s.sum, s.count
+		s.lock.Unlock()
+		var cumulative uint64
+		for i, bound := range f.buckets {
+			cumulative += counts[i]
+			w.WriteString(f.name + "_bucket" + f.labels(s.labelValues, formatPromFloat(bound)) + " " + strconv.FormatUint(cumulative, 10) + "\n")
+		}
+		w.WriteString(f.name + "_bucket" + f.labels(s.labelValues, "+Inf") + " " + strconv.FormatUint(count, 10) + "\n")
+		w.WriteString(f.name + "_sum" + f.labels(s.labelValues, "") + " " + formatPromFloat(sum) + "\n")
+		w.WriteString(f.name + "_count" + f.labels(s.labelValues, "") + " " + strconv.FormatUint(count, 10) + "\n")
+	}
+}
+
+// labels formats the labels of a series, the le label is added for the buckets of the histograms
+func (f *promFamily) labels(values []string, le string) string {
+	if len(f.labelNames) == 0 && le == "" {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, name := range f.labelNames {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(name + "=\"" + escapePromLabel(values[i]) + "\"")
+	}
+	if le != "" {
+		if len(f.labelNames) > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString("le=\"" + le + "\"")
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+var (
+	promLabelReplacer = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+	promHelpReplacer  = strings.NewReplacer("\\", "\\\\", "\n", "\\n")
+)
+
+func escapePromLabel(s string) string {
+	return promLabelReplacer.Replace(s)
+}
+
+func escapePromHelp(s string) string {
+	return promHelpReplacer.Replace(s)
+}
+
+func formatPromFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// PrometheusHandler serves the prometheus metrics, it is the /metrics of the agent manage server and the prometheus
+// server started by the metrics config
+func PrometheusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", PrometheusContentType)
+		WritePrometheus(w)
+	})
+}
+
+// startPrometheusServer listens the port before returning, so the listener inherited by the hot upgrade is used before
+// the parent process is notified, see motan.UpgradeReady
+func startPrometheusServer(port int, path string) {
+	if path == "" {
+		path = defaultPrometheusPath
+	}
+	listener, err := motan.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		vlog.Errorf("start prometheus server fail. port:%d, err:%v", port, err)
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, PrometheusHandler())
+	vlog.Infof("start prometheus server at %s%s", listener.Addr().String(), path)
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			vlog.Warningf("prometheus server stopped. port:%d, err:%v", port, err)
+		}
+	}()
+}
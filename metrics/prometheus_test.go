@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	counter := NewPromCounter("test_prom_calls_total", "The calls.\nOf the test.", "service", "method")
+	assert.Equal(t, counter.family, NewPromCounter("test_prom_calls_total", "").family)
+	counter.Add(1, "s1", "m1")
+	counter.Add(2, "s1", "m1")
+	counter.Add(1, "s\"2\"", "m\\2")
+	gauge := NewPromGauge("test_prom_pending", "The pending calls.")
+	gauge.Add(3)
+	gauge.Add(-1)
+	histogram := NewPromHistogram("test_prom_latency_seconds", "The latency.", []float64{0.1, 1}, "service")
+	histogram.Observe(0.05, "s1")
+	histogram.Observe(0.1, "s1")
+	histogram.Observe(0.5, "s1")
+	histogram.Observe(2, "s1")
+	NewPromGauge("test_prom_unused", "No series.")
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, WritePrometheus(buf))
+	out := buf.String()
+	assert.Contains(t, out, "# HELP test_prom_calls_total The calls.\\nOf the test.\n# TYPE test_prom_calls_total counter\n")
+	assert.Contains(t, out, "test_prom_calls_total{service=\"s1\",method=\"m1\"} 3\n")
+	assert.Contains(t, out, "test_prom_calls_total{service=\"s\\\"2\\\"\",method=\"m\\\\2\"} 1\n")
+	assert.Contains(t, out, "# TYPE test_prom_pending gauge\ntest_prom_pending 2\n")
+	assert.Contains(t, out, "# TYPE test_prom_latency_seconds histogram\n"+
+		"test_prom_latency_seconds_bucket{service=\"s1\",le=\"0.1\"} 2\n"+
+		"test_prom_latency_seconds_bucket{service=\"s1\",le=\"1\"} 3\n"+
+		"test_prom_latency_seconds_bucket{service=\"s1\",le=\"+Inf\"} 4\n"+
+		"test_prom_latency_seconds_sum{service=\"s1\"} 2.65\n"+
+		"test_prom_latency_seconds_count{service=\"s1\"} 4\n")
+	assert.NotContains(t, out, "test_prom_unused")
+	// the families are sorted by name
+	assert.True(t, strings.Index(out, "test_prom_calls_total") < strings.Index(out, "test_prom_latency_seconds"))
+}
+
+func TestPrometheusHandler(t *testing.T) {
+	RecordPromCall("motan-client", "g1", "com.weibo.Test", "hello", 30*time.Millisecond, nil)
+	RecordPromCall("motan-client", "g1", "com.weibo.Test", "hello", 300*time.Millisecond,
+		&motan.Exception{ErrCode: 503, ErrType: motan.ServiceException})
+
+	w := httptest.NewRecorder()
+	PrometheusHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, PrometheusContentType, w.Header().Get("Content-Type"))
+	body, _ := ioutil.ReadAll(w.Body)
+	out := string(body)
+	labels := "role=\"motan-client\",group=\"g1\",service=\"com.weibo.Test\",method=\"hello\""
+	assert.Contains(t, out, "motan_requests_total{"+labels+"} 2\n")
+	assert.Contains(t, out, "motan_request_errors_total{"+labels+",type=\"service\",code=\"503\"} 1\n")
+	assert.Contains(t, out, "motan_request_duration_seconds_bucket{"+labels+",le=\"0.05\"} 1\n")
+	assert.Contains(t, out, "motan_request_duration_seconds_count{"+labels+"} 2\n")
+}
@@ -0,0 +1,59 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"strconv"
+
+	motan "github.com/weibocom/motan-go/core"
+)
+
+var ErrBatch = errors.New("illegal batch frame")
+
+// BuildBatch builds a batch frame of the encoded requests, the body of it is the requests one after another. the
+// batch frame has no request id, the servers dispatch the requests of it and respond them one by one, so only the
+// servers supporting the batch frames can receive them
+func BuildBatch(frames [][]byte) *Message {
+	size := 0
+	for _, frame := range frames {
+		size += len(frame)
+	}
+	body := make([]byte, 0, size)
+	for _, frame := range frames {
+		body = append(body, frame...)
+	}
+	metadata := motan.NewStringMap(1)
+	metadata.Store(MBatch, strconv.Itoa(len(frames)))
+	return &Message{Header: BuildHeader(Req, false, Simple, 0, Normal), Metadata: metadata, Body: body, Type: Req}
+}
+
+// IsBatch returns whether the message is a batch frame built by BuildBatch
+func (msg *Message) IsBatch() bool {
+	return msg.Metadata != nil && msg.Metadata.LoadOrEmpty(MBatch) != ""
+}
+
+// DecodeBatch decodes the requests of a batch frame, the batch frames in a batch frame are illegal
+func DecodeBatch(msg *Message, supportedVersions []int) ([]*Message, error) {
+	count, err := strconv.Atoi(msg.Metadata.LoadOrEmpty(MBatch))
+	// the count is sent by the clients, every request has a header at least
+	if err != nil || count <= 0 || count > len(msg.Body)/HeaderLength {
+		return nil, ErrBatch
+	}
+	reader := bufio.NewReaderSize(bytes.NewReader(msg.Body), len(msg.Body))
+	var messages []*Message
+	for i := 0; i < count; i++ {
+		m, _, err := DecodeWithVersions(reader, supportedVersions)
+		if err != nil {
+			return nil, err
+		}
+		if m.IsBatch() {
+			return nil, ErrBatch
+		}
+		messages = append(messages, m)
+	}
+	if reader.Buffered() > 0 {
+		return nil, ErrBatch
+	}
+	return messages, nil
+}
@@ -0,0 +1,39 @@
+package protocol
+
+import (
+	"bufio"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	frames := make([][]byte, 0, 3)
+	for i := 0; i < 3; i++ {
+		msg := BuildHeartbeat(uint64(100+i), Req)
+		msg.Header.SetHeartbeat(false)
+		msg.Body = []byte{byte(i)}
+		frames = append(frames, msg.Encode().Bytes())
+	}
+	batch, err := Decode(bufio.NewReader(BuildBatch(frames).Encode()))
+	assertTrue(err == nil && batch.IsBatch(), "decode batch frame", t)
+	messages, err := DecodeBatch(batch, nil)
+	assertTrue(err == nil && len(messages) == 3, "decode batch messages", t)
+	for i, msg := range messages {
+		assertTrue(msg.Header.RequestID == uint64(100+i) && msg.Body[0] == byte(i), "batch message", t)
+	}
+	assertTrue(!messages[0].IsBatch(), "not batch", t)
+
+	// the count of the requests does not match the body
+	batch.Metadata.Store(MBatch, "2")
+	_, err = DecodeBatch(batch, nil)
+	assertTrue(err == ErrBatch, "trailing bytes", t)
+	batch.Metadata.Store(MBatch, "4")
+	_, err = DecodeBatch(batch, nil)
+	assertTrue(err != nil, "missing messages", t)
+	// the count can not be more than the requests the body can hold
+	batch.Metadata.Store(MBatch, "100000000")
+	_, err = DecodeBatch(batch, nil)
+	assertTrue(err == ErrBatch, "too many messages", t)
+	// the nested batch frames are illegal
+	_, err = DecodeBatch(BuildBatch([][]byte{BuildBatch(frames).Encode().Bytes()}), nil)
+	assertTrue(err == ErrBatch, "nested batch", t)
+}
@@ -0,0 +1,200 @@
+package protocol
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/klauspost/compress/snappy"
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// compression algorithms. gzip is marked by the gzip bit of the header for the compatibility, the others are marked by
+// MCompression. zstd is not built in, it can be registered by RegistCompressor with a zstd library
+const (
+	CompressionGzip   = "gzip"
+	CompressionSnappy = "snappy"
+	CompressionZstd   = "zstd"
+)
+
+// Compressor compresses the body of the messages, it must be safe for concurrent use
+type Compressor interface {
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+// pooledCompressor is implemented by the built-in compressors, the data is compressed into a buffer of the buffer pool
+type pooledCompressor interface {
+	compressPooled(data []byte) ([]byte, error)
+}
+
+var (
+	compressors     = map[string]Compressor{CompressionGzip: gzipCompressor{}, CompressionSnappy: snappyCompressor{}}
+	compressorsLock sync.RWMutex
+)
+
+// RegistCompressor registers a compression algorithm, gzip can not be replaced
+func RegistCompressor(name string, compressor Compressor) {
+	if name == CompressionGzip || compressor == nil {
+		return
+	}
+	compressorsLock.Lock()
+	defer compressorsLock.Unlock()
+	compressors[name] = compressor
+}
+
+func GetCompressor(name string) Compressor {
+	compressorsLock.RLock()
+	defer compressorsLock.RUnlock()
+	return compressors[name]
+}
+
+// SupportedCompressions returns the algorithms of the list registered, all the registered algorithms if the list is empty
+func SupportedCompressions(list string) []string {
+	compressorsLock.RLock()
+	defer compressorsLock.RUnlock()
+	var names []string
+	if list == "" {
+		for name := range compressors {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names
+	}
+	for _, name := range motan.TrimSplit(list, ",") {
+		if _, ok := compressors[name]; ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// NegotiateCompression returns the first algorithm of the accepted list(the value of MAcceptCompression) which is also
+// supported, empty if there is no such one
+func NegotiateCompression(accepted string, supported []string) string {
+	for _, name := range motan.TrimSplit(accepted, ",") {
+		for _, s := range supported {
+			if name == s {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// EncodeMessageCompression compress the uncompressed body with the algorithm if it is larger than minSize, empty
+// algorithm is gzip. the body compressed by the built-in algorithms is from the buffer pool, it can be released by
+// Message.ReleaseBody after the message is encoded
+func EncodeMessageCompression(msg *Message, algorithm string, minSize int) {
+	// the metadata may be the attachments of a request sent before
+	if msg.Metadata != nil {
+		msg.Metadata.Delete(MCompression)
+	}
+	if algorithm == "" || algorithm == CompressionGzip {
+		EncodeMessageGzip(msg, minSize)
+		return
+	}
+	if minSize <= 0 || len(msg.Body) <= minSize || msg.Header.IsGzip() {
+		return
+	}
+	compressor := GetCompressor(algorithm)
+	if compressor == nil {
+		vlog.Warningf("compression %s is not registered, the message is encoded by gzip. request id:%d", algorithm, msg.Header.RequestID)
+		EncodeMessageGzip(msg, minSize)
+		return
+	}
+	var data []byte
+	var err error
+	pc, pooled := compressor.(pooledCompressor)
+	if pooled {
+		data, err = pc.compressPooled(msg.Body)
+	} else {
+		data, err = compressor.Compress(msg.Body)
+	}
+	if err != nil {
+		vlog.Warningf("encode %s fail! request id:%d, err:%s", algorithm, msg.Header.RequestID, err.Error())
+		return
+	}
+	if pooled {
+		msg.setPooledBody(data)
+	} else {
+		msg.Body = data
+	}
+	if msg.Metadata == nil {
+		msg.Metadata = motan.NewStringMap(DefaultMetaSize)
+	}
+	msg.Metadata.Store(MCompression, algorithm)
+}
+
+// DecodeMessageBody decompress the body of the message in place
+func DecodeMessageBody(msg *Message) error {
+	if msg.Header.IsGzip() {
+		msg.Body = DecodeGzipBody(msg.Body)
+		msg.Header.SetGzip(false)
+		return nil
+	}
+	algorithm := msg.Metadata.LoadOrEmpty(MCompression)
+	if algorithm == "" {
+		return nil
+	}
+	compressor := GetCompressor(algorithm)
+	if compressor == nil {
+		return errors.New("unsupported compression: " + algorithm)
+	}
+	data, err := compressor.Decompress(msg.Body)
+	if err != nil {
+		return err
+	}
+	msg.Body = data
+	msg.Metadata.Delete(MCompression)
+	return nil
+}
+
+// DecompressBody returns the decompressed body without modifying the message
+func DecompressBody(msg *Message) []byte {
+	if msg.Header.IsGzip() {
+		return DecodeGzipBody(msg.Body)
+	}
+	if algorithm := msg.Metadata.LoadOrEmpty(MCompression); algorithm != "" {
+		if compressor := GetCompressor(algorithm); compressor != nil {
+			if data, err := compressor.Decompress(msg.Body); err == nil {
+				return data
+			}
+		}
+	}
+	return msg.Body
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Compress(data []byte) ([]byte, error) {
+	return EncodeGzip(data)
+}
+
+func (gzipCompressor) Decompress(data []byte) ([]byte, error) {
+	return DecodeGzip(data)
+}
+
+func (gzipCompressor) compressPooled(data []byte) ([]byte, error) {
+	return encodeGzip(data, motan.AcquireBytes)
+}
+
+// snappyCompressor uses the snappy block format, it has no state to reuse
+type snappyCompressor struct{}
+
+func (snappyCompressor) Compress(data []byte) ([]byte, error) {
+	return snappy.Encode(nil, data), nil
+}
+
+func (snappyCompressor) Decompress(data []byte) ([]byte, error) {
+	return snappy.Decode(nil, data)
+}
+
+func (snappyCompressor) compressPooled(data []byte) ([]byte, error) {
+	size := snappy.MaxEncodedLen(len(data))
+	if size < 0 {
+		return nil, snappy.ErrTooLarge
+	}
+	return snappy.Encode(motan.AcquireBytes(size), data), nil
+}
@@ -0,0 +1,51 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	motan "github.com/weibocom/motan-go/core"
+)
+
+func TestMessageCompression(t *testing.T) {
+	body := []byte(strings.Repeat("content", 100))
+	for _, algorithm := range []string{"", CompressionGzip, CompressionSnappy} {
+		msg := &Message{Header: BuildHeader(Req, false, Simple, 1, Normal), Metadata: motan.NewStringMap(0), Body: body}
+		EncodeMessageCompression(msg, algorithm, 10)
+		assertTrue(len(msg.Body) < len(body), "compressed body of "+algorithm, t)
+		assertTrue(algorithm == CompressionSnappy || msg.Header.IsGzip(), "gzip header of "+algorithm, t)
+		assertTrue(bytes.Equal(body, DecompressBody(msg)), "decompressed body of "+algorithm, t)
+
+		// the pooled body is released after encoded
+		buf := msg.Encode()
+		msg.ReleaseBody()
+		assertTrue(msg.Body == nil, "released body of "+algorithm, t)
+		decoded, err := Decode(bufio.NewReader(buf))
+		assertTrue(err == nil, "decode message of "+algorithm, t)
+		assertTrue(DecodeMessageBody(decoded) == nil && bytes.Equal(body, decoded.Body), "decode body of "+algorithm, t)
+		assertTrue(!decoded.Header.IsGzip() && decoded.Metadata.LoadOrEmpty(MCompression) == "", "decoded mark of "+algorithm, t)
+		motan.ReleaseBytesBuffer(buf)
+	}
+
+	// small bodies and the stale mark of the attachments
+	msg := &Message{Header: BuildHeader(Req, false, Simple, 1, Normal), Metadata: motan.NewStringMap(0), Body: []byte("small")}
+	msg.Metadata.Store(MCompression, CompressionSnappy)
+	EncodeMessageCompression(msg, CompressionSnappy, 10)
+	assertTrue(string(msg.Body) == "small" && msg.Metadata.LoadOrEmpty(MCompression) == "", "small body", t)
+	// the bodies not from the buffer pool are kept
+	msg.ReleaseBody()
+	assertTrue(string(msg.Body) == "small", "not pooled body", t)
+
+	msg.Metadata.Store(MCompression, CompressionZstd)
+	assertTrue(DecodeMessageBody(msg) != nil, "unsupported compression", t)
+}
+
+func TestNegotiateCompression(t *testing.T) {
+	assertTrue(strings.Join(SupportedCompressions(""), ",") == "gzip,snappy", "all compressions", t)
+	supported := SupportedCompressions("zstd, snappy")
+	assertTrue(len(supported) == 1 && supported[0] == CompressionSnappy, "registered compressions", t)
+	assertTrue(NegotiateCompression("zstd,snappy,gzip", []string{"gzip", "snappy"}) == CompressionSnappy, "negotiate by preference", t)
+	assertTrue(NegotiateCompression("zstd", []string{"gzip", "snappy"}) == "", "no common compression", t)
+}
@@ -0,0 +1,88 @@
+package protocol
+
+import (
+	"errors"
+	"strings"
+)
+
+// the codec of field compression
+const FieldCodecGzip = "gzip"
+
+// field types in MFieldCompress attachment, the decompressed field has the same type as the original one
+const (
+	fieldTypeString = "s"
+	fieldTypeBytes  = "b"
+)
+
+// CompressFields compress the string or []byte fields of a map value whose size is not less than minSize.
+// the value is not modified, a copy with the compressed fields is returned with the attachment value for MFieldCompress,
+// e.g. gzip:content=s,blob=b. the attachment is empty if no field is compressed
+func CompressFields(value map[string]interface{}, fields []string, minSize int) (map[string]interface{}, string, error) {
+	var compressed []string
+	var result map[string]interface{}
+	for _, field := range fields {
+		var data []byte
+		var fieldType string
+		switch v := value[field].(type) {
+		case string:
+			data, fieldType = []byte(v), fieldTypeString
+		case []byte:
+			data, fieldType = v, fieldTypeBytes
+		default:
+			continue
+		}
+		if len(data) == 0 || len(data) < minSize {
+			continue
+		}
+		encoded, err := EncodeGzip(data)
+		if err != nil {
+			return value, "", err
+		}
+		if result == nil {
+			result = make(map[string]interface{}, len(value))
+			for k, v := range value {
+				result[k] = v
+			}
+		}
+		result[field] = encoded
+		compressed = append(compressed, field+"="+fieldType)
+	}
+	if len(compressed) == 0 {
+		return value, "", nil
+	}
+	return result, FieldCodecGzip + ":" + strings.Join(compressed, ","), nil
+}
+
+// DecompressFields restore the fields compressed by CompressFields in place, attachment is the value of MFieldCompress
+func DecompressFields(value map[string]interface{}, attachment string) error {
+	if attachment == "" {
+		return nil
+	}
+	index := strings.Index(attachment, ":")
+	if index < 0 {
+		return errors.New("illegal field compress attachment: " + attachment)
+	}
+	if codec := attachment[:index]; codec != FieldCodecGzip {
+		return errors.New("unsupported field compress codec: " + codec)
+	}
+	for _, item := range strings.Split(attachment[index+1:], ",") {
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 {
+			return errors.New("illegal field compress attachment: " + attachment)
+		}
+		data, ok := value[kv[0]].([]byte)
+		if !ok {
+			return errors.New("compressed field is not bytes: " + kv[0])
+		}
+		decoded, err := DecodeGzip(data)
+		if err != nil {
+			return err
+		}
+		if kv[1] == fieldTypeString {
+			value[kv[0]] = string(decoded)
+		} else {
+			value[kv[0]] = decoded
+		}
+	}
+	return nil
+}
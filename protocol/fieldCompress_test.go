@@ -0,0 +1,26 @@
+package protocol
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompressFields(t *testing.T) {
+	content := strings.Repeat("content", 100)
+	value := map[string]interface{}{"content": content, "blob": []byte(content), "small": "s", "id": 1}
+	compressed, attachment, err := CompressFields(value, []string{"content", "blob", "small", "id", "absent"}, 10)
+	assertTrue(err == nil, "compress fields", t)
+	assertTrue(attachment == "gzip:content=s,blob=b", "compress attachment", t)
+	assertTrue(value["content"] == content, "original value", t)
+	_, ok := compressed["content"].([]byte)
+	assertTrue(ok && len(compressed["content"].([]byte)) < len(content), "compressed field", t)
+	assertTrue(compressed["small"] == "s" && compressed["id"] == 1, "uncompressed fields", t)
+
+	assertTrue(DecompressFields(compressed, attachment) == nil, "decompress fields", t)
+	assertTrue(compressed["content"] == content && string(compressed["blob"].([]byte)) == content, "decompressed fields", t)
+
+	_, attachment, _ = CompressFields(value, []string{"small"}, 10)
+	assertTrue(attachment == "", "no compressed fields", t)
+	assertTrue(DecompressFields(compressed, "zstd:content=s") != nil, "unsupported codec", t)
+	assertTrue(DecompressFields(compressed, "gzip:content") != nil, "illegal attachment", t)
+}
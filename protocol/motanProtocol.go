@@ -47,6 +47,34 @@ const (
 	MSource        = "M_s"
 	MRequestID     = "M_rid"
 	MTimeout       = "M_tmo"
+
+	MProgress        = "M_prg"  // percent of a progress message, only progress message has it
+	MProgressMessage = "M_prgm" // message of a progress message
+	MProgressEnabled = "M_prge" // request attachment, the server sends progress messages only if it is true
+
+	MFieldCompress = "M_fc" // response attachment of the compressed fields of the response value, see CompressFields
+
+	MCompression       = "M_cpr"  // the compression algorithm of the body except gzip, see EncodeMessageCompression
+	MAcceptCompression = "M_acpr" // the compression algorithms accepted by the sender for the reply, ordered by preference
+
+	MStream      = "M_stm" // request metadata, the request opens a stream if it is true
+	MStreamFrame = "M_stf" // type of a stream frame, only stream frames have it
+
+	MCacheBypass = "M_cbp" // request attachment, the cached responses are not returned if it is true, see filter.ResponseCacheFilter
+
+	MBatch = "M_bat" // metadata of a batch frame, the count of the requests in the body, see BuildBatch
+)
+
+func init() {
+	motan.RegisterReservedAttachmentKeys(MPath, MMethod, MExceptionn, MProcessTime, MMethodDesc, MGroup, MProxyProtocol,
+		MVersion, MModule, MSource, MRequestID, MTimeout, MProgress, MProgressMessage, MProgressEnabled, MFieldCompress,
+		MCompression, MAcceptCompression, MStream, MStreamFrame, MCacheBypass, MBatch)
+}
+
+// the MStreamFrame values
+const (
+	StreamFrameData = "d" // a message of the stream
+	StreamFrameEnd  = "e" // the sender has finished sending, only sent by the client. the server ends by the final response
 )
 
 type Header struct {
@@ -72,6 +100,8 @@ type Message struct {
 	Metadata *motan.StringMap
 	Body     []byte
 	Type     int
+	// the body is compressed into a buffer of the buffer pool, see ReleaseBody
+	pooledBody bool
 }
 
 //serialize
@@ -97,6 +127,7 @@ var (
 
 	defaultSerialize = Simple
 	writerPool       = &sync.Pool{}                         // for gzip writer
+	readerPool       = &sync.Pool{}                         // for gzip reader
 	readBufPool      = &sync.Pool{}                         // for gzip read buffer
 	writeBufPool     = &sync.Pool{New: func() interface{} { // for gzip write buffer
 		return &bytes.Buffer{}
@@ -114,6 +145,12 @@ var (
 	ErrSerializedData = errors.New("message serialized data not correct")
 )
 
+// DefaultMaxBodySize is the default limit of the request and response bodies of the motan2 server and endpoint
+const DefaultMaxBodySize = 64 * 1024 * 1024
+
+// OversizedBodyMetric is the counter of the messages rejected for the bodies larger than the limit
+const OversizedBodyMetric = "oversized_body.total_count"
+
 // BuildRequestHeader build a proxy request header
 func BuildRequestHeader(requestID uint64) *Header {
 	return BuildHeader(Req, true, defaultSerialize, requestID, Normal)
@@ -134,6 +171,53 @@ func BuildHeartbeat(requestID uint64, msgType int) *Message {
 	return request
 }
 
+// BuildProgress build a progress message of the request, it is a normal response with MProgress in metadata.
+// the client should keep waiting the final response after received it
+func BuildProgress(requestID uint64, serialize int, percent int, message string) *Message {
+	res := &Message{
+		Header:   BuildHeader(Res, false, serialize, requestID, Normal),
+		Metadata: motan.NewStringMap(DefaultMetaSize),
+		Body:     make([]byte, 0),
+		Type:     Res,
+	}
+	res.Metadata.Store(MProgress, strconv.Itoa(percent))
+	res.Metadata.Store(MProgressMessage, message)
+	return res
+}
+
+// BuildStreamFrame build a frame of the stream opened by the request, the frames of the client are requests and the
+// frames of the server are responses, the stream ends after the final response of the server
+func BuildStreamFrame(msgType int, requestID uint64, serialize int, frame string, body []byte) *Message {
+	if body == nil {
+		body = make([]byte, 0)
+	}
+	msg := &Message{
+		Header:   BuildHeader(msgType, false, serialize, requestID, Normal),
+		Metadata: motan.NewStringMap(DefaultMetaSize),
+		Body:     body,
+		Type:     msgType,
+	}
+	msg.Metadata.Store(MStreamFrame, frame)
+	return msg
+}
+
+// GetStreamFrame returns the frame type of the stream frame, it is empty if the message is not a stream frame
+func (m *Message) GetStreamFrame() string {
+	if m.Metadata == nil {
+		return ""
+	}
+	return m.Metadata.LoadOrEmpty(MStreamFrame)
+}
+
+// IsProgress check whether the response message is a progress message
+func (m *Message) IsProgress() bool {
+	if m.Metadata == nil {
+		return false
+	}
+	_, ok := m.Metadata.Load(MProgress)
+	return ok
+}
+
 func (h *Header) SetVersion(version int) error {
 	if version > 31 {
 		return ErrVersion
@@ -251,8 +335,11 @@ func BuildHeader(msgType int, proxy bool, serialize int, requestID uint64, msgSt
 	return header
 }
 
+// Encode encodes the message into a buffer of the buffer pool, the buffer can be released by motan.ReleaseBytesBuffer
+// after it is written
 func (msg *Message) Encode() (buf *motan.BytesBuffer) {
-	metabuf := motan.NewBytesBuffer(256)
+	metabuf := motan.AcquireBytesBuffer(256)
+	defer motan.ReleaseBytesBuffer(metabuf)
 	msg.Metadata.Range(func(k, v string) bool {
 		if k == "" || v == "" {
 			return true
@@ -261,9 +348,9 @@ func (msg *Message) Encode() (buf *motan.BytesBuffer) {
 			vlog.Errorf("metadata not correct.k:%s, v:%s", k, v)
 			return true
 		}
-		metabuf.Write([]byte(k))
+		metabuf.WriteString(k)
 		metabuf.WriteByte('\n')
-		metabuf.Write([]byte(v))
+		metabuf.WriteString(v)
 		metabuf.WriteByte('\n')
 		return true
 	})
@@ -273,7 +360,7 @@ func (msg *Message) Encode() (buf *motan.BytesBuffer) {
 	}
 	metasize := metabuf.Len()
 	bodysize := len(msg.Body)
-	buf = motan.NewBytesBuffer(int(HeaderLength + bodysize + metasize + 8))
+	buf = motan.AcquireBytesBuffer(int(HeaderLength + bodysize + metasize + 8))
 	// encode header.
 	buf.WriteUint16(MotanMagic)
 	buf.WriteByte(msg.Header.MsgType)
@@ -295,6 +382,24 @@ func (msg *Message) Encode() (buf *motan.BytesBuffer) {
 	return buf
 }
 
+// ReleaseBody returns the body compressed by EncodeMessageCompression to the buffer pool, the body must not be used
+// after that, e.g. after the message is encoded. the bodies not from the buffer pool are kept
+func (msg *Message) ReleaseBody() {
+	if !msg.pooledBody {
+		return
+	}
+	motan.ReleaseBytes(msg.Body)
+	msg.Body = nil
+	msg.pooledBody = false
+}
+
+// setPooledBody replaces the body with the one from the buffer pool, the replaced body is released if it is pooled
+func (msg *Message) setPooledBody(body []byte) {
+	msg.ReleaseBody()
+	msg.Body = body
+	msg.pooledBody = true
+}
+
 func (msg *Message) Clone() interface{} {
 	newMessage := &Message{
 		Header: msg.Header.Clone(),
@@ -313,7 +418,55 @@ func Decode(buf *bufio.Reader) (msg *Message, err error) {
 }
 
 func DecodeWithTime(buf *bufio.Reader) (msg *Message, start time.Time, err error) {
-	temp := make([]byte, HeaderLength, HeaderLength)
+	return DecodeWithVersions(buf, nil)
+}
+
+// VersionError means the message version is not supported. the header has been decoded,
+// so the receiver can build a response for the request id before closing the connection
+type VersionError struct {
+	Header *Header
+}
+
+func (e *VersionError) Error() string {
+	return ErrVersion.Error() + ", unsupported version: " + strconv.Itoa(e.Header.GetVersion())
+}
+
+// BodySizeError means the message body is larger than the limit. the body has been skipped without reading it into
+// memory, so the message has the header and metadata only, and the following messages can be decoded
+type BodySizeError struct {
+	Message *Message
+	Size    int
+	Limit   int
+}
+
+func (e *BodySizeError) Error() string {
+	return "message body size " + strconv.Itoa(e.Size) + " exceeds the limit " + strconv.Itoa(e.Limit)
+}
+
+// MetadataSizeError means the message metadata is larger than the limit. the metadata and the body have been skipped
+// without reading them into memory, so only the header is decoded, and the following messages can be decoded
+type MetadataSizeError struct {
+	Header *Header
+	Size   int
+	Limit  int
+}
+
+func (e *MetadataSizeError) Error() string {
+	return "message metadata size " + strconv.Itoa(e.Size) + " exceeds the limit " + strconv.Itoa(e.Limit)
+}
+
+// DecodeWithVersions decode a message only if its protocol version is in the supported versions.
+// the Version2 will be used if supported versions is empty. all supported versions share the motan2 frame format.
+func DecodeWithVersions(buf *bufio.Reader, supportedVersions []int) (msg *Message, start time.Time, err error) {
+	return DecodeWithLimit(buf, supportedVersions, 0, 0)
+}
+
+// DecodeWithLimit decode a message like DecodeWithVersions, a *BodySizeError is returned if the body is larger than
+// maxBodySize, and a *MetadataSizeError is returned if the metadata is larger than maxMetadataSize. there is no limit
+// if the limit is not positive
+func DecodeWithLimit(buf *bufio.Reader, supportedVersions []int, maxBodySize int, maxMetadataSize int) (msg *Message, start time.Time, err error) {
+	temp := motan.AcquireBytes(HeaderLength)
+	defer motan.ReleaseBytes(temp)
 
 	// decode header
 	_, err = io.ReadAtLeast(buf, temp, HeaderLength)
@@ -330,13 +483,16 @@ func DecodeWithTime(buf *bufio.Reader) (msg *Message, start time.Time, err error
 	header := &Header{Magic: MotanMagic}
 	header.MsgType = temp[2]
 	header.VersionStatus = temp[3]
+	header.Serialize = temp[4]
+	header.RequestID = binary.BigEndian.Uint64(temp[5:])
 	version := header.GetVersion()
-	if version != Version2 {
+	if !IsSupportedVersion(version, supportedVersions) {
 		vlog.Errorf("unsupported protocol version number: %d", version)
-		return nil, start, ErrVersion
+		if supportedVersions == nil {
+			return nil, start, ErrVersion
+		}
+		return nil, start, &VersionError{Header: header}
 	}
-	header.Serialize = temp[4]
-	header.RequestID = binary.BigEndian.Uint64(temp[5:])
 
 	// decode meta
 	_, err = io.ReadAtLeast(buf, temp[:4], 4)
@@ -344,9 +500,24 @@ func DecodeWithTime(buf *bufio.Reader) (msg *Message, start time.Time, err error
 		return nil, start, err
 	}
 	metasize := int(binary.BigEndian.Uint32(temp[:4]))
+	if maxMetadataSize > 0 && metasize > maxMetadataSize {
+		if _, err = buf.Discard(metasize); err != nil {
+			return nil, start, err
+		}
+		if _, err = io.ReadAtLeast(buf, temp[:4], 4); err != nil {
+			return nil, start, err
+		}
+		if _, err = buf.Discard(int(binary.BigEndian.Uint32(temp[:4]))); err != nil {
+			return nil, start, err
+		}
+		return nil, start, &MetadataSizeError{Header: header, Size: metasize, Limit: maxMetadataSize}
+	}
 	metamap := motan.NewStringMap(DefaultMetaSize)
 	if metasize > 0 {
-		metadata, err := readBytes(buf, metasize)
+		// the metadata are copied into strings, so the bytes are released after decoding
+		metadata := motan.AcquireBytes(metasize)
+		defer motan.ReleaseBytes(metadata)
+		_, err = io.ReadFull(buf, metadata)
 		if err != nil {
 			return nil, start, err
 		}
@@ -356,7 +527,8 @@ func DecodeWithTime(buf *bufio.Reader) (msg *Message, start time.Time, err error
 			if i == metasize || metadata[i] == '\n' {
 				e = i
 				if k == "" {
-					k = string(metadata[s:e])
+					// the reserved keys of the other implementations are in the same form, e.g. the reserved keys through the http
+					k = motan.NormalizeAttachmentKey(string(metadata[s:e]))
 				} else {
 					metamap.Store(k, string(metadata[s:e]))
 					k = ""
@@ -376,6 +548,13 @@ func DecodeWithTime(buf *bufio.Reader) (msg *Message, start time.Time, err error
 		return nil, start, err
 	}
 	bodysize := int(binary.BigEndian.Uint32(temp[:4]))
+	if maxBodySize > 0 && bodysize > maxBodySize {
+		if _, err = buf.Discard(bodysize); err != nil {
+			return nil, start, err
+		}
+		msg = &Message{Header: header, Metadata: metamap, Body: make([]byte, 0), Type: Req}
+		return msg, start, &BodySizeError{Message: msg, Size: bodysize, Limit: maxBodySize}
+	}
 	var body []byte
 	if bodysize > 0 {
 		body, err = readBytes(buf, bodysize)
@@ -385,10 +564,23 @@ func DecodeWithTime(buf *bufio.Reader) (msg *Message, start time.Time, err error
 	if err != nil {
 		return nil, start, err
 	}
-	msg = &Message{header, metamap, body, Req}
+	msg = &Message{Header: header, Metadata: metamap, Body: body, Type: Req}
 	return msg, start, err
 }
 
+// IsSupportedVersion check the version is one of supported versions, only Version2 is supported if supported versions is empty
+func IsSupportedVersion(version int, supportedVersions []int) bool {
+	if len(supportedVersions) == 0 {
+		return version == Version2
+	}
+	for _, v := range supportedVersions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
 func DecodeGzipBody(body []byte) []byte {
 	ret, err := DecodeGzip(body)
 	if err != nil {
@@ -410,6 +602,11 @@ func readBytes(buf *bufio.Reader, size int) ([]byte, error) {
 }
 
 func EncodeGzip(data []byte) ([]byte, error) {
+	return encodeGzip(data, func(size int) []byte { return make([]byte, size) })
+}
+
+// encodeGzip compresses the data into the bytes returned by alloc
+func encodeGzip(data []byte, alloc func(size int) []byte) ([]byte, error) {
 	if len(data) > 0 {
 		buf := writeBufPool.Get().(*bytes.Buffer)
 		var w *gzip.Writer
@@ -434,31 +631,38 @@ func EncodeGzip(data []byte) ([]byte, error) {
 			return nil, err
 		}
 		w.Close()
-		ret := make([]byte, buf.Len())
+		ret := alloc(buf.Len())
 		copy(ret, buf.Bytes())
 		return ret, nil
 	}
 	return data, nil
 }
 
+// EncodeMessageGzip compresses the body into a buffer of the buffer pool, it can be released by Message.ReleaseBody
 func EncodeMessageGzip(msg *Message, gzipSize int) {
 	if gzipSize > 0 && len(msg.Body) > gzipSize && !msg.Header.IsGzip() {
-		data, err := EncodeGzip(msg.Body)
+		data, err := encodeGzip(msg.Body, motan.AcquireBytes)
 		if err != nil {
 			vlog.Warningf("encode gzip fail! request id:%d, err:%s", msg.Header.RequestID, err.Error())
 		} else {
 			msg.Header.SetGzip(true)
-			msg.Body = data
+			msg.setPooledBody(data)
 		}
 	}
 }
 
 func DecodeGzip(data []byte) (ret []byte, err error) {
 	if len(data) > 0 {
-		r, err := gzip.NewReader(bytes.NewBuffer(data))
+		r, ok := readerPool.Get().(*gzip.Reader)
+		if ok {
+			err = r.Reset(bytes.NewReader(data))
+		} else {
+			r, err = gzip.NewReader(bytes.NewReader(data))
+		}
 		if err != nil {
 			return nil, err
 		}
+		defer readerPool.Put(r)
 		var buf *bytes.Buffer
 		temp := readBufPool.Get()
 		if temp == nil {
@@ -515,11 +719,11 @@ func ConvertToRequest(request *Message, serialize motan.Serialization) (motan.Re
 	rc := motanRequest.GetRPCContext(true)
 	rc.OriginalMessage = request
 	rc.Proxy = request.Header.IsProxy()
+	rc.Oneway = request.Header.IsOneWay()
 	if request.Body != nil && len(request.Body) > 0 {
 		rc.BodySize = len(request.Body)
-		if request.Header.IsGzip() {
-			request.Body = DecodeGzipBody(request.Body)
-			request.Header.SetGzip(false)
+		if err := DecodeMessageBody(request); err != nil {
+			return nil, err
 		}
 		if !rc.Proxy && serialize == nil {
 			return nil, ErrSerializeNil
@@ -539,7 +743,7 @@ func ConvertToReqMessage(request motan.Request, serialize motan.Serialization) (
 			msg.Header.SetProxy(true)
 			// Make sure group is the requested group
 			msg.Metadata.Store(MGroup, request.GetAttachment(MGroup))
-			EncodeMessageGzip(msg, rc.GzipSize)
+			EncodeMessageCompression(msg, rc.Compression, rc.GzipSize)
 			rc.BodySize = len(msg.Body)
 			return msg, nil
 		}
@@ -576,7 +780,7 @@ func ConvertToReqMessage(request motan.Request, serialize motan.Serialization) (
 	}
 
 	req.Metadata = request.GetAttachments()
-	EncodeMessageGzip(req, rc.GzipSize)
+	EncodeMessageCompression(req, rc.Compression, rc.GzipSize)
 	rc.BodySize = len(req.Body)
 	if rc.Oneway {
 		req.Header.SetOneWay(true)
@@ -645,7 +849,7 @@ func ConvertToResMessage(response motan.Response, serialize motan.Serialization)
 	}
 
 	res.Metadata = response.GetAttachments()
-	EncodeMessageGzip(res, rc.GzipSize)
+	EncodeMessageCompression(res, rc.Compression, rc.GzipSize)
 	rc.BodySize = len(res.Body)
 	if rc.Proxy {
 		res.Header.SetProxy(true)
@@ -667,9 +871,8 @@ func ConvertToResponse(response *Message, serialize motan.Serialization) (motan.
 	}
 	if response.Header.GetStatus() == Normal && len(response.Body) > 0 {
 		rc.BodySize = len(response.Body)
-		if response.Header.IsGzip() {
-			response.Body = DecodeGzipBody(response.Body)
-			response.Header.SetGzip(false)
+		if err := DecodeMessageBody(response); err != nil {
+			return nil, err
 		}
 		if !rc.Proxy && serialize == nil {
 			return nil, ErrSerializeNil
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"compress/gzip"
+	"encoding/json"
 	"fmt"
 	"math/rand"
 	"sync"
@@ -139,6 +140,9 @@ func TestEncode(t *testing.T) {
 	h.RequestID = 2349789
 	meta := core.NewStringMap(0)
 	meta.Store("k1", "v1")
+	meta.Store("m_foo", "v2")
+	meta.Store("motan-x", "v3")
+	meta.Store("Motan-G", "group")
 	body := []byte("testbody")
 	msg := &Message{Header: h, Metadata: meta, Body: body}
 	ebytes := msg.Encode()
@@ -157,6 +161,9 @@ func TestEncode(t *testing.T) {
 	assertTrue(newMsg.Header.GetSerialize() == 5, "serialize", t)
 	assertTrue(newMsg.Header.GetStatus() == 6, "status", t)
 	assertTrue(newMsg.Metadata.LoadOrEmpty("k1") == "v1", "meta", t)
+	// only the reserved keys are normalized
+	assertTrue(newMsg.Metadata.LoadOrEmpty("m_foo") == "v2" && newMsg.Metadata.LoadOrEmpty("motan-x") == "v3", "user meta", t)
+	assertTrue(newMsg.Metadata.LoadOrEmpty(MGroup) == "group", "reserved meta", t)
 	assertTrue(len(newMsg.Body) == len(msg.Body), "body", t)
 
 	msg.Header.SetProxy(false)
@@ -176,6 +183,36 @@ func TestEncode(t *testing.T) {
 	assertTrue(string(nb) == "gzip encode", "body", t)
 }
 
+func TestDecodeWithVersions(t *testing.T) {
+	msg := BuildHeartbeat(123, Req)
+	msg.Header.SetVersion(3)
+	_, err := Decode(bufio.NewReader(msg.Encode()))
+	assertTrue(err == ErrVersion, "default version", t)
+
+	_, _, err = DecodeWithVersions(bufio.NewReader(msg.Encode()), []int{Version2})
+	ve, ok := err.(*VersionError)
+	assertTrue(ok, "version error", t)
+	assertTrue(ve.Header.RequestID == 123 && ve.Header.GetVersion() == 3, "version error header", t)
+
+	newMsg, _, err := DecodeWithVersions(bufio.NewReader(msg.Encode()), []int{Version2, 3})
+	assertTrue(err == nil && newMsg.Header.GetVersion() == 3, "supported version", t)
+}
+
+func TestExceptionDetailsConvert(t *testing.T) {
+	e, _ := core.NewDetailedException(400, "invalid params", core.BizException, map[string]string{"name": "required"})
+	msg := BuildExceptionResponse(1, ExceptionToJSON(e))
+	// old clients only read the flat fields
+	var old struct {
+		ErrMsg string `json:"errmsg"`
+	}
+	assertTrue(json.Unmarshal([]byte(msg.Metadata.LoadOrEmpty(MExceptionn)), &old) == nil && old.ErrMsg == "invalid params", "old exception", t)
+	res, err := ConvertToResponse(msg, nil)
+	assertTrue(err == nil, "convert to response", t)
+	var details map[string]string
+	ok, err := res.GetException().DecodeDetails(&details)
+	assertTrue(ok && err == nil && details["name"] == "required", "exception details", t)
+}
+
 func assertTrue(b bool, msg string, t *testing.T) {
 	if !b {
 		t.Fatalf("test fail, %s not correct.", msg)
@@ -287,3 +324,34 @@ func buildBytes(size int) []byte {
 	}
 	return result.Bytes()
 }
+
+func TestDecodeWithLimit(t *testing.T) {
+	msg := BuildHeartbeat(123, Req)
+	msg.Body = []byte("0123456789")
+	buf := msg.Encode()
+	buf.Write(BuildHeartbeat(124, Req).Encode().Bytes())
+	reader := bufio.NewReader(buf)
+	_, _, err := DecodeWithLimit(reader, nil, 5, 0)
+	be, ok := err.(*BodySizeError)
+	assertTrue(ok, "body size error", t)
+	assertTrue(be.Message.Header.RequestID == 123 && be.Size == 10 && be.Limit == 5, "body size error message", t)
+
+	// the body is skipped, the next message can be decoded
+	next, _, err := DecodeWithLimit(reader, nil, 5, 0)
+	assertTrue(err == nil && next.Header.RequestID == 124, "next message", t)
+
+	newMsg, _, err := DecodeWithLimit(bufio.NewReader(msg.Encode()), nil, 10, 0)
+	assertTrue(err == nil && string(newMsg.Body) == "0123456789", "body in limit", t)
+
+	// the metadata is checked before reading it
+	msg.Metadata.Store("key", "0123456789")
+	buf = msg.Encode()
+	buf.Write(BuildHeartbeat(125, Req).Encode().Bytes())
+	reader = bufio.NewReader(buf)
+	_, _, err = DecodeWithLimit(reader, nil, 0, 10)
+	me, ok := err.(*MetadataSizeError)
+	assertTrue(ok, "metadata size error", t)
+	assertTrue(me.Header.RequestID == 123 && me.Limit == 10 && me.Size > 10, "metadata size error message", t)
+	next, _, err = DecodeWithLimit(reader, nil, 0, 10)
+	assertTrue(err == nil && next.Header.RequestID == 125, "next message after metadata", t)
+}
@@ -0,0 +1,285 @@
+package provider
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// ConfigProvider methods and params
+const (
+	ConfigMethodGet   = "get"   // get(key string) returns the value, the version is in response attachment
+	ConfigMethodWatch = "watch" // watch(key string, version int64[, timeoutMs int64]) returns when the version of key changed or timeout
+
+	ConfigVersionKey      = "configVersion"      // response attachment of config version
+	ConfigWatchTimeoutKey = "configWatchTimeout" // url parameter of max watch time in ms
+)
+
+const defaultConfigWatchTimeout = 30 * time.Second
+
+// ConfigItem is a versioned config blob, the version changes every time the value changes.
+// version 0 means the key does not exist
+type ConfigItem struct {
+	Key     string
+	Value   string
+	Version int64
+}
+
+// ConfigStore is the backend of ConfigProvider
+type ConfigStore interface {
+	Get(key string) *ConfigItem
+	// Watch returns a channel which will be closed when the version of key is not equal to the version,
+	// cancel should be called if the caller stops waiting before the channel is closed
+	Watch(key string, version int64) (changed <-chan struct{}, cancel func())
+}
+
+// ConfigProvider distribute configs(e.g. feature flags) of a ConfigStore to clients.
+// clients watch a key in a loop with the version they have, the change is returned as soon as it happens(long polling).
+// the store is set by SetService, a MemoryConfigStore is used if not set
+type ConfigProvider struct {
+	url          *motan.URL
+	store        ConfigStore
+	watchTimeout time.Duration
+}
+
+func (c *ConfigProvider) Initialize() {
+	if c.store == nil {
+		c.store = NewMemoryConfigStore()
+	}
+	c.watchTimeout = c.url.GetTimeDuration(ConfigWatchTimeoutKey, time.Millisecond, defaultConfigWatchTimeout)
+}
+
+func (c *ConfigProvider) SetService(s interface{}) {
+	if store, ok := s.(ConfigStore); ok {
+		c.store = store
+	} else if s != nil {
+		vlog.Warningf("service of config provider is not a ConfigStore. url:%v", c.url)
+	}
+}
+
+func (c *ConfigProvider) GetURL() *motan.URL {
+	return c.url
+}
+
+func (c *ConfigProvider) SetURL(url *motan.URL) {
+	c.url = url
+}
+
+func (c *ConfigProvider) GetPath() string {
+	return c.url.Path
+}
+
+func (c *ConfigProvider) IsAvailable() bool {
+	return true
+}
+
+func (c *ConfigProvider) Destroy() {}
+
+func (c *ConfigProvider) Call(request motan.Request) motan.Response {
+	if err := request.ProcessDeserializable(nil); err != nil {
+		return configException(request, 500, "deserialize arguments fail."+err.Error())
+	}
+	args := request.GetArguments()
+	if len(args) == 0 {
+		return configException(request, 400, "config key is required")
+	}
+	key, ok := args[0].(string)
+	if !ok || key == "" {
+		return configException(request, 400, "config key must be a non-empty string")
+	}
+	switch request.GetMethod() {
+	case ConfigMethodGet:
+		return buildConfigResponse(request, c.store.Get(key))
+	case ConfigMethodWatch:
+		if len(args) < 2 {
+			return configException(request, 400, "config version is required")
+		}
+		version, err := toInt64(args[1])
+		if err != nil {
+			return configException(request, 400, "illegal config version: "+err.Error())
+		}
+		timeout := c.watchTimeout
+		if len(args) > 2 {
+			if ms, err := toInt64(args[2]); err == nil && ms > 0 && time.Duration(ms)*time.Millisecond < timeout {
+				timeout = time.Duration(ms) * time.Millisecond
+			}
+		}
+		return buildConfigResponse(request, c.watch(key, version, timeout))
+	default:
+		return configException(request, 400, "method "+request.GetMethod()+" is not found in config provider")
+	}
+}
+
+func (c *ConfigProvider) watch(key string, version int64, timeout time.Duration) *ConfigItem {
+	changed, cancel := c.store.Watch(key, version)
+	defer cancel()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-changed:
+	case <-timer.C:
+	}
+	return c.store.Get(key)
+}
+
+func buildConfigResponse(request motan.Request, item *ConfigItem) motan.Response {
+	res := &motan.MotanResponse{RequestID: request.GetRequestID()}
+	if item == nil {
+		res.Value = ""
+		res.SetAttachment(ConfigVersionKey, "0")
+		return res
+	}
+	res.Value = item.Value
+	res.SetAttachment(ConfigVersionKey, strconv.FormatInt(item.Version, 10))
+	return res
+}
+
+func configException(request motan.Request, code int, msg string) motan.Response {
+	return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: code, ErrMsg: msg, ErrType: motan.ServiceException})
+}
+
+func toInt64(v interface{}) (int64, error) {
+	switch n := v.(type) {
+	case int64:
+		return n, nil
+	case int:
+		return int64(n), nil
+	case int32:
+		return int64(n), nil
+	case int16:
+		return int64(n), nil
+	case int8:
+		return int64(n), nil
+	case uint8:
+		return int64(n), nil
+	case string:
+		return strconv.ParseInt(n, 10, 64)
+	}
+	return 0, errors.New("not an integer")
+}
+
+// MemoryConfigStore is a ConfigStore in memory, configs are updated by Put and Delete
+type MemoryConfigStore struct {
+	lock     sync.Mutex
+	items    map[string]*ConfigItem
+	watchers map[string]map[chan struct{}]bool
+	version  int64
+}
+
+func NewMemoryConfigStore() *MemoryConfigStore {
+	return &MemoryConfigStore{items: make(map[string]*ConfigItem), watchers: make(map[string]map[chan struct{}]bool)}
+}
+
+// Put set the value of key and notify the watchers, the version is increased even if the value is not changed
+func (m *MemoryConfigStore) Put(key string, value string) int64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.version++
+	m.items[key] = &ConfigItem{Key: key, Value: value, Version: m.version}
+	m.notify(key)
+	return m.version
+}
+
+func (m *MemoryConfigStore) Delete(key string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.items[key]; ok {
+		delete(m.items, key)
+		m.notify(key)
+	}
+}
+
+func (m *MemoryConfigStore) Get(key string) *ConfigItem {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if item, ok := m.items[key]; ok {
+		c := *item
+		return &c
+	}
+	return nil
+}
+
+func (m *MemoryConfigStore) Watch(key string, version int64) (<-chan struct{}, func()) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	ch := make(chan struct{})
+	var current int64
+	if item, ok := m.items[key]; ok {
+		current = item.Version
+	}
+	if current != version {
+		close(ch)
+		return ch, func() {}
+	}
+	if m.watchers[key] == nil {
+		m.watchers[key] = make(map[chan struct{}]bool)
+	}
+	m.watchers[key][ch] = true
+	return ch, func() {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+		if watchers, ok := m.watchers[key]; ok {
+			delete(watchers, ch)
+			if len(watchers) == 0 {
+				delete(m.watchers, key)
+			}
+		}
+	}
+}
+
+func (m *MemoryConfigStore) notify(key string) {
+	for ch := range m.watchers[key] {
+		close(ch)
+	}
+	delete(m.watchers, key)
+}
+
+// WatchConfig watch a key of a ConfigProvider in background through the caller(e.g. an endpoint or cluster),
+// onChange is called with the latest item when the version changed. call the returned func to stop watching
+func WatchConfig(caller motan.Caller, key string, timeout time.Duration, onChange func(item *ConfigItem)) (stop func()) {
+	done := make(chan struct{})
+	var once sync.Once
+	go func() {
+		defer motan.HandlePanic(nil)
+		var version int64
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			request := &motan.MotanRequest{ServiceName: caller.GetURL().Path, Method: ConfigMethodWatch,
+				Arguments: []interface{}{key, version, int64(timeout / time.Millisecond)}}
+			res := caller.Call(request)
+			if res.GetException() != nil {
+				vlog.Warningf("watch config fail. key:%s, err:%v", key, res.GetException().ErrMsg)
+				select {
+				case <-done:
+					return
+				case <-time.After(time.Second):
+				}
+				continue
+			}
+			newVersion, _ := strconv.ParseInt(res.GetAttachment(ConfigVersionKey), 10, 64)
+			if newVersion == version {
+				continue
+			}
+			version = newVersion
+			if err := res.ProcessDeserializable(nil); err != nil {
+				vlog.Warningf("deserialize config fail. key:%s, err:%v", key, err)
+				continue
+			}
+			value, _ := res.GetValue().(string)
+			onChange(&ConfigItem{Key: key, Value: value, Version: version})
+		}
+	}()
+	return func() {
+		once.Do(func() {
+			close(done)
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package provider
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+)
+
+func newConfigProvider(store ConfigStore) *ConfigProvider {
+	p := &ConfigProvider{url: &motan.URL{Path: "configService", Parameters: map[string]string{ConfigWatchTimeoutKey: "200"}}}
+	p.SetService(store)
+	p.Initialize()
+	return p
+}
+
+func TestMemoryConfigStore(t *testing.T) {
+	store := NewMemoryConfigStore()
+	assert.Nil(t, store.Get("k"))
+	changed, cancel := store.Watch("k", 0)
+	v := store.Put("k", "v1")
+	select {
+	case <-changed:
+	default:
+		assert.Fail(t, "watcher not notified")
+	}
+	cancel()
+	assert.Equal(t, "v1", store.Get("k").Value)
+	assert.Equal(t, v, store.Get("k").Version)
+
+	// stale version returns immediately
+	changed, cancel = store.Watch("k", 0)
+	_, ok := <-changed
+	assert.False(t, ok)
+	cancel()
+
+	changed, cancel = store.Watch("k", v)
+	cancel()
+	assert.Equal(t, 0, len(store.watchers))
+	store.Delete("k")
+	assert.Nil(t, store.Get("k"))
+}
+
+func TestConfigProvider(t *testing.T) {
+	store := NewMemoryConfigStore()
+	p := newConfigProvider(store)
+	version := store.Put("flag", "on")
+
+	res := p.Call(&motan.MotanRequest{Method: ConfigMethodGet, Arguments: []interface{}{"flag"}})
+	assert.Nil(t, res.GetException())
+	assert.Equal(t, "on", res.GetValue())
+	assert.Equal(t, strconv.FormatInt(version, 10), res.GetAttachment(ConfigVersionKey))
+
+	res = p.Call(&motan.MotanRequest{Method: ConfigMethodGet, Arguments: []interface{}{"absent"}})
+	assert.Equal(t, "0", res.GetAttachment(ConfigVersionKey))
+	assert.NotNil(t, p.Call(&motan.MotanRequest{Method: ConfigMethodGet}).GetException())
+	assert.NotNil(t, p.Call(&motan.MotanRequest{Method: "unknown", Arguments: []interface{}{"flag"}}).GetException())
+
+	// watch returns at timeout if nothing changed
+	start := time.Now()
+	res = p.Call(&motan.MotanRequest{Method: ConfigMethodWatch, Arguments: []interface{}{"flag", version, int64(50)}})
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+	assert.Equal(t, strconv.FormatInt(version, 10), res.GetAttachment(ConfigVersionKey))
+
+	// watch returns as soon as changed
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		store.Put("flag", "off")
+	}()
+	start = time.Now()
+	res = p.Call(&motan.MotanRequest{Method: ConfigMethodWatch, Arguments: []interface{}{"flag", version}})
+	assert.True(t, time.Since(start) < 150*time.Millisecond)
+	assert.Equal(t, "off", res.GetValue())
+}
+
+func TestWatchConfig(t *testing.T) {
+	store := NewMemoryConfigStore()
+	p := newConfigProvider(store)
+	store.Put("flag", "on")
+	items := make(chan *ConfigItem, 10)
+	stop := WatchConfig(p, "flag", 100*time.Millisecond, func(item *ConfigItem) {
+		items <- item
+	})
+	defer stop()
+	item := <-items
+	assert.Equal(t, "on", item.Value)
+	store.Put("flag", "off")
+	select {
+	case item = <-items:
+		assert.Equal(t, "off", item.Value)
+	case <-time.After(time.Second):
+		assert.Fail(t, "config change not received")
+	}
+}
@@ -40,6 +40,10 @@ type HTTPProvider struct {
 	domain            string
 	defaultHTTPMethod string
 	enableRewrite     bool
+	proxyRetries      int
+	// the attachment names to the header names, and the header names to the attachment names
+	requestHeaders  map[string]string
+	responseHeaders map[string]string
 }
 
 const (
@@ -84,7 +88,14 @@ func (h *HTTPProvider) Initialize() {
 		h.defaultHTTPMethod = DefaultMotanHTTPMethod
 	}
 	h.domain = h.url.GetParam(mhttp.DomainKey, "")
-	h.locationMatcher = mhttp.NewLocationMatcherFromContext(h.domain, h.gctx)
+	if rules := h.url.GetParam(mhttp.ProxyRewriteRulesKey, ""); rules != "" {
+		h.locationMatcher = mhttp.NewLocationMatcher([]*mhttp.ProxyLocation{{Upstream: h.url.Path, Match: "/", Type: "start", RewriteRules: motan.TrimSplit(rules, ";")}})
+	} else {
+		h.locationMatcher = mhttp.NewLocationMatcherFromContext(h.domain, h.gctx)
+	}
+	h.proxyRetries = int(h.url.GetIntValue(mhttp.ProxyRetriesKey, 0))
+	h.requestHeaders = parseHeaderMapping(h.url, mhttp.ProxyRequestHeadersKey)
+	h.responseHeaders = parseHeaderMapping(h.url, mhttp.ProxyResponseHeadersKey)
 	h.proxyAddr = h.url.GetParam(mhttp.ProxyAddressKey, "")
 	h.proxySchema = h.url.GetParam(mhttp.ProxySchemaKey, "http")
 	h.maxConnections = int(h.url.GetPositiveIntValue(mhttp.MaxConnectionsKey, 512))
@@ -114,6 +125,61 @@ func (h *HTTPProvider) Initialize() {
 	}
 }
 
+// parseHeaderMapping parses the mapping like 'from1:to1,from2:to2', the illegal pairs are ignored
+func parseHeaderMapping(url *motan.URL, key string) map[string]string {
+	value := url.GetParam(key, "")
+	if value == "" {
+		return nil
+	}
+	mapping := make(map[string]string)
+	for _, pair := range motan.TrimSplit(value, ",") {
+		kv := motan.TrimSplit(pair, ":")
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			vlog.Errorf("illegal %s of %s: %s, the pair %s is ignored", key, url.Path, value, pair)
+			continue
+		}
+		mapping[kv[0]] = kv[1]
+	}
+	return mapping
+}
+
+// doProxy sends the request to the upstream, the idempotent requests failing with errors are retried at most proxyRetries times
+func (h *HTTPProvider) doProxy(httpReq *fasthttp.Request, httpRes *fasthttp.Response) error {
+	err := h.fastClient.Do(httpReq, httpRes)
+	for i := 0; err != nil && i < h.proxyRetries && isIdempotent(httpReq); i++ {
+		vlog.Warningf("proxy request %s to %s fail, retry %d. err: %v", httpReq.URI().Path(), h.proxyAddr, i+1, err)
+		httpRes.Reset()
+		err = h.fastClient.Do(httpReq, httpRes)
+	}
+	return err
+}
+
+func isIdempotent(httpReq *fasthttp.Request) bool {
+	switch string(httpReq.Header.Method()) {
+	case "GET", "HEAD", "PUT", "DELETE", "OPTIONS", "TRACE":
+		return true
+	}
+	return false
+}
+
+// mapRequestHeaders sets the headers mapped from the attachments of the request
+func (h *HTTPProvider) mapRequestHeaders(request motan.Request, httpReq *fasthttp.Request) {
+	for attachment, header := range h.requestHeaders {
+		if v := request.GetAttachment(attachment); v != "" {
+			httpReq.Header.Set(header, v)
+		}
+	}
+}
+
+// mapResponseHeaders sets the attachments mapped from the headers of the http response
+func (h *HTTPProvider) mapResponseHeaders(resp *motan.MotanResponse, httpRes *fasthttp.Response) {
+	for header, attachment := range h.responseHeaders {
+		if v := httpRes.Header.Peek(header); len(v) > 0 {
+			resp.SetAttachment(attachment, string(v))
+		}
+	}
+}
+
 // Destroy a HTTPProvider
 func (h *HTTPProvider) Destroy() {
 }
@@ -271,16 +337,18 @@ func (h *HTTPProvider) Call(request motan.Request) motan.Response {
 			}
 			return true
 		})
+		h.mapRequestHeaders(request, httpReq)
 		httpReq.Header.Del("Connection")
 		httpReq.Header.Set("X-Forwarded-For", ip)
 		if len(bodyBytes) != 0 {
 			httpReq.BodyWriter().Write(bodyBytes)
 		}
-		err := h.fastClient.Do(httpReq, httpRes)
+		err := h.doProxy(httpReq, httpRes)
 		if err != nil {
 			fillExceptionWithCode(resp, http.StatusServiceUnavailable, t, err)
 			return resp
 		}
+		h.mapResponseHeaders(resp, httpRes)
 		headerBuffer := &bytes.Buffer{}
 		httpRes.Header.Del("Connection")
 		httpRes.Header.WriteTo(headerBuffer)
@@ -328,13 +396,15 @@ func (h *HTTPProvider) Call(request motan.Request) motan.Response {
 		if len(httpReq.Header.Host()) == 0 {
 			httpReq.Header.SetHost(h.domain)
 		}
+		h.mapRequestHeaders(request, httpReq)
 		httpReq.Header.Set("X-Forwarded-For", ip)
-		err = h.fastClient.Do(httpReq, httpRes)
+		err = h.doProxy(httpReq, httpRes)
 		if err != nil {
 			fillExceptionWithCode(resp, http.StatusServiceUnavailable, t, err)
 			return resp
 		}
 		mhttp.FasthttpResponseToMotanResponse(resp, httpRes)
+		h.mapResponseHeaders(resp, httpRes)
 		resp.ProcessTime = (time.Now().UnixNano() - t) / 1e6
 		updateUpstreamStatusCode(resp, httpRes.StatusCode())
 		return resp
@@ -2,8 +2,10 @@ package provider
 
 import (
 	"bytes"
+	"net"
 	"net/http"
 	"os"
+	"sync/atomic"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -72,15 +74,79 @@ func TestHTTPProvider_Call(t *testing.T) {
 	assert.Equal(t, "/2/p1/test?a=b", string(provider.Call(req).GetValue().([]interface{})[1].([]byte)))
 }
 
+// closeFirstListener closes the first connection of the listener without response
+type closeFirstListener struct {
+	net.Listener
+	accepted int32
+}
+
+func (l *closeFirstListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil || atomic.AddInt32(&l.accepted, 1) > 1 {
+			return conn, err
+		}
+		conn.Close()
+	}
+}
+
+func TestHTTPProvider_Proxy(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	upstream := &closeFirstListener{Listener: listener}
+	defer upstream.Close()
+	go http.Serve(upstream, http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("X-Cost", "12")
+		writer.Write([]byte(request.URL.Path + " " + request.Header.Get("X-Uid")))
+	}))
+	req := &core.MotanRequest{ServiceName: "legacy", Method: "/v1/user"}
+	req.SetAttachment("uid", "100")
+
+	// the upstream connection closed is not retried by default
+	provider := newProxyTestProvider(listener.Addr().String(), map[string]string{mhttp.ProxyRewriteRulesKey: "start /v1/ ^/v1/(.*) /api/$1"})
+	assert.NotNil(t, provider.Call(req).GetException())
+	atomic.StoreInt32(&upstream.accepted, 0)
+	provider = newProxyTestProvider(listener.Addr().String(), map[string]string{
+		mhttp.ProxyRewriteRulesKey:    "exact /v2 /(.*) /$1; start /v1/ ^/v1/(.*) /api/$1",
+		mhttp.ProxyRetriesKey:         "1",
+		mhttp.ProxyRequestHeadersKey:  "uid:X-Uid, illegal",
+		mhttp.ProxyResponseHeadersKey: "X-Cost:cost",
+	})
+	assert.Equal(t, map[string]string{"uid": "X-Uid"}, provider.requestHeaders)
+	res := provider.Call(req)
+	assert.Nil(t, res.GetException())
+	assert.Equal(t, "/api/user 100", string(res.GetValue().([]byte)))
+	assert.Equal(t, "12", res.GetAttachment("cost"))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&upstream.accepted))
+
+	// the non-idempotent requests are not retried
+	atomic.StoreInt32(&upstream.accepted, 0)
+	provider = newProxyTestProvider(listener.Addr().String(), map[string]string{mhttp.ProxyRetriesKey: "1", mhttp.EnableRewriteKey: "false"})
+	req.SetAttachment(mhttp.Method, "POST")
+	assert.NotNil(t, provider.Call(req).GetException())
+}
+
+// newProxyTestProvider returns the initialized provider proxying to the address with the domain legacy.domain
+func newProxyTestProvider(address string, params map[string]string) *HTTPProvider {
+	providerURL := &core.URL{Protocol: "http", Path: "legacy", Parameters: params}
+	providerURL.PutParam(mhttp.ProxyAddressKey, address)
+	providerURL.PutParam(mhttp.DomainKey, "legacy.domain")
+	provider := &HTTPProvider{url: providerURL, gctx: &core.Context{Config: config.NewConfig()}}
+	provider.Initialize()
+	return provider
+}
+
 func TestMain(m *testing.M) {
-	go func() {
-		var addr = ":9090"
-		handler := &http.ServeMux{}
-		handler.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
-			request.ParseForm()
-			writer.Write([]byte(request.URL.String()))
-		})
-		http.ListenAndServe(addr, handler)
-	}()
+	// listen before the tests, so the first requests are not refused
+	listener, err := net.Listen("tcp", ":9090")
+	if err != nil {
+		panic(err)
+	}
+	handler := &http.ServeMux{}
+	handler.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
+		request.ParseForm()
+		writer.Write([]byte(request.URL.String()))
+	})
+	go http.Serve(listener, handler)
 	os.Exit(m.Run())
 }
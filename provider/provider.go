@@ -1,7 +1,10 @@
 package provider
 
 import (
+	"context"
+	"errors"
 	"reflect"
+	"sync"
 
 	motan "github.com/weibocom/motan-go/core"
 	"github.com/weibocom/motan-go/log"
@@ -15,6 +18,7 @@ const (
 	MOTAN2  = "motan2"
 	Mock    = "mockProvider"
 	Default = "default"
+	Config  = "config"
 )
 
 func RegistDefaultProvider(extFactory motan.ExtensionFactory) {
@@ -42,16 +46,33 @@ func RegistDefaultProvider(extFactory motan.ExtensionFactory) {
 	extFactory.RegistExtProvider(Default, func(url *motan.URL) motan.Provider {
 		return &DefaultProvider{url: url}
 	})
+
+	extFactory.RegistExtProvider(Config, func(url *motan.URL) motan.Provider {
+		return &ConfigProvider{url: url}
+	})
 }
 
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	streamType  = reflect.TypeOf((*motan.Stream)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
 type DefaultProvider struct {
 	service interface{}
+	lock    sync.RWMutex
 	methods map[string]reflect.Value
 	url     *motan.URL
 }
 
+// Initialize adds the methods of the service, the methods added by AddMethod are kept, so the provider can be
+// initialized again, e.g. exported again after unexport
 func (d *DefaultProvider) Initialize() {
-	d.methods = make(map[string]reflect.Value, 32)
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.methods == nil {
+		d.methods = make(map[string]reflect.Value, 32)
+	}
 	if d.service != nil && d.url != nil {
 		v := reflect.ValueOf(d.service)
 		if v.Kind() != reflect.Ptr {
@@ -69,6 +90,53 @@ func (d *DefaultProvider) Initialize() {
 	}
 }
 
+// GetMethodNames returns the exported methods of the service and the methods added by AddMethod
+func (d *DefaultProvider) GetMethodNames() []string {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	names := make([]string, 0, len(d.methods))
+	for name := range d.methods {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (d *DefaultProvider) HasMethod(name string) bool {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	_, ok := d.methods[motan.FirstUpper(name)]
+	return ok
+}
+
+// AddMethod add a func as a method of the provider, it replaces the method of the same name
+func (d *DefaultProvider) AddMethod(name string, method interface{}) error {
+	if name == "" {
+		return errors.New("method name is empty")
+	}
+	v := reflect.ValueOf(method)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return errors.New("method " + name + " is not a func")
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.methods == nil {
+		d.methods = make(map[string]reflect.Value)
+	}
+	d.methods[motan.FirstUpper(name)] = v
+	return nil
+}
+
+func (d *DefaultProvider) RemoveMethod(name string) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	name = motan.FirstUpper(name)
+	if _, ok := d.methods[name]; !ok {
+		return false
+	}
+	delete(d.methods, name)
+	return true
+}
+
 func (d *DefaultProvider) SetService(s interface{}) {
 	d.service = s
 }
@@ -92,25 +160,29 @@ func (d *DefaultProvider) IsAvailable() bool {
 func (d *DefaultProvider) Destroy() {}
 
 func (d *DefaultProvider) Call(request motan.Request) (res motan.Response) {
+	if motan.GetStream(request) != nil {
+		return motan.ServeStream(request, d)
+	}
+	d.lock.RLock()
 	m, exit := d.methods[motan.FirstUpper(request.GetMethod())]
+	d.lock.RUnlock()
 	if !exit {
 		vlog.Errorf("method not found in provider. %s", motan.GetReqInfo(request))
 		return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 500, ErrMsg: "method " + request.GetMethod() + " is not found in provider.", ErrType: motan.ServiceException})
 	}
 
-	inNum := m.Type().NumIn()
-	if inNum > 0 {
-		values := make([]interface{}, 0, inNum)
-		for i := 0; i < inNum; i++ {
-			values = append(values, m.Type().In(i))
-		}
+	values, withContext := argumentTypes(m)
+	if len(values) > 0 {
 		err := request.ProcessDeserializable(values)
 		if err != nil {
 			return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 500, ErrMsg: "deserialize arguments fail." + err.Error(), ErrType: motan.ServiceException})
 		}
 	}
 
-	vs := make([]reflect.Value, 0, len(request.GetArguments()))
+	vs := make([]reflect.Value, 0, len(request.GetArguments())+1)
+	if withContext {
+		vs = append(vs, reflect.ValueOf(motan.RequestContext(request)))
+	}
 	for _, arg := range request.GetArguments() {
 		vs = append(vs, reflect.ValueOf(arg))
 	}
@@ -122,6 +194,73 @@ func (d *DefaultProvider) Call(request motan.Request) (res motan.Response) {
 	return mres
 }
 
+// GetArgumentTypes returns the types of the arguments deserialized for the method, the context.Context is not included
+func (d *DefaultProvider) GetArgumentTypes(method string) ([]interface{}, bool) {
+	d.lock.RLock()
+	m, exist := d.methods[motan.FirstUpper(method)]
+	d.lock.RUnlock()
+	if !exist {
+		return nil, false
+	}
+	values, _ := argumentTypes(m)
+	return values, true
+}
+
+// argumentTypes returns the types of the arguments of the method, and whether the context of the request is passed
+// as the first argument of the method which accepts a context.Context
+func argumentTypes(m reflect.Value) ([]interface{}, bool) {
+	inNum := m.Type().NumIn()
+	withContext := inNum > 0 && m.Type().In(0) == contextType
+	first := 0
+	if withContext {
+		first = 1
+	}
+	values := make([]interface{}, 0, inNum-first)
+	for i := first; i < inNum; i++ {
+		values = append(values, m.Type().In(i))
+	}
+	return values, withContext
+}
+
+// CallStream calls the streaming method of the service, the method is a func([context.Context,] motan.Stream, args...) error
+func (d *DefaultProvider) CallStream(request motan.Request, stream motan.Stream) error {
+	d.lock.RLock()
+	m, exist := d.methods[motan.FirstUpper(request.GetMethod())]
+	d.lock.RUnlock()
+	if !exist {
+		return errors.New("method " + request.GetMethod() + " is not found in provider")
+	}
+	t := m.Type()
+	first := 0
+	if t.NumIn() > 0 && t.In(0) == contextType {
+		first = 1
+	}
+	if t.NumIn() <= first || t.In(first) != streamType || t.NumOut() != 1 || t.Out(0) != errorType {
+		return errors.New("method " + request.GetMethod() + " is not a streaming method")
+	}
+	vs := make([]reflect.Value, 0, t.NumIn())
+	if first == 1 {
+		vs = append(vs, reflect.ValueOf(motan.RequestContext(request)))
+	}
+	vs = append(vs, reflect.ValueOf(&stream).Elem())
+	if t.NumIn() > first+1 {
+		values := make([]interface{}, 0, t.NumIn()-first-1)
+		for i := first + 1; i < t.NumIn(); i++ {
+			values = append(values, t.In(i))
+		}
+		if err := request.ProcessDeserializable(values); err != nil {
+			return errors.New("deserialize arguments fail." + err.Error())
+		}
+		for _, arg := range request.GetArguments() {
+			vs = append(vs, reflect.ValueOf(arg))
+		}
+	}
+	if err := m.Call(vs)[0].Interface(); err != nil {
+		return err.(error)
+	}
+	return nil
+}
+
 type MockProvider struct {
 	URL          *motan.URL
 	MockResponse motan.Response
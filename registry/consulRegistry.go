@@ -1,54 +1,617 @@
 package registry
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	URL "net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
 )
 
-// ConsulRegistry TODO implement
+// consul options of the registry url parameters
+const (
+	ConsulTokenKey = "token" // the acl token of the consul api
+	// ConsulTTLKey is the ttl(ms) of the check of the registered services, the check passes every ttl/3 while the url
+	// is available
+	ConsulTTLKey = "ttl"
+	// ConsulDeregisterAfterKey is the time(ms) the services are deregistered by consul after their checks are critical
+	ConsulDeregisterAfterKey = "deregisterAfter"
+	// ConsulWaitKey is the max wait time(ms) of the blocking queries of the subscribed services
+	ConsulWaitKey = "wait"
+)
+
+// consul options of the provider and referer url parameters
+const (
+	// ConsulCheckURLKey is the http url checked by consul for the provider, e.g. http://127.0.0.1:8080/health, the
+	// check is a ttl check kept by the registry if it is absent. the url is in maintenance while it is unavailable
+	ConsulCheckURLKey = "consulCheckURL"
+	// ConsulTagsKey is the extra tags of the provider, or the extra tags required by the referer, separated by ','
+	ConsulTagsKey = "consulTags"
+)
+
+// the tags of the registered services, the referers subscribe the services with the tags of their group and version
+const (
+	ConsulGroupTagPrefix   = "group="
+	ConsulVersionTagPrefix = "version="
+)
+
+const (
+	consulDefaultAddress         = "127.0.0.1:8500"
+	consulDefaultTTL             = 15 * time.Second
+	consulDefaultDeregisterAfter = time.Minute
+	consulDefaultWait            = 55 * time.Second
+	consulRetryInterval          = 3 * time.Second
+	consulExtInfoKey             = "extInfo"
+	consulIndexHeader            = "X-Consul-Index"
+)
+
+// ConsulRegistry is a registry based on the http api of the consul agent, the path of the url is the consul service
+// name, the group and the version of the url are the tags of the service. the services are registered with a ttl
+// check passed by the registry while the url is available, or an http check of ConsulCheckURLKey. the subscribed
+// services are watched by the blocking queries of the passing instances with the tags of the referer
 type ConsulRegistry struct {
-	url           *motan.URL
-	timeout       time.Duration
-	heartInterval time.Duration
+	url                  *motan.URL
+	available            int32
+	address              string
+	token                string
+	timeout              time.Duration
+	heartInterval        time.Duration
+	ttl                  time.Duration
+	deregisterAfter      time.Duration
+	wait                 time.Duration
+	client               *http.Client
+	watchClient          *http.Client
+	registerLock         sync.Mutex
+	subscribeLock        sync.Mutex
+	registeredServiceMap map[string]*motan.URL                          // save all registered services
+	availableServiceMap  map[string]*motan.URL                          // save all available services
+	subscribedServiceMap map[string]map[motan.NotifyListener]*motan.URL // save all subscribed services with listeners
+	lastEntries          map[string][]consulServiceEntry                // save the instances notified last time
+	watcherStops         map[string]chan struct{}                       // save the stop channels of the watchers
+}
+
+type consulCheck struct {
+	CheckID                        string `json:"CheckID,omitempty"`
+	TTL                            string `json:"TTL,omitempty"`
+	HTTP                           string `json:"HTTP,omitempty"`
+	Interval                       string `json:"Interval,omitempty"`
+	Timeout                        string `json:"Timeout,omitempty"`
+	DeregisterCriticalServiceAfter string `json:"DeregisterCriticalServiceAfter,omitempty"`
+}
+
+type consulService struct {
+	ID      string            `json:"ID"`
+	Name    string            `json:"Name"`
+	Tags    []string          `json:"Tags"`
+	Address string            `json:"Address"`
+	Port    int               `json:"Port"`
+	Meta    map[string]string `json:"Meta"`
+	Check   *consulCheck      `json:"Check,omitempty"`
+}
+
+type consulServiceEntry struct {
+	Service struct {
+		ID      string            `json:"ID"`
+		Tags    []string          `json:"Tags"`
+		Address string            `json:"Address"`
+		Port    int               `json:"Port"`
+		Meta    map[string]string `json:"Meta"`
+	} `json:"Service"`
+	Node struct {
+		Address string `json:"Address"`
+	} `json:"Node"`
 }
 
 func (v *ConsulRegistry) GetURL() *motan.URL {
 	return v.url
 }
+
 func (v *ConsulRegistry) SetURL(url *motan.URL) {
 	v.url = url
 }
+
 func (v *ConsulRegistry) GetName() string {
-	return "consulRegistry"
+	return Consul
 }
 
+// Initialize starts the ttl checks of the registered services
 func (v *ConsulRegistry) Initialize() {
+	v.address = consulDefaultAddress
+	if addrs := v.url.GetParam(motan.AddressKey, ""); addrs != "" {
+		v.address = motan.TrimSplit(addrs, ",")[0]
+	} else if len(v.url.Host) > 0 && v.url.Port > 0 {
+		v.address = v.url.GetAddressStr()
+	}
+	if !strings.Contains(v.address, "://") {
+		v.address = "http://" + v.address
+	}
+	v.token = v.url.GetParam(ConsulTokenKey, "")
+	v.timeout = v.url.GetTimeDuration(motan.TimeOutKey, time.Millisecond, DefaultTimeout*time.Millisecond)
+	v.ttl = v.url.GetTimeDuration(ConsulTTLKey, time.Millisecond, consulDefaultTTL)
+	v.heartInterval = v.ttl / 3
+	v.deregisterAfter = v.url.GetTimeDuration(ConsulDeregisterAfterKey, time.Millisecond, consulDefaultDeregisterAfter)
+	v.wait = v.url.GetTimeDuration(ConsulWaitKey, time.Millisecond, consulDefaultWait)
+	v.client = &http.Client{Timeout: v.timeout}
+	// the blocking queries are returned by consul after the wait time
+	v.watchClient = &http.Client{Timeout: v.wait + v.wait/16 + v.timeout}
+	v.registeredServiceMap = make(map[string]*motan.URL)
+	v.availableServiceMap = make(map[string]*motan.URL)
+	v.subscribedServiceMap = make(map[string]map[motan.NotifyListener]*motan.URL)
+	v.lastEntries = make(map[string][]consulServiceEntry)
+	v.watcherStops = make(map[string]chan struct{})
+	v.setAvailable(true)
+	go v.heartbeat()
+}
+
+// Register registers the url with a critical check, the check passes when the url is available
+func (v *ConsulRegistry) Register(url *motan.URL) {
+	if err := v.TryRegister(url); err != nil {
+		vlog.Errorf("[ConsulRegistry] register service error. url:%s, err:%v", url.GetIdentity(), err)
+		v.registerLock.Lock()
+		v.registeredServiceMap[url.GetIdentity()] = url
+		v.registerLock.Unlock()
+	}
+}
+
+// TryRegister registers the url, it fails if the consul is unavailable, so the registration can be retried
+func (v *ConsulRegistry) TryRegister(url *motan.URL) error {
+	v.registerLock.Lock()
+	defer v.registerLock.Unlock()
+	if _, ok := v.registeredServiceMap[url.GetIdentity()]; ok {
+		return nil
+	}
+	if err := v.registerService(url, false); err != nil {
+		return err
+	}
+	vlog.Infof("[ConsulRegistry] register service. url:%s", url.GetIdentity())
+	v.registeredServiceMap[url.GetIdentity()] = url
+	return nil
+}
+
+func (v *ConsulRegistry) registerService(url *motan.URL, available bool) error {
+	service := &consulService{
+		ID:      consulServiceID(url),
+		Name:    url.Path,
+		Tags:    consulTags(url),
+		Address: url.Host,
+		Port:    url.Port,
+		Meta:    map[string]string{consulExtInfoKey: url.ToExtInfo(), motan.NodeTypeKey: url.GetParam(motan.NodeTypeKey, motan.NodeTypeService)},
+		Check:   &consulCheck{DeregisterCriticalServiceAfter: consulDuration(v.deregisterAfter)},
+	}
+	if checkURL := url.GetParam(ConsulCheckURLKey, ""); checkURL != "" {
+		service.Check.HTTP = checkURL
+		service.Check.Interval = consulDuration(v.heartInterval)
+		service.Check.Timeout = consulDuration(v.timeout)
+	} else {
+		service.Check.TTL = consulDuration(v.ttl)
+	}
+	body, _ := json.Marshal(service)
+	if err := v.call(http.MethodPut, "/v1/agent/service/register", nil, body, nil); err != nil {
+		return err
+	}
+	return v.updateService(url, available)
+}
+
+// updateService passes the ttl check or leaves the maintenance of the http check if the url is available
+func (v *ConsulRegistry) updateService(url *motan.URL, available bool) error {
+	id := consulServiceID(url)
+	if url.GetParam(ConsulCheckURLKey, "") != "" {
+		params := URL.Values{}
+		params.Set("enable", strconv.FormatBool(!available))
+		params.Set("reason", "motan service is unavailable")
+		return v.call(http.MethodPut, "/v1/agent/service/maintenance/"+URL.PathEscape(id), params, nil, nil)
+	}
+	status := "pass"
+	if !available {
+		status = "fail"
+	}
+	return v.call(http.MethodPut, "/v1/agent/check/"+status+"/"+URL.PathEscape("service:"+id), nil, nil, nil)
+}
+
+// UnRegister deregisters the service of the url
+func (v *ConsulRegistry) UnRegister(url *motan.URL) {
+	v.registerLock.Lock()
+	defer v.registerLock.Unlock()
+	if _, ok := v.registeredServiceMap[url.GetIdentity()]; !ok {
+		return
+	}
+	vlog.Infof("[ConsulRegistry] unregister service. url:%s", url.GetIdentity())
+	if err := v.call(http.MethodPut, "/v1/agent/service/deregister/"+URL.PathEscape(consulServiceID(url)), nil, nil, nil); err != nil {
+		vlog.Errorf("[ConsulRegistry] unregister service error. url:%s, err:%v", url.GetIdentity(), err)
+	}
+	delete(v.registeredServiceMap, url.GetIdentity())
+	delete(v.availableServiceMap, url.GetIdentity())
+}
+
+// Available passes the checks of the url, all registered services if the url is nil
+func (v *ConsulRegistry) Available(url *motan.URL) {
+	v.setServicesAvailable(url, true)
+}
+
+// Unavailable fails the checks of the url, all registered services if the url is nil
+func (v *ConsulRegistry) Unavailable(url *motan.URL) {
+	v.setServicesAvailable(url, false)
+}
+
+func (v *ConsulRegistry) setServicesAvailable(url *motan.URL, available bool) {
+	v.registerLock.Lock()
+	defer v.registerLock.Unlock()
+	urls := []*motan.URL{url}
+	if url == nil {
+		vlog.Infof("[ConsulRegistry] set all services available:%v, services:%v", available, v.registeredServiceMap)
+		urls = make([]*motan.URL, 0, len(v.registeredServiceMap))
+		for _, u := range v.registeredServiceMap {
+			urls = append(urls, u)
+		}
+	} else {
+		vlog.Infof("[ConsulRegistry] set service available:%v. url:%s", available, url.GetIdentity())
+	}
+	for _, u := range urls {
+		if available {
+			v.availableServiceMap[u.GetIdentity()] = u
+		} else {
+			delete(v.availableServiceMap, u.GetIdentity())
+		}
+		if err := v.updateService(u, available); err != nil {
+			vlog.Errorf("[ConsulRegistry] update service error. url:%s, available:%v, err:%v", u.GetIdentity(), available, err)
+		}
+	}
+}
+
+func (v *ConsulRegistry) GetRegisteredServices() []*motan.URL {
+	v.registerLock.Lock()
+	defer v.registerLock.Unlock()
+	urls := make([]*motan.URL, 0, len(v.registeredServiceMap))
+	for _, u := range v.registeredServiceMap {
+		urls = append(urls, u)
+	}
+	return urls
+}
 
+// heartbeat passes the ttl checks of the available services, the service is registered again if consul has removed it
+func (v *ConsulRegistry) heartbeat() {
+	defer motan.HandlePanic(nil)
+	ticker := time.NewTicker(v.heartInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		v.registerLock.Lock()
+		failed := false
+		for id, u := range v.availableServiceMap {
+			if u.GetParam(ConsulCheckURLKey, "") != "" {
+				continue
+			}
+			err := v.updateService(u, true)
+			if se, ok := err.(*consulStatusError); ok && se.code == http.StatusNotFound {
+				vlog.Warningf("[ConsulRegistry] service is not found, register it again. url:%s", id)
+				err = v.registerService(u, true)
+			}
+			if err != nil {
+				vlog.Errorf("[ConsulRegistry] pass check error. url:%s, err:%v", id, err)
+				failed = true
+			}
+		}
+		v.registerLock.Unlock()
+		v.setAvailable(!failed)
+	}
 }
+
+// Subscribe watches the passing instances of the service with the tags of the referer
 func (v *ConsulRegistry) Subscribe(url *motan.URL, listener motan.NotifyListener) {
+	v.subscribeLock.Lock()
+	defer v.subscribeLock.Unlock()
+	key := consulSubscribeKey(url)
+	if listeners, ok := v.subscribedServiceMap[key]; ok {
+		listeners[listener] = url
+		vlog.Infof("[ConsulRegistry] subscribe service success. service:%s, listener:%s", key, listener.GetIdentity())
+		if entries := v.lastEntries[key]; entries != nil {
+			// the new listener is notified by the instances notified last time
+			go func() {
+				if urls := v.toURLs(url, entries); len(urls) > 0 {
+					listener.Notify(v.url, urls)
+				}
+			}()
+		}
+		return
+	}
+	v.subscribedServiceMap[key] = map[motan.NotifyListener]*motan.URL{listener: url}
+	vlog.Infof("[ConsulRegistry] subscribe service. url:%s, tags:%v", url.GetIdentity(), consulRequiredTags(url))
+	stop := make(chan struct{})
+	v.watcherStops[key] = stop
+	go v.watch(url, key, stop)
+}
 
+// watch queries the service by the blocking queries, the listeners are notified when the index of the service is changed
+func (v *ConsulRegistry) watch(url *motan.URL, key string, stop chan struct{}) {
+	defer motan.HandlePanic(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+	var index uint64
+	for {
+		entries, newIndex, err := v.queryService(ctx, url, index)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			vlog.Errorf("[ConsulRegistry] watch service error. service:%s, err:%v", key, err)
+			index = 0
+			select {
+			case <-stop:
+				return
+			case <-time.After(consulRetryInterval):
+			}
+			continue
+		}
+		// the index is reset if it goes backwards, e.g. the consul is restarted
+		if newIndex < index {
+			newIndex = 0
+		}
+		if newIndex != index {
+			v.update(key, entries)
+		}
+		index = newIndex
+	}
 }
 
-func (v *ConsulRegistry) Unsubscribe(url *motan.URL, listener motan.NotifyListener) {
+func (v *ConsulRegistry) update(key string, entries []consulServiceEntry) {
+	v.subscribeLock.Lock()
+	listeners, ok := v.subscribedServiceMap[key]
+	// the index is changed by the instances of the other tags too
+	if !ok || (v.lastEntries[key] != nil && consulEntriesKey(entries) == consulEntriesKey(v.lastEntries[key])) {
+		v.subscribeLock.Unlock()
+		return
+	}
+	v.lastEntries[key] = entries
+	notifies := make(map[motan.NotifyListener]*motan.URL, len(listeners))
+	for lis, u := range listeners {
+		notifies[lis] = u
+	}
+	v.subscribeLock.Unlock()
+	for lis, u := range notifies {
+		urls := v.toURLs(u, entries)
+		if len(urls) > 0 {
+			lis.Notify(v.url, urls)
+		}
+	}
+	vlog.Infof("[ConsulRegistry] notify nodes. service:%s, size:%d", key, len(entries))
+}
 
+// Unsubscribe removes the listener of the service, the watch is stopped with the last listener
+func (v *ConsulRegistry) Unsubscribe(url *motan.URL, listener motan.NotifyListener) {
+	v.subscribeLock.Lock()
+	defer v.subscribeLock.Unlock()
+	key := consulSubscribeKey(url)
+	if listeners, ok := v.subscribedServiceMap[key]; ok {
+		vlog.Infof("[ConsulRegistry] unsubscribe service. url:%s", url.GetIdentity())
+		delete(listeners, listener)
+		if len(listeners) < 1 {
+			delete(v.subscribedServiceMap, key)
+			delete(v.lastEntries, key)
+			close(v.watcherStops[key])
+			delete(v.watcherStops, key)
+		}
+	}
 }
+
+// Discover returns the passing instances of the service with the tags of the referer
 func (v *ConsulRegistry) Discover(url *motan.URL) []*motan.URL {
-	return nil
+	entries, _, err := v.queryService(context.Background(), url, 0)
+	if err != nil {
+		vlog.Errorf("[ConsulRegistry] discover service error! url:%s, err:%v", url.GetIdentity(), err)
+		return nil
+	}
+	return v.toURLs(url, entries)
 }
-func (v *ConsulRegistry) Register(serverURL *motan.URL) {
 
+// queryService returns the passing instances of the service, it blocks until the index of the service is changed if
+// the index is not 0
+func (v *ConsulRegistry) queryService(ctx context.Context, url *motan.URL, index uint64) ([]consulServiceEntry, uint64, error) {
+	params := URL.Values{}
+	params.Set("passing", "true")
+	for _, tag := range consulRequiredTags(url) {
+		params.Add("tag", tag)
+	}
+	client := v.client
+	if index > 0 {
+		params.Set("index", strconv.FormatUint(index, 10))
+		params.Set("wait", consulDuration(v.wait))
+		client = v.watchClient
+	}
+	var entries []consulServiceEntry
+	header, err := v.do(ctx, client, http.MethodGet, "/v1/health/service/"+URL.PathEscape(url.Path), params, nil, &entries)
+	if err != nil {
+		return nil, 0, err
+	}
+	newIndex, _ := strconv.ParseUint(header.Get(consulIndexHeader), 10, 64)
+	return entries, newIndex, nil
 }
-func (v *ConsulRegistry) UnRegister(serverURL *motan.URL) {
 
+// toURLs returns the urls of the instances having all the required tags of the referer
+func (v *ConsulRegistry) toURLs(url *motan.URL, entries []consulServiceEntry) []*motan.URL {
+	required := consulRequiredTags(url)
+	urls := make([]*motan.URL, 0, len(entries))
+	nodeInfos := make([]SnapshotNodeInfo, 0, len(entries))
+	for _, entry := range entries {
+		if !consulHasTags(entry.Service.Tags, required) {
+			continue
+		}
+		host := entry.Service.Address
+		if host == "" {
+			host = entry.Node.Address
+		}
+		var node *motan.URL
+		extInfo := entry.Service.Meta[consulExtInfoKey]
+		if extInfo != "" {
+			node = motan.FromExtInfo(extInfo)
+		}
+		if node == nil {
+			// the services registered by others
+			node = url.Copy()
+			node.Host = host
+			node.Port = entry.Service.Port
+		}
+		urls = append(urls, node)
+		nodeInfos = append(nodeInfos, SnapshotNodeInfo{Addr: host + ":" + strconv.Itoa(entry.Service.Port), ExtInfo: extInfo})
+	}
+	SaveSnapshot(v.url.GetIdentity(), GetNodeKey(url), ServiceNode{Group: url.Group, Path: url.Path, Nodes: nodeInfos})
+	return urls
 }
-func (v *ConsulRegistry) Available(serverURL *motan.URL) {
 
+// SubscribeCommand is not supported
+func (v *ConsulRegistry) SubscribeCommand(url *motan.URL, listener motan.CommandNotifyListener) {}
+
+func (v *ConsulRegistry) UnSubscribeCommand(url *motan.URL, listener motan.CommandNotifyListener) {}
+
+func (v *ConsulRegistry) DiscoverCommand(url *motan.URL) string {
+	return ""
 }
-func (v *ConsulRegistry) Unavailable(serverURL *motan.URL) {
 
+func (v *ConsulRegistry) IsAvailable() bool {
+	return atomic.LoadInt32(&v.available) == 1
 }
-func (v *ConsulRegistry) GetRegisteredServices() []*motan.URL {
-	return nil
+
+func (v *ConsulRegistry) setAvailable(available bool) {
+	if available {
+		atomic.StoreInt32(&v.available, 1)
+	} else {
+		atomic.StoreInt32(&v.available, 0)
+	}
 }
+
 func (v *ConsulRegistry) StartSnapshot(conf *motan.SnapshotConf) {}
+
+type consulStatusError struct {
+	code    int
+	message string
+}
+
+func (e *consulStatusError) Error() string {
+	return fmt.Sprintf("consul response status %d: %s", e.code, e.message)
+}
+
+func (v *ConsulRegistry) call(method string, api string, params URL.Values, body []byte, response interface{}) error {
+	_, err := v.do(context.Background(), v.client, method, api, params, body, response)
+	return err
+}
+
+func (v *ConsulRegistry) do(ctx context.Context, client *http.Client, method string, api string, params URL.Values, body []byte, response interface{}) (http.Header, error) {
+	target := v.address + api
+	if len(params) > 0 {
+		target += "?" + params.Encode()
+	}
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if v.token != "" {
+		req.Header.Set("X-Consul-Token", v.token)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, &consulStatusError{code: res.StatusCode, message: strings.TrimSpace(string(data))}
+	}
+	if response != nil {
+		if err = json.Unmarshal(data, response); err != nil {
+			return nil, err
+		}
+	}
+	return res.Header, nil
+}
+
+func consulServiceID(url *motan.URL) string {
+	return url.Path + "-" + url.Group + "-" + url.GetAddressStr()
+}
+
+func consulDuration(d time.Duration) string {
+	return strconv.FormatInt(int64(d/time.Millisecond), 10) + "ms"
+}
+
+// consulTags returns the tags of the registered url
+func consulTags(url *motan.URL) []string {
+	tags := []string{ConsulGroupTagPrefix + url.Group}
+	if version := url.GetParam(motan.VersionKey, ""); version != "" {
+		tags = append(tags, ConsulVersionTagPrefix+version)
+	}
+	for _, tag := range motan.TrimSplit(url.GetParam(ConsulTagsKey, ""), ",") {
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// consulRequiredTags returns the tags of the instances subscribed by the referer, the group tag is not required if
+// the group of the referer is empty
+func consulRequiredTags(url *motan.URL) []string {
+	tags := make([]string, 0, 4)
+	if url.Group != "" {
+		tags = append(tags, ConsulGroupTagPrefix+url.Group)
+	}
+	if version := url.GetParam(motan.VersionKey, ""); version != "" {
+		tags = append(tags, ConsulVersionTagPrefix+version)
+	}
+	for _, tag := range motan.TrimSplit(url.GetParam(ConsulTagsKey, ""), ",") {
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func consulSubscribeKey(url *motan.URL) string {
+	return url.Path + "|" + strings.Join(consulRequiredTags(url), ",")
+}
+
+// consulEntriesKey returns the same key for the same instances in any order
+func consulEntriesKey(entries []consulServiceEntry) string {
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		keys = append(keys, entry.Service.ID+"|"+entry.Service.Address+":"+strconv.Itoa(entry.Service.Port)+"|"+entry.Service.Meta[consulExtInfoKey])
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, "\n")
+}
+
+func consulHasTags(tags []string, required []string) bool {
+	for _, r := range required {
+		found := false
+		for _, t := range tags {
+			if t == r {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
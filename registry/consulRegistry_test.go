@@ -0,0 +1,285 @@
+package registry
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/registry/registrytest"
+)
+
+func TestConsulRegistry(t *testing.T) {
+	consul := newFakeConsul()
+	server := httptest.NewServer(consul)
+	defer server.Close()
+	registryURL := &motan.URL{Protocol: Consul, Parameters: map[string]string{motan.AddressKey: strings.TrimPrefix(server.URL, "http://"),
+		ConsulTokenKey: "test-token", ConsulTTLKey: "300", ConsulWaitKey: "1000"}}
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	RegistDefaultRegistry(ext)
+	registry := ext.GetRegistry(registryURL).(*ConsulRegistry)
+	assert.Equal(t, Consul, registry.GetName())
+	assert.True(t, registry.IsAvailable())
+
+	// the ttl check passes while the service is available
+	provider := &motan.URL{Protocol: "motan2", Group: "g1", Path: "com.weibo.HelloService", Host: "10.0.0.1", Port: 8002,
+		Parameters: map[string]string{motan.VersionKey: "1.0"}}
+	registry.Register(provider)
+	id := consulServiceID(provider)
+	service := consul.getService(id)
+	if assert.NotNil(t, service) {
+		assert.Equal(t, "com.weibo.HelloService", service.Name)
+		assert.Equal(t, []string{"group=g1", "version=1.0"}, service.Tags)
+		assert.Equal(t, "300ms", service.Check.TTL)
+		assert.Equal(t, "60000ms", service.Check.DeregisterCriticalServiceAfter)
+	}
+	assert.Equal(t, "test-token", consul.lastToken())
+	assert.False(t, consul.isPassing(id))
+	registry.Available(nil)
+	assert.True(t, consul.isPassing(id))
+	passes := consul.passCount(id)
+	time.Sleep(400 * time.Millisecond)
+	assert.True(t, consul.passCount(id) > passes)
+	registry.Unavailable(provider)
+	assert.False(t, consul.isPassing(id))
+	passes = consul.passCount(id)
+	time.Sleep(400 * time.Millisecond)
+	assert.Equal(t, passes, consul.passCount(id))
+	registry.Available(provider)
+	assert.Equal(t, 1, len(registry.GetRegisteredServices()))
+
+	// the http check is in maintenance while the service is unavailable
+	httpProvider := &motan.URL{Protocol: "motan2", Group: "g1", Path: "com.weibo.HelloService", Host: "10.0.0.2", Port: 8002,
+		Parameters: map[string]string{motan.VersionKey: "1.0", ConsulCheckURLKey: "http://10.0.0.2:8080/health", ConsulTagsKey: "idc-a"}}
+	registry.Register(httpProvider)
+	httpID := consulServiceID(httpProvider)
+	if service = consul.getService(httpID); assert.NotNil(t, service) {
+		assert.Equal(t, "http://10.0.0.2:8080/health", service.Check.HTTP)
+		assert.Equal(t, "", service.Check.TTL)
+		assert.Equal(t, []string{"group=g1", "version=1.0", "idc-a"}, service.Tags)
+	}
+	assert.False(t, consul.isPassing(httpID))
+	registry.Available(httpProvider)
+	assert.True(t, consul.isPassing(httpID))
+	other := &motan.URL{Protocol: "motan2", Group: "g2", Path: "com.weibo.HelloService", Host: "10.0.0.3", Port: 8002,
+		Parameters: map[string]string{motan.VersionKey: "1.0"}}
+	registry.Register(other)
+	registry.Available(other)
+
+	// the referers receive the passing instances with their tags only
+	referer := &motan.URL{Protocol: "motan2", Group: "g1", Path: "com.weibo.HelloService", Parameters: map[string]string{motan.VersionKey: "1.0"}}
+	urls := registry.Discover(referer)
+	assert.Equal(t, 2, len(urls))
+	for _, u := range urls {
+		assert.Equal(t, "g1", u.Group)
+	}
+	assert.Equal(t, 1, len(registry.Discover(&motan.URL{Group: "g1", Path: "com.weibo.HelloService", Parameters: map[string]string{ConsulTagsKey: "idc-a"}})))
+	assert.Equal(t, 0, len(registry.Discover(&motan.URL{Group: "g1", Path: "com.weibo.HelloService", Parameters: map[string]string{motan.VersionKey: "2.0"}})))
+	assert.Equal(t, 3, len(registry.Discover(&motan.URL{Path: "com.weibo.HelloService", Parameters: map[string]string{}})))
+
+	listener := &etcdTestListener{}
+	registry.Subscribe(referer, listener)
+	assert.True(t, listener.waitNotified(1))
+	assert.Equal(t, 2, len(listener.getURLs()))
+	registry.Unavailable(httpProvider)
+	assert.True(t, listener.waitNotified(2))
+	if assert.Equal(t, 1, len(listener.getURLs())) {
+		assert.Equal(t, "10.0.0.1:8002", listener.getURLs()[0].GetAddressStr())
+	}
+	// the changes of the other groups are not notified
+	registry.Unavailable(other)
+	assert.False(t, listener.waitNotified(3))
+
+	registry.Unsubscribe(referer, listener)
+	registry.UnRegister(provider)
+	assert.Nil(t, consul.getService(id))
+	assert.Equal(t, 2, len(registry.GetRegisteredServices()))
+	registry.Available(httpProvider)
+	assert.False(t, listener.waitNotified(3))
+
+	// the services removed by consul are registered by the heartbeat again
+	consul.remove(id)
+	registry.Register(provider)
+	registry.Available(provider)
+	consul.remove(id)
+	for i := 0; i < 50 && consul.getService(id) == nil; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.NotNil(t, consul.getService(id))
+}
+
+func TestConsulRegistryConformance(t *testing.T) {
+	server := httptest.NewServer(newFakeConsul())
+	defer server.Close()
+	registryURL := &motan.URL{Protocol: Consul, Parameters: map[string]string{motan.AddressKey: strings.TrimPrefix(server.URL, "http://"),
+		ConsulTTLKey: "300", ConsulWaitKey: "1000"}}
+	registrytest.Run(t, func() motan.Registry {
+		return newTestRegistry(registryURL)
+	})
+}
+
+func TestConsulTags(t *testing.T) {
+	url := &motan.URL{Group: "g1", Parameters: map[string]string{motan.VersionKey: "1.0", ConsulTagsKey: "a, b"}}
+	assert.Equal(t, []string{"group=g1", "version=1.0", "a", "b"}, consulTags(url))
+	assert.Equal(t, []string{"group=g1", "version=1.0", "a", "b"}, consulRequiredTags(url))
+	assert.Equal(t, []string{"group="}, consulTags(&motan.URL{Parameters: map[string]string{}}))
+	assert.Equal(t, []string{}, consulRequiredTags(&motan.URL{Parameters: map[string]string{}}))
+	assert.True(t, consulHasTags([]string{"group=g1", "version=1.0", "a"}, []string{"a", "group=g1"}))
+	assert.False(t, consulHasTags([]string{"group=g1"}, []string{"group=g1", "version=1.0"}))
+}
+
+type fakeConsulInstance struct {
+	service *consulService
+	passing bool
+	passes  int
+}
+
+// fakeConsul serves the agent apis and the blocking health queries of consul
+type fakeConsul struct {
+	lock      sync.Mutex
+	instances map[string]*fakeConsulInstance
+	index     uint64
+	changed   chan struct{}
+	token     string
+}
+
+func newFakeConsul() *fakeConsul {
+	return &fakeConsul{instances: make(map[string]*fakeConsulInstance), index: 1, changed: make(chan struct{})}
+}
+
+// change must be called with the lock
+func (f *fakeConsul) change() {
+	f.index++
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeConsul) getService(id string) *consulService {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if instance, ok := f.instances[id]; ok {
+		return instance.service
+	}
+	return nil
+}
+
+func (f *fakeConsul) isPassing(id string) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	instance, ok := f.instances[id]
+	return ok && instance.passing
+}
+
+func (f *fakeConsul) passCount(id string) int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if instance, ok := f.instances[id]; ok {
+		return instance.passes
+	}
+	return 0
+}
+
+func (f *fakeConsul) lastToken() string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.token
+}
+
+func (f *fakeConsul) remove(id string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.instances, id)
+	f.change()
+}
+
+func (f *fakeConsul) setPassing(id string, passing bool, pass bool) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	instance, ok := f.instances[id]
+	if !ok {
+		return false
+	}
+	if pass {
+		instance.passes++
+	}
+	if instance.passing != passing {
+		instance.passing = passing
+		f.change()
+	}
+	return true
+}
+
+func (f *fakeConsul) query(w http.ResponseWriter, r *http.Request, name string) {
+	query := r.URL.Query()
+	index, _ := strconv.ParseUint(query.Get("index"), 10, 64)
+	f.lock.Lock()
+	if index > 0 && index == f.index {
+		changed := f.changed
+		f.lock.Unlock()
+		wait, _ := time.ParseDuration(query.Get("wait"))
+		select {
+		case <-changed:
+		case <-time.After(wait):
+		case <-r.Context().Done():
+			return
+		}
+		f.lock.Lock()
+	}
+	entries := make([]map[string]interface{}, 0)
+	for _, instance := range f.instances {
+		if instance.service.Name != name || (query.Get("passing") == "true" && !instance.passing) ||
+			!consulHasTags(instance.service.Tags, query["tag"]) {
+			continue
+		}
+		entries = append(entries, map[string]interface{}{"Node": map[string]string{"Address": "127.0.0.1"}, "Service": instance.service})
+	}
+	w.Header().Set(consulIndexHeader, strconv.FormatUint(f.index, 10))
+	f.lock.Unlock()
+	b, _ := json.Marshal(entries)
+	w.Write(b)
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	f.token = r.Header.Get("X-Consul-Token")
+	f.lock.Unlock()
+	path := r.URL.Path
+	found := true
+	switch {
+	case r.Method == http.MethodPut && path == "/v1/agent/service/register":
+		service := &consulService{}
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, service); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.lock.Lock()
+		f.instances[service.ID] = &fakeConsulInstance{service: service}
+		f.change()
+		f.lock.Unlock()
+	case r.Method == http.MethodPut && strings.HasPrefix(path, "/v1/agent/service/deregister/"):
+		f.remove(strings.TrimPrefix(path, "/v1/agent/service/deregister/"))
+	case r.Method == http.MethodPut && strings.HasPrefix(path, "/v1/agent/service/maintenance/"):
+		found = f.setPassing(strings.TrimPrefix(path, "/v1/agent/service/maintenance/"), r.URL.Query().Get("enable") != "true", false)
+	case r.Method == http.MethodPut && strings.HasPrefix(path, "/v1/agent/check/pass/service:"):
+		found = f.setPassing(strings.TrimPrefix(path, "/v1/agent/check/pass/service:"), true, true)
+	case r.Method == http.MethodPut && strings.HasPrefix(path, "/v1/agent/check/fail/service:"):
+		found = f.setPassing(strings.TrimPrefix(path, "/v1/agent/check/fail/service:"), false, false)
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/v1/health/service/"):
+		f.query(w, r, strings.TrimPrefix(path, "/v1/health/service/"))
+	default:
+		found = false
+	}
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("not found"))
+	}
+}
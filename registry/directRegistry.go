@@ -40,6 +40,7 @@ func (d *DirectRegistry) Discover(url *motan.URL) []*motan.URL {
 		newURL := *url
 		newURL.Host = u.Host
 		newURL.Port = u.Port
+		newURL.ClearCachedInfo()
 		result = append(result, &newURL)
 	}
 	return result
@@ -66,6 +67,11 @@ func parseURLs(url *motan.URL) []*motan.URL {
 		urls = append(urls, url)
 	} else if address, exist := url.Parameters[motan.AddressKey]; exist {
 		for _, add := range strings.Split(address, ",") {
+			// e.g. unix:///var/run/motan-agent.sock
+			if add = strings.TrimSpace(add); strings.HasPrefix(add, motan.UnixAddressPrefix) {
+				urls = append(urls, &motan.URL{Host: add})
+				continue
+			}
 			hostport := motan.TrimSplit(add, ":")
 			if len(hostport) == 2 {
 				port, err := strconv.Atoi(hostport[1])
@@ -66,3 +66,18 @@ func TestMultiAddress(t *testing.T) {
 		}
 	}
 }
+
+func TestUnixAddress(t *testing.T) {
+	regURL := &motan.URL{Parameters: map[string]string{"address": "unix:///var/run/motan-agent.sock, 127.0.0.1:8002"}}
+	registry := &DirectRegistry{url: regURL}
+	urls := registry.Discover(&motan.URL{Protocol: "motan2", Path: "test", Parameters: map[string]string{}})
+	if len(urls) != 2 {
+		t.Fatalf("discover not correct. urls: %+v", urls)
+	}
+	if sock, ok := urls[0].GetUnixSock(); !ok || sock != "/var/run/motan-agent.sock" || urls[0].GetAddressStr() != "unix:///var/run/motan-agent.sock" {
+		t.Fatalf("discover unix address not correct. url: %+v", urls[0])
+	}
+	if _, ok := urls[1].GetUnixSock(); ok || urls[1].GetAddressStr() != "127.0.0.1:8002" {
+		t.Fatalf("discover tcp address not correct. url: %+v", urls[1])
+	}
+}
@@ -0,0 +1,643 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/weibocom/motan-go/cluster"
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+const (
+	etcdDefaultLeaseTTL = 10 // Second
+
+	// etcd v3 json gateway apis
+	etcdLeaseGrantAPI     = "/v3/lease/grant"
+	etcdLeaseKeepAliveAPI = "/v3/lease/keepalive"
+	etcdPutAPI            = "/v3/kv/put"
+	etcdRangeAPI          = "/v3/kv/range"
+	etcdDeleteRangeAPI    = "/v3/kv/deleterange"
+	etcdWatchAPI          = "/v3/watch"
+)
+
+// the watch is created again after etcdWatchRetryInterval if it is broken
+var etcdWatchRetryInterval = time.Second
+
+// EtcdRegistry is a registry based on the etcd v3 json gateway, the nodes are kept in the same layout as the zookeeper
+// registry, e.g. /motan/<group>/<path>/server/<host:port> with the ext info of the url as the value. all nodes are
+// attached to a lease of registrySessionTimeout seconds which is kept alive by the registry, so the nodes are removed by
+// etcd if the process is gone. the nodes are put again with a new lease if the lease is expired
+type EtcdRegistry struct {
+	url                  *motan.URL
+	available            int32
+	addrs                []string
+	addrIndex            uint32
+	ttl                  int64
+	leaseID              int64
+	client               *http.Client // the client of the unary apis
+	watchClient          *http.Client // the client of the watch streams, it has no timeout
+	registerLock         sync.Mutex
+	subscribeLock        sync.Mutex
+	registeredServiceMap map[string]*motan.URL                                 // save all registered services
+	availableServiceMap  map[string]*motan.URL                                 // save all available services
+	clientNodeMap        map[string]*motan.URL                                 // save all client nodes of the subscriptions
+	subscribedServiceMap map[string]map[motan.NotifyListener]*motan.URL        // save all subscribed services with listeners
+	subscribedCommandMap map[string]map[motan.CommandNotifyListener]*motan.URL // save all subscribed commands with listeners
+	watchCancels         map[string]context.CancelFunc                         // save the cancel funcs of the watches
+}
+
+type etcdKeyValue struct {
+	Key   []byte `json:"key,omitempty"`
+	Value []byte `json:"value,omitempty"`
+}
+
+type etcdLeaseResponse struct {
+	ID  int64 `json:"ID,string"`
+	TTL int64 `json:"TTL,string"`
+}
+
+type etcdKeepAliveResponse struct {
+	Result etcdLeaseResponse `json:"result"`
+}
+
+type etcdPutRequest struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+	Lease int64  `json:"lease,string,omitempty"`
+}
+
+type etcdRangeRequest struct {
+	Key      []byte `json:"key"`
+	RangeEnd []byte `json:"range_end,omitempty"`
+}
+
+type etcdRangeResponse struct {
+	Kvs []etcdKeyValue `json:"kvs"`
+}
+
+type etcdWatchResponse struct {
+	Result struct {
+		Events   []json.RawMessage `json:"events"`
+		Canceled bool              `json:"canceled"`
+	} `json:"result"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Initialize grants the lease and starts to keep it alive, the lease is granted again by the keepalive loop if the etcd
+// is unavailable now
+func (e *EtcdRegistry) Initialize() {
+	e.ttl = e.url.GetPositiveIntValue(motan.SessionTimeOutKey, etcdDefaultLeaseTTL)
+	e.registeredServiceMap = make(map[string]*motan.URL)
+	e.availableServiceMap = make(map[string]*motan.URL)
+	e.clientNodeMap = make(map[string]*motan.URL)
+	e.subscribedServiceMap = make(map[string]map[motan.NotifyListener]*motan.URL)
+	e.subscribedCommandMap = make(map[string]map[motan.CommandNotifyListener]*motan.URL)
+	e.watchCancels = make(map[string]context.CancelFunc)
+	if len(e.url.Host) > 0 && e.url.Port > 0 {
+		e.addrs = append(e.addrs, e.url.GetAddressStr())
+	} else if addrString, exist := e.url.Parameters[motan.AddressKey]; exist {
+		e.addrs = motan.TrimSplit(addrString, ",")
+	}
+	e.client = &http.Client{Timeout: e.url.GetTimeDuration(motan.TimeOutKey, time.Millisecond, DefaultTimeout*time.Millisecond)}
+	e.watchClient = &http.Client{}
+	if err := e.grantLease(); err != nil {
+		vlog.Errorf("[EtcdRegistry] grant lease error. err:%v", err)
+	}
+	go e.keepAlive()
+}
+
+// keepAlive keeps the lease alive every third of the ttl, a new lease is granted and all nodes are put again if the
+// lease is expired or not granted yet
+func (e *EtcdRegistry) keepAlive() {
+	defer motan.HandlePanic(nil)
+	interval := time.Duration(e.ttl) * time.Second / 3
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		leaseID := atomic.LoadInt64(&e.leaseID)
+		if leaseID != 0 {
+			res := &etcdKeepAliveResponse{}
+			err := e.call(etcdLeaseKeepAliveAPI, &etcdLeaseResponse{ID: leaseID}, res)
+			if err == nil && res.Result.TTL > 0 {
+				e.setAvailable(true)
+				continue
+			}
+			if err != nil {
+				vlog.Errorf("[EtcdRegistry] keep alive lease error. lease:%d, err:%v", leaseID, err)
+				e.setAvailable(false)
+				continue
+			}
+			vlog.Warningf("[EtcdRegistry] lease %d is expired, grant a new lease", leaseID)
+		}
+		if err := e.grantLease(); err != nil {
+			vlog.Errorf("[EtcdRegistry] grant lease error. err:%v", err)
+			continue
+		}
+		e.recoverService()
+	}
+}
+
+func (e *EtcdRegistry) grantLease() error {
+	res := &etcdLeaseResponse{}
+	if err := e.call(etcdLeaseGrantAPI, &etcdLeaseResponse{TTL: e.ttl}, res); err != nil {
+		e.setAvailable(false)
+		return err
+	}
+	if res.ID == 0 {
+		e.setAvailable(false)
+		return errors.New("no lease id granted")
+	}
+	atomic.StoreInt64(&e.leaseID, res.ID)
+	e.setAvailable(true)
+	vlog.Infof("[EtcdRegistry] lease granted. lease:%d, ttl:%d", res.ID, res.TTL)
+	return nil
+}
+
+// recoverService puts the nodes of the registered, available services and the subscriptions with the new lease
+func (e *EtcdRegistry) recoverService() {
+	e.registerLock.Lock()
+	defer e.registerLock.Unlock()
+	for _, url := range e.registeredServiceMap {
+		e.doRegister(url)
+	}
+	for _, url := range e.availableServiceMap {
+		e.doAvailable(url)
+	}
+	for _, url := range e.clientNodeMap {
+		e.putNode(toNodePath(url, zkNodeTypeClient), url)
+	}
+	vlog.Infof("[EtcdRegistry] recover services success. registered:%d, available:%d", len(e.registeredServiceMap), len(e.availableServiceMap))
+}
+
+// Register puts the unavailableServer node of the url, the url is registered when the lease is granted if the etcd
+// is unavailable now
+func (e *EtcdRegistry) Register(url *motan.URL) {
+	e.registerLock.Lock()
+	defer e.registerLock.Unlock()
+	if _, ok := e.registeredServiceMap[url.GetIdentity()]; !ok {
+		vlog.Infof("[EtcdRegistry] register service. url:%s", url.GetIdentity())
+		e.registeredServiceMap[url.GetIdentity()] = url
+		if e.IsAvailable() {
+			e.doRegister(url)
+		}
+	}
+}
+
+// TryRegister registers the url, it fails if the etcd is unavailable, so the registration can be retried
+func (e *EtcdRegistry) TryRegister(url *motan.URL) error {
+	if !e.IsAvailable() {
+		return errors.New("etcd registry is unavailable: " + e.url.GetIdentity())
+	}
+	e.Register(url)
+	return nil
+}
+
+func (e *EtcdRegistry) doRegister(url *motan.URL) {
+	if url.Group == "" || url.Path == "" || url.Host == "" {
+		vlog.Errorf("[EtcdRegistry] register service fail. invalid url:%s", url.GetIdentity())
+	}
+	if IsAgent(url) {
+		e.putNode(toAgentNodePath(url), url)
+	} else {
+		e.deleteNode(toNodePath(url, zkNodeTypeServer))
+		e.putNode(toNodePath(url, zkNodeTypeUnavailableServer), url)
+	}
+}
+
+// UnRegister removes the server node and unavailableServer node of the url
+func (e *EtcdRegistry) UnRegister(url *motan.URL) {
+	e.registerLock.Lock()
+	defer e.registerLock.Unlock()
+	if _, ok := e.registeredServiceMap[url.GetIdentity()]; ok {
+		vlog.Infof("[EtcdRegistry] unregister service. url:%s", url.GetIdentity())
+		if IsAgent(url) {
+			e.deleteNode(toAgentNodePath(url))
+		} else {
+			e.deleteNode(toNodePath(url, zkNodeTypeServer))
+			e.deleteNode(toNodePath(url, zkNodeTypeUnavailableServer))
+		}
+		delete(e.registeredServiceMap, url.GetIdentity())
+		delete(e.availableServiceMap, url.GetIdentity())
+	}
+}
+
+// Available moves the unavailableServer node to the server node, all registered services if the url is nil
+func (e *EtcdRegistry) Available(url *motan.URL) {
+	e.registerLock.Lock()
+	defer e.registerLock.Unlock()
+	if url == nil {
+		vlog.Infof("[EtcdRegistry] available all services:%v", e.registeredServiceMap)
+		for _, u := range e.registeredServiceMap {
+			e.availableServiceMap[u.GetIdentity()] = u
+			e.doAvailable(u)
+		}
+		return
+	}
+	vlog.Infof("[EtcdRegistry] available service:%s", url.GetIdentity())
+	e.availableServiceMap[url.GetIdentity()] = url
+	e.doAvailable(url)
+}
+
+func (e *EtcdRegistry) doAvailable(url *motan.URL) {
+	if IsAgent(url) || !e.IsAvailable() {
+		return
+	}
+	e.deleteNode(toNodePath(url, zkNodeTypeUnavailableServer))
+	e.putNode(toNodePath(url, zkNodeTypeServer), url)
+}
+
+// Unavailable moves the server node to the unavailableServer node, all registered services if the url is nil
+func (e *EtcdRegistry) Unavailable(url *motan.URL) {
+	e.registerLock.Lock()
+	defer e.registerLock.Unlock()
+	if url == nil {
+		vlog.Infof("[EtcdRegistry] unavailable all services:%v", e.registeredServiceMap)
+		for _, u := range e.registeredServiceMap {
+			delete(e.availableServiceMap, u.GetIdentity())
+			e.doUnavailable(u)
+		}
+		return
+	}
+	vlog.Infof("[EtcdRegistry] unavailable service. url:%s", url.GetIdentity())
+	delete(e.availableServiceMap, url.GetIdentity())
+	e.doUnavailable(url)
+}
+
+func (e *EtcdRegistry) doUnavailable(url *motan.URL) {
+	if IsAgent(url) || !e.IsAvailable() {
+		return
+	}
+	e.deleteNode(toNodePath(url, zkNodeTypeServer))
+	e.putNode(toNodePath(url, zkNodeTypeUnavailableServer), url)
+}
+
+// GetRegisteredServices returns all registered services
+func (e *EtcdRegistry) GetRegisteredServices() []*motan.URL {
+	e.registerLock.Lock()
+	defer e.registerLock.Unlock()
+	urls := make([]*motan.URL, 0, len(e.registeredServiceMap))
+	for _, u := range e.registeredServiceMap {
+		urls = append(urls, u)
+	}
+	return urls
+}
+
+// Subscribe watches the server nodes of the service, the listeners are notified with all server nodes when they are changed
+func (e *EtcdRegistry) Subscribe(url *motan.URL, listener motan.NotifyListener) {
+	e.subscribeLock.Lock()
+	defer e.subscribeLock.Unlock()
+	servicePath := toNodeTypePath(url, zkNodeTypeServer)
+	if listeners, ok := e.subscribedServiceMap[servicePath]; ok {
+		listeners[listener] = url
+		vlog.Infof("[EtcdRegistry] subscribe service success. path:%s, listener:%s", servicePath, listener.GetIdentity())
+		return
+	}
+	e.subscribedServiceMap[servicePath] = map[motan.NotifyListener]*motan.URL{listener: url}
+	vlog.Infof("[EtcdRegistry] subscribe service. url:%s", url.GetIdentity())
+	url.PutParam(motan.NodeTypeKey, motan.NodeTypeReferer) // all subscribe url must as referer
+	if url.Host == "" {
+		url.Host = motan.GetLocalIP()
+	}
+	// register as rpc client
+	e.registerLock.Lock()
+	e.clientNodeMap[servicePath] = url
+	if e.IsAvailable() {
+		e.putNode(toNodePath(url, zkNodeTypeClient), url)
+	}
+	e.registerLock.Unlock()
+	prefix := servicePath + zkPathSeparator
+	e.watch(servicePath, []byte(prefix), etcdPrefixEnd(prefix), func() {
+		urls := e.discover(url)
+		e.subscribeLock.Lock()
+		listeners := make([]motan.NotifyListener, 0, len(e.subscribedServiceMap[servicePath]))
+		for lis := range e.subscribedServiceMap[servicePath] {
+			listeners = append(listeners, lis)
+		}
+		e.subscribeLock.Unlock()
+		if len(urls) > 0 {
+			for _, lis := range listeners {
+				lis.Notify(e.url, urls)
+			}
+			vlog.Infof("[EtcdRegistry] notify nodes. path:%s, size:%d", servicePath, len(urls))
+		}
+	})
+}
+
+// Unsubscribe removes the listener of the service, the watch and the client node are removed with the last listener
+func (e *EtcdRegistry) Unsubscribe(url *motan.URL, listener motan.NotifyListener) {
+	e.subscribeLock.Lock()
+	defer e.subscribeLock.Unlock()
+	servicePath := toNodeTypePath(url, zkNodeTypeServer)
+	listeners, ok := e.subscribedServiceMap[servicePath]
+	if !ok {
+		return
+	}
+	vlog.Infof("[EtcdRegistry] unsubscribe service. url:%s", url.GetIdentity())
+	delete(listeners, listener)
+	if len(listeners) > 0 {
+		return
+	}
+	delete(e.subscribedServiceMap, servicePath)
+	e.stopWatch(servicePath)
+	e.registerLock.Lock()
+	if clientURL, ok := e.clientNodeMap[servicePath]; ok {
+		delete(e.clientNodeMap, servicePath)
+		e.deleteNode(toNodePath(clientURL, zkNodeTypeClient))
+	}
+	e.registerLock.Unlock()
+}
+
+// Discover returns all server nodes of the service
+func (e *EtcdRegistry) Discover(url *motan.URL) []*motan.URL {
+	if !e.IsAvailable() {
+		return nil
+	}
+	return e.discover(url)
+}
+
+func (e *EtcdRegistry) discover(url *motan.URL) []*motan.URL {
+	prefix := toNodeTypePath(url, zkNodeTypeServer) + zkPathSeparator
+	kvs, err := e.rangeKeys([]byte(prefix), etcdPrefixEnd(prefix))
+	if err != nil {
+		vlog.Errorf("[EtcdRegistry] discover service error! url:%s, err:%v", url.GetIdentity(), err)
+		return nil
+	}
+	urls := make([]*motan.URL, 0, len(kvs))
+	nodeInfos := make([]SnapshotNodeInfo, 0, len(kvs))
+	for _, kv := range kvs {
+		extInfo := string(kv.Value)
+		node := motan.FromExtInfo(extInfo)
+		if node == nil {
+			vlog.Warningf("[EtcdRegistry] illegal node value. key:%s, value:%s", kv.Key, extInfo)
+			continue
+		}
+		urls = append(urls, node)
+		nodeInfos = append(nodeInfos, SnapshotNodeInfo{Addr: strings.TrimPrefix(string(kv.Key), prefix), ExtInfo: extInfo})
+	}
+	SaveSnapshot(e.url.GetIdentity(), GetNodeKey(url), ServiceNode{Group: url.Group, Path: url.Path, Nodes: nodeInfos})
+	return urls
+}
+
+// SubscribeCommand watches the command node, the listeners are notified with the command when it is changed
+func (e *EtcdRegistry) SubscribeCommand(url *motan.URL, listener motan.CommandNotifyListener) {
+	e.subscribeLock.Lock()
+	defer e.subscribeLock.Unlock()
+	commandPath := etcdCommandPath(url)
+	if listeners, ok := e.subscribedCommandMap[commandPath]; ok {
+		vlog.Infof("[EtcdRegistry] subscribe command success. path:%s, listener:%s", commandPath, listener.GetIdentity())
+		listeners[listener] = url
+		return
+	}
+	e.subscribedCommandMap[commandPath] = map[motan.CommandNotifyListener]*motan.URL{listener: url}
+	vlog.Infof("[EtcdRegistry] subscribe command success. path:%s, url:%s", commandPath, url.GetIdentity())
+	e.watch(commandPath, []byte(commandPath), nil, func() {
+		cmdInfo := e.discoverCommand(commandPath)
+		if cmdInfo == "" {
+			return
+		}
+		e.subscribeLock.Lock()
+		listeners := make(map[motan.CommandNotifyListener]*motan.URL, len(e.subscribedCommandMap[commandPath]))
+		for lis, u := range e.subscribedCommandMap[commandPath] {
+			listeners[lis] = u
+		}
+		e.subscribeLock.Unlock()
+		for lis, u := range listeners {
+			lis.NotifyCommand(u, cluster.ServiceCmd, cmdInfo)
+		}
+		vlog.Infof("[EtcdRegistry] command changed, path:%s, cmdInfo:%s", commandPath, cmdInfo)
+	})
+}
+
+// UnSubscribeCommand removes the listener of the command
+func (e *EtcdRegistry) UnSubscribeCommand(url *motan.URL, listener motan.CommandNotifyListener) {
+	e.subscribeLock.Lock()
+	defer e.subscribeLock.Unlock()
+	commandPath := etcdCommandPath(url)
+	if listeners, ok := e.subscribedCommandMap[commandPath]; ok {
+		vlog.Infof("[EtcdRegistry] unsubscribe command. url:%s", url.GetIdentity())
+		delete(listeners, listener)
+		if len(listeners) < 1 {
+			delete(e.subscribedCommandMap, commandPath)
+			e.stopWatch(commandPath)
+		}
+	}
+}
+
+// DiscoverCommand returns the command of the command node
+func (e *EtcdRegistry) DiscoverCommand(url *motan.URL) string {
+	if !e.IsAvailable() {
+		return ""
+	}
+	return e.discoverCommand(etcdCommandPath(url))
+}
+
+func (e *EtcdRegistry) discoverCommand(commandPath string) string {
+	kvs, err := e.rangeKeys([]byte(commandPath), nil)
+	if err != nil {
+		vlog.Errorf("[EtcdRegistry] discover command error. path:%s, err:%v", commandPath, err)
+		return ""
+	}
+	if len(kvs) == 0 {
+		return ""
+	}
+	return string(kvs[0].Value)
+}
+
+func (e *EtcdRegistry) GetURL() *motan.URL {
+	return e.url
+}
+
+func (e *EtcdRegistry) SetURL(url *motan.URL) {
+	e.url = url
+}
+
+func (e *EtcdRegistry) GetName() string {
+	return Etcd
+}
+
+func (e *EtcdRegistry) IsAvailable() bool {
+	return atomic.LoadInt32(&e.available) == 1
+}
+
+func (e *EtcdRegistry) setAvailable(available bool) {
+	if available {
+		atomic.StoreInt32(&e.available, 1)
+	} else {
+		atomic.StoreInt32(&e.available, 0)
+	}
+}
+
+func (e *EtcdRegistry) StartSnapshot(conf *motan.SnapshotConf) {}
+
+// watch calls onChange when the keys in [key, rangeEnd) are changed until the watch is stopped, the watch is created
+// again if it is broken, and onChange is called after it is created again because the changes may be missed.
+// it should be called with the subscribeLock held
+func (e *EtcdRegistry) watch(name string, key []byte, rangeEnd []byte, onChange func()) {
+	if _, ok := e.watchCancels[name]; ok {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	e.watchCancels[name] = cancel
+	vlog.Infof("[EtcdRegistry] start watch. path:%s", name)
+	go func() {
+		defer motan.HandlePanic(nil)
+		for retry := false; ; retry = true {
+			if retry {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(etcdWatchRetryInterval):
+				}
+			}
+			err := e.doWatch(ctx, key, rangeEnd, retry, onChange)
+			if ctx.Err() != nil {
+				vlog.Infof("[EtcdRegistry] stop watch. path:%s", name)
+				return
+			}
+			vlog.Warningf("[EtcdRegistry] watch is broken, retry after %v. path:%s, err:%v", etcdWatchRetryInterval, name, err)
+		}
+	}()
+}
+
+func (e *EtcdRegistry) doWatch(ctx context.Context, key []byte, rangeEnd []byte, retry bool, onChange func()) error {
+	body, _ := json.Marshal(map[string]interface{}{"create_request": &etcdRangeRequest{Key: key, RangeEnd: rangeEnd}})
+	req, err := http.NewRequest(http.MethodPost, e.endpoint()+etcdWatchAPI, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := e.watchClient.Do(req.WithContext(ctx))
+	if err != nil {
+		e.nextEndpoint()
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return etcdResponseError(res)
+	}
+	decoder := json.NewDecoder(res.Body)
+	for created := false; ; {
+		watchRes := &etcdWatchResponse{}
+		if err = decoder.Decode(watchRes); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		if watchRes.Error != nil {
+			return errors.New(watchRes.Error.Message)
+		}
+		if watchRes.Result.Canceled {
+			return errors.New("watch is canceled by the server")
+		}
+		if len(watchRes.Result.Events) > 0 || (!created && retry) {
+			onChange()
+		}
+		created = true
+	}
+}
+
+// stopWatch should be called with the subscribeLock held
+func (e *EtcdRegistry) stopWatch(name string) {
+	if cancel, ok := e.watchCancels[name]; ok {
+		cancel()
+		delete(e.watchCancels, name)
+	}
+}
+
+func (e *EtcdRegistry) putNode(key string, url *motan.URL) {
+	req := &etcdPutRequest{Key: []byte(key), Value: []byte(url.ToExtInfo()), Lease: atomic.LoadInt64(&e.leaseID)}
+	if err := e.call(etcdPutAPI, req, nil); err != nil {
+		vlog.Errorf("[EtcdRegistry] put node error. path:%s, err:%v", key, err)
+	}
+}
+
+func (e *EtcdRegistry) deleteNode(key string) {
+	if err := e.call(etcdDeleteRangeAPI, &etcdRangeRequest{Key: []byte(key)}, nil); err != nil {
+		vlog.Errorf("[EtcdRegistry] remove node error. path:%s, err:%v", key, err)
+	}
+}
+
+func (e *EtcdRegistry) rangeKeys(key []byte, rangeEnd []byte) ([]etcdKeyValue, error) {
+	res := &etcdRangeResponse{}
+	if err := e.call(etcdRangeAPI, &etcdRangeRequest{Key: key, RangeEnd: rangeEnd}, res); err != nil {
+		return nil, err
+	}
+	return res.Kvs, nil
+}
+
+// call posts the request to the api of the etcd json gateway, the next address is used by the following calls if the
+// etcd can not be connected
+func (e *EtcdRegistry) call(api string, request interface{}, response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	res, err := e.client.Post(e.endpoint()+api, "application/json", bytes.NewReader(body))
+	if err != nil {
+		e.nextEndpoint()
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return etcdResponseError(res)
+	}
+	if response == nil {
+		_, err = io.Copy(ioutil.Discard, res.Body)
+		return err
+	}
+	// the keepalive api is a stream, only the first response is read
+	return json.NewDecoder(res.Body).Decode(response)
+}
+
+func (e *EtcdRegistry) endpoint() string {
+	if len(e.addrs) == 0 {
+		return "http://127.0.0.1:2379"
+	}
+	addr := e.addrs[int(atomic.LoadUint32(&e.addrIndex))%len(e.addrs)]
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return addr
+}
+
+func (e *EtcdRegistry) nextEndpoint() {
+	atomic.AddUint32(&e.addrIndex, 1)
+}
+
+func etcdResponseError(res *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+	return fmt.Errorf("etcd response status %d: %s", res.StatusCode, strings.TrimSpace(string(body)))
+}
+
+func etcdCommandPath(url *motan.URL) string {
+	if IsAgent(url) {
+		return toAgentCommandPath(url)
+	}
+	return toCommandPath(url)
+}
+
+// etcdPrefixEnd returns the range end of the keys with the prefix
+func etcdPrefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return []byte{0}
+}
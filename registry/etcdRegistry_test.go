@@ -0,0 +1,335 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/registry/registrytest"
+)
+
+func TestEtcdRegistry(t *testing.T) {
+	defer func(interval time.Duration) { etcdWatchRetryInterval = interval }(etcdWatchRetryInterval)
+	etcdWatchRetryInterval = 50 * time.Millisecond
+	etcd := newFakeEtcd()
+	server := httptest.NewServer(etcd)
+	defer server.Close()
+	registryURL := &motan.URL{Protocol: Etcd, Parameters: map[string]string{motan.AddressKey: server.Listener.Addr().String(), motan.SessionTimeOutKey: "1"}}
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	RegistDefaultRegistry(ext)
+	registry := ext.GetRegistry(registryURL).(*EtcdRegistry)
+	assert.True(t, registry.IsAvailable())
+	assert.Equal(t, Etcd, registry.GetName())
+
+	url := &motan.URL{Protocol: "motan", Group: "etcdGroup", Path: "etcdPath", Host: "127.0.0.1", Port: 1234, Parameters: map[string]string{}}
+	serverKey := toNodePath(url, zkNodeTypeServer)
+	unavailableKey := toNodePath(url, zkNodeTypeUnavailableServer)
+	assert.Nil(t, registry.TryRegister(url))
+	assert.Equal(t, url.ToExtInfo(), etcd.get(unavailableKey))
+	assert.NotEqual(t, int64(0), etcd.lease(unavailableKey))
+	assert.Equal(t, 1, len(registry.GetRegisteredServices()))
+
+	// the listener is notified when the service is available
+	listener := &etcdTestListener{}
+	referer := &motan.URL{Protocol: "motan", Group: "etcdGroup", Path: "etcdPath", Parameters: map[string]string{}}
+	registry.Subscribe(referer, listener)
+	for i := 0; i < 100 && etcd.watchCount() < 1; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.NotEqual(t, "", etcd.get(toNodePath(referer, zkNodeTypeClient)))
+	registry.Available(url)
+	assert.Equal(t, "", etcd.get(unavailableKey))
+	assert.Equal(t, url.ToExtInfo(), etcd.get(serverKey))
+	assert.True(t, listener.waitNotified(1))
+	assert.Equal(t, url.GetIdentity(), listener.getURLs()[0].GetIdentity())
+	assert.Equal(t, 1, len(registry.Discover(referer)))
+
+	// the nodes are put again with a new lease if the lease is expired
+	lease := etcd.lease(serverKey)
+	etcd.expire()
+	assert.Equal(t, "", etcd.get(serverKey))
+	for i := 0; i < 100 && etcd.get(serverKey) == ""; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.Equal(t, url.ToExtInfo(), etcd.get(serverKey))
+	assert.NotEqual(t, lease, etcd.lease(serverKey))
+	assert.NotEqual(t, "", etcd.get(toNodePath(referer, zkNodeTypeClient)))
+	assert.True(t, listener.waitNotified(2))
+
+	// the changes are notified after the broken watch is created again
+	etcd.breakWatches()
+	other := url.Copy()
+	other.Port = 1235
+	registry.Register(other)
+	registry.Available(other)
+	for i := 0; i < 100 && len(listener.getURLs()) != 2; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.Equal(t, 2, len(listener.getURLs()))
+
+	registry.Unavailable(nil)
+	assert.Equal(t, "", etcd.get(serverKey))
+	assert.Equal(t, url.ToExtInfo(), etcd.get(unavailableKey))
+	assert.Equal(t, 0, len(registry.Discover(referer)))
+	registry.UnRegister(url)
+	assert.Equal(t, "", etcd.get(unavailableKey))
+	assert.Equal(t, 1, len(registry.GetRegisteredServices()))
+
+	// command
+	commandListener := &etcdTestListener{}
+	registry.SubscribeCommand(referer, commandListener)
+	for i := 0; i < 100 && etcd.watchCount() < 2; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	etcd.put(toCommandPath(referer), "{\"a\":1}", 0)
+	for i := 0; i < 100 && commandListener.getCommand() == ""; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.Equal(t, "{\"a\":1}", commandListener.getCommand())
+	assert.Equal(t, "{\"a\":1}", registry.DiscoverCommand(referer))
+	registry.UnSubscribeCommand(referer, commandListener)
+
+	registry.Unsubscribe(referer, listener)
+	assert.Equal(t, "", etcd.get(toNodePath(referer, zkNodeTypeClient)))
+	for i := 0; i < 100 && etcd.watchCount() > 0; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.Equal(t, 0, etcd.watchCount())
+}
+
+func TestEtcdRegistryConformance(t *testing.T) {
+	server := httptest.NewServer(newFakeEtcd())
+	defer server.Close()
+	registryURL := &motan.URL{Protocol: Etcd, Parameters: map[string]string{motan.AddressKey: server.Listener.Addr().String(), motan.SessionTimeOutKey: "1"}}
+	registrytest.Run(t, func() motan.Registry {
+		return newTestRegistry(registryURL)
+	})
+}
+
+func TestEtcdPrefixEnd(t *testing.T) {
+	assert.Equal(t, []byte("/motan/g/p/server0"), etcdPrefixEnd("/motan/g/p/server/"))
+	assert.Equal(t, []byte("b"), etcdPrefixEnd("a\xff"))
+	assert.Equal(t, []byte{0}, etcdPrefixEnd("\xff"))
+}
+
+type etcdTestListener struct {
+	lock     sync.Mutex
+	notified int
+	urls     []*motan.URL
+	command  string
+}
+
+func (l *etcdTestListener) Notify(registryURL *motan.URL, urls []*motan.URL) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.notified++
+	l.urls = urls
+}
+
+func (l *etcdTestListener) NotifyCommand(registryURL *motan.URL, commandType int, commandInfo string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.command = commandInfo
+}
+
+func (l *etcdTestListener) GetIdentity() string {
+	return "etcdTestListener"
+}
+
+func (l *etcdTestListener) waitNotified(count int) bool {
+	for i := 0; i < 100; i++ {
+		l.lock.Lock()
+		notified := l.notified
+		l.lock.Unlock()
+		if notified >= count {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return false
+}
+
+func (l *etcdTestListener) getURLs() []*motan.URL {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.urls
+}
+
+func (l *etcdTestListener) getCommand() string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.command
+}
+
+// fakeEtcd serves the apis of the etcd json gateway used by the registry, the leases never expire until expire is called
+type fakeEtcd struct {
+	lock     sync.Mutex
+	nextID   int64
+	leases   map[int64]bool
+	values   map[string]string
+	leaseIDs map[string]int64
+	watches  map[chan struct{}]etcdRangeRequest
+	broken   chan struct{}
+}
+
+func newFakeEtcd() *fakeEtcd {
+	return &fakeEtcd{leases: map[int64]bool{}, values: map[string]string{}, leaseIDs: map[string]int64{},
+		watches: map[chan struct{}]etcdRangeRequest{}, broken: make(chan struct{})}
+}
+
+func (f *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID            int64            `json:"ID,string"`
+		TTL           int64            `json:"TTL,string"`
+		Key           []byte           `json:"key"`
+		Value         []byte           `json:"value"`
+		RangeEnd      []byte           `json:"range_end"`
+		Lease         int64            `json:"lease,string"`
+		CreateRequest etcdRangeRequest `json:"create_request"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	switch r.URL.Path {
+	case etcdLeaseGrantAPI:
+		f.lock.Lock()
+		f.nextID++
+		f.leases[f.nextID] = true
+		id := f.nextID
+		f.lock.Unlock()
+		json.NewEncoder(w).Encode(map[string]string{"ID": strconv.FormatInt(id, 10), "TTL": strconv.FormatInt(req.TTL, 10)})
+	case etcdLeaseKeepAliveAPI:
+		f.lock.Lock()
+		alive := f.leases[req.ID]
+		f.lock.Unlock()
+		result := map[string]string{"ID": strconv.FormatInt(req.ID, 10)}
+		if alive {
+			result["TTL"] = "1"
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"result": result})
+	case etcdPutAPI:
+		f.put(string(req.Key), string(req.Value), req.Lease)
+		w.Write([]byte("{}"))
+	case etcdDeleteRangeAPI:
+		f.lock.Lock()
+		delete(f.values, string(req.Key))
+		f.notify(string(req.Key))
+		f.lock.Unlock()
+		w.Write([]byte("{}"))
+	case etcdRangeAPI:
+		f.lock.Lock()
+		res := &etcdRangeResponse{}
+		for k, v := range f.values {
+			if inRange(k, req.Key, req.RangeEnd) {
+				res.Kvs = append(res.Kvs, etcdKeyValue{Key: []byte(k), Value: []byte(v)})
+			}
+		}
+		f.lock.Unlock()
+		json.NewEncoder(w).Encode(res)
+	case etcdWatchAPI:
+		f.serveWatch(w, r, req.CreateRequest)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeEtcd) serveWatch(w http.ResponseWriter, r *http.Request, watch etcdRangeRequest) {
+	ch := make(chan struct{}, 16)
+	f.lock.Lock()
+	f.watches[ch] = watch
+	broken := f.broken
+	f.lock.Unlock()
+	defer func() {
+		f.lock.Lock()
+		delete(f.watches, ch)
+		f.lock.Unlock()
+	}()
+	w.Write([]byte("{\"result\":{\"created\":true}}\n"))
+	w.(http.Flusher).Flush()
+	for {
+		select {
+		case <-ch:
+			w.Write([]byte("{\"result\":{\"events\":[{\"type\":\"PUT\"}]}}\n"))
+			w.(http.Flusher).Flush()
+		case <-broken:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (f *fakeEtcd) put(key string, value string, lease int64) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.values[key] = value
+	f.leaseIDs[key] = lease
+	f.notify(key)
+}
+
+// notify should be called with the lock held
+func (f *fakeEtcd) notify(key string) {
+	for ch, watch := range f.watches {
+		if inRange(key, watch.Key, watch.RangeEnd) {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+func (f *fakeEtcd) get(key string) string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.values[key]
+}
+
+func (f *fakeEtcd) lease(key string) int64 {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.leaseIDs[key]
+}
+
+// expire expires all leases and removes their keys
+func (f *fakeEtcd) expire() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.leases = map[int64]bool{}
+	for k, id := range f.leaseIDs {
+		if id != 0 {
+			delete(f.values, k)
+			delete(f.leaseIDs, k)
+			f.notify(k)
+		}
+	}
+}
+
+func (f *fakeEtcd) breakWatches() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	close(f.broken)
+	f.broken = make(chan struct{})
+}
+
+func (f *fakeEtcd) watchCount() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return len(f.watches)
+}
+
+func inRange(key string, start []byte, end []byte) bool {
+	if len(end) == 0 {
+		return key == string(start)
+	}
+	return bytes.Compare([]byte(key), start) >= 0 && bytes.Compare([]byte(key), end) < 0
+}
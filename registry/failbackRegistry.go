@@ -0,0 +1,375 @@
+package registry
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// failback options of the registry url parameters
+const (
+	FailbackKey              = "failback"              // wrap the registry by the FailbackRegistry if it is true
+	FailbackRetryIntervalKey = "failbackRetryInterval" // the interval(ms) to retry the failed registrations and subscriptions
+)
+
+const failbackDefaultRetryInterval = 5 * time.Second
+
+// FailbackRegistry wraps a remote registry so the services can start and route when the registry is unreachable:
+// the discovered nodes are written to the snapshot dir, and they are discovered from the snapshot file if the registry
+// has not discovered any node of the service. the registrations failed by a TryRegistry and the subscriptions made
+// when the registry is unavailable are retried every FailbackRetryIntervalKey until they succeed
+type FailbackRegistry struct {
+	registry         motan.Registry
+	lock             sync.Mutex
+	failedRegistered map[string]*motan.URL                      // the registrations to retry
+	pendingAvailable map[string]bool                            // the availability of the registrations to retry
+	failedSubscribed map[motan.NotifyListener]*failbackListener // the subscriptions to retry
+	listeners        map[motan.NotifyListener]*failbackListener // all subscriptions
+	discovered       map[string]bool                            // the services which nodes once discovered from the registry
+	snapshotDir      string                                     // the snapshot dir, it is the dir of the snapshot conf if empty
+	stopOnce         sync.Once
+	stopCh           chan struct{}
+}
+
+// failbackListener writes the notified nodes to the snapshot file before notifies the listener
+type failbackListener struct {
+	registry *FailbackRegistry
+	url      *motan.URL
+	listener motan.NotifyListener
+}
+
+func (l *failbackListener) Notify(registryURL *motan.URL, urls []*motan.URL) {
+	l.registry.saveSnapshot(l.url, urls)
+	l.listener.Notify(registryURL, urls)
+}
+
+func (l *failbackListener) GetIdentity() string {
+	return "failback-" + l.listener.GetIdentity()
+}
+
+// NewFailbackRegistry returns the failback registry of the registry, the registry is initialized by the failback registry
+func NewFailbackRegistry(registry motan.Registry) *FailbackRegistry {
+	return &FailbackRegistry{
+		registry:         registry,
+		failedRegistered: make(map[string]*motan.URL),
+		pendingAvailable: make(map[string]bool),
+		failedSubscribed: make(map[motan.NotifyListener]*failbackListener),
+		listeners:        make(map[motan.NotifyListener]*failbackListener),
+		discovered:       make(map[string]bool),
+		stopCh:           make(chan struct{}),
+	}
+}
+
+// newRemoteRegistry wraps the registry by the FailbackRegistry if the failback is enabled by the registry url
+func newRemoteRegistry(url *motan.URL, registry motan.Registry) motan.Registry {
+	if enable, _ := strconv.ParseBool(url.GetParam(FailbackKey, "false")); enable {
+		return NewFailbackRegistry(registry)
+	}
+	return registry
+}
+
+func (f *FailbackRegistry) Initialize() {
+	motan.Initialize(f.registry)
+	go f.retry(f.GetURL().GetTimeDuration(FailbackRetryIntervalKey, time.Millisecond, failbackDefaultRetryInterval))
+}
+
+func (f *FailbackRegistry) retry(interval time.Duration) {
+	defer motan.HandlePanic(nil)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.stopCh:
+			return
+		case <-ticker.C:
+			f.retryRegister()
+			f.retrySubscribe()
+		}
+	}
+}
+
+// Destroy stops the retries of the failback registry, the registry is destroyed too if it is destroyable
+func (f *FailbackRegistry) Destroy() {
+	f.stopOnce.Do(func() {
+		close(f.stopCh)
+	})
+	if d, ok := f.registry.(motan.Destroyable); ok {
+		d.Destroy()
+	}
+}
+
+// retryRegister retries the failed registrations without holding the lock. the registration unregistered during the
+// retry is unregistered again, and the registration failed again during the retry is kept to retry
+func (f *FailbackRegistry) retryRegister() {
+	f.lock.Lock()
+	failed := make(map[string]*motan.URL, len(f.failedRegistered))
+	for id, url := range f.failedRegistered {
+		failed[id] = url
+	}
+	f.lock.Unlock()
+	for id, url := range failed {
+		if err := f.registry.(motan.TryRegistry).TryRegister(url); err != nil {
+			vlog.Warningf("[FailbackRegistry] retry register fail. url:%s, err:%v", id, err)
+			continue
+		}
+		vlog.Infof("[FailbackRegistry] retry register success. url:%s", id)
+		f.lock.Lock()
+		current, ok := f.failedRegistered[id]
+		available := f.pendingAvailable[id]
+		if current == url {
+			delete(f.failedRegistered, id)
+			delete(f.pendingAvailable, id)
+		}
+		f.lock.Unlock()
+		switch {
+		case !ok:
+			// unregistered during the retry
+			f.registry.UnRegister(url)
+		case current == url && available:
+			f.registry.Available(url)
+		}
+	}
+}
+
+func (f *FailbackRegistry) retrySubscribe() {
+	if !f.isRegistryAvailable() {
+		return
+	}
+	f.lock.Lock()
+	failed := make([]*failbackListener, 0, len(f.failedSubscribed))
+	for listener, l := range f.failedSubscribed {
+		failed = append(failed, l)
+		delete(f.failedSubscribed, listener)
+	}
+	f.lock.Unlock()
+	for _, l := range failed {
+		vlog.Infof("[FailbackRegistry] retry subscribe. url:%s", l.url.GetIdentity())
+		f.registry.Subscribe(l.url, l)
+		// the listener only knows the nodes of the snapshot yet
+		if urls := f.registry.Discover(l.url); len(urls) > 0 {
+			l.Notify(f.GetURL(), urls)
+		}
+	}
+}
+
+func (f *FailbackRegistry) Register(serverURL *motan.URL) {
+	tr, ok := f.registry.(motan.TryRegistry)
+	if !ok {
+		f.registry.Register(serverURL)
+		return
+	}
+	if err := tr.TryRegister(serverURL); err != nil {
+		vlog.Warningf("[FailbackRegistry] register fail, retry later. url:%s, err:%v", serverURL.GetIdentity(), err)
+		f.lock.Lock()
+		f.failedRegistered[serverURL.GetIdentity()] = serverURL
+		f.lock.Unlock()
+	}
+}
+
+func (f *FailbackRegistry) UnRegister(serverURL *motan.URL) {
+	f.lock.Lock()
+	delete(f.failedRegistered, serverURL.GetIdentity())
+	delete(f.pendingAvailable, serverURL.GetIdentity())
+	f.lock.Unlock()
+	f.registry.UnRegister(serverURL)
+}
+
+func (f *FailbackRegistry) Available(serverURL *motan.URL) {
+	f.setPendingAvailable(serverURL, true)
+	f.registry.Available(serverURL)
+}
+
+func (f *FailbackRegistry) Unavailable(serverURL *motan.URL) {
+	f.setPendingAvailable(serverURL, false)
+	f.registry.Unavailable(serverURL)
+}
+
+// setPendingAvailable keeps the availability of the failed registrations, they are set available after registered
+func (f *FailbackRegistry) setPendingAvailable(serverURL *motan.URL, available bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for id := range f.failedRegistered {
+		if serverURL == nil || serverURL.GetIdentity() == id {
+			f.pendingAvailable[id] = available
+		}
+	}
+}
+
+func (f *FailbackRegistry) GetRegisteredServices() []*motan.URL {
+	urls := f.registry.GetRegisteredServices()
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, u := range f.failedRegistered {
+		urls = append(urls, u)
+	}
+	return urls
+}
+
+func (f *FailbackRegistry) Subscribe(url *motan.URL, listener motan.NotifyListener) {
+	f.lock.Lock()
+	l, ok := f.listeners[listener]
+	if !ok {
+		l = &failbackListener{registry: f, url: url, listener: listener}
+		f.listeners[listener] = l
+	}
+	available := f.isRegistryAvailable()
+	if !available {
+		vlog.Warningf("[FailbackRegistry] registry is unavailable, retry subscribe later. url:%s", url.GetIdentity())
+		f.failedSubscribed[listener] = l
+	}
+	f.lock.Unlock()
+	if available {
+		f.registry.Subscribe(url, l)
+	}
+}
+
+func (f *FailbackRegistry) Unsubscribe(url *motan.URL, listener motan.NotifyListener) {
+	f.lock.Lock()
+	l, ok := f.listeners[listener]
+	delete(f.listeners, listener)
+	delete(f.failedSubscribed, listener)
+	f.lock.Unlock()
+	if ok {
+		f.registry.Unsubscribe(url, l)
+	}
+}
+
+// Discover returns the nodes of the snapshot file if the registry has not discovered any node of the service
+func (f *FailbackRegistry) Discover(url *motan.URL) []*motan.URL {
+	urls := f.registry.Discover(url)
+	key := GetNodeKey(url)
+	f.lock.Lock()
+	discovered := f.discovered[key]
+	if len(urls) > 0 {
+		f.discovered[key] = true
+	}
+	f.lock.Unlock()
+	if len(urls) > 0 {
+		f.saveSnapshot(url, urls)
+		return urls
+	}
+	if discovered && f.isRegistryAvailable() {
+		return urls
+	}
+	if snapshotURLs := f.loadSnapshot(url); len(snapshotURLs) > 0 {
+		vlog.Warningf("[FailbackRegistry] no node discovered from registry, use the snapshot. url:%s, size:%d", url.GetIdentity(), len(snapshotURLs))
+		return snapshotURLs
+	}
+	return urls
+}
+
+func (f *FailbackRegistry) SubscribeCommand(url *motan.URL, listener motan.CommandNotifyListener) {
+	if cr, ok := f.registry.(motan.DiscoverCommand); ok {
+		cr.SubscribeCommand(url, listener)
+	}
+}
+
+func (f *FailbackRegistry) UnSubscribeCommand(url *motan.URL, listener motan.CommandNotifyListener) {
+	if cr, ok := f.registry.(motan.DiscoverCommand); ok {
+		cr.UnSubscribeCommand(url, listener)
+	}
+}
+
+func (f *FailbackRegistry) DiscoverCommand(url *motan.URL) string {
+	if cr, ok := f.registry.(motan.DiscoverCommand); ok {
+		return cr.DiscoverCommand(url)
+	}
+	return ""
+}
+
+func (f *FailbackRegistry) StartSnapshot(conf *motan.SnapshotConf) {
+	f.registry.StartSnapshot(conf)
+}
+
+func (f *FailbackRegistry) GetURL() *motan.URL {
+	return f.registry.GetURL()
+}
+
+func (f *FailbackRegistry) SetURL(url *motan.URL) {
+	f.registry.SetURL(url)
+}
+
+func (f *FailbackRegistry) GetName() string {
+	return f.registry.GetName()
+}
+
+// isRegistryAvailable returns the availability of the registry, the registry without IsAvailable is always available
+func (f *FailbackRegistry) isRegistryAvailable() bool {
+	if r, ok := f.registry.(interface{ IsAvailable() bool }); ok {
+		return r.IsAvailable()
+	}
+	return true
+}
+
+func (f *FailbackRegistry) getSnapshotDir() string {
+	if f.snapshotDir != "" {
+		return f.snapshotDir
+	}
+	return snapshotConf.SnapshotDir
+}
+
+func (f *FailbackRegistry) snapshotFile(url *motan.URL) string {
+	return filepath.Join(f.getSnapshotDir(), f.GetURL().Protocol+"_"+GetNodeKey(url)+".failback")
+}
+
+// saveSnapshot writes the nodes to the snapshot file, the file is replaced by rename so it is never partially written
+func (f *FailbackRegistry) saveSnapshot(url *motan.URL, urls []*motan.URL) {
+	if len(urls) == 0 {
+		return
+	}
+	node := ServiceNode{Group: url.Group, Path: url.Path, Nodes: make([]SnapshotNodeInfo, 0, len(urls))}
+	for _, u := range urls {
+		node.Nodes = append(node.Nodes, SnapshotNodeInfo{Addr: u.GetAddressStr(), ExtInfo: u.ToExtInfo()})
+	}
+	checkSnapshotDir(f.getSnapshotDir())
+	file := f.snapshotFile(url)
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(JSONString(node)), 0664); err != nil {
+		vlog.Errorf("[FailbackRegistry] write snapshot fail. file:%s, err:%v", file, err)
+		return
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		vlog.Errorf("[FailbackRegistry] write snapshot fail. file:%s, err:%v", file, err)
+	}
+}
+
+func (f *FailbackRegistry) loadSnapshot(url *motan.URL) []*motan.URL {
+	data, err := ioutil.ReadFile(f.snapshotFile(url))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			vlog.Errorf("[FailbackRegistry] read snapshot fail. url:%s, err:%v", url.GetIdentity(), err)
+		}
+		return nil
+	}
+	node := ServiceNode{}
+	if err = json.Unmarshal(data, &node); err != nil {
+		vlog.Errorf("[FailbackRegistry] illegal snapshot. url:%s, err:%v", url.GetIdentity(), err)
+		return nil
+	}
+	urls := make([]*motan.URL, 0, len(node.Nodes))
+	for _, n := range node.Nodes {
+		var u *motan.URL
+		if n.ExtInfo != "" {
+			u = motan.FromExtInfo(n.ExtInfo)
+		}
+		if u == nil {
+			u = url.Copy()
+			hp := strings.Split(n.Addr, ":")
+			u.Host = hp[0]
+			u.Port = 80
+			if len(hp) > 1 {
+				u.Port, _ = strconv.Atoi(hp[1])
+			}
+		}
+		urls = append(urls, u)
+	}
+	return urls
+}
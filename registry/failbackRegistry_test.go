@@ -0,0 +1,259 @@
+package registry
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/registry/registrytest"
+)
+
+func TestFailbackRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "failback")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	registryURL := &motan.URL{Protocol: "failbackTest", Host: "127.0.0.1", Port: 2181, Parameters: map[string]string{FailbackRetryIntervalKey: "20"}}
+	referer := &motan.URL{Protocol: "motan", Group: "failbackGroup", Path: "failbackPath", Parameters: map[string]string{}}
+	node := &motan.URL{Protocol: "motan", Group: "failbackGroup", Path: "failbackPath", Host: "127.0.0.1", Port: 1234, Parameters: map[string]string{"k": "v"}}
+
+	// the discovered nodes are written to the snapshot file
+	inner := &failbackTestRegistry{url: registryURL, available: true, nodes: []*motan.URL{node}}
+	registry := NewFailbackRegistry(inner)
+	registry.snapshotDir = dir
+	motan.Initialize(registry)
+	assert.Equal(t, 1, len(registry.Discover(referer)))
+	registry.Destroy()
+
+	// the snapshot is discovered if the registry is unreachable on startup
+	inner = &failbackTestRegistry{url: registryURL}
+	registry = NewFailbackRegistry(inner)
+	registry.snapshotDir = dir
+	motan.Initialize(registry)
+	defer registry.Destroy()
+	urls := registry.Discover(referer)
+	assert.Equal(t, 1, len(urls))
+	assert.Equal(t, node.ToExtInfo(), urls[0].ToExtInfo())
+
+	// the failed registrations and subscriptions are retried
+	server := &motan.URL{Protocol: "motan", Group: "failbackGroup", Path: "failbackPath", Host: "127.0.0.1", Port: 1235, Parameters: map[string]string{}}
+	registry.Register(server)
+	registry.Available(nil)
+	assert.Equal(t, 1, len(registry.GetRegisteredServices()))
+	listener := &etcdTestListener{}
+	registry.Subscribe(referer, listener)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 0, len(inner.getEvents()))
+	inner.setAvailable(true, []*motan.URL{node, server})
+	assert.True(t, listener.waitNotified(1))
+	assert.Equal(t, 2, len(listener.getURLs()))
+	assert.Equal(t, []string{"register", "available", "subscribe"}, inner.getEvents())
+	assert.Equal(t, 1, len(registry.GetRegisteredServices()))
+
+	// the notified nodes are written to the snapshot file
+	inner.notify([]*motan.URL{server})
+	assert.Equal(t, 1, len(listener.getURLs()))
+	inner.setAvailable(false, nil)
+	snapshotRegistry := NewFailbackRegistry(inner)
+	snapshotRegistry.snapshotDir = dir
+	urls = snapshotRegistry.Discover(referer)
+	assert.Equal(t, 1, len(urls))
+	assert.Equal(t, int(server.Port), int(urls[0].Port))
+
+	// the empty service of the available registry is not replaced by the snapshot
+	inner.setAvailable(true, []*motan.URL{server})
+	assert.Equal(t, 1, len(registry.Discover(referer)))
+	inner.setAvailable(true, nil)
+	assert.Equal(t, 0, len(registry.Discover(referer)))
+	registry.Unsubscribe(referer, listener)
+	assert.Equal(t, "unsubscribe", inner.getEvents()[len(inner.getEvents())-1])
+}
+
+func TestFailbackRegistryRetry(t *testing.T) {
+	registryURL := &motan.URL{Protocol: "failbackTest", Host: "127.0.0.1", Port: 2181, Parameters: map[string]string{FailbackRetryIntervalKey: "20"}}
+	server := &motan.URL{Protocol: "motan", Group: "failbackGroup", Path: "failbackPath", Host: "127.0.0.1", Port: 1235, Parameters: map[string]string{}}
+	inner := &failbackTestRegistry{url: registryURL}
+	registry := NewFailbackRegistry(inner)
+	motan.Initialize(registry)
+	registry.Register(server)
+
+	// the registry is not locked while the registration is retried
+	inner.started, inner.release = make(chan struct{}), make(chan struct{})
+	inner.setAvailable(true, nil)
+	select {
+	case <-inner.started:
+	case <-time.After(time.Second):
+		t.Fatal("the registration is not retried")
+	}
+	done := make(chan struct{})
+	go func() {
+		registry.UnRegister(server)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the registry is locked by the retry")
+	}
+	close(inner.release)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, []string{"unregister", "register", "unregister"}, inner.getEvents())
+	assert.Equal(t, 0, len(registry.GetRegisteredServices()))
+
+	// the registrations are not retried after destroyed
+	inner.started, inner.release = nil, nil
+	inner.setAvailable(false, nil)
+	registry.Register(server)
+	registry.Destroy()
+	inner.setAvailable(true, nil)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 3, len(inner.getEvents()))
+}
+
+func TestFailbackRegistryConformance(t *testing.T) {
+	registryURL := &motan.URL{Protocol: "failbackTest", Host: "127.0.0.1", Port: 2181, Parameters: map[string]string{FailbackRetryIntervalKey: "20"}}
+	for _, available := range []bool{true, false} {
+		t.Run("available="+strconv.FormatBool(available), func(t *testing.T) {
+			registrytest.Run(t, func() motan.Registry {
+				registry := NewFailbackRegistry(&failbackTestRegistry{url: registryURL, available: available})
+				motan.Initialize(registry)
+				return registry
+			})
+		})
+	}
+}
+
+type failbackTestRegistry struct {
+	url       *motan.URL
+	lock      sync.Mutex
+	available bool
+	nodes     []*motan.URL
+	events    []string
+	listener  motan.NotifyListener
+	servers   []*motan.URL
+	started   chan struct{} // closed by the TryRegister if not nil
+	release   chan struct{} // the TryRegister waits for the release if not nil
+}
+
+func (r *failbackTestRegistry) setAvailable(available bool, nodes []*motan.URL) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.available = available
+	r.nodes = nodes
+}
+
+func (r *failbackTestRegistry) notify(nodes []*motan.URL) {
+	r.lock.Lock()
+	listener := r.listener
+	r.lock.Unlock()
+	listener.Notify(r.url, nodes)
+}
+
+func (r *failbackTestRegistry) getEvents() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]string(nil), r.events...)
+}
+
+func (r *failbackTestRegistry) event(event string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *failbackTestRegistry) IsAvailable() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.available
+}
+
+func (r *failbackTestRegistry) TryRegister(url *motan.URL) error {
+	if !r.IsAvailable() {
+		return errors.New("unavailable")
+	}
+	if r.started != nil {
+		close(r.started)
+		<-r.release
+	}
+	r.Register(url)
+	return nil
+}
+
+func (r *failbackTestRegistry) Register(url *motan.URL) {
+	r.event("register")
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.removeServer(url)
+	r.servers = append(r.servers, url)
+}
+
+func (r *failbackTestRegistry) UnRegister(url *motan.URL) {
+	r.event("unregister")
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.removeServer(url)
+}
+
+func (r *failbackTestRegistry) removeServer(url *motan.URL) {
+	for i, server := range r.servers {
+		if server.GetIdentity() == url.GetIdentity() {
+			r.servers = append(r.servers[:i:i], r.servers[i+1:]...)
+			return
+		}
+	}
+}
+
+// Available is ignored if the registry is unavailable like the remote registries
+func (r *failbackTestRegistry) Available(url *motan.URL) {
+	if r.IsAvailable() {
+		r.event("available")
+	}
+}
+
+func (r *failbackTestRegistry) Unavailable(url *motan.URL) {
+	if r.IsAvailable() {
+		r.event("unavailable")
+	}
+}
+
+func (r *failbackTestRegistry) GetRegisteredServices() []*motan.URL {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.servers
+}
+
+func (r *failbackTestRegistry) Subscribe(url *motan.URL, listener motan.NotifyListener) {
+	r.event("subscribe")
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.listener = listener
+}
+
+func (r *failbackTestRegistry) Unsubscribe(url *motan.URL, listener motan.NotifyListener) {
+	r.event("unsubscribe")
+}
+
+func (r *failbackTestRegistry) Discover(url *motan.URL) []*motan.URL {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.nodes
+}
+
+func (r *failbackTestRegistry) StartSnapshot(conf *motan.SnapshotConf) {}
+
+func (r *failbackTestRegistry) GetURL() *motan.URL {
+	return r.url
+}
+
+func (r *failbackTestRegistry) SetURL(url *motan.URL) {
+	r.url = url
+}
+
+func (r *failbackTestRegistry) GetName() string {
+	return "failbackTest"
+}
@@ -0,0 +1,477 @@
+package registry
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	URL "net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// kubernetes options of the registry url parameters
+const (
+	KubernetesNamespaceKey = "namespace" // the namespace of the services, the namespace of the pod or default if absent
+	KubernetesTokenFileKey = "tokenFile" // the bearer token file, the token of the service account of the pod if absent
+	KubernetesCAFileKey    = "caFile"    // the ca certificate of the api server, the ca of the service account of the pod if absent
+)
+
+// kubernetes options of the referer url parameters
+const (
+	KubernetesServiceKey = "kubernetesService" // the kubernetes service of the motan service, the path if absent
+	// KubernetesPortKey is the port name of the endpoints, the only port of the endpoints or the port of the referer
+	// if absent
+	KubernetesPortKey = "kubernetesPort"
+)
+
+const (
+	kubernetesServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount/"
+	kubernetesDefaultNamespace  = "default"
+	kubernetesWatchTimeout      = 5 * time.Minute
+	kubernetesRetryInterval     = 3 * time.Second
+)
+
+var errKubernetesWatchStopped = errors.New("watch is stopped")
+
+// KubernetesRegistry discovers the providers by the endpoints of the kubernetes services, the ready addresses of the
+// endpoints are the nodes of the motan service. a subscribed service is listed and then watched by the kubernetes api,
+// it is listed again if the watch is broken. the registration is done by kubernetes, the pods passing the readiness
+// probe are the ready addresses, so the urls are not registered to the api server
+type KubernetesRegistry struct {
+	url                  *motan.URL
+	available            int32
+	apiServer            string
+	namespace            string
+	tokenFile            string
+	client               *http.Client
+	watchClient          *http.Client
+	registerLock         sync.Mutex
+	subscribeLock        sync.Mutex
+	registeredServiceMap map[string]*motan.URL                          // save all registered services
+	subscribedServiceMap map[string]map[motan.NotifyListener]*motan.URL // save all subscribed services with listeners
+	lastNodes            map[string]string                              // save the nodes notified last time
+	watcherStops         map[string]chan struct{}                       // save the stop channels of the watchers
+}
+
+type kubernetesEndpoints struct {
+	Metadata struct {
+		Name            string `json:"name"`
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Subsets []struct {
+		Addresses []struct {
+			IP string `json:"ip"`
+		} `json:"addresses"`
+		Ports []struct {
+			Name string `json:"name"`
+			Port int    `json:"port"`
+		} `json:"ports"`
+	} `json:"subsets"`
+}
+
+type kubernetesWatchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// Initialize uses the in cluster config of the pod if the address of the api server is absent
+func (k *KubernetesRegistry) Initialize() {
+	k.registeredServiceMap = make(map[string]*motan.URL)
+	k.subscribedServiceMap = make(map[string]map[motan.NotifyListener]*motan.URL)
+	k.lastNodes = make(map[string]string)
+	k.watcherStops = make(map[string]chan struct{})
+	if addr := k.url.GetParam(motan.AddressKey, ""); addr != "" {
+		k.apiServer = motan.TrimSplit(addr, ",")[0]
+	} else if len(k.url.Host) > 0 && k.url.Port > 0 {
+		k.apiServer = k.url.GetAddressStr()
+	} else if host := os.Getenv("KUBERNETES_SERVICE_HOST"); host != "" {
+		k.apiServer = host + ":" + os.Getenv("KUBERNETES_SERVICE_PORT")
+	}
+	if k.apiServer != "" && !strings.Contains(k.apiServer, "://") {
+		k.apiServer = "https://" + k.apiServer
+	}
+	k.namespace = k.url.GetParam(KubernetesNamespaceKey, "")
+	if k.namespace == "" {
+		if b, err := ioutil.ReadFile(kubernetesServiceAccountDir + "namespace"); err == nil {
+			k.namespace = strings.TrimSpace(string(b))
+		}
+	}
+	if k.namespace == "" {
+		k.namespace = kubernetesDefaultNamespace
+	}
+	k.tokenFile = k.url.GetParam(KubernetesTokenFileKey, kubernetesServiceAccountDir+"token")
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	caFile := k.url.GetParam(KubernetesCAFileKey, kubernetesServiceAccountDir+"ca.crt")
+	if ca, err := ioutil.ReadFile(caFile); err == nil {
+		pool := x509.NewCertPool()
+		if pool.AppendCertsFromPEM(ca) {
+			transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		} else {
+			vlog.Warningf("[KubernetesRegistry] illegal ca file %s, the system roots are used", caFile)
+		}
+	}
+	k.client = &http.Client{Transport: transport, Timeout: k.url.GetTimeDuration(motan.TimeOutKey, time.Millisecond, DefaultTimeout*time.Millisecond)}
+	// the watches are long requests, they are stopped by the timeoutSeconds of the watch
+	k.watchClient = &http.Client{Transport: transport}
+	if k.apiServer == "" {
+		vlog.Errorf("[KubernetesRegistry] the api server is unknown, the registry is not in a kubernetes cluster. url:%s", k.url.GetIdentity())
+		return
+	}
+	k.setAvailable(true)
+}
+
+// Register keeps the url only, the url is discovered if its pod is ready
+func (k *KubernetesRegistry) Register(url *motan.URL) {
+	k.registerLock.Lock()
+	defer k.registerLock.Unlock()
+	vlog.Infof("[KubernetesRegistry] register service, it is discovered by the endpoints of the pod. url:%s", url.GetIdentity())
+	k.registeredServiceMap[url.GetIdentity()] = url
+}
+
+func (k *KubernetesRegistry) UnRegister(url *motan.URL) {
+	k.registerLock.Lock()
+	defer k.registerLock.Unlock()
+	delete(k.registeredServiceMap, url.GetIdentity())
+}
+
+// Available does nothing, the availability of the pod is decided by its readiness probe
+func (k *KubernetesRegistry) Available(url *motan.URL) {}
+
+// Unavailable does nothing, the availability of the pod is decided by its readiness probe
+func (k *KubernetesRegistry) Unavailable(url *motan.URL) {}
+
+func (k *KubernetesRegistry) GetRegisteredServices() []*motan.URL {
+	k.registerLock.Lock()
+	defer k.registerLock.Unlock()
+	urls := make([]*motan.URL, 0, len(k.registeredServiceMap))
+	for _, u := range k.registeredServiceMap {
+		urls = append(urls, u)
+	}
+	return urls
+}
+
+// Subscribe watches the endpoints of the kubernetes service, the listeners are notified when the nodes are changed
+func (k *KubernetesRegistry) Subscribe(url *motan.URL, listener motan.NotifyListener) {
+	k.subscribeLock.Lock()
+	defer k.subscribeLock.Unlock()
+	key := kubernetesServiceKey(url)
+	if listeners, ok := k.subscribedServiceMap[key]; ok {
+		listeners[listener] = url
+		vlog.Infof("[KubernetesRegistry] subscribe service success. service:%s, listener:%s", key, listener.GetIdentity())
+		if nodes := k.lastNodes[key]; nodes != "" {
+			// the new listener is notified by the nodes notified last time
+			go k.notifyListener(listener, url, nodes)
+		}
+		return
+	}
+	k.subscribedServiceMap[key] = map[motan.NotifyListener]*motan.URL{listener: url}
+	vlog.Infof("[KubernetesRegistry] subscribe service. url:%s, kubernetes service:%s", url.GetIdentity(), key)
+	stop := make(chan struct{})
+	k.watcherStops[key] = stop
+	go k.watch(url, key, stop)
+}
+
+func (k *KubernetesRegistry) notifyListener(listener motan.NotifyListener, url *motan.URL, nodes string) {
+	urls := kubernetesNodeURLs(url, motan.TrimSplit(nodes, ","))
+	if len(urls) > 0 {
+		listener.Notify(k.url, urls)
+	}
+}
+
+// watch lists the endpoints and watches the changes after the resource version of the list, the endpoints are listed
+// again if the watch is broken
+func (k *KubernetesRegistry) watch(url *motan.URL, key string, stop chan struct{}) {
+	defer motan.HandlePanic(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+	service := kubernetesServiceName(url)
+	for {
+		endpoints, err := k.getEndpoints(ctx, service)
+		if err == nil {
+			k.setAvailable(true)
+			version := ""
+			if endpoints != nil {
+				version = endpoints.Metadata.ResourceVersion
+				k.update(key, url, endpoints)
+			}
+			err = k.watchEndpoints(ctx, service, version, key, url)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			vlog.Warningf("[KubernetesRegistry] watch endpoints error, list them again. service:%s, err:%v", key, err)
+			if _, ok := err.(*kubernetesStatusError); !ok {
+				k.setAvailable(false)
+			}
+			select {
+			case <-stop:
+				return
+			case <-time.After(kubernetesRetryInterval):
+			}
+		}
+	}
+}
+
+// watchEndpoints returns nil when the watch is finished by the timeout
+func (k *KubernetesRegistry) watchEndpoints(ctx context.Context, service string, version string, key string, url *motan.URL) error {
+	params := URL.Values{}
+	params.Set("watch", "true")
+	params.Set("fieldSelector", "metadata.name="+service)
+	params.Set("timeoutSeconds", strconv.Itoa(int(kubernetesWatchTimeout/time.Second)))
+	if version != "" {
+		params.Set("resourceVersion", version)
+	}
+	res, err := k.do(ctx, k.watchClient, "/api/v1/namespaces/"+k.namespace+"/endpoints?"+params.Encode())
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	decoder := json.NewDecoder(res.Body)
+	for {
+		event := &kubernetesWatchEvent{}
+		if err = decoder.Decode(event); err != nil {
+			if ctx.Err() != nil {
+				return errKubernetesWatchStopped
+			}
+			// the watch is finished by the api server
+			return nil
+		}
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			endpoints := &kubernetesEndpoints{}
+			if err = json.Unmarshal(event.Object, endpoints); err != nil {
+				return err
+			}
+			k.update(key, url, endpoints)
+		case "DELETED":
+			vlog.Warningf("[KubernetesRegistry] endpoints are deleted, the nodes are kept. service:%s", key)
+		case "ERROR":
+			// e.g. the resource version is too old
+			return &kubernetesStatusError{message: string(event.Object)}
+		}
+	}
+}
+
+// update notifies the listeners if the nodes are changed, the empty nodes are not notified in case the endpoints of
+// the service are recreated
+func (k *KubernetesRegistry) update(key string, url *motan.URL, endpoints *kubernetesEndpoints) {
+	addrs := kubernetesAddresses(url, endpoints)
+	nodes := strings.Join(addrs, ",")
+	k.subscribeLock.Lock()
+	listeners, ok := k.subscribedServiceMap[key]
+	if !ok || nodes == k.lastNodes[key] {
+		k.subscribeLock.Unlock()
+		return
+	}
+	k.lastNodes[key] = nodes
+	notifies := make(map[motan.NotifyListener]*motan.URL, len(listeners))
+	for lis, u := range listeners {
+		notifies[lis] = u
+	}
+	k.subscribeLock.Unlock()
+	k.saveSnapshot(url, addrs)
+	if len(addrs) == 0 {
+		vlog.Warningf("[KubernetesRegistry] no ready address of the service, the nodes are kept. service:%s", key)
+		return
+	}
+	for lis, u := range notifies {
+		lis.Notify(k.url, kubernetesNodeURLs(u, addrs))
+	}
+	vlog.Infof("[KubernetesRegistry] notify nodes. service:%s, size:%d", key, len(addrs))
+}
+
+func (k *KubernetesRegistry) saveSnapshot(url *motan.URL, addrs []string) {
+	nodeInfos := make([]SnapshotNodeInfo, 0, len(addrs))
+	for _, addr := range addrs {
+		nodeInfos = append(nodeInfos, SnapshotNodeInfo{Addr: addr})
+	}
+	SaveSnapshot(k.url.GetIdentity(), GetNodeKey(url), ServiceNode{Group: url.Group, Path: url.Path, Nodes: nodeInfos})
+}
+
+// Unsubscribe removes the listener of the service, the watch is stopped with the last listener
+func (k *KubernetesRegistry) Unsubscribe(url *motan.URL, listener motan.NotifyListener) {
+	k.subscribeLock.Lock()
+	defer k.subscribeLock.Unlock()
+	key := kubernetesServiceKey(url)
+	if listeners, ok := k.subscribedServiceMap[key]; ok {
+		vlog.Infof("[KubernetesRegistry] unsubscribe service. url:%s", url.GetIdentity())
+		delete(listeners, listener)
+		if len(listeners) < 1 {
+			delete(k.subscribedServiceMap, key)
+			delete(k.lastNodes, key)
+			close(k.watcherStops[key])
+			delete(k.watcherStops, key)
+		}
+	}
+}
+
+// Discover returns the ready addresses of the endpoints of the service
+func (k *KubernetesRegistry) Discover(url *motan.URL) []*motan.URL {
+	endpoints, err := k.getEndpoints(context.Background(), kubernetesServiceName(url))
+	if err != nil {
+		vlog.Errorf("[KubernetesRegistry] discover service error! url:%s, err:%v", url.GetIdentity(), err)
+		return nil
+	}
+	if endpoints == nil {
+		return nil
+	}
+	addrs := kubernetesAddresses(url, endpoints)
+	k.saveSnapshot(url, addrs)
+	return kubernetesNodeURLs(url, addrs)
+}
+
+// getEndpoints returns nil if the endpoints of the service are not found
+func (k *KubernetesRegistry) getEndpoints(ctx context.Context, service string) (*kubernetesEndpoints, error) {
+	res, err := k.do(ctx, k.client, "/api/v1/namespaces/"+k.namespace+"/endpoints/"+service)
+	if err != nil {
+		if se, ok := err.(*kubernetesStatusError); ok && se.code == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer res.Body.Close()
+	endpoints := &kubernetesEndpoints{}
+	if err = json.NewDecoder(res.Body).Decode(endpoints); err != nil {
+		return nil, err
+	}
+	return endpoints, nil
+}
+
+type kubernetesStatusError struct {
+	code    int
+	message string
+}
+
+func (e *kubernetesStatusError) Error() string {
+	return fmt.Sprintf("kubernetes response status %d: %s", e.code, e.message)
+}
+
+func (k *KubernetesRegistry) do(ctx context.Context, client *http.Client, api string) (*http.Response, error) {
+	if k.apiServer == "" {
+		return nil, errors.New("the api server is unknown")
+	}
+	req, err := http.NewRequest(http.MethodGet, k.apiServer+api, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	// the token of the service account is rotated, it is read for every request
+	if token, err := ioutil.ReadFile(k.tokenFile); err == nil {
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		return nil, &kubernetesStatusError{code: res.StatusCode, message: strings.TrimSpace(string(body))}
+	}
+	return res, nil
+}
+
+// SubscribeCommand is not supported, kubernetes has no command node
+func (k *KubernetesRegistry) SubscribeCommand(url *motan.URL, listener motan.CommandNotifyListener) {}
+
+func (k *KubernetesRegistry) UnSubscribeCommand(url *motan.URL, listener motan.CommandNotifyListener) {
+}
+
+func (k *KubernetesRegistry) DiscoverCommand(url *motan.URL) string {
+	return ""
+}
+
+func (k *KubernetesRegistry) GetURL() *motan.URL {
+	return k.url
+}
+
+func (k *KubernetesRegistry) SetURL(url *motan.URL) {
+	k.url = url
+}
+
+func (k *KubernetesRegistry) GetName() string {
+	return Kubernetes
+}
+
+func (k *KubernetesRegistry) IsAvailable() bool {
+	return atomic.LoadInt32(&k.available) == 1
+}
+
+func (k *KubernetesRegistry) setAvailable(available bool) {
+	if available {
+		atomic.StoreInt32(&k.available, 1)
+	} else {
+		atomic.StoreInt32(&k.available, 0)
+	}
+}
+
+func (k *KubernetesRegistry) StartSnapshot(conf *motan.SnapshotConf) {}
+
+func kubernetesServiceName(url *motan.URL) string {
+	return url.GetParam(KubernetesServiceKey, url.Path)
+}
+
+// kubernetesServiceKey is the key of the watch, the referers of the same service and port share the watch
+func kubernetesServiceKey(url *motan.URL) string {
+	return kubernetesServiceName(url) + ":" + url.GetParam(KubernetesPortKey, url.GetPortStr())
+}
+
+// kubernetesAddresses returns the sorted ready addresses of the endpoints with the port of the referer
+func kubernetesAddresses(url *motan.URL, endpoints *kubernetesEndpoints) []string {
+	portName := url.GetParam(KubernetesPortKey, "")
+	addrs := make([]string, 0, 16)
+	for _, subset := range endpoints.Subsets {
+		port := 0
+		for _, p := range subset.Ports {
+			if portName != "" && p.Name == portName || portName == "" && (len(subset.Ports) == 1 || p.Port == url.Port) {
+				port = p.Port
+				break
+			}
+		}
+		if port == 0 {
+			continue
+		}
+		for _, addr := range subset.Addresses {
+			addrs = append(addrs, addr.IP+":"+strconv.Itoa(port))
+		}
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+func kubernetesNodeURLs(url *motan.URL, addrs []string) []*motan.URL {
+	urls := make([]*motan.URL, 0, len(addrs))
+	for _, addr := range addrs {
+		i := strings.LastIndex(addr, ":")
+		if i < 0 {
+			continue
+		}
+		port, err := strconv.Atoi(addr[i+1:])
+		if err != nil {
+			continue
+		}
+		node := url.Copy()
+		node.Host = addr[:i]
+		node.Port = port
+		urls = append(urls, node)
+	}
+	return urls
+}
@@ -0,0 +1,216 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/registry/registrytest"
+)
+
+func TestKubernetesRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubernetes")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	assert.Nil(t, ioutil.WriteFile(tokenFile, []byte("test-token\n"), 0600))
+	kubernetes := newFakeKubernetes("test-ns", "hello-svc")
+	kubernetes.set(1, "10.0.0.1", "10.0.0.2")
+	server := httptest.NewServer(kubernetes)
+	defer server.Close()
+	registryURL := &motan.URL{Protocol: Kubernetes, Parameters: map[string]string{motan.AddressKey: server.URL,
+		KubernetesNamespaceKey: "test-ns", KubernetesTokenFileKey: tokenFile}}
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	RegistDefaultRegistry(ext)
+	registry := ext.GetRegistry(registryURL).(*KubernetesRegistry)
+	assert.Equal(t, Kubernetes, registry.GetName())
+	assert.True(t, registry.IsAvailable())
+
+	referer := &motan.URL{Protocol: "motan2", Group: "k8s", Path: "com.weibo.HelloService",
+		Parameters: map[string]string{KubernetesServiceKey: "hello-svc", KubernetesPortKey: "motan"}}
+	urls := registry.Discover(referer)
+	if assert.Equal(t, 2, len(urls)) {
+		assert.Equal(t, "10.0.0.1:8002", urls[0].GetAddressStr())
+		assert.Equal(t, "com.weibo.HelloService", urls[0].Path)
+		assert.Equal(t, "k8s", urls[0].Group)
+	}
+	assert.Equal(t, "Bearer test-token", kubernetes.lastToken())
+	assert.Equal(t, 0, len(registry.Discover(&motan.URL{Path: "unknown-svc", Parameters: map[string]string{}})))
+
+	// the changes are watched after the list
+	listener := &etcdTestListener{}
+	registry.Subscribe(referer, listener)
+	assert.True(t, listener.waitNotified(1))
+	assert.Equal(t, 2, len(listener.getURLs()))
+	for i := 0; i < 100 && kubernetes.watchCount() < 1; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.Equal(t, "1", kubernetes.lastWatchVersion())
+	kubernetes.push("MODIFIED", 2, "10.0.0.1", "10.0.0.2", "10.0.0.3")
+	assert.True(t, listener.waitNotified(2))
+	assert.Equal(t, 3, len(listener.getURLs()))
+	// the same nodes and the empty nodes are not notified
+	kubernetes.push("MODIFIED", 3, "10.0.0.3", "10.0.0.2", "10.0.0.1")
+	kubernetes.push("MODIFIED", 4)
+	assert.False(t, listener.waitNotified(3))
+
+	// the endpoints are listed again after the watch is broken
+	kubernetes.set(5, "10.0.0.4")
+	kubernetes.breakWatches()
+	assert.True(t, listener.waitNotified(3))
+	if assert.Equal(t, 1, len(listener.getURLs())) {
+		assert.Equal(t, "10.0.0.4:8002", listener.getURLs()[0].GetAddressStr())
+	}
+
+	registry.Unsubscribe(referer, listener)
+	for i := 0; i < 100 && kubernetes.watchCount() > 0; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.Equal(t, 0, kubernetes.watchCount())
+
+	// the registration is done by kubernetes
+	provider := &motan.URL{Protocol: "motan2", Group: "k8s", Path: "com.weibo.HelloService", Host: "10.0.0.9", Port: 8002, Parameters: map[string]string{}}
+	registry.Register(provider)
+	registry.Available(provider)
+	assert.Equal(t, 1, len(registry.GetRegisteredServices()))
+	registry.UnRegister(provider)
+	assert.Equal(t, 0, len(registry.GetRegisteredServices()))
+}
+
+func TestKubernetesAddresses(t *testing.T) {
+	endpoints := &kubernetesEndpoints{}
+	assert.Nil(t, json.Unmarshal([]byte(`{"subsets":[
+		{"addresses":[{"ip":"10.0.0.2"},{"ip":"10.0.0.1"}],"notReadyAddresses":[{"ip":"10.0.0.3"}],"ports":[{"name":"motan","port":8002},{"name":"admin","port":8080}]},
+		{"addresses":[{"ip":"10.0.1.1"}],"ports":[{"port":9000}]}]}`), endpoints))
+	assert.Equal(t, []string{"10.0.0.1:8002", "10.0.0.2:8002"}, kubernetesAddresses(&motan.URL{Parameters: map[string]string{KubernetesPortKey: "motan"}}, endpoints))
+	// the only port or the port of the referer is used without the port name
+	assert.Equal(t, []string{"10.0.0.1:8080", "10.0.0.2:8080", "10.0.1.1:9000"}, kubernetesAddresses(&motan.URL{Port: 8080, Parameters: map[string]string{}}, endpoints))
+	assert.Equal(t, []string{"10.0.1.1:9000"}, kubernetesAddresses(&motan.URL{Parameters: map[string]string{}}, endpoints))
+}
+
+func TestKubernetesRegistryConformance(t *testing.T) {
+	server := httptest.NewServer(newFakeKubernetes("test-ns", "hello-svc"))
+	defer server.Close()
+	registryURL := &motan.URL{Protocol: Kubernetes, Parameters: map[string]string{motan.AddressKey: server.URL, KubernetesNamespaceKey: "test-ns"}}
+	registrytest.Run(t, func() motan.Registry {
+		return newTestRegistry(registryURL)
+	})
+}
+
+// fakeKubernetes serves the endpoints apis of kubernetes for one service
+type fakeKubernetes struct {
+	lock      sync.Mutex
+	namespace string
+	service   string
+	endpoints string
+	token     string
+	version   string
+	watches   map[chan string]bool
+}
+
+func newFakeKubernetes(namespace string, service string) *fakeKubernetes {
+	return &fakeKubernetes{namespace: namespace, service: service, watches: make(map[chan string]bool)}
+}
+
+func fakeEndpoints(version int, ips ...string) string {
+	addresses := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		addresses = append(addresses, `{"ip":"`+ip+`"}`)
+	}
+	return fmt.Sprintf(`{"metadata":{"name":"hello-svc","resourceVersion":"%d"},"subsets":[{"addresses":[%s],"ports":[{"name":"admin","port":8080},{"name":"motan","port":8002}]}]}`,
+		version, strings.Join(addresses, ","))
+}
+
+func (f *fakeKubernetes) set(version int, ips ...string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.endpoints = fakeEndpoints(version, ips...)
+}
+
+func (f *fakeKubernetes) push(eventType string, version int, ips ...string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for w := range f.watches {
+		w <- `{"type":"` + eventType + `","object":` + fakeEndpoints(version, ips...) + `}`
+	}
+}
+
+func (f *fakeKubernetes) breakWatches() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for w := range f.watches {
+		close(w)
+		delete(f.watches, w)
+	}
+}
+
+func (f *fakeKubernetes) watchCount() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return len(f.watches)
+}
+
+func (f *fakeKubernetes) lastToken() string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.token
+}
+
+func (f *fakeKubernetes) lastWatchVersion() string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.version
+}
+
+func (f *fakeKubernetes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	f.token = r.Header.Get("Authorization")
+	f.lock.Unlock()
+	prefix := "/api/v1/namespaces/" + f.namespace + "/endpoints"
+	switch {
+	case r.URL.Path == prefix+"/"+f.service:
+		f.lock.Lock()
+		endpoints := f.endpoints
+		f.lock.Unlock()
+		w.Write([]byte(endpoints))
+	case r.URL.Path == prefix && r.URL.Query().Get("watch") == "true" && r.URL.Query().Get("fieldSelector") == "metadata.name="+f.service:
+		events := make(chan string, 10)
+		f.lock.Lock()
+		f.version = r.URL.Query().Get("resourceVersion")
+		f.watches[events] = true
+		f.lock.Unlock()
+		defer func() {
+			f.lock.Lock()
+			delete(f.watches, events)
+			f.lock.Unlock()
+		}()
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+				w.Write([]byte(event + "\n"))
+				w.(http.Flusher).Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"kind":"Status","code":404}`))
+	}
+}
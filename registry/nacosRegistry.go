@@ -0,0 +1,583 @@
+package registry
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	URL "net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// nacos options of the registry url parameters
+const (
+	NacosNamespaceKey = "namespace"         // the namespace id of the services, the public namespace if absent
+	NacosClusterKey   = "cluster"           // the cluster name of the registered instances, DEFAULT if absent
+	NacosBeatKey      = "heartbeatInterval" // the interval(ms) of the instance heartbeat if the server does not tell it
+)
+
+const (
+	nacosDefaultGroup        = "DEFAULT_GROUP"
+	nacosDefaultCluster      = "DEFAULT"
+	nacosDefaultBeatInterval = 5 * time.Second
+	nacosDefaultCacheMillis  = 10 * 1000
+	nacosExtInfoKey          = "extInfo"
+	nacosGroupSeparator      = "@@"
+	nacosResourceNotFound    = 20404
+
+	// nacos open apis
+	nacosInstanceAPI     = "/nacos/v1/ns/instance"
+	nacosBeatAPI         = "/nacos/v1/ns/instance/beat"
+	nacosInstanceListAPI = "/nacos/v1/ns/instance/list"
+)
+
+// NacosRegistry is a registry based on the nacos open api, the group of the url is the nacos group and the path is the
+// service name. the registered instances are ephemeral, they are kept alive by the heartbeat and disabled when the url
+// is unavailable. the subscribed services are pushed by nacos over udp, and they are pulled again every cacheMillis of
+// the service in case the pushes are lost
+type NacosRegistry struct {
+	url                  *motan.URL
+	available            int32
+	addrs                []string
+	addrIndex            uint32
+	namespace            string
+	cluster              string
+	beatInterval         time.Duration
+	client               *http.Client
+	udpConn              *net.UDPConn
+	registerLock         sync.Mutex
+	subscribeLock        sync.Mutex
+	registeredServiceMap map[string]*motan.URL                          // save all registered services
+	availableServiceMap  map[string]*motan.URL                          // save all available services
+	subscribedServiceMap map[string]map[motan.NotifyListener]*motan.URL // save all subscribed services with listeners
+	lastRefTimes         map[string]int64                               // save the last update time of the subscribed services
+	pullerStops          map[string]chan struct{}                       // save the stop channels of the pullers
+}
+
+type nacosInstance struct {
+	IP       string            `json:"ip"`
+	Port     int               `json:"port"`
+	Weight   float64           `json:"weight"`
+	Healthy  bool              `json:"healthy"`
+	Enabled  bool              `json:"enabled"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+type nacosServiceInfo struct {
+	Name        string          `json:"name"`
+	Hosts       []nacosInstance `json:"hosts"`
+	LastRefTime int64           `json:"lastRefTime"`
+	CacheMillis int64           `json:"cacheMillis"`
+}
+
+type nacosBeatInfo struct {
+	ServiceName string            `json:"serviceName"`
+	IP          string            `json:"ip"`
+	Port        int               `json:"port"`
+	Cluster     string            `json:"cluster"`
+	Weight      float64           `json:"weight"`
+	Metadata    map[string]string `json:"metadata"`
+	Scheduled   bool              `json:"scheduled"`
+}
+
+type nacosBeatResponse struct {
+	ClientBeatInterval int64 `json:"clientBeatInterval"`
+	Code               int   `json:"code"`
+}
+
+type nacosPushPacket struct {
+	Type        string `json:"type"`
+	Data        string `json:"data"`
+	LastRefTime int64  `json:"lastRefTime"`
+}
+
+// Initialize listens the udp port for the pushes and starts the heartbeat
+func (n *NacosRegistry) Initialize() {
+	n.namespace = n.url.GetParam(NacosNamespaceKey, "")
+	n.cluster = n.url.GetParam(NacosClusterKey, nacosDefaultCluster)
+	n.beatInterval = n.url.GetTimeDuration(NacosBeatKey, time.Millisecond, nacosDefaultBeatInterval)
+	n.registeredServiceMap = make(map[string]*motan.URL)
+	n.availableServiceMap = make(map[string]*motan.URL)
+	n.subscribedServiceMap = make(map[string]map[motan.NotifyListener]*motan.URL)
+	n.lastRefTimes = make(map[string]int64)
+	n.pullerStops = make(map[string]chan struct{})
+	if len(n.url.Host) > 0 && n.url.Port > 0 {
+		n.addrs = append(n.addrs, n.url.GetAddressStr())
+	} else if addrString, exist := n.url.Parameters[motan.AddressKey]; exist {
+		n.addrs = motan.TrimSplit(addrString, ",")
+	}
+	n.client = &http.Client{Timeout: n.url.GetTimeDuration(motan.TimeOutKey, time.Millisecond, DefaultTimeout*time.Millisecond)}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		vlog.Errorf("[NacosRegistry] listen udp for push error, the subscribed services are only pulled. err:%v", err)
+	} else {
+		n.udpConn = conn
+		go n.receivePush()
+	}
+	n.setAvailable(true)
+	go n.heartbeat()
+}
+
+// Register registers the url as a disabled instance, the instance is enabled when the url is available
+func (n *NacosRegistry) Register(url *motan.URL) {
+	n.registerLock.Lock()
+	defer n.registerLock.Unlock()
+	if _, ok := n.registeredServiceMap[url.GetIdentity()]; ok {
+		return
+	}
+	vlog.Infof("[NacosRegistry] register service. url:%s", url.GetIdentity())
+	if err := n.registerInstance(url, false); err != nil {
+		vlog.Errorf("[NacosRegistry] register service error. url:%s, err:%v", url.GetIdentity(), err)
+	}
+	n.registeredServiceMap[url.GetIdentity()] = url
+}
+
+// TryRegister registers the url, it fails if the nacos is unavailable, so the registration can be retried
+func (n *NacosRegistry) TryRegister(url *motan.URL) error {
+	n.registerLock.Lock()
+	defer n.registerLock.Unlock()
+	if _, ok := n.registeredServiceMap[url.GetIdentity()]; ok {
+		return nil
+	}
+	if err := n.registerInstance(url, false); err != nil {
+		return err
+	}
+	vlog.Infof("[NacosRegistry] register service. url:%s", url.GetIdentity())
+	n.registeredServiceMap[url.GetIdentity()] = url
+	return nil
+}
+
+// UnRegister deregisters the instance of the url
+func (n *NacosRegistry) UnRegister(url *motan.URL) {
+	n.registerLock.Lock()
+	defer n.registerLock.Unlock()
+	if _, ok := n.registeredServiceMap[url.GetIdentity()]; !ok {
+		return
+	}
+	vlog.Infof("[NacosRegistry] unregister service. url:%s", url.GetIdentity())
+	if err := n.call(http.MethodDelete, nacosInstanceAPI, n.instanceParams(url, false), nil); err != nil {
+		vlog.Errorf("[NacosRegistry] unregister service error. url:%s, err:%v", url.GetIdentity(), err)
+	}
+	delete(n.registeredServiceMap, url.GetIdentity())
+	delete(n.availableServiceMap, url.GetIdentity())
+}
+
+// Available enables the instance of the url, all registered services if the url is nil
+func (n *NacosRegistry) Available(url *motan.URL) {
+	n.registerLock.Lock()
+	defer n.registerLock.Unlock()
+	if url == nil {
+		vlog.Infof("[NacosRegistry] available all services:%v", n.registeredServiceMap)
+		for _, u := range n.registeredServiceMap {
+			n.availableServiceMap[u.GetIdentity()] = u
+			n.updateInstance(u, true)
+		}
+		return
+	}
+	vlog.Infof("[NacosRegistry] available service:%s", url.GetIdentity())
+	n.availableServiceMap[url.GetIdentity()] = url
+	n.updateInstance(url, true)
+}
+
+// Unavailable disables the instance of the url, all registered services if the url is nil
+func (n *NacosRegistry) Unavailable(url *motan.URL) {
+	n.registerLock.Lock()
+	defer n.registerLock.Unlock()
+	if url == nil {
+		vlog.Infof("[NacosRegistry] unavailable all services:%v", n.registeredServiceMap)
+		for _, u := range n.registeredServiceMap {
+			delete(n.availableServiceMap, u.GetIdentity())
+			n.updateInstance(u, false)
+		}
+		return
+	}
+	vlog.Infof("[NacosRegistry] unavailable service. url:%s", url.GetIdentity())
+	delete(n.availableServiceMap, url.GetIdentity())
+	n.updateInstance(url, false)
+}
+
+func (n *NacosRegistry) updateInstance(url *motan.URL, enabled bool) {
+	if err := n.call(http.MethodPut, nacosInstanceAPI, n.instanceParams(url, enabled), nil); err != nil {
+		vlog.Errorf("[NacosRegistry] update service error. url:%s, enabled:%v, err:%v", url.GetIdentity(), enabled, err)
+	}
+}
+
+func (n *NacosRegistry) registerInstance(url *motan.URL, enabled bool) error {
+	return n.call(http.MethodPost, nacosInstanceAPI, n.instanceParams(url, enabled), nil)
+}
+
+// GetRegisteredServices returns all registered services
+func (n *NacosRegistry) GetRegisteredServices() []*motan.URL {
+	n.registerLock.Lock()
+	defer n.registerLock.Unlock()
+	urls := make([]*motan.URL, 0, len(n.registeredServiceMap))
+	for _, u := range n.registeredServiceMap {
+		urls = append(urls, u)
+	}
+	return urls
+}
+
+// heartbeat sends the beats of the registered instances, the instance is registered again if nacos has removed it
+func (n *NacosRegistry) heartbeat() {
+	defer motan.HandlePanic(nil)
+	interval := n.beatInterval
+	for {
+		time.Sleep(interval)
+		n.registerLock.Lock()
+		urls := make([]*motan.URL, 0, len(n.registeredServiceMap))
+		for _, u := range n.registeredServiceMap {
+			urls = append(urls, u)
+		}
+		n.registerLock.Unlock()
+		failed := false
+		for _, u := range urls {
+			res, err := n.beat(u)
+			if err != nil {
+				vlog.Errorf("[NacosRegistry] send beat error. url:%s, err:%v", u.GetIdentity(), err)
+				failed = true
+				continue
+			}
+			if res.ClientBeatInterval > 0 {
+				interval = time.Duration(res.ClientBeatInterval) * time.Millisecond
+			}
+			if res.Code == nacosResourceNotFound {
+				n.registerLock.Lock()
+				_, registered := n.registeredServiceMap[u.GetIdentity()]
+				_, available := n.availableServiceMap[u.GetIdentity()]
+				if registered {
+					vlog.Warningf("[NacosRegistry] instance is not found, register it again. url:%s", u.GetIdentity())
+					if err = n.registerInstance(u, available); err != nil {
+						vlog.Errorf("[NacosRegistry] register service error. url:%s, err:%v", u.GetIdentity(), err)
+					}
+				}
+				n.registerLock.Unlock()
+			}
+		}
+		n.setAvailable(!failed)
+	}
+}
+
+func (n *NacosRegistry) beat(url *motan.URL) (*nacosBeatResponse, error) {
+	beat, _ := json.Marshal(&nacosBeatInfo{ServiceName: nacosServiceName(url), IP: url.Host, Port: int(url.Port), Cluster: n.cluster,
+		Weight: nacosWeight(url), Metadata: nacosMetadata(url), Scheduled: true})
+	params := URL.Values{}
+	params.Set("serviceName", nacosServiceName(url))
+	params.Set("namespaceId", n.namespace)
+	params.Set("groupName", nacosGroup(url))
+	params.Set("ephemeral", "true")
+	params.Set("beat", string(beat))
+	res := &nacosBeatResponse{}
+	if err := n.call(http.MethodPut, nacosBeatAPI, params, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Subscribe pulls the service with the udp port for the pushes, and pulls it again every cacheMillis. the listeners are
+// notified when the instances are changed
+func (n *NacosRegistry) Subscribe(url *motan.URL, listener motan.NotifyListener) {
+	n.subscribeLock.Lock()
+	defer n.subscribeLock.Unlock()
+	key := nacosServiceKey(url)
+	if listeners, ok := n.subscribedServiceMap[key]; ok {
+		listeners[listener] = url
+		vlog.Infof("[NacosRegistry] subscribe service success. service:%s, listener:%s", key, listener.GetIdentity())
+		return
+	}
+	n.subscribedServiceMap[key] = map[motan.NotifyListener]*motan.URL{listener: url}
+	vlog.Infof("[NacosRegistry] subscribe service. url:%s", url.GetIdentity())
+	stop := make(chan struct{})
+	n.pullerStops[key] = stop
+	go n.pull(url, stop)
+}
+
+func (n *NacosRegistry) pull(url *motan.URL, stop chan struct{}) {
+	defer motan.HandlePanic(nil)
+	for {
+		interval := time.Duration(nacosDefaultCacheMillis) * time.Millisecond
+		if info, err := n.queryInstances(url, true); err != nil {
+			vlog.Errorf("[NacosRegistry] pull service error. url:%s, err:%v", url.GetIdentity(), err)
+		} else {
+			if info.CacheMillis > 0 {
+				interval = time.Duration(info.CacheMillis) * time.Millisecond
+			}
+			n.update(nacosServiceKey(url), info)
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// update notifies the listeners of the service if the service info is newer than the last one
+func (n *NacosRegistry) update(key string, info *nacosServiceInfo) {
+	n.subscribeLock.Lock()
+	listeners, ok := n.subscribedServiceMap[key]
+	if !ok || info.LastRefTime <= n.lastRefTimes[key] && info.LastRefTime != 0 {
+		n.subscribeLock.Unlock()
+		return
+	}
+	n.lastRefTimes[key] = info.LastRefTime
+	notifies := make(map[motan.NotifyListener]*motan.URL, len(listeners))
+	for lis, u := range listeners {
+		notifies[lis] = u
+	}
+	n.subscribeLock.Unlock()
+	for lis, u := range notifies {
+		urls := n.toURLs(u, info)
+		if len(urls) > 0 {
+			lis.Notify(n.url, urls)
+		}
+	}
+	vlog.Infof("[NacosRegistry] notify nodes. service:%s, size:%d", key, len(info.Hosts))
+}
+
+// receivePush receives the pushes of the subscribed services and acknowledges them
+func (n *NacosRegistry) receivePush() {
+	defer motan.HandlePanic(nil)
+	buf := make([]byte, 64*1024)
+	for {
+		size, addr, err := n.udpConn.ReadFromUDP(buf)
+		if err != nil {
+			vlog.Errorf("[NacosRegistry] receive push error, stop receiving. err:%v", err)
+			return
+		}
+		data, err := nacosPushData(buf[:size])
+		if err != nil {
+			vlog.Warningf("[NacosRegistry] illegal push packet. from:%v, err:%v", addr, err)
+			continue
+		}
+		packet := &nacosPushPacket{}
+		if err = json.Unmarshal(data, packet); err != nil {
+			vlog.Warningf("[NacosRegistry] illegal push packet. from:%v, err:%v", addr, err)
+			continue
+		}
+		ack := map[string]interface{}{"type": "unknown-ack", "lastRefTime": packet.LastRefTime, "data": ""}
+		if packet.Type == "dom" || packet.Type == "service" {
+			ack["type"] = "push-ack"
+			info := &nacosServiceInfo{}
+			if err = json.Unmarshal([]byte(packet.Data), info); err == nil {
+				n.update(info.Name, info)
+			} else {
+				vlog.Warningf("[NacosRegistry] illegal pushed service. from:%v, err:%v", addr, err)
+			}
+		}
+		out, _ := json.Marshal(ack)
+		n.udpConn.WriteToUDP(out, addr)
+	}
+}
+
+// Unsubscribe removes the listener of the service, the pulling is stopped with the last listener
+func (n *NacosRegistry) Unsubscribe(url *motan.URL, listener motan.NotifyListener) {
+	n.subscribeLock.Lock()
+	defer n.subscribeLock.Unlock()
+	key := nacosServiceKey(url)
+	if listeners, ok := n.subscribedServiceMap[key]; ok {
+		vlog.Infof("[NacosRegistry] unsubscribe service. url:%s", url.GetIdentity())
+		delete(listeners, listener)
+		if len(listeners) < 1 {
+			delete(n.subscribedServiceMap, key)
+			delete(n.lastRefTimes, key)
+			close(n.pullerStops[key])
+			delete(n.pullerStops, key)
+		}
+	}
+}
+
+// Discover returns all enabled and healthy instances of the service
+func (n *NacosRegistry) Discover(url *motan.URL) []*motan.URL {
+	info, err := n.queryInstances(url, false)
+	if err != nil {
+		vlog.Errorf("[NacosRegistry] discover service error! url:%s, err:%v", url.GetIdentity(), err)
+		return nil
+	}
+	return n.toURLs(url, info)
+}
+
+func (n *NacosRegistry) queryInstances(url *motan.URL, subscribe bool) (*nacosServiceInfo, error) {
+	params := URL.Values{}
+	params.Set("serviceName", nacosServiceName(url))
+	params.Set("namespaceId", n.namespace)
+	params.Set("groupName", nacosGroup(url))
+	params.Set("healthyOnly", "false")
+	if subscribe && n.udpConn != nil {
+		params.Set("clientIP", motan.GetLocalIP())
+		params.Set("udpPort", strconv.Itoa(n.udpConn.LocalAddr().(*net.UDPAddr).Port))
+	}
+	info := &nacosServiceInfo{}
+	if err := n.call(http.MethodGet, nacosInstanceListAPI, params, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (n *NacosRegistry) toURLs(url *motan.URL, info *nacosServiceInfo) []*motan.URL {
+	urls := make([]*motan.URL, 0, len(info.Hosts))
+	nodeInfos := make([]SnapshotNodeInfo, 0, len(info.Hosts))
+	for _, host := range info.Hosts {
+		if !host.Enabled || !host.Healthy {
+			continue
+		}
+		var node *motan.URL
+		extInfo := host.Metadata[nacosExtInfoKey]
+		if extInfo != "" {
+			node = motan.FromExtInfo(extInfo)
+		}
+		if node == nil {
+			// the instances registered by others, e.g. the java sdk of nacos
+			node = url.Copy()
+			node.Host = host.IP
+			node.Port = host.Port
+		}
+		urls = append(urls, node)
+		nodeInfos = append(nodeInfos, SnapshotNodeInfo{Addr: host.IP + ":" + strconv.Itoa(host.Port), ExtInfo: extInfo})
+	}
+	SaveSnapshot(n.url.GetIdentity(), GetNodeKey(url), ServiceNode{Group: url.Group, Path: url.Path, Nodes: nodeInfos})
+	return urls
+}
+
+// SubscribeCommand is not supported, nacos has no command node
+func (n *NacosRegistry) SubscribeCommand(url *motan.URL, listener motan.CommandNotifyListener) {}
+
+func (n *NacosRegistry) UnSubscribeCommand(url *motan.URL, listener motan.CommandNotifyListener) {}
+
+func (n *NacosRegistry) DiscoverCommand(url *motan.URL) string {
+	return ""
+}
+
+func (n *NacosRegistry) GetURL() *motan.URL {
+	return n.url
+}
+
+func (n *NacosRegistry) SetURL(url *motan.URL) {
+	n.url = url
+}
+
+func (n *NacosRegistry) GetName() string {
+	return Nacos
+}
+
+func (n *NacosRegistry) IsAvailable() bool {
+	return atomic.LoadInt32(&n.available) == 1
+}
+
+func (n *NacosRegistry) setAvailable(available bool) {
+	if available {
+		atomic.StoreInt32(&n.available, 1)
+	} else {
+		atomic.StoreInt32(&n.available, 0)
+	}
+}
+
+func (n *NacosRegistry) StartSnapshot(conf *motan.SnapshotConf) {}
+
+func (n *NacosRegistry) instanceParams(url *motan.URL, enabled bool) URL.Values {
+	metadata, _ := json.Marshal(nacosMetadata(url))
+	params := URL.Values{}
+	params.Set("serviceName", nacosServiceName(url))
+	params.Set("namespaceId", n.namespace)
+	params.Set("groupName", nacosGroup(url))
+	params.Set("clusterName", n.cluster)
+	params.Set("ip", url.Host)
+	params.Set("port", url.GetPortStr())
+	params.Set("weight", strconv.FormatFloat(nacosWeight(url), 'f', -1, 64))
+	params.Set("enabled", strconv.FormatBool(enabled))
+	params.Set("healthy", "true")
+	params.Set("ephemeral", "true")
+	params.Set("metadata", string(metadata))
+	return params
+}
+
+// call requests the api of nacos with the params in the query, the next address is used by the following calls if
+// the nacos can not be connected
+func (n *NacosRegistry) call(method string, api string, params URL.Values, response interface{}) error {
+	req, err := http.NewRequest(method, n.endpoint()+api+"?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	res, err := n.client.Do(req)
+	if err != nil {
+		atomic.AddUint32(&n.addrIndex, 1)
+		return err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("nacos response status %d: %s", res.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if response == nil {
+		return nil
+	}
+	return json.Unmarshal(body, response)
+}
+
+func (n *NacosRegistry) endpoint() string {
+	if len(n.addrs) == 0 {
+		return "http://127.0.0.1:8848"
+	}
+	addr := n.addrs[int(atomic.LoadUint32(&n.addrIndex))%len(n.addrs)]
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return addr
+}
+
+func nacosServiceName(url *motan.URL) string {
+	return url.Path
+}
+
+func nacosGroup(url *motan.URL) string {
+	if url.Group == "" {
+		return nacosDefaultGroup
+	}
+	return url.Group
+}
+
+// nacosServiceKey is the name of the pushed service
+func nacosServiceKey(url *motan.URL) string {
+	return nacosGroup(url) + nacosGroupSeparator + nacosServiceName(url)
+}
+
+func nacosWeight(url *motan.URL) float64 {
+	if weight, err := strconv.ParseFloat(url.GetParam(motan.WeightKey, ""), 64); err == nil && weight > 0 {
+		return weight
+	}
+	return 1
+}
+
+func nacosMetadata(url *motan.URL) map[string]string {
+	return map[string]string{nacosExtInfoKey: url.ToExtInfo(), motan.NodeTypeKey: url.GetParam(motan.NodeTypeKey, motan.NodeTypeService)}
+}
+
+// nacosPushData returns the push packet, it is gzipped if it is large
+func nacosPushData(packet []byte) ([]byte, error) {
+	if len(packet) > 2 && packet[0] == 0x1f && packet[1] == 0x8b {
+		reader, err := gzip.NewReader(bytes.NewReader(packet))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return ioutil.ReadAll(io.LimitReader(reader, 1<<20))
+	}
+	if len(packet) == 0 {
+		return nil, errors.New("empty packet")
+	}
+	return packet, nil
+}
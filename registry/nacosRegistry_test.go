@@ -0,0 +1,210 @@
+package registry
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/registry/registrytest"
+)
+
+func TestNacosRegistry(t *testing.T) {
+	nacos := newFakeNacos()
+	server := httptest.NewServer(nacos)
+	defer server.Close()
+	registryURL := &motan.URL{Protocol: Nacos, Parameters: map[string]string{motan.AddressKey: server.Listener.Addr().String(),
+		NacosNamespaceKey: "test-ns", NacosBeatKey: "50"}}
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	RegistDefaultRegistry(ext)
+	registry := ext.GetRegistry(registryURL).(*NacosRegistry)
+	assert.Equal(t, Nacos, registry.GetName())
+
+	url := &motan.URL{Protocol: "motan", Group: "nacosGroup", Path: "nacosPath", Host: "127.0.0.1", Port: 1234, Parameters: map[string]string{}}
+	assert.Nil(t, registry.TryRegister(url))
+	instance, ok := nacos.instance("127.0.0.1:1234")
+	assert.True(t, ok)
+	assert.False(t, instance.Enabled)
+	assert.Equal(t, "test-ns", nacos.lastNamespace())
+	assert.Equal(t, url.ToExtInfo(), instance.Metadata[nacosExtInfoKey])
+
+	// the disabled instance is not discovered
+	referer := &motan.URL{Protocol: "motan", Group: "nacosGroup", Path: "nacosPath", Parameters: map[string]string{}}
+	assert.Equal(t, 0, len(registry.Discover(referer)))
+	registry.Available(nil)
+	instance, _ = nacos.instance("127.0.0.1:1234")
+	assert.True(t, instance.Enabled)
+	urls := registry.Discover(referer)
+	assert.Equal(t, 1, len(urls))
+	assert.Equal(t, url.GetIdentity(), urls[0].GetIdentity())
+
+	// the instance is registered again if it is not found by the heartbeat
+	for i := 0; i < 100 && nacos.beatCount() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, nacos.beatCount() > 0)
+	nacos.remove("127.0.0.1:1234")
+	for i := 0; i < 100; i++ {
+		if _, ok = nacos.instance("127.0.0.1:1234"); ok {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	instance, ok = nacos.instance("127.0.0.1:1234")
+	assert.True(t, ok)
+	assert.True(t, instance.Enabled)
+
+	// the subscribed service is pushed over udp
+	listener := &etcdTestListener{}
+	registry.Subscribe(referer, listener)
+	assert.True(t, listener.waitNotified(1))
+	assert.Equal(t, 1, len(listener.getURLs()))
+	udpPort := nacos.udpPort()
+	assert.NotEqual(t, 0, udpPort)
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: udpPort})
+	assert.Nil(t, err)
+	defer conn.Close()
+	other := url.Copy()
+	other.Port = 1235
+	info := &nacosServiceInfo{Name: nacosServiceKey(referer), LastRefTime: time.Now().UnixNano(), Hosts: []nacosInstance{
+		{IP: "127.0.0.1", Port: 1234, Enabled: true, Healthy: true, Metadata: nacosMetadata(url)},
+		{IP: "127.0.0.1", Port: 1235, Enabled: true, Healthy: true, Metadata: nacosMetadata(other)},
+		{IP: "127.0.0.1", Port: 1236, Enabled: true, Healthy: false},
+	}}
+	data, _ := json.Marshal(info)
+	packet, _ := json.Marshal(&nacosPushPacket{Type: "dom", Data: string(data), LastRefTime: info.LastRefTime})
+	_, err = conn.Write(packet)
+	assert.Nil(t, err)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1024)
+	size, err := conn.Read(buf)
+	assert.Nil(t, err)
+	ack := &nacosPushPacket{}
+	assert.Nil(t, json.Unmarshal(buf[:size], ack))
+	assert.Equal(t, "push-ack", ack.Type)
+	assert.Equal(t, info.LastRefTime, ack.LastRefTime)
+	assert.True(t, listener.waitNotified(2))
+	assert.Equal(t, 2, len(listener.getURLs()))
+
+	// the outdated push is ignored
+	info.LastRefTime--
+	data, _ = json.Marshal(info)
+	packet, _ = json.Marshal(&nacosPushPacket{Type: "dom", Data: string(data), LastRefTime: info.LastRefTime})
+	conn.Write(packet)
+	conn.Read(buf)
+	assert.False(t, listener.waitNotified(3))
+	registry.Unsubscribe(referer, listener)
+
+	registry.Unavailable(url)
+	instance, _ = nacos.instance("127.0.0.1:1234")
+	assert.False(t, instance.Enabled)
+	registry.UnRegister(url)
+	_, ok = nacos.instance("127.0.0.1:1234")
+	assert.False(t, ok)
+	assert.Equal(t, 0, len(registry.GetRegisteredServices()))
+}
+
+func TestNacosRegistryConformance(t *testing.T) {
+	server := httptest.NewServer(newFakeNacos())
+	defer server.Close()
+	registryURL := &motan.URL{Protocol: Nacos, Parameters: map[string]string{motan.AddressKey: server.Listener.Addr().String(),
+		NacosNamespaceKey: "test-ns", NacosBeatKey: "50"}}
+	registrytest.Run(t, func() motan.Registry {
+		return newTestRegistry(registryURL)
+	})
+}
+
+// fakeNacos serves the instance apis of nacos used by the registry for one service
+type fakeNacos struct {
+	lock      sync.Mutex
+	instances map[string]*nacosInstance
+	beats     int
+	namespace string
+	port      int
+}
+
+func newFakeNacos() *fakeNacos {
+	return &fakeNacos{instances: map[string]*nacosInstance{}}
+}
+
+func (f *fakeNacos) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.namespace = query.Get("namespaceId")
+	addr := query.Get("ip") + ":" + query.Get("port")
+	switch r.URL.Path {
+	case nacosInstanceAPI:
+		switch r.Method {
+		case http.MethodPost, http.MethodPut:
+			port, _ := strconv.Atoi(query.Get("port"))
+			instance := &nacosInstance{IP: query.Get("ip"), Port: port, Healthy: true, Enabled: query.Get("enabled") == "true"}
+			json.Unmarshal([]byte(query.Get("metadata")), &instance.Metadata)
+			f.instances[addr] = instance
+		case http.MethodDelete:
+			delete(f.instances, addr)
+		}
+		w.Write([]byte("ok"))
+	case nacosBeatAPI:
+		f.beats++
+		beat := &nacosBeatInfo{}
+		json.Unmarshal([]byte(query.Get("beat")), beat)
+		code := 10200
+		if _, ok := f.instances[beat.IP+":"+strconv.Itoa(beat.Port)]; !ok {
+			code = nacosResourceNotFound
+		}
+		json.NewEncoder(w).Encode(&nacosBeatResponse{ClientBeatInterval: 50, Code: code})
+	case nacosInstanceListAPI:
+		if port := query.Get("udpPort"); port != "" {
+			f.port, _ = strconv.Atoi(port)
+		}
+		info := &nacosServiceInfo{Name: query.Get("groupName") + nacosGroupSeparator + query.Get("serviceName"), LastRefTime: 1}
+		for _, instance := range f.instances {
+			info.Hosts = append(info.Hosts, *instance)
+		}
+		json.NewEncoder(w).Encode(info)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeNacos) instance(addr string) (nacosInstance, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	instance, ok := f.instances[addr]
+	if !ok {
+		return nacosInstance{}, false
+	}
+	return *instance, true
+}
+
+func (f *fakeNacos) remove(addr string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.instances, addr)
+}
+
+func (f *fakeNacos) beatCount() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.beats
+}
+
+func (f *fakeNacos) lastNamespace() string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.namespace
+}
+
+func (f *fakeNacos) udpPort() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.port
+}
@@ -27,6 +27,10 @@ const (
 	Consul = "consul"
 	ZK     = "zookeeper"
 	Mesh   = "mesh"
+	Etcd   = "etcd"
+	Nacos  = "nacos"
+	// Kubernetes discovers the providers by the endpoints of the kubernetes services
+	Kubernetes = "kubernetes"
 )
 
 type SnapshotNodeInfo struct {
@@ -48,7 +52,10 @@ var (
 )
 
 func CheckSnapshotDir() {
-	dir := snapshotConf.SnapshotDir
+	checkSnapshotDir(snapshotConf.SnapshotDir)
+}
+
+func checkSnapshotDir(dir string) {
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
 		if err := os.Mkdir(dir, 0775); err != nil {
 			vlog.Errorf("registry make directory error. dir:%s, err:%s", dir, err.Error())
@@ -105,11 +112,23 @@ func RegistDefaultRegistry(extFactory motan.ExtensionFactory) {
 	})
 
 	extFactory.RegistExtRegistry(ZK, func(url *motan.URL) motan.Registry {
-		return &ZkRegistry{url: url}
+		return newRemoteRegistry(url, &ZkRegistry{url: url})
 	})
 
 	extFactory.RegistExtRegistry(Consul, func(url *motan.URL) motan.Registry {
-		return &ConsulRegistry{url: url}
+		return newRemoteRegistry(url, &ConsulRegistry{url: url})
+	})
+
+	extFactory.RegistExtRegistry(Etcd, func(url *motan.URL) motan.Registry {
+		return newRemoteRegistry(url, &EtcdRegistry{url: url})
+	})
+
+	extFactory.RegistExtRegistry(Nacos, func(url *motan.URL) motan.Registry {
+		return newRemoteRegistry(url, &NacosRegistry{url: url})
+	})
+
+	extFactory.RegistExtRegistry(Kubernetes, func(url *motan.URL) motan.Registry {
+		return &KubernetesRegistry{url: url}
 	})
 
 	extFactory.RegistExtRegistry(Mesh, func(url *motan.URL) motan.Registry {
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/registry/registrytest"
 )
 
 func TestGetRegistry(t *testing.T) {
@@ -23,3 +24,29 @@ func TestGetRegistry(t *testing.T) {
 		t.Error("GetName Error")
 	}
 }
+
+func TestRegistryConformance(t *testing.T) {
+	registryURL := &motan.URL{Protocol: "direct", Host: "127.0.0.1", Port: 4072, Parameters: make(map[string]string)}
+	t.Run("local", func(t *testing.T) {
+		registrytest.Run(t, func() motan.Registry {
+			r := &LocalRegistry{}
+			r.SetURL(registryURL)
+			return r
+		})
+	})
+	t.Run("direct", func(t *testing.T) {
+		registrytest.Run(t, func() motan.Registry {
+			r := &DirectRegistry{}
+			r.SetURL(registryURL)
+			return r
+		})
+	})
+}
+
+// newTestRegistry returns a new registry of the url by the default registry factories, the registries are not shared
+func newTestRegistry(url *motan.URL) motan.Registry {
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	RegistDefaultRegistry(ext)
+	return ext.GetRegistry(url)
+}
@@ -0,0 +1,105 @@
+// Package registrytest is the conformance test suite of the motan.Registry contract, registry implementations can run it:
+//
+//	func TestConformance(t *testing.T) {
+//		registrytest.Run(t, func() motan.Registry { return newMyRegistry() })
+//	}
+package registrytest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+)
+
+type testListener struct {
+	id string
+}
+
+func (l *testListener) GetIdentity() string {
+	return l.id
+}
+
+func (l *testListener) Notify(registryURL *motan.URL, urls []*motan.URL) {
+}
+
+// NewServerURL returns the url registered by the suite
+func NewServerURL(path string) *motan.URL {
+	return &motan.URL{Protocol: "motan2", Host: "127.0.0.1", Port: 64590, Path: path, Group: "registrytest",
+		Parameters: map[string]string{motan.NodeTypeKey: motan.NodeTypeService}}
+}
+
+// Run checks the registry created by newRegistry follows the contract, a new registry is created for each case:
+//   - Register and UnRegister are idempotent
+//   - UnRegister, Available and Unavailable of a url not registered are no-ops
+//   - GetRegisteredServices contains a registered url exactly once, and does not contain an unregistered url.
+//     registries which do not keep registrations(e.g. direct registry) return nil
+//   - Subscribe and Unsubscribe are idempotent, Unsubscribe of a listener not subscribed is a no-op
+func Run(t *testing.T, newRegistry func() motan.Registry) {
+	t.Run("RegisterTwice", func(t *testing.T) {
+		r := newRegistry()
+		url := NewServerURL("registrytest.twice")
+		r.Register(url)
+		r.Register(url)
+		if registered := r.GetRegisteredServices(); registered != nil {
+			assert.Equal(t, 1, count(registered, url), "registered url should be listed once")
+		}
+		r.UnRegister(url)
+		assert.Equal(t, 0, count(r.GetRegisteredServices(), url), "unregistered url should not be listed")
+	})
+	t.Run("UnRegisterTwice", func(t *testing.T) {
+		r := newRegistry()
+		url := NewServerURL("registrytest.unregister")
+		r.Register(url)
+		r.UnRegister(url)
+		r.UnRegister(url)
+		assert.Equal(t, 0, count(r.GetRegisteredServices(), url), "unregistered url should not be listed")
+		// registering again after unregister works
+		r.Register(url)
+		if registered := r.GetRegisteredServices(); registered != nil {
+			assert.Equal(t, 1, count(registered, url), "registered url should be listed once")
+		}
+		r.UnRegister(url)
+	})
+	t.Run("NotRegistered", func(t *testing.T) {
+		r := newRegistry()
+		url := NewServerURL("registrytest.missing")
+		r.UnRegister(url)
+		r.Available(url)
+		r.Unavailable(url)
+		assert.Equal(t, 0, count(r.GetRegisteredServices(), url), "url not registered should not be listed")
+	})
+	t.Run("AvailableTwice", func(t *testing.T) {
+		r := newRegistry()
+		url := NewServerURL("registrytest.available")
+		r.Register(url)
+		r.Available(url)
+		r.Available(url)
+		r.Unavailable(url)
+		r.Unavailable(url)
+		if registered := r.GetRegisteredServices(); registered != nil {
+			assert.Equal(t, 1, count(registered, url), "availability should not change registrations")
+		}
+		r.UnRegister(url)
+	})
+	t.Run("SubscribeTwice", func(t *testing.T) {
+		r := newRegistry()
+		url := &motan.URL{Protocol: "motan2", Path: "registrytest.subscribe", Group: "registrytest", Parameters: map[string]string{}}
+		listener := &testListener{id: "registrytest"}
+		r.Subscribe(url, listener)
+		r.Subscribe(url, listener)
+		r.Unsubscribe(url, listener)
+		r.Unsubscribe(url, listener)
+		r.Unsubscribe(url, &testListener{id: "registrytest.missing"})
+	})
+}
+
+func count(urls []*motan.URL, url *motan.URL) int {
+	n := 0
+	for _, u := range urls {
+		if u.GetIdentity() == url.GetIdentity() {
+			n++
+		}
+	}
+	return n
+}
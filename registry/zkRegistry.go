@@ -2,6 +2,7 @@ package registry
 
 import (
 	"encoding/binary"
+	"errors"
 	"strconv"
 	"strings"
 	"sync"
@@ -143,6 +144,15 @@ func (z *ZkRegistry) Register(url *motan.URL) {
 	}
 }
 
+// TryRegister registers the url, it fails if the zookeeper is unavailable, so the registration can be retried
+func (z *ZkRegistry) TryRegister(url *motan.URL) error {
+	if !z.IsAvailable() {
+		return errors.New("zookeeper registry is unavailable: " + z.url.GetIdentity())
+	}
+	z.Register(url)
+	return nil
+}
+
 func (z *ZkRegistry) doRegister(url *motan.URL) {
 	if url.Group == "" || url.Path == "" || url.Host == "" {
 		vlog.Errorf("[ZkRegistry] register service fail. invalid url:%s", url.GetIdentity())
@@ -0,0 +1,562 @@
+package serialize
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+// PbDescriptorSetKey is the service url parameter of the descriptor set file, it is loaded before the service is exported
+const PbDescriptorSetKey = "pbDescriptorSet"
+
+var (
+	ErrNotDynamicMessage = errors.New("param must be *DynamicMessage or message type name in DynamicPbSerialization")
+	errTruncatedPb       = errors.New("truncated protobuf message")
+)
+
+// DynamicMessage is a protobuf message described by a descriptor loaded at runtime, see LoadPbDescriptorSet.
+// field values are keyed by field name:
+//   - int32, sint32, sfixed32 and enum: int32; int64, sint64, sfixed64: int64; uint32, fixed32: uint32; uint64, fixed64: uint64
+//   - float: float32; double: float64; bool; string; bytes: []byte; message: *DynamicMessage
+//   - repeated fields: []interface{}, map fields are repeated entry messages with key and value fields
+//
+// any go number(e.g. float64 from json) can be used for number fields when serializing.
+// fields unknown to the descriptor are kept in Unknown and serialized as is
+type DynamicMessage struct {
+	Type    string // full name of the message type, e.g. motan.demo.HelloRequest
+	Fields  map[string]interface{}
+	Unknown []byte
+}
+
+func NewDynamicMessage(typeName string) *DynamicMessage {
+	return &DynamicMessage{Type: typeName, Fields: make(map[string]interface{})}
+}
+
+type pbField struct {
+	name     string
+	number   int32
+	typ      descriptor.FieldDescriptorProto_Type
+	typeName string
+	repeated bool
+	packed   bool
+}
+
+type pbMessage struct {
+	name     string
+	fields   []*pbField // sorted by number
+	byNumber map[int32]*pbField
+	byName   map[string]*pbField
+}
+
+var (
+	pbMessages    = make(map[string]*pbMessage)
+	pbMessageLock sync.RWMutex
+)
+
+// LoadPbDescriptorSet load the message types from a file descriptor set file,
+// which can be generated by `protoc --include_imports --descriptor_set_out=FILE`
+func LoadPbDescriptorSet(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	set := &descriptor.FileDescriptorSet{}
+	if err = proto.Unmarshal(b, set); err != nil {
+		return fmt.Errorf("illegal descriptor set file %s: %v", path, err)
+	}
+	RegisterPbDescriptorSet(set)
+	return nil
+}
+
+// RegisterPbDescriptorSet register the message types of the descriptor set, existing types with the same name are replaced
+func RegisterPbDescriptorSet(set *descriptor.FileDescriptorSet) {
+	pbMessageLock.Lock()
+	defer pbMessageLock.Unlock()
+	for _, file := range set.GetFile() {
+		prefix := file.GetPackage()
+		proto3 := file.GetSyntax() == "proto3"
+		for _, m := range file.GetMessageType() {
+			registerPbMessage(prefix, m, proto3)
+		}
+	}
+}
+
+func registerPbMessage(prefix string, d *descriptor.DescriptorProto, proto3 bool) {
+	name := d.GetName()
+	if prefix != "" {
+		name = prefix + "." + name
+	}
+	m := &pbMessage{name: name, byNumber: make(map[int32]*pbField), byName: make(map[string]*pbField)}
+	for _, fd := range d.GetField() {
+		f := &pbField{
+			name:     fd.GetName(),
+			number:   fd.GetNumber(),
+			typ:      fd.GetType(),
+			typeName: strings.TrimPrefix(fd.GetTypeName(), "."),
+			repeated: fd.GetLabel() == descriptor.FieldDescriptorProto_LABEL_REPEATED,
+		}
+		if f.repeated && packable(f.typ) {
+			if opts := fd.GetOptions(); opts != nil && opts.Packed != nil {
+				f.packed = *opts.Packed
+			} else {
+				f.packed = proto3
+			}
+		}
+		m.fields = append(m.fields, f)
+		m.byNumber[f.number] = f
+		m.byName[f.name] = f
+	}
+	sort.Slice(m.fields, func(i, j int) bool { return m.fields[i].number < m.fields[j].number })
+	pbMessages[name] = m
+	for _, nested := range d.GetNestedType() {
+		registerPbMessage(name, nested, proto3)
+	}
+}
+
+func getPbMessage(name string) (*pbMessage, error) {
+	pbMessageLock.RLock()
+	defer pbMessageLock.RUnlock()
+	if m, ok := pbMessages[name]; ok {
+		return m, nil
+	}
+	return nil, errors.New("protobuf message type not found: " + name)
+}
+
+func packable(t descriptor.FieldDescriptorProto_Type) bool {
+	switch t {
+	case descriptor.FieldDescriptorProto_TYPE_STRING, descriptor.FieldDescriptorProto_TYPE_BYTES,
+		descriptor.FieldDescriptorProto_TYPE_MESSAGE, descriptor.FieldDescriptorProto_TYPE_GROUP:
+		return false
+	}
+	return true
+}
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+func wireType(t descriptor.FieldDescriptorProto_Type) int {
+	switch t {
+	case descriptor.FieldDescriptorProto_TYPE_DOUBLE, descriptor.FieldDescriptorProto_TYPE_FIXED64, descriptor.FieldDescriptorProto_TYPE_SFIXED64:
+		return wireFixed64
+	case descriptor.FieldDescriptorProto_TYPE_FLOAT, descriptor.FieldDescriptorProto_TYPE_FIXED32, descriptor.FieldDescriptorProto_TYPE_SFIXED32:
+		return wireFixed32
+	case descriptor.FieldDescriptorProto_TYPE_STRING, descriptor.FieldDescriptorProto_TYPE_BYTES, descriptor.FieldDescriptorProto_TYPE_MESSAGE:
+		return wireBytes
+	}
+	return wireVarint
+}
+
+// DynamicPbSerialization serialize & deserialize single DynamicMessage, so protobuf services can be served without generated code
+type DynamicPbSerialization struct{}
+
+func (d *DynamicPbSerialization) GetSerialNum() int {
+	return DynamicPbNumber
+}
+
+func (d *DynamicPbSerialization) Serialize(v interface{}) ([]byte, error) {
+	if v == nil {
+		return nil, ErrNilParam
+	}
+	if rv, ok := v.(reflect.Value); ok {
+		v = rv.Interface()
+	}
+	message, ok := v.(*DynamicMessage)
+	if !ok || message == nil {
+		return nil, ErrNotDynamicMessage
+	}
+	return marshalDynamic(nil, message)
+}
+
+func (d *DynamicPbSerialization) SerializeMulti(v []interface{}) ([]byte, error) {
+	if v == nil {
+		return nil, ErrNilParam
+	}
+	if len(v) == 1 {
+		return d.Serialize(v[0])
+	}
+	return nil, ErrMultiParam
+}
+
+// DeSerialize decode the bytes into v, v can be a *DynamicMessage with the Type set, or the message type name
+func (d *DynamicPbSerialization) DeSerialize(b []byte, v interface{}) (interface{}, error) {
+	var message *DynamicMessage
+	switch t := v.(type) {
+	case *DynamicMessage:
+		message = t
+	case string:
+		message = NewDynamicMessage(t)
+	default:
+		return nil, ErrNotDynamicMessage
+	}
+	if message == nil {
+		return nil, ErrNilParam
+	}
+	if message.Fields == nil {
+		message.Fields = make(map[string]interface{})
+	}
+	if err := unmarshalDynamic(b, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+func (d *DynamicPbSerialization) DeSerializeMulti(b []byte, v []interface{}) ([]interface{}, error) {
+	if v == nil {
+		return nil, ErrNilParam
+	}
+	if len(v) == 1 {
+		r, err := d.DeSerialize(b, v[0])
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{r}, nil
+	}
+	return nil, ErrMultiParam
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+func appendFixed32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendFixed64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendTag(b []byte, number int32, wire int) []byte {
+	return appendVarint(b, uint64(number)<<3|uint64(wire))
+}
+
+func marshalDynamic(b []byte, message *DynamicMessage) ([]byte, error) {
+	m, err := getPbMessage(message.Type)
+	if err != nil {
+		return nil, err
+	}
+	for name := range message.Fields {
+		if _, ok := m.byName[name]; !ok {
+			return nil, fmt.Errorf("field %s not found in protobuf message %s", name, m.name)
+		}
+	}
+	for _, f := range m.fields {
+		value, ok := message.Fields[f.name]
+		if !ok || value == nil {
+			continue
+		}
+		if !f.repeated {
+			if b, err = appendField(b, f, value); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		rv := reflect.ValueOf(value)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			return nil, fmt.Errorf("repeated field %s of %s must be a slice", f.name, m.name)
+		}
+		if f.packed {
+			var packed []byte
+			for i := 0; i < rv.Len(); i++ {
+				if packed, err = appendValue(packed, f, rv.Index(i).Interface()); err != nil {
+					return nil, err
+				}
+			}
+			b = appendTag(b, f.number, wireBytes)
+			b = appendVarint(b, uint64(len(packed)))
+			b = append(b, packed...)
+			continue
+		}
+		for i := 0; i < rv.Len(); i++ {
+			if b, err = appendField(b, f, rv.Index(i).Interface()); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return append(b, message.Unknown...), nil
+}
+
+func appendField(b []byte, f *pbField, value interface{}) ([]byte, error) {
+	b = appendTag(b, f.number, wireType(f.typ))
+	return appendValue(b, f, value)
+}
+
+func appendValue(b []byte, f *pbField, value interface{}) ([]byte, error) {
+	switch f.typ {
+	case descriptor.FieldDescriptorProto_TYPE_STRING, descriptor.FieldDescriptorProto_TYPE_BYTES:
+		var data []byte
+		switch v := value.(type) {
+		case string:
+			data = []byte(v)
+		case []byte:
+			data = v
+		default:
+			return nil, fmt.Errorf("field %s must be string or []byte, but got %T", f.name, value)
+		}
+		b = appendVarint(b, uint64(len(data)))
+		return append(b, data...), nil
+	case descriptor.FieldDescriptorProto_TYPE_MESSAGE:
+		nested, ok := value.(*DynamicMessage)
+		if !ok {
+			return nil, fmt.Errorf("field %s must be *DynamicMessage, but got %T", f.name, value)
+		}
+		if nested.Type == "" {
+			nested = &DynamicMessage{Type: f.typeName, Fields: nested.Fields, Unknown: nested.Unknown}
+		}
+		data, err := marshalDynamic(nil, nested)
+		if err != nil {
+			return nil, err
+		}
+		b = appendVarint(b, uint64(len(data)))
+		return append(b, data...), nil
+	case descriptor.FieldDescriptorProto_TYPE_BOOL:
+		v, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("field %s must be bool, but got %T", f.name, value)
+		}
+		if v {
+			return append(b, 1), nil
+		}
+		return append(b, 0), nil
+	case descriptor.FieldDescriptorProto_TYPE_FLOAT:
+		v, err := toFloat(f, value)
+		if err != nil {
+			return nil, err
+		}
+		return appendFixed32(b, math.Float32bits(float32(v))), nil
+	case descriptor.FieldDescriptorProto_TYPE_DOUBLE:
+		v, err := toFloat(f, value)
+		if err != nil {
+			return nil, err
+		}
+		return appendFixed64(b, math.Float64bits(v)), nil
+	case descriptor.FieldDescriptorProto_TYPE_GROUP:
+		return nil, fmt.Errorf("group field %s is not supported", f.name)
+	}
+	v, err := toInt(f, value)
+	if err != nil {
+		return nil, err
+	}
+	switch f.typ {
+	case descriptor.FieldDescriptorProto_TYPE_SINT32:
+		return appendVarint(b, uint64(uint32((int32(v)<<1)^(int32(v)>>31)))), nil
+	case descriptor.FieldDescriptorProto_TYPE_SINT64:
+		return appendVarint(b, uint64((v<<1)^(v>>63))), nil
+	case descriptor.FieldDescriptorProto_TYPE_FIXED32, descriptor.FieldDescriptorProto_TYPE_SFIXED32:
+		return appendFixed32(b, uint32(v)), nil
+	case descriptor.FieldDescriptorProto_TYPE_FIXED64, descriptor.FieldDescriptorProto_TYPE_SFIXED64:
+		return appendFixed64(b, uint64(v)), nil
+	case descriptor.FieldDescriptorProto_TYPE_UINT32:
+		return appendVarint(b, uint64(uint32(v))), nil
+	case descriptor.FieldDescriptorProto_TYPE_INT32, descriptor.FieldDescriptorProto_TYPE_ENUM:
+		// negative int32 is encoded as 10 bytes like int64
+		return appendVarint(b, uint64(int64(int32(v)))), nil
+	}
+	return appendVarint(b, uint64(v)), nil
+}
+
+func toInt(f *pbField, value interface{}) (int64, error) {
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		if fv := rv.Float(); fv == math.Trunc(fv) {
+			return int64(fv), nil
+		}
+	}
+	return 0, fmt.Errorf("field %s must be an integer, but got %v", f.name, value)
+}
+
+func toFloat(f *pbField, value interface{}) (float64, error) {
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	}
+	return 0, fmt.Errorf("field %s must be a number, but got %T", f.name, value)
+}
+
+func readVarint(b []byte) (uint64, int, error) {
+	v, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, 0, errTruncatedPb
+	}
+	return v, n, nil
+}
+
+// readRaw returns the length of the value of wire type at the beginning of b
+func readRaw(b []byte, wire int) (int, error) {
+	switch wire {
+	case wireVarint:
+		_, n, err := readVarint(b)
+		return n, err
+	case wireFixed64:
+		if len(b) < 8 {
+			return 0, errTruncatedPb
+		}
+		return 8, nil
+	case wireFixed32:
+		if len(b) < 4 {
+			return 0, errTruncatedPb
+		}
+		return 4, nil
+	case wireBytes:
+		l, n, err := readVarint(b)
+		if err != nil {
+			return 0, err
+		}
+		if uint64(len(b)-n) < l {
+			return 0, errTruncatedPb
+		}
+		return n + int(l), nil
+	}
+	return 0, fmt.Errorf("unsupported protobuf wire type %d", wire)
+}
+
+func unmarshalDynamic(b []byte, message *DynamicMessage) error {
+	m, err := getPbMessage(message.Type)
+	if err != nil {
+		return err
+	}
+	for len(b) > 0 {
+		tag, n, err := readVarint(b)
+		if err != nil {
+			return err
+		}
+		number, wire := int32(tag>>3), int(tag&7)
+		size, err := readRaw(b[n:], wire)
+		if err != nil {
+			return err
+		}
+		f, ok := m.byNumber[number]
+		if !ok {
+			// keep the unknown field with its tag
+			message.Unknown = append(message.Unknown, b[:n+size]...)
+			b = b[n+size:]
+			continue
+		}
+		raw := b[n : n+size]
+		b = b[n+size:]
+		if f.repeated && wire == wireBytes && packable(f.typ) {
+			// packed repeated values
+			_, ln, _ := readVarint(raw)
+			values := raw[ln:]
+			for len(values) > 0 {
+				valueSize, err := readRaw(values, wireType(f.typ))
+				if err != nil {
+					return err
+				}
+				value, err := decodeValue(f, values[:valueSize])
+				if err != nil {
+					return err
+				}
+				appendRepeated(message, f, value)
+				values = values[valueSize:]
+			}
+			continue
+		}
+		if wire != wireType(f.typ) {
+			return fmt.Errorf("illegal wire type %d of field %s in %s", wire, f.name, m.name)
+		}
+		value, err := decodeValue(f, raw)
+		if err != nil {
+			return err
+		}
+		if f.repeated {
+			appendRepeated(message, f, value)
+		} else if f.typ == descriptor.FieldDescriptorProto_TYPE_MESSAGE && message.Fields[f.name] != nil {
+			// a message field appearing more than once is merged
+			existing := message.Fields[f.name].(*DynamicMessage)
+			for k, v := range value.(*DynamicMessage).Fields {
+				existing.Fields[k] = v
+			}
+			existing.Unknown = append(existing.Unknown, value.(*DynamicMessage).Unknown...)
+		} else {
+			message.Fields[f.name] = value
+		}
+	}
+	return nil
+}
+
+func appendRepeated(message *DynamicMessage, f *pbField, value interface{}) {
+	values, _ := message.Fields[f.name].([]interface{})
+	message.Fields[f.name] = append(values, value)
+}
+
+func decodeValue(f *pbField, raw []byte) (interface{}, error) {
+	switch f.typ {
+	case descriptor.FieldDescriptorProto_TYPE_STRING, descriptor.FieldDescriptorProto_TYPE_BYTES, descriptor.FieldDescriptorProto_TYPE_MESSAGE:
+		_, n, _ := readVarint(raw)
+		data := raw[n:]
+		switch f.typ {
+		case descriptor.FieldDescriptorProto_TYPE_STRING:
+			return string(data), nil
+		case descriptor.FieldDescriptorProto_TYPE_BYTES:
+			return append([]byte{}, data...), nil
+		}
+		nested := NewDynamicMessage(f.typeName)
+		if err := unmarshalDynamic(data, nested); err != nil {
+			return nil, err
+		}
+		return nested, nil
+	case descriptor.FieldDescriptorProto_TYPE_FLOAT:
+		return math.Float32frombits(binary.LittleEndian.Uint32(raw)), nil
+	case descriptor.FieldDescriptorProto_TYPE_DOUBLE:
+		return math.Float64frombits(binary.LittleEndian.Uint64(raw)), nil
+	case descriptor.FieldDescriptorProto_TYPE_FIXED32:
+		return binary.LittleEndian.Uint32(raw), nil
+	case descriptor.FieldDescriptorProto_TYPE_SFIXED32:
+		return int32(binary.LittleEndian.Uint32(raw)), nil
+	case descriptor.FieldDescriptorProto_TYPE_FIXED64:
+		return binary.LittleEndian.Uint64(raw), nil
+	case descriptor.FieldDescriptorProto_TYPE_SFIXED64:
+		return int64(binary.LittleEndian.Uint64(raw)), nil
+	case descriptor.FieldDescriptorProto_TYPE_GROUP:
+		return nil, fmt.Errorf("group field %s is not supported", f.name)
+	}
+	v, _, err := readVarint(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch f.typ {
+	case descriptor.FieldDescriptorProto_TYPE_BOOL:
+		return v != 0, nil
+	case descriptor.FieldDescriptorProto_TYPE_INT32, descriptor.FieldDescriptorProto_TYPE_ENUM:
+		return int32(v), nil
+	case descriptor.FieldDescriptorProto_TYPE_UINT32:
+		return uint32(v), nil
+	case descriptor.FieldDescriptorProto_TYPE_SINT32:
+		return int32(uint32(v)>>1) ^ -int32(v&1), nil
+	case descriptor.FieldDescriptorProto_TYPE_SINT64:
+		return int64(v>>1) ^ -int64(v&1), nil
+	case descriptor.FieldDescriptorProto_TYPE_UINT64:
+		return v, nil
+	}
+	return int64(v), nil
+}
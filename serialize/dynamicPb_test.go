@@ -0,0 +1,155 @@
+package serialize
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+// registerDescriptorProto register the messages of descriptor.proto, so the generated descriptor messages can be compared
+func registerDescriptorProto(t *testing.T) {
+	r, err := gzip.NewReader(bytes.NewReader(proto.FileDescriptor("google/protobuf/descriptor.proto")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := &descriptor.FileDescriptorProto{}
+	if err = proto.Unmarshal(b, file); err != nil {
+		t.Fatal(err)
+	}
+	RegisterPbDescriptorSet(&descriptor.FileDescriptorSet{File: []*descriptor.FileDescriptorProto{file}})
+}
+
+func TestDynamicPbSerialization(t *testing.T) {
+	registerDescriptorProto(t)
+	s := &DynamicPbSerialization{}
+	CheckSerialeNumber(t, s, DynamicPbNumber)
+
+	// nested and repeated messages, enums
+	expect := &descriptor.DescriptorProto{
+		Name: proto.String("Hello"),
+		Field: []*descriptor.FieldDescriptorProto{
+			{Name: proto.String("name"), Number: proto.Int32(1), Type: descriptor.FieldDescriptorProto_TYPE_STRING.Enum()},
+			{Name: proto.String("ids"), Number: proto.Int32(-2), Label: descriptor.FieldDescriptorProto_LABEL_REPEATED.Enum()},
+		},
+		ReservedName: []string{"a", "b"},
+	}
+	b, err := proto.Marshal(expect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := s.DeSerialize(b, "google.protobuf.DescriptorProto")
+	if err != nil {
+		t.Fatalf("deserialize fail. err:%v", err)
+	}
+	message := v.(*DynamicMessage)
+	if message.Fields["name"] != "Hello" || !reflect.DeepEqual(message.Fields["reserved_name"], []interface{}{"a", "b"}) {
+		t.Errorf("deserialize value not correct. result:%+v", message.Fields)
+	}
+	fields := message.Fields["field"].([]interface{})
+	if len(fields) != 2 || fields[1].(*DynamicMessage).Fields["number"] != int32(-2) || fields[0].(*DynamicMessage).Fields["type"] != int32(9) {
+		t.Errorf("deserialize repeated messages not correct. result:%+v", fields)
+	}
+
+	b, err = s.Serialize(message)
+	if err != nil {
+		t.Fatalf("serialize fail. err:%v", err)
+	}
+	actual := &descriptor.DescriptorProto{}
+	if err = proto.Unmarshal(b, actual); err != nil || !proto.Equal(expect, actual) {
+		t.Errorf("serialize value not correct. expect:%v, real:%v, err:%v", expect, actual, err)
+	}
+
+	// numbers of other go types, e.g. from json
+	message = NewDynamicMessage("google.protobuf.FieldDescriptorProto")
+	message.Fields["name"] = "n"
+	message.Fields["number"] = float64(3)
+	message.Fields["oneof_index"] = 2
+	b, err = s.SerializeMulti([]interface{}{message})
+	field := &descriptor.FieldDescriptorProto{}
+	if err != nil || proto.Unmarshal(b, field) != nil || field.GetNumber() != 3 || field.GetOneofIndex() != 2 || field.GetName() != "n" {
+		t.Errorf("serialize value not correct. result:%v, err:%v", field, err)
+	}
+	message.Fields["number"] = 1.5
+	if _, err = s.Serialize(message); err == nil {
+		t.Errorf("serialize should fail with fractional number")
+	}
+	message.Fields["number"] = 1
+	message.Fields["missing"] = 1
+	if _, err = s.Serialize(message); err == nil {
+		t.Errorf("serialize should fail with unknown field")
+	}
+	if _, err = s.Serialize("abc"); err != ErrNotDynamicMessage {
+		t.Errorf("serialize should fail with non dynamic message. err:%v", err)
+	}
+	if _, err = s.DeSerialize(b, "unknown.Type"); err == nil {
+		t.Errorf("deserialize should fail with unknown type")
+	}
+}
+
+func TestDynamicPbUnknownAndPacked(t *testing.T) {
+	RegisterPbDescriptorSet(&descriptor.FileDescriptorSet{File: []*descriptor.FileDescriptorProto{{
+		Name:    proto.String("test.proto"),
+		Package: proto.String("motan.test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptor.DescriptorProto{{
+			Name: proto.String("Packed"),
+			Field: []*descriptor.FieldDescriptorProto{
+				{Name: proto.String("values"), Number: proto.Int32(1), Label: descriptor.FieldDescriptorProto_LABEL_REPEATED.Enum(), Type: descriptor.FieldDescriptorProto_TYPE_SINT64.Enum()},
+				{Name: proto.String("ratio"), Number: proto.Int32(2), Type: descriptor.FieldDescriptorProto_TYPE_DOUBLE.Enum()},
+			},
+		}, {
+			Name: proto.String("Old"),
+			Field: []*descriptor.FieldDescriptorProto{
+				{Name: proto.String("ratio"), Number: proto.Int32(2), Type: descriptor.FieldDescriptorProto_TYPE_DOUBLE.Enum()},
+			},
+		}},
+	}}})
+	s := &DynamicPbSerialization{}
+	message := NewDynamicMessage("motan.test.Packed")
+	message.Fields["values"] = []int64{-1, 0, 300}
+	message.Fields["ratio"] = 0.5
+	b, err := s.Serialize(message)
+	if err != nil {
+		t.Fatalf("serialize fail. err:%v", err)
+	}
+	// packed: tag, length, zigzag values
+	if !bytes.HasPrefix(b, []byte{0x0a, 0x04, 0x01, 0x00, 0xd8, 0x04}) {
+		t.Errorf("packed encoding not correct. result:%x", b)
+	}
+	v, err := s.DeSerialize(b, NewDynamicMessage("motan.test.Packed"))
+	if err != nil || !reflect.DeepEqual(v.(*DynamicMessage).Fields["values"], []interface{}{int64(-1), int64(0), int64(300)}) {
+		t.Errorf("deserialize packed values not correct. result:%+v, err:%v", v, err)
+	}
+
+	// an old reader keeps the unknown fields and writes them back
+	v, err = s.DeSerialize(b, "motan.test.Old")
+	old := v.(*DynamicMessage)
+	if err != nil || old.Fields["ratio"] != 0.5 || len(old.Unknown) != 6 {
+		t.Errorf("deserialize unknown fields not correct. result:%+v, err:%v", old, err)
+	}
+	old.Type = "motan.test.Packed"
+	v, err = s.DeSerialize(mustSerialize(t, s, old), "motan.test.Packed")
+	if err != nil || !reflect.DeepEqual(v.(*DynamicMessage).Fields, map[string]interface{}{"values": []interface{}{int64(-1), int64(0), int64(300)}, "ratio": 0.5}) {
+		t.Errorf("unknown fields not kept. result:%+v, err:%v", v, err)
+	}
+	if _, err = s.DeSerialize(b[:len(b)-1], "motan.test.Packed"); err == nil {
+		t.Errorf("deserialize should fail with truncated bytes")
+	}
+}
+
+func mustSerialize(t *testing.T, s *DynamicPbSerialization, message *DynamicMessage) []byte {
+	b, err := s.Serialize(message)
+	if err != nil {
+		t.Fatalf("serialize fail. err:%v", err)
+	}
+	return b
+}
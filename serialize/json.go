@@ -0,0 +1,91 @@
+package serialize
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+var ErrJSONMultiCount = errors.New("json serialization: the count of values not match")
+
+// JSONSerialization serialize values as json, multi values are serialized as a json array.
+// the values are deserialized into the pointers, or new values of the given reflect.Type(e.g. the arguments of the
+// DefaultProvider methods) or the types of the given values, or generic json values if nil
+type JSONSerialization struct{}
+
+func (j *JSONSerialization) GetSerialNum() int {
+	return JSONNumber
+}
+
+func (j *JSONSerialization) Serialize(v interface{}) ([]byte, error) {
+	if rv, ok := v.(reflect.Value); ok {
+		v = rv.Interface()
+	}
+	return json.Marshal(v)
+}
+
+func (j *JSONSerialization) SerializeMulti(v []interface{}) ([]byte, error) {
+	if len(v) == 0 {
+		return nil, nil
+	}
+	values := make([]interface{}, 0, len(v))
+	for _, o := range v {
+		if rv, ok := o.(reflect.Value); ok {
+			o = rv.Interface()
+		}
+		values = append(values, o)
+	}
+	return json.Marshal(values)
+}
+
+func (j *JSONSerialization) DeSerialize(b []byte, v interface{}) (interface{}, error) {
+	if v == nil {
+		var result interface{}
+		if err := json.Unmarshal(b, &result); err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+	t, ok := v.(reflect.Type)
+	if !ok {
+		t = reflect.TypeOf(v)
+	}
+	if !ok && t.Kind() == reflect.Ptr {
+		if err := json.Unmarshal(b, v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+	rv := reflect.New(t)
+	if err := json.Unmarshal(b, rv.Interface()); err != nil {
+		return nil, fmt.Errorf("json serialization: deserialize %s fail: %v", t, err)
+	}
+	return rv.Elem().Interface(), nil
+}
+
+func (j *JSONSerialization) DeSerializeMulti(b []byte, v []interface{}) ([]interface{}, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	var raws []json.RawMessage
+	if err := json.Unmarshal(b, &raws); err != nil {
+		return nil, err
+	}
+	if v != nil && len(v) != len(raws) {
+		return nil, ErrJSONMultiCount
+	}
+	ret := make([]interface{}, 0, len(raws))
+	for i, raw := range raws {
+		var o interface{}
+		if v != nil {
+			o = v[i]
+		}
+		rv, err := j.DeSerialize(raw, o)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, rv)
+	}
+	return ret, nil
+}
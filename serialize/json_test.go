@@ -0,0 +1,53 @@
+package serialize
+
+import (
+	"reflect"
+	"testing"
+)
+
+type jsonTestValue struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+func TestJSONSerialization(t *testing.T) {
+	s := &JSONSerialization{}
+	CheckSerialeNumber(t, s, JSONNumber)
+	expect := jsonTestValue{Name: "hello", Tags: []string{"a", "b"}}
+	b, err := s.Serialize(expect)
+	if err != nil || string(b) != `{"name":"hello","tags":["a","b"]}` {
+		t.Errorf("serialize value not correct. result:%s, err:%v", b, err)
+	}
+	v, err := s.DeSerialize(b, jsonTestValue{})
+	if err != nil || !reflect.DeepEqual(expect, v) {
+		t.Errorf("deserialize value not correct. expect:%+v, real:%+v, err:%v", expect, v, err)
+	}
+	actual := &jsonTestValue{}
+	if _, err = s.DeSerialize(b, actual); err != nil || !reflect.DeepEqual(expect, *actual) {
+		t.Errorf("deserialize to pointer not correct. expect:%+v, real:%+v, err:%v", expect, actual, err)
+	}
+	v, err = s.DeSerialize(b, nil)
+	if err != nil || v.(map[string]interface{})["name"] != "hello" {
+		t.Errorf("deserialize generic value not correct. result:%+v, err:%v", v, err)
+	}
+
+	b, err = s.SerializeMulti([]interface{}{"a", 1, reflect.ValueOf(expect)})
+	if err != nil {
+		t.Fatalf("serialize multi fail. err:%v", err)
+	}
+	values, err := s.DeSerializeMulti(b, []interface{}{"", 0, &jsonTestValue{}})
+	if err != nil || values[0] != "a" || values[1] != 1 || !reflect.DeepEqual(&expect, values[2]) {
+		t.Errorf("deserialize multi not correct. result:%+v, err:%v", values, err)
+	}
+	if _, err = s.DeSerializeMulti(b, []interface{}{""}); err != ErrJSONMultiCount {
+		t.Errorf("deserialize multi should fail with wrong count. err:%v", err)
+	}
+	// the argument types of the provider methods
+	values, err = s.DeSerializeMulti(b, []interface{}{reflect.TypeOf(""), reflect.TypeOf(0), reflect.TypeOf(&jsonTestValue{})})
+	if err != nil || values[0] != "a" || values[1] != 1 || !reflect.DeepEqual(&expect, values[2]) {
+		t.Errorf("deserialize multi by types not correct. result:%+v, err:%v", values, err)
+	}
+	if values, err = s.DeSerializeMulti(b, nil); err != nil || len(values) != 3 {
+		t.Errorf("deserialize multi generic values not correct. result:%+v, err:%v", values, err)
+	}
+}
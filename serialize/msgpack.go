@@ -0,0 +1,799 @@
+package serialize
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrMsgpackMultiCount = errors.New("msgpack serialization: the count of values not match")
+	errMsgpackShort      = errors.New("msgpack serialization: unexpected end of data")
+)
+
+// MsgpackSerialization serialize values as MessagePack, multi values are serialized as a sequence of values.
+// structs are serialized as maps of the exported fields, the name of a field can be set by the `msgpack` tag like json
+// (`msgpack:"name,omitempty"`, "-" to ignore). the values are deserialized like JSONSerialization, the generic maps are
+// map[string]interface{} if all the keys are strings, otherwise map[interface{}]interface{}
+type MsgpackSerialization struct{}
+
+func (m *MsgpackSerialization) GetSerialNum() int {
+	return MsgpackNumber
+}
+
+func (m *MsgpackSerialization) Serialize(v interface{}) ([]byte, error) {
+	e := &msgpackEncoder{}
+	if err := e.encode(msgpackValueOf(v)); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+func (m *MsgpackSerialization) SerializeMulti(v []interface{}) ([]byte, error) {
+	if len(v) == 0 {
+		return nil, nil
+	}
+	e := &msgpackEncoder{}
+	for _, o := range v {
+		if err := e.encode(msgpackValueOf(o)); err != nil {
+			return nil, err
+		}
+	}
+	return e.buf, nil
+}
+
+func (m *MsgpackSerialization) DeSerialize(b []byte, v interface{}) (interface{}, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	d := &msgpackDecoder{b: b}
+	return d.decodeValue(v)
+}
+
+func (m *MsgpackSerialization) DeSerializeMulti(b []byte, v []interface{}) ([]interface{}, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	d := &msgpackDecoder{b: b}
+	if v == nil {
+		var ret []interface{}
+		for d.pos < len(d.b) {
+			o, err := d.decodeGeneric()
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, o)
+		}
+		return ret, nil
+	}
+	ret := make([]interface{}, 0, len(v))
+	for _, o := range v {
+		if d.pos >= len(d.b) {
+			return nil, ErrMsgpackMultiCount
+		}
+		rv, err := d.decodeValue(o)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, rv)
+	}
+	if d.pos < len(d.b) {
+		return nil, ErrMsgpackMultiCount
+	}
+	return ret, nil
+}
+
+func msgpackValueOf(v interface{}) reflect.Value {
+	if rv, ok := v.(reflect.Value); ok {
+		return rv
+	}
+	return reflect.ValueOf(v)
+}
+
+type msgpackField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+var msgpackFieldsCache sync.Map // reflect.Type -> []msgpackField
+
+func msgpackFields(t reflect.Type) []msgpackField {
+	if fields, ok := msgpackFieldsCache.Load(t); ok {
+		return fields.([]msgpackField)
+	}
+	fields := make([]msgpackField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		field := msgpackField{name: f.Name, index: f.Index}
+		if tag := f.Tag.Get("msgpack"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			parts := strings.Split(tag, ",")
+			if parts[0] != "" {
+				field.name = parts[0]
+			}
+			for _, option := range parts[1:] {
+				if option == "omitempty" {
+					field.omitEmpty = true
+				}
+			}
+		}
+		fields = append(fields, field)
+	}
+	msgpackFieldsCache.Store(t, fields)
+	return fields
+}
+
+type msgpackEncoder struct {
+	buf []byte
+}
+
+func (e *msgpackEncoder) encode(rv reflect.Value) error {
+	if !rv.IsValid() {
+		e.buf = append(e.buf, 0xc0)
+		return nil
+	}
+	switch rv.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if rv.IsNil() {
+			e.buf = append(e.buf, 0xc0)
+			return nil
+		}
+		return e.encode(rv.Elem())
+	case reflect.Bool:
+		if rv.Bool() {
+			e.buf = append(e.buf, 0xc3)
+		} else {
+			e.buf = append(e.buf, 0xc2)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.encodeInt(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.encodeUint(rv.Uint())
+	case reflect.Float32:
+		e.buf = append(e.buf, 0xca)
+		e.buf = appendUint32(e.buf, math.Float32bits(float32(rv.Float())))
+	case reflect.Float64:
+		e.buf = append(e.buf, 0xcb)
+		e.buf = appendUint64(e.buf, math.Float64bits(rv.Float()))
+	case reflect.String:
+		e.encodeString(rv.String())
+	case reflect.Slice:
+		if rv.IsNil() {
+			e.buf = append(e.buf, 0xc0)
+			return nil
+		}
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			e.encodeBytes(rv.Bytes())
+			return nil
+		}
+		return e.encodeArray(rv)
+	case reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, rv.Len())
+			reflect.Copy(reflect.ValueOf(b), rv)
+			e.encodeBytes(b)
+			return nil
+		}
+		return e.encodeArray(rv)
+	case reflect.Map:
+		if rv.IsNil() {
+			e.buf = append(e.buf, 0xc0)
+			return nil
+		}
+		return e.encodeMap(rv)
+	case reflect.Struct:
+		return e.encodeStruct(rv)
+	default:
+		return fmt.Errorf("msgpack serialization: not support type %s", rv.Type())
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) encodeInt(i int64) {
+	switch {
+	case i >= 0:
+		e.encodeUint(uint64(i))
+	case i >= -32:
+		e.buf = append(e.buf, byte(i))
+	case i >= math.MinInt8:
+		e.buf = append(e.buf, 0xd0, byte(i))
+	case i >= math.MinInt16:
+		e.buf = appendUint16(append(e.buf, 0xd1), uint16(i))
+	case i >= math.MinInt32:
+		e.buf = appendUint32(append(e.buf, 0xd2), uint32(i))
+	default:
+		e.buf = appendUint64(append(e.buf, 0xd3), uint64(i))
+	}
+}
+
+func (e *msgpackEncoder) encodeUint(u uint64) {
+	switch {
+	case u <= 0x7f:
+		e.buf = append(e.buf, byte(u))
+	case u <= math.MaxUint8:
+		e.buf = append(e.buf, 0xcc, byte(u))
+	case u <= math.MaxUint16:
+		e.buf = appendUint16(append(e.buf, 0xcd), uint16(u))
+	case u <= math.MaxUint32:
+		e.buf = appendUint32(append(e.buf, 0xce), uint32(u))
+	default:
+		e.buf = appendUint64(append(e.buf, 0xcf), u)
+	}
+}
+
+func (e *msgpackEncoder) encodeString(s string) {
+	n := len(s)
+	switch {
+	case n < 32:
+		e.buf = append(e.buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = appendUint16(append(e.buf, 0xda), uint16(n))
+	default:
+		e.buf = appendUint32(append(e.buf, 0xdb), uint32(n))
+	}
+	e.buf = append(e.buf, s...)
+}
+
+func (e *msgpackEncoder) encodeBytes(b []byte) {
+	n := len(b)
+	switch {
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = appendUint16(append(e.buf, 0xc5), uint16(n))
+	default:
+		e.buf = appendUint32(append(e.buf, 0xc6), uint32(n))
+	}
+	e.buf = append(e.buf, b...)
+}
+
+// encodeLen writes the header of the arrays(fix 0x90, 0xdc) and the maps(fix 0x80, 0xde)
+func (e *msgpackEncoder) encodeLen(fix byte, code16 byte, n int) {
+	switch {
+	case n < 16:
+		e.buf = append(e.buf, fix|byte(n))
+	case n <= math.MaxUint16:
+		e.buf = appendUint16(append(e.buf, code16), uint16(n))
+	default:
+		e.buf = appendUint32(append(e.buf, code16+1), uint32(n))
+	}
+}
+
+func (e *msgpackEncoder) encodeArray(rv reflect.Value) error {
+	e.encodeLen(0x90, 0xdc, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		if err := e.encode(rv.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) encodeMap(rv reflect.Value) error {
+	keys := rv.MapKeys()
+	// the string keys are sorted for the stable output
+	if rv.Type().Key().Kind() == reflect.String {
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+	}
+	e.encodeLen(0x80, 0xde, len(keys))
+	for _, k := range keys {
+		if err := e.encode(k); err != nil {
+			return err
+		}
+		if err := e.encode(rv.MapIndex(k)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) encodeStruct(rv reflect.Value) error {
+	fields := msgpackFields(rv.Type())
+	values := make([]reflect.Value, len(fields))
+	n := 0
+	for i, f := range fields {
+		values[i] = rv.FieldByIndex(f.index)
+		if !f.omitEmpty || !msgpackIsEmpty(values[i]) {
+			n++
+		}
+	}
+	e.encodeLen(0x80, 0xde, n)
+	for i, f := range fields {
+		if f.omitEmpty && msgpackIsEmpty(values[i]) {
+			continue
+		}
+		e.encodeString(f.name)
+		if err := e.encode(values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func msgpackIsEmpty(rv reflect.Value) bool {
+	switch rv.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return rv.Len() == 0
+	case reflect.Interface, reflect.Ptr:
+		return rv.IsNil()
+	case reflect.Bool:
+		return !rv.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return rv.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return rv.Float() == 0
+	}
+	return false
+}
+
+func appendUint16(b []byte, u uint16) []byte {
+	return append(b, byte(u>>8), byte(u))
+}
+
+func appendUint32(b []byte, u uint32) []byte {
+	return append(b, byte(u>>24), byte(u>>16), byte(u>>8), byte(u))
+}
+
+func appendUint64(b []byte, u uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(u>>32)), uint32(u))
+}
+
+type msgpackDecoder struct {
+	b   []byte
+	pos int
+}
+
+// decodeValue decodes into the pointer, or a new value of the reflect.Type or the type of v, or a generic value if nil
+func (d *msgpackDecoder) decodeValue(v interface{}) (interface{}, error) {
+	if v == nil {
+		return d.decodeGeneric()
+	}
+	t, ok := v.(reflect.Type)
+	if !ok {
+		rv := reflect.ValueOf(v)
+		if rv.Kind() == reflect.Ptr && !rv.IsNil() {
+			if err := d.decodeInto(rv.Elem()); err != nil {
+				return nil, err
+			}
+			return v, nil
+		}
+		t = rv.Type()
+	}
+	rv := reflect.New(t).Elem()
+	if err := d.decodeInto(rv); err != nil {
+		return nil, err
+	}
+	return rv.Interface(), nil
+}
+
+func (d *msgpackDecoder) readByte() (byte, error) {
+	if d.pos >= len(d.b) {
+		return 0, errMsgpackShort
+	}
+	c := d.b[d.pos]
+	d.pos++
+	return c, nil
+}
+
+func (d *msgpackDecoder) readN(n int) ([]byte, error) {
+	if n < 0 || d.pos+n > len(d.b) {
+		return nil, errMsgpackShort
+	}
+	b := d.b[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+// readUint reads a big endian unsigned integer of size bytes
+func (d *msgpackDecoder) readUint(size int) (uint64, error) {
+	b, err := d.readN(size)
+	if err != nil {
+		return 0, err
+	}
+	var u uint64
+	for _, c := range b {
+		u = u<<8 | uint64(c)
+	}
+	return u, nil
+}
+
+func (d *msgpackDecoder) readLen(size int) (int, error) {
+	n, err := d.readUint(size)
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(len(d.b)) {
+		return 0, errMsgpackShort
+	}
+	return int(n), nil
+}
+
+// readContainerLen reads the header of an array or a map
+func (d *msgpackDecoder) readContainerLen(isMap bool) (n int, err error) {
+	c, err := d.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case !isMap && c&0xf0 == 0x90:
+		return int(c & 0x0f), nil
+	case !isMap && c == 0xdc, isMap && c == 0xde:
+		n, err = d.readLen(2)
+	case !isMap && c == 0xdd, isMap && c == 0xdf:
+		n, err = d.readLen(4)
+	case isMap && c&0xf0 == 0x80:
+		return int(c & 0x0f), nil
+	default:
+		kind := "array"
+		if isMap {
+			kind = "map"
+		}
+		return 0, fmt.Errorf("msgpack serialization: expect %s but got 0x%x", kind, c)
+	}
+	return n, err
+}
+
+// decodeGeneric decodes the next value as nil, bool, int64(uint64 if it overflows int64), float32, float64, string,
+// []byte, []interface{} or maps
+func (d *msgpackDecoder) decodeGeneric() (interface{}, error) {
+	c, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return d.decodeGenericMap(int(c & 0x0f))
+	case c&0xf0 == 0x90:
+		return d.decodeGenericArray(int(c & 0x0f))
+	case c&0xe0 == 0xa0:
+		return d.readString(int(c & 0x1f))
+	}
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.readLen(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.readN(n)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), b...), nil
+	case 0xca:
+		u, err := d.readUint(4)
+		return math.Float32frombits(uint32(u)), err
+	case 0xcb:
+		u, err := d.readUint(8)
+		return math.Float64frombits(u), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		u, err := d.readUint(1 << (c - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		if u > math.MaxInt64 {
+			return u, nil
+		}
+		return int64(u), nil
+	case 0xd0:
+		u, err := d.readUint(1)
+		return int64(int8(u)), err
+	case 0xd1:
+		u, err := d.readUint(2)
+		return int64(int16(u)), err
+	case 0xd2:
+		u, err := d.readUint(4)
+		return int64(int32(u)), err
+	case 0xd3:
+		u, err := d.readUint(8)
+		return int64(u), err
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.readLen(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.readString(n)
+	case 0xdc, 0xdd:
+		n, err := d.readLen(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeGenericArray(n)
+	case 0xde, 0xdf:
+		n, err := d.readLen(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeGenericMap(n)
+	}
+	return nil, fmt.Errorf("msgpack serialization: not support format 0x%x", c)
+}
+
+func (d *msgpackDecoder) readString(n int) (string, error) {
+	b, err := d.readN(n)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (d *msgpackDecoder) decodeGenericArray(n int) ([]interface{}, error) {
+	array := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		v, err := d.decodeGeneric()
+		if err != nil {
+			return nil, err
+		}
+		array = append(array, v)
+	}
+	return array, nil
+}
+
+func (d *msgpackDecoder) decodeGenericMap(n int) (interface{}, error) {
+	keys := make([]interface{}, 0, n)
+	values := make([]interface{}, 0, n)
+	stringKeys := true
+	for i := 0; i < n; i++ {
+		k, err := d.decodeGeneric()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.decodeGeneric()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := k.(string); !ok {
+			if k != nil && !reflect.TypeOf(k).Comparable() {
+				return nil, fmt.Errorf("msgpack serialization: not support map key type %T", k)
+			}
+			stringKeys = false
+		}
+		keys = append(keys, k)
+		values = append(values, v)
+	}
+	if stringKeys {
+		m := make(map[string]interface{}, n)
+		for i, k := range keys {
+			m[k.(string)] = values[i]
+		}
+		return m, nil
+	}
+	m := make(map[interface{}]interface{}, n)
+	for i, k := range keys {
+		m[k] = values[i]
+	}
+	return m, nil
+}
+
+func (d *msgpackDecoder) peekNil() bool {
+	if d.pos < len(d.b) && d.b[d.pos] == 0xc0 {
+		d.pos++
+		return true
+	}
+	return false
+}
+
+// decodeInto decodes the next value into the settable rv
+func (d *msgpackDecoder) decodeInto(rv reflect.Value) error {
+	if d.peekNil() {
+		rv.Set(reflect.Zero(rv.Type()))
+		return nil
+	}
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		return d.decodeInto(rv.Elem())
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			break
+		}
+		n, err := d.readContainerLen(false)
+		if err != nil {
+			return err
+		}
+		slice := reflect.MakeSlice(rv.Type(), n, n)
+		for i := 0; i < n; i++ {
+			if err = d.decodeInto(slice.Index(i)); err != nil {
+				return err
+			}
+		}
+		rv.Set(slice)
+		return nil
+	case reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			break
+		}
+		n, err := d.readContainerLen(false)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			if i >= rv.Len() {
+				if _, err = d.decodeGeneric(); err != nil {
+					return err
+				}
+			} else if err = d.decodeInto(rv.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		n, err := d.readContainerLen(true)
+		if err != nil {
+			return err
+		}
+		t := rv.Type()
+		m := reflect.MakeMapWithSize(t, n)
+		for i := 0; i < n; i++ {
+			k := reflect.New(t.Key()).Elem()
+			if err = d.decodeInto(k); err != nil {
+				return err
+			}
+			v := reflect.New(t.Elem()).Elem()
+			if err = d.decodeInto(v); err != nil {
+				return err
+			}
+			m.SetMapIndex(k, v)
+		}
+		rv.Set(m)
+		return nil
+	case reflect.Struct:
+		return d.decodeStruct(rv)
+	}
+	v, err := d.decodeGeneric()
+	if err != nil {
+		return err
+	}
+	return msgpackSet(rv, v)
+}
+
+func (d *msgpackDecoder) decodeStruct(rv reflect.Value) error {
+	n, err := d.readContainerLen(true)
+	if err != nil {
+		return err
+	}
+	fields := msgpackFields(rv.Type())
+	for i := 0; i < n; i++ {
+		k, err := d.decodeGeneric()
+		if err != nil {
+			return err
+		}
+		name, _ := k.(string)
+		var field *msgpackField
+		for j := range fields {
+			if fields[j].name == name {
+				field = &fields[j]
+				break
+			}
+		}
+		if field == nil {
+			for j := range fields {
+				if strings.EqualFold(fields[j].name, name) {
+					field = &fields[j]
+					break
+				}
+			}
+		}
+		if field == nil {
+			// the unknown fields are skipped
+			if _, err = d.decodeGeneric(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err = d.decodeInto(rv.FieldByIndex(field.index)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// msgpackSet sets the generic scalar value v into rv
+func msgpackSet(rv reflect.Value, v interface{}) error {
+	fail := func() error {
+		return fmt.Errorf("msgpack serialization: can not deserialize %T into %s", v, rv.Type())
+	}
+	switch rv.Kind() {
+	case reflect.Interface:
+		if rv.NumMethod() != 0 {
+			return fail()
+		}
+		rv.Set(reflect.ValueOf(v))
+	case reflect.Bool:
+		b, ok := v.(bool)
+		if !ok {
+			return fail()
+		}
+		rv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := v.(int64)
+		if !ok || rv.OverflowInt(i) {
+			return fail()
+		}
+		rv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var u uint64
+		switch n := v.(type) {
+		case int64:
+			if n < 0 {
+				return fail()
+			}
+			u = uint64(n)
+		case uint64:
+			u = n
+		default:
+			return fail()
+		}
+		if rv.OverflowUint(u) {
+			return fail()
+		}
+		rv.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		switch n := v.(type) {
+		case float32:
+			rv.SetFloat(float64(n))
+		case float64:
+			rv.SetFloat(n)
+		case int64:
+			rv.SetFloat(float64(n))
+		case uint64:
+			rv.SetFloat(float64(n))
+		default:
+			return fail()
+		}
+	case reflect.String:
+		switch s := v.(type) {
+		case string:
+			rv.SetString(s)
+		case []byte:
+			rv.SetString(string(s))
+		default:
+			return fail()
+		}
+	case reflect.Slice, reflect.Array: // the byte slices and arrays
+		var b []byte
+		switch s := v.(type) {
+		case []byte:
+			b = s
+		case string:
+			b = []byte(s)
+		default:
+			return fail()
+		}
+		if rv.Kind() == reflect.Slice {
+			rv.SetBytes(b)
+		} else {
+			reflect.Copy(rv, reflect.ValueOf(b))
+		}
+	default:
+		return fail()
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package serialize
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type msgpackTestValue struct {
+	Name    string            `msgpack:"name"`
+	Count   int32             `msgpack:"count"`
+	Tags    []string          `msgpack:"tags,omitempty"`
+	Attrs   map[string]string `msgpack:"attrs"`
+	Score   float64
+	Next    *msgpackTestValue `msgpack:"next,omitempty"`
+	Ignored string            `msgpack:"-"`
+}
+
+func TestMsgpackSerialization(t *testing.T) {
+	s := &MsgpackSerialization{}
+	CheckSerialeNumber(t, s, MsgpackNumber)
+
+	// the encoding of the spec
+	cases := []struct {
+		v      interface{}
+		expect []byte
+	}{
+		{nil, []byte{0xc0}},
+		{true, []byte{0xc3}},
+		{1, []byte{0x01}},
+		{-1, []byte{0xff}},
+		{200, []byte{0xcc, 0xc8}},
+		{-200, []byte{0xd1, 0xff, 0x38}},
+		{int64(math.MaxInt64), []byte{0xcf, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{1.5, []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{"abc", []byte{0xa3, 'a', 'b', 'c'}},
+		{[]byte{1, 2}, []byte{0xc4, 2, 1, 2}},
+		{[]int{1, 2}, []byte{0x92, 1, 2}},
+		{map[string]int{"b": 2, "a": 1}, []byte{0x82, 0xa1, 'a', 1, 0xa1, 'b', 2}},
+	}
+	for _, c := range cases {
+		b, err := s.Serialize(c.v)
+		if err != nil || !bytes.Equal(c.expect, b) {
+			t.Errorf("serialize %v not correct. expect:%x, real:%x, err:%v", c.v, c.expect, b, err)
+		}
+	}
+	long := strings.Repeat("a", 300)
+	b, _ := s.Serialize(long)
+	if v, err := s.DeSerialize(b, ""); err != nil || v != long || b[0] != 0xda {
+		t.Errorf("long string not correct. err:%v", err)
+	}
+
+	expect := msgpackTestValue{Name: "hello", Count: -3, Tags: []string{"a", "b"}, Attrs: map[string]string{"k": "v"}, Score: 0.5, Ignored: "x"}
+	expect.Next = &msgpackTestValue{Name: "next", Attrs: map[string]string{}}
+	b, err := s.Serialize(expect)
+	if err != nil {
+		t.Fatalf("serialize struct fail. err:%v", err)
+	}
+	expect.Ignored = ""
+	v, err := s.DeSerialize(b, msgpackTestValue{})
+	if err != nil || !reflect.DeepEqual(expect, v) {
+		t.Errorf("deserialize value not correct. expect:%+v, real:%+v, err:%v", expect, v, err)
+	}
+	actual := &msgpackTestValue{}
+	if _, err = s.DeSerialize(b, actual); err != nil || !reflect.DeepEqual(expect, *actual) {
+		t.Errorf("deserialize to pointer not correct. expect:%+v, real:%+v, err:%v", expect, actual, err)
+	}
+	v, err = s.DeSerialize(b, nil)
+	if m, ok := v.(map[string]interface{}); err != nil || !ok || m["name"] != "hello" || m["count"] != int64(-3) || m["Score"] != 0.5 {
+		t.Errorf("deserialize generic value not correct. result:%+v, err:%v", v, err)
+	}
+	if _, err = s.DeSerialize(b, ""); err == nil {
+		t.Errorf("deserialize struct into string should fail")
+	}
+	if _, err = s.DeSerialize([]byte{0xcc, 200}, int8(0)); err == nil {
+		t.Errorf("deserialize overflow value should fail")
+	}
+	if _, err = s.DeSerialize([]byte{0xa3, 'a'}, nil); err != errMsgpackShort {
+		t.Errorf("deserialize short data should fail. err:%v", err)
+	}
+	v, err = s.DeSerialize([]byte{0x81, 1, 0xa1, 'a'}, nil)
+	if m, ok := v.(map[interface{}]interface{}); err != nil || !ok || m[int64(1)] != "a" {
+		t.Errorf("deserialize generic map not correct. result:%+v, err:%v", v, err)
+	}
+
+	b, err = s.SerializeMulti([]interface{}{"a", 1, reflect.ValueOf(expect), nil})
+	if err != nil {
+		t.Fatalf("serialize multi fail. err:%v", err)
+	}
+	values, err := s.DeSerializeMulti(b, []interface{}{"", 0, &msgpackTestValue{}, ""})
+	if err != nil || values[0] != "a" || values[1] != 1 || !reflect.DeepEqual(&expect, values[2]) || values[3] != "" {
+		t.Errorf("deserialize multi not correct. result:%+v, err:%v", values, err)
+	}
+	// the argument types of the provider methods
+	values, err = s.DeSerializeMulti(b, []interface{}{reflect.TypeOf(""), reflect.TypeOf(0), reflect.TypeOf(&msgpackTestValue{}), reflect.TypeOf((*string)(nil))})
+	if err != nil || values[0] != "a" || values[1] != 1 || !reflect.DeepEqual(&expect, values[2]) || values[3].(*string) != nil {
+		t.Errorf("deserialize multi by types not correct. result:%+v, err:%v", values, err)
+	}
+	if _, err = s.DeSerializeMulti(b, []interface{}{""}); err != ErrMsgpackMultiCount {
+		t.Errorf("deserialize multi should fail with less values. err:%v", err)
+	}
+	if _, err = s.DeSerializeMulti(b, []interface{}{"", 0, nil, nil, nil}); err != ErrMsgpackMultiCount {
+		t.Errorf("deserialize multi should fail with more values. err:%v", err)
+	}
+	if values, err = s.DeSerializeMulti(b, nil); err != nil || len(values) != 4 || values[3] != nil {
+		t.Errorf("deserialize multi generic values not correct. result:%+v, err:%v", values, err)
+	}
+}
@@ -10,6 +10,10 @@ const (
 	Pb     = "protobuf"
 	GrpcPb = "grpc-pb"
 	Breeze = "breeze"
+	// DynamicPb serialize protobuf messages with descriptors loaded at runtime
+	DynamicPb = "dynamic-pb"
+	JSON      = "json"
+	Msgpack   = "msgpack"
 )
 
 // serialization number in motan2 header
@@ -23,6 +27,7 @@ const (
 	SimpleNumber
 	GrpcPbJSONNumber
 	BreezeNumber
+	DynamicPbNumber
 )
 
 func RegistDefaultSerializations(extFactory motan.ExtensionFactory) {
@@ -38,4 +43,13 @@ func RegistDefaultSerializations(extFactory motan.ExtensionFactory) {
 	extFactory.RegistryExtSerialization(Breeze, BreezeNumber, func() motan.Serialization {
 		return &BreezeSerialization{}
 	})
+	extFactory.RegistryExtSerialization(DynamicPb, DynamicPbNumber, func() motan.Serialization {
+		return &DynamicPbSerialization{}
+	})
+	extFactory.RegistryExtSerialization(JSON, JSONNumber, func() motan.Serialization {
+		return &JSONSerialization{}
+	})
+	extFactory.RegistryExtSerialization(Msgpack, MsgpackNumber, func() motan.Serialization {
+		return &MsgpackSerialization{}
+	})
 }
@@ -1,16 +1,22 @@
 package motan
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"github.com/weibocom/motan-go/config"
+	"net"
 	"reflect"
 	"strconv"
 	"sync"
+	"time"
 
 	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/filter"
 	"github.com/weibocom/motan-go/log"
+	"github.com/weibocom/motan-go/metrics"
+	"github.com/weibocom/motan-go/serialize"
 	mserver "github.com/weibocom/motan-go/server"
 )
 
@@ -23,6 +29,14 @@ type MSContext struct {
 	portServer   map[int]motan.Server
 	serviceImpls map[string]interface{}
 	registries   map[string]motan.Registry // all registries used for services
+	exporters    map[string]*mserver.DefaultExporter
+
+	confFile            string
+	configWatchInterval time.Duration
+	stopWatch           chan struct{}
+	adminPort           int
+	adminListener       net.Listener
+	hotUpgrade          bool
 
 	csync  sync.Mutex
 	inited bool
@@ -70,8 +84,25 @@ func NewMotanServerContextFromConfig(conf *config.Config) (ms *MSContext) {
 	if section != nil && section["log_level"] != nil {
 		logLevel = section["log_level"].(string)
 	}
-	initLog(logDir, logAsync, logStructured, rotatePerHour, logLevel)
+	logFormat := ""
+	if section != nil && section["log_format"] != nil {
+		logFormat = section["log_format"].(string)
+	}
+	initLog(logDir, logAsync, logStructured, rotatePerHour, logLevel, logFormat)
+	if section != nil {
+		if interval, err := strconv.Atoi(fmt.Sprint(section[ConfigWatchIntervalKey])); err == nil && interval > 0 {
+			ms.configWatchInterval = time.Duration(interval) * time.Millisecond
+		}
+		if port, err := strconv.Atoi(fmt.Sprint(section[ServerAdminPortKey])); err == nil && port > 0 {
+			ms.adminPort = port
+		}
+		if hotUpgrade, ok := section[ServerHotUpgradeKey].(bool); ok {
+			ms.hotUpgrade = hotUpgrade
+		}
+	}
 	registerSwitchers(ms.context)
+	metrics.StartReporter(ms.context)
+	filter.StartOTelTracing(ms.context)
 
 	return ms
 }
@@ -99,6 +130,7 @@ func GetMotanServerContextWithFlagParse(confFile string, parseFlag bool) *MSCont
 	ms := serverContextMap[confFile]
 	if ms == nil {
 		ms = NewMotanServerContextFromConfig(conf)
+		ms.confFile = confFile
 		serverContextMap[confFile] = ms
 	}
 	return ms
@@ -115,10 +147,26 @@ func (m *MSContext) Start(extfactory motan.ExtensionFactory) {
 	for _, url := range m.context.ServiceURLs {
 		m.export(url)
 	}
+	if m.stopWatch == nil {
+		m.stopWatch = make(chan struct{})
+		m.startConfigWatcher()
+	}
+	if m.adminPort > 0 && m.adminListener == nil {
+		m.startAdminServer(m.adminPort)
+	}
+	if m.hotUpgrade {
+		watchUpgradeSignal(motan.DefaultHotUpgradeTimeout, defaultHandoverTimeout, func(ctx context.Context) {
+			mserver.HandoverShutdown(ctx)
+		})
+	}
+	// the parent process drains after all services are exported, if the server context is started by the upgrade
+	motan.UpgradeReady()
 }
 
-func (m *MSContext) export(url *motan.URL) {
+// export returns true if the service of the url is exported
+func (m *MSContext) export(url *motan.URL) (exported bool) {
 	defer motan.HandlePanic(nil)
+	key := url.ToExtInfo()
 	service := m.serviceImpls[url.Parameters[motan.RefKey]]
 	if service != nil {
 		//TODO multi protocol support. convert to multi url
@@ -147,6 +195,12 @@ func (m *MSContext) export(url *motan.URL) {
 			url.Host = motan.GetLocalIP()
 		}
 		url.ClearCachedInfo()
+		if path := url.GetParam(serialize.PbDescriptorSetKey, ""); path != "" {
+			if err = serialize.LoadPbDescriptorSet(path); err != nil {
+				vlog.Errorf("service export fail! load protobuf descriptor set fail. url:%+v, err:%v", url, err)
+				return
+			}
+		}
 		provider := GetDefaultExtFactory().GetProvider(url)
 		provider.SetService(service)
 		motan.Initialize(provider)
@@ -162,7 +216,10 @@ func (m *MSContext) export(url *motan.URL) {
 			handler := GetDefaultExtFactory().GetMessageHandler("default")
 			motan.Initialize(handler)
 			handler.AddProvider(provider)
-			server.Open(false, false, handler, m.extFactory)
+			if err = server.Open(false, false, handler, m.extFactory); err != nil {
+				vlog.Errorf("service export fail! open server fail. url:%v, err:%v", url, err)
+				return
+			}
 			m.portServer[url.Port] = server
 		} else if canShareChannel(*url, *server.GetURL()) {
 			server.GetMessageHandler().AddProvider(provider)
@@ -171,6 +228,10 @@ func (m *MSContext) export(url *motan.URL) {
 			return
 		}
 		err = exporter.Export(server, m.extFactory, m.context)
+		if _, partial := err.(*mserver.InvalidRegistryError); partial {
+			vlog.Warningf("service exported to part of the registries. url:%v, err:%v", url, err)
+			err = nil
+		}
 		if err != nil {
 			vlog.Errorf("service export fail! url:%v, err:%v", url, err)
 		} else {
@@ -181,8 +242,11 @@ func (m *MSContext) export(url *motan.URL) {
 					m.registries[rid] = r
 				}
 			}
+			m.addExporter(key, exporter)
+			exported = true
 		}
 	}
+	return exported
 }
 
 func (m *MSContext) Initialize() {
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"strings"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+	mpro "github.com/weibocom/motan-go/protocol"
+)
+
+// MethodACLPrefix is the prefix of the provider url parameters of the method callers allowlist, e.g. acl.hello=app1,app2.
+// the caller is the application of the request(M_s), '*' allows all callers and an empty list allows no caller.
+// the methods without acl are allowed for all callers
+const MethodACLPrefix = "acl."
+
+// methodACLs is the callers allowlist of the methods of a provider, method -> callers
+type methodACLs map[string]map[string]bool
+
+func parseMethodACLs(url *motan.URL) methodACLs {
+	var acls methodACLs
+	for k, v := range url.Parameters {
+		if !strings.HasPrefix(k, MethodACLPrefix) || len(k) == len(MethodACLPrefix) {
+			continue
+		}
+		if acls == nil {
+			acls = make(methodACLs)
+		}
+		acls[k[len(MethodACLPrefix):]] = newCallerSet(motan.TrimSplit(v, ","))
+	}
+	return acls
+}
+
+func newCallerSet(callers []string) map[string]bool {
+	set := make(map[string]bool, len(callers))
+	for _, c := range callers {
+		if c != "" {
+			set[c] = true
+		}
+	}
+	return set
+}
+
+// with returns a copy of the acls with the callers of the method, nil callers removes the acl of the method
+func (a methodACLs) with(method string, callers []string) methodACLs {
+	acls := make(methodACLs, len(a)+1)
+	for m, c := range a {
+		acls[m] = c
+	}
+	if callers == nil {
+		delete(acls, method)
+	} else {
+		acls[method] = newCallerSet(callers)
+	}
+	return acls
+}
+
+// check returns an exception response if the caller is not allowed to call the method, otherwise returns nil
+func (a methodACLs) check(request motan.Request) motan.Response {
+	if len(a) == 0 {
+		return nil
+	}
+	callers, ok := a[request.GetMethod()]
+	if !ok {
+		if callers, ok = a[motan.FirstUpper(request.GetMethod())]; !ok {
+			return nil
+		}
+	}
+	caller := request.GetAttachment(mpro.MSource)
+	if callers["*"] || (caller != "" && callers[caller]) {
+		return nil
+	}
+	vlog.Warningf("request rejected by acl. req:%s, caller:%s", motan.GetReqInfo(request), caller)
+	return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 403, ErrMsg: "caller " + caller + " is not allowed to call method " + request.GetMethod(), ErrType: motan.ServiceException})
+}
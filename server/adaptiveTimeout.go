@@ -0,0 +1,146 @@
+package server
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+	"github.com/weibocom/motan-go/metrics"
+)
+
+// AdaptiveTimeoutKey is the provider url parameter to learn the timeouts of the methods from the observed latency with "true".
+// the learned timeout is the p99 latency * AdaptiveTimeoutFactorKey bounded by AdaptiveTimeoutMinKey and AdaptiveTimeoutMaxKey,
+// it replaces the provider requestTimeout, and limits the remaining timeout of the caller. the method timeouts are still
+// used for the methods configured in MethodTimeoutsKey
+const AdaptiveTimeoutKey = "adaptiveTimeout"
+
+const (
+	AdaptiveTimeoutFactorKey = "adaptiveTimeoutFactor"
+	AdaptiveTimeoutMinKey    = "adaptiveTimeoutMin" // ms
+	AdaptiveTimeoutMaxKey    = "adaptiveTimeoutMax" // ms
+
+	// AdaptiveTimeoutMetricSuffix is the gauge of the learned timeout in ms, the key prefix is the same as the call metrics
+	AdaptiveTimeoutMetricSuffix = ".adaptive_timeout"
+)
+
+const (
+	defaultAdaptiveTimeoutFactor = 2.0
+	defaultAdaptiveTimeoutMin    = 10 * time.Millisecond
+	// the recent latency samples of a method, the timeout is learned again every adaptiveTimeoutInterval samples, and not
+	// learned before adaptiveTimeoutInterval samples are observed
+	adaptiveTimeoutSamples  = 1000
+	adaptiveTimeoutInterval = 100
+)
+
+var addCallGauge = metrics.AddGauge
+
+type methodLatency struct {
+	lock    sync.Mutex
+	samples []time.Duration // ring of the recent latencies
+	next    int
+	count   int64
+	timeout int64 // learned timeout in ns, 0 if not learned yet
+}
+
+// adaptiveTimeouts learns the timeouts of the methods of a provider
+type adaptiveTimeouts struct {
+	factor  float64
+	min     time.Duration
+	max     time.Duration
+	metrics *callMetrics
+	lock    sync.RWMutex
+	methods map[string]*methodLatency
+}
+
+// parseAdaptiveTimeouts returns nil if the adaptive timeout is not enabled
+func parseAdaptiveTimeouts(url *motan.URL) *adaptiveTimeouts {
+	if url.GetParam(AdaptiveTimeoutKey, "") != "true" {
+		return nil
+	}
+	a := &adaptiveTimeouts{factor: defaultAdaptiveTimeoutFactor, min: defaultAdaptiveTimeoutMin, methods: make(map[string]*methodLatency),
+		metrics: newCallMetrics(url.Group, url.Path, url.GetParam(motan.ApplicationKey, metrics.DefaultStatApplication))}
+	if v := url.GetParam(AdaptiveTimeoutFactorKey, ""); v != "" {
+		if factor, err := strconv.ParseFloat(v, 64); err == nil && factor >= 1 {
+			a.factor = factor
+		} else {
+			vlog.Warningf("illegal %s of provider %s: %s, use default: %v", AdaptiveTimeoutFactorKey, url.Path, v, defaultAdaptiveTimeoutFactor)
+		}
+	}
+	a.min = url.GetTimeDuration(AdaptiveTimeoutMinKey, time.Millisecond, defaultAdaptiveTimeoutMin)
+	a.max = url.GetTimeDuration(AdaptiveTimeoutMaxKey, time.Millisecond, url.GetTimeDuration(motan.TimeOutKey, time.Millisecond, defaultRequestTimeout))
+	if a.max < a.min {
+		a.max = a.min
+	}
+	vlog.Infof("adaptive timeout enabled for provider %s, factor: %v, min: %v, max: %v", url.Path, a.factor, a.min, a.max)
+	return a
+}
+
+func (a *adaptiveTimeouts) latency(method string) *methodLatency {
+	a.lock.RLock()
+	l := a.methods[method]
+	a.lock.RUnlock()
+	if l != nil {
+		return l
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if l = a.methods[method]; l == nil {
+		l = &methodLatency{samples: make([]time.Duration, adaptiveTimeoutSamples)}
+		a.methods[method] = l
+	}
+	return l
+}
+
+// observe records the latency of a call, and learns the timeout of the method every adaptiveTimeoutInterval calls
+func (a *adaptiveTimeouts) observe(method string, latency time.Duration) {
+	if a == nil {
+		return
+	}
+	l := a.latency(method)
+	l.lock.Lock()
+	l.samples[l.next] = latency
+	l.next = (l.next + 1) % len(l.samples)
+	l.count++
+	if l.count%adaptiveTimeoutInterval != 0 {
+		l.lock.Unlock()
+		return
+	}
+	n := len(l.samples)
+	if l.count < int64(n) {
+		n = int(l.count)
+	}
+	sorted := make([]time.Duration, n)
+	copy(sorted, l.samples[:n])
+	l.lock.Unlock()
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	p99 := sorted[int(math.Ceil(float64(n)*0.99))-1]
+	timeout := time.Duration(float64(p99) * a.factor)
+	if timeout < a.min {
+		timeout = a.min
+	} else if timeout > a.max {
+		timeout = a.max
+	}
+	atomic.StoreInt64(&l.timeout, int64(timeout))
+	addCallGauge(a.metrics.group, a.metrics.service, a.metrics.methodKeys(method).adaptiveTimeout, int64(timeout/time.Millisecond))
+}
+
+// get returns the learned timeout of the method, ok is false if it is not learned yet
+func (a *adaptiveTimeouts) get(method string) (time.Duration, bool) {
+	if a == nil {
+		return 0, false
+	}
+	a.lock.RLock()
+	l := a.methods[method]
+	a.lock.RUnlock()
+	if l == nil {
+		return 0, false
+	}
+	timeout := time.Duration(atomic.LoadInt64(&l.timeout))
+	return timeout, timeout > 0
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// QueueTimeSLOKey is the provider url parameter of method queue time SLO, the value is a json map of method name to SLO in ms.
+// e.g. {"hello":20}
+// the queue time is the duration from the request being decoded to the request being handled.
+// new requests of a method will be rejected with 503 while its recent queue time exceeds the SLO
+const QueueTimeSLOKey = "queueTimeSLO"
+
+// weight of the latest sample in the recent queue time
+const queueTimeDecay = 0.2
+
+type methodQueueTime struct {
+	slo    time.Duration
+	lock   sync.Mutex
+	recent float64 // ns
+}
+
+// observe records the queue time of a request, and returns whether the request is admitted
+func (m *methodQueueTime) observe(queueTime time.Duration) (recent time.Duration, admitted bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.recent = m.recent*(1-queueTimeDecay) + float64(queueTime)*queueTimeDecay
+	recent = time.Duration(m.recent)
+	return recent, recent <= m.slo
+}
+
+// queueTimeAdmission sheds requests of the methods whose queue time breaches the SLO
+type queueTimeAdmission map[string]*methodQueueTime
+
+func newQueueTimeAdmission(url *motan.URL) (queueTimeAdmission, error) {
+	value := url.GetParam(QueueTimeSLOKey, "")
+	if value == "" {
+		return nil, nil
+	}
+	var raw map[string]int64
+	if err := json.Unmarshal([]byte(value), &raw); err != nil {
+		return nil, fmt.Errorf("illegal %s: %s, err: %v", QueueTimeSLOKey, value, err)
+	}
+	admission := make(queueTimeAdmission, len(raw))
+	for method, ms := range raw {
+		if method == "" {
+			return nil, errors.New("illegal " + QueueTimeSLOKey + ": empty method name")
+		}
+		if ms <= 0 {
+			return nil, fmt.Errorf("illegal %s: SLO of method %s must be positive, value: %d", QueueTimeSLOKey, method, ms)
+		}
+		admission[method] = &methodQueueTime{slo: time.Duration(ms) * time.Millisecond}
+	}
+	return admission, nil
+}
+
+// admit returns an exception response if the request should be shed, otherwise returns nil
+func (q queueTimeAdmission) admit(request motan.Request, retryAfter *retryAfterPolicy) motan.Response {
+	if q == nil {
+		return nil
+	}
+	m, ok := q[request.GetMethod()]
+	if !ok {
+		if m, ok = q[motan.FirstUpper(request.GetMethod())]; !ok {
+			return nil
+		}
+	}
+	ctx := request.GetRPCContext(false)
+	if ctx == nil || ctx.RequestReceiveTime.IsZero() {
+		return nil
+	}
+	if recent, admitted := m.observe(time.Since(ctx.RequestReceiveTime)); !admitted {
+		vlog.Warningf("request rejected by queue time SLO. req:%s, recent queue time:%v, SLO:%v", motan.GetReqInfo(request), recent, m.slo)
+		return overloadResponse(request, retryAfter, "queue time exceeds SLO of method "+request.GetMethod(), float64(recent)/float64(m.slo))
+	}
+	return nil
+}
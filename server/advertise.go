@@ -0,0 +1,58 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	motan "github.com/weibocom/motan-go/core"
+)
+
+// AdvertisedProtocolPrefix is the prefix of the protocol parameters published with the exported url, e.g. protocol.supportedVersions.
+// the values are the settings the server actually uses, clients can configure their connections with them
+const AdvertisedProtocolPrefix = "protocol."
+
+// advertisedProtocolParams are the server level settings, the value function returns the normalized value of the server url,
+// empty means the parameter is not advertised
+var advertisedProtocolParams = map[string]func(url *motan.URL) string{
+	SupportedVersionsKey: func(url *motan.URL) string {
+		versions := parseSupportedVersions(url.GetParam(SupportedVersionsKey, ""))
+		s := make([]string, 0, len(versions))
+		for _, v := range versions {
+			s = append(s, strconv.Itoa(v))
+		}
+		return strings.Join(s, ",")
+	},
+	KeepAlivePeriodKey: func(url *motan.URL) string {
+		return url.GetParam(KeepAlivePeriodKey, "")
+	},
+}
+
+// protocolParams returns the protocol parameters to advertise for the provider url. the settings come from the server url,
+// because providers sharing a port are served with the settings of the first exported url. it fails if the provider url
+// declares a setting different from the server's
+func protocolParams(url *motan.URL, server motan.Server) (map[string]string, error) {
+	if server == nil || server.GetURL() == nil {
+		return nil, nil
+	}
+	params := make(map[string]string, len(advertisedProtocolParams))
+	for key, value := range advertisedProtocolParams {
+		actual := value(server.GetURL())
+		if declared := url.GetParam(key, ""); declared != "" && value(url) != actual {
+			return nil, fmt.Errorf("protocol parameter %s mismatch, declared: %s, server: %s", key, declared, actual)
+		}
+		if actual != "" {
+			params[AdvertisedProtocolPrefix+key] = actual
+		}
+	}
+	return params, nil
+}
+
+// clearAdvertisedParams removes the protocol parameters and placement hints published by the last export
+func clearAdvertisedParams(url *motan.URL) {
+	for k := range url.Parameters {
+		if strings.HasPrefix(k, AdvertisedProtocolPrefix) || strings.HasPrefix(k, motan.PlacementPrefix) {
+			delete(url.Parameters, k)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"unicode/utf8"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// attachment limit url parameter key
+const (
+	MaxAttachmentValueSizeKey = "maxAttachmentValueSize" // max bytes of a single attachment value, 0 means no limit
+	AttachmentOverflowKey     = "attachmentOverflow"     // policy for oversized attachment value: truncate or reject
+)
+
+func init() {
+	motan.RegisterReservedAttachmentKeys(PriorityAttachment, ReplayedAttachment, PageCursorAttachment, PageTotalAttachment,
+		SchemaVersionAttachment, SkipFiltersAttachment, SkipFiltersTokenAttachment, ETagAttachment, IfNoneMatchAttachment,
+		NotModifiedAttachment, SunsetAttachment, TruncatedAttachment, ResponseFormatAttachment)
+}
+
+// attachment overflow policy
+const (
+	AttachmentOverflowTruncate = "truncate"
+	AttachmentOverflowReject   = "reject"
+)
+
+type attachmentLimit struct {
+	maxValueSize int
+	reject       bool
+}
+
+func getAttachmentLimit(url *motan.URL) attachmentLimit {
+	return attachmentLimit{
+		maxValueSize: int(url.GetPositiveIntValue(MaxAttachmentValueSizeKey, 0)),
+		reject:       url.GetParam(AttachmentOverflowKey, AttachmentOverflowTruncate) == AttachmentOverflowReject,
+	}
+}
+
+// apply checks every attachment value against the size limit. oversized values will be truncated at a rune boundary,
+// or the first oversized key will be returned if the policy is reject.
+func (a attachmentLimit) apply(holder motan.Attachment, desc string) (overflowKey string, ok bool) {
+	if a.maxValueSize <= 0 {
+		return "", true
+	}
+	attachments := holder.GetAttachments()
+	ok = true
+	attachments.Range(func(k, v string) bool {
+		if len(v) <= a.maxValueSize {
+			return true
+		}
+		vlog.Warningf("%s attachment value too large. key:%s, size:%d, limit:%d", desc, k, len(v), a.maxValueSize)
+		if a.reject {
+			overflowKey = k
+			ok = false
+			return false
+		}
+		// a multi-byte character is not split
+		n := a.maxValueSize
+		for n > 0 && !utf8.RuneStart(v[n]) {
+			n--
+		}
+		attachments.Store(k, v[:n])
+		return true
+	})
+	return overflowKey, ok
+}
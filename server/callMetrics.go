@@ -0,0 +1,156 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/metrics"
+)
+
+// CallMetricsKey is the provider url parameter to disable the call metrics of DefaultMessageHandler with "false".
+// the metrics are keyed by the service path and method: motan-server-handler:{application}:{method}{suffix}
+const CallMetricsKey = "callMetrics"
+
+// UnknownMetricLabel replaces the group, the service and the method of the calls not served, so the metrics are not
+// keyed by the names sent by the clients. the methods beyond MaxMethodsKey of a provider are also recorded with it
+const UnknownMetricLabel = "unknown"
+
+const (
+	callMetricsRole = "motan-server-handler"
+
+	CallMetricsTotalCountSuffix     = ".total_count"
+	CallMetricsExceptionCountSuffix = ".exception_count" // including the panics
+	CallMetricsPanicCountSuffix     = ".panic_count"
+	CallMetricsNotFoundCountSuffix  = ".not_found_count"
+	CallMetricsLatencySuffix        = ".latency" // histogram in ms
+)
+
+// the metrics funcs of the call metrics, they are replaced in tests
+var (
+	addCallCounter   = metrics.AddCounter
+	addCallHistogram = metrics.AddHistograms
+)
+
+type callMetricKeys struct {
+	method    string // the method label of the prometheus metrics
+	total     string
+	exception string
+	panic     string
+	notFound  string
+	latency   string
+	// gauge of the adaptive timeout
+	adaptiveTimeout string
+}
+
+// callMetrics records the calls of a service, the keys of the methods are cached, so no key is built in the calls
+type callMetrics struct {
+	group   string
+	service string
+	prefix  string
+	// the unescaped labels of the prometheus metrics
+	rawGroup   string
+	rawService string
+	// the methods not served by the provider are recorded as unknown, nil provider serves all methods
+	provider   motan.Provider
+	maxMethods int
+	lock       sync.RWMutex
+	keys       map[string]*callMetricKeys
+	unknown    *callMetricKeys
+}
+
+func newCallMetrics(group string, service string, application string) *callMetrics {
+	c := &callMetrics{group: metrics.Escape(group), service: metrics.Escape(service), rawGroup: group, rawService: service,
+		prefix:     callMetricsRole + metrics.KeyDelimiter + metrics.Escape(application) + metrics.KeyDelimiter,
+		maxMethods: defaultMaxMethods, keys: make(map[string]*callMetricKeys)}
+	c.unknown = c.newMethodKeys(UnknownMetricLabel)
+	return c
+}
+
+// parseCallMetrics returns nil if the call metrics of the provider are disabled
+func parseCallMetrics(p motan.Provider) *callMetrics {
+	url := p.GetURL()
+	if url.GetParam(CallMetricsKey, "") == "false" {
+		return nil
+	}
+	c := newCallMetrics(url.Group, url.Path, url.GetParam(motan.ApplicationKey, metrics.DefaultStatApplication))
+	c.provider = p
+	c.maxMethods = int(url.GetPositiveIntValue(MaxMethodsKey, defaultMaxMethods))
+	return c
+}
+
+func (c *callMetrics) newMethodKeys(method string) *callMetricKeys {
+	prefix := c.prefix + metrics.Escape(method)
+	return &callMetricKeys{method: method, total: prefix + CallMetricsTotalCountSuffix, exception: prefix + CallMetricsExceptionCountSuffix,
+		panic: prefix + CallMetricsPanicCountSuffix, notFound: prefix + CallMetricsNotFoundCountSuffix, latency: prefix + CallMetricsLatencySuffix,
+		adaptiveTimeout: prefix + AdaptiveTimeoutMetricSuffix}
+}
+
+// methodKeys returns the cached keys of the method, only the keys of the methods served by the provider are cached,
+// at most maxMethods, the other methods share the keys of UnknownMetricLabel
+func (c *callMetrics) methodKeys(method string) *callMetricKeys {
+	c.lock.RLock()
+	keys := c.keys[method]
+	c.lock.RUnlock()
+	if keys != nil {
+		return keys
+	}
+	if !hasMethod(c.provider, method) {
+		return c.unknown
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if keys = c.keys[method]; keys != nil {
+		return keys
+	}
+	if len(c.keys) >= c.maxMethods {
+		return c.unknown
+	}
+	keys = c.newMethodKeys(method)
+	c.keys[method] = keys
+	return keys
+}
+
+// begin records a call in process, the call must be recorded after it is finished
+func (c *callMetrics) begin() {
+	if c != nil {
+		metrics.PromRequestsInFlight.Add(1, callMetricsRole, c.rawGroup, c.rawService)
+	}
+}
+
+func (c *callMetrics) record(request motan.Request, start time.Time, res motan.Response) {
+	if c == nil {
+		return
+	}
+	metrics.PromRequestsInFlight.Add(-1, callMetricsRole, c.rawGroup, c.rawService)
+	keys := c.methodKeys(request.GetMethod())
+	addCallCounter(c.group, c.service, keys.total, 1)
+	var exception *motan.Exception
+	if res == nil {
+		exception = &motan.Exception{ErrCode: 500, ErrMsg: "no response", ErrType: motan.ServiceException}
+	} else {
+		exception = res.GetException()
+	}
+	if exception != nil {
+		addCallCounter(c.group, c.service, keys.exception, 1)
+	}
+	addCallHistogram(c.group, c.service, keys.latency, int64(time.Since(start)/time.Millisecond))
+	metrics.RecordPromCall(callMetricsRole, c.rawGroup, c.rawService, keys.method, time.Since(start), exception)
+}
+
+func (c *callMetrics) recordPanic(request motan.Request) {
+	if c == nil {
+		return
+	}
+	addCallCounter(c.group, c.service, c.methodKeys(request.GetMethod()).panic, 1)
+}
+
+// the metrics of the calls of the services without provider, they are recorded with UnknownMetricLabel
+var notFoundMetrics = newCallMetrics(UnknownMetricLabel, UnknownMetricLabel, metrics.DefaultStatApplication)
+
+// recordNotFound records the calls of the services without provider, they are always recorded because they are not configured
+func recordNotFound() {
+	keys := notFoundMetrics.unknown
+	addCallCounter(notFoundMetrics.group, notFoundMetrics.service, keys.total, 1)
+	addCallCounter(notFoundMetrics.group, notFoundMetrics.service, keys.notFound, 1)
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/metrics"
+	mpro "github.com/weibocom/motan-go/protocol"
+	"github.com/weibocom/motan-go/provider"
+)
+
+type callMetricsRecorder struct {
+	lock   sync.Mutex
+	counts map[string]int64
+}
+
+func (c *callMetricsRecorder) add(group string, service string, key string, value int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.counts[group+"/"+service+"/"+key]++
+}
+
+func (c *callMetricsRecorder) count(group string, service string, key string) int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.counts[group+"/"+service+"/"+key]
+}
+
+func TestCallMetrics(t *testing.T) {
+	recorder := &callMetricsRecorder{counts: make(map[string]int64)}
+	addCallCounter, addCallHistogram = recorder.add, recorder.add
+	defer func() {
+		addCallCounter, addCallHistogram = metrics.AddCounter, metrics.AddHistograms
+	}()
+	p := newTestProvider("metricsService", nil)
+	p.callFunc = func(request motan.Request) motan.Response {
+		switch request.GetMethod() {
+		case "panic":
+			panic("metrics panic")
+		case "fail":
+			return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 500, ErrMsg: "fail", ErrType: motan.BizException})
+		}
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: "ok"}
+	}
+	handler := newTestHandler(p)
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, handler.Call(newTestRequest("metricsService", "hello")).GetException())
+	}
+	assert.NotNil(t, handler.Call(newTestRequest("metricsService", "fail")).GetException())
+	assert.NotNil(t, handler.Call(newTestRequest("metricsService", "panic")).GetException())
+
+	prefix := "motan-server-handler:unknown:"
+	count := func(service string, method string, suffix string) int64 {
+		return recorder.count("test", service, prefix+method+suffix)
+	}
+	assert.Equal(t, int64(3), count("metricsService", "hello", CallMetricsTotalCountSuffix))
+	assert.Equal(t, int64(3), count("metricsService", "hello", CallMetricsLatencySuffix))
+	assert.Equal(t, int64(0), count("metricsService", "hello", CallMetricsExceptionCountSuffix))
+	assert.Equal(t, int64(1), count("metricsService", "fail", CallMetricsTotalCountSuffix))
+	assert.Equal(t, int64(1), count("metricsService", "fail", CallMetricsExceptionCountSuffix))
+	assert.Equal(t, int64(0), count("metricsService", "fail", CallMetricsPanicCountSuffix))
+	assert.Equal(t, int64(1), count("metricsService", "panic", CallMetricsTotalCountSuffix))
+	assert.Equal(t, int64(1), count("metricsService", "panic", CallMetricsExceptionCountSuffix))
+	assert.Equal(t, int64(1), count("metricsService", "panic", CallMetricsPanicCountSuffix))
+
+	// not found, the names sent by the clients are not recorded
+	request := newTestRequest("missingMetricsService", "hello")
+	request.SetAttachment(mpro.MGroup, "missing")
+	assert.NotNil(t, handler.Call(request).GetException())
+	assert.Equal(t, int64(1), recorder.count(UnknownMetricLabel, UnknownMetricLabel, prefix+UnknownMetricLabel+CallMetricsTotalCountSuffix))
+	assert.Equal(t, int64(1), recorder.count(UnknownMetricLabel, UnknownMetricLabel, prefix+UnknownMetricLabel+CallMetricsNotFoundCountSuffix))
+	assert.Equal(t, int64(0), recorder.count("missing", "missingMetricsService", prefix+"hello"+CallMetricsTotalCountSuffix))
+
+	// the methods not served by the provider are recorded as unknown
+	plugin := &provider.DefaultProvider{}
+	plugin.SetURL(&motan.URL{Path: "pluginMetricsService", Group: "test", Parameters: map[string]string{}})
+	plugin.SetService(&pluginService{})
+	plugin.Initialize()
+	handler.AddProvider(plugin)
+	assert.NotNil(t, handler.Call(newTestRequest("pluginMetricsService", "missing")).GetException())
+	assert.Equal(t, int64(1), count("pluginMetricsService", UnknownMetricLabel, CallMetricsTotalCountSuffix))
+	assert.Equal(t, int64(0), count("pluginMetricsService", "missing", CallMetricsTotalCountSuffix))
+	buf := &bytes.Buffer{}
+	assert.Nil(t, metrics.WritePrometheus(buf))
+	assert.Contains(t, buf.String(), `service="pluginMetricsService",method="unknown"`)
+	assert.NotContains(t, buf.String(), `service="pluginMetricsService",method="missing"`)
+
+	// the methods beyond the max methods are recorded as unknown
+	handler.AddProvider(newTestProvider("limitedMetricsService", map[string]string{MaxMethodsKey: "1"}))
+	assert.Nil(t, handler.Call(newTestRequest("limitedMetricsService", "hello")).GetException())
+	assert.Nil(t, handler.Call(newTestRequest("limitedMetricsService", "other")).GetException())
+	assert.Equal(t, int64(1), count("limitedMetricsService", "hello", CallMetricsTotalCountSuffix))
+	assert.Equal(t, int64(1), count("limitedMetricsService", UnknownMetricLabel, CallMetricsTotalCountSuffix))
+	assert.Equal(t, int64(0), count("limitedMetricsService", "other", CallMetricsTotalCountSuffix))
+
+	// disabled
+	handler.AddProvider(newTestProvider("disabledMetricsService", map[string]string{CallMetricsKey: "false"}))
+	assert.Nil(t, handler.Call(newTestRequest("disabledMetricsService", "hello")).GetException())
+	assert.Equal(t, int64(0), count("disabledMetricsService", "hello", CallMetricsTotalCountSuffix))
+}
+
+func BenchmarkCallMetrics(b *testing.B) {
+	addCallCounter, addCallHistogram = func(string, string, string, int64) {}, func(string, string, string, int64) {}
+	defer func() {
+		addCallCounter, addCallHistogram = metrics.AddCounter, metrics.AddHistograms
+	}()
+	stat := newCallMetrics("test", "metricsService", "unknown")
+	request := newTestRequest("metricsService", "hello")
+	res := &motan.MotanResponse{}
+	start := time.Now()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		stat.record(request, start, res)
+	}
+}
@@ -0,0 +1,266 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+	mpro "github.com/weibocom/motan-go/protocol"
+)
+
+// request capture url parameter key, capture is disabled if captureRate is not set, or no sink or body redactor registered.
+// the redact keys only redact the attachments, the serialized bodies are redacted by the CaptureBodyRedactor
+const (
+	CaptureRateKey       = "captureRate"       // sample rate between 0 and 1
+	CaptureMaxCountKey   = "captureMaxCount"   // max captured requests of the server, 0 means no limit
+	CaptureQueueSizeKey  = "captureQueueSize"  // captured request will be dropped when the queue is full
+	CaptureRedactKeysKey = "captureRedactKeys" // attachment keys whose value will be redacted, separated by comma
+)
+
+const (
+	defaultCaptureQueueSize = 1000
+	redactedValue           = "***"
+)
+
+var (
+	captureSink         CaptureSink
+	captureBodyRedactor CaptureBodyRedactor
+	captureSinkLock     sync.RWMutex
+)
+
+// CapturedRequest is a replayable record of a request received by MotanServer.
+// the body is the raw(not gzipped) serialized arguments, so it can be sent again with the same serialization
+type CapturedRequest struct {
+	Time          int64             `json:"time"` // ms
+	RequestID     uint64            `json:"requestId"`
+	Service       string            `json:"service"`
+	Method        string            `json:"method"`
+	MethodDesc    string            `json:"methodDesc"`
+	Group         string            `json:"group"`
+	Serialization int               `json:"serialization"`
+	Attachments   map[string]string `json:"attachments"`
+	Body          []byte            `json:"body"`
+	// the response sent for the request, the replayed responses are compared with it, see Replayer
+	Response *CapturedResponse `json:"response,omitempty"`
+}
+
+// CapturedResponse is the response of a captured request, the body is the raw(not gzipped) serialized value
+type CapturedResponse struct {
+	Exception string `json:"exception,omitempty"` // json of the exception, empty if the response is normal
+	Body      []byte `json:"body,omitempty"`
+}
+
+func newCapturedResponse(res *mpro.Message) *CapturedResponse {
+	if res.Header.GetStatus() == mpro.Exception {
+		return &CapturedResponse{Exception: res.Metadata.LoadOrEmpty(mpro.MExceptionn)}
+	}
+	return &CapturedResponse{Body: mpro.DecompressBody(res)}
+}
+
+// CaptureSink receive captured requests. Write is called in a single background goroutine of each server
+type CaptureSink interface {
+	Write(r *CapturedRequest) error
+}
+
+// SetCaptureSink set the sink used by all motan servers opened after this call
+func SetCaptureSink(sink CaptureSink) {
+	captureSinkLock.Lock()
+	defer captureSinkLock.Unlock()
+	captureSink = sink
+}
+
+func getCaptureSink() CaptureSink {
+	captureSinkLock.RLock()
+	defer captureSinkLock.RUnlock()
+	return captureSink
+}
+
+// CaptureBodyRedactor returns the body written to the sink for the serialized body of the method, it is called for the
+// request bodies and the normal response bodies. the arguments and the values are captured as the redactor returns,
+// so the sensitive fields must be removed or masked by it
+type CaptureBodyRedactor func(method string, body []byte) []byte
+
+// NoCaptureBodyRedaction captures the bodies as they are, it is set if the bodies are known to have no sensitive data
+func NoCaptureBodyRedaction(method string, body []byte) []byte {
+	return body
+}
+
+// SetCaptureBodyRedactor set the body redactor used by all motan servers opened after this call, the requests are not
+// captured if no redactor is set
+func SetCaptureBodyRedactor(redactor CaptureBodyRedactor) {
+	captureSinkLock.Lock()
+	defer captureSinkLock.Unlock()
+	captureBodyRedactor = redactor
+}
+
+func getCaptureBodyRedactor() CaptureBodyRedactor {
+	captureSinkLock.RLock()
+	defer captureSinkLock.RUnlock()
+	return captureBodyRedactor
+}
+
+// JSONLineCaptureSink write captured requests as json lines, which can be read back by ReadCapturedRequests
+type JSONLineCaptureSink struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+func NewJSONLineCaptureSink(w io.Writer) *JSONLineCaptureSink {
+	return &JSONLineCaptureSink{w: w}
+}
+
+func (j *JSONLineCaptureSink) Write(r *CapturedRequest) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	_, err = j.w.Write(append(b, '\n'))
+	return err
+}
+
+// ReadCapturedRequests read all captured requests written by JSONLineCaptureSink
+func ReadCapturedRequests(r io.Reader) ([]*CapturedRequest, error) {
+	var result []*CapturedRequest
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		c := &CapturedRequest{}
+		if err := json.Unmarshal(line, c); err != nil {
+			return result, err
+		}
+		result = append(result, c)
+	}
+	return result, scanner.Err()
+}
+
+type requestCapture struct {
+	sink       CaptureSink
+	redactBody CaptureBodyRedactor
+	rate       float64
+	limited    bool
+	remaining  int64
+	redactKeys map[string]bool
+	queue      chan *CapturedRequest
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+// newRequestCapture returns nil if capture is not enabled for the url
+func newRequestCapture(url *motan.URL) *requestCapture {
+	sink := getCaptureSink()
+	rate := url.GetFloatValue(CaptureRateKey, 0)
+	if sink == nil || rate <= 0 {
+		return nil
+	}
+	redactor := getCaptureBodyRedactor()
+	if redactor == nil {
+		vlog.Warningf("request capture disabled, no body redactor is set. url:%s", url.GetIdentity())
+		return nil
+	}
+	if rate > 1 {
+		rate = 1
+	}
+	c := &requestCapture{
+		sink:       sink,
+		redactBody: redactor,
+		rate:       rate,
+		remaining:  url.GetPositiveIntValue(CaptureMaxCountKey, 0),
+		redactKeys: make(map[string]bool),
+		queue:      make(chan *CapturedRequest, url.GetPositiveIntValue(CaptureQueueSizeKey, defaultCaptureQueueSize)),
+		done:       make(chan struct{}),
+	}
+	c.limited = c.remaining > 0
+	for _, k := range motan.TrimSplit(url.GetParam(CaptureRedactKeysKey, ""), ",") {
+		if k != "" {
+			c.redactKeys[k] = true
+		}
+	}
+	vlog.Infof("request capture enabled. rate:%v, maxCount:%d, url:%s", c.rate, c.remaining, url.GetIdentity())
+	go c.run()
+	return c
+}
+
+func (c *requestCapture) sample() bool {
+	if rand.Float64() >= c.rate {
+		return false
+	}
+	if !c.limited {
+		return true
+	}
+	return atomic.AddInt64(&c.remaining, -1) >= 0
+}
+
+// capture returns the record of the sampled request, nil if it is not sampled. the record is written with the response by write.
+// the attachments of the redact keys are redacted, and the body is redacted by the body redactor
+func (c *requestCapture) capture(request *mpro.Message) *CapturedRequest {
+	if !c.sample() {
+		return nil
+	}
+	attachments := request.Metadata.RawMap()
+	delete(attachments, motan.HostKey)
+	for k := range attachments {
+		if c.redactKeys[k] {
+			attachments[k] = redactedValue
+		}
+	}
+	return &CapturedRequest{
+		Time:          time.Now().UnixNano() / 1e6,
+		RequestID:     request.Header.RequestID,
+		Service:       attachments[mpro.MPath],
+		Method:        attachments[mpro.MMethod],
+		MethodDesc:    attachments[mpro.MMethodDesc],
+		Group:         attachments[mpro.MGroup],
+		Serialization: request.Header.GetSerialize(),
+		Attachments:   attachments,
+		Body:          c.redactBody(attachments[mpro.MMethod], request.Body),
+	}
+}
+
+// write queues the captured request with its response, the response can be nil
+func (c *requestCapture) write(r *CapturedRequest, res *mpro.Message) {
+	if r == nil {
+		return
+	}
+	if res != nil {
+		r.Response = newCapturedResponse(res)
+		if len(r.Response.Body) > 0 {
+			r.Response.Body = c.redactBody(r.Method, r.Response.Body)
+		}
+	}
+	select {
+	case c.queue <- r:
+	default:
+		vlog.Warningf("request capture queue is full, drop request. %s.%s", r.Service, r.Method)
+	}
+}
+
+func (c *requestCapture) run() {
+	for {
+		select {
+		case r := <-c.queue:
+			if err := c.sink.Write(r); err != nil {
+				vlog.Warningf("write captured request fail. err:%v", err)
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *requestCapture) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+	mpro "github.com/weibocom/motan-go/protocol"
+	"github.com/weibocom/motan-go/serialize"
+)
+
+type chanCaptureSink struct {
+	c chan *CapturedRequest
+}
+
+func (s *chanCaptureSink) Write(r *CapturedRequest) error {
+	s.c <- r
+	return nil
+}
+
+func buildCaptureMessage() *mpro.Message {
+	msg := &mpro.Message{Header: mpro.BuildHeader(mpro.Req, false, mpro.Simple, 11, mpro.Normal), Metadata: motan.NewStringMap(8), Body: []byte("body")}
+	msg.Metadata.Store(mpro.MPath, "service")
+	msg.Metadata.Store(mpro.MMethod, "method")
+	msg.Metadata.Store("token", "secret")
+	return msg
+}
+
+func TestRequestCapture(t *testing.T) {
+	url := &motan.URL{Parameters: map[string]string{CaptureRateKey: "1"}}
+	SetCaptureSink(nil)
+	assert.Nil(t, newRequestCapture(url))
+
+	sink := &chanCaptureSink{c: make(chan *CapturedRequest, 10)}
+	SetCaptureSink(sink)
+	defer SetCaptureSink(nil)
+	assert.Nil(t, newRequestCapture(&motan.URL{}))
+	// the requests are not captured without the body redactor
+	assert.Nil(t, newRequestCapture(url))
+	SetCaptureBodyRedactor(func(method string, body []byte) []byte {
+		return []byte(method + " " + redactedValue)
+	})
+	defer SetCaptureBodyRedactor(nil)
+
+	url.PutParam(CaptureMaxCountKey, "2")
+	url.PutParam(CaptureRedactKeysKey, "token")
+	c := newRequestCapture(url)
+	assert.NotNil(t, c)
+	defer c.close()
+	for i := 0; i < 5; i++ {
+		c.write(c.capture(buildCaptureMessage()), nil)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case r := <-sink.c:
+			assert.Equal(t, "service", r.Service)
+			assert.Equal(t, "method", r.Method)
+			assert.Equal(t, mpro.Simple, r.Serialization)
+			assert.Equal(t, redactedValue, r.Attachments["token"])
+			assert.Equal(t, []byte("method "+redactedValue), r.Body)
+		case <-time.After(time.Second):
+			t.Fatal("captured request not received")
+		}
+	}
+	select {
+	case <-sink.c:
+		t.Fatal("captured requests should not more than max count")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestJSONLineCaptureSink(t *testing.T) {
+	buf := &bytes.Buffer{}
+	sink := NewJSONLineCaptureSink(buf)
+	assert.Nil(t, sink.Write(&CapturedRequest{Service: "s1", Method: "m1", Body: []byte{0, 1, 2}}))
+	assert.Nil(t, sink.Write(&CapturedRequest{Service: "s2", Method: "m2"}))
+	requests, err := ReadCapturedRequests(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(requests))
+	assert.Equal(t, []byte{0, 1, 2}, requests[0].Body)
+	assert.Equal(t, "m2", requests[1].Method)
+}
+
+// newReplayTestProvider returns the provider greeting the name of the request
+func newReplayTestProvider(greeting string) motan.Provider {
+	p := newTestProvider("replayService", nil)
+	p.callFunc = func(request motan.Request) motan.Response {
+		if request.GetMethod() == "fail" {
+			return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 500, ErrMsg: "fail", ErrType: motan.BizException})
+		}
+		var name string
+		if err := request.ProcessDeserializable([]interface{}{&name}); err != nil {
+			return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 400, ErrMsg: err.Error(), ErrType: motan.BizException})
+		}
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: greeting + " " + name}
+	}
+	return p
+}
+
+func TestReplay(t *testing.T) {
+	sink := &chanCaptureSink{c: make(chan *CapturedRequest, 10)}
+	SetCaptureSink(sink)
+	defer SetCaptureSink(nil)
+	SetCaptureBodyRedactor(NoCaptureBodyRedaction)
+	defer SetCaptureBodyRedactor(nil)
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	serialize.RegistDefaultSerializations(ext)
+	handler := newTestHandler(newReplayTestProvider("hello"))
+	server := &MotanServer{URL: &motan.URL{Protocol: "motan2", Host: "127.0.0.1", Port: 64585, Parameters: map[string]string{CaptureRateKey: "1"}}}
+	assert.Nil(t, server.Open(false, false, handler, ext))
+	defer server.Destroy()
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:64585", time.Second)
+	assert.Nil(t, err)
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	sendTestRequest(t, conn, reader, 1, "replayService", "greet")
+	sendTestRequest(t, conn, reader, 2, "replayService", "fail")
+	var captured []*CapturedRequest
+	for i := 0; i < 2; i++ {
+		select {
+		case r := <-sink.c:
+			captured = append(captured, r)
+		case <-time.After(time.Second):
+			t.Fatal("captured request not received")
+		}
+	}
+	assert.NotNil(t, captured[0].Response)
+	assert.Equal(t, "", captured[0].Response.Exception)
+	assert.Contains(t, captured[1].Response.Exception, "fail")
+	// the requests captured without response are not compared
+	captured = append(captured, &CapturedRequest{Service: "replayService", Method: "greet", Serialization: captured[0].Serialization,
+		Attachments: captured[0].Attachments, Body: captured[0].Body})
+
+	replayer := &Replayer{Handler: handler, ExtFactory: ext}
+	report, err := replayer.Replay(captured)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, report.Total)
+	assert.Equal(t, 2, report.Matched)
+	assert.Equal(t, 1, report.Skipped)
+	assert.Equal(t, 0, len(report.Diffs))
+
+	// the shadow provider responds differently
+	replayer.Shadow = newReplayTestProvider("hi")
+	report, err = replayer.Replay(captured)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, report.Matched)
+	assert.Equal(t, 1, len(report.Diffs))
+	assert.Equal(t, "greet", report.Diffs[0].Request.Method)
+	assert.Contains(t, report.Diffs[0].Reason, "body differs")
+
+	// the requests can not be replayed are reported as diffs
+	report, err = (&Replayer{Handler: handler}).Replay(captured[:1])
+	assert.Nil(t, err)
+	assert.Equal(t, "unsupported serialization: "+strconv.Itoa(captured[0].Serialization), report.Diffs[0].Reason)
+	_, err = (&Replayer{}).Replay(captured)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,221 @@
+package server
+
+// CGI RFC: https://datatracker.ietf.org/doc/rfc3875/?include_text=1
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// CGIScriptKey is the url parameter of the cgi script executed for the requests of a service, the provider url parameter
+// takes precedence over the cgi server url parameter. the script is executed for each request with the timeout of requestTimeout
+const CGIScriptKey = "cgiScript"
+
+// CGIAttachmentPrefix is the prefix of the environment variables of the request attachments
+const CGIAttachmentPrefix = "MOTAN_"
+
+// CGIServer is a MotanServer whose requests are handled by the CGIMessageHandler
+type CGIServer struct {
+	MotanServer
+}
+
+func (c *CGIServer) Open(block bool, proxy bool, handler motan.MessageHandler, extFactory motan.ExtensionFactory) error {
+	if _, ok := handler.(*CGIMessageHandler); !ok {
+		handler = &CGIMessageHandler{MessageHandler: handler, URL: c.URL}
+	}
+	return c.MotanServer.Open(block, proxy, handler, extFactory)
+}
+
+func (c *CGIServer) GetName() string {
+	return CGI
+}
+
+// CGIMessageHandler executes the cgi script of a service for each request, the providers are kept by the wrapped handler
+// and are only used to find the services and their scripts. a request is translated to the script as:
+//
+//	GATEWAY_INTERFACE   CGI/1.1
+//	SERVER_SOFTWARE     motan-go
+//	SERVER_PROTOCOL     motan2
+//	SERVER_NAME         host of the cgi server url
+//	SERVER_PORT         port of the cgi server url
+//	REQUEST_METHOD      POST
+//	SCRIPT_NAME         service name of the request
+//	PATH_INFO           "/" + method of the request
+//	QUERY_STRING        method description of the request
+//	REMOTE_ADDR         host of the caller
+//	CONTENT_TYPE        application/octet-stream for string and []byte argument, otherwise application/json
+//	CONTENT_LENGTH      length of stdin
+//	MOTAN_REQUEST_ID    request id
+//	MOTAN_<ATTACHMENT>  request attachments, the names are upper cased and the characters other than letters and digits are replaced by '_'
+//
+// stdin is the argument of the request, string or []byte arguments are passed as is, other arguments are passed as json.
+// the script writes the cgi response to stdout: the header lines, an empty line and the body. the 'Status' header sets
+// the status code, the response is an exception of the status code if it is not less than 400. other headers are set as the
+// response attachments, and the body is the response value
+type CGIMessageHandler struct {
+	motan.MessageHandler
+	URL *motan.URL // the cgi server url
+}
+
+func (c *CGIMessageHandler) Call(request motan.Request) (res motan.Response) {
+	defer motan.HandleRequestPanic(request, func() {
+		vlog.Errorf("cgi handler call panic. req:%s", motan.GetReqInfo(request))
+		res = motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 500, ErrMsg: "cgi handler call panic", ErrType: motan.ServiceException})
+	})
+	p := c.GetProvider(request.GetServiceName())
+	if p == nil {
+		vlog.Errorf("not found provider for %s", motan.GetReqInfo(request))
+		return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 500, ErrMsg: "not found provider for " + request.GetServiceName(), ErrType: motan.ServiceException})
+	}
+	script := p.GetURL().GetParam(CGIScriptKey, "")
+	if script == "" && c.URL != nil {
+		script = c.URL.GetParam(CGIScriptKey, "")
+	}
+	if script == "" {
+		return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 503, ErrMsg: "no cgi script for service " + request.GetServiceName(), ErrType: motan.ServiceException})
+	}
+	stdin, contentType, err := cgiStdin(request)
+	if err != nil {
+		return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 400, ErrMsg: "illegal cgi request: " + err.Error(), ErrType: motan.ServiceException})
+	}
+	timeout := p.GetURL().GetTimeDuration(motan.TimeOutKey, time.Millisecond, defaultRequestTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, script)
+	cmd.Env = c.cgiEnv(request, contentType, len(stdin))
+	cmd.Stdin = bytes.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	start := time.Now()
+	out, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		vlog.Warningf("cgi script %s fail. req:%s, err:%v, stderr:%s", script, motan.GetReqInfo(request), err, stderr.String())
+		return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 500, ErrMsg: "cgi script fail: " + err.Error(), ErrType: motan.ServiceException})
+	}
+	status, headers, body, err := parseCGIResponse(out)
+	if err != nil {
+		vlog.Warningf("cgi script %s responds illegal output. req:%s, err:%v", script, motan.GetReqInfo(request), err)
+		return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 502, ErrMsg: err.Error(), ErrType: motan.ServiceException})
+	}
+	if status >= 400 {
+		return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: status, ErrMsg: string(body), ErrType: motan.BizException})
+	}
+	resp := &motan.MotanResponse{RequestID: request.GetRequestID(), Value: body, ProcessTime: int64(time.Since(start) / time.Millisecond),
+		Attachment: motan.NewStringMap(len(headers))}
+	for k, v := range headers {
+		resp.SetAttachment(k, v)
+	}
+	return resp
+}
+
+func (c *CGIMessageHandler) cgiEnv(request motan.Request, contentType string, contentLength int) []string {
+	env := []string{
+		"GATEWAY_INTERFACE=CGI/1.1",
+		"SERVER_SOFTWARE=motan-go",
+		"SERVER_PROTOCOL=" + Motan2,
+		"REQUEST_METHOD=POST",
+		"SCRIPT_NAME=" + request.GetServiceName(),
+		"PATH_INFO=/" + request.GetMethod(),
+		"QUERY_STRING=" + request.GetMethodDesc(),
+		"REMOTE_ADDR=" + request.GetAttachment(motan.HostKey),
+		"CONTENT_TYPE=" + contentType,
+		"CONTENT_LENGTH=" + strconv.Itoa(contentLength),
+		CGIAttachmentPrefix + "REQUEST_ID=" + strconv.FormatUint(request.GetRequestID(), 10),
+	}
+	if c.URL != nil {
+		env = append(env, "SERVER_NAME="+c.URL.Host, "SERVER_PORT="+strconv.Itoa(c.URL.Port))
+	}
+	if request.GetAttachments() != nil {
+		request.GetAttachments().Range(func(k, v string) bool {
+			env = append(env, CGIAttachmentPrefix+cgiEnvName(k)+"="+v)
+			return true
+		})
+	}
+	return env
+}
+
+func cgiEnvName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' || r >= 'A' && r <= 'Z' {
+			return r
+		}
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		return '_'
+	}, name)
+}
+
+func cgiStdin(request motan.Request) ([]byte, string, error) {
+	if err := request.ProcessDeserializable(nil); err != nil {
+		return nil, "", err
+	}
+	args := request.GetArguments()
+	if len(args) == 0 {
+		return nil, "application/octet-stream", nil
+	}
+	if len(args) == 1 {
+		switch v := args[0].(type) {
+		case []byte:
+			return v, "application/octet-stream", nil
+		case string:
+			return []byte(v), "application/octet-stream", nil
+		}
+	}
+	var value interface{} = args
+	if len(args) == 1 {
+		value = args[0]
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, "", err
+	}
+	return b, "application/json", nil
+}
+
+// parseCGIResponse parses the cgi response, the lines can be terminated by "\n" or "\r\n"
+func parseCGIResponse(out []byte) (int, map[string]string, []byte, error) {
+	reader := bufio.NewReader(bytes.NewReader(out))
+	status := 200
+	headers := make(map[string]string)
+	read := 0
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return 0, nil, nil, errors.New("illegal cgi response: no empty line after headers")
+		}
+		read += len(line)
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) < 2 {
+			return 0, nil, nil, errors.New("illegal cgi response header: " + line)
+		}
+		name, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if strings.EqualFold(name, "Status") {
+			code, err := strconv.Atoi(strings.SplitN(value, " ", 2)[0])
+			if err != nil {
+				return 0, nil, nil, errors.New("illegal cgi response status: " + value)
+			}
+			status = code
+			continue
+		}
+		headers[name] = value
+	}
+	return status, headers, out[read:], nil
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+	mpro "github.com/weibocom/motan-go/protocol"
+	"github.com/weibocom/motan-go/serialize"
+)
+
+const testCGIScript = `#!/bin/sh
+body=$(cat)
+if [ "$PATH_INFO" = "/missing" ]; then
+	printf 'Status: 404 Not Found\r\n\r\nno method %s' "$PATH_INFO"
+	exit 0
+fi
+printf 'Content-Type: text/plain\r\nX-Service: %s\r\n\r\n' "$SCRIPT_NAME"
+printf '%s %s %s %s %s' "$REQUEST_METHOD" "$body" "$CONTENT_LENGTH" "$MOTAN_TRACE_ID" "$MOTAN_REQUEST_ID"
+`
+
+func TestCGIServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cgi")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "echo.sh")
+	assert.Nil(t, ioutil.WriteFile(script, []byte(testCGIScript), 0755))
+
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	serialize.RegistDefaultSerializations(ext)
+	RegistDefaultServers(ext)
+	url := &motan.URL{Protocol: CGI, Host: "127.0.0.1", Port: 64590, Path: "cgiService"}
+	server := ext.GetServer(url)
+	assert.Equal(t, CGI, server.GetName())
+	handler := newTestHandler(newTestProvider("cgiService", map[string]string{CGIScriptKey: script}), newTestProvider("noScriptService", nil))
+	assert.Nil(t, server.Open(false, false, handler, ext))
+	defer server.Destroy()
+	_, ok := server.GetMessageHandler().(*CGIMessageHandler)
+	assert.True(t, ok)
+
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:64590", time.Second)
+	assert.Nil(t, err)
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	call := func(requestID uint64, service string, method string) motan.Response {
+		request := &motan.MotanRequest{RequestID: requestID, ServiceName: service, Method: method, Arguments: []interface{}{"hello"}}
+		request.SetAttachment("trace-id", "t1")
+		msg, err := mpro.ConvertToReqMessage(request, &serialize.SimpleSerialization{})
+		assert.Nil(t, err)
+		_, err = conn.Write(msg.Encode().Bytes())
+		assert.Nil(t, err)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		resMsg, err := mpro.Decode(reader)
+		assert.Nil(t, err)
+		res, err := mpro.ConvertToResponse(resMsg, &serialize.SimpleSerialization{})
+		assert.Nil(t, err)
+		return res
+	}
+
+	res := call(1, "cgiService", "echo")
+	assert.Nil(t, res.GetException())
+	var value []byte
+	assert.Nil(t, res.ProcessDeserializable(&value))
+	assert.Equal(t, "POST hello 5 t1 1", string(value))
+	assert.Equal(t, "cgiService", res.GetAttachment("X-Service"))
+	assert.Equal(t, "text/plain", res.GetAttachment("Content-Type"))
+
+	res = call(2, "cgiService", "missing")
+	assert.NotNil(t, res.GetException())
+	assert.Equal(t, 404, res.GetException().ErrCode)
+	assert.Equal(t, "no method /missing", res.GetException().ErrMsg)
+
+	res = call(3, "noScriptService", "echo")
+	assert.Equal(t, 503, res.GetException().ErrCode)
+	res = call(4, "unknownService", "echo")
+	assert.NotNil(t, res.GetException())
+}
+
+func TestParseCGIResponse(t *testing.T) {
+	status, headers, body, err := parseCGIResponse([]byte("Status: 201 Created\nA: b\n\nbody\r\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, 201, status)
+	assert.Equal(t, map[string]string{"A": "b"}, headers)
+	assert.Equal(t, "body\r\n", string(body))
+	for _, out := range []string{"A: b", "illegal\r\n\r\n", "Status: ok\r\n\r\n"} {
+		_, _, _, err = parseCGIResponse([]byte(out))
+		assert.NotNil(t, err, out)
+	}
+	assert.Equal(t, "TRACE_ID", cgiEnvName("trace-id"))
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// CoalesceMethodsKey is the provider url parameter of the write methods to coalesce, the value is a json map of method name to
+// window in ms, e.g. {"incr":5}. the requests of a method within the window are applied together by the CoalesceFunc
+// registered for the method, the methods without CoalesceFunc are called one by one
+const CoalesceMethodsKey = "coalesceMethods"
+
+// CoalesceMaxBatchKey limits the requests applied together, a batch is applied before the window ends when it is full
+const CoalesceMaxBatchKey = "coalesceMaxBatch"
+
+const defaultCoalesceMaxBatch = 100
+
+// CoalesceFunc applies the requests of a method as one merged operation, it returns the responses in the order of the requests
+type CoalesceFunc func(requests []motan.Request) []motan.Response
+
+var (
+	coalesceFuncs    = make(map[string]CoalesceFunc) // key: service.method
+	coalesceFuncLock sync.RWMutex
+)
+
+// RegisterCoalesceFunc sets the merge func of a method, nil removes it
+func RegisterCoalesceFunc(service string, method string, f CoalesceFunc) {
+	coalesceFuncLock.Lock()
+	defer coalesceFuncLock.Unlock()
+	if f == nil {
+		delete(coalesceFuncs, service+"."+method)
+		return
+	}
+	coalesceFuncs[service+"."+method] = f
+}
+
+func getCoalesceFunc(service string, method string) CoalesceFunc {
+	coalesceFuncLock.RLock()
+	defer coalesceFuncLock.RUnlock()
+	if f, ok := coalesceFuncs[service+"."+method]; ok {
+		return f
+	}
+	return coalesceFuncs[service+"."+motan.FirstUpper(method)]
+}
+
+func parseCoalesceWindows(url *motan.URL) (map[string]time.Duration, error) {
+	value := url.GetParam(CoalesceMethodsKey, "")
+	if value == "" {
+		return nil, nil
+	}
+	var raw map[string]int64
+	if err := json.Unmarshal([]byte(value), &raw); err != nil {
+		return nil, fmt.Errorf("illegal %s: %s, err: %v", CoalesceMethodsKey, value, err)
+	}
+	windows := make(map[string]time.Duration, len(raw))
+	for method, ms := range raw {
+		if method == "" {
+			return nil, errors.New("illegal " + CoalesceMethodsKey + ": empty method name")
+		}
+		if ms <= 0 {
+			return nil, fmt.Errorf("illegal %s: window of method %s must be positive, value: %d", CoalesceMethodsKey, method, ms)
+		}
+		windows[method] = time.Duration(ms) * time.Millisecond
+	}
+	return windows, nil
+}
+
+type coalesceBatch struct {
+	f        CoalesceFunc
+	requests []motan.Request
+	results  []chan motan.Response
+}
+
+// apply sends the responses of the merged operation to the waiting requests
+func (b *coalesceBatch) apply() {
+	var responses []motan.Response
+	func() {
+		defer motan.HandleRequestPanic(b.requests[0], func() {
+			vlog.Errorf("coalesce func panic. req:%s, batch:%d", motan.GetReqInfo(b.requests[0]), len(b.requests))
+			responses = nil
+		})
+		responses = b.f(b.requests)
+	}()
+	if len(responses) != len(b.requests) {
+		if responses != nil {
+			vlog.Errorf("coalesce func returns %d responses for %d requests. req:%s", len(responses), len(b.requests), motan.GetReqInfo(b.requests[0]))
+		}
+		responses = make([]motan.Response, len(b.requests))
+	}
+	for i, request := range b.requests {
+		res := responses[i]
+		if res == nil {
+			res = motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 500, ErrMsg: "coalesced write of " + strconv.Itoa(len(b.requests)) + " requests fail", ErrType: motan.ServiceException})
+		}
+		b.results[i] <- res
+	}
+}
+
+// CoalesceProviderWrapper buffers the requests of the configured write methods for a short window and applies them together
+type CoalesceProviderWrapper struct {
+	baseProviderWrapper
+	windows  map[string]time.Duration
+	maxBatch int
+	lock     sync.Mutex
+	batches  map[string]*coalesceBatch
+}
+
+// WrapWithCoalesce returns the provider itself if no method is configured for coalescing
+func WrapWithCoalesce(provider motan.Provider) motan.Provider {
+	windows, err := parseCoalesceWindows(provider.GetURL())
+	if err != nil {
+		vlog.Warningf("coalesce of provider %s ignored. err: %v", provider.GetPath(), err)
+		return provider
+	}
+	if len(windows) == 0 {
+		return provider
+	}
+	vlog.Infof("coalesce enabled for provider %s, methods: %s", provider.GetPath(), provider.GetURL().GetParam(CoalesceMethodsKey, ""))
+	return &CoalesceProviderWrapper{baseProviderWrapper: baseProviderWrapper{provider: provider}, windows: windows, batches: make(map[string]*coalesceBatch),
+		maxBatch: int(provider.GetURL().GetPositiveIntValue(CoalesceMaxBatchKey, defaultCoalesceMaxBatch))}
+}
+
+func (c *CoalesceProviderWrapper) window(method string) (string, time.Duration) {
+	if w, ok := c.windows[method]; ok {
+		return method, w
+	}
+	upper := motan.FirstUpper(method)
+	return upper, c.windows[upper]
+}
+
+func (c *CoalesceProviderWrapper) Call(request motan.Request) motan.Response {
+	method, window := c.window(request.GetMethod())
+	if window == 0 {
+		return c.provider.Call(request)
+	}
+	f := getCoalesceFunc(request.GetServiceName(), request.GetMethod())
+	if f == nil {
+		return c.provider.Call(request)
+	}
+	result := make(chan motan.Response, 1)
+	c.lock.Lock()
+	b := c.batches[method]
+	if b == nil {
+		b = &coalesceBatch{f: f}
+		c.batches[method] = b
+		time.AfterFunc(window, func() {
+			if c.detach(method, b) {
+				b.apply()
+			}
+		})
+	}
+	b.requests = append(b.requests, request)
+	b.results = append(b.results, result)
+	full := len(b.requests) >= c.maxBatch
+	if full {
+		delete(c.batches, method)
+	}
+	c.lock.Unlock()
+	if full {
+		go b.apply()
+	}
+	return <-result
+}
+
+// detach removes the batch from the pending batches, it returns false if the batch is already applied because it is full
+func (c *CoalesceProviderWrapper) detach(method string, b *coalesceBatch) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.batches[method] != b {
+		return false
+	}
+	delete(c.batches, method)
+	return true
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+)
+
+func TestParseCoalesceWindows(t *testing.T) {
+	for _, value := range []string{"{", `{"incr":0}`, `{"":5}`} {
+		_, err := parseCoalesceWindows(newTestProvider("test", map[string]string{CoalesceMethodsKey: value}).GetURL())
+		assert.NotNil(t, err, value)
+	}
+	windows, err := parseCoalesceWindows(newTestProvider("test", map[string]string{CoalesceMethodsKey: `{"incr":5}`}).GetURL())
+	assert.Nil(t, err)
+	assert.Equal(t, 5*time.Millisecond, windows["incr"])
+	_, ok := WrapWithCoalesce(newTestProvider("test", nil)).(*testProvider)
+	assert.True(t, ok)
+}
+
+func TestCoalesceProvider(t *testing.T) {
+	p := newTestProvider("coalesce", map[string]string{CoalesceMethodsKey: `{"incr":50,"Fail":10}`, CoalesceMaxBatchKey: "3"})
+	provider := WrapWithCoalesce(p)
+	var lock sync.Mutex
+	var batches []int
+	counter := 0
+	RegisterCoalesceFunc("coalesce", "incr", func(requests []motan.Request) []motan.Response {
+		lock.Lock()
+		defer lock.Unlock()
+		batches = append(batches, len(requests))
+		responses := make([]motan.Response, 0, len(requests))
+		for _, request := range requests {
+			counter += request.GetArguments()[0].(int)
+			responses = append(responses, &motan.MotanResponse{RequestID: request.GetRequestID(), Value: counter})
+		}
+		return responses
+	})
+	RegisterCoalesceFunc("coalesce", "fail", func(requests []motan.Request) []motan.Response {
+		panic("merge fail")
+	})
+	defer RegisterCoalesceFunc("coalesce", "incr", nil)
+	defer RegisterCoalesceFunc("coalesce", "fail", nil)
+
+	call := func(method string, count int) []motan.Response {
+		responses := make([]motan.Response, count)
+		var wg sync.WaitGroup
+		for i := 0; i < count; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				request := newTestRequest("coalesce", method)
+				request.RequestID = uint64(i)
+				request.Arguments = []interface{}{1}
+				responses[i] = provider.Call(request)
+			}(i)
+		}
+		wg.Wait()
+		return responses
+	}
+	// a full batch is applied before the window ends
+	start := time.Now()
+	responses := call("incr", 3)
+	assert.True(t, time.Since(start) < 50*time.Millisecond)
+	assert.Equal(t, []int{3}, batches)
+	for i, res := range responses {
+		assert.Nil(t, res.GetException())
+		assert.Equal(t, uint64(i), res.GetRequestID())
+	}
+	responses = call("incr", 2)
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+	assert.Equal(t, []int{3, 2}, batches)
+	assert.Equal(t, 5, counter)
+
+	// every request of a failed batch gets the exception
+	for _, res := range call("fail", 2) {
+		assert.Equal(t, 500, res.GetException().ErrCode)
+	}
+	// the methods without coalesce func are called directly
+	assert.Equal(t, "ok", provider.Call(newTestRequest("coalesce", "other")).GetValue())
+	RegisterCoalesceFunc("coalesce", "incr", nil)
+	assert.Equal(t, "ok", provider.Call(newTestRequest("coalesce", "incr")).GetValue())
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"errors"
+	"strconv"
+	"sync/atomic"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+	"github.com/weibocom/motan-go/metrics"
+)
+
+// MaxWorkerKey is the provider url parameter of the max requests of the provider in processing by DefaultMessageHandler,
+// the excess requests are rejected with 503 at once instead of waiting. the limit is shared by all the methods, 0 means
+// no limit. the in processing requests and the rejected requests are reported as motan-server-handler:{application}:{suffix}
+const MaxWorkerKey = "maxWorker"
+
+const (
+	ConcurrencyInflightMetric = "concurrency.inflight"       // gauge of the requests in processing
+	ConcurrencyRejectedMetric = "concurrency.rejected_count" // counter of the requests rejected by MaxWorkerKey
+)
+
+// concurrencyLimiter is a semaphore of the requests of a provider
+type concurrencyLimiter struct {
+	max         int64
+	inflight    int64
+	group       string
+	service     string
+	inflightKey string
+	rejectedKey string
+}
+
+// parseConcurrencyLimiter returns nil if the concurrency of the provider is not limited
+func parseConcurrencyLimiter(url *motan.URL) (*concurrencyLimiter, error) {
+	value := url.GetParam(MaxWorkerKey, "")
+	if value == "" {
+		return nil, nil
+	}
+	max, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || max < 0 {
+		return nil, errors.New("illegal " + MaxWorkerKey + ": " + value)
+	}
+	if max == 0 {
+		return nil, nil
+	}
+	prefix := callMetricsRole + metrics.KeyDelimiter + metrics.Escape(url.GetParam(motan.ApplicationKey, metrics.DefaultStatApplication)) + metrics.KeyDelimiter
+	return &concurrencyLimiter{max: max, group: metrics.Escape(url.Group), service: metrics.Escape(url.Path),
+		inflightKey: prefix + ConcurrencyInflightMetric, rejectedKey: prefix + ConcurrencyRejectedMetric}, nil
+}
+
+// acquire returns an exception response if the request is rejected, otherwise release should be called after the call
+func (c *concurrencyLimiter) acquire(request motan.Request, retryAfter *retryAfterPolicy) motan.Response {
+	if c == nil {
+		return nil
+	}
+	inflight := atomic.AddInt64(&c.inflight, 1)
+	if inflight > c.max {
+		atomic.AddInt64(&c.inflight, -1)
+		addCallCounter(c.group, c.service, c.rejectedKey, 1)
+		vlog.Warningf("request rejected by max worker. req:%s, max worker: %d", motan.GetReqInfo(request), c.max)
+		return overloadResponse(request, retryAfter, "too many requests in processing, max worker: "+strconv.FormatInt(c.max, 10), float64(inflight)/float64(c.max))
+	}
+	addCallGauge(c.group, c.service, c.inflightKey, inflight)
+	return nil
+}
+
+func (c *concurrencyLimiter) release() {
+	if c == nil {
+		return
+	}
+	addCallGauge(c.group, c.service, c.inflightKey, atomic.AddInt64(&c.inflight, -1))
+}
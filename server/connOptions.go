@@ -0,0 +1,99 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/juju/ratelimit"
+	motan "github.com/weibocom/motan-go/core"
+)
+
+// tcp connection options of MotanServer url parameters
+const (
+	ReadBufferSizeKey  = "readBufferSize"  // socket receive buffer size in bytes, 0 means the system default
+	WriteBufferSizeKey = "writeBufferSize" // socket send buffer size in bytes, 0 means the system default
+	TCPNoDelayKey      = "tcpNoDelay"      // disable Nagle's algorithm for lower latency, default true
+	KeepAlivePeriodKey = "keepAlivePeriod" // ms, 0 means the system default, negative value disables keepalive
+	ConnRequestRateKey = "connRequestRate" // requests per second of one connection, 0 means unlimited
+)
+
+const maxSocketBufferSize = 64 * 1024 * 1024
+
+type connOptions struct {
+	readBufferSize  int
+	writeBufferSize int
+	noDelay         bool
+	keepAlivePeriod time.Duration
+	requestRate     float64
+}
+
+func parseConnOptions(url *motan.URL) (*connOptions, error) {
+	options := &connOptions{noDelay: true}
+	var err error
+	if options.readBufferSize, err = parseBufferSize(url, ReadBufferSizeKey); err != nil {
+		return nil, err
+	}
+	if options.writeBufferSize, err = parseBufferSize(url, WriteBufferSizeKey); err != nil {
+		return nil, err
+	}
+	if value := url.GetParam(TCPNoDelayKey, ""); value != "" {
+		if options.noDelay, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("illegal %s: %s", TCPNoDelayKey, value)
+		}
+	}
+	if value := url.GetParam(KeepAlivePeriodKey, ""); value != "" {
+		ms, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("illegal %s: %s", KeepAlivePeriodKey, value)
+		}
+		options.keepAlivePeriod = time.Duration(ms) * time.Millisecond
+	}
+	if value := url.GetParam(ConnRequestRateKey, ""); value != "" {
+		if options.requestRate, err = strconv.ParseFloat(value, 64); err != nil || options.requestRate < 0 {
+			return nil, fmt.Errorf("illegal %s: %s", ConnRequestRateKey, value)
+		}
+	}
+	return options, nil
+}
+
+func parseBufferSize(url *motan.URL, key string) (int, error) {
+	value := url.GetParam(key, "")
+	if value == "" {
+		return 0, nil
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil || size < 0 || size > maxSocketBufferSize {
+		return 0, fmt.Errorf("illegal %s: %s, it should be between 0 and %d", key, value, maxSocketBufferSize)
+	}
+	return size, nil
+}
+
+func (c *connOptions) apply(conn *net.TCPConn) {
+	conn.SetNoDelay(c.noDelay)
+	if c.keepAlivePeriod < 0 {
+		conn.SetKeepAlive(false)
+	} else {
+		conn.SetKeepAlive(true)
+		if c.keepAlivePeriod > 0 {
+			conn.SetKeepAlivePeriod(c.keepAlivePeriod)
+		}
+	}
+	if c.readBufferSize > 0 {
+		conn.SetReadBuffer(c.readBufferSize)
+	}
+	if c.writeBufferSize > 0 {
+		conn.SetWriteBuffer(c.writeBufferSize)
+	}
+}
+
+// newRequestLimiter returns the request limiter of a connection, nil if the request rate is unlimited.
+// the bucket allows a burst of one second's requests
+func (c *connOptions) newRequestLimiter() *ratelimit.Bucket {
+	if c.requestRate <= 0 {
+		return nil
+	}
+	return ratelimit.NewBucketWithRate(c.requestRate, int64(math.Ceil(c.requestRate)))
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"reflect"
+	"sync"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// DeltaMethodsKey is the provider url parameter of the methods responding deltas, separated by comma, '*' means all methods.
+// a client sends the version of the value it has(DeltaBaseAttachment), and gets the delta against it if the base version
+// is one of the recent versions kept by the provider, otherwise gets the full value
+const DeltaMethodsKey = "deltaMethods"
+
+// DeltaHistoryKey is the provider url parameter of the number of recent versions kept for each method
+const DeltaHistoryKey = "deltaHistory"
+
+const defaultDeltaHistory = 4
+
+// DeltaStrategy versions and diffs the values of a method
+type DeltaStrategy interface {
+	// Name is the DeltaAttachment of the deltas, clients patch the deltas by it
+	Name() string
+	// Version returns the version id of the value, equal values should have the same version
+	Version(value interface{}) (string, error)
+	// Diff returns the delta from base to value, ok is false if the full value should be sent, e.g. the delta is not smaller
+	Diff(base interface{}, value interface{}) (delta interface{}, ok bool, err error)
+}
+
+var (
+	deltaStrategies    = make(map[string]DeltaStrategy) // key: service.method
+	deltaStrategyLock  sync.RWMutex
+	defaultDeltaMethod = &bytesDeltaStrategy{}
+)
+
+// RegisterDeltaStrategy sets the delta strategy of a method, nil removes it. the methods without strategy respond the deltas
+// of bytes(or string) values
+func RegisterDeltaStrategy(service string, method string, strategy DeltaStrategy) {
+	deltaStrategyLock.Lock()
+	defer deltaStrategyLock.Unlock()
+	if strategy == nil {
+		delete(deltaStrategies, service+"."+method)
+		return
+	}
+	deltaStrategies[service+"."+method] = strategy
+}
+
+func getDeltaStrategy(service string, method string) DeltaStrategy {
+	deltaStrategyLock.RLock()
+	defer deltaStrategyLock.RUnlock()
+	if s, ok := deltaStrategies[service+"."+method]; ok {
+		return s
+	}
+	if s, ok := deltaStrategies[service+"."+motan.FirstUpper(method)]; ok {
+		return s
+	}
+	return defaultDeltaMethod
+}
+
+// bytesDeltaStrategy diffs []byte or string values by motan.DiffBytes, the values of other types are always sent in full
+type bytesDeltaStrategy struct{}
+
+func (b *bytesDeltaStrategy) Name() string {
+	return motan.BytesDelta
+}
+
+func (b *bytesDeltaStrategy) Version(value interface{}) (string, error) {
+	return computeETag(value)
+}
+
+func (b *bytesDeltaStrategy) Diff(base interface{}, value interface{}) (interface{}, bool, error) {
+	baseBytes, ok := deltaBytes(base)
+	if !ok {
+		return nil, false, nil
+	}
+	valueBytes, ok := deltaBytes(value)
+	if !ok {
+		return nil, false, nil
+	}
+	delta := motan.DiffBytes(baseBytes, valueBytes)
+	return delta, len(delta) < len(valueBytes), nil
+}
+
+func deltaBytes(value interface{}) ([]byte, bool) {
+	if rv, ok := value.(reflect.Value); ok {
+		value = rv.Interface()
+	}
+	switch v := value.(type) {
+	case []byte:
+		return v, true
+	case string:
+		return []byte(v), true
+	}
+	return nil, false
+}
+
+type deltaVersion struct {
+	version string
+	value   interface{}
+}
+
+// deltaHistory is the recent versions of a method, the latest is the last
+type deltaHistory struct {
+	lock     sync.Mutex
+	versions []deltaVersion
+}
+
+// record adds the value as the latest version and returns the value of the base version, nil if the base is not kept
+func (h *deltaHistory) record(version string, value interface{}, base string, size int) (baseValue interface{}, found bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for _, v := range h.versions {
+		if v.version == base {
+			baseValue, found = v.value, true
+			break
+		}
+	}
+	if n := len(h.versions); n == 0 || h.versions[n-1].version != version {
+		for i, v := range h.versions {
+			if v.version == version {
+				h.versions = append(h.versions[:i:i], h.versions[i+1:]...)
+				break
+			}
+		}
+		h.versions = append(h.versions, deltaVersion{version: version, value: value})
+		if len(h.versions) > size {
+			h.versions = h.versions[len(h.versions)-size:]
+		}
+	}
+	return baseValue, found
+}
+
+// DeltaProviderWrapper responds the deltas against the versions the clients have for the configured methods.
+// the provider is still called, so only the bandwidth is saved
+type DeltaProviderWrapper struct {
+	baseProviderWrapper
+	allMethods bool
+	methods    map[string]bool
+	size       int
+	lock       sync.Mutex
+	histories  map[string]*deltaHistory
+}
+
+// WrapWithDelta returns the provider itself if no method is configured for deltas
+func WrapWithDelta(provider motan.Provider) motan.Provider {
+	methods := motan.TrimSplit(provider.GetURL().GetParam(DeltaMethodsKey, ""), ",")
+	w := &DeltaProviderWrapper{baseProviderWrapper: baseProviderWrapper{provider: provider}, methods: make(map[string]bool, len(methods)), histories: make(map[string]*deltaHistory)}
+	for _, m := range methods {
+		if m == "*" {
+			w.allMethods = true
+		} else if m != "" {
+			w.methods[m] = true
+		}
+	}
+	if !w.allMethods && len(w.methods) == 0 {
+		return provider
+	}
+	w.size = int(provider.GetURL().GetPositiveIntValue(DeltaHistoryKey, defaultDeltaHistory))
+	vlog.Infof("delta enabled for provider %s, methods: %s, history: %d", provider.GetPath(), provider.GetURL().GetParam(DeltaMethodsKey, ""), w.size)
+	return w
+}
+
+func (d *DeltaProviderWrapper) history(method string) *deltaHistory {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	h := d.histories[method]
+	if h == nil {
+		h = &deltaHistory{}
+		d.histories[method] = h
+	}
+	return h
+}
+
+func (d *DeltaProviderWrapper) Call(request motan.Request) motan.Response {
+	res := d.provider.Call(request)
+	if !(d.allMethods || d.methods[request.GetMethod()] || d.methods[motan.FirstUpper(request.GetMethod())]) {
+		return res
+	}
+	if res == nil || res.GetException() != nil || res.GetAttachment(NotModifiedAttachment) != "" {
+		return res
+	}
+	strategy := getDeltaStrategy(request.GetServiceName(), request.GetMethod())
+	version, err := strategy.Version(res.GetValue())
+	if err != nil {
+		vlog.Warningf("compute delta version fail. req:%s, err:%v", motan.GetReqInfo(request), err)
+		return res
+	}
+	base := request.GetAttachment(motan.DeltaBaseAttachment)
+	baseValue, found := d.history(request.GetMethod()).record(version, res.GetValue(), base, d.size)
+	res.SetAttachment(motan.DeltaVersionAttachment, version)
+	if base == "" || !found {
+		return res
+	}
+	delta, ok, err := strategy.Diff(baseValue, res.GetValue())
+	if err != nil {
+		vlog.Warningf("diff response fail, full value is sent. req:%s, base:%s, err:%v", motan.GetReqInfo(request), base, err)
+		return res
+	}
+	if !ok {
+		return res
+	}
+	deltaRes := copyResponseWithValue(res, delta)
+	deltaRes.SetAttachment(motan.DeltaAttachment, strategy.Name())
+	return deltaRes
+}
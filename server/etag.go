@@ -0,0 +1,98 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"strconv"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// ETagMethodsKey is the provider url parameter of the methods supporting conditional responses, separated by comma,
+// '*' means all methods. the etag of a response is the ETagAttachment set by the provider, or computed from the response value
+const ETagMethodsKey = "etagMethods"
+
+const (
+	// ETagAttachment is the response attachment of the version of the value
+	ETagAttachment = "M_etag"
+	// IfNoneMatchAttachment is the request attachment of the etag the client has,
+	// the response has no value and the attachment NotModifiedAttachment if the etag is not changed
+	IfNoneMatchAttachment = "M_inm"
+	NotModifiedAttachment = "M_nm"
+)
+
+// ETagProviderWrapper adds etags to the responses of the configured methods, and replaces the response whose etag matches
+// the request with a lightweight not modified response. the provider is still called, so only the bandwidth is saved
+type ETagProviderWrapper struct {
+	baseProviderWrapper
+	allMethods bool
+	methods    map[string]bool
+}
+
+// WrapWithETag returns the provider itself if no method is configured for conditional responses
+func WrapWithETag(provider motan.Provider) motan.Provider {
+	methods := motan.TrimSplit(provider.GetURL().GetParam(ETagMethodsKey, ""), ",")
+	w := &ETagProviderWrapper{baseProviderWrapper: baseProviderWrapper{provider: provider}, methods: make(map[string]bool, len(methods))}
+	for _, m := range methods {
+		if m == "*" {
+			w.allMethods = true
+		} else if m != "" {
+			w.methods[m] = true
+		}
+	}
+	if !w.allMethods && len(w.methods) == 0 {
+		return provider
+	}
+	vlog.Infof("etag enabled for provider %s, methods: %s", provider.GetPath(), provider.GetURL().GetParam(ETagMethodsKey, ""))
+	return w
+}
+
+func (e *ETagProviderWrapper) Call(request motan.Request) motan.Response {
+	res := e.provider.Call(request)
+	if !(e.allMethods || e.methods[request.GetMethod()] || e.methods[motan.FirstUpper(request.GetMethod())]) {
+		return res
+	}
+	if res == nil || res.GetException() != nil {
+		return res
+	}
+	etag := res.GetAttachment(ETagAttachment)
+	if etag == "" {
+		var err error
+		if etag, err = computeETag(res.GetValue()); err != nil {
+			vlog.Warningf("compute etag fail. req:%s, err:%v", motan.GetReqInfo(request), err)
+			return res
+		}
+		res.SetAttachment(ETagAttachment, etag)
+	}
+	if request.GetAttachment(IfNoneMatchAttachment) != etag {
+		return res
+	}
+	notModified := copyResponseWithValue(res, nil)
+	notModified.SetAttachment(NotModifiedAttachment, "true")
+	return notModified
+}
+
+// computeETag hash the json of the value, so equal values have the same etag
+func computeETag(value interface{}) (string, error) {
+	if rv, ok := value.(reflect.Value); ok {
+		value = rv.Interface()
+	}
+	h := fnv.New64a()
+	switch v := value.(type) {
+	case nil:
+	case []byte:
+		h.Write(v)
+	case string:
+		h.Write([]byte(v))
+	default:
+		b, err := json.Marshal(value)
+		if err != nil {
+			return "", fmt.Errorf("value of type %T can not be hashed: %v", value, err)
+		}
+		h.Write(b)
+	}
+	return strconv.FormatUint(h.Sum64(), 36), nil
+}
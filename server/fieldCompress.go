@@ -0,0 +1,73 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+	mpro "github.com/weibocom/motan-go/protocol"
+)
+
+// CompressFieldsKey is the provider url parameter of field-level response compression, the value is a json map of method name to
+// fields, e.g. {"getDoc":["content"]}. only the string or []byte fields of map[string]interface{} response values are compressed,
+// the compressed fields are noted in response attachment mpro.MFieldCompress, clients should restore them by mpro.DecompressFields
+const CompressFieldsKey = "compressFields"
+
+// FieldCompressMinSizeKey is the provider url parameter of the min size of a field to be compressed
+const FieldCompressMinSizeKey = "fieldCompressMinSize"
+
+const defaultFieldCompressMinSize = 1024
+
+type fieldCompression struct {
+	methods map[string][]string
+	minSize int
+}
+
+func parseFieldCompression(url *motan.URL) (*fieldCompression, error) {
+	value := url.GetParam(CompressFieldsKey, "")
+	if value == "" {
+		return nil, nil
+	}
+	c := &fieldCompression{minSize: int(url.GetPositiveIntValue(FieldCompressMinSizeKey, defaultFieldCompressMinSize))}
+	if err := json.Unmarshal([]byte(value), &c.methods); err != nil {
+		return nil, fmt.Errorf("illegal %s: %s, err: %v", CompressFieldsKey, value, err)
+	}
+	for method, fields := range c.methods {
+		if method == "" {
+			return nil, errors.New("illegal " + CompressFieldsKey + ": empty method name")
+		}
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("illegal %s: no fields of method %s", CompressFieldsKey, method)
+		}
+	}
+	return c, nil
+}
+
+func (c *fieldCompression) apply(request motan.Request, response motan.Response) motan.Response {
+	if c == nil || response == nil || response.GetException() != nil {
+		return response
+	}
+	fields, ok := c.methods[request.GetMethod()]
+	if !ok {
+		if fields, ok = c.methods[motan.FirstUpper(request.GetMethod())]; !ok {
+			return response
+		}
+	}
+	value, ok := response.GetValue().(map[string]interface{})
+	if !ok {
+		return response
+	}
+	compressed, attachment, err := mpro.CompressFields(value, fields, c.minSize)
+	if err != nil {
+		vlog.Warningf("compress response fields fail, send uncompressed. req:%s, err:%v", motan.GetReqInfo(request), err)
+		return response
+	}
+	if attachment == "" {
+		return response
+	}
+	res := copyResponseWithValue(response, compressed)
+	res.SetAttachment(mpro.MFieldCompress, attachment)
+	return res
+}
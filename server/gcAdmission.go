@@ -0,0 +1,157 @@
+package server
+
+import (
+	"errors"
+	"math"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+const (
+	// GCShedPauseRatioKey is the provider url parameter of the max fraction of recent wall time spent in GC pauses, e.g. 0.05.
+	// low priority requests are rejected with 503 while the GC pressure exceeds any of the thresholds
+	GCShedPauseRatioKey = "gcShedPauseRatio"
+	// GCShedFrequencyKey is the provider url parameter of the max number of recent GCs per second
+	GCShedFrequencyKey = "gcShedFrequency"
+	// GCShedMethodsKey is the provider url parameter of the low priority methods separated by comma, '*' means all methods.
+	// requests with the attachment PriorityAttachment set to PriorityLow are low priority too
+	GCShedMethodsKey = "gcShedMethods"
+)
+
+const (
+	PriorityAttachment = "M_pri"
+	PriorityLow        = "low"
+)
+
+// interval to sample the gc stats
+const gcSampleInterval = time.Second
+
+// gcPressureMonitor samples the gc stats periodically, and keeps the gc pressure of the latest interval
+type gcPressureMonitor struct {
+	once       sync.Once
+	lastTime   time.Time
+	lastPause  uint64
+	lastNumGC  uint32
+	pauseRatio uint64 // float64 bits
+	frequency  uint64 // float64 bits
+}
+
+var defaultGCMonitor = &gcPressureMonitor{}
+
+// start the sampling goroutine once, it runs until the process exits
+func (m *gcPressureMonitor) start() {
+	m.once.Do(func() {
+		stats := &runtime.MemStats{}
+		runtime.ReadMemStats(stats)
+		m.sample(stats, time.Now())
+		go func() {
+			ticker := time.NewTicker(gcSampleInterval)
+			defer ticker.Stop()
+			for now := range ticker.C {
+				runtime.ReadMemStats(stats)
+				m.sample(stats, now)
+			}
+		}()
+	})
+}
+
+// sample is called by the sampling goroutine only, the first sample is the baseline
+func (m *gcPressureMonitor) sample(stats *runtime.MemStats, now time.Time) {
+	if !m.lastTime.IsZero() {
+		elapsed := now.Sub(m.lastTime)
+		if elapsed <= 0 {
+			return
+		}
+		pauseRatio := float64(stats.PauseTotalNs-m.lastPause) / float64(elapsed)
+		frequency := float64(stats.NumGC-m.lastNumGC) / elapsed.Seconds()
+		atomic.StoreUint64(&m.pauseRatio, math.Float64bits(pauseRatio))
+		atomic.StoreUint64(&m.frequency, math.Float64bits(frequency))
+	}
+	m.lastTime, m.lastPause, m.lastNumGC = now, stats.PauseTotalNs, stats.NumGC
+}
+
+// pressure returns the fraction of time spent in gc pauses and the gc count per second of the latest interval
+func (m *gcPressureMonitor) pressure() (pauseRatio float64, frequency float64) {
+	return math.Float64frombits(atomic.LoadUint64(&m.pauseRatio)), math.Float64frombits(atomic.LoadUint64(&m.frequency))
+}
+
+// gcAdmission sheds low priority requests while the gc pressure is high
+type gcAdmission struct {
+	monitor       *gcPressureMonitor
+	maxPauseRatio float64
+	maxFrequency  float64
+	allMethods    bool
+	methods       map[string]bool
+}
+
+func parseGCAdmission(url *motan.URL) (*gcAdmission, error) {
+	a := &gcAdmission{monitor: defaultGCMonitor}
+	var err error
+	if a.maxPauseRatio, err = parseGCThreshold(url, GCShedPauseRatioKey); err != nil {
+		return nil, err
+	}
+	if a.maxFrequency, err = parseGCThreshold(url, GCShedFrequencyKey); err != nil {
+		return nil, err
+	}
+	if a.maxPauseRatio == 0 && a.maxFrequency == 0 {
+		return nil, nil
+	}
+	methods := motan.TrimSplit(url.GetParam(GCShedMethodsKey, ""), ",")
+	a.methods = make(map[string]bool, len(methods))
+	for _, m := range methods {
+		if m == "*" {
+			a.allMethods = true
+		} else if m != "" {
+			a.methods[m] = true
+		}
+	}
+	return a, nil
+}
+
+func parseGCThreshold(url *motan.URL, key string) (float64, error) {
+	v := url.GetParam(key, "")
+	if v == "" {
+		return 0, nil
+	}
+	threshold, err := strconv.ParseFloat(v, 64)
+	if err != nil || threshold <= 0 || math.IsInf(threshold, 0) {
+		return 0, errors.New("illegal " + key + ": " + v)
+	}
+	return threshold, nil
+}
+
+func (a *gcAdmission) lowPriority(request motan.Request) bool {
+	return a.allMethods || a.methods[request.GetMethod()] || a.methods[motan.FirstUpper(request.GetMethod())] ||
+		request.GetAttachment(PriorityAttachment) == PriorityLow
+}
+
+// severity is the max ratio of the gc pressure to the thresholds
+func (a *gcAdmission) severity(pauseRatio float64, frequency float64) float64 {
+	severity := 1.0
+	if a.maxPauseRatio > 0 {
+		severity = math.Max(severity, pauseRatio/a.maxPauseRatio)
+	}
+	if a.maxFrequency > 0 {
+		severity = math.Max(severity, frequency/a.maxFrequency)
+	}
+	return severity
+}
+
+// admit returns an exception response if the request should be shed, otherwise returns nil
+func (a *gcAdmission) admit(request motan.Request, retryAfter *retryAfterPolicy) motan.Response {
+	if a == nil || !a.lowPriority(request) {
+		return nil
+	}
+	pauseRatio, frequency := a.monitor.pressure()
+	if (a.maxPauseRatio > 0 && pauseRatio > a.maxPauseRatio) || (a.maxFrequency > 0 && frequency > a.maxFrequency) {
+		vlog.Warningf("low priority request rejected by gc pressure. req:%s, gc pause ratio:%.4f, gc frequency:%.2f/s", motan.GetReqInfo(request), pauseRatio, frequency)
+		return overloadResponse(request, retryAfter, "server is under gc pressure, low priority request is shed", a.severity(pauseRatio, frequency))
+	}
+	return nil
+}
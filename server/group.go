@@ -0,0 +1,89 @@
+package server
+
+import (
+	motan "github.com/weibocom/motan-go/core"
+)
+
+// groupProviders are the providers of a service path in different local groups, the providers which expose known method sets
+// can share a group, each of them serves a part of the methods of the service
+type groupProviders []motan.Provider
+
+// add appends the provider to the same group if both of them expose known method sets, otherwise it replaces the provider of the same group
+func (g groupProviders) add(p motan.Provider) groupProviders {
+	known := knownMethods(p) != nil
+	for i, gp := range g {
+		if gp.GetURL().Group == p.GetURL().Group && (!known || knownMethods(gp) == nil) {
+			result := append(groupProviders{}, g...)
+			result[i] = p
+			return result
+		}
+	}
+	return append(append(groupProviders{}, g...), p)
+}
+
+func (g groupProviders) remove(p motan.Provider) groupProviders {
+	result := make(groupProviders, 0, len(g))
+	for _, gp := range g {
+		if gp != p {
+			result = append(result, gp)
+		}
+	}
+	return result
+}
+
+func (g groupProviders) contains(p motan.Provider) bool {
+	for _, gp := range g {
+		if gp == p {
+			return true
+		}
+	}
+	return false
+}
+
+// collisions returns the methods of the provider which are served by the other providers of the same group,
+// the calls of them are dispatched to the provider added first
+func (g groupProviders) collisions(p motan.Provider) []string {
+	mp := knownMethods(p)
+	if mp == nil {
+		return nil
+	}
+	var methods []string
+	for _, name := range mp.GetMethodNames() {
+		for _, gp := range g {
+			if gp != p && gp.GetURL().Group == p.GetURL().Group && knownMethods(gp) != nil && hasMethod(gp, name) {
+				methods = append(methods, name)
+				break
+			}
+		}
+	}
+	return methods
+}
+
+// selectProvider prefers the available provider of the request group, then any available provider. the providers which do
+// not serve the method are skipped unless no provider serves it. it returns nil if all groups are unavailable.
+// a single provider is always selected as before
+func (g groupProviders) selectProvider(group string, method string) motan.Provider {
+	if len(g) == 1 {
+		return g[0]
+	}
+	served := false
+	for _, p := range g {
+		if hasMethod(p, method) {
+			served = true
+			break
+		}
+	}
+	var candidate motan.Provider
+	for _, p := range g {
+		if !p.IsAvailable() || (served && !hasMethod(p, method)) {
+			continue
+		}
+		if p.GetURL().Group == group {
+			return p
+		}
+		if candidate == nil {
+			candidate = p
+		}
+	}
+	return candidate
+}
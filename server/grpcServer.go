@@ -0,0 +1,255 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/endpoint"
+	"github.com/weibocom/motan-go/log"
+	"github.com/weibocom/motan-go/serialize"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// GrpcServer serves the providers of the message handler to grpc clients over http2. the grpc method
+// /<service>/<method> is called as the method of the provider of the service, the request message is passed as a
+// DeserializableValue of the grpc-pb serialization, so the provider deserializes it into its protobuf message.
+// the response value should be a protobuf message or the serialized []byte. the grpc metadata is passed as the request
+// attachments, and the response attachments are sent as the response header, the pseudo-headers, the grpc-* headers and
+// the http2 transport headers are not passed in both directions. only unary calls are supported
+type GrpcServer struct {
+	URL        *motan.URL
+	handler    motan.MessageHandler
+	extFactory motan.ExtensionFactory
+	proxy      bool
+	server     *grpc.Server
+	listener   net.Listener
+}
+
+// the headers of the http2 transport, they are not passed as the attachments
+var grpcTransportHeaders = map[string]bool{
+	"content-type":      true,
+	"content-length":    true,
+	"user-agent":        true,
+	"te":                true,
+	"host":              true,
+	"connection":        true,
+	"keep-alive":        true,
+	"proxy-connection":  true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+}
+
+// isGrpcAttachmentKey returns false for the pseudo-headers, the grpc-* headers and the transport headers
+func isGrpcAttachmentKey(key string) bool {
+	key = strings.ToLower(key)
+	return !strings.HasPrefix(key, ":") && !strings.HasPrefix(key, "grpc-") && !grpcTransportHeaders[key]
+}
+
+// grpc status codes of the motan exception codes, other codes are codes.Unknown
+var grpcCodes = map[int]codes.Code{
+	400: codes.InvalidArgument,
+	401: codes.Unauthenticated,
+	403: codes.PermissionDenied,
+	404: codes.NotFound,
+	429: codes.ResourceExhausted,
+	501: codes.Unimplemented,
+	503: codes.Unavailable,
+	504: codes.DeadlineExceeded,
+}
+
+func (g *GrpcServer) Open(block bool, proxy bool, handler motan.MessageHandler, extFactory motan.ExtensionFactory) error {
+	options := []grpc.ServerOption{grpc.CustomCodec(grpcRawCodec{}), grpc.UnknownServiceHandler(g.handleStream)}
+	tlsConfig, err := parseTLSConfig(g.URL)
+	if err != nil {
+		vlog.Errorf("open grpc server of service %s fail. err: %v", g.URL.Path, err)
+		return err
+	}
+	if tlsConfig != nil {
+		options = append(options, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	addr := listenAddr(g.URL)
+	lis, err := motan.Listen("tcp", addr)
+	if err != nil {
+		vlog.Errorf("listen port:%d fail, the port may be used by another process. service: %s, err: %v", g.URL.Port, g.URL.Path, err)
+		return err
+	}
+	g.listener = lis
+	g.handler = handler
+	g.extFactory = extFactory
+	g.proxy = proxy
+	g.server = grpc.NewServer(options...)
+	vlog.Infof("grpc server is started. port:%d", g.URL.Port)
+	if block {
+		return g.server.Serve(lis)
+	}
+	go g.server.Serve(lis)
+	return nil
+}
+
+func (g *GrpcServer) handleStream(srv interface{}, stream grpc.ServerStream) error {
+	start := time.Now()
+	fullMethod, _ := grpc.MethodFromServerStream(stream)
+	service, method := splitServiceMethod(fullMethod)
+	if service == "" || method == "" {
+		return status.Errorf(codes.Unimplemented, "illegal grpc method %s", fullMethod)
+	}
+	if g.handler.GetProvider(service) == nil {
+		return status.Errorf(codes.Unimplemented, "not found provider for %s", service)
+	}
+	in := &grpcRawMessage{}
+	if err := stream.RecvMsg(in); err != nil {
+		return err
+	}
+	request := &motan.MotanRequest{RequestID: endpoint.GenerateRequestID(), ServiceName: service, Method: method,
+		Attachment: motan.NewStringMap(motan.DefaultAttachmentSize)}
+	serialization := g.extFactory.GetSerialization(serialize.GrpcPb, serialize.GrpcPbNumber)
+	request.Arguments = []interface{}{&motan.DeserializableValue{Body: in.buf, Serialization: serialization}}
+	if md, ok := metadata.FromIncomingContext(stream.Context()); ok {
+		for k, v := range md {
+			if len(v) > 0 && isGrpcAttachmentKey(k) {
+				request.Attachment.Store(k, v[0])
+			}
+		}
+	}
+	if p, ok := peer.FromContext(stream.Context()); ok && p.Addr != nil {
+		request.Attachment.Store(motan.HostKey, getRemoteIP(p.Addr.String()))
+	}
+	reqCtx := request.GetRPCContext(true)
+	reqCtx.ExtFactory = g.extFactory
+	reqCtx.RequestReceiveTime = start
+	reqCtx.Proxy = g.proxy
+
+	res := g.handler.Call(request)
+	if res == nil {
+		return status.Error(codes.Internal, "handler call return nil")
+	}
+	defer res.GetRPCContext(true).OnFinish()
+	if res.GetAttachments() != nil {
+		md := metadata.MD{}
+		res.GetAttachments().Range(func(k, v string) bool {
+			if isGrpcAttachmentKey(k) {
+				md.Append(k, v)
+			}
+			return true
+		})
+		stream.SetHeader(md)
+	}
+	if ex := res.GetException(); ex != nil {
+		code, ok := grpcCodes[ex.ErrCode]
+		if !ok {
+			code = codes.Unknown
+		}
+		return status.Error(code, ex.ErrMsg)
+	}
+	out, err := grpcResponseBody(res, serialization)
+	if err != nil {
+		vlog.Errorf("grpc server convert response fail. req:%s, err:%v", motan.GetReqInfo(request), err)
+		return status.Error(codes.Internal, "convert to response fail. err:"+err.Error())
+	}
+	return stream.SendMsg(&grpcRawMessage{buf: out})
+}
+
+// splitServiceMethod splits the grpc method or http path /<service>/<method> into the service and the method
+func splitServiceMethod(fullMethod string) (service string, method string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	i := strings.LastIndex(fullMethod, "/")
+	if i < 0 {
+		return "", ""
+	}
+	return fullMethod[:i], fullMethod[i+1:]
+}
+
+func grpcResponseBody(res motan.Response, serialization motan.Serialization) ([]byte, error) {
+	switch v := res.GetValue().(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return v, nil
+	}
+	if serialization == nil {
+		return nil, errors.New("grpc-pb serialization is not registered")
+	}
+	return serialization.Serialize(res.GetValue())
+}
+
+func (g *GrpcServer) GetMessageHandler() motan.MessageHandler {
+	return g.handler
+}
+
+func (g *GrpcServer) SetMessageHandler(mh motan.MessageHandler) {
+	g.handler = mh
+}
+
+func (g *GrpcServer) GetURL() *motan.URL {
+	return g.URL
+}
+
+func (g *GrpcServer) SetURL(url *motan.URL) {
+	g.URL = url
+}
+
+func (g *GrpcServer) GetName() string {
+	return GRPC
+}
+
+func (g *GrpcServer) Destroy() {
+	if g.server != nil {
+		g.server.Stop()
+	}
+}
+
+// Shutdown stops accepting new connections and waits the requests in processing. the context without deadline waits at
+// most GracefulShutdownTimeoutKey of the server url, the connections are closed if the context is done first
+func (g *GrpcServer) Shutdown(ctx context.Context) error {
+	if g.server == nil {
+		return nil
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.URL.GetTimeDuration(GracefulShutdownTimeoutKey, time.Millisecond, defaultGracefulShutdownTimeout))
+		defer cancel()
+	}
+	stopped := make(chan struct{})
+	go func() {
+		g.server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		vlog.Warningf("drain grpc server fail, requests in processing will be dropped. url:%v, err:%v", g.URL, ctx.Err())
+		g.server.Stop()
+		return ctx.Err()
+	}
+}
+
+// grpcRawCodec passes the grpc messages as is, they are deserialized by the providers
+type grpcRawCodec struct{}
+
+type grpcRawMessage struct {
+	buf []byte
+}
+
+func (grpcRawCodec) Marshal(v interface{}) ([]byte, error) {
+	return v.(*grpcRawMessage).buf, nil
+}
+
+func (grpcRawCodec) Unmarshal(data []byte, v interface{}) error {
+	// the data may be reused by grpc after Unmarshal
+	v.(*grpcRawMessage).buf = append([]byte(nil), data...)
+	return nil
+}
+
+func (grpcRawCodec) String() string {
+	return "raw"
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/endpoint"
+	"github.com/weibocom/motan-go/serialize"
+)
+
+func TestGrpcServer(t *testing.T) {
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	RegistDefaultServers(ext)
+	serialize.RegistDefaultSerializations(ext)
+	url := &motan.URL{Protocol: GRPC, Host: "127.0.0.1", Port: 64596, Path: "grpcService"}
+	server := ext.GetServer(url)
+	assert.Equal(t, GRPC, server.GetName())
+	p := newTestProvider("grpcService", nil)
+	p.callFunc = func(request motan.Request) motan.Response {
+		if request.GetMethod() == "fail" {
+			return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 503, ErrMsg: "overloaded", ErrType: motan.ServiceException})
+		}
+		// the transport headers are not passed as the attachments
+		for _, k := range []string{":authority", "content-type", "user-agent", "te", "grpc-timeout"} {
+			assert.Equal(t, "", request.GetAttachment(k), k)
+		}
+		dv := request.GetArguments()[0].(*motan.DeserializableValue)
+		assert.Equal(t, serialize.GrpcPbNumber, dv.Serialization.GetSerialNum())
+		res := &motan.MotanResponse{RequestID: request.GetRequestID(), Value: append([]byte("echo "), dv.Body...)}
+		res.SetAttachment("from", request.GetAttachment("from"))
+		return res
+	}
+	assert.Nil(t, server.Open(false, false, newTestHandler(p), ext))
+
+	ep := &endpoint.GrpcEndPoint{}
+	ep.SetURL(&motan.URL{Host: "127.0.0.1", Port: 64596})
+	ep.Initialize()
+	defer ep.Destroy()
+	call := func(service string, method string) motan.Response {
+		request := &motan.MotanRequest{RequestID: 1, ServiceName: service, Method: method, Arguments: []interface{}{[]byte("hello")},
+			Attachment: motan.NewStringMap(0)}
+		request.SetAttachment("from", "test")
+		return ep.Call(request)
+	}
+	res := call("grpcService", "echo")
+	assert.Nil(t, res.GetException())
+	assert.Equal(t, []byte("echo hello"), res.GetValue())
+	res = call("grpcService", "fail")
+	assert.Equal(t, 14, res.GetException().ErrCode, "503 is unavailable")
+	assert.Equal(t, "overloaded", res.GetException().ErrMsg)
+	res = call("unknownService", "echo")
+	assert.Equal(t, 12, res.GetException().ErrCode, "unknown service is unimplemented")
+
+	assert.Nil(t, server.Shutdown(context.Background()))
+	assert.NotNil(t, call("grpcService", "echo").GetException())
+}
+
+func TestIsGrpcAttachmentKey(t *testing.T) {
+	for _, k := range []string{"from", "M_s", "x-trace-id"} {
+		assert.True(t, isGrpcAttachmentKey(k), k)
+	}
+	for _, k := range []string{":status", ":authority", "grpc-status", "grpc-message", "Content-Type", "user-agent", "te", "connection"} {
+		assert.False(t, isGrpcAttachmentKey(k), k)
+	}
+}
+
+func TestSplitServiceMethod(t *testing.T) {
+	service, method := splitServiceMethod("/com.weibo.Hello/sayHello")
+	assert.Equal(t, "com.weibo.Hello", service)
+	assert.Equal(t, "sayHello", method)
+	service, method = splitServiceMethod("/noMethod")
+	assert.Equal(t, "", service)
+	assert.Equal(t, "", method)
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/endpoint"
+	"github.com/weibocom/motan-go/log"
+)
+
+// HealthCheckKey is the provider url parameter of the active health check of the exported url. the value is a method of
+// the provider called without arguments, or an endpoint pinged by the check: tcp://host:port is dialed and http(s)://...
+// is requested by GET, a 2xx status is healthy. the provider is unhealthy if it is not available either. the url is set
+// unavailable after HealthCheckThresholdKey consecutive failures, and available again after a successful check
+const HealthCheckKey = "healthCheck"
+
+// HealthCheckIntervalKey is the provider url parameter of the health check interval in ms, it is also the check timeout
+const HealthCheckIntervalKey = "healthCheckInterval"
+
+// HealthCheckThresholdKey is the provider url parameter of the consecutive failures to set the url unavailable
+const HealthCheckThresholdKey = "healthCheckThreshold"
+
+// RegisterAfterHealthyKey is the provider url parameter to register the url only after the health check passes, the
+// check is done at once on export and every interval until it passes, then the url is registered or delayed by
+// RegisterDelayKey, and the periodic health check starts. HealthCheckKey is required if it is true
+const RegisterAfterHealthyKey = "registerAfterHealthy"
+
+const (
+	defaultHealthCheckInterval  = 5 * time.Second
+	defaultHealthCheckThreshold = 3
+)
+
+var errProviderUnavailable = errors.New("provider is not available")
+
+// checkHealth returns nil if the provider is healthy
+func checkHealth(provider motan.Provider, check string, timeout time.Duration) error {
+	if !provider.IsAvailable() {
+		return errProviderUnavailable
+	}
+	switch {
+	case strings.HasPrefix(check, "tcp://"):
+		conn, err := net.DialTimeout("tcp", strings.TrimPrefix(check, "tcp://"), timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case strings.HasPrefix(check, "http://"), strings.HasPrefix(check, "https://"):
+		res, err := (&http.Client{Timeout: timeout}).Get(check)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		if res.StatusCode < 200 || res.StatusCode >= 300 {
+			return errors.New("health check responds " + res.Status)
+		}
+		return nil
+	}
+	request := &motan.MotanRequest{RequestID: endpoint.GenerateRequestID(), ServiceName: provider.GetPath(), Method: check}
+	res := provider.Call(request)
+	if res == nil {
+		return errors.New("health check responds nil")
+	}
+	if ex := res.GetException(); ex != nil {
+		return fmt.Errorf("health check responds exception, code: %d, msg: %s", ex.ErrCode, ex.ErrMsg)
+	}
+	return nil
+}
+
+// startHealthCheck checks the health of the provider periodically if it is configured, it should be called with the lock held
+func (d *DefaultExporter) startHealthCheck() {
+	check := d.url.GetParam(HealthCheckKey, "")
+	if check == "" {
+		return
+	}
+	d.stopHealthCheck()
+	interval := d.url.GetTimeDuration(HealthCheckIntervalKey, time.Millisecond, defaultHealthCheckInterval)
+	threshold := d.url.GetPositiveIntValue(HealthCheckThresholdKey, defaultHealthCheckThreshold)
+	cancel := make(chan struct{})
+	d.healthCancel = cancel
+	vlog.Infof("health check of url %s starts, check: %s, interval: %v, threshold: %d", d.url.GetIdentity(), check, interval, threshold)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		failures := int64(0)
+		// the url is set unavailable by the health check, so it is set available after the provider recovers
+		down := false
+		for {
+			select {
+			case <-ticker.C:
+			case <-cancel:
+				return
+			}
+			err := checkHealth(d.provider, check, interval)
+			if err == nil {
+				failures = 0
+				if down {
+					vlog.Infof("url %s is healthy again, set it available", d.url.GetIdentity())
+					down = false
+					d.setHealthAvailable(cancel, true)
+				}
+				continue
+			}
+			failures++
+			vlog.Warningf("health check of url %s fail, failures: %d, err: %v", d.url.GetIdentity(), failures, err)
+			// the url set unavailable by others is kept unavailable after the provider recovers
+			if failures >= threshold && d.setHealthAvailable(cancel, false) {
+				down = true
+			}
+		}
+	}()
+}
+
+// registerAfterHealthy registers the url after the first passed health check, it is canceled as the delayed registration.
+// it should be called with the lock held
+func (d *DefaultExporter) registerAfterHealthy(delay time.Duration, delayed bool) {
+	check := d.url.GetParam(HealthCheckKey, "")
+	interval := d.url.GetTimeDuration(HealthCheckIntervalKey, time.Millisecond, defaultHealthCheckInterval)
+	cancel := make(chan struct{})
+	d.registerCancel = cancel
+	vlog.Infof("url %s is registered after the health check passes, check: %s", d.url.GetIdentity(), check)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			err := checkHealth(d.provider, check, interval)
+			if err == nil {
+				break
+			}
+			vlog.Warningf("url %s is not registered, health check fail. err: %v", d.url.GetIdentity(), err)
+			select {
+			case <-ticker.C:
+			case <-cancel:
+				return
+			}
+		}
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		if d.registerCancel != cancel {
+			return
+		}
+		d.registerCancel = nil
+		vlog.Infof("health check of url %s passed, register it", d.url.GetIdentity())
+		if delayed {
+			d.delayRegister(delay)
+		} else if err := d.registerAll(); err != nil {
+			return
+		}
+		d.startHealthCheck()
+	}()
+}
+
+// setHealthAvailable sets the availability if the health check is not stopped, it returns whether the availability changes
+func (d *DefaultExporter) setHealthAvailable(cancel chan struct{}, available bool) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.healthCancel != cancel || d.available == available {
+		return false
+	}
+	d.updateAvailable(available)
+	return true
+}
+
+// stopHealthCheck cancels the health check, it should be called with the lock held
+func (d *DefaultExporter) stopHealthCheck() {
+	if d.healthCancel != nil {
+		close(d.healthCancel)
+		d.healthCancel = nil
+	}
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+)
+
+func TestProviderUnavailable(t *testing.T) {
+	exporter := &DefaultExporter{}
+	p := newTestProvider("unavailable", map[string]string{motan.RegistryKey: " "})
+	exporter.SetProvider(p)
+	assert.Nil(t, exporter.Export(&MotanServer{URL: &motan.URL{}, handler: newTestHandler(p)}, nil, &motan.Context{}))
+	assert.True(t, exporter.IsAvailable())
+	p.unavailable = true
+	assert.False(t, exporter.IsAvailable())
+	p.unavailable = false
+	assert.True(t, exporter.IsAvailable())
+	exporter.Unexport()
+}
+
+func TestHealthCheck(t *testing.T) {
+	events := &shutdownEvents{}
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	ext.RegistExtRegistry("record", func(url *motan.URL) motan.Registry {
+		return &recordRegistry{events: events}
+	})
+	context := &motan.Context{RegistryURLs: map[string]*motan.URL{"r": {Protocol: "record", Host: "127.0.0.1"}}}
+	p := newTestProvider("health", map[string]string{motan.RegistryKey: "r", HealthCheckKey: "ping",
+		HealthCheckIntervalKey: "10", HealthCheckThresholdKey: "2"})
+	var healthy int32 = 1
+	p.callFunc = func(request motan.Request) motan.Response {
+		if request.GetMethod() == "ping" && atomic.LoadInt32(&healthy) == 0 {
+			return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 503, ErrMsg: "unhealthy", ErrType: motan.ServiceException})
+		}
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: "pong"}
+	}
+	exporter := &DefaultExporter{}
+	exporter.SetProvider(p)
+	assert.Nil(t, exporter.Export(&MotanServer{URL: &motan.URL{}, handler: newTestHandler(p)}, ext, context))
+	waitAvailable := func(available bool) bool {
+		for i := 0; i < 100; i++ {
+			if exporter.IsAvailable() == available {
+				return true
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return false
+	}
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, exporter.IsAvailable())
+	assert.Equal(t, []string{"register"}, events.get())
+
+	// the unhealthy provider is not advertised
+	atomic.StoreInt32(&healthy, 0)
+	assert.True(t, waitAvailable(false))
+	assert.Equal(t, []string{"register", "unavailable"}, events.get())
+
+	// available again after it recovers
+	atomic.StoreInt32(&healthy, 1)
+	assert.True(t, waitAvailable(true))
+	assert.Equal(t, []string{"register", "unavailable", "available"}, events.get())
+
+	// the url set unavailable by others is kept unavailable
+	exporter.Unavailable()
+	atomic.StoreInt32(&healthy, 0)
+	time.Sleep(50 * time.Millisecond)
+	atomic.StoreInt32(&healthy, 1)
+	time.Sleep(50 * time.Millisecond)
+	assert.False(t, exporter.IsAvailable())
+	exporter.Unexport()
+	count := len(events.get())
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, count, len(events.get()), "health check is stopped by unexport")
+}
+
+func TestCheckHealth(t *testing.T) {
+	p := newTestProvider("check", nil)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	assert.Nil(t, checkHealth(p, "tcp://"+listener.Addr().String(), time.Second))
+	listener.Close()
+	assert.NotNil(t, checkHealth(p, "tcp://"+listener.Addr().String(), time.Second))
+	p.unavailable = true
+	assert.Equal(t, errProviderUnavailable, checkHealth(p, "tcp://"+listener.Addr().String(), time.Second))
+}
+
+func TestRegisterAfterHealthy(t *testing.T) {
+	events := &shutdownEvents{}
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	ext.RegistExtRegistry("record", func(url *motan.URL) motan.Registry {
+		return &recordRegistry{events: events}
+	})
+	context := &motan.Context{RegistryURLs: map[string]*motan.URL{"r": {Protocol: "record", Host: "127.0.0.1"}}}
+	var healthy int32
+	ping := func(request motan.Request) motan.Response {
+		if atomic.LoadInt32(&healthy) == 0 {
+			return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 503, ErrMsg: "unhealthy", ErrType: motan.ServiceException})
+		}
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: "pong"}
+	}
+	export := func(path string, params map[string]string) (*DefaultExporter, error) {
+		params[motan.RegistryKey] = "r"
+		p := newTestProvider(path, params)
+		p.callFunc = ping
+		exporter := &DefaultExporter{}
+		exporter.SetProvider(p)
+		return exporter, exporter.Export(&MotanServer{URL: &motan.URL{}, handler: newTestHandler(p)}, ext, context)
+	}
+	_, err := export("noCheck", map[string]string{RegisterAfterHealthyKey: "true"})
+	assert.NotNil(t, err)
+
+	exporter, err := export("afterHealthy", map[string]string{RegisterAfterHealthyKey: "true", HealthCheckKey: "ping",
+		HealthCheckIntervalKey: "10"})
+	assert.Nil(t, err)
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, events.get(), "the unhealthy url is not registered")
+	atomic.StoreInt32(&healthy, 1)
+	for i := 0; i < 100 && len(events.get()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, []string{"register"}, events.get())
+	assert.True(t, exporter.IsAvailable())
+	exporter.Unexport()
+
+	// canceled by unexport before healthy
+	count := len(events.get())
+	atomic.StoreInt32(&healthy, 0)
+	exporter, err = export("canceled", map[string]string{RegisterAfterHealthyKey: "true", HealthCheckKey: "ping",
+		HealthCheckIntervalKey: "10"})
+	assert.Nil(t, err)
+	exporter.Unexport()
+	atomic.StoreInt32(&healthy, 1)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, count, len(events.get()))
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"math"
@@ -186,11 +187,16 @@ func (s *HTTPProxyServer) Open(block bool, proxy bool, clusterGetter HTTPCluster
 		return nil
 	}
 
+	listener, err := core.Listen("tcp", s.url.Host+":"+s.url.GetPortStr())
+	if err != nil {
+		vlog.Errorf("listen http proxy port fail. port:%s, err:%v", s.url.GetPortStr(), err)
+		return err
+	}
 	if block {
-		s.httpServer.ListenAndServe(s.url.Host + ":" + s.url.GetPortStr())
+		s.httpServer.Serve(listener)
 	} else {
 		go func() {
-			s.httpServer.ListenAndServe(s.url.Host + ":" + s.url.GetPortStr())
+			s.httpServer.Serve(listener)
 		}()
 	}
 	return nil
@@ -211,6 +217,23 @@ func (s *HTTPProxyServer) GetName() string {
 func (s *HTTPProxyServer) Destroy() {
 }
 
+// Shutdown stops accepting new connections and waits the requests in processing until they finish or the context is done
+func (s *HTTPProxyServer) Shutdown(ctx context.Context) error {
+	if s.httpServer == nil {
+		return nil
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.httpServer.Shutdown()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (s *HTTPProxyServer) GetHTTPClient() *fasthttp.Client {
 	return s.httpClient
 }
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/endpoint"
+	"github.com/weibocom/motan-go/log"
+	"github.com/weibocom/motan-go/serialize"
+)
+
+// HTTPAttachmentHeaderPrefix is the prefix of the http headers of the attachments, e.g. the header X-Motan-Attachment-Trace-Id
+// is the request attachment trace-id. the names of the request attachments are lower cased
+const HTTPAttachmentHeaderPrefix = "X-Motan-Attachment-"
+
+// HTTPServer serves the providers of the message handler as json over http. the path /<service>/<method> is called as the
+// method of the provider of the service:
+//
+//	POST   the body is a json array of the arguments, other json values are the only argument
+//	GET    the query parameters are the only argument as a json object of strings, no argument if no query parameter
+//
+// the arguments are passed as a DeserializableValue of the json serialization, so the providers deserialize them into
+// their types. the response value is written as json with status 200. the exception is written as json with the status
+// of the exception code if it is a http error status, otherwise 500
+type HTTPServer struct {
+	URL        *motan.URL
+	handler    motan.MessageHandler
+	extFactory motan.ExtensionFactory
+	proxy      bool
+	server     *http.Server
+}
+
+var httpJSONSerialization = &serialize.JSONSerialization{}
+
+func (h *HTTPServer) Open(block bool, proxy bool, handler motan.MessageHandler, extFactory motan.ExtensionFactory) error {
+	tlsConfig, err := parseTLSConfig(h.URL)
+	if err != nil {
+		vlog.Errorf("open http server of service %s fail. err: %v", h.URL.Path, err)
+		return err
+	}
+	var lis net.Listener
+	lis, err = motan.Listen("tcp", listenAddr(h.URL))
+	if err != nil {
+		vlog.Errorf("listen port:%d fail, the port may be used by another process. service: %s, err: %v", h.URL.Port, h.URL.Path, err)
+		return err
+	}
+	if tlsConfig != nil {
+		lis = tls.NewListener(lis, tlsConfig)
+	}
+	h.handler = handler
+	h.extFactory = extFactory
+	h.proxy = proxy
+	h.server = &http.Server{Handler: http.HandlerFunc(h.serveHTTP)}
+	vlog.Infof("http server is started. port:%d", h.URL.Port)
+	serve := func() {
+		if err := h.server.Serve(lis); err != nil && err != http.ErrServerClosed {
+			vlog.Errorf("http server of service %s stopped. err: %v", h.URL.Path, err)
+		}
+	}
+	if block {
+		serve()
+	} else {
+		go serve()
+	}
+	return nil
+}
+
+func (h *HTTPServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	service, method := splitServiceMethod(r.URL.Path)
+	if service == "" || method == "" {
+		writeHTTPException(w, http.StatusNotFound, &motan.Exception{ErrCode: http.StatusNotFound, ErrMsg: "illegal path " + r.URL.Path, ErrType: motan.ServiceException})
+		return
+	}
+	if h.handler.GetProvider(service) == nil {
+		writeHTTPException(w, http.StatusNotFound, &motan.Exception{ErrCode: http.StatusNotFound, ErrMsg: "not found provider for " + service, ErrType: motan.ServiceException})
+		return
+	}
+	body, err := httpArguments(r)
+	if err != nil {
+		writeHTTPException(w, http.StatusBadRequest, &motan.Exception{ErrCode: http.StatusBadRequest, ErrMsg: err.Error(), ErrType: motan.ServiceException})
+		return
+	}
+	request := &motan.MotanRequest{RequestID: endpoint.GenerateRequestID(), ServiceName: service, Method: method,
+		Attachment: motan.NewStringMap(motan.DefaultAttachmentSize), Arguments: []interface{}{}}
+	if len(body) > 0 {
+		request.Arguments = []interface{}{&motan.DeserializableValue{Body: body, Serialization: httpJSONSerialization}}
+	}
+	for k, v := range r.Header {
+		if strings.HasPrefix(k, HTTPAttachmentHeaderPrefix) && len(v) > 0 {
+			request.Attachment.Store(strings.ToLower(strings.TrimPrefix(k, HTTPAttachmentHeaderPrefix)), v[0])
+		}
+	}
+	request.Attachment.Store(motan.HostKey, getRemoteIP(r.RemoteAddr))
+	reqCtx := request.GetRPCContext(true)
+	reqCtx.ExtFactory = h.extFactory
+	reqCtx.RequestReceiveTime = start
+	reqCtx.Proxy = h.proxy
+
+	res := h.handler.Call(request)
+	if res == nil {
+		writeHTTPException(w, http.StatusInternalServerError, &motan.Exception{ErrCode: http.StatusInternalServerError, ErrMsg: "handler call return nil", ErrType: motan.ServiceException})
+		return
+	}
+	defer res.GetRPCContext(true).OnFinish()
+	if res.GetAttachments() != nil {
+		res.GetAttachments().Range(func(k, v string) bool {
+			w.Header().Set(HTTPAttachmentHeaderPrefix+k, v)
+			return true
+		})
+	}
+	if ex := res.GetException(); ex != nil {
+		status := ex.ErrCode
+		if status < 400 || status > 599 {
+			status = http.StatusInternalServerError
+		}
+		writeHTTPException(w, status, ex)
+		return
+	}
+	out, err := httpJSONSerialization.Serialize(res.GetValue())
+	if err != nil {
+		vlog.Errorf("http server convert response fail. req:%s, err:%v", motan.GetReqInfo(request), err)
+		writeHTTPException(w, http.StatusInternalServerError, &motan.Exception{ErrCode: http.StatusInternalServerError, ErrMsg: "convert to response fail. err:" + err.Error(), ErrType: motan.ServiceException})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+// httpArguments returns the json array of the arguments of the http request
+func httpArguments(r *http.Request) ([]byte, error) {
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		if len(query) == 0 {
+			return nil, nil
+		}
+		return json.Marshal([]interface{}{queryArgument(query)})
+	case http.MethodPost:
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.TrimSpace(body)
+		if len(body) == 0 {
+			return nil, nil
+		}
+		if !json.Valid(body) {
+			return nil, errors.New("the body is not a valid json")
+		}
+		if body[0] != '[' {
+			body = append(append([]byte{'['}, body...), ']')
+		}
+		return body, nil
+	}
+	return nil, errors.New("unsupported http method " + r.Method + ", only GET and POST are supported")
+}
+
+func queryArgument(query url.Values) map[string]string {
+	arg := make(map[string]string, len(query))
+	for k, v := range query {
+		arg[k] = v[0]
+	}
+	return arg
+}
+
+func writeHTTPException(w http.ResponseWriter, status int, ex *motan.Exception) {
+	w.Header().Set("Content-Type", "application/json")
+	body, _ := json.Marshal(ex)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func (h *HTTPServer) GetMessageHandler() motan.MessageHandler {
+	return h.handler
+}
+
+func (h *HTTPServer) SetMessageHandler(mh motan.MessageHandler) {
+	h.handler = mh
+}
+
+func (h *HTTPServer) GetURL() *motan.URL {
+	return h.URL
+}
+
+func (h *HTTPServer) SetURL(url *motan.URL) {
+	h.URL = url
+}
+
+func (h *HTTPServer) GetName() string {
+	return HTTP
+}
+
+func (h *HTTPServer) Destroy() {
+	if h.server != nil {
+		h.server.Close()
+	}
+}
+
+// Shutdown stops accepting new connections and waits the requests in processing. the context without deadline waits at
+// most GracefulShutdownTimeoutKey of the server url, the connections are closed if the context is done first
+func (h *HTTPServer) Shutdown(ctx context.Context) error {
+	if h.server == nil {
+		return nil
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.URL.GetTimeDuration(GracefulShutdownTimeoutKey, time.Millisecond, defaultGracefulShutdownTimeout))
+		defer cancel()
+	}
+	err := h.server.Shutdown(ctx)
+	if err != nil {
+		vlog.Warningf("drain http server fail, requests in processing will be dropped. url:%v, err:%v", h.URL, err)
+		h.server.Close()
+	}
+	return err
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+)
+
+func TestHTTPServer(t *testing.T) {
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	RegistDefaultServers(ext)
+	server := ext.GetServer(&motan.URL{Protocol: HTTP, Host: "127.0.0.1", Port: 64597, Path: "httpService"})
+	assert.Equal(t, HTTP, server.GetName())
+	p := newTestProvider("httpService", nil)
+	p.callFunc = func(request motan.Request) motan.Response {
+		if request.GetMethod() == "fail" {
+			return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 429, ErrMsg: "too many requests", ErrType: motan.ServiceException})
+		}
+		var name string
+		var count int
+		args := []interface{}{&name, &count}
+		if len(request.GetArguments()) == 0 {
+			args = nil
+		} else if request.GetMethod() == "query" {
+			args = []interface{}{map[string]string{}}
+		}
+		if err := request.ProcessDeserializable(args); err != nil {
+			return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 400, ErrMsg: err.Error(), ErrType: motan.ServiceException})
+		}
+		res := &motan.MotanResponse{RequestID: request.GetRequestID(), Value: map[string]interface{}{"args": request.GetArguments()}}
+		res.SetAttachment("from", request.GetAttachment("from"))
+		return res
+	}
+	assert.Nil(t, server.Open(false, false, newTestHandler(p), ext))
+
+	call := func(method string, path string, body string) (int, string, http.Header) {
+		req, err := http.NewRequest(method, "http://127.0.0.1:64597"+path, strings.NewReader(body))
+		assert.Nil(t, err)
+		req.Header.Set(HTTPAttachmentHeaderPrefix+"From", "test")
+		res, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		defer res.Body.Close()
+		b, _ := ioutil.ReadAll(res.Body)
+		return res.StatusCode, string(b), res.Header
+	}
+	status, body, header := call("POST", "/httpService/echo", `["motan", 2]`)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, `{"args":["motan",2]}`, body)
+	assert.Equal(t, "test", header.Get(HTTPAttachmentHeaderPrefix+"from"))
+	status, body, _ = call("GET", "/httpService/query?name=motan", "")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, `{"args":[{"name":"motan"}]}`, body)
+	status, body, _ = call("GET", "/httpService/none", "")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, `{"args":[]}`, body)
+
+	status, body, _ = call("POST", "/httpService/fail", "")
+	assert.Equal(t, 429, status)
+	ex := &motan.Exception{}
+	assert.Nil(t, json.Unmarshal([]byte(body), ex))
+	assert.Equal(t, "too many requests", ex.ErrMsg)
+	status, _, _ = call("POST", "/httpService/echo", `["motan"`)
+	assert.Equal(t, 400, status)
+	status, _, _ = call("PUT", "/httpService/echo", "")
+	assert.Equal(t, 400, status)
+	status, _, _ = call("POST", "/unknown/echo", "")
+	assert.Equal(t, 404, status)
+
+	assert.Nil(t, server.Shutdown(context.Background()))
+	_, err := http.Get("http://127.0.0.1:64597/httpService/none")
+	assert.NotNil(t, err)
+}
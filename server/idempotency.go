@@ -0,0 +1,225 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// idempotency provider url parameter keys
+const (
+	IdempotentMethodsKey     = "idempotentMethods"     // methods separated by comma, '*' means all methods
+	IdempotencyWindowKey     = "idempotencyWindow"     // ms, results are kept in the window
+	IdempotencyStoreKey      = "idempotencyStore"      // name of the store registered by RegisterIdempotencyStore
+	IdempotencyMaxEntriesKey = "idempotencyMaxEntries" // max results of the memory store
+)
+
+// ReplayedAttachment is the response attachment which marks the response is a stored result
+const ReplayedAttachment = "M_idr"
+
+const (
+	IdempotencyStoreMemory = "memory"
+
+	defaultIdempotencyWindow     = 10 * time.Minute
+	defaultIdempotencyMaxEntries = 100000
+)
+
+// IdempotencyStore keeps the results of completed operations, a shared store(e.g. redis) should be used
+// if the retries may be sent to other servers
+type IdempotencyStore interface {
+	// Load returns nil if the result of key does not exist or expired
+	Load(key string) (motan.Response, error)
+	Store(key string, res motan.Response, window time.Duration) error
+}
+
+// IdempotencyStoreFactory creates the store of a provider
+type IdempotencyStoreFactory func(url *motan.URL) (IdempotencyStore, error)
+
+var (
+	idempotencyStores = map[string]IdempotencyStoreFactory{
+		IdempotencyStoreMemory: func(url *motan.URL) (IdempotencyStore, error) {
+			return NewMemoryIdempotencyStore(int(url.GetPositiveIntValue(IdempotencyMaxEntriesKey, defaultIdempotencyMaxEntries))), nil
+		},
+	}
+	idempotencyStoreLock sync.RWMutex
+)
+
+// RegisterIdempotencyStore register a store factory which can be used by url parameter idempotencyStore
+func RegisterIdempotencyStore(name string, factory IdempotencyStoreFactory) {
+	idempotencyStoreLock.Lock()
+	defer idempotencyStoreLock.Unlock()
+	idempotencyStores[name] = factory
+}
+
+func getIdempotencyStoreFactory(name string) IdempotencyStoreFactory {
+	idempotencyStoreLock.RLock()
+	defer idempotencyStoreLock.RUnlock()
+	return idempotencyStores[name]
+}
+
+type idempotentCall struct {
+	done chan struct{}
+	res  motan.Response
+}
+
+// IdempotencyProviderWrapper executes the requests with the same idempotency key at most once in the window,
+// the retries get the stored result. a retry arriving while the first call is running waits for it.
+// successful results and business exceptions are stored, other exceptions(e.g. timeout) are not, so the operation can be retried
+type IdempotencyProviderWrapper struct {
+	baseProviderWrapper
+	allMethods bool
+	methods    map[string]bool
+	window     time.Duration
+	store      IdempotencyStore
+	lock       sync.Mutex
+	inflight   map[string]*idempotentCall
+}
+
+// WrapWithIdempotency returns the provider itself if no method is configured as idempotent
+func WrapWithIdempotency(provider motan.Provider) motan.Provider {
+	url := provider.GetURL()
+	methods := motan.TrimSplit(url.GetParam(IdempotentMethodsKey, ""), ",")
+	w := &IdempotencyProviderWrapper{
+		baseProviderWrapper: baseProviderWrapper{provider: provider},
+		methods:             make(map[string]bool, len(methods)),
+		window:              url.GetTimeDuration(IdempotencyWindowKey, time.Millisecond, defaultIdempotencyWindow),
+		inflight:            make(map[string]*idempotentCall),
+	}
+	for _, m := range methods {
+		if m == "*" {
+			w.allMethods = true
+		} else if m != "" {
+			w.methods[m] = true
+		}
+	}
+	if !w.allMethods && len(w.methods) == 0 {
+		return provider
+	}
+	storeName := url.GetParam(IdempotencyStoreKey, IdempotencyStoreMemory)
+	factory := getIdempotencyStoreFactory(storeName)
+	if factory == nil {
+		vlog.Errorf("idempotency of provider %s ignored. store not found: %s", provider.GetPath(), storeName)
+		return provider
+	}
+	store, err := factory(url)
+	if err != nil {
+		vlog.Errorf("idempotency of provider %s ignored. create store %s fail: %v", provider.GetPath(), storeName, err)
+		return provider
+	}
+	w.store = store
+	vlog.Infof("idempotency enabled for provider %s, methods: %s, window: %v, store: %s", provider.GetPath(), url.GetParam(IdempotentMethodsKey, ""), w.window, storeName)
+	return w
+}
+
+func (w *IdempotencyProviderWrapper) Call(request motan.Request) motan.Response {
+	idk := request.GetAttachment(motan.IdempotencyKeyAttachment)
+	if idk == "" || !(w.allMethods || w.methods[request.GetMethod()] || w.methods[motan.FirstUpper(request.GetMethod())]) {
+		return w.provider.Call(request)
+	}
+	key := request.GetServiceName() + memoizeKeySep + request.GetMethod() + memoizeKeySep + idk
+	w.lock.Lock()
+	if call, ok := w.inflight[key]; ok {
+		w.lock.Unlock()
+		<-call.done
+		if call.res != nil {
+			return replayedResponse(request, call.res)
+		}
+		return w.Call(request)
+	}
+	// load the store while holding the inflight mark, so a concurrent retry can not execute the operation again
+	call := &idempotentCall{done: make(chan struct{})}
+	w.inflight[key] = call
+	w.lock.Unlock()
+	defer func() {
+		w.lock.Lock()
+		delete(w.inflight, key)
+		w.lock.Unlock()
+		close(call.done)
+	}()
+
+	stored, err := w.store.Load(key)
+	if err != nil {
+		// executing without the store may run the operation twice, so let the client retry later
+		vlog.Warningf("load idempotent result fail. req:%s, err:%v", motan.GetReqInfo(request), err)
+		return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 503, ErrMsg: "load idempotent result fail: " + err.Error(), ErrType: motan.ServiceException})
+	}
+	if stored != nil {
+		call.res = stored
+		return replayedResponse(request, stored)
+	}
+	res := w.provider.Call(request)
+	if res == nil || (res.GetException() != nil && res.GetException().ErrType != motan.BizException) {
+		return res
+	}
+	if err := w.store.Store(key, copyResult(res.GetRequestID(), res), w.window); err != nil {
+		vlog.Warningf("store idempotent result fail. req:%s, err:%v", motan.GetReqInfo(request), err)
+	}
+	call.res = res
+	return res
+}
+
+func replayedResponse(request motan.Request, stored motan.Response) motan.Response {
+	res := copyResult(request.GetRequestID(), stored)
+	res.SetAttachment(ReplayedAttachment, "true")
+	return res
+}
+
+// copyResult copy the response with its business exception
+func copyResult(requestID uint64, res motan.Response) motan.Response {
+	r := copyResponse(requestID, res).(*motan.MotanResponse)
+	if e := res.GetException(); e != nil {
+		r.Exception = &motan.Exception{ErrCode: e.ErrCode, ErrMsg: e.ErrMsg, ErrType: e.ErrType, Details: e.Details}
+	}
+	return r
+}
+
+type idempotentEntry struct {
+	res    motan.Response
+	expire time.Time
+}
+
+// MemoryIdempotencyStore is an IdempotencyStore of a single server
+type MemoryIdempotencyStore struct {
+	lock       sync.Mutex
+	maxEntries int
+	entries    map[string]*idempotentEntry
+}
+
+func NewMemoryIdempotencyStore(maxEntries int) *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{maxEntries: maxEntries, entries: make(map[string]*idempotentEntry)}
+}
+
+func (m *MemoryIdempotencyStore) Load(key string) (motan.Response, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	entry, ok := m.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	if time.Now().After(entry.expire) {
+		delete(m.entries, key)
+		return nil, nil
+	}
+	return entry.res, nil
+}
+
+func (m *MemoryIdempotencyStore) Store(key string, res motan.Response, window time.Duration) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.entries[key]; !ok && len(m.entries) >= m.maxEntries {
+		now := time.Now()
+		for k, entry := range m.entries {
+			if now.After(entry.expire) {
+				delete(m.entries, k)
+			}
+		}
+		if len(m.entries) >= m.maxEntries {
+			return fmt.Errorf("idempotency store is full, max entries: %d", m.maxEntries)
+		}
+	}
+	m.entries[key] = &idempotentEntry{res: res, expire: time.Now().Add(window)}
+	return nil
+}
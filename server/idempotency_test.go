@@ -0,0 +1,95 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+)
+
+type failIdempotencyStore struct{}
+
+func (f *failIdempotencyStore) Load(key string) (motan.Response, error) {
+	return nil, errors.New("store unavailable")
+}
+
+func (f *failIdempotencyStore) Store(key string, res motan.Response, window time.Duration) error {
+	return nil
+}
+
+func TestWrapWithIdempotency(t *testing.T) {
+	p := newTestProvider("test", nil)
+	assert.Equal(t, p, WrapWithIdempotency(p))
+	p = newTestProvider("test", map[string]string{IdempotentMethodsKey: "create", IdempotencyStoreKey: "unknown"})
+	assert.Equal(t, p, WrapWithIdempotency(p))
+}
+
+func TestIdempotencyProvider(t *testing.T) {
+	var calls int64
+	p := newTestProvider("idempotency", map[string]string{IdempotentMethodsKey: "create,fail", IdempotencyWindowKey: "100"})
+	p.callFunc = func(request motan.Request) motan.Response {
+		n := atomic.AddInt64(&calls, 1)
+		time.Sleep(10 * time.Millisecond)
+		if request.GetMethod() == "fail" {
+			return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 503, ErrMsg: "busy", ErrType: motan.ServiceException})
+		}
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: n}
+	}
+	w := WrapWithIdempotency(p)
+	_, ok := w.(*IdempotencyProviderWrapper)
+	assert.True(t, ok)
+
+	// concurrent retries execute once
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, int64(1), w.Call(newArgsTestRequest("idempotency", "create", map[string]string{motan.IdempotencyKeyAttachment: "k1"})).GetValue())
+		}()
+	}
+	wg.Wait()
+	res := w.Call(newArgsTestRequest("idempotency", "create", map[string]string{motan.IdempotencyKeyAttachment: "k1"}))
+	assert.Equal(t, int64(1), res.GetValue())
+	assert.Equal(t, "true", res.GetAttachment(ReplayedAttachment))
+	assert.Equal(t, int64(1), atomic.LoadInt64(&calls))
+
+	// other keys, requests without key and methods not configured are executed
+	assert.Equal(t, int64(2), w.Call(newArgsTestRequest("idempotency", "create", map[string]string{motan.IdempotencyKeyAttachment: "k2"})).GetValue())
+	assert.Equal(t, int64(3), w.Call(newTestRequest("idempotency", "create")).GetValue())
+	assert.Equal(t, int64(4), w.Call(newArgsTestRequest("idempotency", "update", map[string]string{motan.IdempotencyKeyAttachment: "k1"})).GetValue())
+
+	// the exceptions other than business exception are not stored
+	w.Call(newArgsTestRequest("idempotency", "fail", map[string]string{motan.IdempotencyKeyAttachment: "k3"}))
+	w.Call(newArgsTestRequest("idempotency", "fail", map[string]string{motan.IdempotencyKeyAttachment: "k3"}))
+	assert.Equal(t, int64(6), atomic.LoadInt64(&calls))
+
+	// expired after the window
+	time.Sleep(150 * time.Millisecond)
+	assert.Equal(t, int64(7), w.Call(newArgsTestRequest("idempotency", "create", map[string]string{motan.IdempotencyKeyAttachment: "k1"})).GetValue())
+
+	RegisterIdempotencyStore("fail", func(url *motan.URL) (IdempotencyStore, error) {
+		return &failIdempotencyStore{}, nil
+	})
+	w = WrapWithIdempotency(newTestProvider("idempotency", map[string]string{IdempotentMethodsKey: "*", IdempotencyStoreKey: "fail"}))
+	res = w.Call(newArgsTestRequest("idempotency", "create", map[string]string{motan.IdempotencyKeyAttachment: "k1"}))
+	assert.NotNil(t, res.GetException())
+	assert.Equal(t, 503, res.GetException().ErrCode)
+}
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	store := NewMemoryIdempotencyStore(1)
+	assert.Nil(t, store.Store("a", &motan.MotanResponse{Value: 1}, 20*time.Millisecond))
+	assert.NotNil(t, store.Store("b", &motan.MotanResponse{Value: 2}, time.Second))
+	res, err := store.Load("a")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, res.GetValue())
+	time.Sleep(30 * time.Millisecond)
+	assert.Nil(t, store.Store("b", &motan.MotanResponse{Value: 2}, time.Second))
+	res, _ = store.Load("a")
+	assert.Nil(t, res)
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"reflect"
+	"sync"
+
+	motan "github.com/weibocom/motan-go/core"
+)
+
+// MethodInvocation is an intercepted call of a method of the go providers, see RegisterMethodInterceptor
+type MethodInvocation struct {
+	Request motan.Request
+	Service string
+	Method  string
+	// the deserialized arguments of the method, they can be modified or replaced by the same types before the method is called
+	Arguments []interface{}
+	// the result of the method, it can be replaced after the method is called
+	Result interface{}
+	// the exception of the call, nil if the call succeeds. it can be set or cleared after the method is called
+	Exception *motan.Exception
+}
+
+// MethodInterceptor is the hooks around the calls of a method, any of them can be nil
+type MethodInterceptor struct {
+	// Before is called before the method with the deserialized arguments. the method is not called if an error is
+	// returned, and the error is responded as a service exception with code 400, e.g. the validation fails
+	Before func(invocation *MethodInvocation) error
+	// After is called after the method with the result, it is also called if the method is not called by Before
+	After func(invocation *MethodInvocation)
+}
+
+// AllMethods intercepts all methods of the service
+const AllMethods = "*"
+
+var (
+	interceptorLock sync.RWMutex
+	interceptors    = make(map[string][]*MethodInterceptor) // service|method -> interceptors
+)
+
+func interceptorKey(service string, method string) string {
+	if method != AllMethods {
+		method = motan.FirstUpper(method)
+	}
+	return service + "|" + method
+}
+
+// RegisterMethodInterceptor adds an interceptor for the method of the go providers(the service set by SetService) of
+// the service path, the method AllMethods intercepts all methods of the service. the interceptors of a method are
+// called after the URL filters, the Before hooks are called in the order of the registration and the After hooks are
+// called in the reverse order. the interceptors of AllMethods are called before the interceptors of the method
+func RegisterMethodInterceptor(service string, method string, interceptor *MethodInterceptor) {
+	if interceptor == nil {
+		return
+	}
+	interceptorLock.Lock()
+	defer interceptorLock.Unlock()
+	key := interceptorKey(service, method)
+	interceptors[key] = append(interceptors[key], interceptor)
+}
+
+// ClearMethodInterceptors removes all interceptors of the service
+func ClearMethodInterceptors(service string) {
+	interceptorLock.Lock()
+	defer interceptorLock.Unlock()
+	prefix := service + "|"
+	for key := range interceptors {
+		if len(key) > len(prefix) && key[:len(prefix)] == prefix {
+			delete(interceptors, key)
+		}
+	}
+}
+
+func getMethodInterceptors(service string, method string) []*MethodInterceptor {
+	interceptorLock.RLock()
+	defer interceptorLock.RUnlock()
+	all := interceptors[interceptorKey(service, AllMethods)]
+	methods := interceptors[interceptorKey(service, method)]
+	if len(all) == 0 {
+		return methods
+	}
+	if len(methods) == 0 {
+		return all
+	}
+	result := make([]*MethodInterceptor, 0, len(all)+len(methods))
+	return append(append(result, all...), methods...)
+}
+
+// argumentTyper is implemented by the providers which can tell the argument types of the methods, e.g. provider.DefaultProvider
+type argumentTyper interface {
+	GetArgumentTypes(method string) ([]interface{}, bool)
+}
+
+// InterceptorProviderWrapper calls the method interceptors registered by RegisterMethodInterceptor
+type InterceptorProviderWrapper struct {
+	baseProviderWrapper
+	typer argumentTyper
+}
+
+// WrapWithInterceptors returns the provider itself if the argument types of its methods are unknown, the interceptors
+// are looked up for each call, so the interceptors registered after the export take effect too
+func WrapWithInterceptors(provider motan.Provider) motan.Provider {
+	typer, ok := provider.(argumentTyper)
+	if !ok {
+		return provider
+	}
+	return &InterceptorProviderWrapper{baseProviderWrapper: baseProviderWrapper{provider: provider}, typer: typer}
+}
+
+func (i *InterceptorProviderWrapper) Call(request motan.Request) motan.Response {
+	hooks := getMethodInterceptors(i.GetPath(), request.GetMethod())
+	// the streams are served by the provider directly
+	if len(hooks) == 0 || motan.GetStream(request) != nil {
+		return i.provider.Call(request)
+	}
+	types, ok := i.typer.GetArgumentTypes(request.GetMethod())
+	if !ok {
+		return i.provider.Call(request)
+	}
+	if len(types) > 0 {
+		if err := request.ProcessDeserializable(types); err != nil {
+			return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 500, ErrMsg: "deserialize arguments fail." + err.Error(), ErrType: motan.ServiceException})
+		}
+	}
+	invocation := &MethodInvocation{Request: request, Service: i.GetPath(), Method: request.GetMethod(), Arguments: request.GetArguments()}
+	called := 0
+	for _, hook := range hooks {
+		called++
+		if hook.Before == nil {
+			continue
+		}
+		if err := hook.Before(invocation); err != nil {
+			invocation.Exception = &motan.Exception{ErrCode: 400, ErrMsg: err.Error(), ErrType: motan.ServiceException}
+			break
+		}
+	}
+	var res motan.Response
+	if invocation.Exception == nil {
+		if r, ok := request.(interface{ SetArguments([]interface{}) }); ok {
+			r.SetArguments(invocation.Arguments)
+		}
+		res = i.provider.Call(request)
+		invocation.Exception = res.GetException()
+		invocation.Result = res.GetValue()
+		// the results of the reflection based providers are reflect.Value
+		if rv, ok := invocation.Result.(reflect.Value); ok && rv.IsValid() && rv.CanInterface() {
+			invocation.Result = rv.Interface()
+		}
+	}
+	for j := called - 1; j >= 0; j-- {
+		if hooks[j].After != nil {
+			hooks[j].After(invocation)
+		}
+	}
+	mres := &motan.MotanResponse{RequestID: request.GetRequestID(), Value: invocation.Result, Exception: invocation.Exception}
+	if res != nil {
+		mres.ProcessTime = res.GetProcessTime()
+		mres.Attachment = res.GetAttachments()
+		mres.RPCContext = res.GetRPCContext(false)
+	}
+	return mres
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/provider"
+	"github.com/weibocom/motan-go/serialize"
+)
+
+type interceptedService struct{}
+
+func (s *interceptedService) Hello(name string) string {
+	return "hello " + name
+}
+
+func (s *interceptedService) Join(a string, b string) string {
+	return a + "," + b
+}
+
+// newSerializedTestRequest returns the test request with the arguments serialized like the requests received by the server
+func newSerializedTestRequest(service string, method string, args ...interface{}) *motan.MotanRequest {
+	serialization := &serialize.SimpleSerialization{}
+	body, _ := serialization.SerializeMulti(args)
+	request := newTestRequest(service, method)
+	request.Arguments = []interface{}{&motan.DeserializableValue{Serialization: serialization, Body: body}}
+	return request
+}
+
+func TestMethodInterceptor(t *testing.T) {
+	defer ClearMethodInterceptors("intercepted")
+	p := &provider.DefaultProvider{}
+	p.SetURL(&motan.URL{Path: "intercepted", Group: "test", Parameters: map[string]string{}})
+	p.SetService(&interceptedService{})
+	p.Initialize()
+	wrapped := WrapWithInterceptors(p)
+	assert.Equal(t, p, wrapped.(providerWrapper).unwrap())
+	// no interceptors wrapped for the providers without argument types
+	other := newTestProvider("test", nil)
+	assert.Equal(t, other, WrapWithInterceptors(other))
+
+	res := wrapped.Call(newSerializedTestRequest("intercepted", "hello", "motan"))
+	assert.Nil(t, res.GetException())
+
+	var calls []string
+	RegisterMethodInterceptor("intercepted", AllMethods, &MethodInterceptor{
+		Before: func(invocation *MethodInvocation) error {
+			calls = append(calls, "all before "+invocation.Method)
+			return nil
+		},
+		After: func(invocation *MethodInvocation) {
+			calls = append(calls, "all after "+invocation.Method)
+		},
+	})
+	RegisterMethodInterceptor("intercepted", "hello", &MethodInterceptor{
+		Before: func(invocation *MethodInvocation) error {
+			calls = append(calls, "hello before")
+			name := invocation.Arguments[0].(string)
+			if name == "" {
+				return errors.New("name is empty")
+			}
+			invocation.Arguments[0] = strings.ToUpper(name)
+			return nil
+		},
+		After: func(invocation *MethodInvocation) {
+			calls = append(calls, "hello after")
+			if invocation.Exception == nil {
+				invocation.Result = invocation.Result.(string) + "!"
+			}
+		},
+	})
+
+	// the arguments and the result are modified
+	res = wrapped.Call(newSerializedTestRequest("intercepted", "hello", "motan"))
+	assert.Nil(t, res.GetException())
+	assert.Equal(t, "hello MOTAN!", res.GetValue())
+	assert.Equal(t, []string{"all before hello", "hello before", "hello after", "all after hello"}, calls)
+
+	// the method is not called if it is rejected, all the After hooks of the called Before hooks are called
+	calls = nil
+	res = wrapped.Call(newSerializedTestRequest("intercepted", "Hello", ""))
+	if assert.NotNil(t, res.GetException()) {
+		assert.Equal(t, 400, res.GetException().ErrCode)
+		assert.Equal(t, "name is empty", res.GetException().ErrMsg)
+	}
+	assert.Nil(t, res.GetValue())
+	assert.Equal(t, []string{"all before Hello", "hello before", "hello after", "all after Hello"}, calls)
+
+	calls = nil
+	res = wrapped.Call(newSerializedTestRequest("intercepted", "join", "a", "b"))
+	assert.Nil(t, res.GetException())
+	assert.Equal(t, "a,b", res.GetValue())
+	assert.Equal(t, []string{"all before join", "all after join"}, calls)
+
+	// the unknown methods are responded by the provider
+	calls = nil
+	res = wrapped.Call(newSerializedTestRequest("intercepted", "unknown"))
+	assert.NotNil(t, res.GetException())
+	assert.Nil(t, calls)
+
+	ClearMethodInterceptors("intercepted")
+	assert.Equal(t, 0, len(getMethodInterceptors("intercepted", "hello")))
+}
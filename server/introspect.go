@@ -0,0 +1,71 @@
+package server
+
+import (
+	"sort"
+
+	motan "github.com/weibocom/motan-go/core"
+)
+
+// ServiceInfo is the status of a service served by the running servers
+type ServiceInfo struct {
+	Path      string `json:"path"`
+	Group     string `json:"group"`
+	Version   string `json:"version"`
+	Protocol  string `json:"protocol"`
+	Port      int    `json:"port"`
+	Available bool   `json:"available"`
+	// the registration states of the exported service, see RegisterPolicyKey
+	Registries []RegistryState `json:"registries,omitempty"`
+}
+
+// providersLister is implemented by the message handlers which can list their providers, e.g. DefaultMessageHandler
+type providersLister interface {
+	GetProviders() []motan.Provider
+}
+
+// GetServices returns the services of the message handler, a service is available if its exporter is available,
+// the services without exporter use the availability of the provider
+func GetServices(handler motan.MessageHandler) []ServiceInfo {
+	lister, ok := handler.(providersLister)
+	if !ok {
+		return nil
+	}
+	exporters, _ := runningSnapshot()
+	exported := make(map[motan.Provider]*DefaultExporter, len(exporters))
+	for _, e := range exporters {
+		exported[e.GetProvider()] = e
+	}
+	providers := lister.GetProviders()
+	services := make([]ServiceInfo, 0, len(providers))
+	for _, p := range providers {
+		url := p.GetURL()
+		info := ServiceInfo{Path: p.GetPath(), Group: url.Group, Version: url.GetParam(motan.VersionKey, ""), Protocol: url.Protocol, Port: url.Port}
+		if e, ok := exported[p]; ok {
+			info.Available = e.IsAvailable()
+			info.Registries = e.GetRegistryStates()
+		} else {
+			info.Available = p.IsAvailable()
+		}
+		services = append(services, info)
+	}
+	return services
+}
+
+// GetExportedServices returns the services of all the running motan servers, ordered by port, path and group
+func GetExportedServices() []ServiceInfo {
+	_, servers := runningSnapshot()
+	var services []ServiceInfo
+	for _, s := range servers {
+		services = append(services, GetServices(s.GetMessageHandler())...)
+	}
+	sort.SliceStable(services, func(i, j int) bool {
+		if services[i].Port != services[j].Port {
+			return services[i].Port < services[j].Port
+		}
+		if services[i].Path != services[j].Path {
+			return services[i].Path < services[j].Path
+		}
+		return services[i].Group < services[j].Group
+	})
+	return services
+}
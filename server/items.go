@@ -0,0 +1,155 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// MaxResponseItemsKey is the provider url parameter of the max item count of list responses,
+// the value is a json map of method name to limit, e.g. {"list":{"max":1000,"policy":"truncate"},"query":{"max":100}}.
+// the policy can be:
+//   - reject: return an exception response, it is the default policy
+//   - truncate: keep the first max items, the original count is in response attachment TruncatedAttachment
+const MaxResponseItemsKey = "maxResponseItems"
+
+// TruncatedAttachment is the response attachment of the original item count when the response is truncated
+const TruncatedAttachment = "M_trunc"
+
+const (
+	ItemsPolicyReject   = "reject"
+	ItemsPolicyTruncate = "truncate"
+)
+
+// ItemExtractor counts and truncates the items of response values
+type ItemExtractor interface {
+	// Count returns the item count of value, ok is false if the value is not a list
+	Count(value interface{}) (count int, ok bool)
+	// Truncate returns the value with the first n items, ok is false if the value can not be truncated
+	Truncate(value interface{}, n int) (truncated interface{}, ok bool)
+}
+
+var (
+	itemExtractors    = make(map[string]ItemExtractor)
+	itemExtractorLock sync.RWMutex
+)
+
+// RegisterItemExtractor register the extractor of a service(path), it is used for values the default extractor can not count,
+// e.g. a struct with a list field. the default extractor counts slices, arrays and maps, and truncates slices
+func RegisterItemExtractor(service string, extractor ItemExtractor) {
+	itemExtractorLock.Lock()
+	defer itemExtractorLock.Unlock()
+	if extractor == nil {
+		delete(itemExtractors, service)
+		return
+	}
+	itemExtractors[service] = extractor
+}
+
+func getItemExtractor(service string) ItemExtractor {
+	itemExtractorLock.RLock()
+	defer itemExtractorLock.RUnlock()
+	if e, ok := itemExtractors[service]; ok {
+		return e
+	}
+	return reflectItemExtractor{}
+}
+
+type reflectItemExtractor struct{}
+
+func (reflectItemExtractor) Count(value interface{}) (int, bool) {
+	if _, ok := value.([]byte); ok {
+		// raw bytes are not a list
+		return 0, false
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return v.Len(), true
+	}
+	return 0, false
+}
+
+func (reflectItemExtractor) Truncate(value interface{}, n int) (interface{}, bool) {
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice {
+		return nil, false
+	}
+	return v.Slice(0, n).Interface(), true
+}
+
+type itemLimit struct {
+	Max    int    `json:"max"`
+	Policy string `json:"policy"`
+}
+
+// responseItemLimits is the resolved item limit table of a provider
+type responseItemLimits map[string]*itemLimit
+
+func parseResponseItemLimits(url *motan.URL) (responseItemLimits, error) {
+	value := url.GetParam(MaxResponseItemsKey, "")
+	if value == "" {
+		return nil, nil
+	}
+	var limits responseItemLimits
+	if err := json.Unmarshal([]byte(value), &limits); err != nil {
+		return nil, fmt.Errorf("illegal %s: %s, err: %v", MaxResponseItemsKey, value, err)
+	}
+	for method, limit := range limits {
+		if method == "" {
+			return nil, errors.New("illegal " + MaxResponseItemsKey + ": empty method name")
+		}
+		if limit == nil || limit.Max <= 0 {
+			return nil, fmt.Errorf("illegal %s: max items of method %s must be positive", MaxResponseItemsKey, method)
+		}
+		switch limit.Policy {
+		case "":
+			limit.Policy = ItemsPolicyReject
+		case ItemsPolicyReject, ItemsPolicyTruncate:
+		default:
+			return nil, fmt.Errorf("illegal %s: unknown policy %s of method %s", MaxResponseItemsKey, limit.Policy, method)
+		}
+	}
+	return limits, nil
+}
+
+func (r responseItemLimits) get(method string) *itemLimit {
+	if r == nil {
+		return nil
+	}
+	if l, ok := r[method]; ok {
+		return l
+	}
+	return r[motan.FirstUpper(method)]
+}
+
+// apply enforces the item limit of the method on a successful response
+func (r responseItemLimits) apply(request motan.Request, response motan.Response) motan.Response {
+	limit := r.get(request.GetMethod())
+	if limit == nil || response == nil || response.GetException() != nil || response.GetValue() == nil {
+		return response
+	}
+	extractor := getItemExtractor(request.GetServiceName())
+	count, ok := extractor.Count(response.GetValue())
+	if !ok || count <= limit.Max {
+		return response
+	}
+	if limit.Policy == ItemsPolicyTruncate {
+		if truncated, ok := extractor.Truncate(response.GetValue(), limit.Max); ok {
+			vlog.Warningf("response items exceed limit, truncated. req:%s, count:%d, max:%d", motan.GetReqInfo(request), count, limit.Max)
+			res := copyResponseWithValue(response, truncated)
+			res.SetAttachment(TruncatedAttachment, strconv.Itoa(count))
+			return res
+		}
+	}
+	vlog.Warningf("response items exceed limit, rejected. req:%s, count:%d, max:%d", motan.GetReqInfo(request), count, limit.Max)
+	return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 500,
+		ErrMsg:  fmt.Sprintf("response items exceed limit. method: %s, count: %d, max: %d", request.GetMethod(), count, limit.Max),
+		ErrType: motan.ServiceException})
+}
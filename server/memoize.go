@@ -0,0 +1,189 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// MemoizeMethodsKey is the provider url parameter of result memoization, the value is a json map of method name to ttl config in ms.
+// e.g. {"hello":{"softTTL":1000,"hardTTL":10000}}
+// a cached result younger than softTTL is returned directly, a result between softTTL and hardTTL is returned and refreshed in background,
+// and a result older than hardTTL is discarded and the call blocks until the provider returns.
+const MemoizeMethodsKey = "memoizeMethods"
+
+// MemoizeMaxEntriesKey limits the cached results of a provider, new results will not be cached when the limit is reached
+const MemoizeMaxEntriesKey = "memoizeMaxEntries"
+
+const defaultMemoizeMaxEntries = 10000
+
+type memoizeConfig struct {
+	SoftTTL int64 `json:"softTTL"`
+	HardTTL int64 `json:"hardTTL"`
+	soft    time.Duration
+	hard    time.Duration
+}
+
+type memoizeEntry struct {
+	method     string
+	res        motan.Response
+	updateTime time.Time
+	refreshing bool
+}
+
+// MemoizeProviderWrapper serves cached results of the configured methods with stale-while-revalidate
+type MemoizeProviderWrapper struct {
+	baseProviderWrapper
+	configs    map[string]*memoizeConfig
+	maxEntries int
+	lock       sync.Mutex
+	entries    map[string]*memoizeEntry
+}
+
+func parseMemoizeConfigs(url *motan.URL) (map[string]*memoizeConfig, error) {
+	value := url.GetParam(MemoizeMethodsKey, "")
+	if value == "" {
+		return nil, nil
+	}
+	var configs map[string]*memoizeConfig
+	if err := json.Unmarshal([]byte(value), &configs); err != nil {
+		return nil, fmt.Errorf("illegal %s: %s, err: %v", MemoizeMethodsKey, value, err)
+	}
+	for method, c := range configs {
+		if method == "" {
+			return nil, errors.New("illegal " + MemoizeMethodsKey + ": empty method name")
+		}
+		if c == nil || c.SoftTTL <= 0 || c.HardTTL < c.SoftTTL {
+			return nil, fmt.Errorf("illegal %s: method %s must have 0 < softTTL <= hardTTL", MemoizeMethodsKey, method)
+		}
+		c.soft = time.Duration(c.SoftTTL) * time.Millisecond
+		c.hard = time.Duration(c.HardTTL) * time.Millisecond
+	}
+	return configs, nil
+}
+
+// WrapWithMemoize returns the provider itself if no method is configured for memoization
+func WrapWithMemoize(provider motan.Provider) motan.Provider {
+	configs, err := parseMemoizeConfigs(provider.GetURL())
+	if err != nil {
+		vlog.Warningf("memoize of provider %s ignored. err: %v", provider.GetPath(), err)
+		return provider
+	}
+	if len(configs) == 0 {
+		return provider
+	}
+	vlog.Infof("memoize enabled for provider %s, methods: %s", provider.GetPath(), provider.GetURL().GetParam(MemoizeMethodsKey, ""))
+	return &MemoizeProviderWrapper{
+		baseProviderWrapper: baseProviderWrapper{provider: provider},
+		configs:             configs,
+		maxEntries:          int(provider.GetURL().GetPositiveIntValue(MemoizeMaxEntriesKey, defaultMemoizeMaxEntries)),
+		entries:             make(map[string]*memoizeEntry),
+	}
+}
+
+func (m *MemoizeProviderWrapper) Call(request motan.Request) motan.Response {
+	config := m.getConfig(request.GetMethod())
+	if config == nil {
+		return m.provider.Call(request)
+	}
+	key := memoizeKey(request)
+	now := time.Now()
+	m.lock.Lock()
+	entry := m.entries[key]
+	if entry != nil {
+		age := now.Sub(entry.updateTime)
+		if age < config.hard {
+			if age >= config.soft && !entry.refreshing {
+				entry.refreshing = true
+				go m.refresh(key, request)
+			}
+			res := entry.res
+			m.lock.Unlock()
+			return copyResponse(request.GetRequestID(), res)
+		}
+	}
+	m.lock.Unlock()
+	res := m.provider.Call(request)
+	m.store(key, request.GetMethod(), res)
+	return res
+}
+
+func (m *MemoizeProviderWrapper) getConfig(method string) *memoizeConfig {
+	if c, ok := m.configs[method]; ok {
+		return c
+	}
+	return m.configs[motan.FirstUpper(method)]
+}
+
+func (m *MemoizeProviderWrapper) refresh(key string, request motan.Request) {
+	defer motan.HandleRequestPanic(request, func() {
+		vlog.Errorf("memoize refresh panic. req:%s", motan.GetReqInfo(request))
+		m.lock.Lock()
+		if entry := m.entries[key]; entry != nil {
+			entry.refreshing = false
+		}
+		m.lock.Unlock()
+	})
+	m.store(key, request.GetMethod(), m.provider.Call(request))
+}
+
+// store only keeps successful results, a failed refresh keeps the stale result until hardTTL
+func (m *MemoizeProviderWrapper) store(key string, method string, res motan.Response) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	entry := m.entries[key]
+	if res == nil || res.GetException() != nil {
+		if entry != nil {
+			entry.refreshing = false
+		}
+		return
+	}
+	if entry == nil {
+		if len(m.entries) >= m.maxEntries {
+			m.evictExpired()
+			if len(m.entries) >= m.maxEntries {
+				return
+			}
+		}
+		entry = &memoizeEntry{method: method}
+		m.entries[key] = entry
+	}
+	entry.res = copyResponse(res.GetRequestID(), res)
+	entry.updateTime = time.Now()
+	entry.refreshing = false
+}
+
+func (m *MemoizeProviderWrapper) evictExpired() {
+	now := time.Now()
+	for key, entry := range m.entries {
+		if c := m.getConfig(entry.method); c == nil || now.Sub(entry.updateTime) >= c.hard {
+			delete(m.entries, key)
+		}
+	}
+}
+
+const memoizeKeySep = "\n"
+
+func memoizeKey(request motan.Request) string {
+	args := request.GetArguments()
+	if len(args) == 1 {
+		if dv, ok := args[0].(*motan.DeserializableValue); ok {
+			return request.GetMethod() + memoizeKeySep + request.GetMethodDesc() + memoizeKeySep + string(dv.Body)
+		}
+	}
+	return request.GetMethod() + memoizeKeySep + request.GetMethodDesc() + memoizeKeySep + fmt.Sprintf("%v", args)
+}
+
+// copyResponse avoids sharing the response between requests, the rpc context of the response is set by each request
+func copyResponse(requestID uint64, res motan.Response) motan.Response {
+	r := &motan.MotanResponse{RequestID: requestID, Value: res.GetValue(), ProcessTime: res.GetProcessTime()}
+	if attachments := res.GetAttachments(); attachments != nil {
+		r.Attachment = attachments.Copy()
+	}
+	return r
+}
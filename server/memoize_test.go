@@ -0,0 +1,60 @@
+package server
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+)
+
+func TestParseMemoizeConfigs(t *testing.T) {
+	for _, value := range []string{"{", `{"hello":{"softTTL":0,"hardTTL":10}}`, `{"hello":{"softTTL":10,"hardTTL":5}}`, `{"":{"softTTL":1,"hardTTL":5}}`} {
+		p := newTestProvider("test", map[string]string{MemoizeMethodsKey: value})
+		_, err := parseMemoizeConfigs(p.GetURL())
+		assert.NotNil(t, err, value)
+		assert.Equal(t, p, WrapWithMemoize(p))
+	}
+	p := newTestProvider("test", nil)
+	assert.Equal(t, p, WrapWithMemoize(p))
+}
+
+func TestMemoizeProvider(t *testing.T) {
+	var calls int64
+	p := newTestProvider("memoize", map[string]string{MemoizeMethodsKey: `{"hello":{"softTTL":50,"hardTTL":200}}`})
+	p.callFunc = func(request motan.Request) motan.Response {
+		n := atomic.AddInt64(&calls, 1)
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: n}
+	}
+	m := WrapWithMemoize(p)
+	_, ok := m.(*MemoizeProviderWrapper)
+	assert.True(t, ok)
+
+	assert.Equal(t, int64(1), m.Call(newArgsTestRequest("memoize", "hello", nil, "a")).GetValue())
+	assert.Equal(t, int64(1), m.Call(newArgsTestRequest("memoize", "hello", nil, "a")).GetValue())
+	// different arguments are cached separately
+	assert.Equal(t, int64(2), m.Call(newArgsTestRequest("memoize", "hello", nil, "b")).GetValue())
+	// method not configured
+	assert.Equal(t, int64(3), m.Call(newArgsTestRequest("memoize", "world", nil, "a")).GetValue())
+	assert.Equal(t, int64(4), m.Call(newArgsTestRequest("memoize", "world", nil, "a")).GetValue())
+
+	// stale result returned and refreshed in background
+	time.Sleep(80 * time.Millisecond)
+	assert.Equal(t, int64(1), m.Call(newArgsTestRequest("memoize", "hello", nil, "a")).GetValue())
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int64(5), atomic.LoadInt64(&calls))
+	assert.Equal(t, int64(5), m.Call(newArgsTestRequest("memoize", "hello", nil, "a")).GetValue())
+
+	// expired result is not returned
+	time.Sleep(250 * time.Millisecond)
+	assert.Equal(t, int64(6), m.Call(newArgsTestRequest("memoize", "hello", nil, "a")).GetValue())
+
+	// a copy is returned for each request
+	r := newArgsTestRequest("memoize", "hello", nil, "a")
+	r.RequestID = 100
+	res := m.Call(r)
+	assert.Equal(t, uint64(100), res.GetRequestID())
+	res.SetAttachment("k", "v")
+	assert.Equal(t, "", m.Call(newArgsTestRequest("memoize", "hello", nil, "a")).GetAttachment("k"))
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"runtime"
+	"sort"
+	"strconv"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/endpoint"
+	"github.com/weibocom/motan-go/serialize"
+)
+
+// MetaServicePath is the reserved service answered by every motan server which is not a proxy, it responds the
+// metadata of the services of the server and the build info of the process, see MetaInfo
+const MetaServicePath = "motan.runtime.MetaService"
+
+// MetaMethodGetMeta is the method of MetaServicePath, it responds the json of MetaInfo as a string
+const MetaMethodGetMeta = "getMeta"
+
+// MetaServiceKey is the server url parameter to disable MetaServicePath, default true
+const MetaServiceKey = "metaService"
+
+// FrameworkVersion is the version of motan-go reported by the meta service
+var FrameworkVersion string
+
+// the build info of the application reported by the meta service, they can be set by the linker, e.g.
+// -ldflags "-X github.com/weibocom/motan-go/server.BuildVersion=1.2.0"
+var (
+	BuildVersion string
+	BuildCommit  string
+	BuildTime    string
+)
+
+// MetaInfo is the metadata responded by MetaServicePath
+type MetaInfo struct {
+	Services []MetaService `json:"services"`
+	Build    BuildInfo     `json:"build"`
+}
+
+// MetaService is the metadata of a service served by the server
+type MetaService struct {
+	ServiceInfo
+	// the methods of the providers with a known method set, empty if the methods are unknown
+	Methods       []string `json:"methods,omitempty"`
+	Serialization string   `json:"serialization,omitempty"`
+	Filters       []string `json:"filters,omitempty"`
+}
+
+// BuildInfo is the build info of the process of the server
+type BuildInfo struct {
+	FrameworkVersion string `json:"frameworkVersion,omitempty"`
+	Version          string `json:"version,omitempty"`
+	Commit           string `json:"commit,omitempty"`
+	BuildTime        string `json:"buildTime,omitempty"`
+	GoVersion        string `json:"goVersion"`
+	Platform         string `json:"platform"`
+}
+
+// GetMetaInfo returns the metadata of the services of the message handler
+func GetMetaInfo(handler motan.MessageHandler) *MetaInfo {
+	info := &MetaInfo{Services: make([]MetaService, 0), Build: getBuildInfo()}
+	lister, ok := handler.(providersLister)
+	if !ok {
+		return info
+	}
+	// GetServices lists the services in the order of the providers
+	providers := lister.GetProviders()
+	services := GetServices(handler)
+	for i, s := range services {
+		if i >= len(providers) {
+			break
+		}
+		url := providers[i].GetURL()
+		service := MetaService{ServiceInfo: s, Serialization: url.GetParam(motan.SerializationKey, "")}
+		if mp := knownMethods(providers[i]); mp != nil {
+			service.Methods = append(service.Methods, mp.GetMethodNames()...)
+			sort.Strings(service.Methods)
+		}
+		for _, f := range motan.TrimSplit(url.GetParam(motan.FilterKey, ""), ",") {
+			if f != "" {
+				service.Filters = append(service.Filters, f)
+			}
+		}
+		info.Services = append(info.Services, service)
+	}
+	sort.SliceStable(info.Services, func(i, j int) bool {
+		if info.Services[i].Path != info.Services[j].Path {
+			return info.Services[i].Path < info.Services[j].Path
+		}
+		return info.Services[i].Group < info.Services[j].Group
+	})
+	return info
+}
+
+func getBuildInfo() BuildInfo {
+	return BuildInfo{
+		FrameworkVersion: FrameworkVersion,
+		Version:          BuildVersion,
+		Commit:           BuildCommit,
+		BuildTime:        BuildTime,
+		GoVersion:        runtime.Version(),
+		Platform:         runtime.GOOS + "/" + runtime.GOARCH,
+	}
+}
+
+// callMetaService responds the requests of MetaServicePath
+func (m *MotanServer) callMetaService(request motan.Request) motan.Response {
+	if request.GetMethod() != MetaMethodGetMeta {
+		return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 404,
+			ErrMsg:  "unknown method of " + MetaServicePath + ": " + request.GetMethod(),
+			ErrType: motan.ServiceException})
+	}
+	b, err := json.Marshal(GetMetaInfo(m.handler))
+	if err != nil {
+		return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 500, ErrMsg: "marshal meta info fail: " + err.Error(), ErrType: motan.ServiceException})
+	}
+	return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: string(b)}
+}
+
+// QueryMetaInfo calls MetaServicePath of the motan server of the address, e.g. 127.0.0.1:8002
+func QueryMetaInfo(address string, timeout time.Duration) (*MetaInfo, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, errors.New("illegal port of address " + address)
+	}
+	url := &motan.URL{Protocol: endpoint.Motan2, Host: host, Port: port, Path: MetaServicePath, Parameters: map[string]string{}}
+	url.PutParam(motan.TimeOutKey, strconv.FormatInt(int64(timeout/time.Millisecond), 10))
+	url.PutParam(motan.ClientConnectionKey, "1")
+	ep := &endpoint.MotanEndpoint{}
+	ep.SetURL(url)
+	ep.SetSerialization(&serialize.SimpleSerialization{})
+	ep.Initialize()
+	defer ep.Destroy()
+	var reply string
+	request := &motan.MotanRequest{RequestID: endpoint.GenerateRequestID(), ServiceName: MetaServicePath, Method: MetaMethodGetMeta, Attachment: motan.NewStringMap(0)}
+	request.GetRPCContext(true).Reply = &reply
+	res := ep.Call(request)
+	if ex := res.GetException(); ex != nil {
+		return nil, errors.New(ex.ErrMsg)
+	}
+	info := &MetaInfo{}
+	if err = json.Unmarshal([]byte(reply), info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
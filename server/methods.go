@@ -0,0 +1,161 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// MaxMethodsKey is the provider url parameter of max methods a provider can expose
+const MaxMethodsKey = "maxMethods"
+
+const defaultMaxMethods = 256
+
+// methodNamesProvider is implemented by the providers which expose a known method set, e.g. reflection based providers
+type methodNamesProvider interface {
+	GetMethodNames() []string
+}
+
+// methodOwner is implemented by the providers which can tell whether they serve a method without listing the methods
+type methodOwner interface {
+	HasMethod(name string) bool
+}
+
+// providerWrapper is implemented by the providers wrapping another provider
+type providerWrapper interface {
+	unwrap() motan.Provider
+}
+
+// baseProviderWrapper delegates the methods of motan.Provider except Call to the wrapped provider, the wrappers embed it
+// and override Call, and the other methods only if they change the behavior, e.g. IsAvailable
+type baseProviderWrapper struct {
+	provider motan.Provider
+}
+
+func (b *baseProviderWrapper) SetService(s interface{}) {
+	b.provider.SetService(s)
+}
+
+func (b *baseProviderWrapper) GetURL() *motan.URL {
+	return b.provider.GetURL()
+}
+
+func (b *baseProviderWrapper) SetURL(url *motan.URL) {
+	b.provider.SetURL(url)
+}
+
+func (b *baseProviderWrapper) GetPath() string {
+	return b.provider.GetPath()
+}
+
+func (b *baseProviderWrapper) IsAvailable() bool {
+	return b.provider.IsAvailable()
+}
+
+func (b *baseProviderWrapper) Destroy() {
+	b.provider.Destroy()
+}
+
+func (b *baseProviderWrapper) unwrap() motan.Provider {
+	return b.provider
+}
+
+// checkMaxMethods rejects the provider which exposes more methods than the limit,
+// providers without a known method set are not checked
+func checkMaxMethods(p motan.Provider) error {
+	max := p.GetURL().GetPositiveIntValue(MaxMethodsKey, defaultMaxMethods)
+	if mp := knownMethods(p); mp != nil {
+		if count := int64(len(mp.GetMethodNames())); count > max {
+			return fmt.Errorf("provider %s exposes %d methods, exceeds the max methods %d by %d", p.GetPath(), count, max, count-max)
+		}
+	}
+	return nil
+}
+
+// knownMethods returns the provider itself or the wrapped provider which exposes a known method set, nil if the method set is unknown
+func knownMethods(p motan.Provider) methodNamesProvider {
+	for p != nil {
+		if mp, ok := p.(methodNamesProvider); ok {
+			return mp
+		}
+		w, ok := p.(providerWrapper)
+		if !ok {
+			return nil
+		}
+		p = w.unwrap()
+	}
+	return nil
+}
+
+// hasMethod returns whether the provider serves the method, the providers with unknown method set serve all methods
+func hasMethod(p motan.Provider, method string) bool {
+	mp := knownMethods(p)
+	if mp == nil {
+		return true
+	}
+	if o, ok := mp.(methodOwner); ok {
+		return o.HasMethod(method)
+	}
+	upper := motan.FirstUpper(method)
+	for _, name := range mp.GetMethodNames() {
+		if name == method || name == upper {
+			return true
+		}
+	}
+	return false
+}
+
+// dynamicMethodProvider returns the provider itself or the wrapped provider which supports dynamic methods
+func dynamicMethodProvider(p motan.Provider) motan.DynamicMethodProvider {
+	for p != nil {
+		if dp, ok := p.(motan.DynamicMethodProvider); ok {
+			return dp
+		}
+		w, ok := p.(providerWrapper)
+		if !ok {
+			return nil
+		}
+		p = w.unwrap()
+	}
+	return nil
+}
+
+// AddMethod add a method to the provider of service at runtime without re-exporting it, the max methods limit is checked
+func (d *DefaultMessageHandler) AddMethod(service string, name string, method interface{}) error {
+	p := d.GetProvider(service)
+	if p == nil {
+		return errors.New("not found provider for " + service)
+	}
+	dp := dynamicMethodProvider(p)
+	if dp == nil {
+		return errors.New("provider of " + service + " does not support dynamic methods")
+	}
+	if err := checkMethodNaming(p, name); err != nil {
+		return err
+	}
+	if err := dp.AddMethod(name, method); err != nil {
+		return err
+	}
+	if err := checkMaxMethods(p); err != nil {
+		dp.RemoveMethod(name)
+		return err
+	}
+	vlog.Infof("method %s added to provider %s", name, service)
+	return nil
+}
+
+// RemoveMethod remove a method of the provider of service at runtime, the calls of a removed method get a not found exception
+func (d *DefaultMessageHandler) RemoveMethod(service string, name string) bool {
+	p := d.GetProvider(service)
+	if p == nil {
+		return false
+	}
+	dp := dynamicMethodProvider(p)
+	if dp == nil || !dp.RemoveMethod(name) {
+		return false
+	}
+	vlog.Infof("method %s removed from provider %s", name, service)
+	return true
+}
@@ -2,7 +2,10 @@ package server
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
 	"errors"
+	"fmt"
 	"net"
 	"strconv"
 	"strings"
@@ -10,6 +13,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/juju/ratelimit"
 	motan "github.com/weibocom/motan-go/core"
 	"github.com/weibocom/motan-go/log"
 	"github.com/weibocom/motan-go/metrics"
@@ -17,6 +21,18 @@ import (
 	"github.com/weibocom/motan-go/registry"
 )
 
+// SupportedVersionsKey is the url parameter of protocol versions accepted by MotanServer, e.g. "1,2"
+const SupportedVersionsKey = "supportedVersions"
+
+// TransportKey is the url parameter of the transport of MotanServer, see motan.RegistTransport. the server fails to
+// open with a misspelled or not registered transport instead of serving tcp silently
+const TransportKey = motan.TransportKey
+
+const TCPTransport = motan.TCPTransport
+
+// UnsupportedSerializationMetric is the counter of the requests rejected for the serializations not registered in the server
+const UnsupportedSerializationMetric = "unsupported_serialization.total_count"
+
 var currentConnections int64
 
 var motanServerOnce sync.Once
@@ -33,24 +49,58 @@ func getConnections() int64 {
 	return atomic.LoadInt64(&currentConnections)
 }
 
+// HealthReporter populates health metadata(e.g. load, version, capacity) into the attachments of heartbeat response.
+// it is called for every heartbeat, so it should be fast
+type HealthReporter func(attachments *motan.StringMap)
+
 type MotanServer struct {
+	inflight    int64 // requests in processing, keep it first for atomic alignment
 	URL         *motan.URL
 	handler     motan.MessageHandler
 	listener    net.Listener
 	extFactory  motan.ExtensionFactory
 	proxy       bool
 	isDestroyed chan bool
+
+	supportedVersions []int
+	connOptions       *connOptions
+	tlsConfig         *tls.Config
+	capture           *requestCapture
+	healthReporter    atomic.Value // HealthReporter
+	conns             sync.Map     // net.Conn -> struct{}, the connections in serving
+
+	// the limits of the bodies, the larger requests are rejected and the larger responses are replaced by exceptions
+	maxRequestBodySize  int
+	maxMetadataSize     int
+	maxResponseBodySize int
+	// answers the requests of MetaServicePath, see MetaServiceKey
+	metaService bool
 }
 
 func (m *MotanServer) Open(block bool, proxy bool, handler motan.MessageHandler, extFactory motan.ExtensionFactory) error {
 	m.isDestroyed = make(chan bool, 1)
+	transport, err := motan.GetURLTransport(m.URL)
+	if err != nil {
+		vlog.Errorf("open motan server of service %s fail. err: %v", m.URL.Path, err)
+		return err
+	}
+	options, err := parseConnOptions(m.URL)
+	if err != nil {
+		vlog.Errorf("open motan server of service %s fail. err: %v", m.URL.Path, err)
+		return err
+	}
+	m.connOptions = options
+	if m.tlsConfig, err = parseTLSConfig(m.URL); err != nil {
+		vlog.Errorf("open motan server of service %s fail. err: %v", m.URL.Path, err)
+		return err
+	}
 
 	motanServerOnce.Do(func() {
 		metrics.RegisterStatusSampleFunc("motan_server_connection_count", getConnections)
 	})
 
 	var lis net.Listener
-	if unixSockAddr := m.URL.GetParam(motan.UnixSockKey, ""); unixSockAddr != "" {
+	if unixSockAddr := serverUnixSock(m.URL); unixSockAddr != "" {
 		listener, err := motan.ListenUnixSock(unixSockAddr)
 		if err != nil {
 			vlog.Errorf("listenUnixSock fail. err:%v", err)
@@ -58,22 +108,32 @@ func (m *MotanServer) Open(block bool, proxy bool, handler motan.MessageHandler,
 		}
 		lis = listener
 	} else {
-		addr := ":" + strconv.Itoa(int(m.URL.Port))
-		if registry.IsAgent(m.URL) {
-			addr = m.URL.Host + addr
+		addr := listenAddr(m.URL)
+		if conflict := runningServerOfAddr(addr); conflict != nil {
+			err := fmt.Errorf("port %d is already used by the motan server of service %s, address: %s", m.URL.Port, conflict.URL.Path, listenAddr(conflict.URL))
+			vlog.Errorf("open motan server of service %s fail. err: %v", m.URL.Path, err)
+			return err
 		}
-		lisTmp, err := net.Listen("tcp", addr)
+		lisTmp, err := transport.Listen(addr, m.URL)
 		if err != nil {
-			vlog.Errorf("listen port:%d fail. err: %v", m.URL.Port, err)
+			vlog.Errorf("listen port:%d fail, the port may be used by another process. service: %s, err: %v", m.URL.Port, m.URL.Path, err)
 			return err
 		}
 		lis = lisTmp
 	}
 
 	m.listener = lis
+	m.supportedVersions = parseSupportedVersions(m.URL.GetParam(SupportedVersionsKey, ""))
+	m.maxRequestBodySize = int(m.URL.GetPositiveIntValue(motan.MaxRequestBodySizeKey, mpro.DefaultMaxBodySize))
+	m.maxMetadataSize = int(m.URL.GetPositiveIntValue(motan.MaxMetadataSizeKey, motan.DefaultMaxMetadataSize))
+	m.maxResponseBodySize = int(m.URL.GetPositiveIntValue(motan.MaxResponseBodySizeKey, mpro.DefaultMaxBodySize))
+	m.capture = newRequestCapture(m.URL)
+	// the proxy forwards the requests of the meta service to the servers proxied
+	m.metaService = !proxy && m.URL.GetParam(MetaServiceKey, "true") != "false"
 	m.handler = handler
 	m.extFactory = extFactory
 	m.proxy = proxy
+	registerServer(m)
 	vlog.Infof("motan server is started. port:%d", m.URL.Port)
 	if block {
 		m.run()
@@ -83,6 +143,42 @@ func (m *MotanServer) Open(block bool, proxy bool, handler motan.MessageHandler,
 	return nil
 }
 
+// serverUnixSock returns the unix socket path of the server url, the UnixSockKey parameter is a path or a unix socket
+// address, the unix socket address in the host is used without the parameter
+func serverUnixSock(url *motan.URL) string {
+	if unixSock := url.GetParam(motan.UnixSockKey, ""); unixSock != "" {
+		return strings.TrimPrefix(unixSock, motan.UnixAddressPrefix)
+	}
+	unixSock, _ := url.GetUnixSock()
+	return unixSock
+}
+
+// listenAddr returns the tcp address of the server url, empty for unix socket servers
+func listenAddr(url *motan.URL) string {
+	if serverUnixSock(url) != "" {
+		return ""
+	}
+	addr := ":" + strconv.Itoa(int(url.Port))
+	if registry.IsAgent(url) {
+		addr = url.Host + addr
+	}
+	return addr
+}
+
+// addrConflict check whether two tcp addresses can not be listened at the same time,
+// a wildcard address conflicts with all addresses of the same port
+func addrConflict(a string, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	hostA, portA, errA := net.SplitHostPort(a)
+	hostB, portB, errB := net.SplitHostPort(b)
+	if errA != nil || errB != nil || portA != portB {
+		return false
+	}
+	return hostA == "" || hostB == "" || hostA == hostB
+}
+
 func (m *MotanServer) GetMessageHandler() motan.MessageHandler {
 	return m.handler
 }
@@ -99,12 +195,32 @@ func (m *MotanServer) SetURL(url *motan.URL) {
 	m.URL = url
 }
 
+// SetHealthReporter set the reporter for heartbeat response, the heartbeat response has no attachments if not set
+func (m *MotanServer) SetHealthReporter(reporter HealthReporter) {
+	m.healthReporter.Store(reporter)
+}
+
+func (m *MotanServer) buildHeartbeatResponse(requestID uint64) *mpro.Message {
+	res := mpro.BuildHeartbeat(requestID, mpro.Res)
+	if reporter, ok := m.healthReporter.Load().(HealthReporter); ok && reporter != nil {
+		func() {
+			defer motan.HandlePanic(nil)
+			reporter(res.Metadata)
+		}()
+	}
+	return res
+}
+
 func (m *MotanServer) GetName() string {
 	return "motan2"
 }
 
 func (m *MotanServer) Destroy() {
+	unregisterServer(m)
 	err := m.listener.Close()
+	if m.capture != nil {
+		m.capture.close()
+	}
 	if err == nil {
 		m.isDestroyed <- true
 		vlog.Infof("motan server destroy success.url %v", m.URL)
@@ -115,89 +231,187 @@ func (m *MotanServer) Destroy() {
 
 func (m *MotanServer) run() {
 	for {
-		conn, err := m.listener.Accept()
-		if err != nil {
-			select {
-			case <-m.isDestroyed:
-				vlog.Infof("Motan agent server been Destroyed and stoped.")
-				return
-			default:
-				vlog.Errorf("motan server accept from port %v fail. err:%s", m.listener.Addr(), err.Error())
-			}
-		} else {
-			if c, ok := conn.(*net.TCPConn); ok {
-				c.SetNoDelay(true)
-				c.SetKeepAlive(true)
-			}
-			go m.handleConn(conn)
+		if m.accept() {
+			return
 		}
 	}
 }
 
+// accept one connection, return true if the server has been destroyed
+func (m *MotanServer) accept() (destroyed bool) {
+	defer motan.HandlePanic(nil)
+	conn, err := m.listener.Accept()
+	if err != nil {
+		select {
+		case <-m.isDestroyed:
+			vlog.Infof("Motan agent server been Destroyed and stoped.")
+			return true
+		default:
+			vlog.Errorf("motan server accept from port %v fail. err:%s", m.listener.Addr(), err.Error())
+		}
+	} else {
+		if c, ok := conn.(*net.TCPConn); ok {
+			m.connOptions.apply(c)
+		}
+		if m.tlsConfig != nil {
+			conn = tls.Server(conn, m.tlsConfig)
+		}
+		go m.handleConn(conn)
+	}
+	return false
+}
+
+// the readers of the connections are reused by the following connections
+var connReaderPool = sync.Pool{New: func() interface{} { return bufio.NewReader(nil) }}
+
+func acquireConnReader(conn net.Conn) *bufio.Reader {
+	r := connReaderPool.Get().(*bufio.Reader)
+	r.Reset(conn)
+	return r
+}
+
+func releaseConnReader(r *bufio.Reader) {
+	// the connection is not referenced by the pool
+	r.Reset(nil)
+	connReaderPool.Put(r)
+}
+
 func (m *MotanServer) handleConn(conn net.Conn) {
 	incrConnections()
 	defer decrConnections()
+	m.conns.Store(conn, struct{}{})
+	defer m.conns.Delete(conn)
 	defer conn.Close()
 	defer motan.HandlePanic(nil)
-	buf := bufio.NewReader(conn)
+	buf := acquireConnReader(conn)
+	defer releaseConnReader(buf)
 
 	var ip string
 	if ta, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
 		ip = ta.IP.String()
+	} else if _, ok := conn.RemoteAddr().(*net.UnixAddr); ok {
+		// the clients of the unix socket are on the same host
+		ip = "127.0.0.1"
 	} else {
 		ip = getRemoteIP(conn.RemoteAddr().String())
 	}
+	limiter := m.connOptions.newRequestLimiter()
+	application := m.URL.GetParam(motan.ApplicationKey, metrics.DefaultStatApplication)
+	streams := &serverStreams{failureKey: metrics.DefaultStatRole + metrics.KeyDelimiter + application + metrics.KeyDelimiter + StreamFailureMetric}
+	defer streams.closeAll()
 
 	for {
-		request, t, err := mpro.DecodeWithTime(buf)
+		request, t, err := mpro.DecodeWithLimit(buf, m.supportedVersions, m.maxRequestBodySize, m.maxMetadataSize)
 		if err != nil {
-			if err.Error() != "EOF" {
+			// the body has been skipped, so the connection keeps serving
+			if be, ok := err.(*mpro.BodySizeError); ok {
+				m.rejectBodySize(conn, be)
+				continue
+			}
+			if me, ok := err.(*mpro.MetadataSizeError); ok {
+				m.rejectMetadataSize(conn, me.Header, me.Size)
+				continue
+			}
+			if ve, ok := err.(*mpro.VersionError); ok {
+				m.rejectVersion(conn, ve)
+			} else if err.Error() != "EOF" {
 				vlog.Warningf("decode motan message fail! con:%s, err:%s.", conn.RemoteAddr().String(), err.Error())
 			}
 			break
 		}
+		if !request.IsBatch() {
+			m.dispatch(conn, ip, t, request, limiter, streams)
+			continue
+		}
+		// the messages of a batch frame are processed concurrently, and responded separately
+		messages, err := mpro.DecodeBatch(request, m.supportedVersions)
+		if err != nil {
+			vlog.Warningf("decode motan batch message fail! con:%s, err:%s.", conn.RemoteAddr().String(), err.Error())
+			break
+		}
+		for _, message := range messages {
+			m.dispatch(conn, ip, t, message, limiter, streams)
+		}
+	}
+}
 
-		request.Metadata.Store(motan.HostKey, ip)
-		var trace *motan.TraceContext
-		if !request.Header.IsHeartbeat() {
-			trace = motan.TracePolicy(request.Header.RequestID, request.Metadata)
-			if trace != nil {
-				trace.Addr = ip
-				trace.PutReqSpan(&motan.Span{Name: motan.Receive, Time: t})
-				trace.PutReqSpan(&motan.Span{Name: motan.Decode, Time: time.Now()})
-			}
+func (m *MotanServer) dispatch(conn net.Conn, ip string, t time.Time, request *mpro.Message, limiter *ratelimit.Bucket, streams *serverStreams) {
+	// the frames of the streams are not requests
+	if request.GetStreamFrame() != "" {
+		streams.dispatch(request)
+		return
+	}
+	// the messages of the batch frames are not checked by the decoding
+	if size := motan.MetadataSize(request.Metadata); size > m.maxMetadataSize {
+		m.rejectMetadataSize(conn, request.Header, size)
+		return
+	}
+	// the connection is not read while waiting, so the backpressure is applied to the client of this connection only
+	if limiter != nil && !request.Header.IsHeartbeat() {
+		limiter.Wait(1)
+	}
+
+	request.Metadata.Store(motan.HostKey, ip)
+	var trace *motan.TraceContext
+	if !request.Header.IsHeartbeat() {
+		trace = motan.TracePolicy(request.Header.RequestID, request.Metadata)
+		if trace != nil {
+			trace.Addr = ip
+			trace.PutReqSpan(&motan.Span{Name: motan.Receive, Time: t})
+			trace.PutReqSpan(&motan.Span{Name: motan.Decode, Time: time.Now()})
 		}
-		go m.processReq(t, request, trace, conn)
 	}
+	// the stream is opened before the following frames are read
+	var stream *serverStream
+	if request.Metadata.LoadOrEmpty(mpro.MStream) == "true" {
+		stream = streams.open(conn, request.Header.RequestID, m.extFactory.GetSerialization("", request.Header.GetSerialize()))
+	}
+	atomic.AddInt64(&m.inflight, 1)
+	go m.processReq(t, request, trace, conn, stream)
 }
 
-func (m *MotanServer) processReq(start time.Time, request *mpro.Message, tc *motan.TraceContext, conn net.Conn) {
+func (m *MotanServer) processReq(start time.Time, request *mpro.Message, tc *motan.TraceContext, conn net.Conn, stream *serverStream) {
+	defer atomic.AddInt64(&m.inflight, -1)
 	defer motan.HandlePanic(nil)
 	request.Header.SetProxy(m.proxy)
 	// TODO request , response reuse
 	var mres motan.Response
 	var mreq motan.Request
 	var res *mpro.Message
+	var progress *progressReporter
+	var captured *CapturedRequest
 	lastRequestID := request.Header.RequestID
 	if request.Header.IsHeartbeat() {
-		res = mpro.BuildHeartbeat(request.Header.RequestID, mpro.Res)
+		res = m.buildHeartbeatResponse(request.Header.RequestID)
+	} else if serialization := m.extFactory.GetSerialization("", request.Header.GetSerialize()); serialization == nil && !request.Header.IsProxy() {
+		res = m.buildUnsupportedSerializationResponse(request)
 	} else {
-		serialization := m.extFactory.GetSerialization("", request.Header.GetSerialize())
 		req, err := mpro.ConvertToRequest(request, serialization)
 		if err != nil {
 			vlog.Errorf("motan server convert to motan request fail. rid :%d, service: %s, method:%s,err:%s\n", request.Header.RequestID, request.Metadata.LoadOrEmpty(mpro.MPath), request.Metadata.LoadOrEmpty(mpro.MMethod), err.Error())
 			res = mpro.BuildExceptionResponse(request.Header.RequestID, mpro.ExceptionToJSON(&motan.Exception{ErrCode: 500, ErrMsg: "deserialize fail. err:" + err.Error() + " method:" + request.Metadata.LoadOrEmpty(mpro.MMethod), ErrType: motan.ServiceException}))
 		} else {
+			if m.capture != nil {
+				captured = m.capture.capture(request)
+			}
 			mreq = req
 			reqCtx := req.GetRPCContext(true)
 			reqCtx.ExtFactory = m.extFactory
 			reqCtx.RequestReceiveTime = start
+			reqCtx.Trailers = &motan.Trailers{}
+			if request.Metadata.LoadOrEmpty(mpro.MProgressEnabled) == "true" {
+				progress = newProgressReporter(conn, lastRequestID, request.Header.GetSerialize())
+				reqCtx.ProgressReporter = progress.report
+			}
+			if stream != nil {
+				reqCtx.Stream = stream
+			}
 			if tc != nil {
 				tc.PutReqSpan(&motan.Span{Name: motan.Convert, Time: time.Now()})
 				req.GetRPCContext(true).Tc = tc
 			}
 			callStart := time.Now()
-			mres = m.handler.Call(req)
+			mres = m.callHandler(req)
 			if tc != nil {
 				// clusterFilter end
 				tc.PutResSpan(&motan.Span{Name: motan.ClFilter, Time: time.Now()})
@@ -208,31 +422,43 @@ func (m *MotanServer) processReq(start time.Time, request *mpro.Message, tc *mot
 				if mres.GetAttachment(mpro.MProcessTime) == "" {
 					mres.SetAttachment(mpro.MProcessTime, strconv.FormatInt(int64(time.Now().Sub(callStart)/1e6), 10))
 				}
-				res, err = mpro.ConvertToResMessage(mres, serialization)
+				res, err = m.convertResponse(req, mres, serialization)
 				if tc != nil {
 					tc.PutResSpan(&motan.Span{Name: motan.Convert, Time: time.Now()})
 				}
 			} else {
 				err = errors.New("handler call return nil")
 			}
+			// sealed after the body is serialized, so the trailers set by the serialization are sent
+			trailers := reqCtx.Trailers.Seal()
+			if err == nil {
+				for k, v := range trailers {
+					res.Metadata.Store(motan.TrailerAttachmentPrefix+k, v)
+				}
+			}
 
 			if err != nil {
 				res = mpro.BuildExceptionResponse(request.Header.RequestID, mpro.ExceptionToJSON(&motan.Exception{ErrCode: 500, ErrMsg: "convert to response fail. err:" + err.Error(), ErrType: motan.ServiceException}))
+			} else if m.maxResponseBodySize > 0 && len(res.Body) > m.maxResponseBodySize {
+				res = m.buildOversizedResponse(request, "response", len(res.Body), m.maxResponseBodySize)
 			}
 		}
 	}
-	// recover the communication identifier
-	res.Header.RequestID = lastRequestID
-	resBuf := res.Encode()
-	if tc != nil {
-		tc.PutResSpan(&motan.Span{Name: motan.Encode, Time: time.Now()})
+	if captured != nil {
+		m.capture.write(captured, res)
 	}
-
-	conn.SetWriteDeadline(time.Now().Add(motan.DefaultWriteTimeout))
-	_, err := conn.Write(resBuf.Bytes())
-	if err != nil {
-		vlog.Errorf("connection will close. conn: %s, err:%s", conn.RemoteAddr().String(), err.Error())
-		conn.Close()
+	// no progress message after the final response
+	if progress != nil {
+		progress.finish()
+	}
+	// no stream frame after the final response, the failed stream has sent its final response
+	respond := true
+	if stream != nil {
+		respond = stream.finish()
+	}
+	// the caller of a oneway request does not wait for the response
+	if !request.Header.IsOneWay() && respond {
+		m.writeResponse(conn, res, lastRequestID, tc)
 	}
 	resSendTime := time.Now()
 	if mreq != nil {
@@ -243,11 +469,179 @@ func (m *MotanServer) processReq(start time.Time, request *mpro.Message, tc *mot
 		resCtx := mres.GetRPCContext(true)
 		resCtx.OnFinish()
 	}
+	if mreq != nil {
+		tapRequest(mreq, mres)
+	}
 	if tc != nil {
 		tc.PutResSpan(&motan.Span{Name: motan.Send, Time: resSendTime})
 	}
 }
 
+// drain waits until all requests in processing are finished or the context is done
+func (m *MotanServer) drain(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for atomic.LoadInt64(&m.inflight) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// Shutdown stops accepting new connections, waits the requests in processing until they finish or the context is done,
+// then closes the connections. the context without deadline waits at most GracefulShutdownTimeoutKey of the server url.
+// the requests in processing are dropped with their connections if the context is done first, and the error is returned
+func (m *MotanServer) Shutdown(ctx context.Context) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.URL.GetTimeDuration(GracefulShutdownTimeoutKey, time.Millisecond, defaultGracefulShutdownTimeout))
+		defer cancel()
+	}
+	m.Destroy()
+	err := m.drain(ctx)
+	if err != nil {
+		vlog.Warningf("drain motan server fail, requests in processing will be dropped. url:%v, err:%v", m.URL, err)
+	}
+	m.closeConns()
+	vlog.Infof("motan server shutdown. url:%v, err:%v", m.URL, err)
+	return err
+}
+
+func (m *MotanServer) closeConns() {
+	m.conns.Range(func(conn, _ interface{}) bool {
+		conn.(net.Conn).Close()
+		return true
+	})
+}
+
+// callHandler isolates the panic of one request(e.g. panic in filters or message handler),
+// so the client still gets an exception response and the connection keeps serving
+func (m *MotanServer) callHandler(req motan.Request) (res motan.Response) {
+	defer motan.HandleRequestPanic(req, func() {
+		vlog.Errorf("motan server handler call panic. req:%s", motan.GetReqInfo(req))
+		res = motan.BuildExceptionResponse(req.GetRequestID(), &motan.Exception{ErrCode: 500, ErrMsg: "server handler panic", ErrType: motan.ServiceException})
+	})
+	if req.GetServiceName() == MetaServicePath && m.metaService {
+		return m.callMetaService(req)
+	}
+	return m.handler.Call(req)
+}
+
+func convertToResMessage(res motan.Response, serialization motan.Serialization) (msg *mpro.Message, err error) {
+	defer motan.HandlePanic(func() {
+		err = errors.New("convert panic")
+	})
+	return mpro.ConvertToResMessage(res, serialization)
+}
+
+// rejectVersion tell the client its protocol version is unsupported. the connection will be closed after that,
+// because the remaining frames can not be decoded correctly
+func (m *MotanServer) rejectVersion(conn net.Conn, ve *mpro.VersionError) {
+	vlog.Warningf("reject unsupported protocol version. conn:%s, version:%d, supported:%v", conn.RemoteAddr().String(), ve.Header.GetVersion(), m.supportedVersions)
+	res := mpro.BuildExceptionResponse(ve.Header.RequestID, mpro.ExceptionToJSON(&motan.Exception{ErrCode: 505, ErrMsg: ve.Error() + ", supported versions: " + fmt.Sprint(m.supportedVersions), ErrType: motan.FrameworkException}))
+	conn.SetWriteDeadline(time.Now().Add(motan.DefaultWriteTimeout))
+	buf := res.Encode()
+	defer motan.ReleaseBytesBuffer(buf)
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		vlog.Warningf("write version reject response fail. conn:%s, err:%s", conn.RemoteAddr().String(), err.Error())
+	}
+}
+
+func (m *MotanServer) writeResponse(conn net.Conn, res *mpro.Message, requestID uint64, tc *motan.TraceContext) {
+	// recover the communication identifier
+	res.Header.RequestID = requestID
+	resBuf := res.Encode()
+	defer motan.ReleaseBytesBuffer(resBuf)
+	// the compressed body is copied into the encoded buffer
+	res.ReleaseBody()
+	if tc != nil {
+		tc.PutResSpan(&motan.Span{Name: motan.Encode, Time: time.Now()})
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(motan.DefaultWriteTimeout))
+	if _, err := conn.Write(resBuf.Bytes()); err != nil {
+		vlog.Errorf("connection will close. conn: %s, err:%s", conn.RemoteAddr().String(), err.Error())
+		conn.Close()
+	}
+}
+
+// rejectBodySize tell the client its request body is too large, the body is not read into memory
+func (m *MotanServer) rejectBodySize(conn net.Conn, be *mpro.BodySizeError) {
+	res := m.buildOversizedResponse(be.Message, "request", be.Size, be.Limit)
+	if be.Message.Header.IsOneWay() {
+		return
+	}
+	conn.SetWriteDeadline(time.Now().Add(motan.DefaultWriteTimeout))
+	buf := res.Encode()
+	defer motan.ReleaseBytesBuffer(buf)
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		vlog.Warningf("write body size reject response fail. conn:%s, err:%s", conn.RemoteAddr().String(), err.Error())
+	}
+}
+
+// rejectMetadataSize tell the client its request metadata is too large, the metadata is skipped without reading into
+// memory if it is rejected by the decoding, so only the header is known
+func (m *MotanServer) rejectMetadataSize(conn net.Conn, header *mpro.Header, size int) {
+	vlog.Warningf("reject oversized request metadata. conn:%s, rid:%d, size:%d, limit:%d", conn.RemoteAddr().String(), header.RequestID, size, m.maxMetadataSize)
+	if header.IsOneWay() {
+		return
+	}
+	res := mpro.BuildExceptionResponse(header.RequestID, mpro.ExceptionToJSON(&motan.Exception{ErrCode: 413,
+		ErrMsg:  "request metadata size " + strconv.Itoa(size) + " exceeds the limit " + strconv.Itoa(m.maxMetadataSize),
+		ErrType: motan.FrameworkException}))
+	conn.SetWriteDeadline(time.Now().Add(motan.DefaultWriteTimeout))
+	buf := res.Encode()
+	defer motan.ReleaseBytesBuffer(buf)
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		vlog.Warningf("write metadata size reject response fail. conn:%s, err:%s", conn.RemoteAddr().String(), err.Error())
+	}
+}
+
+func (m *MotanServer) buildOversizedResponse(request *mpro.Message, kind string, size int, limit int) *mpro.Message {
+	vlog.Warningf("reject oversized %s. rid:%d, service:%s, method:%s, size:%d, limit:%d", kind, request.Header.RequestID, request.Metadata.LoadOrEmpty(mpro.MPath), request.Metadata.LoadOrEmpty(mpro.MMethod), size, limit)
+	application := m.URL.GetParam(motan.ApplicationKey, metrics.DefaultStatApplication)
+	key := metrics.DefaultStatRole + metrics.KeyDelimiter + application + metrics.KeyDelimiter + kind + "_" + mpro.OversizedBodyMetric
+	metrics.AddCounter(metrics.DefaultStatGroup, metrics.DefaultStatService, key, 1)
+	return mpro.BuildExceptionResponse(request.Header.RequestID, mpro.ExceptionToJSON(&motan.Exception{ErrCode: 413,
+		ErrMsg:  kind + " body size " + strconv.Itoa(size) + " exceeds the limit " + strconv.Itoa(limit) + ", method: " + request.Metadata.LoadOrEmpty(mpro.MMethod),
+		ErrType: motan.FrameworkException}))
+}
+
+// buildUnsupportedSerializationResponse rejects the request of a serialization not registered in the server before decoding the body
+func (m *MotanServer) buildUnsupportedSerializationResponse(request *mpro.Message) *mpro.Message {
+	id := request.Header.GetSerialize()
+	vlog.Warningf("unsupported serialization of request. rid:%d, service:%s, method:%s, serialization:%d", request.Header.RequestID, request.Metadata.LoadOrEmpty(mpro.MPath), request.Metadata.LoadOrEmpty(mpro.MMethod), id)
+	application := m.URL.GetParam(motan.ApplicationKey, metrics.DefaultStatApplication)
+	key := metrics.DefaultStatRole + metrics.KeyDelimiter + application + metrics.KeyDelimiter + UnsupportedSerializationMetric
+	metrics.AddCounter(metrics.DefaultStatGroup, metrics.DefaultStatService, key, 1)
+	return mpro.BuildExceptionResponse(request.Header.RequestID, mpro.ExceptionToJSON(&motan.Exception{ErrCode: 415,
+		ErrMsg:  "unsupported serialization: " + strconv.Itoa(id) + ", method: " + request.Metadata.LoadOrEmpty(mpro.MMethod),
+		ErrType: motan.FrameworkException}))
+}
+
+func parseSupportedVersions(versions string) []int {
+	defaultVersions := []int{mpro.Version2}
+	if versions == "" {
+		return defaultVersions
+	}
+	result := make([]int, 0, 2)
+	for _, v := range motan.TrimSplit(versions, ",") {
+		version, err := strconv.Atoi(v)
+		if err != nil || version < 0 || version > 31 {
+			vlog.Warningf("illegal protocol version in %s: %s", SupportedVersionsKey, v)
+			continue
+		}
+		result = append(result, version)
+	}
+	if len(result) == 0 {
+		return defaultVersions
+	}
+	return result
+}
+
 func getRemoteIP(address string) string {
 	var ip string
 	index := strings.Index(address, ":")
@@ -0,0 +1,630 @@
+package server
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/endpoint"
+	mpro "github.com/weibocom/motan-go/protocol"
+	"github.com/weibocom/motan-go/serialize"
+)
+
+func openTestServer(t *testing.T, port int, params map[string]string, handler motan.MessageHandler) *MotanServer {
+	url := &motan.URL{Protocol: "motan2", Host: "127.0.0.1", Port: port, Path: "test", Parameters: params}
+	server := &MotanServer{URL: url}
+	err := server.Open(false, false, handler, nil)
+	assert.Nil(t, err)
+	return server
+}
+
+func TestRejectUnsupportedVersion(t *testing.T) {
+	server := openTestServer(t, 64581, map[string]string{SupportedVersionsKey: "1"}, newTestHandler())
+	defer server.Destroy()
+
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:"+strconv.Itoa(64581), time.Second)
+	assert.Nil(t, err)
+	defer conn.Close()
+	msg := mpro.BuildHeartbeat(789, mpro.Req)
+	msg.Header.SetVersion(2)
+	_, err = conn.Write(msg.Encode().Bytes())
+	assert.Nil(t, err)
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	res, err := mpro.Decode(bufio.NewReader(conn))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(789), res.Header.RequestID)
+	assert.Equal(t, mpro.Exception, res.Header.GetStatus())
+	assert.Contains(t, res.Metadata.LoadOrEmpty(mpro.MExceptionn), "unsupported version: 2")
+}
+
+func TestHeartbeatHealthReporter(t *testing.T) {
+	server := &MotanServer{URL: &motan.URL{}}
+	res := server.buildHeartbeatResponse(1)
+	assert.True(t, res.Header.IsHeartbeat())
+	assert.Equal(t, 0, res.Metadata.Len())
+
+	server.SetHealthReporter(func(attachments *motan.StringMap) {
+		attachments.Store("load", "0.5")
+		attachments.Store("version", "1.0.0")
+	})
+	res = server.buildHeartbeatResponse(2)
+	assert.Equal(t, uint64(2), res.Header.RequestID)
+	assert.Equal(t, "0.5", res.Metadata.LoadOrEmpty("load"))
+	assert.Equal(t, "1.0.0", res.Metadata.LoadOrEmpty("version"))
+
+	// panic in reporter should not break heartbeat
+	server.SetHealthReporter(func(attachments *motan.StringMap) {
+		panic("reporter panic")
+	})
+	res = server.buildHeartbeatResponse(3)
+	assert.True(t, res.Header.IsHeartbeat())
+}
+
+type panicHandler struct {
+	DefaultMessageHandler
+}
+
+func (p *panicHandler) Call(request motan.Request) motan.Response {
+	if request.GetMethod() == "panic" {
+		panic("handler panic")
+	}
+	return p.DefaultMessageHandler.Call(request)
+}
+
+type panicFilter struct {
+	next motan.EndPointFilter
+}
+
+func (p *panicFilter) GetName() string                         { return "panicFilter" }
+func (p *panicFilter) NewFilter(url *motan.URL) motan.Filter   { return &panicFilter{} }
+func (p *panicFilter) HasNext() bool                           { return p.next != nil }
+func (p *panicFilter) GetIndex() int                           { return 1 }
+func (p *panicFilter) GetType() int32                          { return motan.EndPointFilterType }
+func (p *panicFilter) SetNext(nextFilter motan.EndPointFilter) { p.next = nextFilter }
+func (p *panicFilter) GetNext() motan.EndPointFilter           { return p.next }
+func (p *panicFilter) Filter(caller motan.Caller, request motan.Request) motan.Response {
+	if request.GetMethod() == "filterPanic" {
+		panic("filter panic")
+	}
+	return p.next.Filter(caller, request)
+}
+
+func sendTestRequest(t *testing.T, conn net.Conn, reader *bufio.Reader, requestID uint64, service string, method string) *mpro.Message {
+	request := &motan.MotanRequest{RequestID: requestID, ServiceName: service, Method: method, Arguments: []interface{}{"arg"}}
+	msg, err := mpro.ConvertToReqMessage(request, &serialize.SimpleSerialization{})
+	assert.Nil(t, err)
+	_, err = conn.Write(msg.Encode().Bytes())
+	assert.Nil(t, err)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	res, err := mpro.Decode(reader)
+	assert.Nil(t, err)
+	return res
+}
+
+func TestRequestPanicIsolation(t *testing.T) {
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	serialize.RegistDefaultSerializations(ext)
+	p := newTestProvider("panicService", nil)
+	pf := &panicFilter{}
+	pf.SetNext(motan.GetLastEndPointFilter())
+	handler := &panicHandler{}
+	handler.Initialize()
+	handler.AddProvider(&FilterProviderWrapper{provider: p, filter: pf})
+
+	server := &MotanServer{URL: &motan.URL{Protocol: "motan2", Host: "127.0.0.1", Port: 64582}}
+	assert.Nil(t, server.Open(false, false, handler, ext))
+	defer server.Destroy()
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:64582", time.Second)
+	assert.Nil(t, err)
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	res := sendTestRequest(t, conn, reader, 1, "panicService", "panic")
+	assert.Equal(t, mpro.Exception, res.Header.GetStatus())
+	assert.Contains(t, res.Metadata.LoadOrEmpty(mpro.MExceptionn), "server handler panic")
+
+	res = sendTestRequest(t, conn, reader, 2, "panicService", "filterPanic")
+	assert.Equal(t, uint64(2), res.Header.RequestID)
+	assert.Equal(t, mpro.Exception, res.Header.GetStatus())
+
+	// the connection still serving after panics
+	res = sendTestRequest(t, conn, reader, 3, "panicService", "normal")
+	assert.Equal(t, uint64(3), res.Header.RequestID)
+	assert.Equal(t, mpro.Normal, res.Header.GetStatus())
+}
+
+func TestRejectUnsupportedSerialization(t *testing.T) {
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	serialize.RegistDefaultSerializations(ext)
+	server := &MotanServer{URL: &motan.URL{Protocol: "motan2", Host: "127.0.0.1", Port: 64583}}
+	assert.Nil(t, server.Open(false, false, newTestHandler(newTestProvider("test", nil)), ext))
+	defer server.Destroy()
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:64583", time.Second)
+	assert.Nil(t, err)
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	request := &motan.MotanRequest{RequestID: 11, ServiceName: "test", Method: "hello", Arguments: []interface{}{"arg"}}
+	msg, err := mpro.ConvertToReqMessage(request, &serialize.SimpleSerialization{})
+	assert.Nil(t, err)
+	msg.Header.SetSerialize(30)
+	_, err = conn.Write(msg.Encode().Bytes())
+	assert.Nil(t, err)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	res, err := mpro.Decode(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(11), res.Header.RequestID)
+	assert.Equal(t, mpro.Exception, res.Header.GetStatus())
+	assert.Contains(t, res.Metadata.LoadOrEmpty(mpro.MExceptionn), "unsupported serialization: 30")
+	assert.Contains(t, res.Metadata.LoadOrEmpty(mpro.MExceptionn), "415")
+
+	// the connection still serving the requests of supported serializations
+	res = sendTestRequest(t, conn, reader, 12, "test", "hello")
+	assert.Equal(t, uint64(12), res.Header.RequestID)
+	assert.Equal(t, mpro.Normal, res.Header.GetStatus())
+}
+
+// trailerValue sets the trailer when it is serialized, after the provider returns
+type trailerValue struct {
+	request motan.Request
+}
+
+func (v *trailerValue) MarshalJSON() ([]byte, error) {
+	motan.SetTrailer(v.request, "cursor", "next")
+	return []byte(`"items"`), nil
+}
+
+func TestResponseTrailers(t *testing.T) {
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	serialize.RegistDefaultSerializations(ext)
+	var lastRequest motan.Request
+	p := newTestProvider("trailerService", nil)
+	p.callFunc = func(request motan.Request) motan.Response {
+		lastRequest = request
+		assert.Nil(t, motan.SetTrailer(request, "total", "2"))
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: &trailerValue{request: request}}
+	}
+	server := &MotanServer{URL: &motan.URL{Protocol: "motan2", Host: "127.0.0.1", Port: 64584}}
+	assert.Nil(t, server.Open(false, false, newTestHandler(p), ext))
+	defer server.Destroy()
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:64584", time.Second)
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	request := &motan.MotanRequest{RequestID: 21, ServiceName: "trailerService", Method: "list", Arguments: []interface{}{"arg"}}
+	msg, err := mpro.ConvertToReqMessage(request, &serialize.JSONSerialization{})
+	assert.Nil(t, err)
+	_, err = conn.Write(msg.Encode().Bytes())
+	assert.Nil(t, err)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	res, err := mpro.Decode(bufio.NewReader(conn))
+	assert.Nil(t, err)
+	assert.Equal(t, mpro.Normal, res.Header.GetStatus())
+	assert.Equal(t, `"items"`, string(res.Body))
+	assert.Equal(t, "2", res.Metadata.LoadOrEmpty(motan.TrailerAttachmentPrefix+"total"))
+	assert.Equal(t, "next", res.Metadata.LoadOrEmpty(motan.TrailerAttachmentPrefix+"cursor"))
+	// the trailers can not be set after the final response
+	assert.NotNil(t, motan.SetTrailer(lastRequest, "late", "1"))
+}
+
+func TestPortConflict(t *testing.T) {
+	server := openTestServer(t, 64587, nil, newTestHandler())
+	defer server.Destroy()
+	url := &motan.URL{Protocol: "motan2", Host: "127.0.0.1", Port: 64587, Path: "other", Parameters: map[string]string{}}
+	err := (&MotanServer{URL: url}).Open(false, false, newTestHandler(), nil)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "service test")
+
+	assert.True(t, addrConflict(":80", ":80"))
+	assert.True(t, addrConflict(":80", "10.0.0.1:80"))
+	assert.False(t, addrConflict("10.0.0.2:80", "10.0.0.1:80"))
+	assert.False(t, addrConflict(":80", ":81"))
+	assert.False(t, addrConflict("", ":80"))
+}
+
+func TestConnOptions(t *testing.T) {
+	for _, params := range []map[string]string{{ReadBufferSizeKey: "-1"}, {WriteBufferSizeKey: "a"}, {ReadBufferSizeKey: "100000000"}, {TCPNoDelayKey: "yes"}, {KeepAlivePeriodKey: "1s"}, {ConnRequestRateKey: "-1"}} {
+		_, err := parseConnOptions(&motan.URL{Parameters: params})
+		assert.NotNil(t, err, params)
+	}
+	options, err := parseConnOptions(&motan.URL{})
+	assert.Nil(t, err)
+	assert.True(t, options.noDelay)
+	options, err = parseConnOptions(&motan.URL{Parameters: map[string]string{ReadBufferSizeKey: "65536", WriteBufferSizeKey: "131072", TCPNoDelayKey: "false", KeepAlivePeriodKey: "30000"}})
+	assert.Nil(t, err)
+	assert.Equal(t, &connOptions{readBufferSize: 65536, writeBufferSize: 131072, keepAlivePeriod: 30 * time.Second}, options)
+
+	url := &motan.URL{Protocol: "motan2", Host: "127.0.0.1", Port: 64588, Path: "test", Parameters: map[string]string{ReadBufferSizeKey: "-1"}}
+	assert.NotNil(t, (&MotanServer{URL: url}).Open(false, false, newTestHandler(), nil))
+	server := openTestServer(t, 64588, map[string]string{ReadBufferSizeKey: "65536", TCPNoDelayKey: "false"}, newTestHandler())
+	defer server.Destroy()
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:64588", time.Second)
+	assert.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write(mpro.BuildHeartbeat(1, mpro.Req).Encode().Bytes())
+	assert.Nil(t, err)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	res, err := mpro.Decode(bufio.NewReader(conn))
+	assert.Nil(t, err)
+	assert.True(t, res.Header.IsHeartbeat())
+}
+
+func TestConnRequestRate(t *testing.T) {
+	options, err := parseConnOptions(&motan.URL{})
+	assert.Nil(t, err)
+	assert.Nil(t, options.newRequestLimiter())
+
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	serialize.RegistDefaultSerializations(ext)
+	url := &motan.URL{Protocol: "motan2", Host: "127.0.0.1", Port: 64589, Path: "test", Parameters: map[string]string{ConnRequestRateKey: "5"}}
+	server := &MotanServer{URL: url}
+	assert.Nil(t, server.Open(false, false, newTestHandler(newTestProvider("rateService", nil)), ext))
+	defer server.Destroy()
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:64589", time.Second)
+	assert.Nil(t, err)
+	defer conn.Close()
+	other, err := net.DialTimeout("tcp", "127.0.0.1:64589", time.Second)
+	assert.Nil(t, err)
+	defer other.Close()
+
+	// pipelines the requests beyond the burst
+	for i := 0; i < 8; i++ {
+		msg, err := mpro.ConvertToReqMessage(&motan.MotanRequest{RequestID: uint64(i), ServiceName: "rateService", Method: "hello", Arguments: []interface{}{"arg"}}, &serialize.SimpleSerialization{})
+		assert.Nil(t, err)
+		_, err = conn.Write(msg.Encode().Bytes())
+		assert.Nil(t, err)
+	}
+	start := time.Now()
+	// the other connection is not throttled
+	res := sendTestRequest(t, other, bufio.NewReader(other), 100, "rateService", "hello")
+	assert.NotNil(t, res)
+	assert.True(t, time.Since(start) < 200*time.Millisecond)
+
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for i := 0; i < 8; i++ {
+		_, err := mpro.Decode(reader)
+		assert.Nil(t, err)
+	}
+	elapsed := time.Since(start)
+	assert.True(t, elapsed >= 400*time.Millisecond, elapsed)
+	assert.True(t, elapsed < 2*time.Second, elapsed)
+}
+
+func TestUnsupportedTransport(t *testing.T) {
+	url := &motan.URL{Protocol: "motan2", Host: "127.0.0.1", Port: 64593, Path: "test", Parameters: map[string]string{TransportKey: "unregistered"}}
+	err := (&MotanServer{URL: url}).Open(false, false, newTestHandler(), nil)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "unregistered")
+	server := openTestServer(t, 64593, map[string]string{TransportKey: TCPTransport}, newTestHandler())
+	server.Destroy()
+}
+
+type countingTransport struct {
+	listens int32
+	dials   int32
+}
+
+func (c *countingTransport) Listen(address string, url *motan.URL) (net.Listener, error) {
+	atomic.AddInt32(&c.listens, 1)
+	return motan.GetTransport(motan.TCPTransport).Listen(address, url)
+}
+
+func (c *countingTransport) Dial(address string, url *motan.URL, timeout time.Duration) (net.Conn, error) {
+	atomic.AddInt32(&c.dials, 1)
+	return motan.GetTransport(motan.TCPTransport).Dial(address, url, timeout)
+}
+
+func TestRegisteredTransport(t *testing.T) {
+	transport := &countingTransport{}
+	motan.RegistTransport("counting", transport)
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	serialize.RegistDefaultSerializations(ext)
+	p := newTestProvider("transportService", nil)
+	p.callFunc = func(request motan.Request) motan.Response {
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: "ok"}
+	}
+	params := map[string]string{TransportKey: "counting"}
+	server := &MotanServer{URL: &motan.URL{Protocol: "motan2", Host: "127.0.0.1", Port: 64599, Parameters: params}}
+	assert.Nil(t, server.Open(false, false, newTestHandler(p), ext))
+	defer server.Destroy()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&transport.listens))
+
+	url := &motan.URL{Protocol: "motan2", Host: "127.0.0.1", Port: 64599, Path: "transportService", Parameters: map[string]string{TransportKey: "counting"}}
+	url.PutParam(motan.ClientConnectionKey, "2")
+	ep := &endpoint.MotanEndpoint{}
+	ep.SetURL(url)
+	ep.SetSerialization(&serialize.SimpleSerialization{})
+	ep.Initialize()
+	defer ep.Destroy()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&transport.dials))
+
+	var reply string
+	request := &motan.MotanRequest{RequestID: 1, ServiceName: "transportService", Method: "test", Attachment: motan.NewStringMap(0)}
+	request.GetRPCContext(true).Reply = &reply
+	res := ep.Call(request)
+	assert.Nil(t, res.GetException())
+	assert.Equal(t, "ok", reply)
+}
+
+func TestNegotiateCompression(t *testing.T) {
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	serialize.RegistDefaultSerializations(ext)
+	large := strings.Repeat("large", 100)
+	p := newTestProvider("compressionService", map[string]string{motan.GzipSizeKey: "10"})
+	p.callFunc = func(request motan.Request) motan.Response {
+		var arg string
+		request.ProcessDeserializable([]interface{}{&arg})
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: arg + large}
+	}
+	server := &MotanServer{URL: &motan.URL{Protocol: "motan2", Host: "127.0.0.1", Port: 64600}}
+	assert.Nil(t, server.Open(false, false, newTestHandler(p), ext))
+	defer server.Destroy()
+
+	url := &motan.URL{Protocol: "motan2", Host: "127.0.0.1", Port: 64600, Path: "compressionService", Parameters: map[string]string{}}
+	url.PutParam(motan.GzipSizeKey, "10")
+	url.PutParam(motan.CompressionKey, "zstd,snappy,gzip")
+	ep := &endpoint.MotanEndpoint{}
+	ep.SetURL(url)
+	ep.SetSerialization(&serialize.SimpleSerialization{})
+	ep.Initialize()
+	defer ep.Destroy()
+
+	// the first request is compressed by gzip, the later ones by the negotiated snappy
+	for i := 0; i < 3; i++ {
+		var reply string
+		request := &motan.MotanRequest{RequestID: uint64(i), ServiceName: "compressionService", Method: "test", Attachment: motan.NewStringMap(0), Arguments: []interface{}{large}}
+		request.GetRPCContext(true).Reply = &reply
+		res := ep.Call(request)
+		assert.Nil(t, res.GetException())
+		assert.Equal(t, large+large, reply)
+		assert.Equal(t, "gzip,snappy", res.GetAttachments().LoadOrEmpty(mpro.MAcceptCompression))
+	}
+}
+
+func TestRejectOversizedRequest(t *testing.T) {
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	serialize.RegistDefaultSerializations(ext)
+	server := &MotanServer{URL: &motan.URL{Protocol: "motan2", Host: "127.0.0.1", Port: 64611, Parameters: map[string]string{motan.MaxRequestBodySizeKey: "64"}}}
+	assert.Nil(t, server.Open(false, false, newTestHandler(newTestProvider("test", nil)), ext))
+	defer server.Destroy()
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:64611", time.Second)
+	assert.Nil(t, err)
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	request := &motan.MotanRequest{RequestID: 21, ServiceName: "test", Method: "hello", Arguments: []interface{}{strings.Repeat("a", 100)}}
+	msg, err := mpro.ConvertToReqMessage(request, &serialize.SimpleSerialization{})
+	assert.Nil(t, err)
+	_, err = conn.Write(msg.Encode().Bytes())
+	assert.Nil(t, err)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	res, err := mpro.Decode(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(21), res.Header.RequestID)
+	assert.Equal(t, mpro.Exception, res.Header.GetStatus())
+	assert.Contains(t, res.Metadata.LoadOrEmpty(mpro.MExceptionn), "413")
+	assert.Contains(t, res.Metadata.LoadOrEmpty(mpro.MExceptionn), "exceeds the limit 64")
+
+	// the connection keeps serving after the body is skipped
+	res = sendTestRequest(t, conn, reader, 22, "test", "hello")
+	assert.Equal(t, uint64(22), res.Header.RequestID)
+	assert.Equal(t, mpro.Normal, res.Header.GetStatus())
+}
+
+func TestOnewayRequest(t *testing.T) {
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	serialize.RegistDefaultSerializations(ext)
+	called := make(chan string, 1)
+	provider := newTestProvider("test", nil)
+	provider.callFunc = func(request motan.Request) motan.Response {
+		assert.True(t, request.GetRPCContext(true).Oneway)
+		called <- request.GetMethod()
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: "ok"}
+	}
+	server := &MotanServer{URL: &motan.URL{Protocol: "motan2", Host: "127.0.0.1", Port: 64612}}
+	assert.Nil(t, server.Open(false, false, newTestHandler(provider), ext))
+	defer server.Destroy()
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:64612", time.Second)
+	assert.Nil(t, err)
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	request := &motan.MotanRequest{RequestID: 31, ServiceName: "test", Method: "log"}
+	request.GetRPCContext(true).Oneway = true
+	msg, err := mpro.ConvertToReqMessage(request, &serialize.SimpleSerialization{})
+	assert.Nil(t, err)
+	assert.True(t, msg.Header.IsOneWay())
+	_, err = conn.Write(msg.Encode().Bytes())
+	assert.Nil(t, err)
+	select {
+	case method := <-called:
+		assert.Equal(t, "log", method)
+	case <-time.After(time.Second):
+		t.Fatal("oneway request is not handled")
+	}
+
+	// no response of the oneway request, the next response is of the normal request
+	provider.callFunc = nil
+	res := sendTestRequest(t, conn, reader, 32, "test", "hello")
+	assert.Equal(t, uint64(32), res.Header.RequestID)
+	assert.Equal(t, mpro.Normal, res.Header.GetStatus())
+}
+
+func TestMetaService(t *testing.T) {
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	serialize.RegistDefaultSerializations(ext)
+	p := &methodNamesTestProvider{testProvider: newTestProvider("metaTest", map[string]string{motan.SerializationKey: "simple", motan.FilterKey: "accessLog, metrics"}), names: []string{"world", "hello"}}
+	other := newTestProvider("metaOther", nil)
+	BuildVersion = "1.2.0"
+	defer func() { BuildVersion = "" }()
+	server := &MotanServer{URL: &motan.URL{Protocol: "motan2", Host: "127.0.0.1", Port: 64613}}
+	assert.Nil(t, server.Open(false, false, newTestHandler(p, other), ext))
+	defer server.Destroy()
+
+	info, err := QueryMetaInfo("127.0.0.1:64613", time.Second)
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(info.Services)) {
+		assert.Equal(t, "metaOther", info.Services[0].Path)
+		assert.Nil(t, info.Services[0].Methods)
+		service := info.Services[1]
+		assert.Equal(t, "metaTest", service.Path)
+		assert.Equal(t, "test", service.Group)
+		assert.Equal(t, []string{"hello", "world"}, service.Methods)
+		assert.Equal(t, "simple", service.Serialization)
+		assert.Equal(t, []string{"accessLog", "metrics"}, service.Filters)
+	}
+	assert.Equal(t, "1.2.0", info.Build.Version)
+	assert.Equal(t, runtime.Version(), info.Build.GoVersion)
+
+	_, err = QueryMetaInfo("127.0.0.1", time.Second)
+	assert.NotNil(t, err)
+
+	// the meta service can be disabled
+	disabled := &MotanServer{URL: &motan.URL{Protocol: "motan2", Host: "127.0.0.1", Port: 64614, Parameters: map[string]string{MetaServiceKey: "false"}}}
+	assert.Nil(t, disabled.Open(false, false, newTestHandler(p), ext))
+	defer disabled.Destroy()
+	_, err = QueryMetaInfo("127.0.0.1:64614", time.Second)
+	assert.NotNil(t, err)
+}
+
+func TestUnixSockServer(t *testing.T) {
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	serialize.RegistDefaultSerializations(ext)
+	dir, err := ioutil.TempDir("", "motan-unix")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	address := motan.UnixAddressPrefix + filepath.Join(dir, "motan.sock")
+	p := newTestProvider("unixService", nil)
+	hosts := make(chan string, 1)
+	p.callFunc = func(request motan.Request) motan.Response {
+		hosts <- request.GetAttachment(motan.HostKey)
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: "ok"}
+	}
+	server := &MotanServer{URL: &motan.URL{Protocol: "motan2", Host: address}}
+	assert.Nil(t, server.Open(false, false, newTestHandler(p), ext))
+	defer server.Destroy()
+	assert.Equal(t, "", listenAddr(server.URL))
+
+	url := &motan.URL{Protocol: "motan2", Host: address, Path: "unixService", Parameters: map[string]string{}}
+	assert.Equal(t, address, url.GetAddressStr())
+	ep := &endpoint.MotanEndpoint{}
+	ep.SetURL(url)
+	ep.SetSerialization(&serialize.SimpleSerialization{})
+	ep.Initialize()
+	defer ep.Destroy()
+	assert.True(t, ep.IsAvailable())
+
+	var reply string
+	request := &motan.MotanRequest{RequestID: 1, ServiceName: "unixService", Method: "test", Attachment: motan.NewStringMap(0)}
+	request.GetRPCContext(true).Reply = &reply
+	res := ep.Call(request)
+	assert.Nil(t, res.GetException())
+	assert.Equal(t, "ok", reply)
+	assert.Equal(t, "127.0.0.1", <-hosts)
+}
+
+func TestBatchRequest(t *testing.T) {
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	serialize.RegistDefaultSerializations(ext)
+	provider := newTestProvider("test", nil)
+	provider.callFunc = func(request motan.Request) motan.Response {
+		// the requests of a batch frame are processed concurrently
+		if request.GetMethod() == "slow" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: request.GetMethod()}
+	}
+	server := &MotanServer{URL: &motan.URL{Protocol: "motan2", Host: "127.0.0.1", Port: 64615}}
+	assert.Nil(t, server.Open(false, false, newTestHandler(provider), ext))
+	defer server.Destroy()
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:64615", time.Second)
+	assert.Nil(t, err)
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	frames := make([][]byte, 0, 2)
+	for i, method := range []string{"slow", "fast"} {
+		msg, err := mpro.ConvertToReqMessage(&motan.MotanRequest{RequestID: uint64(41 + i), ServiceName: "test", Method: method}, &serialize.SimpleSerialization{})
+		assert.Nil(t, err)
+		frames = append(frames, msg.Encode().Bytes())
+	}
+	_, err = conn.Write(mpro.BuildBatch(frames).Encode().Bytes())
+	assert.Nil(t, err)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var ids []uint64
+	for i := 0; i < 2; i++ {
+		res, err := mpro.Decode(reader)
+		assert.Nil(t, err)
+		assert.Equal(t, mpro.Normal, res.Header.GetStatus())
+		ids = append(ids, res.Header.RequestID)
+	}
+	assert.Equal(t, []uint64{42, 41}, ids)
+}
+
+func TestRejectOversizedMetadata(t *testing.T) {
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	serialize.RegistDefaultSerializations(ext)
+	provider := newTestProvider("test", nil)
+	provider.callFunc = func(request motan.Request) motan.Response {
+		// the reserved keys through the http headers are normalized
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: request.GetAttachment("M_s")}
+	}
+	server := &MotanServer{URL: &motan.URL{Protocol: "motan2", Host: "127.0.0.1", Port: 64616, Parameters: map[string]string{motan.MaxMetadataSizeKey: "128"}}}
+	assert.Nil(t, server.Open(false, false, newTestHandler(provider), ext))
+	defer server.Destroy()
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:64616", time.Second)
+	assert.Nil(t, err)
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	call := func(id uint64, attachments map[string]string) *mpro.Message {
+		request := &motan.MotanRequest{RequestID: id, ServiceName: "test", Method: "hello"}
+		for k, v := range attachments {
+			request.SetAttachment(k, v)
+		}
+		msg, err := mpro.ConvertToReqMessage(request, &serialize.SimpleSerialization{})
+		assert.Nil(t, err)
+		_, err = conn.Write(msg.Encode().Bytes())
+		assert.Nil(t, err)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		res, err := mpro.Decode(reader)
+		assert.Nil(t, err)
+		return res
+	}
+
+	res := call(51, map[string]string{"large": strings.Repeat("a", 128)})
+	assert.Equal(t, uint64(51), res.Header.RequestID)
+	assert.Equal(t, mpro.Exception, res.Header.GetStatus())
+	assert.Contains(t, res.Metadata.LoadOrEmpty(mpro.MExceptionn), "exceeds the limit 128")
+
+	res = call(52, map[string]string{"Motan-S": "source"})
+	assert.Equal(t, mpro.Normal, res.Header.GetStatus())
+	response, err := mpro.ConvertToResponse(res, &serialize.SimpleSerialization{})
+	assert.Nil(t, err)
+	v, err := response.GetValue().(*motan.DeserializableValue).Deserialize(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "source", v)
+}
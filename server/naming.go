@@ -0,0 +1,102 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+
+	motan "github.com/weibocom/motan-go/core"
+)
+
+// NamingValidator checks the names of a provider at export, the export fails with the returned error.
+// methods is nil if the provider has no known method set
+type NamingValidator func(url *motan.URL, methods []string) error
+
+var (
+	namingValidator     NamingValidator
+	namingValidatorLock sync.RWMutex
+)
+
+// SetNamingValidator sets the validator of all the exports and the methods added at runtime, nil disables the validation
+func SetNamingValidator(validator NamingValidator) {
+	namingValidatorLock.Lock()
+	defer namingValidatorLock.Unlock()
+	namingValidator = validator
+}
+
+func getNamingValidator() NamingValidator {
+	namingValidatorLock.RLock()
+	defer namingValidatorLock.RUnlock()
+	return namingValidator
+}
+
+// NamingRules is the regular expressions the names must match, empty means any name
+type NamingRules struct {
+	Path   string
+	Group  string
+	Method string
+}
+
+// NewNamingValidator returns the validator of the rules, it fails if a rule is not a legal regular expression
+func NewNamingValidator(rules NamingRules) (NamingValidator, error) {
+	compile := func(name string, rule string) (*regexp.Regexp, error) {
+		if rule == "" {
+			return nil, nil
+		}
+		re, err := regexp.Compile(rule)
+		if err != nil {
+			return nil, fmt.Errorf("illegal %s naming rule: %s, err: %v", name, rule, err)
+		}
+		return re, nil
+	}
+	path, err := compile("path", rules.Path)
+	if err != nil {
+		return nil, err
+	}
+	group, err := compile("group", rules.Group)
+	if err != nil {
+		return nil, err
+	}
+	method, err := compile("method", rules.Method)
+	if err != nil {
+		return nil, err
+	}
+	return func(url *motan.URL, methods []string) error {
+		if path != nil && !path.MatchString(url.Path) {
+			return fmt.Errorf("service path %s violates the naming rule %s", url.Path, rules.Path)
+		}
+		if group != nil && !group.MatchString(url.Group) {
+			return fmt.Errorf("group %s of service %s violates the naming rule %s", url.Group, url.Path, rules.Group)
+		}
+		if method != nil {
+			for _, m := range methods {
+				if !method.MatchString(m) {
+					return fmt.Errorf("method %s of service %s violates the naming rule %s", m, url.Path, rules.Method)
+				}
+			}
+		}
+		return nil
+	}, nil
+}
+
+// checkNaming validates the names of the provider by the naming validator, it passes if no validator is set
+func checkNaming(p motan.Provider) error {
+	validator := getNamingValidator()
+	if validator == nil {
+		return nil
+	}
+	var methods []string
+	if mp := knownMethods(p); mp != nil {
+		methods = mp.GetMethodNames()
+	}
+	return validator(p.GetURL(), methods)
+}
+
+// checkMethodNaming validates the name of a method added at runtime
+func checkMethodNaming(p motan.Provider, method string) error {
+	validator := getNamingValidator()
+	if validator == nil {
+		return nil
+	}
+	return validator(p.GetURL(), []string{method})
+}
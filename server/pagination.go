@@ -0,0 +1,162 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"strconv"
+	"strings"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// PaginateMethodsKey is the provider url parameter of automatic pagination, the value is a json map of method name to page size,
+// e.g. {"list":100}. the list result of the method is sliced into pages, the cursor of the next page is in the response attachment
+// PageCursorAttachment, and the client passes it back in the request attachment PageCursorAttachment to get the next page.
+// cursors are stateless, the method is called for every page, so it should return the same list for the same arguments
+const PaginateMethodsKey = "paginateMethods"
+
+const (
+	// PageCursorAttachment is the request attachment of the page to get, and the response attachment of the next page.
+	// the response has no cursor if it is the last page
+	PageCursorAttachment = "M_cursor"
+	// PageTotalAttachment is the response attachment of the total item count
+	PageTotalAttachment = "M_total"
+)
+
+// ItemSlicer is implemented by the ItemExtractor which can slice the items for pagination, the default extractor slices slices
+type ItemSlicer interface {
+	// Slice returns the items in [start, end), ok is false if the value can not be sliced
+	Slice(value interface{}, start int, end int) (sliced interface{}, ok bool)
+}
+
+func (reflectItemExtractor) Slice(value interface{}, start int, end int) (interface{}, bool) {
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice {
+		return nil, false
+	}
+	return v.Slice(start, end).Interface(), true
+}
+
+func parsePaginateMethods(url *motan.URL) (map[string]int, error) {
+	value := url.GetParam(PaginateMethodsKey, "")
+	if value == "" {
+		return nil, nil
+	}
+	var sizes map[string]int
+	if err := json.Unmarshal([]byte(value), &sizes); err != nil {
+		return nil, fmt.Errorf("illegal %s: %s, err: %v", PaginateMethodsKey, value, err)
+	}
+	for method, size := range sizes {
+		if method == "" {
+			return nil, errors.New("illegal " + PaginateMethodsKey + ": empty method name")
+		}
+		if size <= 0 {
+			return nil, fmt.Errorf("illegal %s: page size of method %s must be positive", PaginateMethodsKey, method)
+		}
+	}
+	return sizes, nil
+}
+
+// PaginationProviderWrapper slices the list results of the configured methods into pages
+type PaginationProviderWrapper struct {
+	baseProviderWrapper
+	sizes map[string]int
+}
+
+// WrapWithPagination returns the provider itself if no method is configured for pagination
+func WrapWithPagination(provider motan.Provider) motan.Provider {
+	sizes, err := parsePaginateMethods(provider.GetURL())
+	if err != nil {
+		vlog.Warningf("pagination of provider %s ignored. err: %v", provider.GetPath(), err)
+		return provider
+	}
+	if len(sizes) == 0 {
+		return provider
+	}
+	vlog.Infof("pagination enabled for provider %s, methods: %s", provider.GetPath(), provider.GetURL().GetParam(PaginateMethodsKey, ""))
+	return &PaginationProviderWrapper{baseProviderWrapper: baseProviderWrapper{provider: provider}, sizes: sizes}
+}
+
+func (p *PaginationProviderWrapper) pageSize(method string) int {
+	if size, ok := p.sizes[method]; ok {
+		return size
+	}
+	return p.sizes[motan.FirstUpper(method)]
+}
+
+func (p *PaginationProviderWrapper) Call(request motan.Request) motan.Response {
+	size := p.pageSize(request.GetMethod())
+	if size == 0 {
+		return p.provider.Call(request)
+	}
+	// the cursor is bound to the arguments, so it can not be used to page through the result of another query
+	fingerprint := queryFingerprint(request)
+	offset := 0
+	if cursor := request.GetAttachment(PageCursorAttachment); cursor != "" {
+		var err error
+		if offset, err = decodeCursor(cursor, fingerprint); err != nil {
+			return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 400, ErrMsg: err.Error(), ErrType: motan.ServiceException})
+		}
+	}
+	res := p.provider.Call(request)
+	if res == nil || res.GetException() != nil || res.GetValue() == nil {
+		return res
+	}
+	extractor := getItemExtractor(request.GetServiceName())
+	slicer, ok := extractor.(ItemSlicer)
+	if !ok {
+		return res
+	}
+	count, ok := extractor.Count(res.GetValue())
+	if !ok {
+		return res
+	}
+	start, end := offset, offset+size
+	if start > count {
+		start = count
+	}
+	if end > count {
+		end = count
+	}
+	page, ok := slicer.Slice(res.GetValue(), start, end)
+	if !ok {
+		return res
+	}
+	paged := copyResponseWithValue(res, page)
+	paged.SetAttachment(PageTotalAttachment, strconv.Itoa(count))
+	if end < count {
+		paged.SetAttachment(PageCursorAttachment, encodeCursor(end, fingerprint))
+	}
+	return paged
+}
+
+func queryFingerprint(request motan.Request) string {
+	h := fnv.New64a()
+	h.Write([]byte(request.GetServiceName() + memoizeKeySep + memoizeKey(request)))
+	return strconv.FormatUint(h.Sum64(), 36)
+}
+
+func encodeCursor(offset int, fingerprint string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset) + ":" + fingerprint))
+}
+
+func decodeCursor(cursor string, fingerprint string) (int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, errors.New("illegal page cursor: " + cursor)
+	}
+	parts := strings.SplitN(string(b), ":", 2)
+	offset, err := strconv.Atoi(parts[0])
+	if err != nil || offset < 0 || len(parts) != 2 {
+		return 0, errors.New("illegal page cursor: " + cursor)
+	}
+	if parts[1] != fingerprint {
+		return 0, errors.New("page cursor does not match the request: " + cursor)
+	}
+	return offset, nil
+}
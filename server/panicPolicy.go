@@ -0,0 +1,141 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// OnPanicKey is the provider url parameter of the behavior when the provider panics
+const OnPanicKey = "onPanic"
+
+const (
+	// PanicPolicyRecover returns an exception response and keeps serving, it is the default policy
+	PanicPolicyRecover = "recover"
+	// PanicPolicyCrash terminates the process, for the providers whose panic indicates corrupted data
+	PanicPolicyCrash = "crash"
+	// PanicPolicyUnavailable marks the provider unavailable and unregisters it, the following requests are rejected with 503
+	PanicPolicyUnavailable = "unavailable"
+)
+
+// ProviderPanicHandler is called with the recovered value and the goroutine stack when a provider panics, e.g. to report
+// the panic to an error tracker. it is called in the request goroutine before the exception is responded, so it should not block
+type ProviderPanicHandler func(request motan.Request, recovered interface{}, stack []byte)
+
+var providerPanicHandler atomic.Value // ProviderPanicHandler
+
+// OnProviderPanic sets the handler of the provider panics, nil removes it. the panics of the handler itself are recovered
+func OnProviderPanic(handler ProviderPanicHandler) {
+	providerPanicHandler.Store(handler)
+}
+
+func notifyProviderPanic(request motan.Request, info *motan.PanicInfo) {
+	handler, ok := providerPanicHandler.Load().(ProviderPanicHandler)
+	if !ok || handler == nil {
+		return
+	}
+	// not recovered by HandlePanic, so the panic of the handler is not reported again
+	defer func() {
+		if err := recover(); err != nil {
+			vlog.Errorf("provider panic handler panic. req:%s, error:%v", motan.GetReqInfo(request), err)
+		}
+	}()
+	handler(request, info.Err, info.Stack)
+}
+
+// crashProcess panics in a new goroutine, so the panic can not be recovered by the callers
+var crashProcess = func(err interface{}) {
+	go func() {
+		panic(err)
+	}()
+}
+
+func parsePanicPolicy(url *motan.URL) (string, error) {
+	switch policy := url.GetParam(OnPanicKey, PanicPolicyRecover); policy {
+	case PanicPolicyRecover, PanicPolicyCrash, PanicPolicyUnavailable:
+		return policy, nil
+	default:
+		return "", errors.New("illegal " + OnPanicKey + ": " + policy)
+	}
+}
+
+// PanicPolicyProviderWrapper applies the crash or unavailable policy when the provider panics
+type PanicPolicyProviderWrapper struct {
+	baseProviderWrapper
+	policy      string
+	unavailable int32
+	lock        sync.Mutex
+	exporter    *DefaultExporter
+}
+
+// WrapWithPanicPolicy returns the provider itself if the policy is recover
+func WrapWithPanicPolicy(provider motan.Provider) motan.Provider {
+	policy, err := parsePanicPolicy(provider.GetURL())
+	if err != nil {
+		vlog.Warningf("panic policy of provider %s ignored. err: %v", provider.GetPath(), err)
+		return provider
+	}
+	if policy == PanicPolicyRecover {
+		return provider
+	}
+	vlog.Infof("panic policy of provider %s: %s", provider.GetPath(), policy)
+	return &PanicPolicyProviderWrapper{baseProviderWrapper: baseProviderWrapper{provider: provider}, policy: policy}
+}
+
+// panicPolicyProvider returns the provider itself or the wrapped provider which applies a panic policy
+func panicPolicyProvider(p motan.Provider) *PanicPolicyProviderWrapper {
+	for p != nil {
+		if w, ok := p.(*PanicPolicyProviderWrapper); ok {
+			return w
+		}
+		w, ok := p.(providerWrapper)
+		if !ok {
+			return nil
+		}
+		p = w.unwrap()
+	}
+	return nil
+}
+
+func (w *PanicPolicyProviderWrapper) bind(exporter *DefaultExporter) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.exporter = exporter
+}
+
+func (w *PanicPolicyProviderWrapper) IsAvailable() bool {
+	return atomic.LoadInt32(&w.unavailable) == 0 && w.provider.IsAvailable()
+}
+
+func (w *PanicPolicyProviderWrapper) Call(request motan.Request) (res motan.Response) {
+	if atomic.LoadInt32(&w.unavailable) == 1 {
+		return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 503, ErrMsg: "provider is unavailable after panic: " + w.GetPath(), ErrType: motan.ServiceException})
+	}
+	defer motan.HandleRequestPanicInfo(request, func(info *motan.PanicInfo) {
+		notifyProviderPanic(request, info)
+		w.onPanic(request)
+		res = motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 500, ErrMsg: "provider call panic", ErrType: motan.ServiceException})
+	})
+	return w.provider.Call(request)
+}
+
+// onPanic is called after the panic is recovered and logged by HandleRequestPanic
+func (w *PanicPolicyProviderWrapper) onPanic(request motan.Request) {
+	vlog.Errorf("provider call panic, apply policy %s. req:%s", w.policy, motan.GetReqInfo(request))
+	if w.policy == PanicPolicyCrash {
+		crashProcess("provider " + w.GetPath() + " panic with policy crash, req: " + motan.GetReqInfo(request))
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&w.unavailable, 0, 1) {
+		return
+	}
+	w.lock.Lock()
+	exporter := w.exporter
+	w.lock.Unlock()
+	if exporter != nil {
+		go exporter.unregister()
+	}
+}
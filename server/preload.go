@@ -0,0 +1,59 @@
+package server
+
+import (
+	"encoding/json"
+	"sync"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// MaxPreloadHintsKey is the provider url parameter of the max count of preload hints returned in a response
+const MaxPreloadHintsKey = "maxPreloadHints"
+
+const defaultMaxPreloadHints = 100
+
+// PreloadHintsFunc returns the keys or resources the caller of the request should preload, e.g. the hot keys of the caller
+type PreloadHintsFunc func(request motan.Request) []string
+
+var (
+	preloadHintsFuncs    = make(map[string]PreloadHintsFunc)
+	preloadHintsFuncLock sync.RWMutex
+)
+
+// RegisterPreloadHintsFunc register the preload hints func of a service(path), it is called by DefaultMessageHandler
+// for the requests asking for hints with motan.PreloadHintsRequestAttachment
+func RegisterPreloadHintsFunc(service string, f PreloadHintsFunc) {
+	preloadHintsFuncLock.Lock()
+	defer preloadHintsFuncLock.Unlock()
+	if f == nil {
+		delete(preloadHintsFuncs, service)
+		return
+	}
+	preloadHintsFuncs[service] = f
+}
+
+func addPreloadHints(url *motan.URL, request motan.Request, res motan.Response) {
+	if res == nil || res.GetException() != nil || request.GetAttachment(motan.PreloadHintsRequestAttachment) == "" {
+		return
+	}
+	preloadHintsFuncLock.RLock()
+	f := preloadHintsFuncs[request.GetServiceName()]
+	preloadHintsFuncLock.RUnlock()
+	if f == nil {
+		return
+	}
+	hints := f(request)
+	if len(hints) == 0 {
+		return
+	}
+	if max := int(url.GetPositiveIntValue(MaxPreloadHintsKey, defaultMaxPreloadHints)); len(hints) > max {
+		hints = hints[:max]
+	}
+	b, err := json.Marshal(hints)
+	if err != nil {
+		vlog.Warningf("marshal preload hints fail. req:%s, err:%v", motan.GetReqInfo(request), err)
+		return
+	}
+	res.SetAttachment(motan.PreloadHintsAttachment, string(b))
+}
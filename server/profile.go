@@ -0,0 +1,30 @@
+package server
+
+import (
+	"context"
+	"runtime/pprof"
+
+	motan "github.com/weibocom/motan-go/core"
+)
+
+// ProfileLabelsKey is the provider url parameter to label the goroutines serving the requests with the service and the method,
+// so the cpu profiles are attributed to the services. it is disabled by default because of the overhead of labeling
+const ProfileLabelsKey = "profileLabels"
+
+// the pprof labels of the goroutines serving requests
+const (
+	ProfileLabelService = "service"
+	ProfileLabelMethod  = "method"
+)
+
+// callWithProfileLabels runs the call with the pprof labels of the request if the provider enables them,
+// the goroutines started by the call inherit the labels
+func callWithProfileLabels(url *motan.URL, request motan.Request, call func()) {
+	if url.GetParam(ProfileLabelsKey, "") != "true" {
+		call()
+		return
+	}
+	pprof.Do(context.Background(), pprof.Labels(ProfileLabelService, request.GetServiceName(), ProfileLabelMethod, request.GetMethod()), func(context.Context) {
+		call()
+	})
+}
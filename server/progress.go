@@ -0,0 +1,46 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	mpro "github.com/weibocom/motan-go/protocol"
+)
+
+var errProgressFinished = errors.New("progress reported after the final response")
+
+// progressReporter writes progress messages of a request to the connection until the final response is sent
+type progressReporter struct {
+	lock      sync.Mutex
+	conn      net.Conn
+	requestID uint64
+	serialize int
+	finished  bool
+}
+
+func newProgressReporter(conn net.Conn, requestID uint64, serialize int) *progressReporter {
+	return &progressReporter{conn: conn, requestID: requestID, serialize: serialize}
+}
+
+func (p *progressReporter) report(event *motan.ProgressEvent) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.finished {
+		return errProgressFinished
+	}
+	msg := mpro.BuildProgress(p.requestID, p.serialize, event.Percent, event.Message)
+	p.conn.SetWriteDeadline(time.Now().Add(motan.DefaultWriteTimeout))
+	buf := msg.Encode()
+	_, err := p.conn.Write(buf.Bytes())
+	motan.ReleaseBytesBuffer(buf)
+	return err
+}
+
+func (p *progressReporter) finish() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.finished = true
+}
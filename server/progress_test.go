@@ -0,0 +1,60 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/endpoint"
+	"github.com/weibocom/motan-go/serialize"
+)
+
+func TestProgress(t *testing.T) {
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	serialize.RegistDefaultSerializations(ext)
+	p := newTestProvider("progressService", nil)
+	p.callFunc = func(request motan.Request) motan.Response {
+		for i := 1; i <= 3; i++ {
+			if err := motan.ReportProgress(request, i*30, "step"); err != nil {
+				return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: "no progress"}
+			}
+		}
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: "done"}
+	}
+	server := &MotanServer{URL: &motan.URL{Protocol: "motan2", Host: "127.0.0.1", Port: 64586}}
+	assert.Nil(t, server.Open(false, false, newTestHandler(p), ext))
+	defer server.Destroy()
+
+	url := &motan.URL{Protocol: "motan2", Host: "127.0.0.1", Port: 64586, Path: "progressService"}
+	url.PutParam(motan.TimeOutKey, "1000")
+	ep := &endpoint.MotanEndpoint{}
+	ep.SetURL(url)
+	ep.SetSerialization(&serialize.SimpleSerialization{})
+	ep.Initialize()
+	defer ep.Destroy()
+	time.Sleep(50 * time.Millisecond)
+
+	var events []*motan.ProgressEvent
+	request := &motan.MotanRequest{RequestID: 1, ServiceName: "progressService", Method: "export", Attachment: motan.NewStringMap(0)}
+	var reply string
+	request.GetRPCContext(true).Reply = &reply
+	request.GetRPCContext(true).ProgressListener = func(event *motan.ProgressEvent) {
+		events = append(events, event)
+	}
+	res := ep.Call(request)
+	assert.Nil(t, res.GetException())
+	assert.Equal(t, "done", reply)
+	assert.Equal(t, 3, len(events))
+	assert.Equal(t, 90, events[2].Percent)
+	assert.Equal(t, "step", events[2].Message)
+
+	// no progress for clients not listening
+	reply = ""
+	request = &motan.MotanRequest{RequestID: 2, ServiceName: "progressService", Method: "export", Attachment: motan.NewStringMap(0)}
+	request.GetRPCContext(true).Reply = &reply
+	res = ep.Call(request)
+	assert.Nil(t, res.GetException())
+	assert.Equal(t, "no progress", reply)
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// quiesce provider url parameter keys
+const (
+	MaxRequestsKey         = "maxRequests"         // the provider quiesces after serving max requests, e.g. to recycle a provider with leaks
+	QuiesceDrainTimeoutKey = "quiesceDrainTimeout" // ms, max time to wait for the requests in processing before unexport
+)
+
+const defaultQuiesceDrainTimeout = 10 * time.Second
+
+// QuiesceProviderWrapper counts the requests of a provider, when the max requests is reached the provider becomes unavailable,
+// the exporter is unregistered, and unexported after the requests in processing are drained.
+// requests beyond the max are rejected with 503, so the clients retry other servers
+type QuiesceProviderWrapper struct {
+	baseProviderWrapper
+	max          int64
+	drainTimeout time.Duration
+	served       int64
+	inflight     int64
+	lock         sync.Mutex
+	exporter     *DefaultExporter
+}
+
+// WrapWithQuiesce returns the provider itself if max requests is not configured
+func WrapWithQuiesce(provider motan.Provider) motan.Provider {
+	url := provider.GetURL()
+	max := url.GetIntValue(MaxRequestsKey, 0)
+	if max <= 0 {
+		return provider
+	}
+	vlog.Infof("provider %s will quiesce after %d requests", provider.GetPath(), max)
+	return &QuiesceProviderWrapper{
+		baseProviderWrapper: baseProviderWrapper{provider: provider},
+		max:                 max,
+		drainTimeout:        url.GetTimeDuration(QuiesceDrainTimeoutKey, time.Millisecond, defaultQuiesceDrainTimeout),
+	}
+}
+
+// quiesceProvider returns the provider itself or the wrapped provider which quiesces after max requests
+func quiesceProvider(p motan.Provider) *QuiesceProviderWrapper {
+	for p != nil {
+		if q, ok := p.(*QuiesceProviderWrapper); ok {
+			return q
+		}
+		w, ok := p.(providerWrapper)
+		if !ok {
+			return nil
+		}
+		p = w.unwrap()
+	}
+	return nil
+}
+
+func (q *QuiesceProviderWrapper) bind(exporter *DefaultExporter) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.exporter = exporter
+}
+
+func (q *QuiesceProviderWrapper) IsAvailable() bool {
+	return !q.Quiesced() && q.provider.IsAvailable()
+}
+
+// Quiesced returns true if the max requests is reached
+func (q *QuiesceProviderWrapper) Quiesced() bool {
+	return atomic.LoadInt64(&q.served) >= q.max
+}
+
+func (q *QuiesceProviderWrapper) Call(request motan.Request) motan.Response {
+	// count in processing first, so the drain can not miss a request passing the threshold check
+	atomic.AddInt64(&q.inflight, 1)
+	defer atomic.AddInt64(&q.inflight, -1)
+	served := atomic.AddInt64(&q.served, 1)
+	if served > q.max {
+		return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 503, ErrMsg: "provider is quiescing: " + q.GetPath(), ErrType: motan.ServiceException})
+	}
+	if served == q.max {
+		go q.quiesce()
+	}
+	return q.provider.Call(request)
+}
+
+// quiesce unregister the exporter, drain the requests in processing, then unexport and destroy the provider
+func (q *QuiesceProviderWrapper) quiesce() {
+	defer motan.HandlePanic(nil)
+	q.lock.Lock()
+	exporter := q.exporter
+	q.lock.Unlock()
+	vlog.Infof("provider %s reached max requests %d, quiescing", q.GetPath(), q.max)
+	if exporter == nil {
+		return
+	}
+	exporter.unregister()
+	ctx, cancel := context.WithTimeout(context.Background(), q.drainTimeout)
+	defer cancel()
+	if err := q.drain(ctx); err != nil {
+		vlog.Warningf("drain provider %s fail, requests in processing will be dropped. err:%v", q.GetPath(), err)
+	}
+	exporter.Unexport()
+	vlog.Infof("provider %s quiesced", q.GetPath())
+}
+
+func (q *QuiesceProviderWrapper) drain(ctx context.Context) error {
+	return drainInflight(ctx, &q.inflight)
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+)
+
+func TestQuiesce(t *testing.T) {
+	p := newTestProvider("s", nil)
+	assert.Equal(t, p, WrapWithQuiesce(p))
+
+	events := &shutdownEvents{}
+	p = newTestProvider("s", map[string]string{MaxRequestsKey: "3", QuiesceDrainTimeoutKey: "500"})
+	started, release := make(chan struct{}), make(chan struct{})
+	p.callFunc = func(request motan.Request) motan.Response {
+		if request.GetMethod() == "slow" {
+			close(started)
+			<-release
+		}
+		events.add("call finish")
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: "ok"}
+	}
+	p.destroyFunc = func() {
+		events.add("provider destroy")
+	}
+	provider := WrapWithFilter(p, nil, nil)
+	q := quiesceProvider(provider)
+	assert.NotNil(t, q)
+	handler := newTestHandler(provider)
+	exporter := &DefaultExporter{provider: provider, server: &MotanServer{handler: handler}, url: p.GetURL(), exported: true, available: true, registered: true,
+		Registries: []motan.Registry{&recordRegistry{events: events}}}
+	q.bind(exporter)
+
+	slowRes := make(chan motan.Response, 1)
+	go func() {
+		slowRes <- provider.Call(newTestRequest("s", "slow"))
+	}()
+	<-started
+	assert.Nil(t, handler.Call(newTestRequest("s", "m")).GetException())
+	assert.Nil(t, handler.Call(newTestRequest("s", "m")).GetException())
+	assert.True(t, q.Quiesced())
+	assert.False(t, provider.IsAvailable())
+	res := handler.Call(newTestRequest("s", "m"))
+	assert.Equal(t, 503, res.GetException().ErrCode)
+
+	// unregistered, but not unexported until the slow request finished
+	time.Sleep(100 * time.Millisecond)
+	assert.False(t, exporter.IsAvailable())
+	assert.NotNil(t, handler.GetProvider("s"))
+	close(release)
+	assert.Nil(t, (<-slowRes).GetException())
+	for i := 0; i < 100 && len(events.get()) < 5; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, []string{"call finish", "call finish", "unregister", "call finish", "provider destroy"}, events.get())
+	assert.Nil(t, handler.GetProvider("s"))
+}
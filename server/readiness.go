@@ -0,0 +1,81 @@
+package server
+
+import (
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// ReadinessTimeoutKey is the provider url parameter of the max duration(ms) to wait for the provider ready, see
+// DefaultExporter.SetReadyFunc. the url is kept unavailable if the provider is not ready in time, 0 waits until ready
+const ReadinessTimeoutKey = "readinessTimeout"
+
+// the ready func is checked again every readinessInterval until the provider is ready
+var readinessInterval = 100 * time.Millisecond
+
+// SetReadyFunc sets the readiness gate of the provider, it should be called before Export. the url is registered by
+// Export but kept unavailable until the func returns true, then it is set available and notified to the registries.
+// a panic of the func is treated as not ready
+func (d *DefaultExporter) SetReadyFunc(ready func() bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.readyFunc = ready
+}
+
+func isReady(readyFunc func() bool) (ready bool) {
+	defer motan.HandlePanic(func() {
+		ready = false
+	})
+	return readyFunc == nil || readyFunc()
+}
+
+// waitReady sets the url available after the provider is ready, it should be called with the lock held
+func (d *DefaultExporter) waitReady() {
+	d.stopWaitReady()
+	readyFunc := d.readyFunc
+	timeout := d.url.GetTimeDuration(ReadinessTimeoutKey, time.Millisecond, 0)
+	interval := readinessInterval
+	cancel := make(chan struct{})
+	d.readyCancel = cancel
+	vlog.Infof("url %s is unavailable until the provider is ready, timeout: %v", d.url.GetIdentity(), timeout)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var deadline <-chan time.Time
+		if timeout > 0 {
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			deadline = timer.C
+		}
+		for {
+			select {
+			case <-ticker.C:
+			case <-deadline:
+				vlog.Errorf("provider of url %s is not ready after %v, the url is kept unavailable", d.url.GetIdentity(), timeout)
+				return
+			case <-cancel:
+				return
+			}
+			if !isReady(readyFunc) {
+				continue
+			}
+			d.lock.Lock()
+			if d.readyCancel == cancel {
+				d.readyCancel = nil
+				vlog.Infof("provider of url %s is ready, set it available", d.url.GetIdentity())
+				d.updateAvailable(true)
+			}
+			d.lock.Unlock()
+			return
+		}
+	}()
+}
+
+// stopWaitReady cancels the pending readiness wait, it should be called with the lock held
+func (d *DefaultExporter) stopWaitReady() {
+	if d.readyCancel != nil {
+		close(d.readyCancel)
+		d.readyCancel = nil
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+)
+
+func TestReadiness(t *testing.T) {
+	defer func(interval time.Duration) { readinessInterval = interval }(readinessInterval)
+	readinessInterval = 10 * time.Millisecond
+	events := &shutdownEvents{}
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	ext.RegistExtRegistry("record", func(url *motan.URL) motan.Registry {
+		return &recordRegistry{events: events}
+	})
+	context := &motan.Context{RegistryURLs: map[string]*motan.URL{"r": {Protocol: "record", Host: "127.0.0.1"}}}
+	export := func(path string, timeout string, ready func() bool) *DefaultExporter {
+		p := newTestProvider(path, map[string]string{motan.RegistryKey: "r", ReadinessTimeoutKey: timeout})
+		exporter := &DefaultExporter{}
+		exporter.SetProvider(p)
+		exporter.SetReadyFunc(ready)
+		assert.Nil(t, exporter.Export(&MotanServer{URL: &motan.URL{}, handler: newTestHandler(p)}, ext, context))
+		return exporter
+	}
+
+	// ready after a delay
+	var ready int32
+	exporter := export("ready", "1000", func() bool { return atomic.LoadInt32(&ready) == 1 })
+	assert.False(t, exporter.IsAvailable())
+	assert.Equal(t, []string{"register", "unavailable"}, events.get())
+	time.Sleep(50 * time.Millisecond)
+	assert.False(t, exporter.IsAvailable())
+	atomic.StoreInt32(&ready, 1)
+	for i := 0; i < 100 && !exporter.IsAvailable(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, exporter.IsAvailable())
+	assert.Equal(t, []string{"register", "unavailable", "available"}, events.get())
+	exporter.Unexport()
+
+	// never ready, the url is kept unavailable after the timeout
+	count := len(events.get())
+	exporter = export("never", "50", func() bool { return false })
+	time.Sleep(150 * time.Millisecond)
+	assert.False(t, exporter.IsAvailable())
+	assert.Equal(t, []string{"register", "unavailable"}, events.get()[count:])
+	exporter.Unexport()
+
+	// the panic of the ready func is not ready, and the ready provider is available at once
+	exporter = export("panic", "50", func() bool { panic("not loaded") })
+	assert.False(t, exporter.IsAvailable())
+	exporter.Unexport()
+	count = len(events.get())
+	exporter = export("readyAtOnce", "0", func() bool { return true })
+	assert.True(t, exporter.IsAvailable())
+	assert.Equal(t, []string{"register"}, events.get()[count:])
+	exporter.Unexport()
+}
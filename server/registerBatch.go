@@ -0,0 +1,142 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// the registry url parameters of the registration batching, so the processes exporting many services do not register them
+// to the registry one by one at startup. the registrations within the window are registered in one operation if the registry
+// is a motan.BatchRegistry, otherwise they are registered one by one at most rate per second
+const (
+	RegisterBatchWindowKey = "registerBatchWindow" // ms
+	RegisterRateKey        = "registerRate"
+)
+
+type pendingRegistration struct {
+	url       *motan.URL
+	available func() bool
+}
+
+// registerBatcher registers the urls to a registry in batches, the urls not registered yet can be cancelled
+type registerBatcher struct {
+	registry motan.Registry
+	window   time.Duration
+	interval time.Duration // between individual registrations, 0 means no limit
+	lock     sync.Mutex
+	pending  []pendingRegistration
+	flushing bool
+}
+
+var (
+	registerBatchers     = make(map[motan.Registry]*registerBatcher)
+	registerBatchersLock sync.Mutex
+)
+
+// getRegisterBatcher returns nil if the registry does not batch registrations
+func getRegisterBatcher(registry motan.Registry) *registerBatcher {
+	url := registry.GetURL()
+	if url == nil {
+		return nil
+	}
+	window := time.Duration(url.GetPositiveIntValue(RegisterBatchWindowKey, 0)) * time.Millisecond
+	rate := url.GetPositiveIntValue(RegisterRateKey, 0)
+	if window == 0 && rate == 0 {
+		return nil
+	}
+	registerBatchersLock.Lock()
+	defer registerBatchersLock.Unlock()
+	b := registerBatchers[registry]
+	if b == nil {
+		b = &registerBatcher{registry: registry, window: window}
+		if rate > 0 {
+			b.interval = time.Second / time.Duration(rate)
+		}
+		registerBatchers[registry] = b
+	}
+	return b
+}
+
+// add registers the url after the window, the url is set unavailable after registration if available returns false
+func (b *registerBatcher) add(url *motan.URL, available func() bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.pending = append(b.pending, pendingRegistration{url: url, available: available})
+	if !b.flushing {
+		b.flushing = true
+		time.AfterFunc(b.window, b.flush)
+	}
+}
+
+// cancel removes the url not registered yet, it returns false if the url is registered or not added
+func (b *registerBatcher) cancel(url *motan.URL) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for i, p := range b.pending {
+		if p.url == url {
+			b.pending = append(b.pending[:i:i], b.pending[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (b *registerBatcher) isPending(url *motan.URL) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, p := range b.pending {
+		if p.url == url {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *registerBatcher) flush() {
+	var registered []pendingRegistration
+	if br, ok := b.registry.(motan.BatchRegistry); ok {
+		// the lock is held while registering, so a cancelled url is never registered
+		b.lock.Lock()
+		registered, b.pending = b.pending, nil
+		urls := make([]*motan.URL, 0, len(registered))
+		for _, p := range registered {
+			urls = append(urls, p.url)
+		}
+		if len(urls) > 0 {
+			br.RegisterBatch(urls)
+			vlog.Infof("%d urls registered to registry %s in batch", len(urls), b.registry.GetURL().GetIdentity())
+		}
+		b.flushing = false
+		b.lock.Unlock()
+		b.notifyUnavailable(registered)
+		return
+	}
+	for {
+		b.lock.Lock()
+		if len(b.pending) == 0 {
+			b.flushing = false
+			b.lock.Unlock()
+			return
+		}
+		p := b.pending[0]
+		b.pending = b.pending[1:]
+		b.registry.Register(p.url)
+		b.lock.Unlock()
+		b.notifyUnavailable([]pendingRegistration{p})
+		if b.interval > 0 {
+			time.Sleep(b.interval)
+		}
+	}
+}
+
+// notifyUnavailable notifies the registry of the urls set unavailable before they are registered
+func (b *registerBatcher) notifyUnavailable(registered []pendingRegistration) {
+	for _, p := range registered {
+		if p.available != nil && !p.available() {
+			b.registry.Unavailable(p.url)
+		}
+	}
+}
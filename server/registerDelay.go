@@ -0,0 +1,81 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/weibocom/motan-go/log"
+)
+
+// RegisterDelayKey is the provider url parameter of registration delay in ms. the provider is served after export,
+// but registered to the registries when the delay elapsed or Ready is called, whichever comes first.
+// a negative delay means registering only when Ready is called
+const RegisterDelayKey = "registerDelay"
+
+var (
+	readyCh   = make(chan struct{})
+	readyOnce sync.Once
+	readyLock sync.RWMutex
+)
+
+// Ready tells the exporters with registration delay that the process has finished initialization
+func Ready() {
+	readyLock.RLock()
+	defer readyLock.RUnlock()
+	readyOnce.Do(func() {
+		close(readyCh)
+		vlog.Infoln("process is ready, delayed registrations start")
+	})
+}
+
+func readyChan() <-chan struct{} {
+	readyLock.RLock()
+	defer readyLock.RUnlock()
+	return readyCh
+}
+
+// delayRegister register the url to the registries after the delay or Ready, it is canceled if the exporter
+// is unexported or unregistered before that
+func (d *DefaultExporter) delayRegister(delay time.Duration) {
+	cancel := make(chan struct{})
+	d.registerCancel = cancel
+	ready := readyChan()
+	go func() {
+		var timeout <-chan time.Time
+		if delay > 0 {
+			timer := time.NewTimer(delay)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case <-timeout:
+		case <-ready:
+		case <-cancel:
+			return
+		}
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		if d.registerCancel != cancel {
+			return
+		}
+		d.registerCancel = nil
+		if err := d.registerAll(); err != nil {
+			return
+		}
+		vlog.Infof("delayed registration of url %s finished", d.url.GetIdentity())
+	}()
+}
+
+// cancelRegister cancel the pending delayed registration, the background registration retry and the warmup,
+// it should be called with the lock held
+func (d *DefaultExporter) cancelRegister() {
+	d.stopWarmup()
+	if d.registerCancel != nil {
+		close(d.registerCancel)
+		d.registerCancel = nil
+	}
+	if d.retryCancel != nil {
+		close(d.retryCancel)
+		d.retryCancel = nil
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// RegisterPolicyKey is the provider url parameter of the policy when the registration to some of the registries fails,
+// one of the RegisterPolicy values, default bestEffort. only the registries implementing motan.TryRegistry report the
+// failures, and the batched registrations are treated as succeeded
+const RegisterPolicyKey = "registerPolicy"
+
+// the RegisterPolicy values of RegisterPolicyKey
+const (
+	RegisterPolicyBestEffort = "bestEffort" // the url is kept in the registries succeeded, the failed ones are retried in background
+	RegisterPolicyAll        = "all"        // the url is registered to all registries or none of them, Export fails if any one fails
+	RegisterPolicyQuorum     = "quorum"     // like all, but the url is kept if more than half of the registries succeed, the others are retried
+)
+
+// the RegistryState values of the registrations of an exporter
+const (
+	RegistryStateRegistered = "registered" // the url is held by the registry
+	RegistryStatePending    = "pending"    // the url is waiting in the batch of the registry
+	RegistryStateRetrying   = "retrying"   // the registration failed and is retried in background
+	RegistryStateFailed     = "failed"     // the registration failed and is not retried by the policy
+)
+
+// RegistryState is the registration state of an url in a registry
+type RegistryState struct {
+	Registry string `json:"registry"`
+	State    string `json:"state"`
+}
+
+var registerPolicies = map[string]bool{RegisterPolicyBestEffort: true, RegisterPolicyAll: true, RegisterPolicyQuorum: true}
+
+func parseRegisterPolicy(url *motan.URL) (string, error) {
+	policy := url.GetParam(RegisterPolicyKey, RegisterPolicyBestEffort)
+	if !registerPolicies[policy] {
+		return "", errors.New("illegal " + RegisterPolicyKey + ": " + policy)
+	}
+	return policy, nil
+}
+
+// checkRegisterPolicy returns the error if the failed registrations are not allowed by the policy
+func checkRegisterPolicy(policy string, total int, failed int) error {
+	if failed == 0 {
+		return nil
+	}
+	switch policy {
+	case RegisterPolicyAll:
+		return fmt.Errorf("register fail to %d of %d registries, %s is %s", failed, total, RegisterPolicyKey, policy)
+	case RegisterPolicyQuorum:
+		if (total-failed)*2 <= total {
+			return fmt.Errorf("register fail to %d of %d registries, no quorum of %s %s", failed, total, RegisterPolicyKey, policy)
+		}
+	}
+	return nil
+}
+
+// rollbackRegister unregisters the url from the registries succeeded when the policy is not satisfied, it should be
+// called with the lock held
+func (d *DefaultExporter) rollbackRegister(err error) {
+	vlog.Errorf("register url %s fail, unregister it from all registries. err: %v", d.url.GetIdentity(), err)
+	for _, r := range d.Registries {
+		id := registryIdentity(r)
+		if b := getRegisterBatcher(r); b != nil {
+			if !b.cancel(d.url) {
+				r.UnRegister(d.url)
+			}
+		} else if d.registryStates[id] == RegistryStateRegistered {
+			r.UnRegister(d.url)
+		}
+		d.registryStates[id] = RegistryStateFailed
+	}
+}
+
+// setRegistryState should be called with the lock held
+func (d *DefaultExporter) setRegistryState(r motan.Registry, state string) {
+	if d.registryStates == nil {
+		d.registryStates = make(map[string]string, len(d.Registries))
+	}
+	d.registryStates[registryIdentity(r)] = state
+}
+
+// GetRegistryStates returns the registration states of the url in the registries, ordered by the registry. it is nil
+// if the url is not registered yet, e.g. the registration is delayed
+func (d *DefaultExporter) GetRegistryStates() []RegistryState {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	var states []RegistryState
+	for _, r := range d.Registries {
+		id := registryIdentity(r)
+		state, ok := d.registryStates[id]
+		if !ok {
+			continue
+		}
+		if b := getRegisterBatcher(r); b != nil && state == RegistryStatePending && !b.isPending(d.url) {
+			state = RegistryStateRegistered
+		}
+		states = append(states, RegistryState{Registry: id, State: state})
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Registry < states[j].Registry
+	})
+	return states
+}
+
+func registryIdentity(r motan.Registry) string {
+	if url := r.GetURL(); url != nil {
+		return url.GetIdentity()
+	}
+	return r.GetName()
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// RegisterRetriesKey is the provider url parameter of the retries of a failed registration in Export, the retry interval
+// starts from RegisterRetryIntervalKey(ms) and doubles every retry. the registrations still failed are retried in background
+// until they succeed or the exporter is unexported. only the registries implementing motan.TryRegistry report the failures
+const (
+	RegisterRetriesKey       = "registerRetries"
+	RegisterRetryIntervalKey = "registerRetryInterval"
+)
+
+const (
+	defaultRegisterRetries       = 2
+	defaultRegisterRetryInterval = 100 * time.Millisecond
+	maxRegisterRetryInterval     = 30 * time.Second
+)
+
+func (d *DefaultExporter) registerRetryInterval() time.Duration {
+	return d.url.GetTimeDuration(RegisterRetryIntervalKey, time.Millisecond, defaultRegisterRetryInterval)
+}
+
+func nextRegisterRetryInterval(interval time.Duration) time.Duration {
+	if interval *= 2; interval > maxRegisterRetryInterval || interval <= 0 {
+		return maxRegisterRetryInterval
+	}
+	return interval
+}
+
+// tryRegister registers the url to the registry with the retries, it should be called with the lock held
+func (d *DefaultExporter) tryRegister(r motan.TryRegistry) error {
+	retries := d.url.GetIntValue(RegisterRetriesKey, defaultRegisterRetries)
+	interval := d.registerRetryInterval()
+	var err error
+	for i := int64(0); ; i++ {
+		if err = r.TryRegister(d.url); err == nil {
+			return nil
+		}
+		if i >= retries {
+			break
+		}
+		vlog.Warningf("register url %s to registry %s fail, retry after %v. err: %v", d.url.GetIdentity(), r.GetURL().GetIdentity(), interval, err)
+		time.Sleep(interval)
+		interval = nextRegisterRetryInterval(interval)
+	}
+	vlog.Errorf("register url %s to registry %s fail after %d retries, retry in background. err: %v", d.url.GetIdentity(), r.GetURL().GetIdentity(), retries, err)
+	return err
+}
+
+// retryRegister keeps registering the url to the failed registries in background, it is canceled by cancelRegister.
+// it should be called with the lock held
+func (d *DefaultExporter) retryRegister(failed []motan.TryRegistry) {
+	cancel := make(chan struct{})
+	d.retryCancel = cancel
+	interval := d.registerRetryInterval()
+	for i := d.url.GetIntValue(RegisterRetriesKey, defaultRegisterRetries); i >= 0; i-- {
+		interval = nextRegisterRetryInterval(interval)
+	}
+	go func() {
+		for len(failed) > 0 {
+			timer := time.NewTimer(interval)
+			select {
+			case <-timer.C:
+			case <-cancel:
+				timer.Stop()
+				return
+			}
+			d.lock.Lock()
+			if d.retryCancel != cancel {
+				d.lock.Unlock()
+				return
+			}
+			remaining := failed[:0]
+			for _, r := range failed {
+				if err := r.TryRegister(d.url); err != nil {
+					vlog.Warningf("retry register url %s to registry %s fail. err: %v", d.url.GetIdentity(), r.GetURL().GetIdentity(), err)
+					remaining = append(remaining, r)
+					continue
+				}
+				vlog.Infof("retry register url %s to registry %s success", d.url.GetIdentity(), r.GetURL().GetIdentity())
+				d.setRegistryState(r, RegistryStateRegistered)
+				if !d.available {
+					r.Unavailable(d.url)
+				}
+			}
+			failed = remaining
+			if len(failed) == 0 {
+				d.retryCancel = nil
+			}
+			d.lock.Unlock()
+			interval = nextRegisterRetryInterval(interval)
+		}
+	}()
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+	mpro "github.com/weibocom/motan-go/protocol"
+)
+
+// Replayer drives the captured requests through the Call path of a message handler, and compares the responses with the
+// captured ones, so the provider changes can be validated with the real traffic. the requests are sent to the Shadow
+// provider instead if it is set, e.g. a new version of the provider not serving online traffic
+type Replayer struct {
+	Handler    motan.MessageHandler
+	Shadow     motan.Provider
+	ExtFactory motan.ExtensionFactory // serializations of the captured requests
+}
+
+// ReplayDiff is a replayed request whose response is not the same as the captured one
+type ReplayDiff struct {
+	Request  *CapturedRequest
+	Expected *CapturedResponse
+	Actual   *CapturedResponse
+	Reason   string
+}
+
+// ReplayReport is the result of Replay, the requests captured without response are replayed but not compared
+type ReplayReport struct {
+	Total   int
+	Matched int
+	Skipped int
+	Diffs   []*ReplayDiff
+}
+
+// Replay calls the captured requests one by one in order and reports the diffs of the responses
+func (r *Replayer) Replay(requests []*CapturedRequest) (*ReplayReport, error) {
+	handler := r.Handler
+	if r.Shadow != nil {
+		shadow := &DefaultMessageHandler{}
+		shadow.Initialize()
+		shadow.AddProvider(r.Shadow)
+		handler = shadow
+	}
+	if handler == nil {
+		return nil, errors.New("no handler or shadow provider to replay")
+	}
+	report := &ReplayReport{}
+	for _, c := range requests {
+		report.Total++
+		actual, err := r.replay(handler, c)
+		if err != nil {
+			report.Diffs = append(report.Diffs, &ReplayDiff{Request: c, Expected: c.Response, Reason: err.Error()})
+			continue
+		}
+		if c.Response == nil {
+			report.Skipped++
+			continue
+		}
+		if reason := compareCapturedResponse(c.Response, actual); reason != "" {
+			vlog.Warningf("replayed response differs. service:%s, method:%s, requestId:%d, reason:%s", c.Service, c.Method, c.RequestID, reason)
+			report.Diffs = append(report.Diffs, &ReplayDiff{Request: c, Expected: c.Response, Actual: actual, Reason: reason})
+			continue
+		}
+		report.Matched++
+	}
+	return report, nil
+}
+
+func (r *Replayer) replay(handler motan.MessageHandler, c *CapturedRequest) (*CapturedResponse, error) {
+	var serialization motan.Serialization
+	if r.ExtFactory != nil {
+		serialization = r.ExtFactory.GetSerialization("", c.Serialization)
+	}
+	if serialization == nil {
+		return nil, fmt.Errorf("unsupported serialization: %d", c.Serialization)
+	}
+	msg := &mpro.Message{Header: mpro.BuildHeader(mpro.Req, false, c.Serialization, c.RequestID, mpro.Normal),
+		Metadata: motan.NewStringMap(len(c.Attachments)), Body: c.Body}
+	for k, v := range c.Attachments {
+		msg.Metadata.Store(k, v)
+	}
+	request, err := mpro.ConvertToRequest(msg, serialization)
+	if err != nil {
+		return nil, fmt.Errorf("convert captured request fail: %v", err)
+	}
+	request.GetRPCContext(true).ExtFactory = r.ExtFactory
+	res := handler.Call(request)
+	if res == nil {
+		return nil, errors.New("handler call return nil")
+	}
+	resMsg, err := mpro.ConvertToResMessage(res, serialization)
+	if err != nil {
+		return nil, fmt.Errorf("convert replayed response fail: %v", err)
+	}
+	return newCapturedResponse(resMsg), nil
+}
+
+// compareCapturedResponse returns the reason if the responses are not the same, the attachments are not compared
+func compareCapturedResponse(expected *CapturedResponse, actual *CapturedResponse) string {
+	if expected.Exception != actual.Exception {
+		if expected.Exception == "" {
+			return "unexpected exception: " + actual.Exception
+		}
+		if actual.Exception == "" {
+			return "exception not returned: " + expected.Exception
+		}
+		return "exception differs, expected: " + expected.Exception + ", actual: " + actual.Exception
+	}
+	if !bytes.Equal(expected.Body, actual.Body) {
+		return fmt.Sprintf("body differs, expected %d bytes, actual %d bytes", len(expected.Body), len(actual.Body))
+	}
+	return ""
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// ResponseFormatsKey is the provider url parameter of the response serializations a caller can choose, the value is a json map of
+// method name to serialization names, e.g. {"*":["json"],"hello":["json","simple"]}, method '*' applies to all methods
+const ResponseFormatsKey = "responseFormats"
+
+// ResponseFormatAttachment is the request attachment of the serialization name of the response,
+// the response is serialized with the request serialization if it is absent
+const ResponseFormatAttachment = "M_rf"
+
+// responseFormats is the allowed serializations of methods
+type responseFormats map[string]map[string]bool
+
+func parseResponseFormats(url *motan.URL) (responseFormats, error) {
+	value := url.GetParam(ResponseFormatsKey, "")
+	if value == "" {
+		return nil, nil
+	}
+	var raw map[string][]string
+	if err := json.Unmarshal([]byte(value), &raw); err != nil {
+		return nil, fmt.Errorf("illegal %s: %s, err: %v", ResponseFormatsKey, value, err)
+	}
+	formats := make(responseFormats, len(raw))
+	for method, names := range raw {
+		if method == "" || len(names) == 0 {
+			return nil, fmt.Errorf("illegal %s: %s, method name and formats can not be empty", ResponseFormatsKey, value)
+		}
+		formats[method] = make(map[string]bool, len(names))
+		for _, name := range names {
+			formats[method][name] = true
+		}
+	}
+	return formats, nil
+}
+
+func (r responseFormats) allowed(method string, format string) bool {
+	if m, ok := r[method]; ok {
+		return m[format]
+	}
+	if m, ok := r[motan.FirstUpper(method)]; ok {
+		return m[format]
+	}
+	return r["*"][format]
+}
+
+// apply serializes the response value with the serialization the caller chooses, so the response is not serialized by the request serialization
+func (r responseFormats) apply(request motan.Request, res motan.Response) motan.Response {
+	format := request.GetAttachment(ResponseFormatAttachment)
+	if format == "" || res == nil || res.GetException() != nil || res.GetRPCContext(true).Serialized {
+		return res
+	}
+	if !r.allowed(request.GetMethod(), format) {
+		return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 400, ErrMsg: "response format " + format + " is not allowed for method " + request.GetMethod(), ErrType: motan.ServiceException})
+	}
+	var serialization motan.Serialization
+	if ctx := request.GetRPCContext(false); ctx != nil && ctx.ExtFactory != nil {
+		serialization = ctx.ExtFactory.GetSerialization(format, -1)
+	}
+	if serialization == nil {
+		return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 400, ErrMsg: "response format not supported: " + format, ErrType: motan.ServiceException})
+	}
+	b, err := serialization.Serialize(res.GetValue())
+	if err != nil {
+		vlog.Warningf("serialize response with format %s fail. req:%s, err:%v", format, motan.GetReqInfo(request), err)
+		return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 500, ErrMsg: "serialize response with format " + format + " fail: " + err.Error(), ErrType: motan.ServiceException})
+	}
+	formatted := copyResponseWithValue(res, b)
+	ctx := formatted.GetRPCContext(true)
+	ctx.Serialized = true
+	ctx.SerializeNum = serialization.GetSerialNum()
+	return formatted
+}
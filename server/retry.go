@@ -0,0 +1,36 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+
+	motan "github.com/weibocom/motan-go/core"
+)
+
+type retryPolicy struct {
+	budget  int64
+	backoff int64 // ms
+}
+
+// parseRetryPolicy returns nil if the url does not advertise retry budget
+func parseRetryPolicy(url *motan.URL) (*retryPolicy, error) {
+	value := url.GetParam(motan.RetryBudgetKey, "")
+	if value == "" {
+		return nil, nil
+	}
+	budget, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || budget < 0 {
+		return nil, fmt.Errorf("illegal %s: %s, must be a non-negative integer", motan.RetryBudgetKey, value)
+	}
+	return &retryPolicy{budget: budget, backoff: url.GetPositiveIntValue(motan.RetryBackoffKey, 0)}, nil
+}
+
+func (p *retryPolicy) advertise(res motan.Response) {
+	if p == nil || res == nil {
+		return
+	}
+	res.SetAttachment(motan.RetryBudgetAttachment, strconv.FormatInt(p.budget, 10))
+	if p.backoff > 0 {
+		res.SetAttachment(motan.RetryBackoffAttachment, strconv.FormatInt(p.backoff, 10))
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"math/rand"
+	"strconv"
+
+	motan "github.com/weibocom/motan-go/core"
+)
+
+// the delay advertised by the 503 responses of the shed requests, so the clients back off instead of retrying immediately.
+// the delay is base * severity + random jitter, the severity is how much the server is overloaded, e.g. the queue time
+// divided by the SLO, and it is limited to maxRetryAfterScale
+const (
+	RetryAfterKey       = "retryAfter"       // provider url parameter of the base delay in ms
+	RetryAfterJitterKey = "retryAfterJitter" // provider url parameter of the max random jitter in ms
+
+	defaultRetryAfter       = 100
+	defaultRetryAfterJitter = 50
+	maxRetryAfterScale      = 10
+)
+
+type retryAfterPolicy struct {
+	base   int64 // ms
+	jitter int64 // ms
+}
+
+var defaultRetryAfterPolicy = &retryAfterPolicy{base: defaultRetryAfter, jitter: defaultRetryAfterJitter}
+
+func parseRetryAfterPolicy(url *motan.URL) *retryAfterPolicy {
+	return &retryAfterPolicy{
+		base:   url.GetPositiveIntValue(RetryAfterKey, defaultRetryAfter),
+		jitter: url.GetPositiveIntValue(RetryAfterJitterKey, defaultRetryAfterJitter),
+	}
+}
+
+// delay returns the retry after delay in ms of the severity
+func (p *retryAfterPolicy) delay(severity float64) int64 {
+	if severity < 1 {
+		severity = 1
+	} else if severity > maxRetryAfterScale {
+		severity = maxRetryAfterScale
+	}
+	delay := int64(float64(p.base) * severity)
+	if p.jitter > 0 {
+		delay += rand.Int63n(p.jitter + 1)
+	}
+	return delay
+}
+
+// overloadResponse builds the 503 response of a shed request with the retry after delay of the severity, the default
+// policy is used if the policy is nil
+func overloadResponse(request motan.Request, policy *retryAfterPolicy, errMsg string, severity float64) motan.Response {
+	if policy == nil {
+		policy = defaultRetryAfterPolicy
+	}
+	res := motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 503, ErrMsg: errMsg, ErrType: motan.ServiceException})
+	res.SetAttachment(motan.RetryAfterAttachment, strconv.FormatInt(policy.delay(severity), 10))
+	return res
+}
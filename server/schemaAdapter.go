@@ -0,0 +1,122 @@
+package server
+
+import (
+	"sort"
+	"sync"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// SchemaVersionKey is the provider url parameter of the current schema version of the service arguments and responses, e.g. "3".
+// it is registered with the url, so clients know the version they can send
+const SchemaVersionKey = "schemaVersion"
+
+// SchemaVersionAttachment is the request attachment of the schema version the caller uses, the requests without it use the current version
+const SchemaVersionAttachment = "M_sv"
+
+// SchemaAdapter adapts a method between a schema version and the next one
+type SchemaAdapter struct {
+	// Version is the older schema version the adapter upgrades from
+	Version string
+	// Upgrade converts the arguments of the request from Version to the next version, the request is rejected if it returns an error
+	Upgrade func(request motan.Request) error
+	// Downgrade converts the response of the next version to Version, nil means the response is not changed
+	Downgrade func(request motan.Request, response motan.Response) motan.Response
+}
+
+var (
+	schemaAdapters    = make(map[string][]SchemaAdapter) // key: service.method, sorted by version
+	schemaAdapterLock sync.RWMutex
+)
+
+// RegisterSchemaAdapter register the adapter of a method, adapters of the same version are replaced.
+// the adapters are applied by the providers with SchemaVersionKey
+func RegisterSchemaAdapter(service string, method string, adapter SchemaAdapter) {
+	schemaAdapterLock.Lock()
+	defer schemaAdapterLock.Unlock()
+	key := service + "." + method
+	adapters := schemaAdapters[key]
+	for i, a := range adapters {
+		if a.Version == adapter.Version {
+			adapters = append(adapters[:i:i], adapters[i+1:]...)
+			break
+		}
+	}
+	adapters = append(adapters, adapter)
+	sort.Slice(adapters, func(i, j int) bool {
+		return motan.CompareVersion(adapters[i].Version, adapters[j].Version) < 0
+	})
+	schemaAdapters[key] = adapters
+}
+
+// UnregisterSchemaAdapters removes all the adapters of a method
+func UnregisterSchemaAdapters(service string, method string) {
+	schemaAdapterLock.Lock()
+	defer schemaAdapterLock.Unlock()
+	delete(schemaAdapters, service+"."+method)
+}
+
+// getSchemaAdapters returns the adapters from the version to the current version in version order
+func getSchemaAdapters(service string, method string, version string, current string) []SchemaAdapter {
+	schemaAdapterLock.RLock()
+	defer schemaAdapterLock.RUnlock()
+	adapters := schemaAdapters[service+"."+method]
+	if adapters == nil {
+		adapters = schemaAdapters[service+"."+motan.FirstUpper(method)]
+	}
+	var result []SchemaAdapter
+	for _, a := range adapters {
+		if motan.CompareVersion(a.Version, version) >= 0 && motan.CompareVersion(a.Version, current) < 0 {
+			result = append(result, a)
+		}
+	}
+	return result
+}
+
+// SchemaAdapterProviderWrapper upgrades the requests of older schema versions to the current version before the call,
+// and downgrades the responses to the version of the caller
+type SchemaAdapterProviderWrapper struct {
+	baseProviderWrapper
+	version string
+}
+
+// WrapWithSchemaAdapter returns the provider itself if the provider has no schema version
+func WrapWithSchemaAdapter(provider motan.Provider) motan.Provider {
+	version := provider.GetURL().GetParam(SchemaVersionKey, "")
+	if version == "" {
+		return provider
+	}
+	vlog.Infof("schema adapters enabled for provider %s, schema version: %s", provider.GetPath(), version)
+	return &SchemaAdapterProviderWrapper{baseProviderWrapper: baseProviderWrapper{provider: provider}, version: version}
+}
+
+func (s *SchemaAdapterProviderWrapper) Call(request motan.Request) motan.Response {
+	version := request.GetAttachment(SchemaVersionAttachment)
+	if version == "" || motan.CompareVersion(version, s.version) >= 0 {
+		return s.provider.Call(request)
+	}
+	adapters := getSchemaAdapters(request.GetServiceName(), request.GetMethod(), version, s.version)
+	for _, a := range adapters {
+		if a.Upgrade == nil {
+			continue
+		}
+		if err := a.Upgrade(request); err != nil {
+			vlog.Warningf("upgrade request from schema version %s fail. req:%s, err:%v", a.Version, motan.GetReqInfo(request), err)
+			return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 400, ErrMsg: "request of schema version " + version + " can not be adapted: " + err.Error(), ErrType: motan.ServiceException})
+		}
+	}
+	res := s.provider.Call(request)
+	if res == nil || res.GetException() != nil {
+		return res
+	}
+	for i := len(adapters) - 1; i >= 0; i-- {
+		if adapters[i].Downgrade == nil {
+			continue
+		}
+		if downgraded := adapters[i].Downgrade(request, res); downgraded != nil {
+			res = downgraded
+		}
+	}
+	return res
+}
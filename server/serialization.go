@@ -0,0 +1,87 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+	mpro "github.com/weibocom/motan-go/protocol"
+)
+
+// SerializationFallbackKey is the provider url parameter of the response serialization fallback policy,
+// the value is a json map of method name to policy, e.g. {"hello":"simple","world":"error"}, method '*' applies to all methods.
+// the policy is used only when the response value can not be serialized by the request serialization, it can be:
+//   - name of a serialization: serialize the value with it, the client should support the serialization
+//   - string: serialize the truncated string representation of the value with the request serialization
+//   - error: return an exception response with the method and value type of the failure
+const SerializationFallbackKey = "serializationFallback"
+
+const (
+	FallbackString = "string"
+	FallbackError  = "error"
+)
+
+// max length of the fallback string representation
+const maxFallbackStringLength = 1024
+
+func getSerializationFallback(url *motan.URL, method string) string {
+	value := url.GetParam(SerializationFallbackKey, "")
+	if value == "" {
+		return ""
+	}
+	var policies map[string]string
+	if err := json.Unmarshal([]byte(value), &policies); err != nil {
+		vlog.Warningf("illegal %s: %s, err: %v", SerializationFallbackKey, value, err)
+		return ""
+	}
+	if policy, ok := policies[method]; ok {
+		return policy
+	}
+	if policy, ok := policies[motan.FirstUpper(method)]; ok {
+		return policy
+	}
+	return policies["*"]
+}
+
+// convertResponse convert the response to message, the serialization fallback policy of provider is applied if the serialization fails
+func (m *MotanServer) convertResponse(req motan.Request, res motan.Response, serialization motan.Serialization) (*mpro.Message, error) {
+	msg, err := convertToResMessage(res, serialization)
+	if err == nil || res.GetException() != nil || res.GetValue() == nil || m.handler == nil {
+		return msg, err
+	}
+	p := m.handler.GetProvider(req.GetServiceName())
+	if p == nil {
+		return msg, err
+	}
+	policy := getSerializationFallback(p.GetURL(), req.GetMethod())
+	if policy == "" {
+		return msg, err
+	}
+	vlog.Warningf("serialize response fail, use fallback policy %s. req:%s, err:%v", policy, motan.GetReqInfo(req), err)
+	switch policy {
+	case FallbackError:
+		return convertToResMessage(motan.BuildExceptionResponse(res.GetRequestID(), &motan.Exception{ErrCode: 500,
+			ErrMsg:  fmt.Sprintf("serialize response fail. method: %s, value type: %T, err: %v", req.GetMethod(), res.GetValue(), err),
+			ErrType: motan.ServiceException}), serialization)
+	case FallbackString:
+		value := fmt.Sprintf("%+v", res.GetValue())
+		if len(value) > maxFallbackStringLength {
+			value = value[:maxFallbackStringLength]
+		}
+		return convertToResMessage(copyResponseWithValue(res, value), serialization)
+	default:
+		fallback := m.extFactory.GetSerialization(policy, -1)
+		if fallback == nil {
+			vlog.Warningf("fallback serialization not found: %s", policy)
+			return msg, err
+		}
+		return convertToResMessage(res, fallback)
+	}
+}
+
+func copyResponseWithValue(res motan.Response, value interface{}) motan.Response {
+	r := &motan.MotanResponse{RequestID: res.GetRequestID(), Value: value, ProcessTime: res.GetProcessTime(), Attachment: res.GetAttachments()}
+	r.RPCContext = res.GetRPCContext(true)
+	return r
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+	mpro "github.com/weibocom/motan-go/protocol"
+	"github.com/weibocom/motan-go/serialize"
+)
+
+type unencodable struct {
+	Name string
+}
+
+func TestSerializationFallback(t *testing.T) {
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	serialize.RegistDefaultSerializations(ext)
+	p := newTestProvider("fallbackService", map[string]string{SerializationFallbackKey: `{"simple":"simple","string":"string","error":"error"}`})
+	server := &MotanServer{URL: &motan.URL{}, handler: newTestHandler(p, newTestProvider("noFallback", nil)), extFactory: ext}
+	simple := &serialize.SimpleSerialization{}
+	value := &unencodable{Name: "test"}
+
+	_, err := server.convertResponse(newTestRequest("noFallback", "simple"), &motan.MotanResponse{RequestID: 1, Value: value}, simple)
+	assert.NotNil(t, err)
+	_, err = server.convertResponse(newTestRequest("fallbackService", "other"), &motan.MotanResponse{RequestID: 1, Value: value}, simple)
+	assert.NotNil(t, err)
+
+	msg, err := server.convertResponse(newTestRequest("fallbackService", "string"), &motan.MotanResponse{RequestID: 1, Value: value}, simple)
+	assert.Nil(t, err)
+	assert.Equal(t, mpro.Normal, msg.Header.GetStatus())
+	v, err := simple.DeSerialize(msg.Body, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "&{Name:test}", v)
+
+	msg, err = server.convertResponse(newTestRequest("fallbackService", "error"), &motan.MotanResponse{RequestID: 1, Value: value}, simple)
+	assert.Nil(t, err)
+	assert.Equal(t, mpro.Exception, msg.Header.GetStatus())
+	assert.Contains(t, msg.Metadata.LoadOrEmpty(mpro.MExceptionn), "unencodable")
+
+	msg, err = server.convertResponse(newTestRequest("fallbackService", "simple"), &motan.MotanResponse{RequestID: 1, Value: map[string]string{"k": "v"}}, &serialize.PbSerialization{})
+	assert.Nil(t, err)
+	assert.Equal(t, serialize.SimpleNumber, msg.Header.GetSerialize())
+}
+
+func TestResponseFormat(t *testing.T) {
+	for _, value := range []string{"{", `{"hello":[]}`, `{"":["json"]}`} {
+		_, err := parseResponseFormats(newTestProvider("test", map[string]string{ResponseFormatsKey: value}).GetURL())
+		assert.NotNil(t, err, value)
+	}
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	serialize.RegistDefaultSerializations(ext)
+	p := newTestProvider("format", map[string]string{ResponseFormatsKey: `{"*":["json"],"raw":["simple"]}`})
+	p.callFunc = func(request motan.Request) motan.Response {
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: map[string]string{"k": "v"}}
+	}
+	server := &MotanServer{URL: &motan.URL{}, handler: newTestHandler(p), extFactory: ext}
+	call := func(method string, format string) (motan.Response, *mpro.Message) {
+		request := newTestRequest("format", method)
+		request.GetRPCContext(true).ExtFactory = ext
+		request.SetAttachment(ResponseFormatAttachment, format)
+		res := server.handler.Call(request)
+		msg, err := server.convertResponse(request, res, &serialize.SimpleSerialization{})
+		assert.Nil(t, err)
+		return res, msg
+	}
+	_, msg := call("hello", "json")
+	assert.Equal(t, serialize.JSONNumber, msg.Header.GetSerialize())
+	assert.Equal(t, `{"k":"v"}`, string(msg.Body))
+	// the request serialization is used without the attachment
+	_, msg = call("hello", "")
+	assert.Equal(t, serialize.SimpleNumber, msg.Header.GetSerialize())
+
+	res, _ := call("raw", "json")
+	assert.NotNil(t, res.GetException())
+	assert.Equal(t, 400, res.GetException().ErrCode)
+	_, msg = call("raw", "simple")
+	assert.Equal(t, serialize.SimpleNumber, msg.Header.GetSerialize())
+
+	p.url.PutParam(ResponseFormatsKey, `{"*":["unknown"]}`)
+	server = &MotanServer{URL: &motan.URL{}, handler: newTestHandler(p), extFactory: ext}
+	res, _ = call("hello", "unknown")
+	assert.Equal(t, 400, res.GetException().ErrCode)
+}
@@ -1,29 +1,54 @@
 package server
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	motan "github.com/weibocom/motan-go/core"
 	"github.com/weibocom/motan-go/log"
+	mpro "github.com/weibocom/motan-go/protocol"
 )
 
 const (
 	Motan2 = "motan2"
 	CGI    = "cgi"
+	GRPC   = "grpc"
+	HTTP   = "http"
 )
 
 const (
 	Default = "default"
 )
 
+const (
+	// NoCompressKey is the request attachment to disable response compression for debugging
+	NoCompressKey = "noCompress"
+	// AllowNoCompressKey is the provider url parameter, NoCompressKey attachment will be ignored if it is not true
+	AllowNoCompressKey = "allowNoCompress"
+	// DirectServeKey is the provider url parameter, the provider is served without registration if it is true.
+	// an explicitly empty registry list has the same effect, while a missing registry list is a misconfiguration
+	DirectServeKey = "directServe"
+)
+
 func RegistDefaultServers(extFactory motan.ExtensionFactory) {
 	extFactory.RegistExtServer(Motan2, func(url *motan.URL) motan.Server {
 		return &MotanServer{URL: url}
 	})
 	extFactory.RegistExtServer(CGI, func(url *motan.URL) motan.Server {
-		return &MotanServer{URL: url}
+		return &CGIServer{MotanServer: MotanServer{URL: url}}
+	})
+	extFactory.RegistExtServer(GRPC, func(url *motan.URL) motan.Server {
+		return &GrpcServer{URL: url}
+	})
+	extFactory.RegistExtServer(HTTP, func(url *motan.URL) motan.Server {
+		return &HTTPServer{URL: url}
 	})
 }
 
@@ -42,6 +67,31 @@ type DefaultExporter struct {
 	lock       sync.Mutex
 	available  bool
 	exported   bool
+	// unregistered from all registries by GracefulShutdown, but not unexported yet
+	unregistered bool
+	// registered to all registries and not unregistered yet
+	registered bool
+	// the registration states of the url by the registry identity, see RegistryState
+	registryStates map[string]string
+	// closed to cancel the pending delayed registration
+	registerCancel chan struct{}
+	// closed to cancel the background retry of the failed registrations
+	retryCancel chan struct{}
+	// unexported after exported, the next Export adds the provider to the server again
+	unexported bool
+	// closed to cancel the warmup, availableTime is the time the warmup starts
+	warmupCancel  chan struct{}
+	availableTime time.Time
+	// closed to stop the health check
+	healthCancel chan struct{}
+	// the readiness gate, readyCancel is closed to stop waiting for the provider ready
+	readyFunc   func() bool
+	readyCancel chan struct{}
+	// the time the url is unregistered by GracefulShutdown, the quiesce period of Unexport starts from it
+	unregisterTime time.Time
+	// Unexport is in progress, the exporter is still exported until the provider is drained
+	unexporting bool
+	preStop     func()
 
 	// 服务管理单位，负责服务注册、心跳、导出和销毁，内部包含provider，与provider是一对一关系
 }
@@ -60,51 +110,353 @@ func (d *DefaultExporter) Export(server motan.Server, extFactory motan.Extension
 	d.extFactory = extFactory
 	d.server = server
 	d.url = d.provider.GetURL()
+	if d.unexported {
+		// the provider url may be changed after unexport, the params advertised by the last export are computed again
+		d.url.ClearCachedInfo()
+		clearAdvertisedParams(d.url)
+	}
 	d.url.PutParam(motan.NodeTypeKey, motan.NodeTypeService) // node type must be service in export
 	regs, ok := d.url.Parameters[motan.RegistryKey]
-	if !ok {
+	directServe := d.url.GetParam(DirectServeKey, "") == "true"
+	if !ok && !directServe {
 		errInfo := fmt.Sprintf("registry not found! url %+v", d.url)
 		err = errors.New(errInfo)
 		vlog.Errorln(errInfo)
 		return err
 	}
-	arr := motan.TrimSplit(regs, ",")
+	if f, ok := d.provider.(*FilterProviderWrapper); ok && f.err != nil {
+		vlog.Errorf("export url %s fail. err: %v", d.url.GetIdentity(), f.err)
+		return f.err
+	}
+	if err = checkMaxMethods(d.provider); err != nil {
+		vlog.Errorf("export url %s fail. err: %v", d.url.GetIdentity(), err)
+		return err
+	}
+	if err = checkNaming(d.provider); err != nil {
+		vlog.Errorf("export url %s fail. err: %v", d.url.GetIdentity(), err)
+		return err
+	}
+	retry, err := parseRetryPolicy(d.url)
+	if err != nil {
+		vlog.Errorf("export url %s fail. err: %v", d.url.GetIdentity(), err)
+		return err
+	}
+	if retry != nil {
+		vlog.Infof("export url %s with retry budget: %d, backoff: %dms", d.url.GetIdentity(), retry.budget, retry.backoff)
+	}
+	timeouts, err := parseMethodTimeouts(d.url)
+	if err != nil {
+		vlog.Errorf("export url %s fail. err: %v", d.url.GetIdentity(), err)
+		return err
+	}
+	if len(timeouts) > 0 {
+		vlog.Infof("export url %s with method timeouts: %v", d.url.GetIdentity(), timeouts)
+	}
+	if _, err = parseResponseItemLimits(d.url); err != nil {
+		vlog.Errorf("export url %s fail. err: %v", d.url.GetIdentity(), err)
+		return err
+	}
+	if _, err = parseFieldCompression(d.url); err != nil {
+		vlog.Errorf("export url %s fail. err: %v", d.url.GetIdentity(), err)
+		return err
+	}
+	if _, err = parseResponseFormats(d.url); err != nil {
+		vlog.Errorf("export url %s fail. err: %v", d.url.GetIdentity(), err)
+		return err
+	}
+	if _, err = parseMethodSunsets(d.url); err != nil {
+		vlog.Errorf("export url %s fail. err: %v", d.url.GetIdentity(), err)
+		return err
+	}
+	if _, err = parseMethodSLOs(d.url); err != nil {
+		vlog.Errorf("export url %s fail. err: %v", d.url.GetIdentity(), err)
+		return err
+	}
+	if _, err = parsePanicPolicy(d.url); err != nil {
+		vlog.Errorf("export url %s fail. err: %v", d.url.GetIdentity(), err)
+		return err
+	}
+	if _, err = parseGCAdmission(d.url); err != nil {
+		vlog.Errorf("export url %s fail. err: %v", d.url.GetIdentity(), err)
+		return err
+	}
+	if _, err = parseConcurrencyLimiter(d.url); err != nil {
+		vlog.Errorf("export url %s fail. err: %v", d.url.GetIdentity(), err)
+		return err
+	}
+	if _, err = parseRegisterPolicy(d.url); err != nil {
+		vlog.Errorf("export url %s fail. err: %v", d.url.GetIdentity(), err)
+		return err
+	}
+	advertised, err := protocolParams(d.url, server)
+	if err != nil {
+		vlog.Errorf("export url %s fail. err: %v", d.url.GetIdentity(), err)
+		return err
+	}
+	for k, v := range advertised {
+		d.url.PutParam(k, v)
+	}
+	placement, err := motan.ParsePlacementHints(d.url.GetParam(motan.PlacementHintsKey, ""))
+	if err != nil {
+		vlog.Errorf("export url %s fail. err: %v", d.url.GetIdentity(), err)
+		return err
+	}
+	for k, v := range placement {
+		d.url.PutParam(motan.PlacementPrefix+k, v)
+	}
+	var arr []string
+	if directServe || strings.TrimSpace(regs) == "" {
+		// serve without registration for point-to-point deployments
+		vlog.Infof("export url %s without registry. directServe: %v", d.url.GetIdentity(), directServe)
+	} else {
+		arr = uniqueRegistries(d.url, motan.TrimSplit(regs, ","))
+	}
+	registerDelay, delayed := time.Duration(0), false
+	if v := d.url.GetParam(RegisterDelayKey, ""); v != "" {
+		delay, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || delay == 0 {
+			err = errors.New("illegal " + RegisterDelayKey + ": " + v)
+			vlog.Errorf("export url %s fail. err: %v", d.url.GetIdentity(), err)
+			return err
+		}
+		registerDelay, delayed = time.Duration(delay)*time.Millisecond, true
+	}
+	afterHealthy := d.url.GetParam(RegisterAfterHealthyKey, "") == "true"
+	if afterHealthy && d.url.GetParam(HealthCheckKey, "") == "" {
+		err = errors.New(HealthCheckKey + " is required if " + RegisterAfterHealthyKey + " is true")
+		vlog.Errorf("export url %s fail. err: %v", d.url.GetIdentity(), err)
+		return err
+	}
 	registries := make([]motan.Registry, 0, len(arr))
+	var invalid []string
 	for _, r := range arr {
+		var registry motan.Registry
 		if registryURL, ok := context.RegistryURLs[r]; ok {
-			registry := d.extFactory.GetRegistry(registryURL)
-			if registry != nil {
-				registry.Register(d.url)
-				registries = append(registries, registry)
-			}
-		} else {
-			err = errors.New("registry is invalid: " + r)
+			registry = d.extFactory.GetRegistry(registryURL)
+		}
+		if registry == nil {
 			vlog.Errorln("registry is invalid: " + r)
+			invalid = append(invalid, r)
+			continue
+		}
+		registries = append(registries, registry)
+	}
+	if len(arr) > 0 && len(registries) == 0 {
+		err = errors.New("all registries are invalid: " + strings.Join(invalid, ", "))
+		vlog.Errorf("export url %s fail. err: %v", d.url.GetIdentity(), err)
+		return err
+	}
+	if d.unexported {
+		// the provider is destroyed by Unexport, it is initialized again before serving
+		initializeProvider(d.provider)
+		if err = server.GetMessageHandler().AddProvider(d.provider); err != nil {
+			vlog.Errorf("export url %s fail. err: %v", d.url.GetIdentity(), err)
+			return err
 		}
+		d.unexported = false
 	}
 	d.Registries = registries
-	// TODO heartbeat or 200 switcher
+	// the url not ready is registered unavailable
+	d.available = isReady(d.readyFunc)
+	if afterHealthy && len(registries) > 0 {
+		d.registerAfterHealthy(registerDelay, delayed)
+	} else if !delayed {
+		if err = d.registerAll(); err != nil {
+			vlog.Errorf("export url %s fail. err: %v", d.url.GetIdentity(), err)
+			return err
+		}
+	} else if len(registries) > 0 {
+		vlog.Infof("export url %s with registration delay: %v", d.url.GetIdentity(), registerDelay)
+		d.delayRegister(registerDelay)
+	}
+	if q := quiesceProvider(d.provider); q != nil {
+		q.bind(d)
+	}
+	if w := panicPolicyProvider(d.provider); w != nil {
+		w.bind(d)
+	}
+	if f, ok := d.provider.(*FilterProviderWrapper); ok {
+		atomic.StoreInt32(&f.closing, 0)
+	}
 	d.exported = true
-	d.available = true
+	if !d.available {
+		d.waitReady()
+	}
+	// the periodic health check starts after the url is registered by the passed health check
+	if !afterHealthy || len(registries) == 0 {
+		d.startHealthCheck()
+	}
+	registerExporter(d)
+	if len(invalid) > 0 {
+		vlog.Warningf("export url %s success with invalid registries: %v", d.url.GetIdentity(), invalid)
+		return &InvalidRegistryError{Names: invalid}
+	}
 	vlog.Infof("export url %s success.", d.url.GetIdentity())
 	return nil
 }
 
+// InvalidRegistryError is returned by Export if some registries of the url are not configured, the url is still exported
+// and registered to the other registries. the export fails with other errors if all the registries are invalid
+type InvalidRegistryError struct {
+	Names []string
+}
+
+func (e *InvalidRegistryError) Error() string {
+	return "registry is invalid: " + strings.Join(e.Names, ", ")
+}
+
+// uniqueRegistries drops the duplicated registry names, so the url is not registered twice to a registry
+func uniqueRegistries(url *motan.URL, names []string) []string {
+	unique := make([]string, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if seen[name] {
+			vlog.Warningf("duplicated registry %s of url %s dropped", name, url.GetIdentity())
+			continue
+		}
+		seen[name] = true
+		unique = append(unique, name)
+	}
+	return unique
+}
+
+// Unexport stops the provider in order: unregister from all registries -> wait UnregisterQuiesceKey for the registries
+// to notify the clients -> remove the provider from the server, so no new calls are accepted -> wait the requests in
+// processing at most GracefulShutdownTimeoutKey -> run the pre-stop hook, see SetPreStopHook -> destroy the provider.
+// the provider Destroy is waited at most DestroyTimeoutKey.
+// the exporter keeps only the provider, it can be exported again with the current provider url, the provider and the
+// providers it wraps are initialized again by the next Export if they implement motan.Initializable
 func (d *DefaultExporter) Unexport() error {
+	d.lock.Lock()
+	if !d.exported || d.unexporting {
+		d.lock.Unlock()
+		return nil
+	}
+	d.cancelRegister()
+	d.stopWaitReady()
+	d.stopHealthCheck()
+	quiesce := d.remainingQuiesce()
+	d.unregisterAll()
+	d.available = false
+	d.unexporting = true
+	server := d.server
+	provider := d.provider
+	preStop := d.preStop
+	d.lock.Unlock()
+	if quiesce > 0 {
+		vlog.Infof("unexport url %s after quiesce %v", d.url.GetIdentity(), quiesce)
+		time.Sleep(quiesce)
+	}
+	server.GetMessageHandler().RmProvider(provider)
+	if f, ok := provider.(*FilterProviderWrapper); ok {
+		atomic.StoreInt32(&f.closing, 1)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(d.url.GetPositiveIntValue(GracefulShutdownTimeoutKey, int64(defaultGracefulShutdownTimeout/time.Millisecond)))*time.Millisecond)
+		if err := drainInflight(ctx, &f.inflight); err != nil {
+			vlog.Warningf("drain provider %s fail, destroy it with requests in processing. err:%v", provider.GetPath(), err)
+		}
+		cancel()
+	}
+	if preStop != nil {
+		runPreStop(preStop, provider.GetPath(), d.url.GetTimeDuration(PreStopTimeoutKey, time.Millisecond, defaultPreStopTimeout))
+	}
+	d.lock.Lock()
+	d.exported = false
+	d.unexporting = false
+	d.unregistered = false
+	d.unexported = true
+	d.Registries = nil
+	d.server = nil
+	d.extFactory = nil
+	unregisterExporter(d)
+	d.lock.Unlock()
+	destroyProvider(provider, time.Duration(d.url.GetPositiveIntValue(DestroyTimeoutKey, int64(defaultDestroyTimeout/time.Millisecond)))*time.Millisecond)
+	return nil
+}
+
+// unregister the exporter from registries, so that no new request will be routed to it
+func (d *DefaultExporter) unregister() {
 	d.lock.Lock()
 	defer d.lock.Unlock()
-	if !d.exported {
+	if !d.exported || d.unregistered {
+		return
+	}
+	d.cancelRegister()
+	d.stopWaitReady()
+	d.stopHealthCheck()
+	if d.registered {
+		d.unregisterTime = time.Now()
+	}
+	d.unregisterAll()
+	d.unregistered = true
+	d.available = false
+}
+
+// registerAll register the url to all registries at most once until it is unregistered, it should be called with the lock held.
+// registries are not required to be idempotent, so the exporter never registers or unregisters twice
+// the url is unregistered from all registries if the failed registrations are not allowed by RegisterPolicyKey
+func (d *DefaultExporter) registerAll() error {
+	if d.registered {
 		return nil
 	}
+	policy, _ := parseRegisterPolicy(d.url)
+	var failed []motan.TryRegistry
 	for _, r := range d.Registries {
-		r.UnRegister(d.url)
+		if b := getRegisterBatcher(r); b != nil {
+			b.add(d.url, d.availableAfterBatch)
+			d.setRegistryState(r, RegistryStatePending)
+		} else if tr, ok := r.(motan.TryRegistry); ok {
+			if err := d.tryRegister(tr); err != nil {
+				failed = append(failed, tr)
+				d.setRegistryState(r, RegistryStateRetrying)
+			} else {
+				d.setRegistryState(r, RegistryStateRegistered)
+			}
+		} else {
+			r.Register(d.url)
+			d.setRegistryState(r, RegistryStateRegistered)
+		}
+	}
+	if err := checkRegisterPolicy(policy, len(d.Registries), len(failed)); err != nil {
+		d.rollbackRegister(err)
+		return err
+	}
+	d.registered = true
+	if len(failed) > 0 {
+		d.retryRegister(failed)
+	}
+	// the url is set unavailable before the delayed registration
+	if !d.available {
+		d.notifyAvailability()
+	} else {
+		d.startWarmup()
 	}
-	d.server.GetMessageHandler().RmProvider(d.provider)
-	d.exported = false
-	// TODO: gracefully destroy provider
 	return nil
 }
 
+// unregisterAll unregister the url only if it is registered, it should be called with the lock held
+func (d *DefaultExporter) unregisterAll() {
+	if !d.registered {
+		return
+	}
+	for _, r := range d.Registries {
+		// the url not registered by the batch yet is cancelled
+		if b := getRegisterBatcher(r); b != nil && b.cancel(d.url) {
+			continue
+		}
+		r.UnRegister(d.url)
+	}
+	d.registered = false
+	d.registryStates = nil
+}
+
+// availableAfterBatch is the availability of the url registered by a batch, the url unregistered is treated as available
+// so it is not notified
+func (d *DefaultExporter) availableAfterBatch() bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return !d.registered || d.available
+}
+
 func (d *DefaultExporter) SetProvider(provider motan.Provider) {
 	d.provider = provider
 }
@@ -113,20 +465,86 @@ func (d *DefaultExporter) GetProvider() motan.Provider {
 	return d.provider
 }
 
+// Available marks the exported url available in registries, so it can be discovered again after Unavailable.
+// it is ignored before Export
 func (d *DefaultExporter) Available() {
+	d.setAvailable(true)
+}
+
+// Unavailable removes the exported url from discovery without unregistering it, it is ignored before Export
+func (d *DefaultExporter) Unavailable() {
+	d.setAvailable(false)
+}
+
+func (d *DefaultExporter) setAvailable(available bool) {
 	d.lock.Lock()
 	defer d.lock.Unlock()
-	d.available = true
+	if !d.exported || d.unexporting {
+		vlog.Warningf("set availability of %s ignored: not exported, available: %v", d.provider.GetPath(), available)
+		return
+	}
+	d.updateAvailable(available)
 }
 
-func (d *DefaultExporter) Unavailable() {
+// updateAvailable changes the availability and notifies the registries, it should be called with the lock held
+func (d *DefaultExporter) updateAvailable(available bool) {
+	if d.available == available {
+		return
+	}
+	d.available = available
+	// the url not registered yet is notified after registration
+	if d.registered {
+		// the warmup advertises the available url with the warmup weight
+		if !available {
+			d.stopWarmup()
+			d.notifyAvailability()
+		} else if !d.startWarmup() {
+			d.notifyAvailability()
+		}
+	}
+}
+
+// notifyAvailability notifies the registries of the availability, it should be called with the lock held
+func (d *DefaultExporter) notifyAvailability() {
+	for _, r := range d.Registries {
+		// the url not registered by the batch yet is notified after registration
+		if b := getRegisterBatcher(r); b != nil && b.isPending(d.url) {
+			continue
+		}
+		if d.available {
+			r.Available(d.url)
+		} else {
+			r.Unavailable(d.url)
+		}
+	}
+}
+
+// SetGzipSize changes the min size of the responses to compress of the exported provider without exporting it again,
+// 0 disables the compression
+func (d *DefaultExporter) SetGzipSize(size int) error {
 	d.lock.Lock()
 	defer d.lock.Unlock()
-	d.available = false
+	if size < 0 {
+		return fmt.Errorf("illegal gzip size: %d", size)
+	}
+	if !d.exported {
+		return errors.New("exporter not exported")
+	}
+	handler, ok := d.server.GetMessageHandler().(interface {
+		SetGzipSize(p motan.Provider, size int) bool
+	})
+	if !ok || !handler.SetGzipSize(d.provider, size) {
+		return errors.New("gzip size can not be changed by the message handler of " + d.provider.GetPath())
+	}
+	vlog.Infof("gzip size of url %s is changed to %d", d.url.GetIdentity(), size)
+	return nil
 }
 
+// IsAvailable returns false if the url is set unavailable or the provider is not available
 func (d *DefaultExporter) IsAvailable() bool {
-	return d.available
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.available && d.provider.IsAvailable()
 }
 
 func (d *DefaultExporter) GetURL() *motan.URL {
@@ -137,48 +555,414 @@ func (d *DefaultExporter) SetURL(url *motan.URL) {
 	d.url = url
 }
 
+// DefaultMessageHandler routes requests to the providers by service(path), providers can be added and removed while serving
 type DefaultMessageHandler struct {
+	// lock of all the maps, the values are not modified after they are put
+	lock      sync.RWMutex
 	providers map[string]motan.Provider
+	groups    map[string]groupProviders
+	// the config of each provider of the groups, so the providers of a path in different groups keep their own config
+	configs map[motan.Provider]*providerConfig
+	// builds the responses of ErrProviderPanic and ErrProviderNotFound
+	errorHandler func(motan.Request, error) motan.Response
+}
+
+// providerConfig is the config parsed from the url of a provider when it is added
+type providerConfig struct {
+	timeouts    methodTimeouts
+	admission   queueTimeAdmission
+	gcAdmit     *gcAdmission
+	limiter     *concurrencyLimiter
+	itemLimits  responseItemLimits
+	compression *fieldCompression
+	formats     responseFormats
+	metrics     *callMetrics
+	adaptive    *adaptiveTimeouts
+	sunsets     methodSunsets
+	slo         *sloTracker
+	slowLog     *slowRequestDetector
+	gzipSize    *int64 // the live motan.GzipSizeKey of the provider, see SetGzipSize
+	acls        methodACLs
+	retry       *retryPolicy
+	retryAfter  *retryAfterPolicy
+}
+
+func newProviderConfig(p motan.Provider) *providerConfig {
+	var err error
+	c := &providerConfig{}
+	if c.timeouts, err = parseMethodTimeouts(p.GetURL()); err != nil {
+		vlog.Warningf("method timeouts of provider %s ignored. err: %v", p.GetPath(), err)
+	}
+	if c.admission, err = newQueueTimeAdmission(p.GetURL()); err != nil {
+		vlog.Warningf("queue time SLO of provider %s ignored. err: %v", p.GetPath(), err)
+	}
+	if c.gcAdmit, err = parseGCAdmission(p.GetURL()); err != nil {
+		vlog.Warningf("gc pressure shedding of provider %s ignored. err: %v", p.GetPath(), err)
+	}
+	if c.gcAdmit != nil {
+		c.gcAdmit.monitor.start()
+	}
+	if c.limiter, err = parseConcurrencyLimiter(p.GetURL()); err != nil {
+		vlog.Warningf("max worker of provider %s ignored. err: %v", p.GetPath(), err)
+	}
+	if c.itemLimits, err = parseResponseItemLimits(p.GetURL()); err != nil {
+		vlog.Warningf("max response items of provider %s ignored. err: %v", p.GetPath(), err)
+	}
+	if c.compression, err = parseFieldCompression(p.GetURL()); err != nil {
+		vlog.Warningf("field compression of provider %s ignored. err: %v", p.GetPath(), err)
+	}
+	if c.formats, err = parseResponseFormats(p.GetURL()); err != nil {
+		vlog.Warningf("response formats of provider %s ignored. err: %v", p.GetPath(), err)
+	}
+	if c.sunsets, err = parseMethodSunsets(p.GetURL()); err != nil {
+		vlog.Warningf("method sunsets of provider %s ignored. err: %v", p.GetPath(), err)
+	}
+	if c.slo, err = parseSLOTracker(p.GetURL()); err != nil {
+		vlog.Warningf("method SLOs of provider %s ignored. err: %v", p.GetPath(), err)
+	}
+	if c.slowLog, err = parseSlowRequestDetector(p.GetURL()); err != nil {
+		vlog.Warningf("slow request detection of provider %s ignored. err: %v", p.GetPath(), err)
+	}
+	if c.retry, err = parseRetryPolicy(p.GetURL()); err != nil {
+		vlog.Warningf("retry budget of provider %s ignored. err: %v", p.GetPath(), err)
+	}
+	c.acls = parseMethodACLs(p.GetURL())
+	c.retryAfter = parseRetryAfterPolicy(p.GetURL())
+	c.metrics = parseCallMetrics(p)
+	c.adaptive = parseAdaptiveTimeouts(p.GetURL())
+	gzipSize := p.GetURL().GetIntValue(motan.GzipSizeKey, 0)
+	c.gzipSize = &gzipSize
+	return c
 }
 
+// the errors of the requests the message handler can not serve, see DefaultMessageHandler.SetErrorHandler
+var (
+	ErrProviderPanic    = errors.New("provider call panic")
+	ErrProviderNotFound = errors.New("provider not found")
+)
+
 func (d *DefaultMessageHandler) Initialize() {
 	d.providers = make(map[string]motan.Provider)
+	d.groups = make(map[string]groupProviders)
+	d.configs = make(map[motan.Provider]*providerConfig)
 }
 
 func (d *DefaultMessageHandler) AddProvider(p motan.Provider) error {
+	config := newProviderConfig(p)
+	d.lock.Lock()
+	defer d.lock.Unlock()
 	d.providers[p.GetPath()] = p
+	groups := d.groups[p.GetPath()].add(p)
+	if collisions := groups.collisions(p); len(collisions) > 0 {
+		vlog.Warningf("methods %v of provider %s are served by other providers of the same path and group, the calls are dispatched to the provider added first", collisions, p.GetPath())
+	}
+	// the provider replaced in the same group is not served any more
+	for _, gp := range d.groups[p.GetPath()] {
+		if gp != p && !groups.contains(gp) {
+			delete(d.configs, gp)
+		}
+	}
+	d.groups[p.GetPath()] = groups
+	d.configs[p] = config
 	return nil
 }
 
+// RmProvider removes the provider only if it is the one added, so a provider replaced by another one of the same path
+// does not remove the new one
 func (d *DefaultMessageHandler) RmProvider(p motan.Provider) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	delete(d.configs, p)
+	groups := d.groups[p.GetPath()].remove(p)
+	if len(groups) > 0 {
+		// other groups of the path are still serving
+		d.groups[p.GetPath()] = groups
+		if d.providers[p.GetPath()] == p {
+			d.providers[p.GetPath()] = groups[len(groups)-1]
+		}
+		return
+	}
 	dp := d.providers[p.GetPath()]
 	if dp != nil && p == dp {
 		delete(d.providers, p.GetPath())
+		delete(d.groups, p.GetPath())
+	}
+}
+
+// Destroy removes all the providers and destroys them. the providers are removed under the lock, so the calls after it
+// respond the not found exceptions, and destroyed after the lock is released, each is waited at most DestroyTimeoutKey.
+// the calls in processing are not waited, it is safe to call it more than once
+func (d *DefaultMessageHandler) Destroy() {
+	d.lock.Lock()
+	var providers []motan.Provider
+	for _, groups := range d.groups {
+		providers = append(providers, groups...)
+	}
+	d.Initialize()
+	d.lock.Unlock()
+	for _, p := range providers {
+		destroyProvider(p, time.Duration(p.GetURL().GetPositiveIntValue(DestroyTimeoutKey, int64(defaultDestroyTimeout/time.Millisecond)))*time.Millisecond)
+	}
+	if len(providers) > 0 {
+		vlog.Infof("message handler destroyed, providers: %d", len(providers))
 	}
 }
 
 func (d *DefaultMessageHandler) GetProvider(serviceName string) motan.Provider {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
 	return d.providers[serviceName]
 }
 
+// GetProviders returns a snapshot of all the providers of all the groups, ordered by path and group
+func (d *DefaultMessageHandler) GetProviders() []motan.Provider {
+	d.lock.RLock()
+	providers := make([]motan.Provider, 0, len(d.groups))
+	for _, groups := range d.groups {
+		providers = append(providers, groups...)
+	}
+	d.lock.RUnlock()
+	sort.SliceStable(providers, func(i, j int) bool {
+		if providers[i].GetPath() != providers[j].GetPath() {
+			return providers[i].GetPath() < providers[j].GetPath()
+		}
+		return providers[i].GetURL().Group < providers[j].GetURL().Group
+	})
+	return providers
+}
+
+// SetErrorHandler sets the hook to build the responses of a provider panic(ErrProviderPanic) and a service without provider
+// (ErrProviderNotFound), e.g. to use other error codes or emit metrics. the exception responses of code 500 are returned
+// if the hook is nil or returns nil. the panic is still recovered and logged before the hook is called
+func (d *DefaultMessageHandler) SetErrorHandler(handler func(motan.Request, error) motan.Response) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.errorHandler = handler
+}
+
+// errorResponse returns the response of the error handler, or the default response if the hook is nil, returns nil or panics
+func (d *DefaultMessageHandler) errorResponse(request motan.Request, err error, defaultResponse motan.Response) (res motan.Response) {
+	d.lock.RLock()
+	handler := d.errorHandler
+	d.lock.RUnlock()
+	if handler == nil {
+		return defaultResponse
+	}
+	defer motan.HandleRequestPanic(request, func() {
+		res = defaultResponse
+	})
+	if res = handler(request, err); res == nil {
+		res = defaultResponse
+	}
+	return res
+}
+
+func (d *DefaultMessageHandler) panicResponse(request motan.Request, stat *callMetrics) motan.Response {
+	stat.recordPanic(request)
+	return d.errorResponse(request, ErrProviderPanic,
+		motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 500, ErrMsg: "provider call panic", ErrType: motan.ServiceException}))
+}
+
 func (d *DefaultMessageHandler) Call(request motan.Request) (res motan.Response) {
-	defer motan.HandlePanic(func() {
-		res = motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 500, ErrMsg: "provider call panic", ErrType: motan.ServiceException})
-		vlog.Errorf("provider call panic. req:%s", motan.GetReqInfo(request))
+	start := time.Now()
+	var stat *callMetrics
+	var slo *sloTracker
+	var slowLog *slowRequestDetector
+	// deferred before the panic recovery, so the panic responses are recorded
+	defer func() {
+		stat.record(request, start, res)
+		slo.observe(request, start, res)
+		slowLog.observe(request, start, res)
+	}()
+	defer motan.HandleRequestPanicInfo(request, func(info *motan.PanicInfo) {
+		vlog.Errorf("provider call panic. req:%s, error:%v", motan.GetReqInfo(request), info.Err)
+		res = d.panicResponse(request, stat)
+		notifyProviderPanic(request, info)
 	})
-	p := d.providers[request.GetServiceName()]
+	service := request.GetServiceName()
+	d.lock.RLock()
+	p, groups := d.providers[service], d.groups[service]
+	var config *providerConfig
 	if p != nil {
-		res = p.Call(request)
-		res.GetRPCContext(true).GzipSize = int(p.GetURL().GetIntValue(motan.GzipSizeKey, 0))
+		// the config of the selected provider, or the config of the default provider if all groups are unavailable
+		selected := groups.selectProvider(request.GetAttachment(mpro.MGroup), request.GetMethod())
+		if config = d.configs[selected]; config == nil {
+			config = d.configs[p]
+		}
+		p = selected
+	}
+	d.lock.RUnlock()
+	if config != nil {
+		stat, slo, slowLog = config.metrics, config.slo, config.slowLog
+	}
+	stat.begin()
+	if config != nil {
+		if p == nil {
+			vlog.Warningf("all groups are unavailable for %s", motan.GetReqInfo(request))
+			return overloadResponse(request, config.retryAfter, "no available provider for "+request.GetServiceName()+", all groups are unavailable", 1)
+		}
+		if res = config.acls.check(request); res != nil {
+			return res
+		}
+		if res = config.sunsets.check(request); res != nil {
+			return res
+		}
+		if res = config.admission.admit(request, config.retryAfter); res != nil {
+			return res
+		}
+		if res = config.gcAdmit.admit(request, config.retryAfter); res != nil {
+			return res
+		}
+		if res = config.limiter.acquire(request, config.retryAfter); res != nil {
+			return res
+		}
+		defer config.limiter.release()
+		motan.ExtractCallerVersion(request)
+		motan.ExtractBaggage(request, int(p.GetURL().GetPositiveIntValue(motan.MaxBaggageItemsKey, motan.DefaultMaxBaggageItems)),
+			int(p.GetURL().GetPositiveIntValue(motan.MaxBaggageSizeKey, motan.DefaultMaxBaggageSize)))
+		limit := getAttachmentLimit(p.GetURL())
+		if key, ok := limit.apply(request, "request"); !ok {
+			return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 400, ErrMsg: "request attachment value too large, key: " + key, ErrType: motan.ServiceException})
+		}
+		callWithProfileLabels(p.GetURL(), request, func() {
+			callStart := time.Now()
+			if timeout, ok := requestTimeout(p.GetURL(), config.timeouts, config.adaptive, request); ok {
+				res = callWithTimeout(p, request, timeout, func(request motan.Request) motan.Response {
+					return d.panicResponse(request, stat)
+				})
+			} else {
+				res = p.Call(request)
+			}
+			// the durations of the streams are not the latencies of the method
+			if motan.GetStream(request) == nil {
+				config.adaptive.observe(request.GetMethod(), time.Since(callStart))
+			}
+		})
+		res = shapeResponse(request, res)
+		res = correctRequestID(request, res)
+		res = config.itemLimits.apply(request, res)
+		res = config.compression.apply(request, res)
+		res = config.formats.apply(request, res)
+		config.sunsets.warn(request, res)
+		config.retry.advertise(res)
+		if key, ok := limit.apply(res, "response"); !ok {
+			return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 500, ErrMsg: "response attachment value too large, key: " + key, ErrType: motan.ServiceException})
+		}
+		// the hints are bounded by MaxPreloadHintsKey, so they are not truncated by the attachment limit
+		addPreloadHints(p.GetURL(), request, res)
+		rc := res.GetRPCContext(true)
+		rc.GzipSize = getGzipSize(p, config, request)
+		rc.Compression = negotiateCompression(p, request, res)
 		return res
 	}
 	vlog.Errorf("not found provider for %s", motan.GetReqInfo(request))
-	return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 500, ErrMsg: "not found provider for " + request.GetServiceName(), ErrType: motan.ServiceException})
+	recordNotFound()
+	return d.errorResponse(request, ErrProviderNotFound,
+		motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 500, ErrMsg: "not found provider for " + request.GetServiceName(), ErrType: motan.ServiceException}))
+}
+
+// correctRequestID returns a copy of the response with the request id of the request if the provider responds another one,
+// so the clients always correlate the response correctly. the response is copied because it may be shared by the provider
+func correctRequestID(request motan.Request, res motan.Response) motan.Response {
+	if res == nil || res.GetRequestID() == request.GetRequestID() {
+		return res
+	}
+	vlog.Warningf("provider responds mismatched request id %d, it is overwritten. req:%s", res.GetRequestID(), motan.GetReqInfo(request))
+	r := &motan.MotanResponse{RequestID: request.GetRequestID(), Value: res.GetValue(), Exception: res.GetException(), ProcessTime: res.GetProcessTime()}
+	if attachments := res.GetAttachments(); attachments != nil {
+		r.Attachment = attachments.Copy()
+	}
+	r.RPCContext = res.GetRPCContext(false)
+	return r
+}
+
+func getGzipSize(p motan.Provider, config *providerConfig, request motan.Request) int {
+	if request.GetAttachment(NoCompressKey) == "true" && p.GetURL().GetParam(AllowNoCompressKey, "") == "true" {
+		return 0
+	}
+	return int(atomic.LoadInt64(config.gzipSize))
+}
+
+// negotiateCompression returns the algorithm of the response accepted by the client, the algorithms supported by the
+// provider are advertised in the response so the client can compress the requests with them
+func negotiateCompression(p motan.Provider, request motan.Request, res motan.Response) string {
+	accepted := request.GetAttachment(mpro.MAcceptCompression)
+	if accepted == "" {
+		return ""
+	}
+	supported := mpro.SupportedCompressions(p.GetURL().GetParam(motan.CompressionKey, ""))
+	res.SetAttachment(mpro.MAcceptCompression, strings.Join(supported, ","))
+	return mpro.NegotiateCompression(accepted, supported)
+}
+
+// SetGzipSize changes the min size of the responses to compress of the provider while serving, 0 disables the compression.
+// it returns false if the provider is not added. the size is reset to motan.GzipSizeKey of the provider url if it is added again
+func (d *DefaultMessageHandler) SetGzipSize(p motan.Provider, size int) bool {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	if c := d.configs[p]; c != nil {
+		atomic.StoreInt64(c.gzipSize, int64(size))
+		return true
+	}
+	return false
+}
+
+// SetMethodACL replaces the callers allowlist of a method of the providers of the service at runtime, nil callers removes
+// the acl so all callers are allowed. it returns false if the service is not added. the acl is reset to the provider url
+// if the provider is added again
+func (d *DefaultMessageHandler) SetMethodACL(service string, method string, callers []string) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	groups := d.groups[service]
+	for _, p := range groups {
+		if c := d.configs[p]; c != nil {
+			// the config is copied on write, so the calls in processing keep the acls they checked
+			config := *c
+			config.acls = c.acls.with(method, callers)
+			d.configs[p] = &config
+		}
+	}
+	if len(groups) > 0 {
+		vlog.Infof("acl of %s.%s changed to %v", service, method, callers)
+	}
+	return len(groups) > 0
+}
+
+// SetRetryBudget changes the retry budget advertised by the responses of the service, e.g. lower it when the server is
+// under pressure. it only takes effect for the providers which advertise retry budget in the url, and returns false if
+// none of the providers of the service does. the budget is reset to the provider url if the provider is added again
+func (d *DefaultMessageHandler) SetRetryBudget(service string, budget int64) bool {
+	if budget < 0 {
+		budget = 0
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	changed := false
+	for _, p := range d.groups[service] {
+		c := d.configs[p]
+		if c == nil || c.retry == nil {
+			continue
+		}
+		if c.retry.budget != budget {
+			vlog.Infof("retry budget of %s changed from %d to %d", p.GetURL().GetIdentity(), c.retry.budget, budget)
+		}
+		config := *c
+		config.retry = &retryPolicy{budget: budget, backoff: c.retry.backoff}
+		d.configs[p] = &config
+		changed = true
+	}
+	return changed
 }
 
 type FilterProviderWrapper struct {
 	provider motan.Provider
 	filter   motan.EndPointFilter
+	skipping *filterSkipping
+	// violation of the filter constraints, the export fails with it
+	err error
+	// requests in processing, and whether new requests are rejected since the provider is unexported
+	inflight int64
+	closing  int32
 }
 
 func (f *FilterProviderWrapper) SetService(s interface{}) {
@@ -205,22 +989,71 @@ func (f *FilterProviderWrapper) Destroy() {
 	f.provider.Destroy()
 }
 
+func (f *FilterProviderWrapper) unwrap() motan.Provider {
+	return f.provider
+}
+
 func (f *FilterProviderWrapper) Call(request motan.Request) (res motan.Response) {
+	// count before checking closing, so a request passing the check is always drained
+	atomic.AddInt64(&f.inflight, 1)
+	defer atomic.AddInt64(&f.inflight, -1)
+	if atomic.LoadInt32(&f.closing) == 1 {
+		return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 503, ErrMsg: "provider is unexported: " + f.GetPath(), ErrType: motan.ServiceException})
+	}
+	if f.filter == nil {
+		// no filter configured, call the provider as the last endpoint filter does
+		if ctx := request.GetRPCContext(false); ctx != nil && ctx.Tc != nil {
+			ctx.Tc.PutReqSpan(&motan.Span{Name: motan.EpFilterEnd, Addr: f.provider.GetURL().GetAddressStr(), Time: time.Now()})
+		}
+		return f.provider.Call(request)
+	}
+	if f.skipping != nil {
+		f.skipping.resolve(request)
+	}
 	return f.filter.Filter(f.provider, request)
 }
 
+// FilterCheckKey is the provider url parameter of how the violations of filter constraints(see motan.FilterConstraint) are handled,
+// the export fails by default, or only a warning is logged with FilterCheckWarn
+const FilterCheckKey = "filterCheck"
+
+const (
+	FilterCheckStrict = "strict"
+	FilterCheckWarn   = "warn"
+)
+
 func WrapWithFilter(provider motan.Provider, extFactory motan.ExtensionFactory, context *motan.Context) motan.Provider {
+	provider = WrapWithQuiesce(WrapWithIdempotency(WrapWithDelta(WrapWithETag(WrapWithPagination(WrapWithSchemaAdapter(WrapWithMemoize(WrapWithCoalesce(WrapWithPanicPolicy(WrapWithInterceptors(provider))))))))))
 	var lastf motan.EndPointFilter
 	lastf = motan.GetLastEndPointFilter()
+	skipping := newFilterSkipping(provider.GetURL())
 	_, filters := motan.GetURLFilters(provider.GetURL(), extFactory)
+	err := motan.CheckFilterConstraints(filters)
+	if err != nil && provider.GetURL().GetParam(FilterCheckKey, FilterCheckStrict) == FilterCheckWarn {
+		vlog.Warningf("filters of provider %s are not compatible. err: %v", provider.GetPath(), err)
+		err = nil
+	}
+	// the filters are sorted by index in descending order, so the filter of the smallest index is the outermost
+	order := make([]string, 0, len(filters))
 	for _, f := range filters {
 		if filter := f.NewFilter(provider.GetURL()); filter != nil {
 			if ef, ok := filter.(motan.EndPointFilter); ok {
 				motan.CanSetContext(ef, context)
 				ef.SetNext(lastf)
 				lastf = ef
+				if skipping != nil && skipping.skippable[ef.GetName()] {
+					lastf = &skippableFilter{EndPointFilter: ef}
+				}
+				order = append(order, fmt.Sprintf("%s(%d)", ef.GetName(), ef.GetIndex()))
 			}
 		}
 	}
-	return &FilterProviderWrapper{provider: provider, filter: lastf}
+	if lastf == motan.GetLastEndPointFilter() {
+		return &FilterProviderWrapper{provider: provider}
+	}
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+	vlog.Infof("filters of provider %s in call order: %s", provider.GetPath(), strings.Join(order, " -> "))
+	return &FilterProviderWrapper{provider: provider, filter: lastf, skipping: skipping, err: err}
 }
@@ -0,0 +1,2138 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/metrics"
+	mpro "github.com/weibocom/motan-go/protocol"
+	"github.com/weibocom/motan-go/provider"
+)
+
+type testProvider struct {
+	url         *motan.URL
+	callFunc    func(request motan.Request) motan.Response
+	initFunc    func()
+	destroyFunc func()
+	unavailable bool
+}
+
+func (t *testProvider) SetService(s interface{}) {}
+
+func (t *testProvider) GetURL() *motan.URL {
+	return t.url
+}
+
+func (t *testProvider) SetURL(url *motan.URL) {
+	t.url = url
+}
+
+func (t *testProvider) IsAvailable() bool {
+	return !t.unavailable
+}
+
+func (t *testProvider) Call(request motan.Request) motan.Response {
+	if t.callFunc != nil {
+		return t.callFunc(request)
+	}
+	return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: "ok"}
+}
+
+func (t *testProvider) Initialize() {
+	if t.initFunc != nil {
+		t.initFunc()
+	}
+}
+
+func (t *testProvider) Destroy() {
+	if t.destroyFunc != nil {
+		t.destroyFunc()
+	}
+}
+
+func (t *testProvider) GetPath() string {
+	return t.url.Path
+}
+
+func newTestProvider(path string, params map[string]string) *testProvider {
+	url := &motan.URL{Protocol: "motan2", Host: "127.0.0.1", Port: 8100, Path: path, Group: "test", Parameters: map[string]string{}}
+	for k, v := range params {
+		url.PutParam(k, v)
+	}
+	return &testProvider{url: url}
+}
+
+func newTestHandler(providers ...motan.Provider) *DefaultMessageHandler {
+	handler := &DefaultMessageHandler{}
+	handler.Initialize()
+	for _, p := range providers {
+		handler.AddProvider(p)
+	}
+	return handler
+}
+
+func newTestRequest(service string, method string) *motan.MotanRequest {
+	return &motan.MotanRequest{RequestID: 1, ServiceName: service, Method: method}
+}
+
+// newGroupTestProvider returns a provider of the group responding the group
+func newGroupTestProvider(path string, group string, params map[string]string) *testProvider {
+	p := newTestProvider(path, params)
+	p.url.Group = group
+	p.callFunc = func(request motan.Request) motan.Response {
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: group}
+	}
+	return p
+}
+
+func newGroupTestRequest(service string, method string, group string) *motan.MotanRequest {
+	request := newTestRequest(service, method)
+	request.SetAttachment(mpro.MGroup, group)
+	return request
+}
+
+// newArgsTestRequest returns the test request with the arguments and the attachments, the empty attachments are not set
+func newArgsTestRequest(service string, method string, attachments map[string]string, args ...interface{}) *motan.MotanRequest {
+	request := newTestRequest(service, method)
+	for k, v := range attachments {
+		if v != "" {
+			request.SetAttachment(k, v)
+		}
+	}
+	if len(args) > 0 {
+		request.Arguments = args
+	}
+	return request
+}
+
+func TestAttachmentValueLimit(t *testing.T) {
+	large := strings.Repeat("a", 20)
+	p := newTestProvider("truncate", map[string]string{MaxAttachmentValueSizeKey: "10"})
+	p.callFunc = func(request motan.Request) motan.Response {
+		assert.Equal(t, 10, len(request.GetAttachment("big")))
+		assert.Equal(t, "small", request.GetAttachment("small"))
+		assert.Equal(t, "aaaaaaaa", request.GetAttachment("multiByte"))
+		res := &motan.MotanResponse{RequestID: request.GetRequestID()}
+		res.SetAttachment("big", large)
+		return res
+	}
+	rejectProvider := newTestProvider("reject", map[string]string{MaxAttachmentValueSizeKey: "10", AttachmentOverflowKey: AttachmentOverflowReject})
+	noLimitProvider := newTestProvider("noLimit", nil)
+	handler := newTestHandler(p, rejectProvider, noLimitProvider)
+
+	request := newTestRequest("truncate", "test")
+	request.SetAttachment("big", large)
+	request.SetAttachment("small", "small")
+	// 8 bytes and the 3-byte character, it is not split by the 10-byte limit
+	request.SetAttachment("multiByte", "aaaaaaaa中")
+	res := handler.Call(request)
+	assert.Nil(t, res.GetException())
+	assert.Equal(t, 10, len(res.GetAttachment("big")))
+
+	request = newTestRequest("reject", "test")
+	request.SetAttachment("big", large)
+	res = handler.Call(request)
+	assert.NotNil(t, res.GetException())
+	assert.Equal(t, 400, res.GetException().ErrCode)
+	assert.Contains(t, res.GetException().ErrMsg, "big")
+
+	request = newTestRequest("noLimit", "test")
+	request.SetAttachment("big", large)
+	res = handler.Call(request)
+	assert.Nil(t, res.GetException())
+}
+
+func TestNoCompressAttachment(t *testing.T) {
+	p := newTestProvider("compress", map[string]string{motan.GzipSizeKey: "100"})
+	allowed := newTestProvider("allowed", map[string]string{motan.GzipSizeKey: "100", AllowNoCompressKey: "true"})
+	handler := newTestHandler(p, allowed)
+
+	request := newTestRequest("compress", "test")
+	request.SetAttachment(NoCompressKey, "true")
+	res := handler.Call(request)
+	assert.Equal(t, 100, res.GetRPCContext(true).GzipSize)
+
+	request = newTestRequest("allowed", "test")
+	res = handler.Call(request)
+	assert.Equal(t, 100, res.GetRPCContext(true).GzipSize)
+	request.SetAttachment(NoCompressKey, "true")
+	res = handler.Call(request)
+	assert.Equal(t, 0, res.GetRPCContext(true).GzipSize)
+}
+
+func TestSetGzipSize(t *testing.T) {
+	p := newTestProvider("gzipSize", map[string]string{motan.RegistryKey: "", motan.GzipSizeKey: "100"})
+	handler := newTestHandler(p)
+	exporter := &DefaultExporter{}
+	exporter.SetProvider(p)
+	assert.NotNil(t, exporter.SetGzipSize(10))
+	assert.Nil(t, exporter.Export(&MotanServer{URL: &motan.URL{}, handler: handler}, nil, &motan.Context{}))
+	res := handler.Call(newTestRequest("gzipSize", "test"))
+	assert.Equal(t, 100, res.GetRPCContext(true).GzipSize)
+
+	// disabled on the fly
+	assert.Nil(t, exporter.SetGzipSize(0))
+	res = handler.Call(newTestRequest("gzipSize", "test"))
+	assert.Equal(t, 0, res.GetRPCContext(true).GzipSize)
+	assert.Nil(t, exporter.SetGzipSize(20))
+	res = handler.Call(newTestRequest("gzipSize", "test"))
+	assert.Equal(t, 20, res.GetRPCContext(true).GzipSize)
+	assert.NotNil(t, exporter.SetGzipSize(-1))
+	// the provider url is not changed
+	assert.Equal(t, "100", p.GetURL().GetParam(motan.GzipSizeKey, ""))
+
+	// reset to the url value after export again
+	exporter.Unexport()
+	assert.False(t, handler.SetGzipSize(p, 10))
+	assert.Nil(t, exporter.Export(&MotanServer{URL: &motan.URL{}, handler: handler}, nil, &motan.Context{}))
+	res = handler.Call(newTestRequest("gzipSize", "test"))
+	assert.Equal(t, 100, res.GetRPCContext(true).GzipSize)
+	exporter.Unexport()
+}
+
+func TestMethodTimeouts(t *testing.T) {
+	for _, table := range []string{"{", `{"hello":0}`, `{"hello":-1}`, `{"":100}`} {
+		_, err := parseMethodTimeouts(newTestProvider("test", map[string]string{MethodTimeoutsKey: table}).GetURL())
+		assert.NotNil(t, err, table)
+	}
+	timeouts, err := parseMethodTimeouts(newTestProvider("test", map[string]string{MethodTimeoutsKey: `{"Slow":50}`}).GetURL())
+	assert.Nil(t, err)
+	assert.Equal(t, 50*time.Millisecond, timeouts.get("slow"))
+	assert.Equal(t, time.Duration(0), timeouts.get("fast"))
+
+	p := newTestProvider("timeout", map[string]string{MethodTimeoutsKey: `{"slow":50}`})
+	p.callFunc = func(request motan.Request) motan.Response {
+		time.Sleep(200 * time.Millisecond)
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: "ok"}
+	}
+	handler := newTestHandler(p)
+	start := time.Now()
+	res := handler.Call(newTestRequest("timeout", "slow"))
+	assert.True(t, time.Since(start) < 150*time.Millisecond)
+	assert.NotNil(t, res.GetException())
+	assert.Equal(t, 503, res.GetException().ErrCode)
+
+	res = handler.Call(newTestRequest("timeout", "other"))
+	assert.Nil(t, res.GetException())
+	assert.Equal(t, "ok", res.GetValue())
+}
+
+func TestRequestTimeout(t *testing.T) {
+	var calls int32
+	p := newTestProvider("requestTimeout", map[string]string{motan.TimeOutKey: "50"})
+	p.callFunc = func(request motan.Request) motan.Response {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(200 * time.Millisecond)
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: "ok"}
+	}
+	handler := newTestHandler(p)
+	start := time.Now()
+	res := handler.Call(newTestRequest("requestTimeout", "hello"))
+	assert.True(t, time.Since(start) < 150*time.Millisecond)
+	assert.Equal(t, 503, res.GetException().ErrCode)
+	assert.Equal(t, motan.TimeoutException, res.GetException().ErrType)
+
+	// the remaining timeout of the caller
+	request := newTestRequest("requestTimeout", "hello")
+	request.SetAttachment(mpro.MTimeout, "500")
+	request.GetRPCContext(true).RequestReceiveTime = time.Now().Add(-450 * time.Millisecond)
+	start = time.Now()
+	res = handler.Call(request)
+	assert.True(t, time.Since(start) < 150*time.Millisecond)
+	assert.Equal(t, motan.TimeoutException, res.GetException().ErrType)
+	// the provider is not called if the caller timeout is exceeded
+	request.GetRPCContext(true).RequestReceiveTime = time.Now().Add(-time.Second)
+	atomic.StoreInt32(&calls, 0)
+	res = handler.Call(request)
+	assert.Equal(t, motan.TimeoutException, res.GetException().ErrType)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&calls))
+
+	// not limited if the timeout is not positive
+	p.url.PutParam(motan.TimeOutKey, "0")
+	res = handler.Call(newTestRequest("requestTimeout", "hello"))
+	assert.Nil(t, res.GetException())
+	timeout, ok := requestTimeout(newTestProvider("requestTimeout", nil).GetURL(), nil, nil, newTestRequest("requestTimeout", "hello"))
+	assert.True(t, ok)
+	assert.Equal(t, defaultRequestTimeout, timeout)
+}
+
+type contextService struct {
+	canceled chan error
+}
+
+func (c *contextService) Wait(ctx context.Context, name string) string {
+	<-ctx.Done()
+	c.canceled <- ctx.Err()
+	return "canceled " + name
+}
+
+func (c *contextService) Deadline(ctx context.Context, name string) bool {
+	_, ok := ctx.Deadline()
+	return ok
+}
+
+func TestRequestContext(t *testing.T) {
+	service := &contextService{canceled: make(chan error, 1)}
+	p := &provider.DefaultProvider{}
+	p.SetURL(&motan.URL{Path: "context", Group: "test", Parameters: map[string]string{motan.TimeOutKey: "50"}})
+	p.SetService(service)
+	p.Initialize()
+	handler := newTestHandler(p)
+	start := time.Now()
+	res := handler.Call(newArgsTestRequest("context", "wait", nil, "motan"))
+	assert.True(t, time.Since(start) < 150*time.Millisecond)
+	assert.Equal(t, motan.TimeoutException, res.GetException().ErrType)
+	// the provider is canceled by the timeout
+	select {
+	case err := <-service.canceled:
+		assert.Equal(t, context.DeadlineExceeded, err)
+	case <-time.After(time.Second):
+		t.Error("the context of the provider is not canceled")
+	}
+	res = handler.Call(newArgsTestRequest("context", "deadline", nil, "motan"))
+	assert.Nil(t, res.GetException())
+	assert.Equal(t, true, res.GetValue().(reflect.Value).Interface())
+	// no deadline if the call is not limited
+	p.GetURL().PutParam(motan.TimeOutKey, "0")
+	res = handler.Call(newArgsTestRequest("context", "deadline", nil, "motan"))
+	assert.Equal(t, false, res.GetValue().(reflect.Value).Interface())
+	assert.Equal(t, context.Background(), motan.RequestContext(newTestRequest("context", "deadline")))
+}
+
+func TestAdaptiveTimeout(t *testing.T) {
+	assert.Nil(t, parseAdaptiveTimeouts(newTestProvider("adaptive", nil).GetURL()))
+	var gauges []int64
+	addCallGauge = func(group string, service string, key string, value int64) {
+		assert.Equal(t, "motan-server-handler:unknown:hello"+AdaptiveTimeoutMetricSuffix, key)
+		gauges = append(gauges, value)
+	}
+	defer func() {
+		addCallGauge = metrics.AddGauge
+	}()
+	url := newTestProvider("adaptive", map[string]string{AdaptiveTimeoutKey: "true", AdaptiveTimeoutFactorKey: "2",
+		AdaptiveTimeoutMinKey: "5", AdaptiveTimeoutMaxKey: "100"}).GetURL()
+	adaptive := parseAdaptiveTimeouts(url)
+	for i := 0; i < adaptiveTimeoutInterval-1; i++ {
+		adaptive.observe("hello", 10*time.Millisecond)
+	}
+	_, ok := adaptive.get("hello")
+	assert.False(t, ok)
+	adaptive.observe("hello", 10*time.Millisecond)
+	timeout, ok := adaptive.get("hello")
+	assert.True(t, ok)
+	assert.Equal(t, 20*time.Millisecond, timeout)
+	// bounded by the max
+	for i := 0; i < adaptiveTimeoutInterval; i++ {
+		adaptive.observe("hello", 200*time.Millisecond)
+	}
+	timeout, _ = adaptive.get("hello")
+	assert.Equal(t, 100*time.Millisecond, timeout)
+	assert.Equal(t, []int64{20, 100}, gauges)
+
+	// the learned timeout replaces the requestTimeout and limits the caller timeout
+	request := newTestRequest("adaptive", "hello")
+	timeout, ok = requestTimeout(url, nil, adaptive, request)
+	assert.True(t, ok)
+	assert.Equal(t, 100*time.Millisecond, timeout)
+	request.SetAttachment(mpro.MTimeout, "500")
+	timeout, _ = requestTimeout(url, nil, adaptive, request)
+	assert.Equal(t, 100*time.Millisecond, timeout)
+	request.SetAttachment(mpro.MTimeout, "50")
+	timeout, _ = requestTimeout(url, nil, adaptive, request)
+	assert.Equal(t, 50*time.Millisecond, timeout)
+	timeout, _ = requestTimeout(url, methodTimeouts{"hello": 300 * time.Millisecond}, adaptive, request)
+	assert.Equal(t, 300*time.Millisecond, timeout)
+
+	// the handler learns from the calls
+	addCallGauge = func(string, string, string, int64) {}
+	var slow int32
+	p := newTestProvider("adaptive", map[string]string{AdaptiveTimeoutKey: "true", AdaptiveTimeoutMinKey: "20"})
+	p.callFunc = func(request motan.Request) motan.Response {
+		if atomic.LoadInt32(&slow) == 1 {
+			time.Sleep(200 * time.Millisecond)
+		}
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: "ok"}
+	}
+	handler := newTestHandler(p)
+	for i := 0; i < adaptiveTimeoutInterval; i++ {
+		assert.Nil(t, handler.Call(newTestRequest("adaptive", "hello")).GetException())
+	}
+	atomic.StoreInt32(&slow, 1)
+	start := time.Now()
+	res := handler.Call(newTestRequest("adaptive", "hello"))
+	assert.True(t, time.Since(start) < 150*time.Millisecond)
+	assert.Equal(t, motan.TimeoutException, res.GetException().ErrType)
+}
+
+func TestQueueTimeAdmission(t *testing.T) {
+	for _, value := range []string{"{", `{"hello":0}`, `{"":10}`} {
+		_, err := newQueueTimeAdmission(newTestProvider("test", map[string]string{QueueTimeSLOKey: value}).GetURL())
+		assert.NotNil(t, err, value)
+	}
+	handler := newTestHandler(newTestProvider("slo", map[string]string{QueueTimeSLOKey: `{"hello":20}`}))
+	fast, slow, other := newTestRequest("slo", "hello"), newTestRequest("slo", "hello"), newTestRequest("slo", "world")
+	fast.GetRPCContext(true).RequestReceiveTime = time.Now()
+	slow.GetRPCContext(true).RequestReceiveTime = time.Now().Add(-100 * time.Millisecond)
+	other.GetRPCContext(true).RequestReceiveTime = time.Now().Add(-100 * time.Millisecond)
+	assert.Nil(t, handler.Call(fast).GetException())
+	var res motan.Response
+	for i := 0; i < 20; i++ {
+		res = handler.Call(slow)
+	}
+	assert.NotNil(t, res.GetException())
+	assert.Equal(t, 503, res.GetException().ErrCode)
+	// other methods are not affected
+	assert.Nil(t, handler.Call(other).GetException())
+	// recover after queue time drops
+	for i := 0; i < 30; i++ {
+		fast.GetRPCContext(true).RequestReceiveTime = time.Now()
+		res = handler.Call(fast)
+	}
+	assert.Nil(t, res.GetException())
+}
+
+func TestGCAdmission(t *testing.T) {
+	for _, value := range []string{"abc", "0", "-1"} {
+		_, err := parseGCAdmission(newTestProvider("test", map[string]string{GCShedPauseRatioKey: value}).GetURL())
+		assert.NotNil(t, err, value)
+	}
+	a, err := parseGCAdmission(newTestProvider("test", map[string]string{}).GetURL())
+	assert.Nil(t, err)
+	assert.Nil(t, a)
+	handler := newTestHandler(newTestProvider("gc", map[string]string{GCShedPauseRatioKey: "0.1", GCShedFrequencyKey: "5", GCShedMethodsKey: "report"}))
+	monitor := &gcPressureMonitor{}
+	handler.configs[handler.providers["gc"]].gcAdmit.monitor = monitor
+	now := time.Now()
+	monitor.sample(&runtime.MemStats{PauseTotalNs: 0, NumGC: 0}, now)
+	// 5% pause, 2 gc per second
+	monitor.sample(&runtime.MemStats{PauseTotalNs: uint64(50 * time.Millisecond), NumGC: 2}, now.Add(time.Second))
+	assert.Nil(t, handler.Call(newTestRequest("gc", "report")).GetException())
+
+	// 20% pause
+	monitor.sample(&runtime.MemStats{PauseTotalNs: uint64(250 * time.Millisecond), NumGC: 4}, now.Add(2*time.Second))
+	res := handler.Call(newTestRequest("gc", "report"))
+	assert.NotNil(t, res.GetException())
+	assert.Equal(t, 503, res.GetException().ErrCode)
+	// high priority requests are not shed
+	assert.Nil(t, handler.Call(newTestRequest("gc", "hello")).GetException())
+	request := newTestRequest("gc", "hello")
+	request.SetAttachment(PriorityAttachment, PriorityLow)
+	assert.NotNil(t, handler.Call(request).GetException())
+
+	// 1% pause, but 10 gc per second
+	monitor.sample(&runtime.MemStats{PauseTotalNs: uint64(260 * time.Millisecond), NumGC: 14}, now.Add(3*time.Second))
+	assert.NotNil(t, handler.Call(newTestRequest("gc", "report")).GetException())
+	// recover after the pressure drops
+	monitor.sample(&runtime.MemStats{PauseTotalNs: uint64(270 * time.Millisecond), NumGC: 15}, now.Add(4*time.Second))
+	assert.Nil(t, handler.Call(newTestRequest("gc", "report")).GetException())
+}
+
+func TestMaxWorker(t *testing.T) {
+	for _, value := range []string{"abc", "-1"} {
+		_, err := parseConcurrencyLimiter(newTestProvider("test", map[string]string{MaxWorkerKey: value}).GetURL())
+		assert.NotNil(t, err, value)
+	}
+	l, err := parseConcurrencyLimiter(newTestProvider("test", map[string]string{MaxWorkerKey: "0"}).GetURL())
+	assert.Nil(t, err)
+	assert.Nil(t, l)
+	recorder := &callMetricsRecorder{counts: make(map[string]int64)}
+	var lock sync.Mutex
+	var gauges []int64
+	addCallCounter = recorder.add
+	addCallGauge = func(group string, service string, key string, value int64) {
+		if key == "motan-server-handler:unknown:"+ConcurrencyInflightMetric {
+			lock.Lock()
+			gauges = append(gauges, value)
+			lock.Unlock()
+		}
+	}
+	defer func() {
+		addCallCounter, addCallGauge = metrics.AddCounter, metrics.AddGauge
+	}()
+	p := newTestProvider("worker", map[string]string{MaxWorkerKey: "2"})
+	release := make(chan struct{})
+	p.callFunc = func(request motan.Request) motan.Response {
+		if request.GetMethod() == "block" {
+			<-release
+		}
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: "ok"}
+	}
+	handler := newTestHandler(p)
+	var done sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			assert.Nil(t, handler.Call(newTestRequest("worker", "block")).GetException())
+		}()
+	}
+	for i := 0; i < 100 && atomic.LoadInt64(&handler.configs[handler.providers["worker"]].limiter.inflight) < 2; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	res := handler.Call(newTestRequest("worker", "hello"))
+	assert.NotNil(t, res.GetException())
+	assert.Equal(t, 503, res.GetException().ErrCode)
+	assert.Equal(t, int64(1), recorder.count("test", "worker", "motan-server-handler:unknown:"+ConcurrencyRejectedMetric))
+	close(release)
+	done.Wait()
+	assert.Nil(t, handler.Call(newTestRequest("worker", "hello")).GetException())
+	lock.Lock()
+	assert.Equal(t, 6, len(gauges))
+	assert.Contains(t, gauges, int64(2))
+	assert.Equal(t, int64(0), gauges[len(gauges)-1])
+	lock.Unlock()
+}
+
+func TestMethodACL(t *testing.T) {
+	p := newTestProvider("acl", map[string]string{MethodACLPrefix + "delete": "admin, ops", MethodACLPrefix + "list": "*", MethodACLPrefix + "drop": ""})
+	handler := newTestHandler(p)
+	call := func(method string, caller string) motan.Response {
+		request := newTestRequest("acl", method)
+		if caller != "" {
+			request.SetAttachment(mpro.MSource, caller)
+		}
+		return handler.Call(request)
+	}
+	assert.Nil(t, call("delete", "ops").GetException())
+	res := call("delete", "guest")
+	assert.NotNil(t, res.GetException())
+	assert.Equal(t, 403, res.GetException().ErrCode)
+	assert.Equal(t, 403, call("delete", "").GetException().ErrCode)
+	assert.Equal(t, 403, call("drop", "admin").GetException().ErrCode)
+	assert.Nil(t, call("list", "guest").GetException())
+	// methods without acl are allowed
+	assert.Nil(t, call("hello", "guest").GetException())
+
+	// update at runtime
+	assert.True(t, handler.SetMethodACL("acl", "delete", []string{"guest"}))
+	assert.Nil(t, call("delete", "guest").GetException())
+	assert.Equal(t, 403, call("delete", "ops").GetException().ErrCode)
+	assert.True(t, handler.SetMethodACL("acl", "delete", nil))
+	assert.Nil(t, call("delete", "ops").GetException())
+	assert.False(t, handler.SetMethodACL("noACL", "delete", nil))
+
+	// the acls belong to the provider, the provider added again without acl allows all callers
+	handler.RmProvider(p)
+	assert.Nil(t, handler.AddProvider(newTestProvider("acl", nil)))
+	assert.Nil(t, call("drop", "admin").GetException())
+}
+
+func TestPreloadHints(t *testing.T) {
+	handler := newTestHandler(newTestProvider("preload", map[string]string{MaxPreloadHintsKey: "2"}))
+	RegisterPreloadHintsFunc("preload", func(request motan.Request) []string {
+		return []string{"user:" + request.GetAttachment(mpro.MSource), "config", "dict"}
+	})
+	defer RegisterPreloadHintsFunc("preload", nil)
+	request := newTestRequest("preload", "hello")
+	res := handler.Call(request)
+	assert.Nil(t, motan.GetPreloadHints(res))
+
+	request = newTestRequest("preload", "hello")
+	request.SetAttachment(mpro.MSource, "app")
+	request.SetAttachment(motan.PreloadHintsRequestAttachment, "true")
+	res = handler.Call(request)
+	assert.Equal(t, []string{"user:app", "config"}, motan.GetPreloadHints(res))
+}
+
+func TestMaxResponseItems(t *testing.T) {
+	for _, value := range []string{"{", `{"list":{"max":0}}`, `{"":{"max":1}}`, `{"list":{"max":1,"policy":"drop"}}`} {
+		_, err := parseResponseItemLimits(newTestProvider("test", map[string]string{MaxResponseItemsKey: value}).GetURL())
+		assert.NotNil(t, err, value)
+	}
+	p := newTestProvider("items", map[string]string{MaxResponseItemsKey: `{"list":{"max":2,"policy":"truncate"},"query":{"max":2},"map":{"max":1,"policy":"truncate"}}`})
+	p.callFunc = func(request motan.Request) motan.Response {
+		res := &motan.MotanResponse{RequestID: request.GetRequestID(), Value: []string{"a", "b", "c"}}
+		if request.GetMethod() == "map" {
+			res.Value = map[string]int{"a": 1, "b": 2}
+		}
+		return res
+	}
+	handler := newTestHandler(p)
+	res := handler.Call(newTestRequest("items", "list"))
+	assert.Nil(t, res.GetException())
+	assert.Equal(t, []string{"a", "b"}, res.GetValue())
+	assert.Equal(t, "3", res.GetAttachment(TruncatedAttachment))
+	res = handler.Call(newTestRequest("items", "query"))
+	assert.NotNil(t, res.GetException())
+	// maps can not be truncated
+	assert.NotNil(t, handler.Call(newTestRequest("items", "map")).GetException())
+	// methods without limit are not affected
+	res = handler.Call(newTestRequest("items", "other"))
+	assert.Nil(t, res.GetException())
+	assert.Equal(t, 3, len(res.GetValue().([]string)))
+}
+
+func TestPagination(t *testing.T) {
+	for _, value := range []string{"{", `{"list":0}`, `{"":1}`} {
+		_, err := parsePaginateMethods(newTestProvider("test", map[string]string{PaginateMethodsKey: value}).GetURL())
+		assert.NotNil(t, err, value)
+	}
+	p := newTestProvider("pages", map[string]string{PaginateMethodsKey: `{"list":2}`})
+	p.callFunc = func(request motan.Request) motan.Response {
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: []string{"a", "b", "c", "d", "e"}}
+	}
+	provider := WrapWithPagination(p)
+	var pages [][]string
+	cursor := ""
+	for {
+		res := provider.Call(newArgsTestRequest("pages", "list", map[string]string{PageCursorAttachment: cursor}, "q"))
+		assert.Nil(t, res.GetException())
+		assert.Equal(t, "5", res.GetAttachment(PageTotalAttachment))
+		pages = append(pages, res.GetValue().([]string))
+		if cursor = res.GetAttachment(PageCursorAttachment); cursor == "" {
+			break
+		}
+	}
+	assert.Equal(t, [][]string{{"a", "b"}, {"c", "d"}, {"e"}}, pages)
+
+	// the cursor can not be used for other arguments
+	next := provider.Call(newArgsTestRequest("pages", "list", nil, "q")).GetAttachment(PageCursorAttachment)
+	assert.Equal(t, 400, provider.Call(newArgsTestRequest("pages", "list", map[string]string{PageCursorAttachment: next}, "other")).GetException().ErrCode)
+	assert.Equal(t, 400, provider.Call(newArgsTestRequest("pages", "list", map[string]string{PageCursorAttachment: "!bad"}, "q")).GetException().ErrCode)
+	// methods without pagination are not affected
+	assert.Equal(t, 5, len(provider.Call(newArgsTestRequest("pages", "other", nil, "q")).GetValue().([]string)))
+}
+
+func TestETag(t *testing.T) {
+	p := newTestProvider("etag", map[string]string{ETagMethodsKey: "get,version"})
+	value := map[string]string{"name": "motan"}
+	p.callFunc = func(request motan.Request) motan.Response {
+		res := &motan.MotanResponse{RequestID: request.GetRequestID(), Value: value}
+		if request.GetMethod() == "version" {
+			res.SetAttachment(ETagAttachment, "v1")
+		}
+		return res
+	}
+	provider := WrapWithETag(p)
+	res := provider.Call(newTestRequest("etag", "get"))
+	etag := res.GetAttachment(ETagAttachment)
+	assert.NotEqual(t, "", etag)
+	assert.Equal(t, value, res.GetValue())
+	res = provider.Call(newArgsTestRequest("etag", "get", map[string]string{IfNoneMatchAttachment: etag}))
+	assert.Nil(t, res.GetValue())
+	assert.Equal(t, "true", res.GetAttachment(NotModifiedAttachment))
+	assert.Equal(t, etag, res.GetAttachment(ETagAttachment))
+
+	// changed value
+	value = map[string]string{"name": "motan2"}
+	res = provider.Call(newArgsTestRequest("etag", "get", map[string]string{IfNoneMatchAttachment: etag}))
+	assert.Equal(t, value, res.GetValue())
+	assert.NotEqual(t, etag, res.GetAttachment(ETagAttachment))
+
+	// etag set by the provider
+	res = provider.Call(newArgsTestRequest("etag", "version", map[string]string{IfNoneMatchAttachment: "v1"}))
+	assert.Nil(t, res.GetValue())
+	assert.Equal(t, "true", res.GetAttachment(NotModifiedAttachment))
+	// methods without etag are not affected
+	res = provider.Call(newArgsTestRequest("etag", "other", map[string]string{IfNoneMatchAttachment: etag}))
+	assert.Equal(t, "", res.GetAttachment(ETagAttachment))
+	assert.Equal(t, value, res.GetValue())
+	_, ok := WrapWithETag(newTestProvider("etag", nil)).(*testProvider)
+	assert.True(t, ok)
+}
+
+type lengthDeltaStrategy struct{}
+
+func (l *lengthDeltaStrategy) Name() string {
+	return "length"
+}
+
+func (l *lengthDeltaStrategy) Version(value interface{}) (string, error) {
+	if len(value.([]int)) > 2 {
+		return "", errors.New("too long")
+	}
+	return strconv.Itoa(len(value.([]int))), nil
+}
+
+func (l *lengthDeltaStrategy) Diff(base interface{}, value interface{}) (interface{}, bool, error) {
+	return value.([]int)[len(base.([]int)):], true, nil
+}
+
+func TestDelta(t *testing.T) {
+	p := newTestProvider("delta", map[string]string{DeltaMethodsKey: "get,List", DeltaHistoryKey: "2"})
+	value := "a large resource v1"
+	list := []int{1}
+	p.callFunc = func(request motan.Request) motan.Response {
+		if request.GetMethod() == "list" {
+			return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: list}
+		}
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: value}
+	}
+	provider := WrapWithDelta(p)
+	res := provider.Call(newTestRequest("delta", "get"))
+	v1 := res.GetAttachment(motan.DeltaVersionAttachment)
+	assert.NotEqual(t, "", v1)
+	assert.Equal(t, value, res.GetValue())
+	assert.Equal(t, "", res.GetAttachment(motan.DeltaAttachment))
+
+	value = "a large resource v2"
+	res = provider.Call(newArgsTestRequest("delta", "get", map[string]string{motan.DeltaBaseAttachment: v1}))
+	v2 := res.GetAttachment(motan.DeltaVersionAttachment)
+	assert.NotEqual(t, v1, v2)
+	assert.Equal(t, motan.BytesDelta, res.GetAttachment(motan.DeltaAttachment))
+	patched, err := motan.PatchBytes([]byte("a large resource v1"), res.GetValue().([]byte))
+	assert.Nil(t, err)
+	assert.Equal(t, value, string(patched))
+
+	// the base is not kept any more or unknown, full value is sent
+	value = "a large resource v3"
+	provider.Call(newTestRequest("delta", "get"))
+	for _, base := range []string{v1, "unknown"} {
+		res = provider.Call(newArgsTestRequest("delta", "get", map[string]string{motan.DeltaBaseAttachment: base}))
+		assert.Equal(t, value, res.GetValue())
+		assert.Equal(t, "", res.GetAttachment(motan.DeltaAttachment))
+	}
+	// full value is sent if the delta is not smaller
+	value = "xyz"
+	res = provider.Call(newArgsTestRequest("delta", "get", map[string]string{motan.DeltaBaseAttachment: v2}))
+	assert.Equal(t, value, res.GetValue())
+	// methods without delta are not affected
+	res = provider.Call(newArgsTestRequest("delta", "other", map[string]string{motan.DeltaBaseAttachment: v2}))
+	assert.Equal(t, "", res.GetAttachment(motan.DeltaVersionAttachment))
+
+	// registered strategy
+	RegisterDeltaStrategy("delta", "list", &lengthDeltaStrategy{})
+	defer RegisterDeltaStrategy("delta", "list", nil)
+	res = provider.Call(newTestRequest("delta", "list"))
+	assert.Equal(t, "1", res.GetAttachment(motan.DeltaVersionAttachment))
+	list = []int{1, 2}
+	res = provider.Call(newArgsTestRequest("delta", "list", map[string]string{motan.DeltaBaseAttachment: "1"}))
+	assert.Equal(t, "length", res.GetAttachment(motan.DeltaAttachment))
+	assert.Equal(t, []int{2}, res.GetValue())
+	list = []int{1, 2, 3}
+	res = provider.Call(newArgsTestRequest("delta", "list", map[string]string{motan.DeltaBaseAttachment: "2"}))
+	assert.Equal(t, list, res.GetValue())
+	assert.Equal(t, "", res.GetAttachment(motan.DeltaVersionAttachment))
+
+	_, ok := WrapWithDelta(newTestProvider("delta", nil)).(*testProvider)
+	assert.True(t, ok)
+}
+
+func TestSchemaAdapter(t *testing.T) {
+	p := newTestProvider("schema", map[string]string{SchemaVersionKey: "3"})
+	p.callFunc = func(request motan.Request) motan.Response {
+		// version 3 takes the full name
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: "hello " + request.GetArguments()[0].(string)}
+	}
+	RegisterSchemaAdapter("schema", "hello", SchemaAdapter{
+		Version: "2",
+		Upgrade: func(request motan.Request) error {
+			request.GetArguments()[0] = request.GetArguments()[0].(string) + " smith"
+			return nil
+		},
+		Downgrade: func(request motan.Request, response motan.Response) motan.Response {
+			return copyResponseWithValue(response, response.GetValue().(string)+"!")
+		},
+	})
+	RegisterSchemaAdapter("schema", "hello", SchemaAdapter{
+		Version: "1",
+		Upgrade: func(request motan.Request) error {
+			if len(request.GetArguments()) == 0 {
+				return errors.New("no name")
+			}
+			return nil
+		},
+		Downgrade: func(request motan.Request, response motan.Response) motan.Response {
+			return copyResponseWithValue(response, strings.ToUpper(response.GetValue().(string)))
+		},
+	})
+	defer UnregisterSchemaAdapters("schema", "hello")
+	provider := WrapWithSchemaAdapter(p)
+	call := func(version string, args ...interface{}) motan.Response {
+		request := newTestRequest("schema", "hello")
+		request.Arguments = args
+		if version != "" {
+			request.SetAttachment(SchemaVersionAttachment, version)
+		}
+		return provider.Call(request)
+	}
+	assert.Equal(t, "hello john", call("", "john").GetValue())
+	assert.Equal(t, "hello john", call("3", "john").GetValue())
+	assert.Equal(t, "hello john smith!", call("2", "john").GetValue())
+	// downgrade in reverse order
+	assert.Equal(t, "HELLO JOHN SMITH!", call("1", "john").GetValue())
+	res := call("1")
+	assert.NotNil(t, res.GetException())
+	assert.Equal(t, 400, res.GetException().ErrCode)
+
+	plain := newTestProvider("schema", nil)
+	assert.Equal(t, plain, WrapWithSchemaAdapter(plain))
+}
+
+func TestPanicPolicy(t *testing.T) {
+	_, err := parsePanicPolicy(newTestProvider("panic", map[string]string{OnPanicKey: "ignore"}).GetURL())
+	assert.NotNil(t, err)
+	p := newTestProvider("panic", nil)
+	p.callFunc = func(request motan.Request) motan.Response {
+		if request.GetMethod() == "panic" {
+			panic("corrupted")
+		}
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: "ok"}
+	}
+	assert.Equal(t, p, WrapWithPanicPolicy(p))
+
+	crashed := make(chan interface{}, 1)
+	defer func(f func(err interface{})) { crashProcess = f }(crashProcess)
+	crashProcess = func(err interface{}) { crashed <- err }
+	p.url.PutParam(OnPanicKey, PanicPolicyCrash)
+	provider := WrapWithPanicPolicy(p)
+	assert.Equal(t, 500, provider.Call(newTestRequest("panic", "panic")).GetException().ErrCode)
+	assert.Contains(t, (<-crashed).(string), "policy crash")
+
+	events := &shutdownEvents{}
+	p.url.PutParam(OnPanicKey, PanicPolicyUnavailable)
+	provider = WrapWithPanicPolicy(p)
+	exporter := &DefaultExporter{provider: provider, url: p.GetURL(), exported: true, available: true, registered: true,
+		Registries: []motan.Registry{&recordRegistry{events: events}}}
+	panicPolicyProvider(&FilterProviderWrapper{provider: provider}).bind(exporter)
+	handler := newTestHandler(provider)
+	assert.Equal(t, "ok", handler.Call(newTestRequest("panic", "hello")).GetValue())
+	assert.Equal(t, 500, handler.Call(newTestRequest("panic", "panic")).GetException().ErrCode)
+	assert.False(t, provider.IsAvailable())
+	assert.Equal(t, 503, handler.Call(newTestRequest("panic", "hello")).GetException().ErrCode)
+	for i := 0; i < 100 && len(events.get()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, []string{"unregister"}, events.get())
+	assert.False(t, exporter.IsAvailable())
+	assert.Equal(t, 0, len(crashed))
+}
+
+func TestOnProviderPanic(t *testing.T) {
+	p := newTestProvider("onPanic", nil)
+	p.callFunc = func(request motan.Request) motan.Response {
+		panic("provider panic")
+	}
+	handler := newTestHandler(p)
+	type recovered struct {
+		request motan.Request
+		err     interface{}
+		stack   []byte
+	}
+	var panics []recovered
+	OnProviderPanic(func(request motan.Request, err interface{}, stack []byte) {
+		panics = append(panics, recovered{request: request, err: err, stack: stack})
+		if request.GetMethod() == "panic" {
+			panic("panic handler panic")
+		}
+	})
+	defer OnProviderPanic(nil)
+
+	// called with timeout in another goroutine, and without timeout
+	for _, timeout := range []string{"1000", "0"} {
+		p.url.PutParam(motan.TimeOutKey, timeout)
+		request := newTestRequest("onPanic", "hello")
+		res := handler.Call(request)
+		assert.Equal(t, 500, res.GetException().ErrCode)
+		assert.Equal(t, "provider call panic", res.GetException().ErrMsg)
+		recent := panics[len(panics)-1]
+		assert.Equal(t, request, recent.request)
+		assert.Equal(t, "provider panic", recent.err)
+		assert.Contains(t, string(recent.stack), "goroutine")
+	}
+	assert.Equal(t, 2, len(panics))
+	// the panic of the handler is recovered
+	assert.Equal(t, 500, handler.Call(newTestRequest("onPanic", "panic")).GetException().ErrCode)
+	assert.Equal(t, 3, len(panics))
+
+	OnProviderPanic(nil)
+	assert.Equal(t, 500, handler.Call(newTestRequest("onPanic", "hello")).GetException().ErrCode)
+	assert.Equal(t, 3, len(panics))
+}
+
+func TestHandlerDestroy(t *testing.T) {
+	var destroyed int32
+	release := make(chan struct{})
+	var providers []motan.Provider
+	for _, path := range []string{"a", "b", "b"} {
+		p := newTestProvider(path, nil)
+		if len(providers) == 2 {
+			p.url.Group = "other"
+		}
+		p.destroyFunc = func() { atomic.AddInt32(&destroyed, 1) }
+		p.callFunc = func(request motan.Request) motan.Response {
+			if request.GetMethod() == "block" {
+				<-release
+			}
+			return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: "ok"}
+		}
+		providers = append(providers, p)
+	}
+	handler := newTestHandler(providers...)
+	inflight := make(chan motan.Response, 1)
+	go func() {
+		inflight <- handler.Call(newTestRequest("a", "block"))
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	handler.Destroy()
+	assert.Equal(t, int32(3), atomic.LoadInt32(&destroyed))
+	assert.Equal(t, 0, len(handler.GetProviders()))
+	for _, service := range []string{"a", "b"} {
+		res := handler.Call(newTestRequest(service, "hello"))
+		assert.Equal(t, 500, res.GetException().ErrCode)
+	}
+	// the call in processing is not interrupted
+	close(release)
+	assert.Equal(t, "ok", (<-inflight).GetValue())
+
+	handler.Destroy()
+	assert.Equal(t, int32(3), atomic.LoadInt32(&destroyed))
+	// providers can be added again
+	assert.Nil(t, handler.AddProvider(providers[0]))
+	assert.Equal(t, "ok", handler.Call(newTestRequest("a", "hello")).GetValue())
+}
+
+func TestErrorHandler(t *testing.T) {
+	p := newTestProvider("errors", nil)
+	p.callFunc = func(request motan.Request) motan.Response {
+		panic("provider panic")
+	}
+	handler := newTestHandler(p)
+	res := handler.Call(newTestRequest("errors", "hello"))
+	assert.Equal(t, 500, res.GetException().ErrCode)
+	assert.Equal(t, "provider call panic", res.GetException().ErrMsg)
+	res = handler.Call(newTestRequest("missing", "hello"))
+	assert.Equal(t, 500, res.GetException().ErrCode)
+
+	var handled []error
+	handler.SetErrorHandler(func(request motan.Request, err error) motan.Response {
+		handled = append(handled, err)
+		switch err {
+		case ErrProviderNotFound:
+			return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 404, ErrMsg: "no service " + request.GetServiceName(), ErrType: motan.ServiceException})
+		case ErrProviderPanic:
+			if request.GetMethod() == "default" {
+				return nil
+			}
+			if request.GetMethod() == "panic" {
+				panic("error handler panic")
+			}
+			return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: "envelope"}
+		}
+		return nil
+	})
+	res = handler.Call(newTestRequest("missing", "hello"))
+	assert.Equal(t, 404, res.GetException().ErrCode)
+	assert.Equal(t, "envelope", handler.Call(newTestRequest("errors", "hello")).GetValue())
+	// the default responses are returned if the hook returns nil or panics
+	assert.Equal(t, 500, handler.Call(newTestRequest("errors", "default")).GetException().ErrCode)
+	assert.Equal(t, 500, handler.Call(newTestRequest("errors", "panic")).GetException().ErrCode)
+	assert.Equal(t, []error{ErrProviderNotFound, ErrProviderPanic, ErrProviderPanic, ErrProviderPanic}, handled)
+	// the panic of the provider called without timeout
+	p.url.PutParam(motan.TimeOutKey, "0")
+	assert.Equal(t, "envelope", handler.Call(newTestRequest("errors", "hello")).GetValue())
+
+	handler.SetErrorHandler(nil)
+	assert.Equal(t, 500, handler.Call(newTestRequest("missing", "hello")).GetException().ErrCode)
+}
+
+func TestGroupProviders(t *testing.T) {
+	g1, g2 := newGroupTestProvider("groups", "g1", nil), newGroupTestProvider("groups", "g2", nil)
+	handler := newTestHandler(g1, g2)
+	assert.Equal(t, "g1", handler.Call(newGroupTestRequest("groups", "test", "g1")).GetValue())
+	assert.Equal(t, "g2", handler.Call(newGroupTestRequest("groups", "test", "g2")).GetValue())
+	g2.unavailable = true
+	assert.Equal(t, "g1", handler.Call(newGroupTestRequest("groups", "test", "g2")).GetValue())
+	g1.unavailable = true
+	res := handler.Call(newGroupTestRequest("groups", "test", "g1"))
+	assert.NotNil(t, res.GetException())
+	assert.Equal(t, 503, res.GetException().ErrCode)
+
+	// a single provider is called regardless of its availability
+	handler.RmProvider(g1)
+	assert.Equal(t, "g2", handler.Call(newGroupTestRequest("groups", "test", "g1")).GetValue())
+	assert.Equal(t, g2, handler.GetProvider("groups"))
+	handler.RmProvider(g2)
+	assert.Nil(t, handler.GetProvider("groups"))
+}
+
+func TestGroupProviderConfigs(t *testing.T) {
+	defer func() { sunsetNow = time.Now }()
+	sunsetNow = func() time.Time {
+		return time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	}
+	g1 := newGroupTestProvider("groupConfigs", "g1", nil)
+	g2 := newGroupTestProvider("groupConfigs", "g2", map[string]string{MethodSunsetsKey: `{"retired":"2024-01-01"}`})
+	handler := newTestHandler(g1, g2)
+	// each group keeps its own config
+	assert.Nil(t, handler.Call(newGroupTestRequest("groupConfigs", "retired", "g1")).GetException())
+	assert.Equal(t, 410, handler.Call(newGroupTestRequest("groupConfigs", "retired", "g2")).GetException().ErrCode)
+	assert.True(t, handler.SetGzipSize(g1, 100))
+	assert.Equal(t, 100, handler.Call(newGroupTestRequest("groupConfigs", "hello", "g1")).GetRPCContext(true).GzipSize)
+	assert.Equal(t, 0, handler.Call(newGroupTestRequest("groupConfigs", "hello", "g2")).GetRPCContext(true).GzipSize)
+
+	// the config of the removed provider is removed with it
+	handler.RmProvider(g2)
+	assert.Equal(t, "g1", handler.Call(newGroupTestRequest("groupConfigs", "retired", "g2")).GetValue())
+	assert.Nil(t, handler.configs[g2])
+	assert.False(t, handler.SetGzipSize(g2, 100))
+}
+
+func TestConcurrentProviders(t *testing.T) {
+	handler := newTestHandler()
+	stable := newTestProvider("stable", nil)
+	handler.AddProvider(stable)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				p := newTestProvider("changing", nil)
+				handler.AddProvider(p)
+				handler.RmProvider(p)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				res := handler.Call(newTestRequest("stable", "hello"))
+				assert.Nil(t, res.GetException())
+				handler.Call(newTestRequest("changing", "hello"))
+				handler.GetProvider("changing")
+				handler.GetProviders()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, stable, handler.GetProvider("stable"))
+
+	// a replaced provider does not remove the new one
+	old, replaced := newTestProvider("replaced", nil), newTestProvider("replaced", nil)
+	handler.AddProvider(old)
+	handler.AddProvider(replaced)
+	handler.RmProvider(old)
+	assert.Equal(t, replaced, handler.GetProvider("replaced"))
+}
+
+func TestGetProviders(t *testing.T) {
+	a, b := newTestProvider("a", nil), newTestProvider("b", map[string]string{motan.VersionKey: "1.0"})
+	b2 := newTestProvider("b", nil)
+	b2.url.Group = "other"
+	b2.unavailable = true
+	handler := newTestHandler(b, a)
+	providers := handler.GetProviders()
+	assert.Equal(t, []motan.Provider{a, b}, providers)
+	handler.AddProvider(b2)
+	// the snapshot is not changed by adds and removes
+	assert.Equal(t, 2, len(providers))
+	assert.Equal(t, []motan.Provider{a, b2, b}, handler.GetProviders())
+
+	exporter := &DefaultExporter{provider: b, url: b.GetURL(), exported: true, available: false}
+	registerExporter(exporter)
+	defer unregisterExporter(exporter)
+	assert.Equal(t, []ServiceInfo{
+		{Path: "a", Group: "test", Protocol: "motan2", Port: 8100, Available: true},
+		{Path: "b", Group: "other", Protocol: "motan2", Port: 8100, Available: false},
+		{Path: "b", Group: "test", Version: "1.0", Protocol: "motan2", Port: 8100, Available: false},
+	}, GetServices(handler))
+	handler.RmProvider(a)
+	handler.RmProvider(b2)
+	services := GetServices(handler)
+	assert.Equal(t, 1, len(services))
+	assert.Equal(t, "b", services[0].Path)
+	assert.Nil(t, GetServices(nil))
+}
+
+func TestFieldCompression(t *testing.T) {
+	for _, value := range []string{"{", `{"get":[]}`, `{"":["content"]}`} {
+		_, err := parseFieldCompression(newTestProvider("test", map[string]string{CompressFieldsKey: value}).GetURL())
+		assert.NotNil(t, err, value)
+	}
+	content := strings.Repeat("content", 100)
+	p := newTestProvider("compress", map[string]string{CompressFieldsKey: `{"get":["content"]}`, FieldCompressMinSizeKey: "100"})
+	p.callFunc = func(request motan.Request) motan.Response {
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: map[string]interface{}{"content": content, "id": "1"}}
+	}
+	handler := newTestHandler(p)
+	res := handler.Call(newTestRequest("compress", "get"))
+	assert.Equal(t, "gzip:content=s", res.GetAttachment(mpro.MFieldCompress))
+	value := res.GetValue().(map[string]interface{})
+	assert.Nil(t, mpro.DecompressFields(value, res.GetAttachment(mpro.MFieldCompress)))
+	assert.Equal(t, content, value["content"])
+
+	res = handler.Call(newTestRequest("compress", "other"))
+	assert.Equal(t, "", res.GetAttachment(mpro.MFieldCompress))
+	assert.Equal(t, content, res.GetValue().(map[string]interface{})["content"])
+}
+
+func TestBaggageExtract(t *testing.T) {
+	p := newTestProvider("baggage", map[string]string{motan.MaxBaggageItemsKey: "1"})
+	p.callFunc = func(request motan.Request) motan.Response {
+		assert.Equal(t, motan.Baggage{"a": "1"}, motan.GetBaggage(request))
+		return &motan.MotanResponse{RequestID: request.GetRequestID()}
+	}
+	request := newTestRequest("baggage", "test")
+	request.SetAttachment(motan.BaggagePrefix+"a", "1")
+	request.SetAttachment(motan.BaggagePrefix+"b", "2")
+	newTestHandler(p).Call(request)
+	assert.Equal(t, "", request.GetAttachment(motan.BaggagePrefix+"b"))
+}
+
+type methodNamesTestProvider struct {
+	*testProvider
+	names []string
+}
+
+func (m *methodNamesTestProvider) GetMethodNames() []string {
+	return m.names
+}
+
+func TestProfileLabels(t *testing.T) {
+	p := newTestProvider("profile", map[string]string{ProfileLabelsKey: "true"})
+	var labeled bool
+	p.callFunc = func(request motan.Request) motan.Response {
+		var buf bytes.Buffer
+		pprof.Lookup("goroutine").WriteTo(&buf, 1)
+		labeled = strings.Contains(buf.String(), `"method":"hello"`) && strings.Contains(buf.String(), `"service":"profile"`)
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: "ok"}
+	}
+	handler := newTestHandler(p)
+	assert.Nil(t, handler.Call(newTestRequest("profile", "hello")).GetException())
+	assert.True(t, labeled)
+	p.url.PutParam(ProfileLabelsKey, "false")
+	handler.Call(newTestRequest("profile", "hello"))
+	assert.False(t, labeled)
+}
+
+func TestMaxMethods(t *testing.T) {
+	p := &methodNamesTestProvider{testProvider: newTestProvider("methods", map[string]string{MaxMethodsKey: "2", motan.RegistryKey: "direct"}), names: []string{"a", "b"}}
+	assert.Nil(t, checkMaxMethods(p))
+	p.names = append(p.names, "c")
+	err := checkMaxMethods(&FilterProviderWrapper{provider: &MemoizeProviderWrapper{baseProviderWrapper: baseProviderWrapper{provider: p}}})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "by 1")
+	// providers without known methods are not checked
+	assert.Nil(t, checkMaxMethods(newTestProvider("unknown", map[string]string{MaxMethodsKey: "1"})))
+
+	exporter := &DefaultExporter{}
+	exporter.SetProvider(p)
+	assert.NotNil(t, exporter.Export(nil, nil, &motan.Context{}))
+	assert.False(t, exporter.IsAvailable())
+}
+
+func TestNamingValidator(t *testing.T) {
+	direct := map[string]string{motan.RegistryKey: "direct"}
+	_, err := NewNamingValidator(NamingRules{Method: "("})
+	assert.NotNil(t, err)
+	validator, err := NewNamingValidator(NamingRules{Path: `^com\.weibo\.[a-z.]+Service$`, Group: "^[a-z-]+$", Method: "^[A-Z][A-Za-z]*$"})
+	assert.Nil(t, err)
+	SetNamingValidator(validator)
+	defer SetNamingValidator(nil)
+
+	assert.Nil(t, checkNaming(&methodNamesTestProvider{testProvider: newGroupTestProvider("com.weibo.userService", "user-rpc", direct), names: []string{"Get", "Set"}}))
+	err = checkNaming(&methodNamesTestProvider{testProvider: newGroupTestProvider("userService", "user-rpc", direct), names: []string{"Get"}})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "service path userService")
+	err = checkNaming(&methodNamesTestProvider{testProvider: newGroupTestProvider("com.weibo.userService", "user_rpc", direct), names: []string{"Get"}})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "group user_rpc")
+	err = checkNaming(&MemoizeProviderWrapper{baseProviderWrapper: baseProviderWrapper{provider: &methodNamesTestProvider{testProvider: newGroupTestProvider("com.weibo.userService", "user-rpc", direct), names: []string{"Get", "get_all"}}}})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "method get_all")
+	// the methods of providers without known methods are not checked
+	assert.Nil(t, checkNaming(&methodNamesTestProvider{testProvider: newGroupTestProvider("com.weibo.userService", "user-rpc", direct)}))
+
+	exporter := &DefaultExporter{}
+	exporter.SetProvider(&methodNamesTestProvider{testProvider: newGroupTestProvider("userService", "user-rpc", direct), names: []string{"Get"}})
+	err = exporter.Export(nil, nil, &motan.Context{})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "violates the naming rule")
+	assert.False(t, exporter.IsAvailable())
+}
+
+type pluginService struct{}
+
+func (p *pluginService) Hello(name string) string {
+	return "hello " + name
+}
+
+func TestDynamicMethods(t *testing.T) {
+	url := &motan.URL{Path: "plugin", Parameters: map[string]string{MaxMethodsKey: "2"}}
+	p := &provider.DefaultProvider{}
+	p.SetURL(url)
+	p.SetService(&pluginService{})
+	p.Initialize()
+	handler := newTestHandler(&MemoizeProviderWrapper{baseProviderWrapper: baseProviderWrapper{provider: p}})
+	assert.NotNil(t, handler.Call(newArgsTestRequest("plugin", "world", nil, "motan")).GetException())
+	assert.Nil(t, handler.AddMethod("plugin", "world", func(name string) string { return "world " + name }))
+	res := handler.Call(newArgsTestRequest("plugin", "world", nil, "motan"))
+	assert.Nil(t, res.GetException())
+	// DefaultProvider returns the reflect value of the result
+	assert.Equal(t, "world motan", res.GetValue().(reflect.Value).Interface())
+	res = handler.Call(newArgsTestRequest("plugin", "hello", nil, "motan"))
+	assert.Nil(t, res.GetException())
+	assert.Equal(t, "hello motan", res.GetValue().(reflect.Value).Interface())
+	// the added methods are kept if the provider is initialized again, e.g. exported again
+	p.Initialize()
+	assert.Nil(t, handler.Call(newArgsTestRequest("plugin", "world", nil, "motan")).GetException())
+
+	// the max methods limit is checked
+	assert.NotNil(t, handler.AddMethod("plugin", "other", func() {}))
+	assert.NotNil(t, handler.Call(newArgsTestRequest("plugin", "other", nil, "motan")).GetException())
+	assert.NotNil(t, handler.AddMethod("plugin", "bad", "not a func"))
+	assert.NotNil(t, handler.AddMethod("missing", "world", func() {}))
+	// the naming rules are checked
+	validator, _ := NewNamingValidator(NamingRules{Method: "^[a-z]+$"})
+	SetNamingValidator(validator)
+	err := handler.AddMethod("plugin", "other_1", func() {})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "violates the naming rule")
+	SetNamingValidator(nil)
+	assert.NotNil(t, newTestHandler(newTestProvider("static", nil)).AddMethod("static", "world", func() {}))
+
+	assert.True(t, handler.RemoveMethod("plugin", "world"))
+	assert.False(t, handler.RemoveMethod("plugin", "world"))
+	assert.NotNil(t, handler.Call(newArgsTestRequest("plugin", "world", nil, "motan")).GetException())
+}
+
+type readService struct{}
+
+func (r *readService) Get(key string) string {
+	return "get " + key
+}
+
+func (r *readService) Set(key string) string {
+	return "read only " + key
+}
+
+type writeService struct{}
+
+func (w *writeService) Set(key string) string {
+	return "set " + key
+}
+
+func (w *writeService) Delete(key string) string {
+	return "delete " + key
+}
+
+func TestMethodRouting(t *testing.T) {
+	reader, writer := &provider.DefaultProvider{}, &provider.DefaultProvider{}
+	reader.SetURL(&motan.URL{Path: "split", Group: "test", Parameters: map[string]string{}})
+	reader.SetService(&readService{})
+	reader.Initialize()
+	writer.SetURL(&motan.URL{Path: "split", Group: "test", Parameters: map[string]string{}})
+	writer.SetService(&writeService{})
+	writer.Initialize()
+	handler := newTestHandler(reader, writer)
+	call := func(method string) string {
+		request := newTestRequest("split", method)
+		request.Arguments = []interface{}{"k"}
+		res := handler.Call(request)
+		if res.GetException() != nil {
+			return res.GetException().ErrMsg
+		}
+		return res.GetValue().(reflect.Value).Interface().(string)
+	}
+	assert.Equal(t, "get k", call("get"))
+	assert.Equal(t, "delete k", call("Delete"))
+	// the provider added first wins the colliding method
+	assert.Equal(t, "read only k", call("set"))
+	assert.Equal(t, []string{"Set"}, handler.groups["split"].collisions(writer))
+	assert.Nil(t, handler.groups["split"].collisions(newTestProvider("split", nil)))
+	assert.Contains(t, call("missing"), "not found")
+
+	handler.RmProvider(reader)
+	assert.Equal(t, "set k", call("set"))
+	assert.Contains(t, call("get"), "not found")
+	assert.Equal(t, writer, handler.GetProvider("split"))
+
+	// providers without known methods replace the provider of the same group as before
+	unknown := newTestProvider("split", nil)
+	handler.AddProvider(unknown)
+	assert.Equal(t, groupProviders{unknown}, handler.groups["split"])
+}
+
+func TestExportWithoutRegistry(t *testing.T) {
+	server := &MotanServer{URL: &motan.URL{}, handler: newTestHandler()}
+	exporter := &DefaultExporter{}
+	exporter.SetProvider(newTestProvider("missing", nil))
+	err := exporter.Export(server, nil, &motan.Context{})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "registry not found")
+
+	exporter = &DefaultExporter{}
+	exporter.SetProvider(newTestProvider("empty", map[string]string{motan.RegistryKey: " "}))
+	assert.Nil(t, exporter.Export(server, nil, &motan.Context{}))
+	assert.True(t, exporter.IsAvailable())
+	assert.Equal(t, 0, len(exporter.Registries))
+	exporter.Unexport()
+
+	exporter = &DefaultExporter{}
+	exporter.SetProvider(newTestProvider("direct", map[string]string{motan.RegistryKey: "unknown", DirectServeKey: "true"}))
+	assert.Nil(t, exporter.Export(server, nil, &motan.Context{}))
+	assert.Equal(t, 0, len(exporter.Registries))
+	exporter.Unexport()
+}
+
+func TestAdvertiseURLParams(t *testing.T) {
+	server := &MotanServer{URL: &motan.URL{Parameters: map[string]string{SupportedVersionsKey: "0, 1", KeepAlivePeriodKey: "30000"}}, handler: newTestHandler()}
+	exporter := &DefaultExporter{}
+	exporter.SetProvider(newTestProvider("advertise", map[string]string{motan.RegistryKey: " ", SupportedVersionsKey: "0,1"}))
+	assert.Nil(t, exporter.Export(server, nil, &motan.Context{}))
+	assert.Equal(t, "0,1", exporter.GetURL().GetParam(AdvertisedProtocolPrefix+SupportedVersionsKey, ""))
+	assert.Equal(t, "30000", exporter.GetURL().GetParam(AdvertisedProtocolPrefix+KeepAlivePeriodKey, ""))
+	exporter.Unexport()
+
+	// the default versions are advertised
+	server = &MotanServer{URL: &motan.URL{}, handler: newTestHandler()}
+	exporter = &DefaultExporter{}
+	exporter.SetProvider(newTestProvider("advertise", map[string]string{motan.RegistryKey: " "}))
+	assert.Nil(t, exporter.Export(server, nil, &motan.Context{}))
+	assert.Equal(t, "1", exporter.GetURL().GetParam(AdvertisedProtocolPrefix+SupportedVersionsKey, ""))
+	assert.Equal(t, "", exporter.GetURL().GetParam(AdvertisedProtocolPrefix+KeepAlivePeriodKey, ""))
+	exporter.Unexport()
+
+	// the provider declares settings the server does not use
+	exporter = &DefaultExporter{}
+	exporter.SetProvider(newTestProvider("advertise", map[string]string{motan.RegistryKey: " ", SupportedVersionsKey: "0"}))
+	err := exporter.Export(server, nil, &motan.Context{})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "mismatch")
+
+	// placement hints
+	exporter = &DefaultExporter{}
+	exporter.SetProvider(newTestProvider("placement", map[string]string{motan.RegistryKey: " ", motan.PlacementHintsKey: "zone=bj-1,antiAffinity=shard-3"}))
+	assert.Nil(t, exporter.Export(server, nil, &motan.Context{}))
+	assert.Equal(t, map[string]string{motan.PlacementZone: "bj-1", motan.PlacementAntiAffinity: "shard-3"}, motan.GetPlacementHints(exporter.GetURL()))
+	exporter.Unexport()
+	exporter = &DefaultExporter{}
+	exporter.SetProvider(newTestProvider("placement", map[string]string{motan.RegistryKey: " ", motan.PlacementHintsKey: "zone"}))
+	assert.NotNil(t, exporter.Export(server, nil, &motan.Context{}))
+}
+
+func TestRegisterDelay(t *testing.T) {
+	events := &shutdownEvents{}
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	ext.RegistExtRegistry("record", func(url *motan.URL) motan.Registry {
+		return &recordRegistry{events: events}
+	})
+	context := &motan.Context{RegistryURLs: map[string]*motan.URL{"r": {Protocol: "record", Host: "127.0.0.1"}}}
+	server := &MotanServer{URL: &motan.URL{}, handler: newTestHandler()}
+	export := func(delay string) *DefaultExporter {
+		exporter := &DefaultExporter{}
+		exporter.SetProvider(newTestProvider("delay", map[string]string{motan.RegistryKey: "r", RegisterDelayKey: delay}))
+		assert.Nil(t, exporter.Export(server, ext, context))
+		return exporter
+	}
+	exporter := &DefaultExporter{}
+	exporter.SetProvider(newTestProvider("delay", map[string]string{motan.RegistryKey: "r", RegisterDelayKey: "abc"}))
+	assert.NotNil(t, exporter.Export(server, ext, context))
+
+	exporter = export("50")
+	assert.True(t, exporter.IsAvailable())
+	assert.Equal(t, 0, len(events.get()))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, []string{"register"}, events.get())
+	exporter.Unexport()
+
+	// canceled by unexport, the url not registered is not unregistered
+	exporter = export("50")
+	exporter.Unexport()
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, []string{"register", "unregister"}, events.get())
+
+	// registered only when ready
+	readyLock.Lock()
+	readyCh, readyOnce = make(chan struct{}), sync.Once{}
+	readyLock.Unlock()
+	exporter = export("-1")
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 2, len(events.get()))
+	Ready()
+	Ready()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, []string{"register", "unregister", "register"}, events.get())
+	exporter.Unexport()
+}
+
+func TestExporterRegistration(t *testing.T) {
+	events := &shutdownEvents{}
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	ext.RegistExtRegistry("record", func(url *motan.URL) motan.Registry {
+		return &recordRegistry{events: events}
+	})
+	context := &motan.Context{RegistryURLs: map[string]*motan.URL{"r": {Protocol: "record", Host: "127.0.0.1"}}}
+	exporter := &DefaultExporter{}
+	exporter.SetProvider(newTestProvider("registration", map[string]string{motan.RegistryKey: "r"}))
+	server := &MotanServer{URL: &motan.URL{}, handler: newTestHandler(exporter.GetProvider())}
+	assert.Nil(t, exporter.Export(server, ext, context))
+	assert.NotNil(t, exporter.Export(server, ext, context))
+	// the exporter never unregisters twice
+	exporter.unregister()
+	exporter.unregister()
+	exporter.Unexport()
+	exporter.Unexport()
+	assert.Equal(t, []string{"register", "unregister"}, events.get())
+	assert.Nil(t, exporter.Export(server, ext, context))
+	exporter.Unexport()
+	assert.Equal(t, []string{"register", "unregister", "register", "unregister"}, events.get())
+
+	// duplicated registries are registered once
+	exporter = &DefaultExporter{}
+	exporter.SetProvider(newTestProvider("registration", map[string]string{motan.RegistryKey: "r, r,r"}))
+	assert.Nil(t, exporter.Export(server, ext, context))
+	assert.Equal(t, 1, len(exporter.Registries))
+	exporter.Unexport()
+	assert.Equal(t, []string{"register", "unregister", "register", "unregister", "register", "unregister"}, events.get())
+}
+
+func TestExportInvalidRegistries(t *testing.T) {
+	events := &shutdownEvents{}
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	ext.RegistExtRegistry("record", func(url *motan.URL) motan.Registry {
+		return &recordRegistry{events: events}
+	})
+	context := &motan.Context{RegistryURLs: map[string]*motan.URL{
+		"r1":      {Protocol: "record", Host: "127.0.0.1", Port: 1},
+		"r2":      {Protocol: "record", Host: "127.0.0.1", Port: 2},
+		"unknown": {Protocol: "unknownRegistry", Host: "127.0.0.1"},
+	}}
+	export := func(registries string) (*DefaultExporter, error) {
+		exporter := &DefaultExporter{}
+		exporter.SetProvider(newTestProvider("invalidRegistries", map[string]string{motan.RegistryKey: registries}))
+		server := &MotanServer{URL: &motan.URL{}, handler: newTestHandler(exporter.GetProvider())}
+		return exporter, exporter.Export(server, ext, context)
+	}
+
+	// all valid
+	exporter, err := export("r1,r2")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(exporter.Registries))
+	assert.True(t, exporter.IsAvailable())
+	exporter.Unexport()
+
+	// some invalid, exported to the valid ones
+	exporter, err = export("r1,bad,r2,unknown")
+	assert.NotNil(t, err)
+	invalid, ok := err.(*InvalidRegistryError)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"bad", "unknown"}, invalid.Names)
+	assert.Equal(t, "registry is invalid: bad, unknown", err.Error())
+	assert.Equal(t, 2, len(exporter.Registries))
+	assert.True(t, exporter.IsAvailable())
+	assert.NotNil(t, exporter.Export(exporter.server, ext, context))
+	exporter.Unexport()
+	assert.Equal(t, []string{"register", "register", "unregister", "unregister", "register", "register", "unregister", "unregister"}, events.get())
+
+	// all invalid, not exported
+	exporter, err = export("bad,unknown")
+	assert.NotNil(t, err)
+	_, ok = err.(*InvalidRegistryError)
+	assert.False(t, ok)
+	assert.Contains(t, err.Error(), "bad, unknown")
+	assert.False(t, exporter.IsAvailable())
+	assert.Nil(t, exporter.Registries)
+	assert.False(t, exporter.exported)
+	assert.Equal(t, 8, len(events.get()))
+}
+
+func TestReExport(t *testing.T) {
+	events := &shutdownEvents{}
+	registries := make(map[string]*recordRegistry)
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	ext.RegistExtRegistry("record", func(url *motan.URL) motan.Registry {
+		r := &recordRegistry{events: events}
+		registries[url.Host] = r
+		return r
+	})
+	p := newTestProvider("reExport", map[string]string{motan.RegistryKey: "r", motan.PlacementHintsKey: "zone=bj-1"})
+	// the provider can not serve after destroyed until initialized again
+	destroyed := false
+	p.initFunc = func() { destroyed = false }
+	p.destroyFunc = func() { destroyed = true }
+	p.callFunc = func(request motan.Request) motan.Response {
+		if destroyed {
+			return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 503, ErrMsg: "destroyed", ErrType: motan.ServiceException})
+		}
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: "ok"}
+	}
+	exporter := &DefaultExporter{}
+	exporter.SetProvider(p)
+	handler := newTestHandler(p)
+	server := &MotanServer{URL: &motan.URL{}, handler: handler}
+	assert.Nil(t, exporter.Export(server, ext, &motan.Context{RegistryURLs: map[string]*motan.URL{"r": {Protocol: "record", Host: "old"}}}))
+	assert.Equal(t, "bj-1", registries["old"].urls[0].GetParam(motan.PlacementPrefix+motan.PlacementZone, ""))
+	exporter.Unexport()
+	assert.True(t, destroyed)
+	assert.Nil(t, handler.GetProvider("reExport"))
+	assert.Nil(t, exporter.Registries)
+	assert.False(t, exporter.IsAvailable())
+
+	p.url.PutParam("weight", "2")
+	p.url.PutParam(motan.PlacementHintsKey, "rack=r12")
+	assert.Nil(t, exporter.Export(server, ext, &motan.Context{RegistryURLs: map[string]*motan.URL{"r": {Protocol: "record", Host: "new"}}}))
+	assert.Equal(t, []string{"register", "unregister", "register"}, events.get())
+	assert.Equal(t, 1, len(registries["old"].urls))
+	registered := registries["new"].urls[0]
+	assert.Equal(t, "2", registered.GetParam("weight", ""))
+	assert.Equal(t, map[string]string{motan.PlacementRack: "r12"}, motan.GetPlacementHints(registered))
+	assert.Equal(t, p, handler.GetProvider("reExport"))
+	assert.Nil(t, handler.Call(newTestRequest("reExport", "hello")).GetException())
+	assert.True(t, exporter.IsAvailable())
+	exporter.Unexport()
+}
+
+type batchRecordRegistry struct {
+	recordRegistry
+}
+
+func (b *batchRecordRegistry) RegisterBatch(serverURLs []*motan.URL) {
+	b.events.add("batch " + strconv.Itoa(len(serverURLs)))
+}
+
+func TestRegisterBatch(t *testing.T) {
+	events := &shutdownEvents{}
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	ext.RegistExtRegistry("batch", func(url *motan.URL) motan.Registry {
+		r := &batchRecordRegistry{recordRegistry{events: events}}
+		r.SetURL(url)
+		return r
+	})
+	ext.RegistExtRegistry("record", func(url *motan.URL) motan.Registry {
+		r := &recordRegistry{events: events}
+		r.SetURL(url)
+		return r
+	})
+	context := &motan.Context{RegistryURLs: map[string]*motan.URL{
+		"batch":  {Protocol: "batch", Host: "127.0.0.1", Parameters: map[string]string{RegisterBatchWindowKey: "50"}},
+		"record": {Protocol: "record", Host: "127.0.0.1", Parameters: map[string]string{RegisterRateKey: "20"}},
+	}}
+	export := func(registry string, path string) *DefaultExporter {
+		exporter := &DefaultExporter{}
+		exporter.SetProvider(newTestProvider(path, map[string]string{motan.RegistryKey: registry}))
+		assert.Nil(t, exporter.Export(&MotanServer{URL: &motan.URL{}, handler: newTestHandler(exporter.GetProvider())}, ext, context))
+		return exporter
+	}
+	waitEvents := func(count int) {
+		for i := 0; i < 100 && len(events.get()) < count; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// registrations within the window are registered together, the cancelled one is not registered or unregistered
+	exporters := []*DefaultExporter{export("batch", "b1"), export("batch", "b2"), export("batch", "b3")}
+	exporters[1].Unavailable()
+	exporters[2].Unexport()
+	assert.Equal(t, 0, len(events.get()))
+	waitEvents(2)
+	assert.Equal(t, []string{"batch 2", "unavailable"}, events.get())
+	exporters[0].Unexport()
+	exporters[1].Unexport()
+	assert.Equal(t, []string{"batch 2", "unavailable", "unregister", "unregister"}, events.get())
+
+	// individual registrations are rate limited
+	events.events = nil
+	start := time.Now()
+	exporters = []*DefaultExporter{export("record", "r1"), export("record", "r2"), export("record", "r3")}
+	waitEvents(3)
+	assert.Equal(t, []string{"register", "register", "register"}, events.get())
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+	for _, e := range exporters {
+		e.Unexport()
+	}
+	assert.Equal(t, 6, len(events.get()))
+}
+
+// failRegistry fails the first fails registrations
+type failRegistry struct {
+	recordRegistry
+	lock     sync.Mutex
+	fails    int
+	attempts int
+}
+
+func (f *failRegistry) TryRegister(serverURL *motan.URL) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.attempts++
+	if f.attempts <= f.fails {
+		return errors.New("registry unreachable")
+	}
+	f.Register(serverURL)
+	return nil
+}
+
+func (f *failRegistry) getAttempts() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.attempts
+}
+
+func TestRegisterRetry(t *testing.T) {
+	events := &shutdownEvents{}
+	registries := make(map[int]*failRegistry)
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	ext.RegistExtRegistry("fail", func(url *motan.URL) motan.Registry {
+		r := &failRegistry{recordRegistry: recordRegistry{events: events}, fails: int(url.GetIntValue("fails", 0))}
+		r.SetURL(url)
+		registries[url.Port] = r
+		return r
+	})
+	export := func(port int, fails int) *DefaultExporter {
+		context := &motan.Context{RegistryURLs: map[string]*motan.URL{
+			"r": {Protocol: "fail", Host: "127.0.0.1", Port: port, Parameters: map[string]string{"fails": strconv.Itoa(fails)}},
+		}}
+		exporter := &DefaultExporter{}
+		exporter.SetProvider(newTestProvider("registerRetry", map[string]string{motan.RegistryKey: "r",
+			RegisterRetriesKey: "2", RegisterRetryIntervalKey: "1"}))
+		assert.Nil(t, exporter.Export(&MotanServer{URL: &motan.URL{}, handler: newTestHandler(exporter.GetProvider())}, ext, context))
+		return exporter
+	}
+
+	// registered by the retries in Export
+	exporter := export(1, 2)
+	assert.Equal(t, 3, registries[1].getAttempts())
+	assert.Equal(t, []string{"register"}, events.get())
+	exporter.Unexport()
+	assert.Equal(t, []string{"register", "unregister"}, events.get())
+
+	// registered by the background retries
+	events.events = nil
+	exporter = export(2, 6)
+	assert.Equal(t, 3, registries[2].getAttempts())
+	assert.Equal(t, 0, len(events.get()))
+	for i := 0; i < 100 && registries[2].getAttempts() < 7; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 7, registries[2].getAttempts())
+	assert.Equal(t, []string{"register"}, events.get())
+	exporter.Unexport()
+
+	// the background retries are canceled by Unexport
+	events.events = nil
+	exporter = export(3, 1000)
+	for i := 0; i < 100 && registries[3].getAttempts() < 5; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	exporter.Unexport()
+	attempts := registries[3].getAttempts()
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, attempts, registries[3].getAttempts())
+	assert.Equal(t, []string{"unregister"}, events.get())
+}
+
+func TestRegisterPolicy(t *testing.T) {
+	events := &shutdownEvents{}
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	ext.RegistExtRegistry("fail", func(url *motan.URL) motan.Registry {
+		r := &failRegistry{recordRegistry: recordRegistry{events: events}, fails: int(url.GetIntValue("fails", 0))}
+		r.SetURL(url)
+		return r
+	})
+	export := func(policy string, fails ...int) (*DefaultExporter, error) {
+		context := &motan.Context{RegistryURLs: map[string]*motan.URL{}}
+		var names []string
+		for i, f := range fails {
+			name := "r" + strconv.Itoa(i)
+			context.RegistryURLs[name] = &motan.URL{Protocol: "fail", Host: "127.0.0.1", Port: 100 + i, Path: policy,
+				Parameters: map[string]string{"fails": strconv.Itoa(f)}}
+			names = append(names, name)
+		}
+		exporter := &DefaultExporter{}
+		exporter.SetProvider(newTestProvider("registerPolicy", map[string]string{motan.RegistryKey: strings.Join(names, ","),
+			RegisterRetriesKey: "0", RegisterRetryIntervalKey: "1", RegisterPolicyKey: policy}))
+		err := exporter.Export(&MotanServer{URL: &motan.URL{}, handler: newTestHandler(exporter.GetProvider())}, ext, context)
+		return exporter, err
+	}
+	states := func(e *DefaultExporter) []string {
+		var s []string
+		for _, state := range e.GetRegistryStates() {
+			s = append(s, state.State)
+		}
+		return s
+	}
+
+	// best effort keeps the url registered and retries the failed one
+	exporter, err := export(RegisterPolicyBestEffort, 0, 1000)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{RegistryStateRegistered, RegistryStateRetrying}, states(exporter))
+	exporter.Unexport()
+	assert.Equal(t, 0, len(states(exporter)))
+
+	// all unregisters the url if any registration fails
+	events.events = nil
+	exporter, err = export(RegisterPolicyAll, 0, 0, 1000)
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{"register", "register", "unregister", "unregister"}, events.get())
+	assert.Equal(t, []string{RegistryStateFailed, RegistryStateFailed, RegistryStateFailed}, states(exporter))
+	assert.Nil(t, exporter.Unexport())
+
+	// quorum keeps the url registered by the majority
+	events.events = nil
+	exporter, err = export(RegisterPolicyQuorum, 0, 1000, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{RegistryStateRegistered, RegistryStateRetrying, RegistryStateRegistered}, states(exporter))
+	exporter.Unexport()
+	events.events = nil
+	exporter, err = export(RegisterPolicyQuorum, 0, 1000)
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{"register", "unregister"}, events.get())
+
+	// the states are listed by the exported services
+	exporter, err = export(RegisterPolicyAll, 0, 0)
+	assert.Nil(t, err)
+	info := GetServices(newTestHandler(exporter.GetProvider()))
+	assert.Equal(t, 2, len(info[0].Registries))
+	assert.Equal(t, RegistryStateRegistered, info[0].Registries[0].State)
+	exporter.Unexport()
+
+	_, err = export("unknown", 0)
+	assert.NotNil(t, err)
+}
+
+func TestExporterAvailability(t *testing.T) {
+	events := &shutdownEvents{}
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	ext.RegistExtRegistry("record", func(url *motan.URL) motan.Registry {
+		return &recordRegistry{events: events}
+	})
+	context := &motan.Context{RegistryURLs: map[string]*motan.URL{"r": {Protocol: "record", Host: "127.0.0.1"}}}
+	exporter := &DefaultExporter{}
+	exporter.SetProvider(newTestProvider("availability", map[string]string{motan.RegistryKey: "r"}))
+	// ignored before export
+	exporter.Unavailable()
+	assert.False(t, exporter.IsAvailable())
+	server := &MotanServer{URL: &motan.URL{}, handler: newTestHandler(exporter.GetProvider())}
+	assert.Nil(t, exporter.Export(server, ext, context))
+	assert.True(t, exporter.IsAvailable())
+	exporter.Unavailable()
+	exporter.Unavailable()
+	assert.False(t, exporter.IsAvailable())
+	exporter.Available()
+	exporter.Available()
+	assert.True(t, exporter.IsAvailable())
+	exporter.Unexport()
+	assert.Equal(t, []string{"register", "unavailable", "available", "unregister"}, events.get())
+
+	// the availability set before the delayed registration is notified after registration
+	exporter = &DefaultExporter{}
+	exporter.SetProvider(newTestProvider("availability", map[string]string{motan.RegistryKey: "r", RegisterDelayKey: "-1"}))
+	readyLock.Lock()
+	readyCh, readyOnce = make(chan struct{}), sync.Once{}
+	readyLock.Unlock()
+	assert.Nil(t, exporter.Export(server, ext, context))
+	exporter.Unavailable()
+	assert.Equal(t, 4, len(events.get()))
+	Ready()
+	for i := 0; i < 100 && len(events.get()) < 6; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	exporter.Unexport()
+	assert.Equal(t, []string{"register", "unavailable", "available", "unregister", "register", "unavailable", "unregister"}, events.get())
+}
+
+func TestResponseShaper(t *testing.T) {
+	p := newTestProvider("shaper", nil)
+	p.callFunc = func(request motan.Request) motan.Response {
+		if motan.CallerVersionAtLeast(request, "2.0") {
+			return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: "rich"}
+		}
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: "plain"}
+	}
+	handler := newTestHandler(p)
+	request := newTestRequest("shaper", "test")
+	request.SetAttachment(motan.CallerVersionAttachment, "2.1")
+	assert.Equal(t, "rich", handler.Call(request).GetValue())
+	assert.Equal(t, "plain", handler.Call(newTestRequest("shaper", "test")).GetValue())
+
+	RegisterResponseShaper("shaper", func(callerVersion string, request motan.Request, response motan.Response) motan.Response {
+		if callerVersion == "" {
+			return &motan.MotanResponse{RequestID: response.GetRequestID(), Value: "trimmed"}
+		}
+		return response
+	})
+	defer RegisterResponseShaper("shaper", nil)
+	assert.Equal(t, "trimmed", handler.Call(newTestRequest("shaper", "test")).GetValue())
+	assert.Equal(t, "rich", handler.Call(request).GetValue())
+}
+
+func TestRetryBudget(t *testing.T) {
+	_, err := parseRetryPolicy(newTestProvider("test", map[string]string{motan.RetryBudgetKey: "-1"}).GetURL())
+	assert.NotNil(t, err)
+	handler := newTestHandler(newTestProvider("retry", map[string]string{motan.RetryBudgetKey: "2", motan.RetryBackoffKey: "50"}), newTestProvider("noRetry", nil))
+	res := handler.Call(newTestRequest("retry", "test"))
+	assert.Equal(t, "2", res.GetAttachment(motan.RetryBudgetAttachment))
+	assert.Equal(t, "50", res.GetAttachment(motan.RetryBackoffAttachment))
+	budget, backoff, ok := motan.GetAdvertisedRetryPolicy(nil, res)
+	assert.True(t, ok)
+	assert.Equal(t, int64(2), budget)
+	assert.Equal(t, 50*time.Millisecond, backoff)
+
+	assert.True(t, handler.SetRetryBudget("retry", 0))
+	assert.Equal(t, "0", handler.Call(newTestRequest("retry", "test")).GetAttachment(motan.RetryBudgetAttachment))
+	assert.Equal(t, "50", handler.Call(newTestRequest("retry", "test")).GetAttachment(motan.RetryBackoffAttachment))
+	assert.False(t, handler.SetRetryBudget("noRetry", 1))
+	assert.Equal(t, "", handler.Call(newTestRequest("noRetry", "test")).GetAttachment(motan.RetryBudgetAttachment))
+}
+
+func TestRetryAfter(t *testing.T) {
+	busy := newTestProvider("busy", map[string]string{RetryAfterKey: "20", RetryAfterJitterKey: "10", QueueTimeSLOKey: `{"slow":10}`})
+	busy.callFunc = func(request motan.Request) motan.Response {
+		return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 503, ErrMsg: "busy", ErrType: motan.ServiceException})
+	}
+	handler := newTestHandler(busy)
+	for i := 0; i < 10; i++ {
+		delay := motan.GetRetryAfter(overloadResponse(newTestRequest("busy", "hello"), parseRetryAfterPolicy(busy.GetURL()), "busy", 1))
+		assert.True(t, delay >= 20*time.Millisecond && delay <= 30*time.Millisecond, delay.String())
+	}
+	// only the shed requests advertise the delay, the 503 of the provider does not
+	res := handler.Call(newTestRequest("busy", "hello"))
+	assert.Equal(t, 503, res.GetException().ErrCode)
+	assert.Equal(t, time.Duration(0), motan.GetRetryAfter(res))
+
+	// the delay scales with the overload severity
+	request := newTestRequest("busy", "slow")
+	request.GetRPCContext(true).RequestReceiveTime = time.Now().Add(-time.Second)
+	res = handler.Call(request)
+	assert.Equal(t, 503, res.GetException().ErrCode)
+	assert.Equal(t, "queue time exceeds SLO of method slow", res.GetException().ErrMsg)
+	delay := motan.GetRetryAfter(res)
+	assert.True(t, delay >= 20*maxRetryAfterScale*time.Millisecond && delay <= (20*maxRetryAfterScale+10)*time.Millisecond, delay.String())
+
+	res = overloadResponse(newTestRequest("busy", "hello"), nil, "busy", 1)
+	delay = motan.GetRetryAfter(res)
+	assert.True(t, delay >= defaultRetryAfter*time.Millisecond && delay <= (defaultRetryAfter+defaultRetryAfterJitter)*time.Millisecond, delay.String())
+}
+
+func TestWrapWithFilterWithoutFilters(t *testing.T) {
+	factory := &motan.DefaultExtensionFactory{}
+	factory.Initialize()
+	wrapper := WrapWithFilter(newTestProvider("nofilter", nil), factory, nil).(*FilterProviderWrapper)
+	assert.Nil(t, wrapper.filter)
+	request := newTestRequest("nofilter", "test")
+	assert.Equal(t, "ok", wrapper.Call(request).GetValue())
+	// the trace span of the last endpoint filter is kept
+	request.GetRPCContext(true).Tc = &motan.TraceContext{}
+	assert.Equal(t, "ok", wrapper.Call(request).GetValue())
+	assert.Equal(t, 1, len(request.GetRPCContext(false).Tc.ReqSpans))
+	assert.Equal(t, motan.EpFilterEnd, request.GetRPCContext(false).Tc.ReqSpans[0].Name)
+}
+
+// rejectFilter rejects all requests, it is used to check whether a filter is skipped
+type rejectFilter struct {
+	name  string
+	index int
+	next  motan.EndPointFilter
+}
+
+func (r *rejectFilter) GetIndex() int {
+	return r.index
+}
+
+func (r *rejectFilter) GetName() string {
+	return r.name
+}
+
+func (r *rejectFilter) NewFilter(url *motan.URL) motan.Filter {
+	return &rejectFilter{name: r.name, index: r.index}
+}
+
+func (r *rejectFilter) GetType() int32 {
+	return motan.EndPointFilterType
+}
+
+func (r *rejectFilter) SetNext(next motan.EndPointFilter) {
+	r.next = next
+}
+
+func (r *rejectFilter) GetNext() motan.EndPointFilter {
+	return r.next
+}
+
+func (r *rejectFilter) HasNext() bool {
+	return r.next != nil
+}
+
+func (r *rejectFilter) Filter(caller motan.Caller, request motan.Request) motan.Response {
+	return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 403, ErrMsg: "rejected by " + r.name})
+}
+
+type orderFilter struct {
+	rejectFilter
+	calls *[]string
+}
+
+func (o *orderFilter) NewFilter(url *motan.URL) motan.Filter {
+	return &orderFilter{rejectFilter: rejectFilter{name: o.name, index: o.index}, calls: o.calls}
+}
+
+func (o *orderFilter) Filter(caller motan.Caller, request motan.Request) motan.Response {
+	*o.calls = append(*o.calls, o.name)
+	return o.GetNext().Filter(caller, request)
+}
+
+func TestFilterOrder(t *testing.T) {
+	factory := &motan.DefaultExtensionFactory{}
+	factory.Initialize()
+	var calls []string
+	for name, index := range map[string]int{"accessLog": 1, "auth": 5, "rateLimit": 10, "audit": 5} {
+		f := &orderFilter{rejectFilter: rejectFilter{name: name, index: index}, calls: &calls}
+		factory.RegistExtFilter(name, func() motan.Filter { return f })
+	}
+	call := func(filters string) []string {
+		calls = nil
+		p := WrapWithFilter(newTestProvider("order", map[string]string{motan.FilterKey: filters}), factory, nil)
+		assert.Equal(t, "ok", p.Call(newTestRequest("order", "hello")).GetValue())
+		return calls
+	}
+	assert.Equal(t, []string{"accessLog", "auth", "rateLimit"}, call("rateLimit,auth,accessLog"))
+	assert.Equal(t, []string{"accessLog", "auth", "rateLimit"}, call("auth,accessLog,rateLimit"))
+	// the filters of the same index keep the configured order
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, []string{"audit", "auth", "rateLimit"}, call("rateLimit,audit,auth"))
+	}
+}
+
+func TestSkipFilters(t *testing.T) {
+	factory := &motan.DefaultExtensionFactory{}
+	factory.Initialize()
+	for i, name := range []string{"auth", "audit"} {
+		f := &rejectFilter{name: name, index: i + 1}
+		factory.RegistExtFilter(name, func() motan.Filter { return f })
+	}
+	// skipping is off by default
+	wrapper := WrapWithFilter(newTestProvider("skip", map[string]string{motan.FilterKey: "auth"}), factory, nil)
+	assert.Equal(t, 403, wrapper.Call(newArgsTestRequest("skip", "health", map[string]string{SkipFiltersAttachment: "auth", SkipFiltersTokenAttachment: "", mpro.MSource: "tool"})).GetException().ErrCode)
+
+	wrapper = WrapWithFilter(newTestProvider("skip", map[string]string{motan.FilterKey: "auth,audit", SkippableFiltersKey: "auth,audit",
+		SkipFiltersTokenKey: "secret", SkipFiltersCallersKey: "tool"}), factory, nil)
+	assert.Equal(t, "ok", wrapper.Call(newArgsTestRequest("skip", "health", map[string]string{SkipFiltersAttachment: "auth,audit", SkipFiltersTokenAttachment: "secret", mpro.MSource: "tool"})).GetValue())
+	res := wrapper.Call(newArgsTestRequest("skip", "health", map[string]string{SkipFiltersAttachment: "audit", SkipFiltersTokenAttachment: "secret", mpro.MSource: "tool"}))
+	assert.Equal(t, "rejected by auth", res.GetException().ErrMsg)
+	// untrusted callers
+	assert.NotNil(t, wrapper.Call(newArgsTestRequest("skip", "health", map[string]string{SkipFiltersAttachment: "auth,audit", SkipFiltersTokenAttachment: "wrong", mpro.MSource: "tool"})).GetException())
+	assert.NotNil(t, wrapper.Call(newArgsTestRequest("skip", "health", map[string]string{SkipFiltersAttachment: "auth,audit", SkipFiltersTokenAttachment: "secret", mpro.MSource: "other"})).GetException())
+	// the token is not passed to the provider
+	request := newArgsTestRequest("skip", "health", map[string]string{SkipFiltersAttachment: "auth,audit", SkipFiltersTokenAttachment: "secret", mpro.MSource: "tool"})
+	wrapper.Call(request)
+	assert.Equal(t, "", request.GetAttachment(SkipFiltersTokenAttachment))
+
+	// filters not configured as skippable are never skipped
+	wrapper = WrapWithFilter(newTestProvider("skip", map[string]string{motan.FilterKey: "auth,audit", SkippableFiltersKey: "audit",
+		SkipFiltersTokenKey: "secret"}), factory, nil)
+	assert.Equal(t, "rejected by auth", wrapper.Call(newArgsTestRequest("skip", "health", map[string]string{SkipFiltersAttachment: "auth,audit", SkipFiltersTokenAttachment: "secret", mpro.MSource: "any"})).GetException().ErrMsg)
+}
+
+func BenchmarkFilterProviderWrapper_Call(b *testing.B) {
+	p := newTestProvider("benchmark", nil)
+	request := newTestRequest("benchmark", "test")
+	b.Run("lastFilter", func(b *testing.B) {
+		wrapper := &FilterProviderWrapper{provider: p, filter: motan.GetLastEndPointFilter()}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			wrapper.Call(request)
+		}
+	})
+	b.Run("noFilter", func(b *testing.B) {
+		wrapper := &FilterProviderWrapper{provider: p}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			wrapper.Call(request)
+		}
+	})
+}
+
+// constrainedFilter declares the filter constraints
+type constrainedFilter struct {
+	rejectFilter
+	conflicts []string
+	after     []string
+}
+
+func (c *constrainedFilter) ConflictFilters() []string {
+	return c.conflicts
+}
+
+func (c *constrainedFilter) AfterFilters() []string {
+	return c.after
+}
+
+func TestFilterConstraints(t *testing.T) {
+	factory := &motan.DefaultExtensionFactory{}
+	factory.Initialize()
+	filters := []motan.Filter{
+		&rejectFilter{name: "auth", index: 1},
+		&constrainedFilter{rejectFilter: rejectFilter{name: "quota", index: 5}, after: []string{"auth"}},
+		&constrainedFilter{rejectFilter: rejectFilter{name: "earlyQuota", index: 0}, after: []string{"auth"}},
+		&constrainedFilter{rejectFilter: rejectFilter{name: "gzip", index: 6}, conflicts: []string{"compress"}},
+		&rejectFilter{name: "compress", index: 7},
+	}
+	for _, f := range filters {
+		f := f
+		factory.RegistExtFilter(f.GetName(), func() motan.Filter { return f })
+	}
+	export := func(params map[string]string) error {
+		params[motan.RegistryKey] = " "
+		exporter := &DefaultExporter{}
+		exporter.SetProvider(WrapWithFilter(newTestProvider("constraint", params), factory, nil))
+		err := exporter.Export(&MotanServer{URL: &motan.URL{}, handler: newTestHandler()}, nil, &motan.Context{})
+		exporter.Unexport()
+		return err
+	}
+	assert.Nil(t, export(map[string]string{motan.FilterKey: "quota,auth"}))
+	// the filters not configured are ignored
+	assert.Nil(t, export(map[string]string{motan.FilterKey: "quota,gzip"}))
+	err := export(map[string]string{motan.FilterKey: "auth,earlyQuota"})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "must be called after filter auth")
+	err = export(map[string]string{motan.FilterKey: "compress,gzip"})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "conflicts with filter compress")
+	assert.Nil(t, export(map[string]string{motan.FilterKey: "compress,gzip", FilterCheckKey: FilterCheckWarn}))
+}
+
+func TestCorrectRequestID(t *testing.T) {
+	shared := &motan.MotanResponse{RequestID: 100, Value: "v", Attachment: motan.NewStringMap(1)}
+	shared.SetAttachment("k", "a")
+	p := newTestProvider("requestIDService", nil)
+	p.callFunc = func(request motan.Request) motan.Response {
+		if request.GetMethod() == "exception" {
+			return motan.BuildExceptionResponse(request.GetRequestID()+1, &motan.Exception{ErrCode: 503, ErrMsg: "fail", ErrType: motan.BizException})
+		}
+		return shared
+	}
+	handler := newTestHandler(p)
+	res := handler.Call(&motan.MotanRequest{RequestID: 1, ServiceName: "requestIDService", Method: "hello"})
+	assert.Equal(t, uint64(1), res.GetRequestID())
+	assert.Equal(t, "v", res.GetValue())
+	assert.Equal(t, "a", res.GetAttachment("k"))
+	// the shared response is not modified
+	assert.Equal(t, uint64(100), shared.GetRequestID())
+	res = handler.Call(&motan.MotanRequest{RequestID: 100, ServiceName: "requestIDService", Method: "hello"})
+	assert.True(t, res == shared)
+	res = handler.Call(&motan.MotanRequest{RequestID: 2, ServiceName: "requestIDService", Method: "exception"})
+	assert.Equal(t, uint64(2), res.GetRequestID())
+	assert.Equal(t, 503, res.GetException().ErrCode)
+}
+
+type weightRegistry struct {
+	recordRegistry
+	lock    sync.Mutex
+	weights []int64
+}
+
+func (w *weightRegistry) Available(serverURL *motan.URL) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.weights = append(w.weights, serverURL.GetIntValue(motan.WeightKey, 0))
+}
+
+func (w *weightRegistry) getWeights() []int64 {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return append([]int64{}, w.weights...)
+}
+
+func TestWarmup(t *testing.T) {
+	assert.Equal(t, int64(1), warmupWeight(50, 0, time.Second))
+	assert.Equal(t, int64(25), warmupWeight(50, 500*time.Millisecond, time.Second))
+	assert.Equal(t, int64(50), warmupWeight(50, 2*time.Second, time.Second))
+
+	r := &weightRegistry{recordRegistry: recordRegistry{events: &shutdownEvents{}}}
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	ext.RegistExtRegistry("weight", func(url *motan.URL) motan.Registry {
+		return r
+	})
+	context := &motan.Context{RegistryURLs: map[string]*motan.URL{"r": {Protocol: "weight", Host: "127.0.0.1", Port: 1}}}
+	exporter := &DefaultExporter{}
+	exporter.SetProvider(newTestProvider("warmup", map[string]string{motan.RegistryKey: "r", WarmupKey: "1", motan.WeightKey: "50"}))
+	assert.Nil(t, exporter.Export(&MotanServer{URL: &motan.URL{}, handler: newTestHandler(exporter.GetProvider())}, ext, context))
+	for i := 0; i < 200; i++ {
+		if weights := r.getWeights(); len(weights) > 0 && weights[len(weights)-1] == 50 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	weights := r.getWeights()
+	assert.True(t, len(weights) > 2, weights)
+	assert.Equal(t, int64(1), weights[0])
+	assert.Equal(t, int64(50), weights[len(weights)-1])
+	for i := 1; i < len(weights); i++ {
+		assert.True(t, weights[i] >= weights[i-1], weights)
+	}
+	// the weights are not advertised after the warmup
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, len(weights), len(r.getWeights()))
+	// the provider url is not modified
+	assert.Equal(t, "50", exporter.GetURL().GetParam(motan.WeightKey, ""))
+
+	// warmup again after available, and it is stopped by unavailable
+	exporter.Unavailable()
+	exporter.Available()
+	time.Sleep(150 * time.Millisecond)
+	exporter.Unavailable()
+	count := len(r.getWeights())
+	assert.True(t, count > len(weights)+1)
+	assert.Equal(t, int64(1), r.getWeights()[len(weights)])
+	assert.True(t, r.getWeights()[count-1] < 50)
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, count, len(r.getWeights()))
+	exporter.Unexport()
+
+	// no warmup is configured
+	r.weights = nil
+	exporter = &DefaultExporter{}
+	exporter.SetProvider(newTestProvider("noWarmup", map[string]string{motan.RegistryKey: "r"}))
+	assert.Nil(t, exporter.Export(&MotanServer{URL: &motan.URL{}, handler: newTestHandler(exporter.GetProvider())}, ext, context))
+	assert.Equal(t, 0, len(r.getWeights()))
+	exporter.Unexport()
+}
+
+func TestMethodSunsets(t *testing.T) {
+	for _, value := range []string{"[]", `{"":"2024-01-01"}`, `{"hello":"2024/01/01"}`} {
+		_, err := parseMethodSunsets(&motan.URL{Parameters: map[string]string{MethodSunsetsKey: value}})
+		assert.NotNil(t, err, value)
+	}
+	defer func() { sunsetNow = time.Now }()
+	sunsetNow = func() time.Time {
+		return time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	}
+	p := newTestProvider("sunset", map[string]string{MethodSunsetsKey: `{"Hello":"2024-07-01","retired":"2024-06-01T08:00:00+08:00"}`})
+	handler := newTestHandler(p)
+
+	res := handler.Call(newTestRequest("sunset", "hello"))
+	assert.Nil(t, res.GetException())
+	assert.Equal(t, "2024-07-01T00:00:00Z", res.GetAttachment(SunsetAttachment))
+	res = handler.Call(newTestRequest("sunset", "retired"))
+	assert.Equal(t, 410, res.GetException().ErrCode)
+	assert.Equal(t, "method retired of sunset is retired since 2024-06-01T08:00:00+08:00", res.GetException().ErrMsg)
+	res = handler.Call(newTestRequest("sunset", "other"))
+	assert.Nil(t, res.GetException())
+	assert.Equal(t, "", res.GetAttachment(SunsetAttachment))
+
+	// retired after the sunset time
+	sunsetNow = func() time.Time {
+		return time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	}
+	res = handler.Call(newTestRequest("sunset", "hello"))
+	assert.Equal(t, 410, res.GetException().ErrCode)
+
+	exporter := &DefaultExporter{}
+	exporter.SetProvider(newTestProvider("sunset", map[string]string{motan.RegistryKey: "", MethodSunsetsKey: `{"hello":"tomorrow"}`}))
+	err := exporter.Export(&MotanServer{URL: &motan.URL{}, handler: newTestHandler()}, nil, &motan.Context{})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), MethodSunsetsKey)
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"sync"
+
+	motan "github.com/weibocom/motan-go/core"
+)
+
+// ResponseShaper shapes the response of a provider by the caller version, e.g. trim the new fields for old callers.
+// callerVersion is empty if the caller does not send it
+type ResponseShaper func(callerVersion string, request motan.Request, response motan.Response) motan.Response
+
+var (
+	responseShapers    = make(map[string]ResponseShaper)
+	responseShaperLock sync.RWMutex
+)
+
+// RegisterResponseShaper register the shaper of a service(path), it is applied by DefaultMessageHandler after the provider call
+func RegisterResponseShaper(service string, shaper ResponseShaper) {
+	responseShaperLock.Lock()
+	defer responseShaperLock.Unlock()
+	if shaper == nil {
+		delete(responseShapers, service)
+		return
+	}
+	responseShapers[service] = shaper
+}
+
+func shapeResponse(request motan.Request, response motan.Response) motan.Response {
+	responseShaperLock.RLock()
+	shaper := responseShapers[request.GetServiceName()]
+	responseShaperLock.RUnlock()
+	if shaper == nil || response == nil {
+		return response
+	}
+	if shaped := shaper(motan.GetCallerVersion(request), request, response); shaped != nil {
+		return shaped
+	}
+	return response
+}
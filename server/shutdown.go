@@ -0,0 +1,245 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// DestroyTimeoutKey is the provider url parameter of the max duration(ms) Unexport waits for the provider Destroy.
+// the slow Destroy keeps running in background after the timeout
+const DestroyTimeoutKey = "destroyTimeout"
+
+const defaultDestroyTimeout = 5 * time.Second
+
+// GracefulShutdownTimeoutKey is the provider url parameter of the max duration(ms) Unexport waits for the requests in processing
+// of the provider before destroying it
+const GracefulShutdownTimeoutKey = "gracefulShutdownTimeout"
+
+const defaultGracefulShutdownTimeout = 5 * time.Second
+
+// UnregisterQuiesceKey is the provider url parameter of the duration(ms) Unexport waits after unregistering the url,
+// so the clients are notified by the registries before the provider stops accepting calls. default 0, no waiting
+const UnregisterQuiesceKey = "unregisterQuiesce"
+
+// PreStopTimeoutKey is the provider url parameter of the max duration(ms) Unexport waits for the pre-stop hook
+const PreStopTimeoutKey = "preStopTimeout"
+
+const defaultPreStopTimeout = 5 * time.Second
+
+var (
+	shutdownLock     sync.Mutex
+	runningExporters = make(map[*DefaultExporter]bool)
+	runningServers   = make(map[*MotanServer]bool)
+)
+
+func registerExporter(e *DefaultExporter) {
+	shutdownLock.Lock()
+	defer shutdownLock.Unlock()
+	runningExporters[e] = true
+}
+
+func unregisterExporter(e *DefaultExporter) {
+	shutdownLock.Lock()
+	defer shutdownLock.Unlock()
+	delete(runningExporters, e)
+}
+
+func registerServer(s *MotanServer) {
+	shutdownLock.Lock()
+	defer shutdownLock.Unlock()
+	runningServers[s] = true
+}
+
+func unregisterServer(s *MotanServer) {
+	shutdownLock.Lock()
+	defer shutdownLock.Unlock()
+	delete(runningServers, s)
+}
+
+// runningServerOfAddr returns the opened motan server whose address conflicts with the addr
+func runningServerOfAddr(addr string) *MotanServer {
+	shutdownLock.Lock()
+	defer shutdownLock.Unlock()
+	for s := range runningServers {
+		if addrConflict(addr, listenAddr(s.URL)) {
+			return s
+		}
+	}
+	return nil
+}
+
+func runningSnapshot() ([]*DefaultExporter, []*MotanServer) {
+	shutdownLock.Lock()
+	defer shutdownLock.Unlock()
+	exporters := make([]*DefaultExporter, 0, len(runningExporters))
+	for e := range runningExporters {
+		exporters = append(exporters, e)
+	}
+	servers := make([]*MotanServer, 0, len(runningServers))
+	for s := range runningServers {
+		servers = append(servers, s)
+	}
+	return exporters, servers
+}
+
+// GracefulShutdown stops all exported services and opened motan servers in order:
+// unregister from registries -> wait UnregisterQuiesceKey -> drain requests in processing -> stop listeners -> destroy providers.
+// if the context is done before all requests are drained, the remaining steps are still executed and the context error is returned
+func GracefulShutdown(ctx context.Context) error {
+	exporters, servers := runningSnapshot()
+	vlog.Infof("graceful shutdown start. exporters:%d, servers:%d", len(exporters), len(servers))
+	var quiesce time.Duration
+	for _, e := range exporters {
+		e.unregister()
+		if q := e.unregisterQuiesce(); q > quiesce {
+			quiesce = q
+		}
+	}
+	if quiesce > 0 {
+		select {
+		case <-time.After(quiesce):
+		case <-ctx.Done():
+		}
+	}
+	var err error
+	for _, s := range servers {
+		if drainErr := s.drain(ctx); drainErr != nil {
+			vlog.Warningf("drain motan server fail, requests in processing will be dropped. url:%v, err:%v", s.URL, drainErr)
+			err = drainErr
+			break
+		}
+	}
+	for _, s := range servers {
+		s.Destroy()
+	}
+	for _, e := range exporters {
+		e.Unexport()
+	}
+	vlog.Infof("graceful shutdown finish. err:%v", err)
+	return err
+}
+
+// HandoverShutdown stops the opened motan servers without unregistering the exported services, it is used after the
+// listeners are handed over to the new process by motan.Upgrade, the services are still registered by the new process:
+// stop listeners -> drain requests in processing -> close connections, the clients reconnect to the new process.
+// the requests in processing are dropped if the context is done first, and the context error is returned
+func HandoverShutdown(ctx context.Context) error {
+	_, servers := runningSnapshot()
+	vlog.Infof("handover shutdown start. servers:%d", len(servers))
+	for _, s := range servers {
+		s.Destroy()
+	}
+	var err error
+	for _, s := range servers {
+		if drainErr := s.drain(ctx); drainErr != nil {
+			vlog.Warningf("drain motan server fail, requests in processing will be dropped. url:%v, err:%v", s.URL, drainErr)
+			err = drainErr
+			break
+		}
+	}
+	for _, s := range servers {
+		s.closeConns()
+	}
+	vlog.Infof("handover shutdown finish. err:%v", err)
+	return err
+}
+
+// SetPreStopHook sets the hook called by Unexport after the requests in processing are drained and before the provider
+// is destroyed, so the application can flush the state, e.g. the buffered writes. the hook is waited at most
+// PreStopTimeoutKey, a panic of the hook is logged and ignored
+func (d *DefaultExporter) SetPreStopHook(hook func()) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.preStop = hook
+}
+
+// unregisterQuiesce returns the remaining quiesce period of the url unregistered
+func (d *DefaultExporter) unregisterQuiesce() time.Duration {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.remainingQuiesce()
+}
+
+// remainingQuiesce returns the quiesce period of the url registered, or the remaining period of the url unregistered by
+// GracefulShutdown, it should be called with the lock held
+func (d *DefaultExporter) remainingQuiesce() time.Duration {
+	if d.url == nil {
+		return 0
+	}
+	quiesce := d.url.GetTimeDuration(UnregisterQuiesceKey, time.Millisecond, 0)
+	switch {
+	case quiesce <= 0:
+		return 0
+	case d.registered:
+		return quiesce
+	case d.unregistered && !d.unregisterTime.IsZero():
+		return quiesce - time.Since(d.unregisterTime)
+	}
+	return 0
+}
+
+func runPreStop(hook func(), path string, timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer motan.HandlePanic(nil)
+		hook()
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		vlog.Warningf("pre-stop hook of provider %s timeout after %v, the provider is destroyed", path, timeout)
+	}
+}
+
+// destroyProvider calls the provider Destroy and waits at most the timeout, it returns false if the Destroy is abandoned
+func destroyProvider(p motan.Provider, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer motan.HandlePanic(nil)
+		p.Destroy()
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		vlog.Warningf("destroy provider %s timeout after %v, the cleanup is left in background", p.GetPath(), timeout)
+		return false
+	}
+}
+
+// initializeProvider initializes the provider destroyed by destroyProvider again, and the providers it wraps
+func initializeProvider(p motan.Provider) {
+	for p != nil {
+		motan.Initialize(p)
+		w, ok := p.(providerWrapper)
+		if !ok {
+			return
+		}
+		p = w.unwrap()
+	}
+}
+
+// drainInflight waits until the inflight count drops to zero or the context is done
+func drainInflight(ctx context.Context, inflight *int64) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for atomic.LoadInt64(inflight) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
@@ -0,0 +1,317 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+	mpro "github.com/weibocom/motan-go/protocol"
+	"github.com/weibocom/motan-go/registry"
+	"github.com/weibocom/motan-go/serialize"
+)
+
+type shutdownEvents struct {
+	lock   sync.Mutex
+	events []string
+}
+
+func (s *shutdownEvents) add(event string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.events = append(s.events, event)
+}
+
+func (s *shutdownEvents) get() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string{}, s.events...)
+}
+
+type recordRegistry struct {
+	registry.LocalRegistry
+	events *shutdownEvents
+	urls   []*motan.URL
+}
+
+func (r *recordRegistry) Register(serverURL *motan.URL) {
+	r.events.add("register")
+	r.urls = append(r.urls, serverURL.Copy())
+}
+
+func (r *recordRegistry) UnRegister(serverURL *motan.URL) {
+	r.events.add("unregister")
+}
+
+func (r *recordRegistry) Available(serverURL *motan.URL) {
+	r.events.add("available")
+}
+
+func (r *recordRegistry) Unavailable(serverURL *motan.URL) {
+	r.events.add("unavailable")
+}
+
+func newShutdownTestServer(t *testing.T, port int, events *shutdownEvents, callTime time.Duration) (*MotanServer, *DefaultExporter) {
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	serialize.RegistDefaultSerializations(ext)
+	p := newTestProvider("shutdownService", nil)
+	p.callFunc = func(request motan.Request) motan.Response {
+		time.Sleep(callTime)
+		events.add("call finish")
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: "ok"}
+	}
+	p.destroyFunc = func() {
+		events.add("provider destroy")
+	}
+	handler := newTestHandler(p)
+	server := &MotanServer{URL: &motan.URL{Protocol: "motan2", Host: "127.0.0.1", Port: port}}
+	assert.Nil(t, server.Open(false, false, handler, ext))
+	exporter := &DefaultExporter{provider: p, server: server, url: p.GetURL(), exported: true, available: true, registered: true,
+		Registries: []motan.Registry{&recordRegistry{events: events}}}
+	registerExporter(exporter)
+	return server, exporter
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	events := &shutdownEvents{}
+	server, exporter := newShutdownTestServer(t, 64583, events, 200*time.Millisecond)
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:64583", time.Second)
+	assert.Nil(t, err)
+	defer conn.Close()
+	resChan := make(chan *mpro.Message, 1)
+	go func() {
+		resChan <- sendTestRequest(t, conn, bufio.NewReader(conn), 1, "shutdownService", "hello")
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	assert.Nil(t, GracefulShutdown(ctx))
+	assert.Equal(t, []string{"unregister", "call finish", "provider destroy"}, events.get())
+	assert.False(t, exporter.IsAvailable())
+	assert.Nil(t, server.GetMessageHandler().GetProvider("shutdownService"))
+	res := <-resChan
+	assert.Equal(t, mpro.Normal, res.Header.GetStatus())
+
+	_, err = net.DialTimeout("tcp", "127.0.0.1:64583", 100*time.Millisecond)
+	assert.NotNil(t, err)
+	exporters, servers := runningSnapshot()
+	assert.Equal(t, 0, len(exporters))
+	assert.Equal(t, 0, len(servers))
+}
+
+func TestGracefulShutdownDeadline(t *testing.T) {
+	events := &shutdownEvents{}
+	newShutdownTestServer(t, 64584, events, time.Second)
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:64584", time.Second)
+	assert.Nil(t, err)
+	defer conn.Close()
+	msg, _ := mpro.ConvertToReqMessage(&motan.MotanRequest{RequestID: 1, ServiceName: "shutdownService", Method: "hello", Arguments: []interface{}{"arg"}}, &serialize.SimpleSerialization{})
+	conn.Write(msg.Encode().Bytes())
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.Equal(t, context.DeadlineExceeded, GracefulShutdown(ctx))
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+	assert.Equal(t, []string{"unregister", "provider destroy"}, events.get())
+}
+
+func TestMotanServerShutdown(t *testing.T) {
+	events := &shutdownEvents{}
+	server, exporter := newShutdownTestServer(t, 64594, events, 200*time.Millisecond)
+	defer unregisterExporter(exporter)
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:64594", time.Second)
+	assert.Nil(t, err)
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	resChan := make(chan *mpro.Message, 1)
+	go func() {
+		resChan <- sendTestRequest(t, conn, reader, 1, "shutdownService", "hello")
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	assert.Nil(t, server.Shutdown(context.Background()))
+	assert.Equal(t, []string{"call finish"}, events.get())
+	res := <-resChan
+	assert.Equal(t, mpro.Normal, res.Header.GetStatus())
+	// no new connections, and the connection is closed after the requests are drained
+	_, err = net.DialTimeout("tcp", "127.0.0.1:64594", 100*time.Millisecond)
+	assert.NotNil(t, err)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = reader.ReadByte()
+	assert.Equal(t, io.EOF, err)
+	_, servers := runningSnapshot()
+	assert.Equal(t, 0, len(servers))
+
+	// the requests in processing are dropped after the deadline
+	events = &shutdownEvents{}
+	server, exporter = newShutdownTestServer(t, 64595, events, time.Second)
+	defer unregisterExporter(exporter)
+	conn, err = net.DialTimeout("tcp", "127.0.0.1:64595", time.Second)
+	assert.Nil(t, err)
+	defer conn.Close()
+	msg, _ := mpro.ConvertToReqMessage(&motan.MotanRequest{RequestID: 1, ServiceName: "shutdownService", Method: "hello", Arguments: []interface{}{"arg"}}, &serialize.SimpleSerialization{})
+	conn.Write(msg.Encode().Bytes())
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.Equal(t, context.DeadlineExceeded, server.Shutdown(ctx))
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+	assert.NotEqual(t, []string{"call finish"}, events.get())
+}
+
+func TestUnexportDestroyTimeout(t *testing.T) {
+	events := &shutdownEvents{}
+	release := make(chan struct{})
+	p := newTestProvider("slowDestroy", map[string]string{DestroyTimeoutKey: "50"})
+	p.destroyFunc = func() {
+		<-release
+		events.add("provider destroy")
+	}
+	exporter := &DefaultExporter{provider: p, server: &MotanServer{handler: newTestHandler(p)}, url: p.GetURL(), exported: true, available: true}
+	start := time.Now()
+	assert.Nil(t, exporter.Unexport())
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+	assert.Equal(t, 0, len(events.get()))
+	// the abandoned cleanup keeps running
+	close(release)
+	for i := 0; i < 100 && len(events.get()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, []string{"provider destroy"}, events.get())
+
+	p = newTestProvider("fastDestroy", nil)
+	p.destroyFunc = func() {
+		events.add("provider destroy")
+	}
+	exporter = &DefaultExporter{provider: p, server: &MotanServer{handler: newTestHandler(p)}, url: p.GetURL(), exported: true, available: true}
+	assert.Nil(t, exporter.Unexport())
+	assert.Equal(t, 2, len(events.get()))
+	// destroyed only once
+	assert.Nil(t, exporter.Unexport())
+	assert.Equal(t, 2, len(events.get()))
+}
+
+func TestUnexportDrain(t *testing.T) {
+	events := &shutdownEvents{}
+	started := make(chan struct{})
+	p := newTestProvider("drainService", map[string]string{GracefulShutdownTimeoutKey: "1000"})
+	p.callFunc = func(request motan.Request) motan.Response {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		events.add("call finish")
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: "ok"}
+	}
+	p.destroyFunc = func() {
+		events.add("provider destroy")
+	}
+	provider := WrapWithFilter(p, nil, nil)
+	handler := newTestHandler(provider)
+	exporter := &DefaultExporter{provider: provider, server: &MotanServer{handler: handler}, url: p.GetURL(), exported: true, available: true, registered: true,
+		Registries: []motan.Registry{&recordRegistry{events: events}}}
+	resChan := make(chan motan.Response, 1)
+	go func() {
+		resChan <- handler.Call(newTestRequest("drainService", "hello"))
+	}()
+	<-started
+	done := make(chan struct{})
+	go func() {
+		exporter.Unexport()
+		close(done)
+	}()
+	for i := 0; i < 100 && len(events.get()) == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	// new requests are rejected while draining
+	assert.NotNil(t, provider.Call(newTestRequest("drainService", "hello")).GetException())
+	assert.NotNil(t, handler.Call(newTestRequest("drainService", "hello")).GetException())
+	<-done
+	assert.Equal(t, "ok", (<-resChan).GetValue())
+	assert.Equal(t, []string{"unregister", "call finish", "provider destroy"}, events.get())
+}
+
+func TestUnexportDrainTimeout(t *testing.T) {
+	events := &shutdownEvents{}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	p := newTestProvider("drainTimeout", map[string]string{GracefulShutdownTimeoutKey: "50"})
+	p.callFunc = func(request motan.Request) motan.Response {
+		close(started)
+		<-release
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: "ok"}
+	}
+	p.destroyFunc = func() {
+		events.add("provider destroy")
+	}
+	provider := WrapWithFilter(p, nil, nil)
+	exporter := &DefaultExporter{provider: provider, server: &MotanServer{handler: newTestHandler(provider)}, url: p.GetURL(), exported: true, available: true}
+	go provider.Call(newTestRequest("drainTimeout", "hello"))
+	<-started
+	start := time.Now()
+	exporter.Unexport()
+	// destroyed by force after the timeout
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+	assert.Equal(t, []string{"provider destroy"}, events.get())
+	close(release)
+}
+
+func TestUnexportQuiesce(t *testing.T) {
+	events := &shutdownEvents{}
+	p := newTestProvider("quiesceService", map[string]string{UnregisterQuiesceKey: "100"})
+	p.callFunc = func(request motan.Request) motan.Response {
+		events.add("call finish")
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: "ok"}
+	}
+	p.destroyFunc = func() {
+		events.add("provider destroy")
+	}
+	provider := WrapWithFilter(p, nil, nil)
+	handler := newTestHandler(provider)
+	exporter := &DefaultExporter{provider: provider, server: &MotanServer{handler: handler}, url: p.GetURL(), exported: true, available: true, registered: true,
+		Registries: []motan.Registry{&recordRegistry{events: events}}}
+	exporter.SetPreStopHook(func() {
+		events.add("pre stop")
+	})
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		exporter.Unexport()
+		close(done)
+	}()
+	time.Sleep(30 * time.Millisecond)
+	// the provider keeps serving in the quiesce period, and can not be exported again
+	assert.Equal(t, "ok", handler.Call(newTestRequest("quiesceService", "hello")).GetValue())
+	assert.False(t, exporter.IsAvailable())
+	assert.Nil(t, exporter.Unexport())
+	<-done
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+	assert.Equal(t, []string{"unregister", "call finish", "pre stop", "provider destroy"}, events.get())
+	assert.Nil(t, handler.GetProvider("quiesceService"))
+
+	// the panic of the pre-stop hook is ignored, and the slow hook is waited at most the timeout
+	for _, hook := range []func(){func() { panic("flush fail") }, func() { time.Sleep(time.Second) }} {
+		events = &shutdownEvents{}
+		p = newTestProvider("preStopService", map[string]string{PreStopTimeoutKey: "50"})
+		p.destroyFunc = func() {
+			events.add("provider destroy")
+		}
+		exporter = &DefaultExporter{provider: p, server: &MotanServer{handler: newTestHandler(p)}, url: p.GetURL(), exported: true, available: true}
+		exporter.SetPreStopHook(hook)
+		start = time.Now()
+		assert.Nil(t, exporter.Unexport())
+		assert.True(t, time.Since(start) < 500*time.Millisecond)
+		assert.Equal(t, []string{"provider destroy"}, events.get())
+	}
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"crypto/subtle"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+	mpro "github.com/weibocom/motan-go/protocol"
+)
+
+// filter skipping provider url parameter keys. skipping is off unless both the skippable filters and the token are configured
+const (
+	SkippableFiltersKey   = "skippableFilters"   // names of the filters which can be skipped, separated by comma
+	SkipFiltersTokenKey   = "skipFiltersToken"   // secret shared with the internal callers
+	SkipFiltersCallersKey = "skipFiltersCallers" // applications allowed to skip filters, separated by comma, empty means any caller with the token
+)
+
+// request attachments of filter skipping, e.g. for health checks and diagnostic calls of internal tools
+const (
+	SkipFiltersAttachment      = "M_skf" // names of the filters to skip, separated by comma
+	SkipFiltersTokenAttachment = "M_skt" // it is removed from the request once checked
+)
+
+type filterSkipping struct {
+	skippable map[string]bool
+	token     []byte
+	callers   map[string]bool
+}
+
+func newFilterSkipping(url *motan.URL) *filterSkipping {
+	names := motan.TrimSplit(url.GetParam(SkippableFiltersKey, ""), ",")
+	token := url.GetParam(SkipFiltersTokenKey, "")
+	if len(names) == 0 || token == "" {
+		return nil
+	}
+	s := &filterSkipping{skippable: make(map[string]bool, len(names)), token: []byte(token)}
+	for _, name := range names {
+		if name != "" {
+			s.skippable[name] = true
+		}
+	}
+	if callers := motan.TrimSplit(url.GetParam(SkipFiltersCallersKey, ""), ","); len(callers) > 0 {
+		s.callers = make(map[string]bool, len(callers))
+		for _, c := range callers {
+			if c != "" {
+				s.callers[c] = true
+			}
+		}
+	}
+	return s
+}
+
+// resolve checks the caller and token of the request, and sets the filters to skip into the rpc context
+func (s *filterSkipping) resolve(request motan.Request) {
+	names := request.GetAttachment(SkipFiltersAttachment)
+	token := request.GetAttachment(SkipFiltersTokenAttachment)
+	if attachments := request.GetAttachments(); attachments != nil && token != "" {
+		attachments.Delete(SkipFiltersTokenAttachment)
+	}
+	if names == "" {
+		return
+	}
+	caller := request.GetAttachment(mpro.MSource)
+	if subtle.ConstantTimeCompare([]byte(token), s.token) != 1 || (s.callers != nil && !s.callers[caller]) {
+		vlog.Warningf("skip filters rejected, untrusted caller. req:%s, caller:%s", motan.GetReqInfo(request), caller)
+		return
+	}
+	var skip map[string]bool
+	for _, name := range motan.TrimSplit(names, ",") {
+		if s.skippable[name] {
+			if skip == nil {
+				skip = make(map[string]bool)
+			}
+			skip[name] = true
+		}
+	}
+	if skip != nil {
+		request.GetRPCContext(true).SkipFilters = skip
+	}
+}
+
+// skippableFilter passes the request to the next filter if it is skipped by the request
+type skippableFilter struct {
+	motan.EndPointFilter
+}
+
+func (s *skippableFilter) Filter(caller motan.Caller, request motan.Request) motan.Response {
+	if ctx := request.GetRPCContext(false); ctx != nil && ctx.SkipFilters[s.GetName()] {
+		return s.GetNext().Filter(caller, request)
+	}
+	return s.EndPointFilter.Filter(caller, request)
+}
@@ -0,0 +1,286 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+	"github.com/weibocom/motan-go/metrics"
+)
+
+// MethodSLOsKey is the provider url parameter of the SLOs of the methods, the value is a json map of method name to SLO,
+// method '*' applies to the methods not configured, e.g. {"*":{"availability":99.9},"hello":{"latency":50,"percentile":99}}.
+// the compliance is computed from the recent calls of each method, it is reported by the gauges of the call metrics keys
+// and GetSLOStatus, and the SLOBreachHandler is called when a method starts or stops breaching its SLO
+const MethodSLOsKey = "methodSLOs"
+
+// the gauges of the SLO compliance, the key prefix is the same as the call metrics
+const (
+	SLOAvailabilityMetricSuffix = ".slo_availability" // in 1/100 percent, e.g. 9990 is 99.9%
+	SLOLatencyMetricSuffix      = ".slo_latency"      // ms at the percentile of the SLO
+	SLOBreachMetricSuffix       = ".slo_breach"       // 1 if the SLO is breached
+)
+
+const (
+	defaultSLOPercentile = 99.0
+	// the recent calls of a method, the compliance is computed again every sloInterval calls
+	sloSamples  = 1000
+	sloInterval = 100
+)
+
+// SLO is the target of a method, the zero targets are not checked
+type SLO struct {
+	Latency      int64   `json:"latency"`      // ms at Percentile
+	Percentile   float64 `json:"percentile"`   // default 99
+	Availability float64 `json:"availability"` // percent of the calls without service or framework exception, the biz exceptions are available
+}
+
+// SLOStatus is the compliance of the recent calls of a method
+type SLOStatus struct {
+	Service      string  `json:"service"`
+	Group        string  `json:"group"`
+	Method       string  `json:"method"`
+	SLO          SLO     `json:"slo"`
+	Samples      int     `json:"samples"`
+	Availability float64 `json:"availability"` // percent
+	Latency      int64   `json:"latency"`      // ms at the percentile of the SLO
+	Breached     bool    `json:"breached"`
+	Reason       string  `json:"reason,omitempty"`
+}
+
+// SLOBreachHandler is called when a method starts breaching its SLO, and when it complies again
+type SLOBreachHandler func(status SLOStatus)
+
+var sloBreachHandler atomic.Value // SLOBreachHandler
+
+// SetSLOBreachHandler sets the alert hook of the SLO breaches, nil removes it. it is called in the request goroutine,
+// so it should not block
+func SetSLOBreachHandler(handler SLOBreachHandler) {
+	sloBreachHandler.Store(handler)
+}
+
+func notifySLOBreach(status SLOStatus) {
+	if status.Breached {
+		vlog.Warningf("SLO of %s.%s is breached: %s", status.Service, status.Method, status.Reason)
+	} else {
+		vlog.Infof("SLO of %s.%s complies again", status.Service, status.Method)
+	}
+	if handler, ok := sloBreachHandler.Load().(SLOBreachHandler); ok && handler != nil {
+		defer motan.HandlePanic(nil)
+		handler(status)
+	}
+}
+
+type methodSLOs map[string]SLO
+
+func parseMethodSLOs(url *motan.URL) (methodSLOs, error) {
+	value := url.GetParam(MethodSLOsKey, "")
+	if value == "" {
+		return nil, nil
+	}
+	var slos methodSLOs
+	if err := json.Unmarshal([]byte(value), &slos); err != nil {
+		return nil, fmt.Errorf("illegal %s: %s, err: %v", MethodSLOsKey, value, err)
+	}
+	for method, slo := range slos {
+		if method == "" {
+			return nil, errors.New("illegal " + MethodSLOsKey + ": empty method name")
+		}
+		if slo.Percentile == 0 {
+			slo.Percentile = defaultSLOPercentile
+		}
+		if slo.Latency < 0 || slo.Percentile <= 0 || slo.Percentile > 100 || slo.Availability < 0 || slo.Availability > 100 {
+			return nil, fmt.Errorf("illegal %s: SLO of method %s is out of range: %+v", MethodSLOsKey, method, slo)
+		}
+		slos[method] = slo
+	}
+	return slos, nil
+}
+
+func (m methodSLOs) get(method string) (SLO, bool) {
+	if slo, ok := m[method]; ok {
+		return slo, true
+	}
+	if slo, ok := m[motan.FirstUpper(method)]; ok {
+		return slo, true
+	}
+	slo, ok := m["*"]
+	return slo, ok
+}
+
+type sloSample struct {
+	latency time.Duration
+	failed  bool
+}
+
+type methodCompliance struct {
+	lock    sync.Mutex
+	slo     SLO
+	samples []sloSample // ring of the recent calls
+	next    int
+	count   int64
+	status  SLOStatus
+}
+
+// sloTracker tracks the SLO compliance of the methods of a provider
+type sloTracker struct {
+	slos    methodSLOs
+	metrics *callMetrics
+	lock    sync.RWMutex
+	methods map[string]*methodCompliance
+}
+
+// parseSLOTracker returns nil if no SLO is configured
+func parseSLOTracker(url *motan.URL) (*sloTracker, error) {
+	slos, err := parseMethodSLOs(url)
+	if err != nil || len(slos) == 0 {
+		return nil, err
+	}
+	return &sloTracker{slos: slos, methods: make(map[string]*methodCompliance),
+		metrics: newCallMetrics(url.Group, url.Path, url.GetParam(motan.ApplicationKey, metrics.DefaultStatApplication))}, nil
+}
+
+// compliance returns nil if the method has no SLO
+func (s *sloTracker) compliance(request motan.Request) *methodCompliance {
+	method := request.GetMethod()
+	s.lock.RLock()
+	c, ok := s.methods[method]
+	s.lock.RUnlock()
+	if ok {
+		return c
+	}
+	slo, found := s.slos.get(method)
+	if !found {
+		return nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if c, ok = s.methods[method]; !ok {
+		c = &methodCompliance{slo: slo, samples: make([]sloSample, sloSamples),
+			status: SLOStatus{Service: request.GetServiceName(), Group: s.metrics.group, Method: method, SLO: slo}}
+		s.methods[method] = c
+	}
+	return c
+}
+
+// observe records the call, and computes the compliance of the method every sloInterval calls
+func (s *sloTracker) observe(request motan.Request, start time.Time, res motan.Response) {
+	if s == nil {
+		return
+	}
+	c := s.compliance(request)
+	if c == nil {
+		return
+	}
+	failed := res == nil || (res.GetException() != nil && res.GetException().ErrType != motan.BizException)
+	c.lock.Lock()
+	c.samples[c.next] = sloSample{latency: time.Since(start), failed: failed}
+	c.next = (c.next + 1) % len(c.samples)
+	c.count++
+	if c.count%sloInterval != 0 {
+		c.lock.Unlock()
+		return
+	}
+	n := len(c.samples)
+	if c.count < int64(n) {
+		n = int(c.count)
+	}
+	latencies := make([]time.Duration, n)
+	failures := 0
+	for i, sample := range c.samples[:n] {
+		latencies[i] = sample.latency
+		if sample.failed {
+			failures++
+		}
+	}
+	last := c.status
+	c.lock.Unlock()
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	status := SLOStatus{Service: last.Service, Group: last.Group, Method: last.Method, SLO: c.slo, Samples: n,
+		Availability: float64(n-failures) * 100 / float64(n),
+		Latency:      int64(latencies[int(math.Ceil(float64(n)*c.slo.Percentile/100))-1] / time.Millisecond)}
+	if c.slo.Availability > 0 && status.Availability < c.slo.Availability {
+		status.Breached = true
+		status.Reason = fmt.Sprintf("availability %.2f%% is lower than %v%%", status.Availability, c.slo.Availability)
+	} else if c.slo.Latency > 0 && status.Latency > c.slo.Latency {
+		status.Breached = true
+		status.Reason = fmt.Sprintf("p%v latency %dms is higher than %dms", c.slo.Percentile, status.Latency, c.slo.Latency)
+	}
+	c.lock.Lock()
+	changed := status.Breached != c.status.Breached
+	c.status = status
+	c.lock.Unlock()
+
+	keyPrefix := s.metrics.prefix + metrics.Escape(status.Method)
+	breach := int64(0)
+	if status.Breached {
+		breach = 1
+	}
+	addCallGauge(s.metrics.group, s.metrics.service, keyPrefix+SLOAvailabilityMetricSuffix, int64(status.Availability*100))
+	addCallGauge(s.metrics.group, s.metrics.service, keyPrefix+SLOLatencyMetricSuffix, status.Latency)
+	addCallGauge(s.metrics.group, s.metrics.service, keyPrefix+SLOBreachMetricSuffix, breach)
+	if changed {
+		notifySLOBreach(status)
+	}
+}
+
+// statuses returns the compliance of the methods called, ordered by method
+func (s *sloTracker) statuses() []SLOStatus {
+	if s == nil {
+		return nil
+	}
+	s.lock.RLock()
+	statuses := make([]SLOStatus, 0, len(s.methods))
+	for _, c := range s.methods {
+		c.lock.Lock()
+		statuses = append(statuses, c.status)
+		c.lock.Unlock()
+	}
+	s.lock.RUnlock()
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Method < statuses[j].Method })
+	return statuses
+}
+
+// GetSLOStatus returns the SLO compliance of the methods of all the services, ordered by service and method.
+// the compliance of a method is not computed before it is called sloInterval times
+func (d *DefaultMessageHandler) GetSLOStatus() []SLOStatus {
+	d.lock.RLock()
+	trackers := make([]*sloTracker, 0, len(d.configs))
+	for _, c := range d.configs {
+		if c.slo != nil {
+			trackers = append(trackers, c.slo)
+		}
+	}
+	d.lock.RUnlock()
+	var statuses []SLOStatus
+	for _, s := range trackers {
+		statuses = append(statuses, s.statuses()...)
+	}
+	sort.SliceStable(statuses, func(i, j int) bool {
+		if statuses[i].Service != statuses[j].Service {
+			return statuses[i].Service < statuses[j].Service
+		}
+		return statuses[i].Method < statuses[j].Method
+	})
+	return statuses
+}
+
+// GetExportedSLOStatus returns the SLO compliance of the services of all the running motan servers
+func GetExportedSLOStatus() []SLOStatus {
+	_, servers := runningSnapshot()
+	var statuses []SLOStatus
+	for _, s := range servers {
+		if h, ok := s.GetMessageHandler().(interface{ GetSLOStatus() []SLOStatus }); ok {
+			statuses = append(statuses, h.GetSLOStatus()...)
+		}
+	}
+	return statuses
+}
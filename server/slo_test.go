@@ -0,0 +1,102 @@
+package server
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/metrics"
+)
+
+func TestMethodSLOs(t *testing.T) {
+	for _, value := range []string{"[]", `{"":{"availability":99}}`, `{"hello":{"availability":101}}`, `{"hello":{"latency":-1}}`, `{"hello":{"percentile":200}}`} {
+		_, err := parseMethodSLOs(&motan.URL{Parameters: map[string]string{MethodSLOsKey: value}})
+		assert.NotNil(t, err, value)
+	}
+	slos, err := parseMethodSLOs(&motan.URL{Parameters: map[string]string{MethodSLOsKey: `{"*":{"availability":99.9},"Hello":{"latency":50}}`}})
+	assert.Nil(t, err)
+	slo, ok := slos.get("hello")
+	assert.True(t, ok)
+	assert.Equal(t, SLO{Latency: 50, Percentile: 99}, slo)
+	slo, ok = slos.get("other")
+	assert.True(t, ok)
+	assert.Equal(t, 99.9, slo.Availability)
+}
+
+func TestSLOTracking(t *testing.T) {
+	var lock sync.Mutex
+	gauges := make(map[string]int64)
+	addCallGauge = func(group string, service string, key string, value int64) {
+		lock.Lock()
+		defer lock.Unlock()
+		gauges[key] = value
+	}
+	var breaches []SLOStatus
+	SetSLOBreachHandler(func(status SLOStatus) {
+		lock.Lock()
+		defer lock.Unlock()
+		breaches = append(breaches, status)
+	})
+	defer func() {
+		addCallGauge = metrics.AddGauge
+		SetSLOBreachHandler(nil)
+	}()
+	failing := true
+	p := newTestProvider("sloService", map[string]string{MethodSLOsKey: `{"fail":{"availability":99},"slow":{"latency":1,"percentile":50},"biz":{"availability":99}}`})
+	p.callFunc = func(request motan.Request) motan.Response {
+		switch request.GetMethod() {
+		case "fail":
+			if failing {
+				return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 503, ErrMsg: "fail", ErrType: motan.ServiceException})
+			}
+		case "biz":
+			return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 400, ErrMsg: "biz", ErrType: motan.BizException})
+		case "slow":
+			time.Sleep(3 * time.Millisecond)
+		}
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: "ok"}
+	}
+	handler := newTestHandler(p)
+	for i := 0; i < sloInterval; i++ {
+		handler.Call(newTestRequest("sloService", "fail"))
+		handler.Call(newTestRequest("sloService", "slow"))
+		handler.Call(newTestRequest("sloService", "biz"))
+		handler.Call(newTestRequest("sloService", "other"))
+	}
+	statuses := handler.GetSLOStatus()
+	assert.Equal(t, 3, len(statuses))
+	assert.Equal(t, "biz", statuses[0].Method)
+	assert.False(t, statuses[0].Breached)
+	assert.Equal(t, float64(100), statuses[0].Availability)
+	assert.Equal(t, "fail", statuses[1].Method)
+	assert.True(t, statuses[1].Breached)
+	assert.Equal(t, sloInterval, statuses[1].Samples)
+	assert.Equal(t, float64(0), statuses[1].Availability)
+	assert.Equal(t, "slow", statuses[2].Method)
+	assert.True(t, statuses[2].Breached)
+	assert.True(t, statuses[2].Latency >= 3)
+	assert.Contains(t, statuses[2].Reason, "latency")
+
+	lock.Lock()
+	assert.Equal(t, 2, len(breaches))
+	assert.Equal(t, int64(1), gauges["motan-server-handler:unknown:fail"+SLOBreachMetricSuffix])
+	assert.Equal(t, int64(0), gauges["motan-server-handler:unknown:fail"+SLOAvailabilityMetricSuffix])
+	assert.Equal(t, int64(10000), gauges["motan-server-handler:unknown:biz"+SLOAvailabilityMetricSuffix])
+	lock.Unlock()
+
+	// complies again after the failures roll out of the samples
+	failing = false
+	for i := 0; i < sloSamples; i++ {
+		handler.Call(newTestRequest("sloService", "fail"))
+	}
+	statuses = handler.GetSLOStatus()
+	assert.False(t, statuses[1].Breached)
+	lock.Lock()
+	assert.Equal(t, 3, len(breaches))
+	assert.Equal(t, "fail", breaches[2].Method)
+	assert.False(t, breaches[2].Breached)
+	assert.Equal(t, int64(0), gauges["motan-server-handler:unknown:fail"+SLOBreachMetricSuffix])
+	lock.Unlock()
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+	mpro "github.com/weibocom/motan-go/protocol"
+)
+
+// the provider url parameters of the slow request detection. the requests cost more than SlowRequestThresholdKey(ms)
+// are logged with the caller and the summary of the arguments, 0 disables it. if SlowRequestProfileKey is set, the
+// profiles of the process are captured when a slow request is detected, they are written to SlowRequestProfileDirKey
+// and captured at most once every SlowRequestProfileIntervalKey(ms) for all the services of the process
+const (
+	SlowRequestThresholdKey       = "slowRequestThreshold"
+	SlowRequestProfileKey         = "slowRequestProfile" // comma separated profiles, cpu and goroutine
+	SlowRequestProfileDirKey      = "slowRequestProfileDir"
+	SlowRequestProfileIntervalKey = "slowRequestProfileInterval"
+	SlowRequestProfileDurationKey = "slowRequestProfileDuration" // ms of the cpu profile
+)
+
+// the profiles captured for the slow requests
+const (
+	CPUProfile       = "cpu"
+	GoroutineProfile = "goroutine"
+)
+
+const (
+	defaultSlowRequestProfileInterval = time.Minute
+	defaultSlowRequestProfileDuration = 3 * time.Second
+	// the max length of the arguments in the logs
+	slowRequestArgumentsSize = 512
+)
+
+var defaultSlowRequestProfileDir = filepath.Join(os.TempDir(), "motan-profiles")
+
+type slowRequestDetector struct {
+	threshold time.Duration
+	profiles  []string
+	dir       string
+	interval  time.Duration
+	duration  time.Duration
+}
+
+// parseSlowRequestDetector returns nil if the slow request detection is disabled
+func parseSlowRequestDetector(url *motan.URL) (*slowRequestDetector, error) {
+	threshold := url.GetTimeDuration(SlowRequestThresholdKey, time.Millisecond, 0)
+	if threshold <= 0 {
+		return nil, nil
+	}
+	s := &slowRequestDetector{
+		threshold: threshold,
+		dir:       url.GetParam(SlowRequestProfileDirKey, defaultSlowRequestProfileDir),
+		interval:  url.GetTimeDuration(SlowRequestProfileIntervalKey, time.Millisecond, defaultSlowRequestProfileInterval),
+		duration:  url.GetTimeDuration(SlowRequestProfileDurationKey, time.Millisecond, defaultSlowRequestProfileDuration),
+	}
+	for _, profile := range motan.TrimSplit(url.GetParam(SlowRequestProfileKey, ""), ",") {
+		if profile == "" {
+			continue
+		}
+		if profile != CPUProfile && profile != GoroutineProfile {
+			return nil, errors.New("illegal " + SlowRequestProfileKey + ": unknown profile " + profile)
+		}
+		s.profiles = append(s.profiles, profile)
+	}
+	if s.interval <= 0 || s.duration <= 0 {
+		return nil, fmt.Errorf("illegal %s or %s: the durations must be positive", SlowRequestProfileIntervalKey, SlowRequestProfileDurationKey)
+	}
+	return s, nil
+}
+
+func (s *slowRequestDetector) observe(request motan.Request, start time.Time, res motan.Response) {
+	if s == nil {
+		return
+	}
+	cost := time.Since(start)
+	if cost < s.threshold {
+		return
+	}
+	var exception *motan.Exception
+	if res != nil {
+		exception = res.GetException()
+	}
+	vlog.Warningw("slow request", vlog.FieldService, request.GetServiceName(), vlog.FieldMethod, request.GetMethod(),
+		vlog.FieldGroup, request.GetAttachment(mpro.MGroup), vlog.FieldRequestID, request.GetRequestID(),
+		vlog.FieldRemoteAddress, request.GetAttachment(motan.HostKey), vlog.FieldCost, int64(cost/time.Millisecond),
+		"threshold", int64(s.threshold/time.Millisecond), "arguments", summarizeArguments(request.GetArguments()), "exception", exception)
+	if len(s.profiles) > 0 {
+		slowProfiler.capture(s, request)
+	}
+}
+
+// summarizeArguments returns the truncated arguments, the arguments not deserialized are summarized by their size
+func summarizeArguments(arguments []interface{}) string {
+	var b strings.Builder
+	b.WriteString("[")
+	for i, argument := range arguments {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		switch v := argument.(type) {
+		case *motan.DeserializableValue:
+			b.WriteString("<" + strconv.Itoa(len(v.Body)) + " bytes>")
+		case []byte:
+			b.WriteString("<" + strconv.Itoa(len(v)) + " bytes>")
+		default:
+			fmt.Fprintf(&b, "%v", v)
+		}
+		if b.Len() > slowRequestArgumentsSize {
+			break
+		}
+	}
+	if b.Len() > slowRequestArgumentsSize {
+		return b.String()[:slowRequestArgumentsSize] + "...]"
+	}
+	b.WriteString("]")
+	return b.String()
+}
+
+// slowRequestProfiler rate limits the profile captures of the process
+type slowRequestProfiler struct {
+	lock sync.Mutex
+	last time.Time
+}
+
+var slowProfiler = &slowRequestProfiler{}
+
+// capture returns false if the profiles are rate limited, the cpu profile is written asynchronously after the duration
+func (p *slowRequestProfiler) capture(s *slowRequestDetector, request motan.Request) bool {
+	p.lock.Lock()
+	now := time.Now()
+	if !p.last.IsZero() && now.Sub(p.last) < s.interval {
+		p.lock.Unlock()
+		return false
+	}
+	p.last = now
+	p.lock.Unlock()
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		vlog.Warningf("create slow request profile dir %s fail. err:%v", s.dir, err)
+		return true
+	}
+	prefix := filepath.Join(s.dir, fmt.Sprintf("%s-%s-%d-%s", request.GetServiceName(), request.GetMethod(), os.Getpid(), now.Format("20060102150405.000")))
+	for _, profile := range s.profiles {
+		switch profile {
+		case GoroutineProfile:
+			writeProfileFile(prefix+".goroutine.pprof", func(f *os.File) error {
+				return pprof.Lookup(GoroutineProfile).WriteTo(f, 0)
+			})
+		case CPUProfile:
+			go writeProfileFile(prefix+".cpu.pprof", func(f *os.File) error {
+				// fails if another cpu profile is running, e.g. by the pprof debug handler
+				if err := pprof.StartCPUProfile(f); err != nil {
+					return err
+				}
+				time.Sleep(s.duration)
+				pprof.StopCPUProfile()
+				return nil
+			})
+		}
+	}
+	return true
+}
+
+func writeProfileFile(path string, write func(f *os.File) error) {
+	f, err := os.Create(path)
+	if err != nil {
+		vlog.Warningf("create slow request profile %s fail. err:%v", path, err)
+		return
+	}
+	err = write(f)
+	f.Close()
+	if err != nil {
+		os.Remove(path)
+		vlog.Warningf("capture slow request profile %s fail. err:%v", path, err)
+		return
+	}
+	vlog.Infof("slow request profile is captured: %s", path)
+}
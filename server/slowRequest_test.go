@@ -0,0 +1,66 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+)
+
+func TestSlowRequestDetector(t *testing.T) {
+	s, err := parseSlowRequestDetector(&motan.URL{Parameters: map[string]string{}})
+	assert.Nil(t, err)
+	assert.Nil(t, s)
+	_, err = parseSlowRequestDetector(&motan.URL{Parameters: map[string]string{SlowRequestThresholdKey: "10", SlowRequestProfileKey: "heap"}})
+	assert.NotNil(t, err)
+	_, err = parseSlowRequestDetector(&motan.URL{Parameters: map[string]string{SlowRequestThresholdKey: "10", SlowRequestProfileIntervalKey: "-1"}})
+	assert.NotNil(t, err)
+
+	assert.Equal(t, "[a, 1, <3 bytes>]", summarizeArguments([]interface{}{"a", 1, &motan.DeserializableValue{Body: []byte("abc")}}))
+	summary := summarizeArguments([]interface{}{strings.Repeat("a", 1000), "b"})
+	assert.Equal(t, slowRequestArgumentsSize+len("...]"), len(summary))
+
+	dir, err := ioutil.TempDir("", "slowRequest")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	p := newTestProvider("slowService", map[string]string{
+		SlowRequestThresholdKey:       "5",
+		SlowRequestProfileKey:         "goroutine, cpu",
+		SlowRequestProfileDirKey:      dir,
+		SlowRequestProfileIntervalKey: "60000",
+		SlowRequestProfileDurationKey: "50",
+	})
+	p.callFunc = func(request motan.Request) motan.Response {
+		if request.GetMethod() == "slow" {
+			time.Sleep(10 * time.Millisecond)
+		}
+		return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: "ok"}
+	}
+	handler := newTestHandler(p)
+	profiles := func() []string {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.pprof"))
+		return files
+	}
+
+	handler.Call(newTestRequest("slowService", "fast"))
+	assert.Equal(t, 0, len(profiles()))
+
+	handler.Call(newTestRequest("slowService", "slow"))
+	// the cpu profile is written after the duration
+	time.Sleep(200 * time.Millisecond)
+	files := profiles()
+	if assert.Equal(t, 2, len(files)) {
+		assert.True(t, strings.HasSuffix(files[0], ".cpu.pprof"))
+		assert.True(t, strings.HasSuffix(files[1], ".goroutine.pprof"))
+	}
+
+	// the profiles are rate limited
+	handler.Call(newTestRequest("slowService", "slow"))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 2, len(profiles()))
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+	"github.com/weibocom/motan-go/metrics"
+	mpro "github.com/weibocom/motan-go/protocol"
+)
+
+// the frames received are buffered, the connection is not read when the buffer is full until the provider receives
+const serverStreamBufferSize = 64
+
+// StreamFailureMetric is the counter of the streams terminated in the middle, e.g. a message can not be serialized
+const StreamFailureMetric = "stream_failure.total_count"
+
+var errStreamConnClosed = errors.New("stream connection is closed")
+
+// serverStream is the stream of a request opening a stream, the frames are written to the connection until the final
+// response is sent
+type serverStream struct {
+	lock          sync.Mutex
+	owner         *serverStreams
+	conn          net.Conn
+	requestID     uint64
+	serialization motan.Serialization
+	recvCh        chan *mpro.Message
+	closeCh       chan struct{}
+	closeOnce     sync.Once
+	recvEnd       bool
+	finished      bool
+	// terminated by fail, the final response has been sent
+	failed bool
+}
+
+func newServerStream(conn net.Conn, requestID uint64, serialization motan.Serialization) *serverStream {
+	return &serverStream{
+		conn:          conn,
+		requestID:     requestID,
+		serialization: serialization,
+		recvCh:        make(chan *mpro.Message, serverStreamBufferSize),
+		closeCh:       make(chan struct{}),
+	}
+}
+
+func (s *serverStream) Send(value interface{}) error {
+	if s.serialization == nil {
+		return errors.New("stream has no serialization")
+	}
+	body, err := s.serialization.Serialize(value)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.finished {
+		return motan.ErrStreamClosed
+	}
+	if err != nil {
+		s.fail("serialize stream message fail. err:" + err.Error())
+		return err
+	}
+	msg := mpro.BuildStreamFrame(mpro.Res, s.requestID, s.serialization.GetSerialNum(), mpro.StreamFrameData, body)
+	s.conn.SetWriteDeadline(time.Now().Add(motan.DefaultWriteTimeout))
+	buf := msg.Encode()
+	_, err = s.conn.Write(buf.Bytes())
+	motan.ReleaseBytesBuffer(buf)
+	return err
+}
+
+func (s *serverStream) Recv(v interface{}) (interface{}, error) {
+	if s.recvEnd {
+		return nil, io.EOF
+	}
+	select {
+	case msg := <-s.recvCh:
+		if msg.GetStreamFrame() == mpro.StreamFrameEnd {
+			s.recvEnd = true
+			return nil, io.EOF
+		}
+		if s.serialization == nil {
+			return nil, errors.New("stream has no serialization")
+		}
+		return s.serialization.DeSerialize(msg.Body, v)
+	case <-s.closeCh:
+		return nil, errStreamConnClosed
+	}
+}
+
+// CloseSend does nothing, the stream of the server is finished by the final response
+func (s *serverStream) CloseSend() error {
+	return nil
+}
+
+// deliver is called by the reading goroutine of the connection
+func (s *serverStream) deliver(msg *mpro.Message) {
+	select {
+	case s.recvCh <- msg:
+	case <-s.closeCh:
+	}
+}
+
+// fail terminates the stream with an exception response as the final response, so the client receives the error
+// instead of waiting for the following messages. it is called with the lock
+func (s *serverStream) fail(errMsg string) {
+	s.finished = true
+	s.failed = true
+	vlog.Warningf("stream %d is terminated: %s", s.requestID, errMsg)
+	if s.owner != nil && s.owner.failureKey != "" {
+		addCallCounter(metrics.DefaultStatGroup, metrics.DefaultStatService, s.owner.failureKey, 1)
+	}
+	res := mpro.BuildExceptionResponse(s.requestID, mpro.ExceptionToJSON(&motan.Exception{ErrCode: 500, ErrMsg: errMsg, ErrType: motan.ServiceException}))
+	s.conn.SetWriteDeadline(time.Now().Add(motan.DefaultWriteTimeout))
+	buf := res.Encode()
+	if _, err := s.conn.Write(buf.Bytes()); err != nil {
+		vlog.Warningf("write stream failure response fail. rid:%d, err:%s", s.requestID, err.Error())
+	}
+	motan.ReleaseBytesBuffer(buf)
+	// the provider receiving the stream is not blocked
+	s.close()
+}
+
+// finish is called before the final response is sent, it returns false if the final response has been sent by fail
+func (s *serverStream) finish() bool {
+	s.lock.Lock()
+	s.finished = true
+	failed := s.failed
+	s.lock.Unlock()
+	s.owner.remove(s.requestID)
+	s.close()
+	return !failed
+}
+
+func (s *serverStream) close() {
+	s.closeOnce.Do(func() {
+		close(s.closeCh)
+	})
+}
+
+// serverStreams are the streams opened on a connection
+type serverStreams struct {
+	lock    sync.Mutex
+	streams map[uint64]*serverStream
+	// the key of StreamFailureMetric
+	failureKey string
+}
+
+func (c *serverStreams) open(conn net.Conn, requestID uint64, serialization motan.Serialization) *serverStream {
+	s := newServerStream(conn, requestID, serialization)
+	s.owner = c
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.streams == nil {
+		c.streams = make(map[uint64]*serverStream)
+	}
+	c.streams[requestID] = s
+	return s
+}
+
+func (c *serverStreams) remove(requestID uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.streams, requestID)
+}
+
+func (c *serverStreams) dispatch(msg *mpro.Message) {
+	c.lock.Lock()
+	s := c.streams[msg.Header.RequestID]
+	c.lock.Unlock()
+	if s == nil {
+		vlog.Warningf("receive the frame of a missing stream: %d", msg.Header.RequestID)
+		return
+	}
+	s.deliver(msg)
+}
+
+// closeAll is called when the connection is closed
+func (c *serverStreams) closeAll() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for id, s := range c.streams {
+		s.close()
+		delete(c.streams, id)
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/endpoint"
+	"github.com/weibocom/motan-go/metrics"
+	"github.com/weibocom/motan-go/serialize"
+)
+
+func TestStream(t *testing.T) {
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	serialize.RegistDefaultSerializations(ext)
+	// the streams are not limited by the request timeout
+	p := newTestProvider("streamService", map[string]string{motan.TimeOutKey: "100"})
+	p.callFunc = func(request motan.Request) motan.Response {
+		stream := motan.GetStream(request)
+		if stream == nil {
+			return &motan.MotanResponse{RequestID: request.GetRequestID(), Value: "no stream"}
+		}
+		if request.GetMethod() == "fail" {
+			return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 500, ErrMsg: "stream fail", ErrType: motan.BizException})
+		}
+		if request.GetMethod() == "unserializable" {
+			assert.NotNil(t, stream.Send(make(chan int)))
+			// the stream is terminated by the failure
+			assert.Equal(t, motan.ErrStreamClosed, stream.Send("after failure"))
+			return &motan.MotanResponse{RequestID: request.GetRequestID()}
+		}
+		// echo until the client closes sending
+		for {
+			var s string
+			_, err := stream.Recv(&s)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 500, ErrMsg: err.Error(), ErrType: motan.BizException})
+			}
+			if err = stream.Send("echo " + s); err != nil {
+				return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 500, ErrMsg: err.Error(), ErrType: motan.BizException})
+			}
+		}
+		return &motan.MotanResponse{RequestID: request.GetRequestID()}
+	}
+	server := &MotanServer{URL: &motan.URL{Protocol: "motan2", Host: "127.0.0.1", Port: 64598}}
+	assert.Nil(t, server.Open(false, false, newTestHandler(p), ext))
+	defer server.Destroy()
+
+	url := &motan.URL{Protocol: "motan2", Host: "127.0.0.1", Port: 64598, Path: "streamService"}
+	url.PutParam(motan.TimeOutKey, "1000")
+	url.PutParam(endpoint.StreamTimeoutKey, "3000")
+	ep := &endpoint.MotanEndpoint{}
+	ep.SetURL(url)
+	ep.SetSerialization(&serialize.SimpleSerialization{})
+	ep.Initialize()
+	defer ep.Destroy()
+	time.Sleep(50 * time.Millisecond)
+
+	request := &motan.MotanRequest{RequestID: 1, ServiceName: "streamService", Method: "echo", Attachment: motan.NewStringMap(0)}
+	stream, err := ep.OpenStream(request)
+	assert.Nil(t, err)
+	var reply string
+	for _, s := range []string{"a", "b", "c"} {
+		assert.Nil(t, stream.Send(s))
+		_, err = stream.Recv(&reply)
+		assert.Nil(t, err)
+		assert.Equal(t, "echo "+s, reply)
+	}
+	// the stream lives longer than the request timeout
+	time.Sleep(150 * time.Millisecond)
+	assert.Nil(t, stream.Send("d"))
+	_, err = stream.Recv(&reply)
+	assert.Nil(t, err)
+	assert.Equal(t, "echo d", reply)
+	assert.Nil(t, stream.CloseSend())
+	assert.Equal(t, motan.ErrStreamClosed, stream.Send("e"))
+	_, err = stream.Recv(&reply)
+	assert.Equal(t, io.EOF, err)
+	_, err = stream.Recv(&reply)
+	assert.Equal(t, io.EOF, err)
+
+	// the exception of the final response
+	request = &motan.MotanRequest{RequestID: 2, ServiceName: "streamService", Method: "fail", Attachment: motan.NewStringMap(0)}
+	stream, err = ep.OpenStream(request)
+	assert.Nil(t, err)
+	_, err = stream.Recv(&reply)
+	assert.Equal(t, errors.New("stream fail"), err)
+
+	// the failure in the middle terminates the stream with an exception
+	recorder := &callMetricsRecorder{counts: make(map[string]int64)}
+	addCallCounter = recorder.add
+	defer func() {
+		addCallCounter = metrics.AddCounter
+	}()
+	request = &motan.MotanRequest{RequestID: 4, ServiceName: "streamService", Method: "unserializable", Attachment: motan.NewStringMap(0)}
+	stream, err = ep.OpenStream(request)
+	assert.Nil(t, err)
+	_, err = stream.Recv(&reply)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "serialize stream message fail")
+	_, err = stream.Recv(&reply)
+	assert.NotNil(t, err)
+	assert.Equal(t, int64(1), recorder.count(metrics.DefaultStatGroup, metrics.DefaultStatService, metrics.DefaultStatRole+metrics.KeyDelimiter+metrics.DefaultStatApplication+metrics.KeyDelimiter+StreamFailureMetric))
+
+	// normal calls are not affected
+	var value string
+	request = &motan.MotanRequest{RequestID: 3, ServiceName: "streamService", Method: "echo", Attachment: motan.NewStringMap(0)}
+	request.GetRPCContext(true).Reply = &value
+	res := ep.Call(request)
+	assert.Nil(t, res.GetException())
+	assert.Equal(t, "no stream", value)
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+)
+
+// MethodSunsetsKey is the provider url parameter of the sunset time of the deprecated methods, the value is a json map of
+// method name to RFC3339 time or date(UTC), e.g. {"hello":"2024-01-01","hi":"2024-01-01T08:00:00+08:00"}. the responses
+// of a method have the SunsetAttachment before its sunset time, and the method is retired after that
+const MethodSunsetsKey = "methodSunsets"
+
+// SunsetAttachment is the response attachment of the sunset time(RFC3339) of a deprecated method, callers should migrate
+// from the method before the time
+const SunsetAttachment = "M_sunset"
+
+// methodSunsets is the sunset time of methods
+type methodSunsets map[string]time.Time
+
+var sunsetNow = time.Now
+
+func parseMethodSunsets(url *motan.URL) (methodSunsets, error) {
+	value := url.GetParam(MethodSunsetsKey, "")
+	if value == "" {
+		return nil, nil
+	}
+	var raw map[string]string
+	if err := json.Unmarshal([]byte(value), &raw); err != nil {
+		return nil, fmt.Errorf("illegal %s: %s, err: %v", MethodSunsetsKey, value, err)
+	}
+	sunsets := make(methodSunsets, len(raw))
+	for method, date := range raw {
+		if method == "" {
+			return nil, errors.New("illegal " + MethodSunsetsKey + ": empty method name")
+		}
+		t, err := time.Parse(time.RFC3339, date)
+		if err != nil {
+			if t, err = time.Parse("2006-01-02", date); err != nil {
+				return nil, fmt.Errorf("illegal %s: sunset of method %s should be RFC3339 time or date, value: %s", MethodSunsetsKey, method, date)
+			}
+		}
+		sunsets[method] = t
+	}
+	return sunsets, nil
+}
+
+func (m methodSunsets) get(method string) (time.Time, bool) {
+	if t, ok := m[method]; ok {
+		return t, true
+	}
+	t, ok := m[motan.FirstUpper(method)]
+	return t, ok
+}
+
+// check returns the exception response if the method is retired
+func (m methodSunsets) check(request motan.Request) motan.Response {
+	if m == nil {
+		return nil
+	}
+	if t, ok := m.get(request.GetMethod()); ok && !sunsetNow().Before(t) {
+		return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 410,
+			ErrMsg: "method " + request.GetMethod() + " of " + request.GetServiceName() + " is retired since " + t.Format(time.RFC3339), ErrType: motan.ServiceException})
+	}
+	return nil
+}
+
+// warn sets the sunset time of the deprecated method to the response
+func (m methodSunsets) warn(request motan.Request, res motan.Response) {
+	if m == nil || res == nil {
+		return
+	}
+	if t, ok := m.get(request.GetMethod()); ok {
+		res.SetAttachment(SunsetAttachment, t.Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+	mpro "github.com/weibocom/motan-go/protocol"
+)
+
+// limits of request taps, a tap can not be started without a bound
+const (
+	MaxTapDuration = 10 * time.Minute
+	MaxTapCount    = 10000
+	MaxActiveTaps  = 8
+
+	tapQueueSize = 256
+)
+
+var (
+	ErrTooManyTaps = errors.New("too many active request taps")
+	ErrTapNoBound  = errors.New("tap count and duration should be positive")
+)
+
+// TapFilter selects the requests of a tap, empty field matches any value
+type TapFilter struct {
+	Service string
+	Method  string
+	Caller  string // application of the client
+}
+
+func (f *TapFilter) match(request motan.Request) bool {
+	return (f.Service == "" || f.Service == request.GetServiceName()) &&
+		(f.Method == "" || f.Method == request.GetMethod()) &&
+		(f.Caller == "" || f.Caller == request.GetAttachment(mpro.MSource))
+}
+
+// TapRecord is the summary of a tapped request, arguments and attachment values are not included
+type TapRecord struct {
+	Time         int64  `json:"time"` // ms
+	RequestID    uint64 `json:"requestId"`
+	Service      string `json:"service"`
+	Method       string `json:"method"`
+	Caller       string `json:"caller"`
+	RemoteIP     string `json:"remoteIp"`
+	Cost         int64  `json:"cost"` // ms from receive to send
+	Attachments  int    `json:"attachments"`
+	ResponseSize int    `json:"responseSize"`
+	ErrCode      int    `json:"errCode,omitempty"`
+	ErrMsg       string `json:"errMsg,omitempty"`
+}
+
+// Tap streams the summaries of matched requests until it is stopped, or the count or duration is reached.
+// records are dropped if the subscriber can not keep up, so a slow subscriber never blocks requests
+type Tap struct {
+	filter    TapFilter
+	remaining int64
+	records   chan *TapRecord
+	done      chan struct{}
+	timer     *time.Timer
+	stopOnce  sync.Once
+	lock      sync.RWMutex
+	stopped   bool
+}
+
+var (
+	activeTaps    []*Tap
+	activeTapSize int32 // fast path of no active tap
+	tapLock       sync.Mutex
+)
+
+// StartTap start a tap with a max count and duration, both are required and capped by MaxTapCount and MaxTapDuration
+func StartTap(filter TapFilter, count int, duration time.Duration) (*Tap, error) {
+	if count <= 0 || duration <= 0 {
+		return nil, ErrTapNoBound
+	}
+	if count > MaxTapCount {
+		count = MaxTapCount
+	}
+	if duration > MaxTapDuration {
+		duration = MaxTapDuration
+	}
+	tapLock.Lock()
+	defer tapLock.Unlock()
+	if len(activeTaps) >= MaxActiveTaps {
+		return nil, ErrTooManyTaps
+	}
+	t := &Tap{filter: filter, remaining: int64(count), records: make(chan *TapRecord, tapQueueSize), done: make(chan struct{})}
+	t.timer = time.AfterFunc(duration, t.Stop)
+	activeTaps = append(activeTaps, t)
+	atomic.StoreInt32(&activeTapSize, int32(len(activeTaps)))
+	vlog.Infof("request tap started. filter:%+v, count:%d, duration:%v", filter, count, duration)
+	return t, nil
+}
+
+// Records returns the channel of tapped records, it is closed when the tap stopped
+func (t *Tap) Records() <-chan *TapRecord {
+	return t.records
+}
+
+// Done returns a channel which is closed when the tap stopped
+func (t *Tap) Done() <-chan struct{} {
+	return t.done
+}
+
+func (t *Tap) Stop() {
+	t.stopOnce.Do(func() {
+		tapLock.Lock()
+		t.timer.Stop()
+		for i, a := range activeTaps {
+			if a == t {
+				activeTaps = append(activeTaps[:i:i], activeTaps[i+1:]...)
+				break
+			}
+		}
+		atomic.StoreInt32(&activeTapSize, int32(len(activeTaps)))
+		tapLock.Unlock()
+		t.lock.Lock()
+		t.stopped = true
+		close(t.records)
+		t.lock.Unlock()
+		close(t.done)
+		vlog.Infof("request tap stopped. filter:%+v", t.filter)
+	})
+}
+
+func (t *Tap) offer(record *TapRecord) {
+	t.lock.RLock()
+	if t.stopped {
+		t.lock.RUnlock()
+		return
+	}
+	remaining := atomic.AddInt64(&t.remaining, -1)
+	if remaining >= 0 {
+		select {
+		case t.records <- record:
+		default:
+		}
+	}
+	t.lock.RUnlock()
+	if remaining <= 0 {
+		go t.Stop()
+	}
+}
+
+func tapping() bool {
+	return atomic.LoadInt32(&activeTapSize) > 0
+}
+
+// tapRequest offer the summary of the request to all matched taps
+func tapRequest(request motan.Request, response motan.Response) {
+	if !tapping() {
+		return
+	}
+	tapLock.Lock()
+	taps := make([]*Tap, 0, len(activeTaps))
+	for _, t := range activeTaps {
+		if t.filter.match(request) {
+			taps = append(taps, t)
+		}
+	}
+	tapLock.Unlock()
+	if len(taps) == 0 {
+		return
+	}
+	record := &TapRecord{
+		Time:      time.Now().UnixNano() / 1e6,
+		RequestID: request.GetRequestID(),
+		Service:   request.GetServiceName(),
+		Method:    request.GetMethod(),
+		Caller:    request.GetAttachment(mpro.MSource),
+		RemoteIP:  request.GetAttachment(motan.HostKey),
+	}
+	if attachments := request.GetAttachments(); attachments != nil {
+		attachments.Range(func(k, v string) bool {
+			record.Attachments++
+			return true
+		})
+	}
+	if ctx := request.GetRPCContext(false); ctx != nil && !ctx.RequestReceiveTime.IsZero() && !ctx.ResponseSendTime.IsZero() {
+		record.Cost = int64(ctx.ResponseSendTime.Sub(ctx.RequestReceiveTime) / time.Millisecond)
+	}
+	if response != nil {
+		record.ResponseSize = response.GetRPCContext(true).BodySize
+		if e := response.GetException(); e != nil {
+			record.ErrCode = e.ErrCode
+			record.ErrMsg = e.ErrMsg
+		}
+	}
+	for _, t := range taps {
+		t.offer(record)
+	}
+}
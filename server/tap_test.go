@@ -0,0 +1,68 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+	mpro "github.com/weibocom/motan-go/protocol"
+)
+
+func buildTapRequest(service string, method string, caller string) motan.Request {
+	request := &motan.MotanRequest{ServiceName: service, Method: method, RequestID: 1}
+	request.SetAttachment(mpro.MSource, caller)
+	return request
+}
+
+func TestRequestTap(t *testing.T) {
+	_, err := StartTap(TapFilter{}, 0, time.Second)
+	assert.Equal(t, ErrTapNoBound, err)
+	assert.False(t, tapping())
+
+	tap, err := StartTap(TapFilter{Service: "s1", Caller: "app1"}, 2, time.Second)
+	assert.Nil(t, err)
+	assert.True(t, tapping())
+	response := motan.BuildExceptionResponse(1, &motan.Exception{ErrCode: 503, ErrMsg: "overload"})
+	tapRequest(buildTapRequest("s2", "m", "app1"), response)
+	tapRequest(buildTapRequest("s1", "m", "app2"), response)
+	tapRequest(buildTapRequest("s1", "m1", "app1"), response)
+	tapRequest(buildTapRequest("s1", "m2", "app1"), nil)
+	tapRequest(buildTapRequest("s1", "m3", "app1"), nil)
+
+	var records []*TapRecord
+	for r := range tap.Records() {
+		records = append(records, r)
+	}
+	assert.Equal(t, 2, len(records))
+	assert.Equal(t, "m1", records[0].Method)
+	assert.Equal(t, "app1", records[0].Caller)
+	assert.Equal(t, 503, records[0].ErrCode)
+	assert.Equal(t, "m2", records[1].Method)
+	<-tap.Done()
+	assert.False(t, tapping())
+
+	// stopped by duration
+	tap, err = StartTap(TapFilter{}, 10, 50*time.Millisecond)
+	assert.Nil(t, err)
+	select {
+	case <-tap.Done():
+	case <-time.After(time.Second):
+		t.Fatal("tap not stopped after duration")
+	}
+	assert.False(t, tapping())
+
+	var taps []*Tap
+	for i := 0; i < MaxActiveTaps; i++ {
+		tap, err = StartTap(TapFilter{}, 10, time.Second)
+		assert.Nil(t, err)
+		taps = append(taps, tap)
+	}
+	_, err = StartTap(TapFilter{}, 10, time.Second)
+	assert.Equal(t, ErrTooManyTaps, err)
+	for _, tap := range taps {
+		tap.Stop()
+		tap.Stop()
+	}
+	assert.False(t, tapping())
+}
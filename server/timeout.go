@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+	mpro "github.com/weibocom/motan-go/protocol"
+)
+
+// MethodTimeoutsKey is the provider url parameter of method timeout table, the value is a json map of method name to timeout in ms.
+// e.g. {"hello":100,"world":500}
+const MethodTimeoutsKey = "methodTimeouts"
+
+// methodTimeouts is the resolved timeout table of a provider
+type methodTimeouts map[string]time.Duration
+
+func parseMethodTimeouts(url *motan.URL) (methodTimeouts, error) {
+	table := url.GetParam(MethodTimeoutsKey, "")
+	if table == "" {
+		return nil, nil
+	}
+	var raw map[string]int64
+	if err := json.Unmarshal([]byte(table), &raw); err != nil {
+		return nil, fmt.Errorf("illegal %s: %s, err: %v", MethodTimeoutsKey, table, err)
+	}
+	timeouts := make(methodTimeouts, len(raw))
+	for method, ms := range raw {
+		if method == "" {
+			return nil, errors.New("illegal " + MethodTimeoutsKey + ": empty method name")
+		}
+		if ms <= 0 {
+			return nil, fmt.Errorf("illegal %s: timeout of method %s must be positive, value: %d", MethodTimeoutsKey, method, ms)
+		}
+		timeouts[method] = time.Duration(ms) * time.Millisecond
+	}
+	return timeouts, nil
+}
+
+func (m methodTimeouts) get(method string) time.Duration {
+	if m == nil {
+		return 0
+	}
+	if t, ok := m[method]; ok {
+		return t
+	}
+	return m[motan.FirstUpper(method)]
+}
+
+// default timeout of the provider calls without method timeout or caller timeout, see requestTimeout
+const defaultRequestTimeout = time.Second
+
+// requestTimeout returns the budget of the provider call, it is the method timeout, or the remaining timeout of the caller(M_tmo)
+// since the request is received, or the provider url parameter requestTimeout in ms. the learned adaptive timeout replaces
+// the requestTimeout and limits the remaining timeout of the caller. ok is false if the requestTimeout is not
+// positive or the request opens a stream, the provider call is not limited then. the streams live until they are
+// finished by the provider or the connection is closed, the clients bound them by their own stream timeout
+func requestTimeout(url *motan.URL, timeouts methodTimeouts, adaptive *adaptiveTimeouts, request motan.Request) (timeout time.Duration, ok bool) {
+	if motan.GetStream(request) != nil {
+		return 0, false
+	}
+	if timeout = timeouts.get(request.GetMethod()); timeout > 0 {
+		return timeout, true
+	}
+	learned, learnedOK := adaptive.get(request.GetMethod())
+	if ms, err := strconv.ParseInt(request.GetAttachment(mpro.MTimeout), 10, 64); err == nil && ms > 0 {
+		timeout = time.Duration(ms) * time.Millisecond
+		if ctx := request.GetRPCContext(false); ctx != nil && !ctx.RequestReceiveTime.IsZero() {
+			timeout -= time.Since(ctx.RequestReceiveTime)
+		}
+		if timeout < 0 {
+			timeout = 0
+		}
+		if learnedOK && learned < timeout {
+			timeout = learned
+		}
+		return timeout, true
+	}
+	if learnedOK {
+		return learned, true
+	}
+	timeout = url.GetTimeDuration(motan.TimeOutKey, time.Millisecond, defaultRequestTimeout)
+	return timeout, timeout > 0
+}
+
+// callWithTimeout returns a timeout exception response when the provider can not finish in time, the provider is not
+// called if the timeout is already exceeded. the provider call can not be interrupted, it still runs to completion in
+// background and its response will be dropped, but the caller gets the timeout response promptly. the context of the
+// request(see motan.RequestContext) has the deadline of the timeout and is canceled when the call times out, so the
+// providers aware of it can stop early. the response of a provider panic is built by panicResponse
+func callWithTimeout(p motan.Provider, request motan.Request, timeout time.Duration, panicResponse func(motan.Request) motan.Response) motan.Response {
+	if timeout <= 0 {
+		vlog.Warningf("provider call timeout before start. req:%s", motan.GetReqInfo(request))
+		return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 503, ErrMsg: "provider call timeout, caller timeout exceeded before call", ErrType: motan.TimeoutException})
+	}
+	rc := request.GetRPCContext(true)
+	parent := rc.Context
+	ctx, cancel := context.WithTimeout(motan.RequestContext(request), timeout)
+	defer cancel()
+	rc.Context = ctx
+	resChan := make(chan motan.Response, 1)
+	go func() {
+		defer motan.HandleRequestPanicInfo(request, func(info *motan.PanicInfo) {
+			vlog.Errorf("provider call panic. req:%s, error:%v", motan.GetReqInfo(request), info.Err)
+			notifyProviderPanic(request, info)
+			resChan <- panicResponse(request)
+		})
+		resChan <- p.Call(request)
+	}()
+	select {
+	case res := <-resChan:
+		// the request may be called again, e.g. by a retry, the parent context is restored after the provider returns
+		rc.Context = parent
+		return res
+	case <-ctx.Done():
+		vlog.Warningf("provider call timeout. req:%s, timeout:%v", motan.GetReqInfo(request), timeout)
+		return motan.BuildExceptionResponse(request.GetRequestID(), &motan.Exception{ErrCode: 503, ErrMsg: "provider call timeout, timeout: " + timeout.String(), ErrType: motan.TimeoutException})
+	}
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strconv"
+
+	motan "github.com/weibocom/motan-go/core"
+)
+
+// tls options of MotanServer url parameters, see motan.ParseServerTLSConfig
+const (
+	SSLEnableKey    = motan.SSLEnableKey
+	CertFileKey     = motan.CertFileKey
+	KeyFileKey      = motan.KeyFileKey
+	CAFileKey       = motan.CAFileKey
+	ClientAuthKey   = motan.ClientAuthKey
+	VerifyClientKey = "verifyClient" // the same as clientAuth verify if it is true, clientAuth takes precedence
+)
+
+// parseTLSConfig returns nil if tls is not enabled. the certificate is reloaded when the files are modified
+func parseTLSConfig(url *motan.URL) (*tls.Config, error) {
+	if value := url.GetParam(VerifyClientKey, ""); value != "" && url.GetParam(ClientAuthKey, "") == "" {
+		verify, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("illegal %s: %s", VerifyClientKey, value)
+		}
+		if verify {
+			url = url.Copy()
+			url.PutParam(ClientAuthKey, motan.TLSClientAuthVerify)
+		}
+	}
+	return motan.ParseServerTLSConfig(url)
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/serialize"
+)
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1 used by both the server and the client
+func writeTestCert(t *testing.T, dir string) (certFile string, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "motan-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	assert.Nil(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.Nil(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certFile, keyFile
+}
+
+func TestTLSServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeTestCert(t, dir)
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	assert.Nil(t, err)
+	pemBytes, err := ioutil.ReadFile(certFile)
+	assert.Nil(t, err)
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(pemBytes)
+
+	ext := &motan.DefaultExtensionFactory{}
+	ext.Initialize()
+	serialize.RegistDefaultSerializations(ext)
+	url := &motan.URL{Protocol: "motan2", Host: "127.0.0.1", Port: 64591, Path: "tlsService", Parameters: map[string]string{
+		SSLEnableKey: "true", CertFileKey: certFile, KeyFileKey: keyFile, CAFileKey: certFile, VerifyClientKey: "true"}}
+	server := &MotanServer{URL: url}
+	assert.Nil(t, server.Open(false, false, newTestHandler(newTestProvider("tlsService", nil)), ext))
+	defer server.Destroy()
+
+	conn, err := tls.Dial("tcp", "127.0.0.1:64591", &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{cert}})
+	assert.Nil(t, err)
+	defer conn.Close()
+	res := sendTestRequest(t, conn, bufio.NewReader(conn), 1, "tlsService", "hello")
+	assert.NotNil(t, res)
+	assert.False(t, res.Header.IsHeartbeat())
+	assert.Equal(t, uint64(1), res.Header.RequestID)
+
+	// the client without certificate is rejected
+	conn, err = tls.Dial("tcp", "127.0.0.1:64591", &tls.Config{RootCAs: roots})
+	if err == nil {
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(time.Second))
+		_, err = conn.Read(make([]byte, 1))
+	}
+	assert.NotNil(t, err)
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeTestCert(t, dir)
+
+	config, err := parseTLSConfig(&motan.URL{Parameters: map[string]string{SSLEnableKey: "false", CertFileKey: "none"}})
+	assert.Nil(t, err)
+	assert.Nil(t, config)
+	config, err = parseTLSConfig(&motan.URL{Parameters: map[string]string{SSLEnableKey: "true", CertFileKey: certFile, KeyFileKey: keyFile}})
+	assert.Nil(t, err)
+	assert.Equal(t, tls.NoClientCert, config.ClientAuth)
+	for _, params := range []map[string]string{
+		{SSLEnableKey: "yes"},
+		{SSLEnableKey: "true", CertFileKey: certFile},
+		{SSLEnableKey: "true", CertFileKey: filepath.Join(dir, "missing.pem"), KeyFileKey: keyFile},
+		{SSLEnableKey: "true", CertFileKey: keyFile, KeyFileKey: keyFile},
+		{SSLEnableKey: "true", CertFileKey: certFile, KeyFileKey: keyFile, VerifyClientKey: "true"},
+		{SSLEnableKey: "true", CertFileKey: certFile, KeyFileKey: keyFile, CAFileKey: keyFile},
+		{SSLEnableKey: "true", CertFileKey: certFile, KeyFileKey: keyFile, CAFileKey: filepath.Join(dir, "missing.pem")},
+	} {
+		_, err = parseTLSConfig(&motan.URL{Parameters: params})
+		assert.NotNil(t, err, params)
+	}
+
+	// the server is not opened with a bad certificate path
+	url := &motan.URL{Protocol: "motan2", Host: "127.0.0.1", Port: 64592, Path: "tlsService", Parameters: map[string]string{
+		SSLEnableKey: "true", CertFileKey: filepath.Join(dir, "missing.pem"), KeyFileKey: keyFile}}
+	err = (&MotanServer{URL: url}).Open(false, false, newTestHandler(), nil)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "missing.pem")
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+)
+
+// WarmupKey is the provider url parameter of the warmup period in seconds. the weight advertised to the registries ramps
+// linearly from 1 to the configured weight(motan.WeightKey, default 100) within the period after the url becomes
+// available, so the new instance is not hit by the full traffic while it is cold
+const WarmupKey = "warmup"
+
+const (
+	defaultWarmupWeight = 100
+	// the weight is advertised again warmupSteps times within the period
+	warmupSteps = 10
+)
+
+// warmupWeight is the weight advertised after the url has been available for the elapsed time
+func warmupWeight(weight int64, elapsed time.Duration, warmup time.Duration) int64 {
+	if elapsed >= warmup {
+		return weight
+	}
+	if w := int64(float64(weight) * float64(elapsed) / float64(warmup)); w > 1 {
+		return w
+	}
+	return 1
+}
+
+// startWarmup advertises the warmup weights of the available url until the warmup period ends, it returns false if no
+// warmup is configured. it should be called with the lock held
+func (d *DefaultExporter) startWarmup() bool {
+	warmup := d.url.GetTimeDuration(WarmupKey, time.Second, 0)
+	if warmup <= 0 || len(d.Registries) == 0 {
+		return false
+	}
+	d.stopWarmup()
+	cancel := make(chan struct{})
+	d.warmupCancel = cancel
+	d.availableTime = time.Now()
+	weight := d.url.GetPositiveIntValue(motan.WeightKey, defaultWarmupWeight)
+	vlog.Infof("warmup of url %s starts, period: %v, weight: %d", d.url.GetIdentity(), warmup, weight)
+	d.notifyWeight(warmupWeight(weight, 0, warmup))
+	go func() {
+		ticker := time.NewTicker(warmup / warmupSteps)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-cancel:
+				return
+			}
+			d.lock.Lock()
+			if d.warmupCancel != cancel {
+				d.lock.Unlock()
+				return
+			}
+			elapsed := time.Since(d.availableTime)
+			d.notifyWeight(warmupWeight(weight, elapsed, warmup))
+			if elapsed >= warmup {
+				d.warmupCancel = nil
+				d.lock.Unlock()
+				vlog.Infof("warmup of url %s finished", d.url.GetIdentity())
+				return
+			}
+			d.lock.Unlock()
+		}
+	}()
+	return true
+}
+
+// stopWarmup cancels the pending warmup, it should be called with the lock held
+func (d *DefaultExporter) stopWarmup() {
+	if d.warmupCancel != nil {
+		close(d.warmupCancel)
+		d.warmupCancel = nil
+	}
+}
+
+// notifyWeight advertises the url with the weight to the registries. the provider url is read while serving, so a copy
+// is advertised, it should be called with the lock held
+func (d *DefaultExporter) notifyWeight(weight int64) {
+	url := d.url.Copy()
+	url.PutParam(motan.WeightKey, strconv.FormatInt(weight, 10))
+	for _, r := range d.Registries {
+		if b := getRegisterBatcher(r); b != nil && b.isPending(d.url) {
+			continue
+		}
+		r.Available(url)
+	}
+}
@@ -2,10 +2,17 @@ package motan
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	assert2 "github.com/stretchr/testify/assert"
 	"github.com/weibocom/motan-go/config"
 	motan "github.com/weibocom/motan-go/core"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 )
@@ -74,3 +81,162 @@ type HelloService struct{}
 func (m *HelloService) Hello(name string) string {
 	return fmt.Sprintf("Hello %s from motan server", name)
 }
+
+func TestMSContext_ReloadConfig(t *testing.T) {
+	assert := assert2.New(t)
+	cfgText := `
+motan-server:
+  log_dir: "stdout"
+  application: "app-golang"
+
+motan-registry:
+  direct:
+    protocol: direct
+
+motan-service:
+  reload-service:
+    path: %s
+    group: bj
+    protocol: motan2
+    registry: direct
+    serialization: simple
+    ref : "serviceID"
+    export: "motan2:64601"
+`
+	dir, err := ioutil.TempDir("", "motan-reload")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	confFile := filepath.Join(dir, "server.yaml")
+	assert.Nil(ioutil.WriteFile(confFile, []byte(fmt.Sprintf(cfgText, "reloadService1")), 0644))
+	mscontext := GetMotanServerContextWithFlagParse(confFile, false)
+	assert.NotNil(mscontext)
+	assert.Nil(mscontext.RegisterService(&HelloService{}, "serviceID"))
+	ext := GetDefaultExtFactory()
+	mscontext.Start(ext)
+	assert.Len(mscontext.exporters, 1)
+
+	// nothing is changed
+	changes, err := mscontext.ReloadConfig()
+	assert.Nil(err)
+	assert.Empty(changes.ExportedServices)
+	assert.Empty(changes.UnexportedServices)
+
+	assert.Nil(ioutil.WriteFile(confFile, []byte(fmt.Sprintf(cfgText, "reloadService2")), 0644))
+	changes, err = mscontext.ReloadConfig()
+	assert.Nil(err)
+	assert.Len(changes.UnexportedServices, 1)
+	assert.Len(changes.ExportedServices, 1)
+	assert.Len(mscontext.exporters, 1)
+
+	call := func(path string) motan.Response {
+		u := motan.FromExtInfo("motan2://127.0.0.1:64601/" + path + "?serialization=simple")
+		ep := GetDefaultExtFactory().GetEndPoint(u)
+		ep.SetSerialization(motan.GetSerialization(u, ext))
+		motan.Initialize(ep)
+		defer ep.Destroy()
+		request := newRequest(path, "hello", "Ray")
+		request.Attachment = motan.NewStringMap(motan.DefaultAttachmentSize)
+		return ep.Call(request)
+	}
+	resp := call("reloadService2")
+	assert.Nil(resp.GetException())
+	assert.Equal("Hello Ray from motan server", resp.GetValue())
+	assert.NotNil(call("reloadService1").GetException())
+
+	_, err = NewMotanServerContextFromConfig(mscontext.config).ReloadConfig()
+	assert.NotNil(err)
+}
+
+func TestWatchConfig(t *testing.T) {
+	assert := assert2.New(t)
+	var lock sync.Mutex
+	text := "motan-server:\n  application: app1\n"
+	load := func() (*config.Config, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		return config.NewConfigFromReader(bytes.NewReader([]byte(text)))
+	}
+	reloads := make(chan struct{}, 8)
+	stop := make(chan struct{})
+	defer close(stop)
+	go watchConfig("test", 10*time.Millisecond, load, func() error {
+		reloads <- struct{}{}
+		return nil
+	}, stop)
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(reloads, 0)
+	lock.Lock()
+	text = "motan-server:\n  application: app2\n"
+	lock.Unlock()
+	select {
+	case <-reloads:
+	case <-time.After(time.Second):
+		t.Fatal("config change is not reloaded")
+	}
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(reloads, 0)
+}
+
+func TestMSContext_AdminServer(t *testing.T) {
+	assert := assert2.New(t)
+	cfgText := `
+motan-server:
+  log_dir: "stdout"
+  application: "app-golang"
+  adminPort: 64603
+
+motan-registry:
+  direct:
+    protocol: direct
+
+motan-service:
+  admin-service:
+    path: adminService
+    group: bj
+    protocol: motan2
+    registry: direct
+    serialization: simple
+    ref : "serviceID"
+    export: "motan2:64602"
+`
+	conf, err := config.NewConfigFromReader(bytes.NewReader([]byte(cfgText)))
+	assert.Nil(err)
+	mscontext := NewMotanServerContextFromConfig(conf)
+	assert.Nil(mscontext.RegisterService(&HelloService{}, "serviceID"))
+	mscontext.Start(GetDefaultExtFactory())
+	defer mscontext.adminListener.Close()
+
+	type result struct {
+		Code    int            `json:"code"`
+		Message string         `json:"message"`
+		Body    []ExporterInfo `json:"body"`
+	}
+	list := func() []ExporterInfo {
+		resp, err := http.Get("http://127.0.0.1:64603/exporter/list")
+		assert.Nil(err)
+		defer resp.Body.Close()
+		var r result
+		assert.Nil(json.NewDecoder(resp.Body).Decode(&r))
+		return r.Body
+	}
+	post := func(path string, service string) int {
+		resp, err := http.PostForm("http://127.0.0.1:64603"+path, url.Values{"service": {service}})
+		assert.Nil(err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	infos := list()
+	assert.Len(infos, 1)
+	assert.Equal("adminService", infos[0].Path)
+	assert.Equal(64602, infos[0].Port)
+
+	assert.Equal(http.StatusOK, post("/exporter/unavailable", "adminService"))
+	assert.False(list()[0].Available)
+	assert.Equal(http.StatusOK, post("/exporter/available", "adminService"))
+	assert.True(list()[0].Available)
+	assert.Equal(http.StatusNotFound, post("/exporter/available", "unknownService"))
+	resp, err := http.Get("http://127.0.0.1:64603/exporter/available?service=adminService")
+	assert.Nil(err)
+	resp.Body.Close()
+	assert.Equal(http.StatusMethodNotAllowed, resp.StatusCode)
+}
@@ -0,0 +1,62 @@
+package motan
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	motan "github.com/weibocom/motan-go/core"
+	"github.com/weibocom/motan-go/log"
+	mserver "github.com/weibocom/motan-go/server"
+)
+
+// ServerHotUpgradeKey is the parameter of the motan-server section to enable the hot upgrade on SIGUSR2, default false
+const ServerHotUpgradeKey = "hotUpgrade"
+
+const defaultHandoverTimeout = 5 * time.Second
+
+var upgradeSignalOnce sync.Once
+
+// watchUpgradeSignal upgrades the process by the executable on SIGUSR2, see motan.Upgrade. the handover drains the
+// connections after the new process is ready, then the process exits. the process keeps serving if the upgrade fails
+func watchUpgradeSignal(timeout time.Duration, handoverTimeout time.Duration, handover func(ctx context.Context)) {
+	upgradeSignalOnce.Do(func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGUSR2)
+		go func() {
+			for range signals {
+				vlog.Infof("receive the upgrade signal, start upgrading. pid:%d", os.Getpid())
+				if err := motan.Upgrade(timeout); err != nil {
+					vlog.Errorf("hot upgrade fail, the process keeps serving. err:%v", err)
+					continue
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), handoverTimeout)
+				handover(ctx)
+				cancel()
+				vlog.Infof("hot upgrade finish, the process exits. pid:%d", os.Getpid())
+				vlog.Flush()
+				os.Exit(0)
+			}
+		}()
+	})
+}
+
+// watchUpgradeSignal hands over the agent server, the http proxy and the exporters of the agent on SIGUSR2
+func (a *Agent) watchUpgradeSignal() {
+	if a.agentURL.GetParam(motan.HotUpgradeKey, "true") == "false" {
+		return
+	}
+	timeout := a.agentURL.GetTimeDuration(motan.HotUpgradeTimeoutKey, time.Millisecond, motan.DefaultHotUpgradeTimeout)
+	handoverTimeout := a.agentURL.GetTimeDuration(mserver.GracefulShutdownTimeoutKey, time.Millisecond, defaultHandoverTimeout)
+	watchUpgradeSignal(timeout, handoverTimeout, func(ctx context.Context) {
+		if a.httpProxyServer != nil {
+			if err := a.httpProxyServer.Shutdown(ctx); err != nil {
+				vlog.Warningf("shutdown http proxy server fail. err:%v", err)
+			}
+		}
+		mserver.HandoverShutdown(ctx)
+	})
+}
@@ -1,5 +1,11 @@
 package motan
 
+import "github.com/weibocom/motan-go/server"
+
 const (
 	Version = "1.0.0"
 )
+
+func init() {
+	server.FrameworkVersion = Version
+}